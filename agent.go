@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	ic "github.com/libp2p/go-libp2p-crypto"
+	"path/filepath"
 )
 
 // Unique user identifier in context of this holochain
@@ -85,7 +86,7 @@ func SaveAgent(path string, agent Agent) (err error) {
 	if err != nil {
 		return
 	}
-	if fileExists(path + "/" + PrivKeyFileName) {
+	if fileExists(filepath.Join(path, PrivKeyFileName)) {
 		return errors.New("keys already exist")
 	}
 	var k []byte
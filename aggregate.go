@@ -0,0 +1,165 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// aggregate implements counting and summing where the data is held, so apps that only need
+// a number, e.g. of votes or followers, don't have to fetch every entry to work it out.
+// Links are counted by the node holding their base, which is sent a getmeta query asking for
+// a count instead of the entries.  Queries are aggregated over the live entries of their type
+// held in the local DHT store, so on nodes that only hold some topics they only cover those.
+// Aggregates can't be used during validation, as what's held differs from node to node.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrAggregateInValidation error = errors.New("aggregates can't be used during validation")
+
+// Aggregate is the result of aggregating the entries selected by a query
+type Aggregate struct {
+	Count int
+	Sum   float64
+}
+
+// countMeta counts the values of a type associated with a hash, without decoding them
+func (dht *DHT) countMeta(key Hash, metaTag string) (count int, err error) {
+	k := key.String()
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("entry:" + k); err == buntdb.ErrNotFound {
+			return ErrHashNotFound
+		}
+		prefix := "meta:" + k + ":"
+		tx.AscendKeys(prefix+"*", func(key, value string) bool {
+			x := strings.SplitN(strings.TrimPrefix(key, prefix), ":", 2)
+			if len(x) == 2 && x[1] == metaTag {
+				count++
+			}
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// CountLinks returns how many links of a type there are from an entry
+func (h *Holochain) CountLinks(base Hash, metaTag string) (count int, err error) {
+	var n *Node
+	if n, err = h.dht.FindNodeForHash(base); err != nil {
+		return
+	}
+	// nodes that predate counting would send the links themselves
+	if h.peerLacks(n.HashAddr, FeatureCount) {
+		err = fmt.Errorf("node %v holding %v can't count links", n.HashAddr, base)
+		return
+	}
+	var response interface{}
+	if response, err = h.dht.send(n.HashAddr, GETMETA_REQUEST, MetaQuery{H: base, T: metaTag, Count: true}); err != nil {
+		return
+	}
+	r, ok := response.(MetaQueryResp)
+	if !ok {
+		err = fmt.Errorf("unexpected response type from getmeta: %T", response)
+		return
+	}
+	count = r.Count
+	return
+}
+
+// aggregate counts the live entries held in the DHT store that are selected by a query, and
+// sums a top level number field of them if one is given.  Entries without the field are
+// counted but add nothing to the sum.
+func (dht *DHT) aggregate(q *Query, field string) (a Aggregate, err error) {
+	if err = q.Check(dht.h); err != nil {
+		return
+	}
+	if field != "" {
+		var def *EntryDef
+		if _, def, err = dht.h.GetEntryDef(q.Entry); err != nil {
+			return
+		}
+		if def.DataFormat != DataFormatJSON {
+			err = fmt.Errorf("can't sum a field of a %s entry", def.DataFormat)
+			return
+		}
+	}
+	_, entryType := SplitEntryType(q.Entry)
+	now := time.Now()
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		// the type index orders the entries by type, ignoring case, so only those of the type
+		// and of types differing from it only in case are visited
+		tx.AscendGreaterOrEqual("type", entryType, func(key, value string) bool {
+			if !strings.EqualFold(value, entryType) {
+				return false
+			}
+			if value != entryType {
+				return true
+			}
+			k := strings.TrimPrefix(key, "type:")
+			var status string
+			if status, e = tx.Get("status:" + k); e != nil {
+				return false
+			}
+			if status != strconv.Itoa(LIVE) || isExpired(tx, k, now) {
+				return true
+			}
+			if len(q.Where) == 0 && field == "" {
+				a.Count++
+				return true
+			}
+			var data string
+			if data, e = tx.Get("entry:" + k); e != nil {
+				return false
+			}
+			var entry GobEntry
+			if e = entry.Unmarshal([]byte(data)); e != nil {
+				return false
+			}
+			s, _ := entry.C.(string)
+			var fields map[string]interface{}
+			if json.Unmarshal([]byte(s), &fields) != nil || !q.matches(fields) {
+				return true
+			}
+			a.Count++
+			if v, ok := fields[field]; ok && field != "" {
+				n, ok := v.(float64)
+				if !ok {
+					e = fmt.Errorf("field %s of %s is not a number", field, k)
+					return false
+				}
+				a.Sum += n
+			}
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// QueryCount returns how many of the entries held in the DHT store are selected by a query
+func (h *Holochain) QueryCount(q Query) (count int, err error) {
+	var a Aggregate
+	if a, err = h.dht.aggregate(&q, ""); err == nil {
+		count = a.Count
+	}
+	return
+}
+
+// QuerySum returns the sum of a number field of the entries held in the DHT store that are
+// selected by a query, along with how many there are
+func (h *Holochain) QuerySum(q Query, field string) (a Aggregate, err error) {
+	if field == "" {
+		err = errors.New("querySum must name a field")
+		return
+	}
+	a, err = h.dht.aggregate(&q, field)
+	return
+}
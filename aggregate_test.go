@@ -0,0 +1,80 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestAggregates(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	dht := h.dht
+	put := func(entryType string, content string, status int) Hash {
+		var hash Hash
+		hash.Sum(h.hashSpec, []byte(content))
+		e := GobEntry{C: content}
+		b, _ := e.Marshal()
+		if err := dht.put(nil, entryType, hash, h.id, b, status); err != nil {
+			panic(err)
+		}
+		return hash
+	}
+	base := put("profile", `{"firstName":"Zippy","age":30}`, LIVE)
+	put("profile", `{"firstName":"Zerbina","age":12}`, LIVE)
+	put("profile", `{"firstName":"Zippy","lastName":"Pinhead"}`, LIVE)
+	put("profile", `{"firstName":"Zippy","age":100}`, REJECTED)
+	put("myData", "2", LIVE)
+	put("Profile", `{"firstName":"Zippy","age":7}`, LIVE) // only differs from profile in case
+
+	for i, tag := range []string{"follows", "follows", "likes"} {
+		var meta Hash
+		meta.Sum(h.hashSpec, []byte(fmt.Sprintf("meta%d", i)))
+		if err := dht.putMeta(nil, base, meta, tag, &GobEntry{C: "x"}); err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should count the links from an entry", t, func() {
+		n, err := h.CountLinks(base, "follows")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		n, err = h.CountLinks(base, "nothing")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+		var missing Hash
+		missing.Sum(h.hashSpec, []byte("missing"))
+		_, err = h.CountLinks(missing, "follows")
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should count the live entries a query selects", t, func() {
+		n, err := h.QueryCount(Query{Entry: "profile"})
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 3)
+		n, err = h.QueryCount(Query{Entry: "profile", Where: map[string]interface{}{"firstName": "Zippy"}})
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		n, err = h.QueryCount(Query{Entry: "myData"})
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		_, err = h.QueryCount(Query{Entry: "nosuchtype"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should sum a field of the entries a query selects", t, func() {
+		a, err := h.QuerySum(Query{Entry: "profile"}, "age")
+		So(err, ShouldBeNil)
+		So(a, ShouldResemble, Aggregate{Count: 3, Sum: 42})
+		a, err = h.QuerySum(Query{Entry: "profile", Where: map[string]interface{}{"firstName": "Zippy"}}, "age")
+		So(err, ShouldBeNil)
+		So(a.Sum, ShouldEqual, 30)
+		_, err = h.QuerySum(Query{Entry: "profile"}, "firstName")
+		So(err, ShouldNotBeNil)
+		_, err = h.QuerySum(Query{Entry: "myData"}, "age")
+		So(err, ShouldNotBeNil)
+		_, err = h.QuerySum(Query{Entry: "profile"}, "")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,572 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// apptemplates implements the starter apps that a new chain can be generated from for
+// development, beyond the single sample that GenDev writes.  Each template is a small but
+// working app, with its zomes, entry schemas, tests and a basic UI.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AppTemplate is a starter app from which a chain can be generated for development
+type AppTemplate struct {
+	Name        string
+	Description string
+	Zomes       []Zome
+	Files       map[string]string     // the schemas, zome code and ui files by their path in the app
+	Tests       map[string][]TestData // the test files by name
+}
+
+var ErrUnknownAppTemplate error = errors.New("unknown app template")
+
+var appTemplates = make(map[string]*AppTemplate)
+
+// RegisterAppTemplate makes an app template available for generating chains from
+func RegisterAppTemplate(t *AppTemplate) {
+	appTemplates[t.Name] = t
+}
+
+// AppTemplateNames returns the names of the registered app templates in order
+func AppTemplateNames() (names []string) {
+	for name := range appTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// GetAppTemplate returns the registered app template with the given name
+func GetAppTemplate(name string) (t *AppTemplate, err error) {
+	t, ok := appTemplates[name]
+	if !ok {
+		err = fmt.Errorf("%v: %s, must be one of %s", ErrUnknownAppTemplate, name, strings.Join(AppTemplateNames(), ", "))
+	}
+	return
+}
+
+// GenDevTemplate generates a chain for development from a registered app template
+func (s *Service) GenDevTemplate(path string, format string, template string) (hP *Holochain, err error) {
+	var t *AppTemplate
+	if t, err = GetAppTemplate(template); err != nil {
+		return
+	}
+	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
+		agent, err := LoadAgent(s.Dirs().Config)
+		if err != nil {
+			return
+		}
+
+		// the zomes get their own entry maps so generating doesn't change the template
+		zomes := make([]Zome, len(t.Zomes))
+		for i, z := range t.Zomes {
+			z.Entries = make(map[string]EntryDef)
+			for name, d := range t.Zomes[i].Entries {
+				z.Entries[name] = d
+			}
+			z.Code = fmt.Sprintf("zome_%s.js", z.Name)
+			zomes[i] = z
+		}
+		h := NewHolochain(agent, path, format, zomes...)
+		h.Name = filepath.Base(path)
+
+		if err = makeConfig(&h, s, true); err != nil {
+			return
+		}
+
+		h.PropertiesSchema = "schema_properties.json"
+		h.Properties = map[string]string{
+			"description": t.Description,
+			"language":    "en"}
+		if err = writeFile(path, h.PropertiesSchema, []byte(templatePropertiesSchema)); err != nil {
+			return
+		}
+
+		for name, text := range t.Files {
+			dir := filepath.Join(path, filepath.Dir(name))
+			if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+				return
+			}
+			if err = writeFile(dir, filepath.Base(name), []byte(text)); err != nil {
+				return
+			}
+		}
+		if err = writeFile(filepath.Join(path, "ui"), "hc.js", []byte(SampleJS)); err != nil {
+			return
+		}
+
+		testPath := filepath.Join(path, "test")
+		if err = os.MkdirAll(testPath, os.ModePerm); err != nil {
+			return
+		}
+		for name, tests := range t.Tests {
+			var j []byte
+			if j, err = json.Marshal(tests); err != nil {
+				return
+			}
+			if err = writeFile(testPath, name+".json", j); err != nil {
+				return
+			}
+		}
+		hP = &h
+		return
+	})
+	return
+}
+
+// templateUI returns a basic page from which the functions of a template's zome can be called
+func templateUI(title string, zome string, help string, functions ...string) string {
+	var options []string
+	for _, f := range functions {
+		options = append(options, fmt.Sprintf(`      <option value="%s">%s</option>`, f, f))
+	}
+	return fmt.Sprintf(`<html>
+  <head>
+    <title>%s</title>
+    <script type="text/javascript" src="http://code.jquery.com/jquery-latest.js"></script>
+    <script type="text/javascript" src="/hc.js"></script>
+  </head>
+  <body>
+    <h1>%s</h1>
+    <select id="zome" name="zome">
+      <option value="%s">%s</option>
+    </select>
+    <select id="fn" name="fn">
+%s
+    </select>
+    <input id="data" name="data" size="60">
+    <button onclick="send();">Send</button>
+    <p>%s</p>
+
+    <div id="result"></div>
+    <div id="err"></div>
+  </body>
+</html>`, title, title, zome, zome, strings.Join(options, "\n"), help)
+}
+
+const templatePropertiesSchema = `{
+	"title": "Properties Schema",
+	"type": "object",
+	"properties": {
+		"description": {
+			"type": "string"
+		},
+		"language": {
+			"type": "string"
+		}
+	}
+}`
+
+// hashRegexp matches the quoted hash returned by a zome function that returns json
+const hashRegexp = `^"Qm[1-9A-Za-z]+"$`
+
+func init() {
+	RegisterAppTemplate(&AppTemplate{
+		Name:        "chat",
+		Description: "a chat app with rooms of messages",
+		Zomes: []Zome{
+			{Name: "chat",
+				Description: "rooms and the messages posted in them",
+				NucleusType: JSNucleusType,
+				Entries: map[string]EntryDef{
+					"room":    {Name: "room", DataFormat: DataFormatString},
+					"message": {Name: "message", DataFormat: DataFormatJSON, Schema: "schema_message.json"},
+				},
+				Functions: []FunctionDef{
+					{Name: "newRoom", Description: "creates a room", Arg: StringType, Returns: HashType},
+					{Name: "post", Description: "posts a message of text to a room", Arg: JSONType, Returns: JSONType},
+					{Name: "messages", Description: "lists the messages posted to a room", Arg: HashType, Returns: JSONType, ReadOnly: true},
+				},
+			},
+		},
+		Files: map[string]string{
+			"schema_message.json": `{
+	"title": "Message Schema",
+	"type": "object",
+	"properties": {
+		"room": {
+			"description": "hash of the room the message is posted to",
+			"type": "string"
+		},
+		"text": {
+			"type": "string"
+		}
+	},
+	"required": ["room", "text"]
+}`,
+			"zome_chat.js": `
+expose("newRoom", HC.STRING);
+function newRoom(name) {
+  var key = commit("room", name);
+  if (key instanceof Error) {return key;}
+  put(key);
+  return key;
+}
+
+expose("post", HC.JSON);
+function post(message) {
+  var key = commit("message", message);
+  if (key instanceof Error) {return key;}
+  put(key);
+  putmeta(message.room, key, "message");
+  return key;
+}
+
+expose("messages", HC.STRING);
+function messages(room) {
+  var result = getmeta(room, "message");
+  var list = [];
+  if (!(result instanceof Error)) {
+    for (var i = 0; i < result.length; i++) {
+      list.push(JSON.parse(result[i].C));
+    }
+  }
+  return JSON.stringify(list);
+}
+
+function validate(entry_type, entry, props) {
+  if (props.MetaTag) {
+    return props.MetaTag == "message";
+  }
+  if (entry_type == "room") {
+    return /^[a-z0-9-]{1,64}$/.test(entry);
+  }
+  if (entry_type == "message") {
+    return entry.text.length > 0 && entry.text.length <= 1024;
+  }
+  return false;
+}
+
+function genesis() {return true;}
+`,
+			"ui/index.html": templateUI("Chat", "chat",
+				"create a room named with lower case letters, numbers and dashes, then post {\"room\":\"&lt;room hash&gt;\",\"text\":\"...\"} to it",
+				"newRoom", "post", "messages"),
+		},
+		Tests: map[string][]TestData{
+			"chat": {
+				{Zome: "chat", FnName: "newRoom", Input: "lobby", Output: "%h%", Signals: []string{"commit"}},
+				{Zome: "chat", FnName: "post", Input: `{"room":"%r1%","text":"hello"}`, Output: `"%h%"`},
+				{Zome: "chat", FnName: "newRoom", Input: "Not A Room!", Err: "Invalid entry: Not A Room!"},
+			},
+		},
+	})
+
+	RegisterAppTemplate(&AppTemplate{
+		Name:        "wiki",
+		Description: "a wiki of pages and their revisions",
+		Zomes: []Zome{
+			{Name: "wiki",
+				Description: "pages, each with a history of revisions",
+				NucleusType: JSNucleusType,
+				Entries: map[string]EntryDef{
+					"title":    {Name: "title", DataFormat: DataFormatString},
+					"revision": {Name: "revision", DataFormat: DataFormatJSON, Schema: "schema_revision.json"},
+				},
+				Functions: []FunctionDef{
+					{Name: "newPage", Description: "creates a page with a title", Arg: StringType, Returns: HashType},
+					{Name: "edit", Description: "saves a revision of a page", Arg: JSONType, Returns: JSONType},
+					{Name: "history", Description: "lists the revisions of a page", Arg: HashType, Returns: JSONType, ReadOnly: true},
+				},
+			},
+		},
+		Files: map[string]string{
+			"schema_revision.json": `{
+	"title": "Revision Schema",
+	"type": "object",
+	"properties": {
+		"page": {
+			"description": "hash of the title of the page revised",
+			"type": "string"
+		},
+		"body": {
+			"description": "the page's text in markdown",
+			"type": "string"
+		}
+	},
+	"required": ["page", "body"]
+}`,
+			"zome_wiki.js": `
+expose("newPage", HC.STRING);
+function newPage(title) {
+  var key = commit("title", title);
+  if (key instanceof Error) {return key;}
+  put(key);
+  return key;
+}
+
+expose("edit", HC.JSON);
+function edit(revision) {
+  var key = commit("revision", revision);
+  if (key instanceof Error) {return key;}
+  put(key);
+  putmeta(revision.page, key, "revision");
+  return key;
+}
+
+expose("history", HC.STRING);
+function history(page) {
+  var result = getmeta(page, "revision");
+  var list = [];
+  if (!(result instanceof Error)) {
+    for (var i = 0; i < result.length; i++) {
+      list.push(JSON.parse(result[i].C));
+    }
+  }
+  return JSON.stringify(list);
+}
+
+function validate(entry_type, entry, props) {
+  if (props.MetaTag) {
+    return props.MetaTag == "revision";
+  }
+  if (entry_type == "title") {
+    return entry.length > 0 && entry.length <= 100 && entry.indexOf("/") < 0;
+  }
+  if (entry_type == "revision") {
+    return entry.body.length <= 65536;
+  }
+  return false;
+}
+
+function genesis() {return true;}
+`,
+			"ui/index.html": templateUI("Wiki", "wiki",
+				"create a page with a title, then edit it with {\"page\":\"&lt;title hash&gt;\",\"body\":\"...\"}",
+				"newPage", "edit", "history"),
+		},
+		Tests: map[string][]TestData{
+			"wiki": {
+				{Zome: "wiki", FnName: "newPage", Input: "Home", Output: "%h%"},
+				{Zome: "wiki", FnName: "edit", Input: `{"page":"%r1%","body":"# Welcome"}`, Output: `"%h%"`},
+				{Zome: "wiki", FnName: "edit", Input: `{"page":"%r2%","body":"# Welcome back"}`, Output: `"%h%"`},
+				{Zome: "wiki", FnName: "newPage", Input: "bad/title", Err: "Invalid entry: bad/title"},
+			},
+		},
+	})
+
+	RegisterAppTemplate(&AppTemplate{
+		Name:        "directory",
+		Description: "a directory of links to web sites, by category",
+		Zomes: []Zome{
+			{Name: "directory",
+				Description: "categories and the listings in them",
+				NucleusType: JSNucleusType,
+				Entries: map[string]EntryDef{
+					"category": {Name: "category", DataFormat: DataFormatString},
+					"listing":  {Name: "listing", DataFormat: DataFormatJSON, Schema: "schema_listing.json"},
+				},
+				Functions: []FunctionDef{
+					{Name: "addCategory", Description: "creates a category", Arg: StringType, Returns: HashType},
+					{Name: "addListing", Description: "lists a web site in a category", Arg: JSONType, Returns: JSONType},
+					{Name: "listings", Description: "lists the web sites in a category", Arg: HashType, Returns: JSONType, ReadOnly: true},
+				},
+			},
+		},
+		Files: map[string]string{
+			"schema_listing.json": `{
+	"title": "Listing Schema",
+	"type": "object",
+	"properties": {
+		"category": {
+			"description": "hash of the category the site is listed in",
+			"type": "string"
+		},
+		"name": {
+			"type": "string"
+		},
+		"url": {
+			"type": "string"
+		},
+		"description": {
+			"type": "string"
+		}
+	},
+	"required": ["category", "name", "url"]
+}`,
+			"zome_directory.js": `
+expose("addCategory", HC.STRING);
+function addCategory(name) {
+  var key = commit("category", name);
+  if (key instanceof Error) {return key;}
+  put(key);
+  return key;
+}
+
+expose("addListing", HC.JSON);
+function addListing(listing) {
+  var key = commit("listing", listing);
+  if (key instanceof Error) {return key;}
+  put(key);
+  putmeta(listing.category, key, "listing");
+  return key;
+}
+
+expose("listings", HC.STRING);
+function listings(category) {
+  var result = getmeta(category, "listing");
+  var list = [];
+  if (!(result instanceof Error)) {
+    for (var i = 0; i < result.length; i++) {
+      list.push(JSON.parse(result[i].C));
+    }
+  }
+  return JSON.stringify(list);
+}
+
+function validate(entry_type, entry, props) {
+  if (props.MetaTag) {
+    return props.MetaTag == "listing";
+  }
+  if (entry_type == "category") {
+    return /^[a-z][a-z0-9 -]{0,63}$/.test(entry);
+  }
+  if (entry_type == "listing") {
+    return entry.name.length > 0 && /^https?:\/\/[^ ]+$/.test(entry.url);
+  }
+  return false;
+}
+
+function genesis() {return true;}
+`,
+			"ui/index.html": templateUI("Directory", "directory",
+				"add a category, then list sites in it with {\"category\":\"&lt;category hash&gt;\",\"name\":\"...\",\"url\":\"http://...\"}",
+				"addCategory", "addListing", "listings"),
+		},
+		Tests: map[string][]TestData{
+			"directory": {
+				{Zome: "directory", FnName: "addCategory", Input: "free software", Output: "%h%"},
+				{Zome: "directory", FnName: "addListing", Input: `{"category":"%r1%","name":"holochain","url":"https://github.com/metacurrency/holochain"}`, Output: `"%h%"`},
+				{Zome: "directory", FnName: "addCategory", Input: "Bad_Category!", Err: "Invalid entry: Bad_Category!"},
+			},
+		},
+	})
+
+	RegisterAppTemplate(&AppTemplate{
+		Name:        "ledger",
+		Description: "a mutual credit currency ledger",
+		Zomes: []Zome{
+			{Name: "ledger",
+				Description: "accounts and the transactions between them, with balances kept as counters",
+				NucleusType: JSNucleusType,
+				Entries: map[string]EntryDef{
+					"account":     {Name: "account", DataFormat: DataFormatJSON},
+					"transaction": {Name: "transaction", DataFormat: DataFormatJSON, Schema: "schema_transaction.json", Dependencies: []string{"from"}},
+					"balance":     {Name: "balance", DataFormat: DataFormatJSON, CRDT: CRDTCounter, Dependencies: []string{"Cause"}},
+				},
+				Functions: []FunctionDef{
+					{Name: "openAccount", Description: "opens an account with a zero balance", Arg: StringType, Returns: HashType},
+					{Name: "pay", Description: "pays an amount from one account to another", Arg: JSONType, Returns: JSONType},
+					{Name: "balance", Description: "returns the balance of an account", Arg: HashType, Returns: JSONType, ReadOnly: true},
+				},
+			},
+		},
+		Files: map[string]string{
+			"schema_transaction.json": `{
+	"title": "Transaction Schema",
+	"type": "object",
+	"properties": {
+		"from": {
+			"description": "hash of the account paying",
+			"type": "string"
+		},
+		"to": {
+			"description": "hash of the account paid",
+			"type": "string"
+		},
+		"amount": {
+			"type": "integer"
+		},
+		"memo": {
+			"type": "string"
+		},
+		"payer": {
+			"description": "key of the agent paying, who must own the account paying",
+			"type": "string"
+		}
+	},
+	"required": ["from", "to", "amount", "payer"]
+}`,
+			"zome_ledger.js": `
+// how far below zero an account's balance may go, as in mutual credit everyone starts at zero
+var creditLimit = 100;
+
+expose("openAccount", HC.STRING);
+function openAccount(name) {
+  var key = commit("account", {name: name, owner: App.Key.Hash});
+  if (key instanceof Error) {return key;}
+  put(key);
+  return key;
+}
+
+expose("pay", HC.JSON);
+function pay(transaction) {
+  transaction.payer = App.Key.Hash;
+  var key = commit("transaction", transaction);
+  if (key instanceof Error) {return key;}
+  put(key);
+  var debit = crdtIncrement("balance", transaction.from, -transaction.amount, key);
+  if (debit instanceof Error) {return debit;}
+  var credit = crdtIncrement("balance", transaction.to, transaction.amount, key);
+  if (credit instanceof Error) {return credit;}
+  return key;
+}
+
+expose("balance", HC.STRING);
+function balance(account) {
+  return JSON.stringify(balanceOf(account));
+}
+
+function balanceOf(account) {
+  var count = crdtGet("balance", account);
+  if (count instanceof Error) {return 0;}
+  return count;
+}
+
+function validate(entry_type, entry, props) {
+  if (entry_type == "account") {
+    return /^[a-z][a-z0-9_-]{0,31}$/.test(entry.name) && entry.owner == props.Sources[0];
+  }
+  if (entry_type == "transaction") {
+    // only the owner of the paying account can pay from it, and only within its credit
+    var from = props.Deps[entry.from];
+    return entry.amount > 0 && entry.from != entry.to &&
+      entry.payer == props.Sources[0] && from.owner == entry.payer &&
+      balanceOf(entry.from) - entry.amount >= -creditLimit;
+  }
+  if (entry_type == "balance") {
+    // balances only change by the amounts of transactions, as made by their payers
+    var t = props.Deps && props.Deps[entry.Cause];
+    if (!t || t.payer != props.Sources[0]) {return false;}
+    return (entry.Object == t.from && entry.Value == -t.amount) ||
+      (entry.Object == t.to && entry.Value == t.amount);
+  }
+  return false;
+}
+
+function genesis() {return true;}
+`,
+			"ui/index.html": templateUI("Ledger", "ledger",
+				"open accounts, then pay from your own {\"from\":\"&lt;account hash&gt;\",\"to\":\"&lt;account hash&gt;\",\"amount\":10,\"memo\":\"...\"}",
+				"openAccount", "pay", "balance"),
+		},
+		Tests: map[string][]TestData{
+			"ledger": {
+				{Zome: "ledger", FnName: "openAccount", Input: "alice", Output: "%h%"},
+				{Zome: "ledger", FnName: "openAccount", Input: "bob", Output: "%h%"},
+				{Zome: "ledger", FnName: "pay", Input: `{"from":"%r2%","to":"%r1%","amount":10,"memo":"lunch"}`, Regexp: hashRegexp},
+				{Zome: "ledger", FnName: "pay", Input: `{"from":"%r3%","to":"%r2%","amount":1000,"memo":"a yacht"}`, Err: `Invalid entry: {"from":"%r3%","to":"%r2%","amount":1000,"memo":"a yacht","payer":"%key%"}`},
+				{Zome: "ledger", FnName: "openAccount", Input: "Not An Account", Err: `Invalid entry: {"name":"Not An Account","owner":"%key%"}`},
+			},
+		},
+	})
+}
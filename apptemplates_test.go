@@ -0,0 +1,50 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppTemplates(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+
+	Convey("it should list the registered app templates", t, func() {
+		So(AppTemplateNames(), ShouldResemble, []string{"chat", "directory", "ledger", "wiki"})
+	})
+
+	Convey("it should refuse to generate from an unknown template", t, func() {
+		_, err := s.GenDevTemplate(filepath.Join(s.Path, "bogus"), "toml", "bogus")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "unknown app template: bogus, must be one of chat, directory, ledger, wiki")
+		So(dirExists(filepath.Join(s.Path, "bogus")), ShouldBeFalse)
+	})
+
+	for _, name := range AppTemplateNames() {
+		Convey("the "+name+" template should generate an app that passes its own tests", t, func() {
+			h, err := s.GenDevTemplate(filepath.Join(s.Path, name), "json", name)
+			So(err, ShouldBeNil)
+			So(fileExists(filepath.Join(h.path, "zome_"+name+".js")), ShouldBeTrue)
+			So(fileExists(filepath.Join(h.path, "ui", "index.html")), ShouldBeTrue)
+			So(fileExists(filepath.Join(h.path, "ui", "hc.js")), ShouldBeTrue)
+			So(fileExists(filepath.Join(h.path, "test", name+".json")), ShouldBeTrue)
+
+			loaded, err := s.Load(name)
+			So(err, ShouldBeNil)
+			So(loaded.Properties["description"], ShouldEqual, appTemplates[name].Description)
+
+			if os.Getenv("DEBUG") != "1" {
+				h.config.Loggers.TestPassed.Enabled = false
+				h.config.Loggers.TestFailed.Enabled = false
+				h.config.Loggers.TestInfo.Enabled = false
+			}
+			So(h.Test(), ShouldBeNil)
+		})
+	}
+
+	Convey("generating shouldn't change the template", t, func() {
+		So(appTemplates["chat"].Zomes[0].Code, ShouldEqual, "")
+	})
+}
@@ -0,0 +1,276 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// attestation implements signed claims by which an agent links its key to identities outside
+// of the chain: an email address, a domain, or the key it uses on another chain.  Attestations
+// are committed to the agent's chain and linked from its key in the DHT so that others can
+// find and check them, building webs of trust across apps.
+
+package holochain
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"strings"
+	"time"
+)
+
+const (
+	AttestationEntryType = "%attestation"
+	AttestationMetaTag   = "attestation"
+
+	// kinds of external identity
+	AttestEmail  = "email"  // identity is the salted hash of the email address, so it isn't published
+	AttestDomain = "domain" // identity is a domain, which should list the agent at DomainProofURL
+	AttestKey    = "key"    // identity is the base58 encoded public key of an agent on another chain
+)
+
+var ErrBadAttestation error = errors.New("attestation signature doesn't verify")
+
+// DomainProofURL returns where a domain lists the ids of the agents it attests to, one per
+// line.  It is a variable so it can be pointed elsewhere for testing.
+var DomainProofURL = func(domain string) string {
+	return "https://" + domain + "/.well-known/holochain-agents"
+}
+
+// Attestation is an agent's signed claim to an external identity
+type Attestation struct {
+	Agent     string // node id of the attesting agent
+	PubKey    string // base58 encoded public key of the attesting agent
+	Kind      string
+	Identity  string
+	Proof     string // for key attestations, the other key's base58 signature of Agent
+	Time      time.Time
+	Signature string // base58 signature by PubKey of all of the above
+
+	// Salt is the secret salt an email address was hashed with.  It isn't part of the
+	// published attestation: the agent gives it, with the address, to whoever is to check it.
+	Salt string `json:"-"`
+}
+
+// signed returns the bytes of the attestation that its signature covers
+func (a *Attestation) signed() []byte {
+	return []byte(strings.Join([]string{a.Agent, a.PubKey, a.Kind, a.Identity, a.Proof, a.Time.UTC().Format(time.RFC3339Nano)}, "\n"))
+}
+
+// Verify checks the attestation's signature, that the key it is signed with belongs to the
+// attesting agent, and for key attestations that the other key has signed the agent's id
+func (a *Attestation) Verify() (err error) {
+	var key ic.PubKey
+	key, err = ic.UnmarshalPublicKey(b58.Decode(a.PubKey))
+	if err != nil {
+		err = fmt.Errorf("bad attestation key: %v", err)
+		return
+	}
+	var id peer.ID
+	id, err = peer.IDFromPublicKey(key)
+	if err != nil {
+		return
+	}
+	if peer.IDB58Encode(id) != a.Agent {
+		err = errors.New("attestation key doesn't belong to the attesting agent")
+		return
+	}
+	if ok, e := key.Verify(a.signed(), b58.Decode(a.Signature)); e != nil || !ok {
+		err = ErrBadAttestation
+		return
+	}
+	switch a.Kind {
+	case AttestEmail, AttestDomain:
+		if a.Identity == "" {
+			err = fmt.Errorf("%s attestation has no identity", a.Kind)
+		}
+	case AttestKey:
+		var other ic.PubKey
+		other, err = ic.UnmarshalPublicKey(b58.Decode(a.Identity))
+		if err != nil {
+			err = fmt.Errorf("bad attested key: %v", err)
+			return
+		}
+		if ok, e := other.Verify([]byte(a.Agent), b58.Decode(a.Proof)); e != nil || !ok {
+			err = errors.New("attested key hasn't signed the agent's id")
+		}
+	default:
+		err = fmt.Errorf("unknown attestation kind: %s", a.Kind)
+	}
+	return
+}
+
+// AttestsEmail returns true if the attestation is to the given email address, hashed with the
+// given salt
+func (a *Attestation) AttestsEmail(h *Holochain, email string, salt string) bool {
+	if a.Kind != AttestEmail {
+		return false
+	}
+	hash, err := emailHash(h, email, salt)
+	return err == nil && hash == a.Identity
+}
+
+// VerifyDomain checks that the domain of a domain attestation lists the attesting agent
+func (a *Attestation) VerifyDomain() (err error) {
+	if a.Kind != AttestDomain {
+		err = fmt.Errorf("not a domain attestation: %s", a.Kind)
+		return
+	}
+	var data []byte
+	data, err = fetchURL(DomainProofURL(a.Identity))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == a.Agent {
+			return
+		}
+	}
+	err = fmt.Errorf("domain %s doesn't list agent %s", a.Identity, a.Agent)
+	return
+}
+
+// emailHash returns the hash by which an email address is attested with a salt, which keeps
+// the address from being found by hashing likely ones
+func emailHash(h *Holochain, email string, salt string) (s string, err error) {
+	var hash Hash
+	if err = hash.Sum(h.hashSpec, []byte(salt+"\n"+strings.ToLower(strings.TrimSpace(email)))); err != nil {
+		return
+	}
+	s = hash.String()
+	return
+}
+
+// keyHash returns the hash of an agent's key entry in the DHT
+func keyHash(id peer.ID) (Hash, error) {
+	return NewHash(peer.IDB58Encode(id))
+}
+
+// NewAttestation creates an attestation by this agent to an external identity.  Email
+// addresses are replaced with their hash, salted with a new random salt kept in the Salt field.
+func (h *Holochain) NewAttestation(kind string, identity string, proof string) (a *Attestation, err error) {
+	var salt string
+	if kind == AttestEmail {
+		b := make([]byte, 16)
+		if _, err = rand.Read(b); err != nil {
+			return
+		}
+		salt = b58.Encode(b)
+		if identity, err = emailHash(h, identity, salt); err != nil {
+			return
+		}
+	}
+	var pub []byte
+	pub, err = ic.MarshalPublicKey(h.agent.PubKey())
+	if err != nil {
+		return
+	}
+	a = &Attestation{
+		Agent:    peer.IDB58Encode(h.id),
+		PubKey:   b58.Encode(pub),
+		Kind:     kind,
+		Identity: identity,
+		Proof:    proof,
+		Time:     time.Now(),
+		Salt:     salt,
+	}
+	var sig []byte
+	sig, err = h.agent.PrivKey().Sign(a.signed())
+	if err != nil {
+		return
+	}
+	a.Signature = b58.Encode(sig)
+	err = a.Verify()
+	return
+}
+
+// Attest commits an attestation to the chain and publishes it, linked from the agent's key,
+// returning the salt of email attestations
+func (h *Holochain) Attest(kind string, identity string, proof string) (hash Hash, salt string, err error) {
+	var a *Attestation
+	a, err = h.NewAttestation(kind, identity, proof)
+	if err != nil {
+		return
+	}
+	salt = a.Salt
+	var b []byte
+	b, err = json.Marshal(a)
+	if err != nil {
+		return
+	}
+	hash, err = h.Commit(AttestationEntryType, string(b))
+	if err != nil {
+		return
+	}
+	if err = h.dht.SendPut(hash); err != nil {
+		return
+	}
+	var kh Hash
+	kh, err = keyHash(h.id)
+	if err != nil {
+		return
+	}
+	err = h.dht.SendPutMeta(MetaReq{O: kh, M: hash, T: AttestationMetaTag})
+	return
+}
+
+// parseAttestation decodes an attestation entry
+func parseAttestation(entry Entry) (a *Attestation, err error) {
+	s, ok := entry.Content().(string)
+	if !ok {
+		err = errors.New("attestation entry should be a string")
+		return
+	}
+	a = &Attestation{}
+	err = json.Unmarshal([]byte(s), a)
+	return
+}
+
+// validateAttestation checks an attestation entry, which must be made by its source
+func validateAttestation(entry Entry, props *ValidationProps) (err error) {
+	var a *Attestation
+	a, err = parseAttestation(entry)
+	if err != nil {
+		return
+	}
+	if err = a.Verify(); err != nil {
+		return
+	}
+	if props != nil && len(props.Sources) > 0 && props.Sources[0] != a.Agent {
+		err = errors.New("attestation must be published by the attesting agent")
+	}
+	return
+}
+
+// Attestations returns the verified attestations of an agent found in the DHT
+func (h *Holochain) Attestations(id peer.ID) (attestations []*Attestation, err error) {
+	var kh Hash
+	kh, err = keyHash(id)
+	if err != nil {
+		return
+	}
+	var r interface{}
+	r, err = h.dht.SendGetMeta(MetaQuery{H: kh, T: AttestationMetaTag})
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "No values for") {
+			err = nil
+		}
+		return
+	}
+	resp, ok := r.(MetaQueryResp)
+	if !ok {
+		err = fmt.Errorf("unexpected response type from getmeta: %T", r)
+		return
+	}
+	agent := peer.IDB58Encode(id)
+	for _, m := range resp.Entries {
+		a, e := parseAttestation(m.E)
+		if e != nil || a.Agent != agent || a.Verify() != nil {
+			continue
+		}
+		attestations = append(attestations, a)
+	}
+	return
+}
@@ -0,0 +1,118 @@
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAttestations(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	for len(h.dht.puts) > 0 {
+		<-h.dht.puts
+	}
+	agent := peer.IDB58Encode(h.id)
+
+	Convey("it should attest to email addresses by hash", t, func() {
+		a, err := h.NewAttestation(AttestEmail, "Someone@Example.com", "")
+		So(err, ShouldBeNil)
+		So(a.Agent, ShouldEqual, agent)
+		So(a.Identity, ShouldNotContainSubstring, "@")
+		So(a.Salt, ShouldNotEqual, "")
+		So(a.AttestsEmail(h, " someone@example.com", a.Salt), ShouldBeTrue)
+		So(a.AttestsEmail(h, "someone@example.com", ""), ShouldBeFalse)
+		So(a.AttestsEmail(h, "other@example.com", a.Salt), ShouldBeFalse)
+
+		b, _ := h.NewAttestation(AttestEmail, "someone@example.com", "")
+		So(b.Identity, ShouldNotEqual, a.Identity)
+		j, _ := json.Marshal(a)
+		So(string(j), ShouldNotContainSubstring, a.Salt)
+
+		a.Identity = "tampered"
+		So(a.Verify(), ShouldEqual, ErrBadAttestation)
+	})
+
+	Convey("it should only verify attestations signed by the attesting agent", t, func() {
+		a, _ := h.NewAttestation(AttestEmail, "someone@example.com", "")
+		other, _ := makePeer("other")
+		a.Agent = peer.IDB58Encode(other)
+		So(a.Verify().Error(), ShouldEqual, "attestation key doesn't belong to the attesting agent")
+	})
+
+	Convey("it should attest to keys on other chains that have signed the agent's id", t, func() {
+		key, pub, _ := ic.GenerateEd25519Key(strings.NewReader("other chain key1234567890123456789012345678901234567890"))
+		pb, _ := ic.MarshalPublicKey(pub)
+		sig, _ := key.Sign([]byte(agent))
+		_, err := h.NewAttestation(AttestKey, b58.Encode(pb), b58.Encode(sig))
+		So(err, ShouldBeNil)
+
+		sig, _ = key.Sign([]byte("someone else"))
+		_, err = h.NewAttestation(AttestKey, b58.Encode(pb), b58.Encode(sig))
+		So(err.Error(), ShouldEqual, "attested key hasn't signed the agent's id")
+
+		_, err = h.NewAttestation("phone", "555-1234", "")
+		So(err.Error(), ShouldEqual, "unknown attestation kind: phone")
+	})
+
+	Convey("it should verify domain attestations against the domain", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "someone\n%s\n", agent)
+		}))
+		defer ts.Close()
+		old := DomainProofURL
+		defer func() { DomainProofURL = old }()
+		DomainProofURL = func(domain string) string { return ts.URL + "/" + domain }
+
+		a, err := h.NewAttestation(AttestDomain, "example.com", "")
+		So(err, ShouldBeNil)
+		So(a.VerifyDomain(), ShouldBeNil)
+		b, _ := json.Marshal(a)
+		r, err := h.Call(SystemZomeName, "verifyAttestation", string(b))
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, "true")
+
+		a.Agent = "someone-else"
+		So(a.VerifyDomain().Error(), ShouldEqual, "domain example.com doesn't list agent someone-else")
+	})
+
+	Convey("it should publish attestations linked from the agent's key", t, func() {
+		attestations, err := h.Attestations(h.id)
+		So(err, ShouldBeNil)
+		So(len(attestations), ShouldEqual, 0)
+
+		r, err := h.Call(SystemZomeName, "attest", `{"Kind":"email","Identity":"someone@example.com"}`)
+		So(err, ShouldBeNil)
+		var res struct{ Hash, Salt string }
+		So(json.Unmarshal([]byte(r.(string)), &res), ShouldBeNil)
+		hash, _ := NewHash(res.Hash)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		So(h.dht.exists(hash), ShouldBeNil)
+
+		attestations, err = h.Attestations(h.id)
+		So(err, ShouldBeNil)
+		So(len(attestations), ShouldEqual, 1)
+		So(attestations[0].AttestsEmail(h, "someone@example.com", res.Salt), ShouldBeTrue)
+
+		r, err = h.Call(SystemZomeName, "attestations", agent)
+		So(err, ShouldBeNil)
+		So(r.(string), ShouldStartWith, `[{"Agent":"`+agent)
+	})
+
+	Convey("attestation entries must come from the attesting agent", t, func() {
+		a, _ := h.NewAttestation(AttestEmail, "someone@example.com", "")
+		b, _ := json.Marshal(a)
+		other, _ := makePeer("other")
+		err := h.ValidateEntry(AttestationEntryType, &GobEntry{C: string(b)}, &ValidationProps{Sources: []string{peer.IDB58Encode(other)}})
+		So(err.Error(), ShouldEqual, "attestation must be published by the attesting agent")
+		So(h.ValidateEntry(AttestationEntryType, &GobEntry{C: string(b)}, &ValidationProps{Sources: []string{agent}}), ShouldBeNil)
+	})
+}
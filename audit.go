@@ -0,0 +1,342 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// audit implements a public audit log of a node's chain, for apps that want third parties to
+// be able to check that the chain's history is only ever added to.  Like a certificate
+// transparency log (RFC 6962), the node signs statements of its chain's head that include the
+// root of a Merkle tree over the hashes of the chain's headers, and for any two heads gives a
+// consistency proof that the earlier tree is the start of the later one, which takes a number
+// of hashes logarithmic in the length of the chain.  Only headers are given out, never entries.
+
+package holochain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrAuditLogDisabled error = errors.New("the app doesn't publish an audit log")
+var ErrBadAuditHead error = errors.New("audit head signature doesn't verify")
+var ErrAuditInconsistent error = errors.New("audit proof doesn't link the heads")
+
+// AuditHead is a node's signed statement of its chain's head
+type AuditHead struct {
+	Agent     string // node id of the agent whose chain it is
+	PubKey    string // base58 encoded public key of the agent
+	DNA       string // hash of the app's DNA
+	Length    int    // how many headers the chain has
+	Top       string // hash of the chain's top header
+	Root      string // root of the Merkle tree over the hashes of the chain's headers
+	Time      time.Time
+	Signature string // base58 signature by PubKey of all of the above
+}
+
+// AuditHeader is a chain header as given out in the audit log
+type AuditHeader struct {
+	Index      int
+	Hash       string
+	Type       string
+	Time       time.Time
+	HeaderLink string
+	EntryLink  string
+	Bytes      []byte // the marshaled header, which Hash is the hash of
+}
+
+// AuditProof proves that the chain with the head of length From is the start of the chain
+// with the head of length To
+type AuditProof struct {
+	From  int
+	To    int
+	Nodes [][]byte // the Merkle consistency proof between the two heads' trees
+}
+
+// signed returns the bytes of the head that its signature covers
+func (a *AuditHead) signed() []byte {
+	return []byte(strings.Join([]string{a.Agent, a.PubKey, a.DNA, strconv.Itoa(a.Length), a.Top, a.Root, a.Time.UTC().Format(time.RFC3339Nano)}, "\n"))
+}
+
+// Verify checks the head's signature and that the key it is signed with belongs to its agent
+func (a *AuditHead) Verify() (err error) {
+	var key ic.PubKey
+	key, err = ic.UnmarshalPublicKey(b58.Decode(a.PubKey))
+	if err != nil {
+		err = fmt.Errorf("bad audit head key: %v", err)
+		return
+	}
+	var id peer.ID
+	id, err = peer.IDFromPublicKey(key)
+	if err != nil {
+		return
+	}
+	if peer.IDB58Encode(id) != a.Agent {
+		err = errors.New("audit head key doesn't belong to its agent")
+		return
+	}
+	if ok, e := key.Verify(a.signed(), b58.Decode(a.Signature)); e != nil || !ok {
+		err = ErrBadAuditHead
+	}
+	return
+}
+
+// AuditHead returns a signed statement of the chain's current head
+func (h *Holochain) AuditHead() (head *AuditHead, err error) {
+	if !h.AuditLog {
+		err = ErrAuditLogDisabled
+		return
+	}
+	var pub []byte
+	pub, err = ic.MarshalPublicKey(h.agent.PubKey())
+	if err != nil {
+		return
+	}
+	hashes, _, _ := h.chain.snapshot()
+	head = &AuditHead{
+		Agent:  peer.IDB58Encode(h.id),
+		PubKey: b58.Encode(pub),
+		DNA:    h.dnaHash.String(),
+		Length: len(hashes),
+		Time:   time.Now(),
+	}
+	if head.Length > 0 {
+		head.Top = hashes[head.Length-1].String()
+	}
+	var root []byte
+	if root, err = merkleRoot(h.hashSpec, hashes); err != nil {
+		return
+	}
+	head.Root = b58.Encode(root)
+	var sig []byte
+	sig, err = h.agent.PrivKey().Sign(head.signed())
+	if err != nil {
+		return
+	}
+	head.Signature = b58.Encode(sig)
+	return
+}
+
+// checkAuditRange checks that from and to are lengths of a chain of the given length, from no
+// later than to
+func (h *Holochain) checkAuditRange(from int, to int, length int) (err error) {
+	if !h.AuditLog {
+		return ErrAuditLogDisabled
+	}
+	if from < 0 || from > to || to > length {
+		err = fmt.Errorf("bad audit range %d to %d of a chain of %d headers", from, to, length)
+	}
+	return
+}
+
+// AuditLogHeaders returns the chain's headers from index from up to but not including to
+func (h *Holochain) AuditLogHeaders(from int, to int) (headers []AuditHeader, err error) {
+	hashes, chainHeaders, _ := h.chain.snapshot()
+	if err = h.checkAuditRange(from, to, len(hashes)); err != nil {
+		return
+	}
+	for i := from; i < to; i++ {
+		hd := chainHeaders[i]
+		var b []byte
+		if b, err = hd.Marshal(); err != nil {
+			return
+		}
+		headers = append(headers, AuditHeader{
+			Index:      i,
+			Hash:       hashes[i].String(),
+			Type:       hd.Type,
+			Time:       hd.Time,
+			HeaderLink: hd.HeaderLink.String(),
+			EntryLink:  hd.EntryLink.String(),
+			Bytes:      b,
+		})
+	}
+	return
+}
+
+// AuditProof returns a proof that the chain when it had from headers is the start of the
+// chain when it had to headers
+func (h *Holochain) AuditProof(from int, to int) (proof *AuditProof, err error) {
+	hashes, _, _ := h.chain.snapshot()
+	if err = h.checkAuditRange(from, to, len(hashes)); err != nil {
+		return
+	}
+	proof = &AuditProof{From: from, To: to}
+	if from > 0 && from < to {
+		proof.Nodes, err = merkleConsistency(h.hashSpec, from, hashes[:to], true)
+	}
+	return
+}
+
+// VerifyAuditProof checks that a proof shows the chain of the earlier of two heads, signed by
+// the same agent, to be the start of the chain of the later head.  Hashes are checked with
+// the app's hash spec.
+func VerifyAuditProof(spec HashSpec, from *AuditHead, to *AuditHead, proof *AuditProof) (err error) {
+	if err = from.Verify(); err != nil {
+		return
+	}
+	if err = to.Verify(); err != nil {
+		return
+	}
+	if from.Agent != to.Agent || from.DNA != to.DNA {
+		return errors.New("audit heads are of different chains")
+	}
+	if proof.From != from.Length || proof.To != to.Length || from.Length > to.Length {
+		return fmt.Errorf("%v: proof is of %d to %d, heads are of %d and %d", ErrAuditInconsistent, proof.From, proof.To, from.Length, to.Length)
+	}
+	first, second := b58.Decode(from.Root), b58.Decode(to.Root)
+	switch {
+	case from.Length == 0:
+		var empty []byte
+		if empty, err = merkleRoot(spec, nil); err != nil {
+			return
+		}
+		if !bytes.Equal(first, empty) || len(proof.Nodes) != 0 {
+			err = fmt.Errorf("%v: the empty chain's head isn't of an empty tree", ErrAuditInconsistent)
+		}
+	case from.Length == to.Length:
+		if !bytes.Equal(first, second) || from.Top != to.Top || len(proof.Nodes) != 0 {
+			err = fmt.Errorf("%v: heads of the same length differ", ErrAuditInconsistent)
+		}
+	default:
+		err = verifyMerkleConsistency(spec, from.Length, to.Length, first, second, proof.Nodes)
+	}
+	return
+}
+
+// merkleLeaf returns the hash of a leaf of the Merkle tree over a chain's header hashes
+func merkleLeaf(spec HashSpec, leaf Hash) (node []byte, err error) {
+	var hash Hash
+	if err = hash.Sum(spec, append([]byte{0}, leaf.H...)); err != nil {
+		return
+	}
+	node = hash.H
+	return
+}
+
+// merkleNode returns the hash of an interior node of a Merkle tree from its two children
+func merkleNode(spec HashSpec, left []byte, right []byte) (node []byte, err error) {
+	b := make([]byte, 0, 1+len(left)+len(right))
+	b = append(append(append(b, 1), left...), right...)
+	var hash Hash
+	if err = hash.Sum(spec, b); err != nil {
+		return
+	}
+	node = hash.H
+	return
+}
+
+// merkleSplit returns the largest power of two less than n, where the tree of n leaves is
+// split into its two subtrees
+func merkleSplit(n int) (k int) {
+	k = 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return
+}
+
+// merkleRoot returns the root of the Merkle tree over leaves
+func merkleRoot(spec HashSpec, leaves []Hash) (root []byte, err error) {
+	switch len(leaves) {
+	case 0:
+		var hash Hash
+		if err = hash.Sum(spec, nil); err != nil {
+			return
+		}
+		root = hash.H
+		return
+	case 1:
+		return merkleLeaf(spec, leaves[0])
+	}
+	k := merkleSplit(len(leaves))
+	var left, right []byte
+	if left, err = merkleRoot(spec, leaves[:k]); err != nil {
+		return
+	}
+	if right, err = merkleRoot(spec, leaves[k:]); err != nil {
+		return
+	}
+	return merkleNode(spec, left, right)
+}
+
+// merkleConsistency returns the nodes proving the tree of the first m leaves to be the start
+// of the tree of all of them, complete being whether the first m leaves are the whole of the
+// earlier tree rather than a subtree of it
+func merkleConsistency(spec HashSpec, m int, leaves []Hash, complete bool) (nodes [][]byte, err error) {
+	n := len(leaves)
+	if m == n {
+		if !complete {
+			var root []byte
+			if root, err = merkleRoot(spec, leaves); err != nil {
+				return
+			}
+			nodes = [][]byte{root}
+		}
+		return
+	}
+	k := merkleSplit(n)
+	var node []byte
+	if m <= k {
+		if nodes, err = merkleConsistency(spec, m, leaves[:k], complete); err != nil {
+			return
+		}
+		node, err = merkleRoot(spec, leaves[k:])
+	} else {
+		if nodes, err = merkleConsistency(spec, m-k, leaves[k:], false); err != nil {
+			return
+		}
+		node, err = merkleRoot(spec, leaves[:k])
+	}
+	nodes = append(nodes, node)
+	return
+}
+
+// verifyMerkleConsistency checks that nodes prove the tree of m leaves with root first to be
+// the start of the tree of n leaves with root second, for 0 < m < n
+func verifyMerkleConsistency(spec HashSpec, m int, n int, first []byte, second []byte, nodes [][]byte) (err error) {
+	inconsistent := fmt.Errorf("%v: the earlier head's tree isn't the start of the later one's", ErrAuditInconsistent)
+	if m&(m-1) == 0 {
+		// the earlier tree is a complete subtree of the later one, so its root starts the proof
+		nodes = append([][]byte{first}, nodes...)
+	}
+	if len(nodes) == 0 {
+		return inconsistent
+	}
+	fn, sn := m-1, n-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := nodes[0], nodes[0]
+	for _, c := range nodes[1:] {
+		if sn == 0 {
+			return inconsistent
+		}
+		if fn&1 == 1 || fn == sn {
+			if fr, err = merkleNode(spec, c, fr); err != nil {
+				return
+			}
+			if sr, err = merkleNode(spec, c, sr); err != nil {
+				return
+			}
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else if sr, err = merkleNode(spec, sr, c); err != nil {
+			return
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if !bytes.Equal(fr, first) || !bytes.Equal(sr, second) || sn != 0 {
+		err = inconsistent
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package holochain
+
+import (
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should be disabled unless the DNA asks for it", t, func() {
+		_, err := h.AuditHead()
+		So(err, ShouldEqual, ErrAuditLogDisabled)
+		_, err = h.AuditProof(0, 1)
+		So(err, ShouldEqual, ErrAuditLogDisabled)
+		So(StatusCode(err), ShouldEqual, 404)
+	})
+
+	h.AuditLog = true
+	from, err := h.AuditHead()
+	if err != nil {
+		panic(err)
+	}
+	e := GobEntry{C: "audited"}
+	if _, _, err = h.NewEntry(time.Now(), "myData", &e); err != nil {
+		panic(err)
+	}
+	to, err := h.AuditHead()
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("heads should be signed statements of the chain's top", t, func() {
+		So(from.Verify(), ShouldBeNil)
+		So(to.Length, ShouldEqual, from.Length+1)
+		top, _ := h.Top()
+		So(to.Top, ShouldEqual, top.String())
+		So(to.DNA, ShouldEqual, h.dnaHash.String())
+
+		forged := *to
+		forged.Length++
+		So(forged.Verify(), ShouldEqual, ErrBadAuditHead)
+	})
+
+	Convey("the log should give the chain's headers without their entries", t, func() {
+		headers, err := h.AuditLogHeaders(0, to.Length)
+		So(err, ShouldBeNil)
+		So(len(headers), ShouldEqual, to.Length)
+		last := headers[len(headers)-1]
+		So(last.Hash, ShouldEqual, to.Top)
+		So(last.Type, ShouldEqual, "myData")
+		So(last.HeaderLink, ShouldEqual, headers[len(headers)-2].Hash)
+
+		_, err = h.AuditLogHeaders(2, 1)
+		So(err, ShouldNotBeNil)
+		_, err = h.AuditLogHeaders(0, to.Length+1)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("proofs should show the earlier chain is the start of the later", t, func() {
+		proof, err := h.AuditProof(from.Length, to.Length)
+		So(err, ShouldBeNil)
+		So(len(proof.Nodes), ShouldBeGreaterThan, 0)
+		So(VerifyAuditProof(h.hashSpec, from, to, proof), ShouldBeNil)
+
+		proof, err = h.AuditProof(0, to.Length)
+		So(err, ShouldBeNil)
+		empty, _ := merkleRoot(h.hashSpec, nil)
+		start := &AuditHead{Agent: from.Agent, PubKey: from.PubKey, DNA: from.DNA, Root: b58.Encode(empty), Time: time.Now()}
+		sig, _ := h.agent.PrivKey().Sign(start.signed())
+		start.Signature = b58.Encode(sig)
+		So(VerifyAuditProof(h.hashSpec, start, to, proof), ShouldBeNil)
+	})
+
+	Convey("proofs that don't link the heads should fail", t, func() {
+		proof, _ := h.AuditProof(from.Length-1, to.Length)
+		err := VerifyAuditProof(h.hashSpec, from, to, proof)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ErrAuditInconsistent.Error())
+
+		proof, _ = h.AuditProof(from.Length-1, to.Length-1)
+		proof.From, proof.To = from.Length, to.Length
+		err = VerifyAuditProof(h.hashSpec, from, to, proof)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ErrAuditInconsistent.Error())
+	})
+}
+
+func TestMerkleConsistency(t *testing.T) {
+	spec := HashSpec{Code: 0x12, Length: 32}
+	var leaves []Hash
+	for i := 0; i < 17; i++ {
+		var hash Hash
+		if err := hash.Sum(spec, []byte(fmt.Sprintf("header %d", i))); err != nil {
+			panic(err)
+		}
+		leaves = append(leaves, hash)
+	}
+
+	Convey("proofs between trees of every size should verify", t, func() {
+		for n := 2; n <= len(leaves); n++ {
+			second, err := merkleRoot(spec, leaves[:n])
+			So(err, ShouldBeNil)
+			for m := 1; m < n; m++ {
+				first, err := merkleRoot(spec, leaves[:m])
+				So(err, ShouldBeNil)
+				nodes, err := merkleConsistency(spec, m, leaves[:n], true)
+				So(err, ShouldBeNil)
+				So(verifyMerkleConsistency(spec, m, n, first, second, nodes), ShouldBeNil)
+			}
+		}
+	})
+
+	Convey("proofs of trees that don't share their start should fail", t, func() {
+		first, _ := merkleRoot(spec, leaves[:5])
+		nodes, _ := merkleConsistency(spec, 5, leaves[:11], true)
+		forked := append([]Hash{}, leaves[:11]...)
+		forked[2] = leaves[16]
+		second, _ := merkleRoot(spec, forked)
+		So(verifyMerkleConsistency(spec, 5, 11, first, second, nodes), ShouldNotBeNil)
+
+		second, _ = merkleRoot(spec, leaves[:11])
+		nodes[0] = nodes[1]
+		So(verifyMerkleConsistency(spec, 5, 11, first, second, nodes), ShouldNotBeNil)
+		So(verifyMerkleConsistency(spec, 5, 11, first, second, nil), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,341 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// backlog implements catching up with peers that are far ahead without holding everything
+// they have in memory.  Gossip responses carry at most MaxGossipPuts puts, the requester asking
+// for the next batch until it has caught up, and gossiped puts that can't be queued for handling
+// straight away spill to a backlog kept in the DHT store, from which the put queue is refilled
+// as it drains.  The backlog survives restarts and is bounded by a quota; when it is full the
+// "pause" policy stops fetching puts until it drains, leaving the rest for later gossip, while
+// the "drop" policy drops them, leaving them to be recovered by republishing.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultGossipBacklogBytes = 64 << 20
+	MaxGossipPuts             = 100 // most puts sent in one gossip response to peers that batch
+
+	GossipBacklogPause = "pause"
+	GossipBacklogDrop  = "drop"
+)
+
+var ErrGossipBacklogFull error = errors.New("gossip backlog full")
+
+// GossipBacklogQuota returns the most bytes of puts the backlog may hold, zero if puts aren't
+// to be backlogged
+func (q *Quotas) GossipBacklogQuota() int64 {
+	switch {
+	case q.GossipBacklogBytes == 0:
+		return DefaultGossipBacklogBytes
+	case q.GossipBacklogBytes < 0:
+		return 0
+	}
+	return q.GossipBacklogBytes
+}
+
+// BacklogPolicy returns what is done with gossiped puts when the backlog is full
+func (q *Quotas) BacklogPolicy() (policy string, err error) {
+	switch q.GossipBacklogPolicy {
+	case "", GossipBacklogPause:
+		policy = GossipBacklogPause
+	case GossipBacklogDrop:
+		policy = GossipBacklogDrop
+	default:
+		err = fmt.Errorf("gossip backlog policy must be %s or %s, got: %s", GossipBacklogPause, GossipBacklogDrop, q.GossipBacklogPolicy)
+	}
+	return
+}
+
+func backlogKey(seq int) string {
+	return fmt.Sprintf("backlog:%020d", seq)
+}
+
+// loadBacklog reads the size of a backlog left by an earlier run
+func (dht *DHT) loadBacklog() (err error) {
+	dht.backlogLock.Lock()
+	defer dht.backlogLock.Unlock()
+	var n, size int
+	err = dht.db.View(func(tx *buntdb.Tx) (e error) {
+		if n, e = getIntVal("_backlog", tx); e != nil {
+			return
+		}
+		size, e = getIntVal("_backlogbytes", tx)
+		return
+	})
+	if err == nil {
+		dht.backlog = n
+		dht.setBacklogMetrics(n, size)
+	}
+	return
+}
+
+func (dht *DHT) setBacklogMetrics(n int, size int) {
+	dht.h.metrics.Set("dht.backlog", int64(n))
+	dht.h.metrics.Set("dht.backlog.bytes", int64(size))
+}
+
+// Backlog returns the number of gossiped puts waiting in the backlog
+func (dht *DHT) Backlog() int {
+	dht.backlogLock.Lock()
+	defer dht.backlogLock.Unlock()
+	return dht.backlog
+}
+
+// GetPutsBatch returns at most max of the puts from the given index on, and whether there are
+// more to come
+func (dht *DHT) GetPutsBatch(since int, max int) (puts []Put, more bool, err error) {
+	puts = make([]Put, 0)
+	now := time.Now()
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		last, e := getIntVal("_idx", tx)
+		if e != nil {
+			return e
+		}
+		if since < 1 {
+			since = 1
+		}
+		idx := since
+		for ; idx <= last && len(puts) < max; idx++ {
+			var p Put
+			value, e := tx.Get(fmt.Sprintf("idx:%d", idx))
+			if e != nil && e != buntdb.ErrNotFound {
+				return e
+			}
+			if value != "" {
+				if e = ByteDecoder([]byte(value), &p.M); e != nil {
+					return e
+				}
+			}
+			if r, ok := p.M.Body.(PutReq); ok && isExpired(tx, r.H.String(), now) {
+				// keep the place of expired puts so that indexes still line up
+				p = Put{}
+			}
+			puts = append(puts, p)
+		}
+		more = idx <= last
+		return nil
+	})
+	return
+}
+
+// tryQueuePut adds a put to the put queue if there is room for it
+func (dht *DHT) tryQueuePut(m *Message) bool {
+	atomic.AddInt32(&dht.inflight, 1)
+	select {
+	case dht.puts <- m:
+		dht.h.metrics.Set("dht.putqueue", int64(len(dht.puts)))
+		return true
+	default:
+		atomic.AddInt32(&dht.inflight, -1)
+		return false
+	}
+}
+
+// queueGossiped queues a gossiped put for handling, spilling it to the backlog if the put
+// queue is full or puts are already waiting in the backlog, so that they are handled in order
+func (dht *DHT) queueGossiped(m *Message) (err error) {
+	if dht.h.ShuttingDown() {
+		return ErrShuttingDown
+	}
+	dht.backlogLock.Lock()
+	defer dht.backlogLock.Unlock()
+	if dht.backlog == 0 && dht.tryQueuePut(m) {
+		return
+	}
+	var b []byte
+	if b, err = ByteEncoder(m); err != nil {
+		return
+	}
+	quotas := dht.h.Config().Quotas
+	quota := quotas.GossipBacklogQuota()
+	var n, size int
+	err = dht.db.Update(func(tx *buntdb.Tx) (e error) {
+		if size, e = getIntVal("_backlogbytes", tx); e != nil {
+			return
+		}
+		if int64(size+len(b)) > quota {
+			return ErrGossipBacklogFull
+		}
+		var seq int
+		if seq, e = getIntVal("_backlogseq", tx); e != nil {
+			return
+		}
+		if n, e = getIntVal("_backlog", tx); e != nil {
+			return
+		}
+		seq++
+		n++
+		size += len(b)
+		if _, _, e = tx.Set(backlogKey(seq), string(b), nil); e != nil {
+			return
+		}
+		if _, _, e = tx.Set("_backlogseq", fmt.Sprintf("%d", seq), nil); e != nil {
+			return
+		}
+		return setBacklogCounts(tx, n, size)
+	})
+	if err == nil {
+		dht.backlog = n
+		dht.setBacklogMetrics(n, size)
+	}
+	return
+}
+
+// refillPuts moves puts from the backlog to the put queue until the queue is full or the
+// backlog is empty.  Puts are only queued once their removal from the backlog is committed,
+// and any that no longer fit in the queue by then are put back.
+func (dht *DHT) refillPuts() (err error) {
+	dht.backlogLock.Lock()
+	defer dht.backlogLock.Unlock()
+	if dht.backlog == 0 {
+		return
+	}
+	type backlogged struct {
+		key   string
+		value string
+		m     Message
+	}
+	var moved []backlogged
+	var n, size int
+	room := cap(dht.puts) - len(dht.puts)
+	err = dht.db.Update(func(tx *buntdb.Tx) (e error) {
+		if n, e = getIntVal("_backlog", tx); e != nil {
+			return
+		}
+		if size, e = getIntVal("_backlogbytes", tx); e != nil {
+			return
+		}
+		tx.AscendKeys("backlog:*", func(key, value string) bool {
+			if len(moved) >= room {
+				return false
+			}
+			b := backlogged{key: key, value: value}
+			if e = ByteDecoder([]byte(value), &b.m); e != nil {
+				return false
+			}
+			moved = append(moved, b)
+			return true
+		})
+		if e != nil {
+			return
+		}
+		for _, b := range moved {
+			if _, e = tx.Delete(b.key); e != nil {
+				return
+			}
+			n--
+			size -= len(b.value)
+		}
+		return setBacklogCounts(tx, n, size)
+	})
+	if err != nil {
+		return
+	}
+	var left []backlogged
+	for i := range moved {
+		if !dht.tryQueuePut(&moved[i].m) {
+			left = moved[i:]
+			break
+		}
+	}
+	if len(left) > 0 {
+		err = dht.db.Update(func(tx *buntdb.Tx) (e error) {
+			for _, b := range left {
+				if _, _, e = tx.Set(b.key, b.value, nil); e != nil {
+					return
+				}
+				n++
+				size += len(b.value)
+			}
+			return setBacklogCounts(tx, n, size)
+		})
+	}
+	dht.backlog = n
+	dht.setBacklogMetrics(n, size)
+	return
+}
+
+func setBacklogCounts(tx *buntdb.Tx, n int, size int) (err error) {
+	if _, _, err = tx.Set("_backlog", fmt.Sprintf("%d", n), nil); err != nil {
+		return
+	}
+	_, _, err = tx.Set("_backlogbytes", fmt.Sprintf("%d", size), nil)
+	return
+}
+
+// receiveGossipedPuts runs a batch of puts received from a gossiper, returning how many of
+// them were taken, and whether the gossiper should be asked for no more because the backlog
+// is full
+func (dht *DHT) receiveGossipedPuts(id peer.ID, puts []Put) (taken int, paused bool) {
+	quotas := dht.h.Config().Quotas
+	policy, err := quotas.BacklogPolicy()
+	if err != nil {
+		policy = GossipBacklogPause
+	}
+	defer func() {
+		if e := dht.refillPuts(); e != nil {
+			dht.glog.Logf("error refilling put queue: %v", e)
+		}
+	}()
+	// entries put earlier in the batch count as existing for metas later in it, as they will
+	// have been handled by the time the metas are
+	batch := make(map[string]bool)
+	for i := range puts {
+		m := puts[i].M
+		if m.Body == nil {
+			// placeholder for an expired put, or one outside the topics this node holds
+			taken++
+			continue
+		}
+		dht.glog.Log("running puts")
+		r, isPut := m.Body.(PutReq)
+		meta, isMeta := m.Body.(MetaReq)
+		if m.Type == PUTMETA_REQUEST && isMeta && !batch[meta.O.String()] && dht.exists(meta.O) != nil {
+			// as when a putmeta is sent directly, one on an entry that isn't held is ignored
+			dht.glog.Logf("gossiped putmeta on %v which doesn't exist, ignoring", meta.O)
+			taken++
+			continue
+		}
+		if (m.Type == PUT_REQUEST && isPut) || (m.Type == PUTMETA_REQUEST && isMeta) {
+			err = dht.queueGossiped(&m)
+			if err == ErrGossipBacklogFull && policy == GossipBacklogDrop {
+				dht.h.quotaExceeded("backlog", "gossip backlog full, dropping put from %v", id)
+				dht.h.metrics.Inc("dht.backlog.dropped", 1)
+			} else if err != nil {
+				if err == ErrGossipBacklogFull {
+					dht.h.quotaExceeded("backlog", "gossip backlog full, pausing gossip with %v", id)
+				} else {
+					dht.glog.Logf("error queueing put: %v", err)
+				}
+				paused = true
+				return
+			}
+		} else {
+			// the gossiper passed on a message that isn't a put, which is its violation
+			// rather than that of the node the message claims to be from
+			dht.glog.Logf("gossip from %v held a %v message from %v", id, m.Type, m.From)
+			dht.recordPeerEvent(id, PeerViolation)
+			taken++
+			continue
+		}
+		if isPut {
+			batch[r.H.String()] = true
+			// the gossiper told us about this put so it must hold the entry
+			if e := dht.AddHolder(r.H, id); e != nil {
+				dht.glog.Logf("error recording holder: %v", e)
+			}
+		}
+		taken++
+	}
+	return
+}
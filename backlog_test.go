@@ -0,0 +1,159 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestGetPutsBatch(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	last, err := dht.GetIdx()
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should return at most max puts, saying whether there are more", t, func() {
+		puts, more, err := dht.GetPutsBatch(1, last-1)
+		So(err, ShouldBeNil)
+		So(len(puts), ShouldEqual, last-1)
+		So(more, ShouldBeTrue)
+
+		all, err := dht.GetPuts(0)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", puts[0].M), ShouldEqual, fmt.Sprintf("%v", all[0].M))
+
+		puts, more, err = dht.GetPutsBatch(last, MaxGossipPuts)
+		So(err, ShouldBeNil)
+		So(len(puts), ShouldEqual, 1)
+		So(more, ShouldBeFalse)
+
+		puts, more, err = dht.GetPutsBatch(last+1, MaxGossipPuts)
+		So(err, ShouldBeNil)
+		So(len(puts), ShouldEqual, 0)
+		So(more, ShouldBeFalse)
+	})
+}
+
+func TestGossipBacklog(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	putMsg := func(i int) *Message {
+		e := GobEntry{C: fmt.Sprintf("backlogged %d", i)}
+		_, hd, _ := h.NewEntry(time.Now(), "myData", &e)
+		return h.node.NewMessage(PUT_REQUEST, PutReq{H: hd.EntryLink})
+	}
+	fillQueue := func() {
+		for i := 0; len(dht.puts) < cap(dht.puts); i++ {
+			dht.tryQueuePut(putMsg(-i))
+		}
+	}
+	drainQueue := func() {
+		for len(dht.puts) > 0 {
+			dht.simHandlePutReqs()
+		}
+	}
+
+	Convey("it should default the backlog quota and policy", t, func() {
+		So(h.config.Quotas.GossipBacklogQuota(), ShouldEqual, DefaultGossipBacklogBytes)
+		policy, err := h.config.Quotas.BacklogPolicy()
+		So(err, ShouldBeNil)
+		So(policy, ShouldEqual, GossipBacklogPause)
+	})
+
+	Convey("config should reject unknown backlog policies", t, func() {
+		config := h.config
+		config.Quotas.GossipBacklogPolicy = "panic"
+		So(config.setup(), ShouldNotBeNil)
+	})
+
+	Convey("gossiped puts should be queued while the queue has room", t, func() {
+		So(dht.queueGossiped(putMsg(1)), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 0)
+		So(len(dht.puts), ShouldEqual, 1)
+		drainQueue()
+	})
+
+	Convey("gossiped puts should spill to the backlog when the queue is full", t, func() {
+		fillQueue()
+		m := putMsg(2)
+		So(dht.queueGossiped(m), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 1)
+		So(h.Metrics().Get("dht.backlog"), ShouldEqual, 1)
+		So(h.Metrics().Get("dht.backlog.bytes"), ShouldBeGreaterThan, 0)
+
+		// puts are kept in order, so once there's a backlog new puts join it
+		drainQueue()
+		So(dht.queueGossiped(putMsg(3)), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 2)
+		So(len(dht.puts), ShouldEqual, 0)
+
+		// the backlog survives a restart
+		dht.backlog = 0
+		So(dht.loadBacklog(), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 2)
+
+		// and refills the put queue in order
+		So(dht.refillPuts(), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 0)
+		So(h.Metrics().Get("dht.backlog"), ShouldEqual, 0)
+		So(h.Metrics().Get("dht.backlog.bytes"), ShouldEqual, 0)
+		So(len(dht.puts), ShouldEqual, 2)
+		first := <-dht.puts
+		So(first.Body.(PutReq).H.String(), ShouldEqual, m.Body.(PutReq).H.String())
+		So(dht.handlePutReq(first), ShouldBeNil)
+		drainQueue()
+	})
+
+	Convey("refilling should leave in the backlog the puts the queue has no room for", t, func() {
+		fillQueue()
+		So(dht.queueGossiped(putMsg(7)), ShouldBeNil)
+		So(dht.queueGossiped(putMsg(8)), ShouldBeNil)
+		<-dht.puts
+		So(dht.refillPuts(), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 1)
+		So(len(dht.puts), ShouldEqual, cap(dht.puts))
+		drainQueue()
+		So(dht.refillPuts(), ShouldBeNil)
+		So(dht.Backlog(), ShouldEqual, 0)
+		drainQueue()
+	})
+
+	Convey("gossiped putmetas on entries that aren't held should be ignored", t, func() {
+		unknown, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		m := h.node.NewMessage(PUTMETA_REQUEST, MetaReq{O: unknown, M: unknown, T: "odd"})
+		taken, paused := dht.receiveGossipedPuts(h.id, []Put{{M: *m}})
+		So(paused, ShouldBeFalse)
+		So(taken, ShouldEqual, 1)
+		So(len(dht.puts), ShouldEqual, 0)
+		So(dht.Backlog(), ShouldEqual, 0)
+	})
+
+	h.config.Quotas.GossipBacklogBytes = 1
+	puts := []Put{{}, {M: *putMsg(5)}, {M: *putMsg(6)}}
+
+	Convey("a full backlog should pause gossip by default", t, func() {
+		fillQueue()
+		So(dht.queueGossiped(putMsg(4)), ShouldEqual, ErrGossipBacklogFull)
+
+		taken, paused := dht.receiveGossipedPuts(h.id, puts)
+		So(paused, ShouldBeTrue)
+		So(taken, ShouldEqual, 1)
+		So(h.Metrics().Get("quota.backlog.exceeded"), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("a full backlog should drop puts with the drop policy", t, func() {
+		h.config.Quotas.GossipBacklogPolicy = GossipBacklogDrop
+		taken, paused := dht.receiveGossipedPuts(h.id, puts)
+		So(paused, ShouldBeFalse)
+		So(taken, ShouldEqual, 3)
+		So(h.Metrics().Get("dht.backlog.dropped"), ShouldEqual, 2)
+		drainQueue()
+	})
+}
@@ -0,0 +1,551 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// backup implements encrypted, incremental backups of a chain and its agent's keys to a
+// directory, an S3 compatible endpoint or an SFTP server.  The chain's file is only ever
+// appended to, so each backup holds just what was added to it since the one before, along with
+// any of the chain's other files, like its DNA and config, and the agent's files that changed.
+// If the chain's file was rewritten, e.g. by compaction, the next backup holds all of it.  Each
+// backup is sealed with AES-GCM under a key derived from a backup passphrase, so the target
+// never sees the chain or the keys.  Restoring replays the backups from the latest full one,
+// checks that they join up, and then loads the restored chain and validates it.  The DHT store
+// isn't backed up, as it is held by other nodes too.
+
+package holochain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BackupPassphraseEnvVar = "HC_BACKUP_PASSPHRASE" // environment variable consulted for the backup passphrase
+	BackupStateFileName    = "backup.state"         // records what the last backup of a chain held
+
+	DefaultBackupInterval = 24 * time.Hour
+
+	backupMagic   = "HCBAK01\n"
+	backupExt     = ".hcb"
+	backupTailLen = 4096 // how much of the end of the chain's file is checked to be unchanged
+)
+
+var ErrNoBackupPassphrase error = errors.New("no backup passphrase was given")
+var ErrNotBackedUp error = errors.New("chain has no backup target configured")
+var ErrNothingToBackUp error = errors.New("chain hasn't changed since its last backup")
+var ErrNoBackups error = errors.New("no backups of the chain found")
+var ErrBackupGap error = errors.New("backups of the chain don't join up")
+
+// BackupConfig configures where and how often a chain is backed up
+type BackupConfig struct {
+	Target   string // a directory, s3://bucket/prefix or sftp://user@host/path
+	Interval string `toml:",omitempty"` // how often to back up while serving, DefaultBackupInterval if not set
+}
+
+// IntervalDuration returns how often the chain should be backed up
+func (c *BackupConfig) IntervalDuration() (interval time.Duration, err error) {
+	if c.Interval == "" {
+		interval = DefaultBackupInterval
+		return
+	}
+	interval, err = time.ParseDuration(c.Interval)
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("backup interval must be positive, got: %s", c.Interval)
+	}
+	return
+}
+
+// Check confirms that the backup target is one that is known and the interval parses
+func (c *BackupConfig) Check() (err error) {
+	if _, err = NewBackupTarget(c.Target); err != nil {
+		return
+	}
+	_, err = c.IntervalDuration()
+	return
+}
+
+// BackupFile is a file of the chain or of its agent held in a backup
+type BackupFile struct {
+	Agent bool   // the file is in the service's config directory rather than the chain's
+	Name  string // path of the file relative to its directory, slash separated
+	Data  []byte
+}
+
+// Backup holds what was added to a chain since its previous backup
+type Backup struct {
+	Chain  string
+	Seq    int // backups of a chain are numbered from 1
+	Time   time.Time
+	Offset int64  // where in the chain's file Data starts, 0 for a full backup
+	Data   []byte // the chain's file from Offset
+	Size   int64  // the length of the chain's file once Data is added
+	Pairs  int    // how many header and entry pairs the chain's file holds
+	Top    string // hash of the chain's top header, empty if it has none
+	Files  []BackupFile
+}
+
+// backupState records what the last backup of a chain held, so the next only holds changes
+type backupState struct {
+	Seq  int
+	Size int64
+	Tail string            // hash of the end of the chain's file as backed up
+	Sums map[string]string // hashes of the other files backed up, by their names
+}
+
+// BackupName returns the name a backup of a chain is stored under at a target
+func BackupName(chain string, seq int) string {
+	return fmt.Sprintf("%s/%08d%s", chain, seq, backupExt)
+}
+
+// BackupPassphrase returns the backup passphrase from its environment variable
+func BackupPassphrase() (passphrase string, err error) {
+	if passphrase = os.Getenv(BackupPassphraseEnvVar); passphrase == "" {
+		err = ErrNoBackupPassphrase
+	}
+	return
+}
+
+// sealBackup encrypts a backup under a key derived from the passphrase and a fresh salt
+func sealBackup(b *Backup, passphrase string) (sealed []byte, err error) {
+	var plain []byte
+	if plain, err = ByteEncoder(b); err != nil {
+		return
+	}
+	salt := make([]byte, chainSaltLen)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	var c *chainCrypt
+	if c, err = newChainCrypt(passphrase, salt, nil); err != nil {
+		return
+	}
+	var s []byte
+	if s, err = c.seal(plain); err != nil {
+		return
+	}
+	sealed = append(append([]byte(backupMagic), salt...), s...)
+	return
+}
+
+// openBackup decrypts a backup sealed with the passphrase
+func openBackup(sealed []byte, passphrase string) (b *Backup, err error) {
+	if len(sealed) < len(backupMagic)+chainSaltLen || string(sealed[:len(backupMagic)]) != backupMagic {
+		err = errors.New("not a holochain backup")
+		return
+	}
+	salt := sealed[len(backupMagic) : len(backupMagic)+chainSaltLen]
+	var c *chainCrypt
+	if c, err = newChainCrypt(passphrase, salt, nil); err != nil {
+		return
+	}
+	var plain []byte
+	if plain, err = c.open(sealed[len(backupMagic)+chainSaltLen:]); err != nil {
+		err = ErrBadPassphrase
+		return
+	}
+	b = &Backup{}
+	err = ByteDecoder(plain, b)
+	return
+}
+
+func sumBytes(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+// backupSkips are the chain's files that aren't backed up: the DHT store, which other nodes
+// hold too, files the chain's file is being written or compacted through, and logs
+var backupSkips = map[string]bool{
+	StoreFileName + ".dat": true,
+	"dht.db":               true,
+	BackupStateFileName:    true,
+	ServingFileName:        true,
+	ServeLogFileName:       true,
+}
+
+// skipBackup returns whether a chain's file isn't backed up
+func skipBackup(name string) bool {
+	return backupSkips[name] || strings.HasSuffix(name, WALSuffix) || strings.HasSuffix(name, CompactSuffix)
+}
+
+// readBackupFiles reads the files of a directory to back up, skipping those skip is true of
+func readBackupFiles(dir string, agent bool, names []string, skip func(name string) bool) (files []BackupFile, err error) {
+	if names == nil {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err == nil {
+				names = append(names, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		if err != nil {
+			return
+		}
+	}
+	for _, name := range names {
+		if skip != nil && skip(name) {
+			continue
+		}
+		var data []byte
+		if data, err = ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return
+		}
+		files = append(files, BackupFile{Agent: agent, Name: name, Data: data})
+	}
+	return
+}
+
+// Backup backs up what was added to the chain since its last backup to its configured target
+func (s *Service) Backup(h *Holochain, passphrase string) (b *Backup, err error) {
+	c := h.BackupConfig()
+	if c == nil {
+		err = ErrNotBackedUp
+		return
+	}
+	var target BackupTarget
+	if target, err = NewBackupTarget(c.Target); err != nil {
+		return
+	}
+	if passphrase == "" {
+		err = ErrNoBackupPassphrase
+		return
+	}
+
+	var state backupState
+	statePath := filepath.Join(h.path, BackupStateFileName)
+	if data, e := ioutil.ReadFile(statePath); e == nil {
+		if err = json.Unmarshal(data, &state); err != nil {
+			return
+		}
+	}
+	if state.Sums == nil {
+		state.Sums = make(map[string]string)
+	}
+
+	// only whole pairs are backed up, as one may be being written
+	var it *ChainIterator
+	if it, err = h.Iterator(true); err != nil {
+		return
+	}
+	defer it.Close()
+	b = &Backup{Chain: filepath.Base(h.path), Seq: state.Seq + 1, Time: time.Now(), Size: it.end, Pairs: it.Len()}
+	if it.Next() {
+		b.Top = it.Hash().String()
+	}
+	if err = it.Err(); err != nil {
+		return
+	}
+	tail := func(size int64) (sum string, err error) {
+		start := size - backupTailLen
+		if start < 0 {
+			start = 0
+		}
+		buf := make([]byte, size-start)
+		if _, err = it.f.ReadAt(buf, start); err == nil {
+			sum = sumBytes(buf)
+		}
+		return
+	}
+	if state.Size > 0 && state.Size <= b.Size {
+		var sum string
+		if sum, err = tail(state.Size); err != nil {
+			return
+		}
+		if sum == state.Tail {
+			b.Offset = state.Size
+		}
+	}
+	b.Data = make([]byte, b.Size-b.Offset)
+	if _, err = it.f.ReadAt(b.Data, b.Offset); err != nil && err != io.EOF {
+		return
+	}
+	if state.Tail, err = tail(b.Size); err != nil {
+		return
+	}
+
+	var files, agentFiles []BackupFile
+	if files, err = readBackupFiles(h.path, false, nil, skipBackup); err != nil {
+		return
+	}
+	if agentFiles, err = readBackupFiles(s.ConfigPath, true, []string{AgentFileName, PrivKeyFileName}, nil); err != nil {
+		return
+	}
+	sums := make(map[string]string)
+	for _, f := range append(files, agentFiles...) {
+		key := f.Name
+		if f.Agent {
+			key = "agent:" + f.Name
+		}
+		sums[key] = sumBytes(f.Data)
+		if b.Offset == 0 || state.Sums[key] != sums[key] {
+			b.Files = append(b.Files, f)
+		}
+	}
+
+	if b.Offset > 0 && len(b.Data) == 0 && len(b.Files) == 0 {
+		err = ErrNothingToBackUp
+		return
+	}
+
+	var sealed []byte
+	if sealed, err = sealBackup(b, passphrase); err != nil {
+		return
+	}
+	if err = target.Put(BackupName(b.Chain, b.Seq), sealed); err != nil {
+		return
+	}
+	state.Seq = b.Seq
+	state.Size = b.Size
+	state.Sums = sums
+	var data []byte
+	if data, err = json.Marshal(&state); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(statePath, data, 0600); err != nil {
+		return
+	}
+	h.metrics.Inc("backup.runs", 1)
+	h.metrics.Set("backup.bytes", int64(len(sealed)))
+	return
+}
+
+// BackupEvery backs up the chain at the given interval, logging any errors, until the chain
+// begins shutting down or stops being backed up.  The backup config is read again each time,
+// so a changed interval takes effect after the next backup.
+func (s *Service) BackupEvery(h *Holochain, interval time.Duration, passphrase string) {
+	t := time.NewTicker(interval)
+	defer func() { t.Stop() }()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-t.C:
+		}
+		c := h.BackupConfig()
+		if c == nil {
+			return
+		}
+		if _, err := s.Backup(h, passphrase); err != nil && err != ErrNothingToBackUp {
+			h.dht.dlog.Logf("backup error: %v", err)
+		}
+		if i, err := c.IntervalDuration(); err == nil && i != interval {
+			interval = i
+			t.Stop()
+			t = time.NewTicker(interval)
+		}
+	}
+}
+
+// BackupConfig returns where the chain is backed up to, or nil if it isn't
+func (h *Holochain) BackupConfig() *BackupConfig {
+	return h.Config().Backup
+}
+
+// SetBackupConfig sets where the chain is backed up to and saves it to the chain's config
+func (h *Holochain) SetBackupConfig(c *BackupConfig) (err error) {
+	if c != nil {
+		if err = c.Check(); err != nil {
+			return
+		}
+	}
+	h.updateConfig(func(config *Config) { config.Backup = c })
+	err = h.saveConfig()
+	return
+}
+
+// Restored describes a chain restored from its backups
+type Restored struct {
+	Chain   string
+	Backups int // how many backups were replayed, from the latest full one
+	Seq     int // number of the last backup replayed
+	Time    time.Time
+	Pairs   int
+	Top     string
+}
+
+// fetchBackups gets and opens a chain's backups from the latest full backup on
+func fetchBackups(target BackupTarget, chain string, passphrase string) (backups []*Backup, err error) {
+	var names []string
+	if names, err = target.List(chain + "/"); err != nil {
+		return
+	}
+	var found []string
+	for _, n := range names {
+		if strings.HasSuffix(n, backupExt) && strings.HasPrefix(n, chain+"/") {
+			found = append(found, n)
+		}
+	}
+	if len(found) == 0 {
+		err = ErrNoBackups
+		return
+	}
+	sort.Strings(found)
+	for i := len(found) - 1; i >= 0; i-- {
+		var data []byte
+		if data, err = target.Get(found[i]); err != nil {
+			return
+		}
+		var b *Backup
+		if b, err = openBackup(data, passphrase); err != nil {
+			err = fmt.Errorf("%s: %v", found[i], err)
+			return
+		}
+		backups = append([]*Backup{b}, backups...)
+		if b.Offset == 0 {
+			return
+		}
+	}
+	err = ErrBackupGap
+	return
+}
+
+// restoreBackups writes a chain's backups into a chain directory and an agent directory,
+// not overwriting an agent that is already there with a different one
+func restoreBackups(backups []*Backup, chainDir string, agentDir string) (err error) {
+	if existing, e := ioutil.ReadFile(filepath.Join(agentDir, PrivKeyFileName)); e == nil {
+		for _, b := range backups {
+			for _, file := range b.Files {
+				if file.Agent && file.Name == PrivKeyFileName && sumBytes(existing) != sumBytes(file.Data) {
+					err = errors.New("the service already has a different agent than the backed up chain's")
+					return
+				}
+			}
+		}
+	}
+	if err = os.MkdirAll(chainDir, os.ModePerm); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Create(filepath.Join(chainDir, StoreFileName+".dat")); err != nil {
+		return
+	}
+	defer f.Close()
+	var size int64
+	for i, b := range backups {
+		if b.Offset != size || (i > 0 && b.Seq != backups[i-1].Seq+1) {
+			err = fmt.Errorf("%v: backup %d starts at %d of the chain's file, expected %d", ErrBackupGap, b.Seq, b.Offset, size)
+			return
+		}
+		if _, err = f.Write(b.Data); err != nil {
+			return
+		}
+		size += int64(len(b.Data))
+		for _, file := range b.Files {
+			dir := chainDir
+			if file.Agent {
+				dir = agentDir
+			}
+			path := filepath.Join(dir, filepath.FromSlash(file.Name))
+			if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return
+			}
+			if err = ioutil.WriteFile(path, file.Data, 0600); err != nil {
+				return
+			}
+		}
+	}
+	if last := backups[len(backups)-1]; size != last.Size {
+		err = fmt.Errorf("%v: restored %d bytes of the chain's file, expected %d", ErrBackupGap, size, last.Size)
+	}
+	return
+}
+
+// checkRestored loads a restored chain and checks that it validates and has the backed up top
+func (s *Service) checkRestored(chain string, last *Backup) (r *Restored, err error) {
+	var svc *Service
+	if svc, err = LoadServiceDirs(s.Dirs()); err != nil {
+		return
+	}
+	svc.GetPassphrase = s.GetPassphrase
+	var h *Holochain
+	if h, err = svc.Load(chain); err != nil {
+		return
+	}
+	if err = h.ValidateChain(); err != nil {
+		return
+	}
+	r = &Restored{Chain: chain, Seq: last.Seq, Time: last.Time, Pairs: len(h.chain.Headers)}
+	if r.Pairs > 0 {
+		r.Top = h.chain.Hashes[r.Pairs-1].String()
+	}
+	if r.Pairs != last.Pairs || r.Top != last.Top {
+		err = fmt.Errorf("restored chain has %d pairs with top %s, but was backed up with %d with top %s", r.Pairs, r.Top, last.Pairs, last.Top)
+	}
+	return
+}
+
+// Restore restores a chain from its backups at a target into the service, along with its
+// agent's keys if the service doesn't have them, and checks the restored chain
+func (s *Service) Restore(target BackupTarget, chain string, passphrase string) (r *Restored, err error) {
+	path := filepath.Join(s.Path, chain)
+	if dirExists(path) {
+		err = fmt.Errorf("chain %s already exists", chain)
+		return
+	}
+	var backups []*Backup
+	if backups, err = fetchBackups(target, chain, passphrase); err != nil {
+		return
+	}
+	var added []string
+	for _, name := range []string{AgentFileName, PrivKeyFileName} {
+		if p := filepath.Join(s.ConfigPath, name); !fileExists(p) {
+			added = append(added, p)
+		}
+	}
+	if err = restoreBackups(backups, path, s.ConfigPath); err == nil {
+		r, err = s.checkRestored(chain, backups[len(backups)-1])
+	}
+	if err != nil {
+		// a chain that didn't restore fully isn't left behind, nor are the agent's files it
+		// brought with it
+		os.RemoveAll(path)
+		for _, p := range added {
+			os.Remove(p)
+		}
+		return
+	}
+	r.Backups = len(backups)
+	return
+}
+
+// VerifyBackups restores a chain from its backups at a target into a throwaway service and
+// checks it, leaving the service untouched
+func (s *Service) VerifyBackups(target BackupTarget, chain string, passphrase string) (r *Restored, err error) {
+	var root string
+	if root, err = ioutil.TempDir("", "hc-restore"); err != nil {
+		return
+	}
+	defer os.RemoveAll(root)
+	var backups []*Backup
+	if backups, err = fetchBackups(target, chain, passphrase); err != nil {
+		return
+	}
+	if err = restoreBackups(backups, filepath.Join(root, chain), root); err != nil {
+		return
+	}
+	if err = writeToml(root, SysFileName, s.Settings, false); err != nil {
+		return
+	}
+	if err = writeFormat(SingleChainDirs(root), CurrentFormat); err != nil {
+		return
+	}
+	tmp := &Service{Settings: s.Settings, Path: root, ConfigPath: root, GetPassphrase: s.GetPassphrase}
+	if r, err = tmp.checkRestored(chain, backups[len(backups)-1]); err == nil {
+		r.Backups = len(backups)
+	}
+	return
+}
@@ -0,0 +1,272 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackupConfig(t *testing.T) {
+	Convey("it should check backup targets and intervals", t, func() {
+		So((&BackupConfig{Target: "/tmp/backups"}).Check(), ShouldBeNil)
+		So((&BackupConfig{Target: "s3://bucket/prefix?endpoint=http://localhost:9000", Interval: "6h"}).Check(), ShouldBeNil)
+		So((&BackupConfig{Target: "sftp://me@host:2222/backups"}).Check(), ShouldBeNil)
+		So((&BackupConfig{Target: ""}).Check(), ShouldNotBeNil)
+		So((&BackupConfig{Target: "ftp://host/backups"}).Check(), ShouldNotBeNil)
+		So((&BackupConfig{Target: "s3:///prefix"}).Check(), ShouldNotBeNil)
+		So((&BackupConfig{Target: "/tmp/backups", Interval: "-1h"}).Check(), ShouldNotBeNil)
+		i, err := (&BackupConfig{Target: "/tmp/backups"}).IntervalDuration()
+		So(err, ShouldBeNil)
+		So(i, ShouldEqual, DefaultBackupInterval)
+	})
+}
+
+func TestBackup(t *testing.T) {
+	d, s, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	target := filepath.Join(d, "backups")
+
+	Convey("it should only back up configured chains", t, func() {
+		_, err := s.Backup(h, "secret")
+		So(err, ShouldEqual, ErrNotBackedUp)
+	})
+
+	Convey("it should save the backup config with the chain", t, func() {
+		So(h.SetBackupConfig(&BackupConfig{Target: "nowhere://"}), ShouldNotBeNil)
+		So(h.SetBackupConfig(&BackupConfig{Target: target}), ShouldBeNil)
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(h2.BackupConfig().Target, ShouldEqual, target)
+	})
+
+	Convey("it should require a passphrase", t, func() {
+		_, err := s.Backup(h, "")
+		So(err, ShouldEqual, ErrNoBackupPassphrase)
+	})
+
+	Convey("it should make a full backup and then incremental ones", t, func() {
+		for _, name := range []string{ServeLogFileName, StoreFileName + ".dat" + WALSuffix, StoreFileName + ".dat" + CompactSuffix} {
+			So(ioutil.WriteFile(filepath.Join(h.path, name), []byte("x"), 0600), ShouldBeNil)
+		}
+		b, err := s.Backup(h, "secret")
+		So(err, ShouldBeNil)
+		for _, f := range b.Files {
+			So(skipBackup(f.Name), ShouldBeFalse)
+		}
+		So(skipBackup(ServeLogFileName), ShouldBeTrue)
+		So(skipBackup(StoreFileName+".dat"+WALSuffix), ShouldBeTrue)
+		So(skipBackup(StoreFileName+".dat"+CompactSuffix), ShouldBeTrue)
+		So(b.Seq, ShouldEqual, 1)
+		So(b.Offset, ShouldEqual, 0)
+		So(b.Pairs, ShouldEqual, len(h.chain.Headers))
+		So(b.Top, ShouldEqual, h.chain.Hashes[len(h.chain.Hashes)-1].String())
+		var agent bool
+		for _, f := range b.Files {
+			agent = agent || (f.Agent && f.Name == PrivKeyFileName)
+		}
+		So(agent, ShouldBeTrue)
+		So(h.Metrics().Get("backup.runs"), ShouldEqual, 1)
+
+		_, err = s.Backup(h, "secret")
+		So(err, ShouldEqual, ErrNothingToBackUp)
+
+		_, err = h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		b2, err := s.Backup(h, "secret")
+		So(err, ShouldBeNil)
+		So(b2.Seq, ShouldEqual, 2)
+		So(b2.Offset, ShouldEqual, b.Size)
+		So(len(b2.Files), ShouldEqual, 0)
+		So(b2.Pairs, ShouldEqual, b.Pairs+1)
+
+		names, err := (&dirTarget{dir: target}).List("test/")
+		So(err, ShouldBeNil)
+		So(names, ShouldResemble, []string{BackupName("test", 1), BackupName("test", 2)})
+		data, err := ioutil.ReadFile(filepath.Join(target, BackupName("test", 1)))
+		So(err, ShouldBeNil)
+		So(strings.Contains(string(data), "Herbert"), ShouldBeFalse)
+	})
+
+	Convey("it should verify that the backups restore to the chain", t, func() {
+		dt := &dirTarget{dir: target}
+		r, err := s.VerifyBackups(dt, "test", "secret")
+		So(err, ShouldBeNil)
+		So(r.Backups, ShouldEqual, 2)
+		So(r.Seq, ShouldEqual, 2)
+		So(r.Pairs, ShouldEqual, len(h.chain.Headers))
+		So(r.Top, ShouldEqual, h.chain.Hashes[len(h.chain.Hashes)-1].String())
+
+		_, err = s.VerifyBackups(dt, "test", "wrong")
+		So(err, ShouldNotBeNil)
+		_, err = s.VerifyBackups(dt, "other", "secret")
+		So(err, ShouldEqual, ErrNoBackups)
+	})
+
+	Convey("it should make a full backup when the chain's file is rewritten", t, func() {
+		data, err := ioutil.ReadFile(filepath.Join(h.path, BackupStateFileName))
+		So(err, ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(h.path, BackupStateFileName), []byte(strings.Replace(string(data), `"Tail":"`, `"Tail":"x`, 1)), 0600), ShouldBeNil)
+		b, err := s.Backup(h, "secret")
+		So(err, ShouldBeNil)
+		So(b.Seq, ShouldEqual, 3)
+		So(b.Offset, ShouldEqual, 0)
+		r, err := s.VerifyBackups(&dirTarget{dir: target}, "test", "secret")
+		So(err, ShouldBeNil)
+		So(r.Backups, ShouldEqual, 1)
+	})
+
+	Convey("it should restore a chain into the service", t, func() {
+		dt := &dirTarget{dir: target}
+		_, err := s.Restore(dt, "test", "secret")
+		So(err, ShouldNotBeNil)
+
+		pairs := len(h.chain.Headers)
+		So(os.RemoveAll(h.path), ShouldBeNil)
+		r, err := s.Restore(dt, "test", "secret")
+		So(err, ShouldBeNil)
+		So(r.Pairs, ShouldEqual, pairs)
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(len(h2.chain.Headers), ShouldEqual, pairs)
+	})
+
+	Convey("it should not leave the agent's files behind when a restore fails", t, func() {
+		dt := &dirTarget{dir: target}
+		data, err := dt.Get(BackupName("test", 3))
+		So(err, ShouldBeNil)
+		b, err := openBackup(data, "secret")
+		So(err, ShouldBeNil)
+		b.Seq = 4
+		b.Top = "not the top"
+		data, err = sealBackup(b, "secret")
+		So(err, ShouldBeNil)
+		So(dt.Put(BackupName("test", 4), data), ShouldBeNil)
+		defer os.Remove(filepath.Join(target, BackupName("test", 4)))
+
+		d2, s2 := setupTestService()
+		defer cleanupTestDir(d2)
+		So(os.Remove(filepath.Join(s2.ConfigPath, AgentFileName)), ShouldBeNil)
+		So(os.Remove(filepath.Join(s2.ConfigPath, PrivKeyFileName)), ShouldBeNil)
+		_, err = s2.Restore(dt, "test", "secret")
+		So(err, ShouldNotBeNil)
+		So(dirExists(filepath.Join(s2.Path, "test")), ShouldBeFalse)
+		So(fileExists(filepath.Join(s2.ConfigPath, AgentFileName)), ShouldBeFalse)
+		So(fileExists(filepath.Join(s2.ConfigPath, PrivKeyFileName)), ShouldBeFalse)
+	})
+
+	Convey("it should stop backing up when the chain is no longer backed up", t, func() {
+		done := make(chan struct{})
+		go func() {
+			s.BackupEvery(h, 10*time.Millisecond, "secret")
+			close(done)
+		}()
+		So(h.SetBackupConfig(nil), ShouldBeNil)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("still backing up")
+		}
+		So(h.SetBackupConfig(&BackupConfig{Target: target}), ShouldBeNil)
+	})
+
+	Convey("it should stop backing up when the chain shuts down", t, func() {
+		done := make(chan struct{})
+		go func() {
+			s.BackupEvery(h, time.Hour, "secret")
+			close(done)
+		}()
+		close(h.stop)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("still backing up")
+		}
+		h.stop = make(chan struct{})
+	})
+
+	Convey("it should not restore over a service with a different agent", t, func() {
+		d2, s2 := setupTestService()
+		defer cleanupTestDir(d2)
+		_, err := s2.Restore(&dirTarget{dir: target}, "test", "secret")
+		So(err, ShouldNotBeNil)
+		So(dirExists(filepath.Join(s2.Path, "test")), ShouldBeFalse)
+	})
+}
+
+func TestS3BackupTarget(t *testing.T) {
+	var lock sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") || r.Header.Get("x-amz-content-sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == "PUT":
+			objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+		case r.URL.Query().Get("list-type") == "2":
+			var keys []string
+			prefix := strings.TrimPrefix(r.URL.Path, "/") + r.URL.Query().Get("prefix")
+			for k := range objects {
+				if strings.HasPrefix(strings.TrimPrefix(k, "/"), prefix) {
+					keys = append(keys, strings.TrimPrefix(k, "/bucket/"))
+				}
+			}
+			sort.Strings(keys)
+			w.Write([]byte("<ListBucketResult>"))
+			for _, k := range keys {
+				w.Write([]byte("<Contents><Key>" + k + "</Key></Contents>"))
+			}
+			w.Write([]byte("<IsTruncated>false</IsTruncated></ListBucketResult>"))
+		default:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	t0, err := NewBackupTarget("s3://bucket/backups?endpoint=" + server.URL)
+	if err != nil {
+		panic(err)
+	}
+	target := t0.(*s3Target)
+
+	Convey("it should need credentials", t, func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		So(target.Put("test/00000001.hcb", []byte("data")), ShouldEqual, ErrNoS3Credentials)
+	})
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	Convey("it should put, get and list backups in the bucket", t, func() {
+		So(target.Put("test/00000001.hcb", []byte("one")), ShouldBeNil)
+		So(target.Put("test/00000002.hcb", []byte("two")), ShouldBeNil)
+		So(target.Put("other/00000001.hcb", []byte("other")), ShouldBeNil)
+		So(string(objects["/bucket/backups/test/00000002.hcb"]), ShouldEqual, "two")
+		data, err := target.Get("test/00000001.hcb")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "one")
+		_, err = target.Get("test/00000003.hcb")
+		So(err, ShouldNotBeNil)
+		names, err := target.List("test/")
+		So(err, ShouldBeNil)
+		So(names, ShouldResemble, []string{"test/00000001.hcb", "test/00000002.hcb"})
+	})
+}
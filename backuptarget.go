@@ -0,0 +1,351 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// backuptarget implements the places backups are kept: a directory, which may be a mounted
+// network or removable drive, a bucket of an S3 compatible object store, reached with the
+// minio client using credentials from the usual AWS environment variables, or a directory on
+// an SFTP server, reached over ssh with the user's agent or keys and checked against their
+// known hosts.
+
+package holochain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultS3Endpoint = "https://s3.amazonaws.com"
+	DefaultS3Region   = "us-east-1"
+)
+
+var ErrNoS3Credentials error = errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3 backups")
+
+// BackupTarget is a place backups are kept, by name
+type BackupTarget interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List(prefix string) ([]string, error) // names starting with the prefix
+}
+
+// NewBackupTarget returns the backup target for a target given as a directory, an
+// s3://bucket/prefix url, optionally with endpoint and region query parameters, or an
+// sftp://user@host:port/path url
+func NewBackupTarget(target string) (t BackupTarget, err error) {
+	if target == "" {
+		err = errors.New("backup target must be given")
+		return
+	}
+	if !strings.Contains(target, "://") {
+		t = &dirTarget{dir: target}
+		return
+	}
+	var u *url.URL
+	if u, err = url.Parse(target); err != nil {
+		return
+	}
+	switch u.Scheme {
+	case "file":
+		t = &dirTarget{dir: u.Path}
+	case "s3":
+		q := u.Query()
+		s := &s3Target{bucket: u.Host, prefix: strings.Trim(u.Path, "/"), endpoint: q.Get("endpoint"), region: q.Get("region")}
+		if s.bucket == "" {
+			err = errors.New("s3 backup target must name a bucket")
+			return
+		}
+		if s.endpoint == "" {
+			s.endpoint = DefaultS3Endpoint
+		}
+		if s.region == "" {
+			s.region = DefaultS3Region
+		}
+		t = s
+	case "sftp":
+		if u.Host == "" {
+			err = errors.New("sftp backup target must name a host")
+			return
+		}
+		t = &sftpTarget{u: u}
+	default:
+		err = fmt.Errorf("unknown backup target: %s", target)
+	}
+	return
+}
+
+// dirTarget keeps backups in a directory
+type dirTarget struct {
+	dir string
+}
+
+func (d *dirTarget) Put(name string, data []byte) (err error) {
+	p := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return
+	}
+	// written under a temporary name so a partly written backup is never listed
+	tmp := p + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	err = os.Rename(tmp, p)
+	return
+}
+
+func (d *dirTarget) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(d.dir, filepath.FromSlash(name)))
+}
+
+func (d *dirTarget) List(prefix string) (names []string, err error) {
+	if !dirExists(d.dir) {
+		return
+	}
+	err = filepath.Walk(d.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(d.dir, p)
+		if err == nil && strings.HasPrefix(filepath.ToSlash(rel), prefix) {
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	sort.Strings(names)
+	return
+}
+
+// s3Target keeps backups in a bucket of an S3 compatible object store
+type s3Target struct {
+	endpoint string
+	region   string
+	bucket   string
+	prefix   string
+}
+
+// key returns the object key of a backup
+func (s *s3Target) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// client returns a client of the object store, with credentials from the environment
+func (s *s3Target) client() (client *minio.Client, err error) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		err = ErrNoS3Credentials
+		return
+	}
+	var u *url.URL
+	if u, err = url.Parse(s.endpoint); err != nil {
+		return
+	}
+	creds := credentials.NewStaticV4(id, secret, os.Getenv("AWS_SESSION_TOKEN"))
+	client, err = minio.NewWithCredentials(u.Host, creds, u.Scheme != "http", s.region)
+	return
+}
+
+func (s *s3Target) Put(name string, data []byte) (err error) {
+	var client *minio.Client
+	if client, err = s.client(); err != nil {
+		return
+	}
+	_, err = client.PutObject(s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return
+}
+
+func (s *s3Target) Get(name string) (data []byte, err error) {
+	var client *minio.Client
+	if client, err = s.client(); err != nil {
+		return
+	}
+	var o *minio.Object
+	if o, err = client.GetObject(s.bucket, s.key(name), minio.GetObjectOptions{}); err != nil {
+		return
+	}
+	defer o.Close()
+	data, err = ioutil.ReadAll(o)
+	return
+}
+
+func (s *s3Target) List(prefix string) (names []string, err error) {
+	var client *minio.Client
+	if client, err = s.client(); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	for o := range client.ListObjectsV2(s.bucket, s.key(prefix), true, done) {
+		if o.Err != nil {
+			err = o.Err
+			return
+		}
+		name := o.Key
+		if s.prefix != "" {
+			name = strings.TrimPrefix(name, s.prefix+"/")
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// sftpTarget keeps backups in a directory on an SFTP server
+type sftpTarget struct {
+	u *url.URL
+}
+
+// sshSigners returns the keys to offer the server: those held by the ssh agent, if one is
+// running, and the user's default keys that aren't protected by a passphrase
+func sshSigners() (signers []ssh.Signer, err error) {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, e := net.Dial("unix", sock); e == nil {
+			if signers, err = agent.NewClient(conn).Signers(); err != nil {
+				return
+			}
+		}
+	}
+	var home string
+	if home, err = homeDir(); err != nil {
+		return
+	}
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, e := ioutil.ReadFile(filepath.Join(home, ".ssh", name))
+		if e != nil {
+			continue
+		}
+		if signer, e := ssh.ParsePrivateKey(data); e == nil {
+			signers = append(signers, signer)
+		}
+	}
+	return
+}
+
+// connect opens an SFTP session with the server, which must be in the user's known hosts
+func (s *sftpTarget) connect() (conn *ssh.Client, client *sftp.Client, err error) {
+	var home string
+	if home, err = homeDir(); err != nil {
+		return
+	}
+	config := &ssh.ClientConfig{Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(sshSigners)}, Timeout: 30 * time.Second}
+	if config.HostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts")); err != nil {
+		return
+	}
+	if s.u.User != nil {
+		config.User = s.u.User.Username()
+	} else {
+		var u *user.User
+		if u, err = user.Current(); err != nil {
+			return
+		}
+		config.User = u.Username
+	}
+	host := s.u.Host
+	if s.u.Port() == "" {
+		host = net.JoinHostPort(host, "22")
+	}
+	if conn, err = ssh.Dial("tcp", host, config); err != nil {
+		return
+	}
+	if client, err = sftp.NewClient(conn); err != nil {
+		conn.Close()
+	}
+	return
+}
+
+// do runs a function with an SFTP session with the server
+func (s *sftpTarget) do(fn func(client *sftp.Client) error) (err error) {
+	var conn *ssh.Client
+	var client *sftp.Client
+	if conn, client, err = s.connect(); err != nil {
+		err = fmt.Errorf("sftp: %v", err)
+		return
+	}
+	defer conn.Close()
+	defer client.Close()
+	err = fn(client)
+	return
+}
+
+func (s *sftpTarget) remote(name string) string {
+	return path.Join(s.u.Path, name)
+}
+
+func (s *sftpTarget) Put(name string, data []byte) (err error) {
+	err = s.do(func(client *sftp.Client) (err error) {
+		p := s.remote(name)
+		if err = client.MkdirAll(path.Dir(p)); err != nil {
+			return
+		}
+		// written under a temporary name so a partly written backup is never listed
+		tmp := p + ".tmp"
+		var f *sftp.File
+		if f, err = client.Create(tmp); err != nil {
+			return
+		}
+		_, err = f.Write(data)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return
+		}
+		err = client.PosixRename(tmp, p)
+		return
+	})
+	return
+}
+
+func (s *sftpTarget) Get(name string) (data []byte, err error) {
+	err = s.do(func(client *sftp.Client) (err error) {
+		var f *sftp.File
+		if f, err = client.Open(s.remote(name)); err != nil {
+			return
+		}
+		defer f.Close()
+		data, err = ioutil.ReadAll(f)
+		return
+	})
+	return
+}
+
+func (s *sftpTarget) List(prefix string) (names []string, err error) {
+	// backups are named chain/number, so the directory holding the prefix is listed
+	dir := path.Dir(prefix + "x")
+	err = s.do(func(client *sftp.Client) (err error) {
+		var infos []os.FileInfo
+		if infos, err = client.ReadDir(s.remote(dir)); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+			}
+			return
+		}
+		for _, info := range infos {
+			if name := path.Join(dir, info.Name()); !info.IsDir() && strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, ".tmp") {
+				names = append(names, name)
+			}
+		}
+		return
+	})
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,315 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// bridge implements read only access by a chain's zome code to the DHT store of another chain
+// run by the same service, and the witnessing of statements for chains using it as a notary.
+// The reading chain asks the bridged chain's running node over its web API, at the address or
+// unix socket hc serve records for it, authorized by the service's admin token, so each chain's
+// stores are only ever opened by the process serving it.  The bridged chain checks that it
+// grants the reading chain access, and reads its local store without running any of its zome
+// code.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BridgeFromHeader = "X-Holochain-Bridge-From" // name of the chain a bridge request comes from
+	BridgeDNAHeader  = "X-Holochain-Bridge-DNA"  // and its DNA hash
+
+	bridgeTimeout  = 10 * time.Second
+	maxBridgeReply = 16 * 1024 * 1024
+)
+
+var ErrBridgeNotGranted error = errors.New("bridge not granted")
+var ErrBridgeInValidation error = errors.New("bridged chains can't be read during validation")
+var ErrNoBridgeService error = errors.New("chain wasn't loaded by a service so can't bridge")
+var ErrBridgeNotServed error = errors.New("bridged chain isn't being served")
+
+// BridgeGrant allows another chain to read entries from this chain's DHT store
+type BridgeGrant struct {
+	From    string   // name or DNA hash of the chain allowed to read
+	Entries []string `toml:",omitempty"` // entry types it may read, or all if empty
+}
+
+// allows returns true if the grant covers entries of the type
+func (g *BridgeGrant) allows(entryType string) bool {
+	if len(g.Entries) == 0 {
+		return true
+	}
+	for _, t := range g.Entries {
+		if t == entryType {
+			return true
+		}
+	}
+	return false
+}
+
+// BridgeReader identifies the chain reading over a bridge
+type BridgeReader struct {
+	Name    string
+	DNAHash string
+}
+
+// bridgeGetReply is what the bridged chain sends back for a get
+type bridgeGetReply struct {
+	EntryType string
+	C         interface{}
+}
+
+// bridgeMetaReply is what the bridged chain sends back for each entry a query finds
+type bridgeMetaReply struct {
+	H string
+	C interface{}
+}
+
+// bridgeServing returns where the web API of a chain run by the service is being served, found
+// from its config and the record hc serve keeps without opening any of its stores
+func (h *Holochain) bridgeServing(chain string) (s Serving, err error) {
+	if h.service == nil {
+		err = ErrNoBridgeService
+		return
+	}
+	path := filepath.Join(h.service.Path, chain)
+	var format string
+	if format, err = findEncodedFile(path, ConfigFileName); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(filepath.Join(path, ConfigFileName+"."+format)); err != nil {
+		return
+	}
+	defer f.Close()
+	var config Config
+	if err = Decode(f, format, &config); err != nil {
+		return
+	}
+	if config.Disabled {
+		err = ErrChainDisabled
+		return
+	}
+	if s, err = readServing(path); err == ErrNotServing {
+		err = ErrBridgeNotServed
+	}
+	return
+}
+
+// bridgeRequest asks a bridged chain's node for what is at path, decoding its JSON reply.  If
+// body isn't nil it is posted as JSON.
+func (h *Holochain) bridgeRequest(chain string, path string, body interface{}, reply interface{}) (err error) {
+	var serving Serving
+	if serving, err = h.bridgeServing(chain); err != nil {
+		return
+	}
+	base := serving.URL
+	var token string
+	if token, err = h.service.AdminToken(); err != nil {
+		return
+	}
+	var req *http.Request
+	if body == nil {
+		req, err = http.NewRequest("GET", base+path, nil)
+	} else {
+		var b []byte
+		if b, err = json.Marshal(body); err != nil {
+			return
+		}
+		if req, err = http.NewRequest("POST", base+path, bytes.NewReader(b)); err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(BridgeFromHeader, filepath.Base(h.path))
+	if h.dnaHash.H != nil {
+		req.Header.Set(BridgeDNAHeader, h.dnaHash.String())
+	}
+	client := serving.Client(bridgeTimeout)
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		err = fmt.Errorf("%v: %v", ErrBridgeNotServed, err)
+		return
+	}
+	defer resp.Body.Close()
+	var b []byte
+	if b, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxBridgeReply)); err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = knownError(strings.TrimSpace(string(b)))
+		return
+	}
+	err = json.Unmarshal(b, reply)
+	return
+}
+
+// bridgeGrant returns the grant of the chain to the reading chain, if it has one
+func (h *Holochain) bridgeGrant(reader BridgeReader) *BridgeGrant {
+	grants := h.Config().BridgeGrants
+	for i := range grants {
+		g := &grants[i]
+		if g.From == reader.Name || (reader.DNAHash != "" && g.From == reader.DNAHash) {
+			return g
+		}
+	}
+	return nil
+}
+
+// BridgeGet returns an entry held in the DHT store of a bridged chain
+func (h *Holochain) BridgeGet(chain string, key Hash) (entry *GobEntry, entryType string, err error) {
+	var r bridgeGetReply
+	if err = h.bridgeRequest(chain, "/_bridge/get/"+key.String(), nil, &r); err != nil {
+		return
+	}
+	entry, entryType = &GobEntry{C: r.C}, r.EntryType
+	return
+}
+
+// BridgeQuery returns the entries linked to an entry in the DHT store of a bridged chain
+func (h *Holochain) BridgeQuery(chain string, query MetaQuery) (entries []MetaEntry, err error) {
+	var r []bridgeMetaReply
+	if err = h.bridgeRequest(chain, "/_bridge/query/"+query.H.String()+"?tag="+url.QueryEscape(query.T), nil, &r); err != nil {
+		return
+	}
+	for _, m := range r {
+		entries = append(entries, MetaEntry{H: m.H, E: &GobEntry{C: m.C}})
+	}
+	return
+}
+
+// bridgedGet returns an entry in this chain's DHT store to a reading chain it grants access to
+func (h *Holochain) bridgedGet(reader BridgeReader, key Hash) (entry *GobEntry, entryType string, err error) {
+	g := h.bridgeGrant(reader)
+	if g == nil {
+		err = ErrBridgeNotGranted
+		return
+	}
+	var data []byte
+	var status int
+	if data, entryType, status, err = h.dht.get(key); err != nil {
+		return
+	}
+	if status == REDACTED {
+		err = ErrEntryRedacted
+		return
+	}
+	if status == QUARANTINED {
+		err = ErrEntryQuarantined
+		return
+	}
+	if status != LIVE {
+		err = ErrHashNotFound
+		return
+	}
+	if !g.allows(entryType) {
+		err = fmt.Errorf("%v for entries of type %s", ErrBridgeNotGranted, entryType)
+		return
+	}
+	var e GobEntry
+	if err = e.Unmarshal(data); err != nil {
+		return
+	}
+	entry = &e
+	return
+}
+
+// bridgedQuery returns the entries linked to an entry in this chain's DHT store to a reading
+// chain it grants access to
+func (h *Holochain) bridgedQuery(reader BridgeReader, query MetaQuery) (entries []MetaEntry, err error) {
+	g := h.bridgeGrant(reader)
+	if g == nil {
+		err = ErrBridgeNotGranted
+		return
+	}
+	var entryType string
+	var status int
+	if _, entryType, status, err = h.dht.get(query.H); err != nil {
+		return
+	}
+	if status != LIVE {
+		err = ErrHashNotFound
+		return
+	}
+	if !g.allows(entryType) {
+		err = fmt.Errorf("%v for entries of type %s", ErrBridgeNotGranted, entryType)
+		return
+	}
+	entries, err = h.dht.getMeta(query.H, query.T)
+	return
+}
+
+// ServeBridge handles the bridge requests of other chains run by the service, which must
+// carry the service's admin token
+func (h *Holochain) ServeBridge(w http.ResponseWriter, r *http.Request) {
+	if h.service == nil || !h.service.CheckAdminToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		http.Error(w, "admin token required", http.StatusUnauthorized)
+		return
+	}
+	reader := BridgeReader{Name: r.Header.Get(BridgeFromHeader), DNAHash: r.Header.Get(BridgeDNAHeader)}
+	var reply interface{}
+	var err error
+	var key Hash
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/_bridge/get/"):
+		if key, err = NewHash(strings.TrimPrefix(r.URL.Path, "/_bridge/get/")); err == nil {
+			var entry *GobEntry
+			var entryType string
+			if entry, entryType, err = h.bridgedGet(reader, key); err == nil {
+				reply = bridgeGetReply{EntryType: entryType, C: entry.C}
+			}
+		}
+	case strings.HasPrefix(r.URL.Path, "/_bridge/query/"):
+		if key, err = NewHash(strings.TrimPrefix(r.URL.Path, "/_bridge/query/")); err == nil {
+			var entries []MetaEntry
+			if entries, err = h.bridgedQuery(reader, MetaQuery{H: key, T: r.URL.Query().Get("tag")}); err == nil {
+				metas := []bridgeMetaReply{}
+				for _, m := range entries {
+					metas = append(metas, bridgeMetaReply{H: m.H, C: m.E.Content()})
+				}
+				reply = metas
+			}
+		}
+	case r.URL.Path == "/_bridge/witness" && r.Method == "POST":
+		var st NotaryStatement
+		if err = json.NewDecoder(io.LimitReader(r.Body, maxBridgeReply)).Decode(&st); err == nil {
+			var hash Hash
+			if hash, err = h.bridgedWitness(reader, &st); err == nil {
+				reply = bridgeWitnessReply{Hash: hash.String()}
+			}
+		}
+	case strings.HasPrefix(r.URL.Path, "/_bridge/witness/"):
+		if key, err = NewHash(strings.TrimPrefix(r.URL.Path, "/_bridge/witness/")); err == nil {
+			reply, err = h.bridgedWitnessed(reader, key)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), StatusCode(err))
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(reply); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
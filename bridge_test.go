@@ -0,0 +1,120 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// serveBridge serves a chain's bridge requests, recording where as hc serve does
+func serveBridge(h *Holochain) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(h.ServeBridge))
+	if err := h.SetServing(Serving{URL: ts.URL}); err != nil {
+		panic(err)
+	}
+	return ts
+}
+
+// serveBridgeSocket serves a chain's bridge requests under a base path over a unix socket in
+// its directory, recording where as hc serve --socket does
+func serveBridgeSocket(h *Holochain) net.Listener {
+	socket := filepath.Join(h.path, "bridge.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/app/", http.StripPrefix("/app", http.HandlerFunc(h.ServeBridge)))
+	go http.Serve(l, mux)
+	if err = h.SetServing(Serving{URL: "http://unix/app", Socket: socket}); err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func TestBridge(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+	for _, n := range []string{"reader", "target", "other"} {
+		if _, err := s.GenDev(filepath.Join(s.Path, n), "toml"); err != nil {
+			panic(err)
+		}
+	}
+	target, err := s.Load("target")
+	if err != nil {
+		panic(err)
+	}
+	target.config.BridgeGrants = []BridgeGrant{{From: "reader", Entries: []string{"myData"}}}
+	ts := serveBridge(target)
+	defer ts.Close()
+	other, err := s.Load("other")
+	if err != nil {
+		panic(err)
+	}
+	l := serveBridgeSocket(other)
+	defer l.Close()
+
+	e := GobEntry{C: "some data"}
+	b, _ := e.Marshal()
+	key, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	secret, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	link, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+	target.dht.put(nil, "myData", key, target.id, b, LIVE)
+	target.dht.put(nil, "secret", secret, target.id, b, LIVE)
+	target.dht.putMeta(nil, key, link, "tag", &GobEntry{C: "linked"})
+
+	reader, err := s.Load("reader")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should read granted entries from the bridged chain's node", t, func() {
+		entry, entryType, err := reader.BridgeGet("target", key)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "myData")
+		So(entry.C, ShouldEqual, "some data")
+		entries, err := reader.BridgeQuery("target", MetaQuery{H: key, T: "tag"})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].H, ShouldEqual, link.String())
+		So(entries[0].E.Content(), ShouldEqual, "linked")
+	})
+
+	Convey("it should refuse entry types that weren't granted", t, func() {
+		_, _, err := reader.BridgeGet("target", secret)
+		So(err.Error(), ShouldEqual, ErrBridgeNotGranted.Error()+" for entries of type secret")
+		_, err = reader.BridgeQuery("target", MetaQuery{H: secret, T: "tag"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should refuse chains that haven't granted a bridge", t, func() {
+		// other is served over a unix socket, so this also shows the request reaching it there
+		_, _, err := reader.BridgeGet("other", key)
+		So(err, ShouldEqual, ErrBridgeNotGranted)
+	})
+
+	Convey("it should need the bridged chain to be served", t, func() {
+		_, _, err := target.BridgeGet("reader", key)
+		So(err, ShouldEqual, ErrBridgeNotServed)
+		So(other.ClearServing(), ShouldBeNil)
+		_, _, err = reader.BridgeGet("other", key)
+		So(err, ShouldEqual, ErrBridgeNotServed)
+	})
+
+	Convey("it should only answer requests with the admin token", t, func() {
+		resp, err := http.Get(ts.URL + "/_bridge/get/" + key.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+	})
+
+	Convey("it should need chains to have been loaded by a service", t, func() {
+		h := Holochain{}
+		_, _, err := h.BridgeGet("target", key)
+		So(err, ShouldEqual, ErrNoBridgeService)
+		_, _, err = reader.BridgeGet("missing", key)
+		So(err, ShouldNotBeNil)
+	})
+}
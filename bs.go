@@ -11,10 +11,11 @@ import (
 	"encoding/json"
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
-	pstore "github.com/libp2p/go-libp2p-peerstore"
 	ma "github.com/multiformats/go-multiaddr"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 )
 
 type BSReq struct {
@@ -28,10 +29,21 @@ type BSResp struct {
 	Remote string
 }
 
+// bootstrap registers the node with the bootstrap server and adds the peers it knows of,
+// logging rather than returning errors as the node can carry on without it
+func (h *Holochain) bootstrap() {
+	if e := h.BSpost(); e != nil {
+		h.dht.dlog.Logf("error in BSpost: %s", e.Error())
+	}
+	if e := h.BSget(); e != nil {
+		h.dht.dlog.Logf("error in BSget: %s", e.Error())
+	}
+}
+
 func (h *Holochain) BSpost() (err error) {
 	nodeID := peer.IDB58Encode(h.node.HashAddr)
 	req := BSReq{Version: 1, NodeID: nodeID, NodeAddr: h.node.NetAddr.String()}
-	host := h.config.BootstrapServer
+	host := h.Config().BootstrapServer
 	id := h.DNAHash()
 	url := fmt.Sprintf("http://%s/%s/%s", host, id.String(), nodeID)
 	var b []byte
@@ -44,7 +56,7 @@ func (h *Holochain) BSpost() (err error) {
 }
 
 func (h *Holochain) BSget() (err error) {
-	host := h.config.BootstrapServer
+	host := h.Config().BootstrapServer
 	if host == "" {
 		return
 	}
@@ -70,8 +82,9 @@ func (h *Holochain) BSget() (err error) {
 						if err == nil {
 							if myNodeID != r.Req.NodeID {
 								h.dht.dlog.Logf("discovered peer: %s", r.Req.NodeID)
-								h.node.Host.Peerstore().AddAddr(id, addr, pstore.PermanentAddrTTL)
-								err = h.dht.UpdateGossiper(id, 0)
+								if err = h.dht.AddPeerAddr(id, addr); err == nil {
+									err = h.dht.UpdateGossiper(id, 0)
+								}
 
 							}
 
@@ -84,3 +97,43 @@ func (h *Holochain) BSget() (err error) {
 	}
 	return
 }
+
+// BootstrapServer is an in-memory bootstrap server speaking the same protocol as bs, for
+// running local networks of nodes such as sandboxes without a separate server
+type BootstrapServer struct {
+	lk    sync.Mutex
+	nodes map[string]map[string]BSResp // nodes of each chain by node id
+}
+
+// NewBootstrapServer returns an empty in-memory bootstrap server
+func NewBootstrapServer() *BootstrapServer {
+	return &BootstrapServer{nodes: make(map[string]map[string]BSResp)}
+}
+
+// ServeHTTP registers nodes posted to /<chain>/<node> and lists them on gets of /<chain>
+func (b *BootstrapServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	switch {
+	case r.Method == "GET" && len(path) == 1:
+		nodes := make([]BSResp, 0)
+		for _, n := range b.nodes[path[0]] {
+			nodes = append(nodes, n)
+		}
+		json.NewEncoder(w).Encode(nodes)
+	case r.Method == "POST" && len(path) == 2:
+		var req BSReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if b.nodes[path[0]] == nil {
+			b.nodes[path[0]] = make(map[string]BSResp)
+		}
+		b.nodes[path[0]][path[1]] = BSResp{Req: req, Remote: r.RemoteAddr}
+		fmt.Fprint(w, "ok")
+	default:
+		http.Error(w, "expecting GET /<holochainid> or POST /<holochainid>/<peerid>", 400)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// caller implements telling zome code who is calling it, so that apps can authorize calls
+// per user.  Calls made by the chain's own agent, e.g. from the command line or a client using
+// the admin token, are from "self"; calls made with an API token are from that token, named
+// by the name it was issued with; and calls made because of another node, i.e. approving its
+// countersigning proposal or receiving an entry it put, are from that node's agent.  Zome
+// code gets the caller with caller().
+
+package holochain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	CallerSelf  = "self"  // the chain's own agent
+	CallerToken = "token" // a client holding an API token
+	CallerAgent = "agent" // the agent of another node
+)
+
+var ErrCallerInValidation error = errors.New("caller isn't known during validation")
+
+// Caller identifies who a zome function is being called by
+type Caller struct {
+	Kind string
+	ID   string `json:",omitempty"` // the token's name or fingerprint, or the remote agent's node id
+}
+
+// TokenFingerprint identifies a token without revealing it, for tokens issued without a name
+func TokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// tokenCaller returns the caller of calls made with an API token
+func tokenCaller(t *APIToken) *Caller {
+	id := t.Name
+	if id == "" {
+		id = TokenFingerprint(t.Token)
+	}
+	return &Caller{Kind: CallerToken, ID: id}
+}
+
+// WithCaller returns a copy of the holochain whose zome calls are made on behalf of the
+// agent of another node
+func (h *Holochain) WithCaller(agent peer.ID) *Holochain {
+	x := *h
+	x.caller = &Caller{Kind: CallerAgent, ID: peer.IDB58Encode(agent)}
+	return &x
+}
+
+// Caller returns who the holochain's zome calls are being made by
+func (h *Holochain) Caller() Caller {
+	if h.caller == nil {
+		return Caller{Kind: CallerSelf}
+	}
+	return *h.caller
+}
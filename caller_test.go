@@ -0,0 +1,27 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCaller(t *testing.T) {
+	d, s, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("calls should be from self unless made with a token", t, func() {
+		So(h.Caller(), ShouldResemble, Caller{Kind: CallerSelf})
+	})
+
+	Convey("calls made with a token should be from its name, or its fingerprint if unnamed", t, func() {
+		tk, err := s.IssueNamedToken("test", "alice", "", []string{"*"}, nil)
+		So(err, ShouldBeNil)
+		So(h.WithScope(&tk).Caller(), ShouldResemble, Caller{Kind: CallerToken, ID: "alice"})
+
+		tk, _ = s.IssueToken("test", "", []string{"*"}, nil)
+		c := h.WithScope(&tk).Caller()
+		So(c.ID, ShouldEqual, TokenFingerprint(tk.Token))
+		So(len(c.ID), ShouldEqual, 16)
+		So(h.Caller().Kind, ShouldEqual, CallerSelf)
+	})
+}
@@ -14,6 +14,7 @@ import (
 	ic "github.com/libp2p/go-libp2p-crypto"
 	"io"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -33,7 +34,13 @@ type Chain struct {
 
 	//---
 
-	s *os.File // if this stream is not nil, new entries will get marshaled to it
+	s      *os.File    // if this stream is not nil, new entries will get marshaled to it
+	r      *os.File    // the chain's file as loaded, which stored entries are read from
+	crypt  *chainCrypt // if not nil, entries are encrypted when marshaled to the stream
+	fsync  string      // when writes to the stream are forced to disk
+	lk     sync.Mutex  // held while writing to the stream
+	refuse error       // if set, the error new entries are refused with
+	prefs  sync.Mutex  // keeps concurrent changes to the preferences on the chain from overwriting each other
 }
 
 // NewChain creates and empty chain
@@ -53,36 +60,56 @@ func NewChain() (chain *Chain) {
 // Creates a chain from a file, loading any data there, and setting it to be persisted to
 // if no file exists it will be created
 func NewChainFromFile(h HashSpec, path string) (c *Chain, err error) {
+	if isEncryptedChainFile(path) {
+		err = ErrNoPassphrase
+		return
+	}
+	c, err = loadChainFile(h, path, nil)
+	return
+}
+
+// loadChainFile loads a chain from a file, decrypting it if crypt isn't nil
+func loadChainFile(h HashSpec, path string, crypt *chainCrypt) (c *Chain, err error) {
 	defer func() {
 		if err != nil {
 			Debugf("error loading chain :%s", err.Error())
 		}
 	}()
 	c = NewChain()
+	c.crypt = crypt
+	c.fsync = FsyncAlways
+
+	if _, err = recoverWAL(path); err != nil {
+		return
+	}
 
 	var f *os.File
 	if fileExists(path) {
-		f, err = os.Open(path)
-		if err != nil {
+		// the headers are kept in memory but the entries are left in the file until used
+		if c.r, err = os.Open(path); err != nil {
 			return
 		}
-		var i int
-		for {
-			var header *Header
-			var e Entry
-			header, e, err = readPair(f)
-			if err != nil && err.Error() == "EOF" {
-				err = nil
-				break
-			}
-			if err != nil {
-				Debugf("error reading pair:%s", err.Error())
-				return
-			}
-			c.addPair(header, e, i)
+		var end int64
+		i := 0
+		end, err = scanChainFile(c.r, crypt, true, func(at int64, entryAt int64, hd *Header) {
+			c.addPair(hd, &storedEntry{f: c.r, crypt: crypt, at: entryAt}, i)
 			i++
+		})
+		if err != nil {
+			Debugf("error reading pair:%s", err.Error())
+			c.r.Close()
+			return
+		}
+		var info os.FileInfo
+		if info, err = c.r.Stat(); err != nil {
+			c.r.Close()
+			return
+		}
+		if end != info.Size() {
+			err = fmt.Errorf("chain file has %d bytes of a partly written pair at its end", info.Size()-end)
+			c.r.Close()
+			return
 		}
-		f.Close()
 		i--
 		// if we read anything then we have to calculate the final hash and add it
 		if i >= 0 {
@@ -97,13 +124,6 @@ func NewChainFromFile(h HashSpec, path string) (c *Chain, err error) {
 
 			c.Hashes = append(c.Hashes, hash)
 			c.Hmap[hash.String()] = i
-
-			// finally validate that it all hashes out correctly
-			/*			err = c.Validate(h)
-						if err != nil {
-							return
-						}
-			*/
 		}
 
 		f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
@@ -180,7 +200,13 @@ func (c *Chain) PrepareHeader(h HashSpec, now time.Time, entryType string, e Ent
 }
 
 func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
 
+	if c.refuse != nil {
+		err = c.refuse
+		return
+	}
 	l := len(c.Hashes)
 	if l != entryIdx {
 		err = errors.New("entry indexes don't match can't create new entry")
@@ -197,7 +223,7 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 	c.Hmap[hash.String()] = entryIdx
 
 	if c.s != nil {
-		err = writePair(c.s, header, &g)
+		err = c.writeDurably(header, &g)
 	}
 
 	return
@@ -214,18 +240,41 @@ func (c *Chain) Get(h Hash) (header *Header, err error) {
 	return
 }
 
-// GetEntry returns the entry of a given entry hash
+// GetEntry returns the entry of a given entry hash, or only its type and ErrEntryPruned if
+// its content has been pruned
 func (c *Chain) GetEntry(h Hash) (entry Entry, entryType string, err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
 	i, ok := c.Emap[h.String()]
 	if ok {
-		entry = c.Entries[i]
 		entryType = c.Headers[i].Type
+		if entry, err = loadEntry(c.Entries[i]); err != nil {
+			return
+		}
+		if isPruned(entry) {
+			entry, err = nil, ErrEntryPruned
+		}
 	} else {
 		err = ErrHashNotFound
 	}
 	return
 }
 
+// getCommitted returns the header, header hash and entry of a given entry hash, holding the
+// chain's lock so that it is safe to call while entries are being added
+func (c *Chain) getCommitted(h Hash) (header *Header, hash Hash, entry Entry, err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	i, ok := c.Emap[h.String()]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	header, hash = c.Headers[i], c.Hashes[i]
+	entry, err = loadEntry(c.Entries[i])
+	return
+}
+
 // GetEntryHeader returns the header of a given entry hash
 func (c *Chain) GetEntryHeader(h Hash) (header *Header, err error) {
 	i, ok := c.Emap[h.String()]
@@ -246,6 +295,23 @@ func writePair(writer io.Writer, header *Header, entry Entry) (err error) {
 	return
 }
 
+// writePair writes a header and entry pair to the chain's file, encrypting it if the chain is
+// encrypted
+func (c *Chain) writePair(writer io.Writer, header *Header, entry Entry) (err error) {
+	if c.crypt != nil {
+		return c.crypt.writePair(writer, header, entry)
+	}
+	return writePair(writer, header, entry)
+}
+
+// readPair reads a header and entry pair from the chain's file
+func (c *Chain) readPair(reader io.Reader) (header *Header, entry Entry, err error) {
+	if c.crypt != nil {
+		return c.crypt.readPair(reader)
+	}
+	return readPair(reader)
+}
+
 func readPair(reader io.Reader) (header *Header, entry Entry, err error) {
 	var hd Header
 	err = UnmarshalHeader(reader, &hd, 34)
@@ -332,9 +398,13 @@ func UnmarshalChain(reader io.Reader) (c *Chain, err error) {
 
 // Walk traverses chain from most recent to first entry calling fn on each one
 func (c *Chain) Walk(fn WalkerFn) (err error) {
-	l := len(c.Headers)
-	for i := l - 1; i >= 0; i-- {
-		err = fn(&c.Hashes[i], c.Headers[i], c.Entries[i])
+	hashes, headers, entries := c.snapshot()
+	for i := len(headers) - 1; i >= 0; i-- {
+		var entry Entry
+		if entry, err = loadEntry(entries[i]); err != nil {
+			return
+		}
+		err = fn(&hashes[i], headers[i], entry)
 		if err != nil {
 			return
 		}
@@ -342,13 +412,30 @@ func (c *Chain) Walk(fn WalkerFn) (err error) {
 	return
 }
 
+// snapshot returns the chain's hashes, headers and entries as they are, holding the lock so
+// that they are consistent with each other.  Entries are only ever appended, and pruning
+// replaces the entries rather than changing them, so the snapshot stays valid once released.
+func (c *Chain) snapshot() (hashes []Hash, headers []*Header, entries []Entry) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.Hashes, c.Headers, c.Entries
+}
+
 // Validate traverses chain confirming the hashes
 // @TODO confirm that TypeLinks are also correct
 // @TODO confirm signatures
 func (c *Chain) Validate(h HashSpec) (err error) {
-	l := len(c.Headers)
+	err = c.validate(h, nil)
+	return
+}
+
+// validate traverses the chain confirming the hashes, allowing the content of entries of the
+// types prunable returns true for to have been pruned
+func (c *Chain) validate(h HashSpec, prunable func(entryType string) bool) (err error) {
+	hashes, headers, entries := c.snapshot()
+	l := len(headers)
 	for i := l - 1; i >= 0; i-- {
-		hd := c.Headers[i]
+		hd := headers[i]
 		var hash Hash
 
 		// hash the header
@@ -359,9 +446,9 @@ func (c *Chain) Validate(h HashSpec) (err error) {
 
 		var nexth Hash
 		if i == l-1 {
-			nexth = c.Hashes[i]
+			nexth = hashes[i]
 		} else {
-			nexth = c.Headers[i+1].HeaderLink
+			nexth = headers[i+1].HeaderLink
 		}
 
 		if !bytes.Equal(hash.H, nexth.H) {
@@ -369,8 +456,19 @@ func (c *Chain) Validate(h HashSpec) (err error) {
 			return
 		}
 
+		var entry Entry
+		if entry, err = loadEntry(entries[i]); err != nil {
+			return
+		}
+
+		// pruned entries only keep their headers, and only entries of types with a retention
+		// policy can be pruned, so a pruned marker can't stand in for any other entry
+		if isPruned(entry) && prunable != nil && prunable(hd.Type) {
+			continue
+		}
+
 		var b []byte
-		b, err = c.Entries[i].Marshal()
+		b, err = entry.Marshal()
 		if err != nil {
 			return
 		}
@@ -393,7 +491,12 @@ func (c *Chain) String() string {
 	r := ""
 	for i := 0; i < l; i++ {
 		r += fmt.Sprintf("Header:%v\n", *c.Headers[i])
-		r += fmt.Sprintf("Entry:%v\n\n", c.Entries[i])
+		e, err := loadEntry(c.Entries[i])
+		if err != nil {
+			r += fmt.Sprintf("Entry error:%v\n\n", err)
+			continue
+		}
+		r += fmt.Sprintf("Entry:%v\n\n", e)
 	}
 	r += "Hashlist:\n"
 	for i := 0; i < len(c.Headers); i++ {
@@ -407,3 +510,37 @@ func (c *Chain) String() string {
 func (c *Chain) Length() int {
 	return len(c.Headers)
 }
+
+// Truncate removes all entries after the header with the given hash, rebuilding the indexes
+// and rewriting the chain's stream if it has one.  It returns the headers that were removed.
+func (c *Chain) Truncate(to Hash) (removed []*Header, err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	i, ok := c.Hmap[to.String()]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	removed = append(removed, c.Headers[i+1:]...)
+	// copied rather than resliced, so that entries added later don't overwrite what snapshots
+	// taken before still hold
+	hashes := append([]Hash{}, c.Hashes[:i+1]...)
+	headers := append([]*Header{}, c.Headers[:i+1]...)
+	entries := append([]Entry{}, c.Entries[:i+1]...)
+	if c.s != nil {
+		if err = c.rewrite(headers, entries); err != nil {
+			return
+		}
+	}
+	c.Hashes, c.Headers, c.Entries = hashes, headers, entries
+
+	c.TypeTops = make(map[string]int)
+	c.Hmap = make(map[string]int)
+	c.Emap = make(map[string]int)
+	for j, hd := range c.Headers {
+		c.TypeTops[hd.Type] = j
+		c.Emap[hd.EntryLink.String()] = j
+		c.Hmap[c.Hashes[j].String()] = j
+	}
+	return
+}
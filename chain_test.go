@@ -5,6 +5,7 @@ import (
 	"fmt"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	. "github.com/smartystreets/goconvey/convey"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -290,3 +291,62 @@ func chainTestSetup() (hs HashSpec, key ic.PrivKey, now time.Time) {
 	hs = hP.hashSpec
 	return
 }
+
+func TestTruncate(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+
+	path := d + "/chain.dat"
+	c, err := NewChainFromFile(h, path)
+	if err != nil {
+		panic(err)
+	}
+	e := GobEntry{C: "some data1"}
+	h1, _ := c.AddEntry(h, now, "myData1", &e, key)
+	dump := c.String()
+	e = GobEntry{C: "some data2"}
+	c.AddEntry(h, now, "myData2", &e, key)
+	e = GobEntry{C: "some data3"}
+	c.AddEntry(h, now, "myData1", &e, key)
+
+	Convey("it should fail for an unknown hash", t, func() {
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err := c.Truncate(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	_, _, before := c.snapshot()
+
+	Convey("it should remove later entries and rebuild the indexes", t, func() {
+		removed, err := c.Truncate(h1)
+		So(err, ShouldBeNil)
+		So(len(removed), ShouldEqual, 2)
+		So(removed[0].Type, ShouldEqual, "myData2")
+		So(c.Length(), ShouldEqual, 1)
+		So(c.String(), ShouldEqual, dump)
+		So(c.TypeTops["myData1"], ShouldEqual, 0)
+		_, ok := c.TypeTops["myData2"]
+		So(ok, ShouldBeFalse)
+		_, _, err = c.GetEntry(removed[1].EntryLink)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should rewrite the chain file", t, func() {
+		e = GobEntry{C: "some data4"}
+		c.AddEntry(h, now, "myData2", &e, key)
+		dump = c.String()
+		c.s.Close()
+		c, err = NewChainFromFile(h, path)
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		So(c.Length(), ShouldEqual, 2)
+		_, err = os.Stat(path + CompactSuffix)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("it should leave snapshots taken before as they were", t, func() {
+		So(len(before), ShouldEqual, 3)
+		So(before[1].Content(), ShouldEqual, "some data2")
+	})
+}
@@ -0,0 +1,228 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// chaincrypt implements optional encryption at rest of the source chain file.  Each header and
+// entry pair is sealed with AES-GCM under a key derived from the agent's passphrase, so that a
+// copy of the file doesn't expose the chain's contents.  The encrypted file starts with a
+// header holding the salt of the key and a sealed check value, which lets a wrong passphrase
+// be detected even before anything has been committed.
+
+package holochain
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	PassphraseEnvVar = "HC_PASSPHRASE" // environment variable consulted for chain passphrases
+
+	chainCryptMagic    = "HCENC01\n"
+	chainCryptCheck    = "holochain"
+	chainKeyIterations = 100000
+	chainSaltLen       = 16
+
+	// maxSealedSize is the size of the largest sealed record of an encrypted chain file, room
+	// for a header and an entry of the default size cap, so that a corrupt length read from
+	// the file can't make reading it allocate without bound
+	maxSealedSize = DefaultMaxEntrySize + messageOverhead
+)
+
+var ErrNoPassphrase error = errors.New("chain is encrypted and no passphrase was given")
+var ErrBadPassphrase error = errors.New("wrong passphrase for encrypted chain")
+
+// chainCrypt holds the cipher of an encrypted chain file and the header the file starts with
+type chainCrypt struct {
+	aead   cipher.AEAD
+	header []byte
+}
+
+// deriveChainKey derives an AES-256 key from a passphrase with PBKDF2-HMAC-SHA256
+func deriveChainKey(passphrase string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, chainKeyIterations, 32, sha256.New)
+}
+
+// newChainCrypt creates the cipher for a passphrase and salt, sealing the check value for the
+// file header if check is nil, or confirming that it opens if not
+func newChainCrypt(passphrase string, salt []byte, check []byte) (c *chainCrypt, err error) {
+	var block cipher.Block
+	block, err = aes.NewCipher(deriveChainKey(passphrase, salt))
+	if err != nil {
+		return
+	}
+	c = &chainCrypt{}
+	c.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	if check == nil {
+		if check, err = c.seal([]byte(chainCryptCheck)); err != nil {
+			return
+		}
+	} else if v, e := c.open(check); e != nil || string(v) != chainCryptCheck {
+		err = ErrBadPassphrase
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(chainCryptMagic)
+	b.Write(salt)
+	binary.Write(&b, binary.LittleEndian, uint32(len(check)))
+	b.Write(check)
+	c.header = b.Bytes()
+	return
+}
+
+func (c *chainCrypt) seal(plain []byte) (sealed []byte, err error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	sealed = c.aead.Seal(nonce, nonce, plain, nil)
+	return
+}
+
+func (c *chainCrypt) open(sealed []byte) (plain []byte, err error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		err = errors.New("encrypted chain record too short")
+		return
+	}
+	plain, err = c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	return
+}
+
+// readSealed reads a length prefixed sealed record
+func readSealed(reader io.Reader) (sealed []byte, err error) {
+	var l uint32
+	if err = binary.Read(reader, binary.LittleEndian, &l); err != nil {
+		return
+	}
+	if l > maxSealedSize {
+		err = fmt.Errorf("encrypted chain record of %d bytes is over the limit of %d", l, maxSealedSize)
+		return
+	}
+	sealed = make([]byte, l)
+	_, err = io.ReadFull(reader, sealed)
+	return
+}
+
+// writePair writes a sealed header and entry pair
+func (c *chainCrypt) writePair(writer io.Writer, header *Header, entry Entry) (err error) {
+	var b bytes.Buffer
+	if err = writePair(&b, header, entry); err != nil {
+		return
+	}
+	var sealed []byte
+	if sealed, err = c.seal(b.Bytes()); err != nil {
+		return
+	}
+	// what can't be read back mustn't be written
+	if len(sealed) > maxSealedSize {
+		err = fmt.Errorf("%v: encrypted chain record of %d bytes is over the limit of %d", ErrEntryTooLarge, len(sealed), maxSealedSize)
+		return
+	}
+	if err = binary.Write(writer, binary.LittleEndian, uint32(len(sealed))); err != nil {
+		return
+	}
+	_, err = writer.Write(sealed)
+	return
+}
+
+// readPair reads and opens a sealed header and entry pair
+func (c *chainCrypt) readPair(reader io.Reader) (header *Header, entry Entry, err error) {
+	var sealed, plain []byte
+	if sealed, err = readSealed(reader); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("encrypted chain record truncated")
+		}
+		return
+	}
+	if plain, err = c.open(sealed); err != nil {
+		return
+	}
+	header, entry, err = readPair(bytes.NewReader(plain))
+	return
+}
+
+// isEncryptedChainFile returns true if the file at path is an encrypted chain
+func isEncryptedChainFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(chainCryptMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == chainCryptMagic
+}
+
+// openChainCrypt reads the header of an encrypted chain file and checks the passphrase
+// against it
+func openChainCrypt(reader io.Reader, passphrase string) (c *chainCrypt, err error) {
+	magic := make([]byte, len(chainCryptMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != chainCryptMagic {
+		err = errors.New("chain file isn't encrypted")
+		return
+	}
+	salt := make([]byte, chainSaltLen)
+	if _, err = io.ReadFull(reader, salt); err != nil {
+		return
+	}
+	var check []byte
+	if check, err = readSealed(reader); err != nil {
+		return
+	}
+	c, err = newChainCrypt(passphrase, salt, check)
+	return
+}
+
+// NewEncryptedChainFromFile is like NewChainFromFile but keeps the chain's headers and entries
+// encrypted in the file with a key derived from passphrase.  An empty file is set up for
+// encryption, but a file already holding an unencrypted chain is refused.
+func NewEncryptedChainFromFile(h HashSpec, path string, passphrase string) (c *Chain, err error) {
+	if passphrase == "" {
+		err = ErrNoPassphrase
+		return
+	}
+	var crypt *chainCrypt
+	if fi, e := os.Stat(path); e != nil || fi.Size() == 0 {
+		salt := make([]byte, chainSaltLen)
+		if _, err = rand.Read(salt); err != nil {
+			return
+		}
+		if crypt, err = newChainCrypt(passphrase, salt, nil); err != nil {
+			return
+		}
+		if err = ioutil.WriteFile(path, crypt.header, 0600); err != nil {
+			return
+		}
+	} else {
+		var f *os.File
+		if f, err = os.Open(path); err != nil {
+			return
+		}
+		crypt, err = openChainCrypt(f, passphrase)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	c, err = loadChainFile(h, path, crypt)
+	return
+}
+
+// Encrypted returns true if the chain is kept encrypted on disk
+func (h *Holochain) Encrypted() bool {
+	return h.chain != nil && h.chain.crypt != nil
+}
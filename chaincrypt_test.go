@@ -0,0 +1,123 @@
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewEncryptedChainFromFile(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+
+	path := d + "/chain.dat"
+	Convey("it should require a passphrase", t, func() {
+		_, err := NewEncryptedChainFromFile(h, path, "")
+		So(err, ShouldEqual, ErrNoPassphrase)
+	})
+
+	c, err := NewEncryptedChainFromFile(h, path, "secret")
+	Convey("it should set up an encrypted chain file", t, func() {
+		So(err, ShouldBeNil)
+		So(isEncryptedChainFile(path), ShouldBeTrue)
+	})
+
+	e := GobEntry{C: "some private data"}
+	c.AddEntry(h, now, "myData1", &e, key)
+	e = GobEntry{C: "some other data2"}
+	hash, _ := c.AddEntry(h, now, "myData2", &e, key)
+	dump := c.String()
+	c.s.Close()
+
+	Convey("it shouldn't store entries in the clear", t, func() {
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(b, []byte("some private data")), ShouldBeFalse)
+	})
+
+	Convey("it should decrypt the chain when loaded with the passphrase", t, func() {
+		c, err := NewEncryptedChainFromFile(h, path, "secret")
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		c.s.Close()
+	})
+
+	Convey("it should refuse the wrong passphrase or none", t, func() {
+		_, err := NewEncryptedChainFromFile(h, path, "guess")
+		So(err, ShouldEqual, ErrBadPassphrase)
+		_, err = NewChainFromFile(h, path)
+		So(err, ShouldEqual, ErrNoPassphrase)
+	})
+
+	Convey("it should stay encrypted when truncated", t, func() {
+		c, err := NewEncryptedChainFromFile(h, path, "secret")
+		So(err, ShouldBeNil)
+		e := GobEntry{C: "more data"}
+		c.AddEntry(h, now, "myData3", &e, key)
+		_, err = c.Truncate(hash)
+		So(err, ShouldBeNil)
+		c.s.Close()
+		c, err = NewEncryptedChainFromFile(h, path, "secret")
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		c.s.Close()
+	})
+
+	Convey("it should refuse records longer than the limit", t, func() {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, uint32(maxSealedSize+1))
+		_, err := readSealed(&b)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("encrypted chain record of %d bytes is over the limit of %d", maxSealedSize+1, maxSealedSize))
+	})
+
+	Convey("it should refuse to encrypt an existing unencrypted chain", t, func() {
+		plain := d + "/plain.dat"
+		c, err := NewChainFromFile(h, plain)
+		So(err, ShouldBeNil)
+		e := GobEntry{C: "data"}
+		c.AddEntry(h, now, "myData1", &e, key)
+		c.s.Close()
+		_, err = NewEncryptedChainFromFile(h, plain, "secret")
+		So(err.Error(), ShouldEqual, "chain file isn't encrypted")
+	})
+}
+
+func TestLoadEncryptedChain(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	h.config.EncryptChain = true
+	f, err := os.Create(h.path + "/" + ConfigFileName + "." + h.encodingFormat)
+	if err != nil {
+		panic(err)
+	}
+	if err = Encode(f, h.encodingFormat, &h.config); err != nil {
+		panic(err)
+	}
+	f.Close()
+	os.Setenv(PassphraseEnvVar, "secret")
+	defer os.Unsetenv(PassphraseEnvVar)
+
+	Convey("it should encrypt the chain when configured to", t, func() {
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		_, err = h2.GenChain()
+		So(err, ShouldBeNil)
+		So(isEncryptedChainFile(h2.path+"/"+StoreFileName+".dat"), ShouldBeTrue)
+	})
+
+	Convey("it should decrypt the chain on load", t, func() {
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(h2.chain.Length(), ShouldEqual, 2)
+		s.GetPassphrase = func(chain string) (string, error) { return "wrong", nil }
+		_, err = s.Load("test")
+		So(err, ShouldEqual, ErrBadPassphrase)
+		s.GetPassphrase = nil
+	})
+}
@@ -0,0 +1,320 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// chainiter implements reading a chain's file a pair at a time, so that features which look
+// at every entry of a large chain don't have to decode all of it into memory at once.  Opening
+// an iterator makes one pass over the file noting where each pair starts, skipping over the
+// entries.  After that each pair's header is read from the file as the iterator reaches it,
+// and its entry only if it is asked for, so the file's pages are only read as they are needed.
+// Loading a chain makes the same pass keeping the headers, and leaves each entry in the file
+// as a stored entry that is read when it is used.  The pairs of encrypted chains are sealed
+// together, so reaching one of them reads both.
+
+package holochain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrIteratorDone error = errors.New("chain iterator has no current pair")
+var ErrStoredEntry error = errors.New("entries stored in a chain's file can't be changed")
+
+// ChainIterator steps through the header and entry pairs of a chain's file, either from the
+// first pair to the last or from the last to the first
+type ChainIterator struct {
+	spec    HashSpec
+	f       *os.File
+	crypt   *chainCrypt
+	offsets []int64 // where each pair starts in the file
+	end     int64   // where the last whole pair ends
+	reverse bool
+	i       int // index of the current pair, or -1 before the first step
+	next    int // index of the pair the next step reaches
+	header  *Header
+	hash    Hash
+	entryAt int64 // where the current pair's entry starts, if the chain isn't encrypted
+	entry   Entry
+	err     error
+}
+
+// countingReader keeps track of how far into a file a buffered reader has read
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+// skip discards the next n bytes
+func (c *countingReader) skip(n int64) (err error) {
+	var d int
+	for n > 0 && err == nil {
+		chunk := n
+		if chunk > 1<<30 {
+			chunk = 1 << 30
+		}
+		d, err = c.r.Discard(int(chunk))
+		c.n += int64(d)
+		n -= int64(d)
+	}
+	return
+}
+
+// Iterator opens the chain's file for iterating over its pairs, newest first if reverse is
+// true.  Pairs committed after the iterator is opened aren't reached by it.  The iterator must
+// be closed when done with.
+func (h *Holochain) Iterator(reverse bool) (it *ChainIterator, err error) {
+	var crypt *chainCrypt
+	if h.chain != nil {
+		crypt = h.chain.crypt
+	}
+	it, err = openChainIterator(h.hashSpec, filepath.Join(h.path, StoreFileName+".dat"), crypt, reverse)
+	return
+}
+
+// openChainIterator opens the chain file at path for iterating, noting where each of its pairs
+// starts.  A partly written pair at the end of the file is left out.
+func openChainIterator(spec HashSpec, path string, crypt *chainCrypt, reverse bool) (it *ChainIterator, err error) {
+	it = &ChainIterator{spec: spec, crypt: crypt, reverse: reverse, i: -1}
+	if it.f, err = os.Open(path); err != nil {
+		return
+	}
+	it.end, err = scanChainFile(it.f, crypt, false, func(at int64, entryAt int64, hd *Header) {
+		it.offsets = append(it.offsets, at)
+	})
+	if err != nil {
+		it.f.Close()
+		return
+	}
+	if reverse {
+		it.next = len(it.offsets) - 1
+	}
+	return
+}
+
+// scanChainFile makes one pass over a chain's file, calling fn with where each pair and its
+// entry start, skipping over the entries.  The header is given too unless the chain is
+// encrypted and withHeaders is false, as reading the header of a sealed pair means opening it.
+// A partly written pair at the end of the file is left out, end being where the last whole
+// pair ends.
+func scanChainFile(f *os.File, crypt *chainCrypt, withHeaders bool, fn func(at int64, entryAt int64, hd *Header)) (end int64, err error) {
+	var info os.FileInfo
+	if info, err = f.Stat(); err != nil {
+		return
+	}
+	var start int64
+	if crypt != nil {
+		start = int64(len(crypt.header))
+	}
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(f, start, info.Size()-start)), n: start}
+	end = start
+	for {
+		at, entryAt := r.n, r.n
+		var hd *Header
+		if crypt != nil {
+			var sealed []byte
+			if sealed, err = readSealed(r); err == nil && withHeaders {
+				var plain []byte
+				if plain, err = crypt.open(sealed); err == nil {
+					hd, _, err = readPair(bytes.NewReader(plain))
+				}
+			}
+		} else {
+			var header Header
+			if err = UnmarshalHeader(r, &header, 34); err == nil {
+				hd, entryAt = &header, r.n
+				var l uint64
+				if err = binary.Read(r, binary.LittleEndian, &l); err == nil {
+					err = r.skip(int64(l))
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		fn(at, entryAt, hd)
+		end = r.n
+	}
+}
+
+// storedEntry is an entry left in a chain's file when the chain is loaded, which is read from
+// the file each time it is used rather than kept in memory
+type storedEntry struct {
+	f     *os.File
+	crypt *chainCrypt // if not nil, the entry is sealed with its header in the pair at at
+	at    int64       // where the entry starts in the file
+}
+
+// load reads the entry from the file
+func (s *storedEntry) load() (entry Entry, err error) {
+	info, err := s.f.Stat()
+	if err != nil {
+		return
+	}
+	r := io.NewSectionReader(s.f, s.at, info.Size()-s.at)
+	if s.crypt != nil {
+		_, entry, err = s.crypt.readPair(r)
+		return
+	}
+	entry, err = UnmarshalEntry(r)
+	return
+}
+
+func (s *storedEntry) Marshal() (b []byte, err error) {
+	var e Entry
+	if e, err = s.load(); err != nil {
+		return
+	}
+	return e.Marshal()
+}
+
+func (s *storedEntry) Unmarshal(b []byte) error {
+	return ErrStoredEntry
+}
+
+// Content returns the entry's content, or nil if it can't be read
+func (s *storedEntry) Content() interface{} {
+	e, err := s.load()
+	if err != nil {
+		Debugf("error reading stored entry: %v", err)
+		return nil
+	}
+	return e.Content()
+}
+
+func (s *storedEntry) Sum(spec HashSpec) (hash Hash, err error) {
+	var e Entry
+	if e, err = s.load(); err != nil {
+		return
+	}
+	return e.Sum(spec)
+}
+
+// reopenStored points the stored entries among entries at the chain's file at path, once it
+// has been rewritten with the same pairs.  The old file is left for the garbage collector to
+// close, as snapshots taken before may still read from it.
+func (c *Chain) reopenStored(path string, entries []Entry) (err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	i := 0
+	_, err = scanChainFile(f, c.crypt, false, func(at int64, entryAt int64, hd *Header) {
+		if i < len(entries) {
+			if _, ok := entries[i].(*storedEntry); ok {
+				entries[i] = &storedEntry{f: f, crypt: c.crypt, at: entryAt}
+			}
+		}
+		i++
+	})
+	if err != nil {
+		f.Close()
+		return
+	}
+	c.r = f
+	return
+}
+
+// loadEntry returns an entry of a chain read into memory if it is still in the chain's file
+func loadEntry(e Entry) (Entry, error) {
+	if s, ok := e.(*storedEntry); ok {
+		return s.load()
+	}
+	return e, nil
+}
+
+// Len returns how many pairs the iterator steps through
+func (it *ChainIterator) Len() int {
+	return len(it.offsets)
+}
+
+// Next steps to the next pair, returning false once there are no more or reading one fails
+func (it *ChainIterator) Next() bool {
+	it.header, it.entry = nil, nil
+	if it.err != nil || it.next < 0 || it.next >= len(it.offsets) {
+		return false
+	}
+	it.i = it.next
+	if it.reverse {
+		it.next--
+	} else {
+		it.next++
+	}
+	at := it.offsets[it.i]
+	r := io.NewSectionReader(it.f, at, it.end-at)
+	var hd *Header
+	if it.crypt != nil {
+		hd, it.entry, it.err = it.crypt.readPair(r)
+	} else {
+		var header Header
+		cr := &countingReader{r: bufio.NewReaderSize(r, 512), n: at}
+		if it.err = UnmarshalHeader(cr, &header, 34); it.err == nil {
+			hd = &header
+			// the buffered reader may have read past the header, but cr.n counts what it returned
+			it.entryAt = cr.n
+		}
+	}
+	if it.err != nil {
+		return false
+	}
+	if it.hash, _, it.err = hd.Sum(it.spec); it.err != nil {
+		return false
+	}
+	it.header = hd
+	return true
+}
+
+// Index returns the index in the chain of the current pair
+func (it *ChainIterator) Index() int {
+	return it.i
+}
+
+// Hash returns the hash of the current pair's header
+func (it *ChainIterator) Hash() Hash {
+	return it.hash
+}
+
+// Header returns the current pair's header
+func (it *ChainIterator) Header() *Header {
+	return it.header
+}
+
+// Entry reads and returns the current pair's entry
+func (it *ChainIterator) Entry() (entry Entry, err error) {
+	if it.header == nil {
+		err = ErrIteratorDone
+		return
+	}
+	if it.entry == nil {
+		if it.entry, err = UnmarshalEntry(io.NewSectionReader(it.f, it.entryAt, it.end-it.entryAt)); err != nil {
+			return
+		}
+	}
+	entry = it.entry
+	return
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *ChainIterator) Err() error {
+	return it.err
+}
+
+// Close closes the chain's file
+func (it *ChainIterator) Close() error {
+	return it.f.Close()
+}
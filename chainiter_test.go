@@ -0,0 +1,139 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+)
+
+func TestChainIterator(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+
+	path := d + "/chain.dat"
+	c, err := NewChainFromFile(h, path)
+	if err != nil {
+		panic(err)
+	}
+	for _, s := range []string{"one", "two", "three"} {
+		e := GobEntry{C: s}
+		c.AddEntry(h, now, "myData", &e, key)
+	}
+
+	Convey("it should step through the pairs from first to last", t, func() {
+		it, err := openChainIterator(h, path, nil, false)
+		So(err, ShouldBeNil)
+		defer it.Close()
+		So(it.Len(), ShouldEqual, 3)
+		_, err = it.Entry()
+		So(err, ShouldEqual, ErrIteratorDone)
+		i := 0
+		for it.Next() {
+			So(it.Index(), ShouldEqual, i)
+			So(it.Hash().String(), ShouldEqual, c.Hashes[i].String())
+			So(it.Header().EntryLink.String(), ShouldEqual, c.Headers[i].EntryLink.String())
+			e, err := it.Entry()
+			So(err, ShouldBeNil)
+			So(e.Content(), ShouldEqual, c.Entries[i].Content())
+			i++
+		}
+		So(it.Err(), ShouldBeNil)
+		So(i, ShouldEqual, 3)
+		_, err = it.Entry()
+		So(err, ShouldEqual, ErrIteratorDone)
+	})
+
+	Convey("it should step through the pairs from last to first", t, func() {
+		it, err := openChainIterator(h, path, nil, true)
+		So(err, ShouldBeNil)
+		defer it.Close()
+		i := 2
+		for it.Next() {
+			So(it.Index(), ShouldEqual, i)
+			So(it.Hash().String(), ShouldEqual, c.Hashes[i].String())
+			if i == 1 {
+				e, err := it.Entry()
+				So(err, ShouldBeNil)
+				So(e.Content(), ShouldEqual, "two")
+			}
+			i--
+		}
+		So(it.Err(), ShouldBeNil)
+		So(i, ShouldEqual, -1)
+	})
+
+	Convey("loading the chain should leave its entries in the file until they are used", t, func() {
+		l, err := NewChainFromFile(h, path)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		So(l.Length(), ShouldEqual, 3)
+		_, stored := l.Entries[1].(*storedEntry)
+		So(stored, ShouldBeTrue)
+		So(l.Entries[1].Content(), ShouldEqual, "two")
+		entry, entryType, err := l.GetEntry(l.Headers[1].EntryLink)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "myData")
+		So(entry.(*GobEntry).C, ShouldEqual, "two")
+		So(l.Validate(h), ShouldBeNil)
+	})
+
+	Convey("it should leave out a partly written pair at the end", t, func() {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		So(err, ShouldBeNil)
+		b, _ := c.Headers[2].Marshal()
+		f.Write(b)
+		f.Write([]byte{9, 0, 0, 0})
+		f.Close()
+		it, err := openChainIterator(h, path, nil, true)
+		So(err, ShouldBeNil)
+		defer it.Close()
+		So(it.Len(), ShouldEqual, 3)
+		So(it.Next(), ShouldBeTrue)
+		So(it.Hash().String(), ShouldEqual, c.Hashes[2].String())
+
+		_, err = NewChainFromFile(h, path)
+		So(err, ShouldNotBeNil)
+	})
+	c.s.Close()
+
+	Convey("it should read encrypted chains", t, func() {
+		epath := d + "/encrypted.dat"
+		ec, err := NewEncryptedChainFromFile(h, epath, "secret")
+		So(err, ShouldBeNil)
+		e := GobEntry{C: "private"}
+		ec.AddEntry(h, now, "myData", &e, key)
+		it, err := openChainIterator(h, epath, ec.crypt, false)
+		So(err, ShouldBeNil)
+		defer it.Close()
+		So(it.Next(), ShouldBeTrue)
+		entry, err := it.Entry()
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "private")
+		So(it.Hash().String(), ShouldEqual, ec.Hashes[0].String())
+		So(it.Next(), ShouldBeFalse)
+		ec.s.Close()
+
+		ec, err = NewEncryptedChainFromFile(h, epath, "secret")
+		So(err, ShouldBeNil)
+		defer ec.Close()
+		_, stored := ec.Entries[0].(*storedEntry)
+		So(stored, ShouldBeTrue)
+		So(ec.Entries[0].Content(), ShouldEqual, "private")
+	})
+}
+
+func TestHolochainIterator(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should iterate over the chain's file", t, func() {
+		it, err := h.Iterator(true)
+		So(err, ShouldBeNil)
+		defer it.Close()
+		So(it.Len(), ShouldEqual, len(h.chain.Headers))
+		So(it.Next(), ShouldBeTrue)
+		top, _ := h.Top()
+		So(it.Hash().String(), ShouldEqual, top.String())
+	})
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// check implements checking an installed chain's app before it is run, so that the problems
+// in its zomes are found all at once, at the file and line they are at, rather than by the
+// first call that trips over one.  The app is verified as it would be before installing it,
+// and then a new agent runs its genesis in a throwaway service, so the chain isn't changed.
+
+package holochain
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const checkChainName = "check"
+
+var codeLineRE = regexp.MustCompile(`[Ll]ine:? (\d+)`)
+
+// Check checks the app of an installed chain: that its zomes load in their nuclei and expose
+// the functions they declare, that its schemas compile and that its genesis runs.  An error is
+// only returned if the app can't be checked at all.
+func (s *Service) Check(name string) (problems AppProblems, err error) {
+	if _, err = s.IsConfigured(name); err != nil {
+		return
+	}
+	path := filepath.Join(s.Path, name)
+	var b *DNABundle
+	if b, err = ReadBundle(path); err != nil {
+		return
+	}
+	if problems, err = VerifyApp(b, Hash{}); err != nil {
+		return
+	}
+	if problems.Errors() > 0 {
+		// genesis would only trip over the same problems
+		return
+	}
+	var p AppProblems
+	if p, err = checkGenesis(path); err != nil {
+		return
+	}
+	problems = append(problems, p...)
+	return
+}
+
+// checkGenesis runs the genesis of each zome of the app at src for a new agent in a throwaway
+// service, returning the problems found
+func checkGenesis(src string) (problems AppProblems, err error) {
+	var root string
+	if root, err = ioutil.TempDir("", "hc-check"); err != nil {
+		return
+	}
+	defer os.RemoveAll(root)
+	var s *Service
+	if s, err = Init(filepath.Join(root, checkChainName), AgentName("check <check@localhost>")); err != nil {
+		return
+	}
+	var h *Holochain
+	if h, err = s.Clone(src, filepath.Join(s.Path, checkChainName), false); err != nil {
+		return
+	}
+	// the throwaway agent can't have been invited, and joining has nothing to do with the zomes
+	h.JoinPuzzle = nil
+	if _, err = h.genIdentity(); err != nil {
+		problems = append(problems, AppProblem{Where: "genesis", Message: err.Error()})
+		err = nil
+		return
+	}
+	if err = h.dht.SetupDHT(); err != nil {
+		return
+	}
+
+	var names []string
+	for name := range h.Zomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		z := h.Zomes[name]
+		var n Nucleus
+		if n, err = h.makeNucleus(z); err == nil {
+			err = n.ChainGenesis()
+		}
+		if err != nil {
+			problems = append(problems, AppProblem{Where: "zome " + name, Message: fmt.Sprintf("genesis fails: %s: %v", h.codeLocation(z, err), err)})
+			err = nil
+		}
+	}
+	return
+}
+
+// codePrefixLines returns how many lines a nucleus loads before the code given it
+func codePrefixLines(nucleusType string) int {
+	return nucleusPrefixLines[nucleusType]
+}
+
+// codeLocation returns the file and line of a zome's code that an error from its nucleus
+// points at, or just the zome's code file if the error doesn't give a line
+func (h *Holochain) codeLocation(z *Zome, err error) string {
+	m := codeLineRE.FindStringSubmatch(err.Error())
+	if m == nil {
+		return z.Code
+	}
+	line, _ := strconv.Atoi(m[1])
+	line -= codePrefixLines(z.NucleusType)
+	for _, hash := range z.Libraries {
+		l, code, e := h.library(hash)
+		if e != nil {
+			return z.Code
+		}
+		n := strings.Count(string(code), "\n") + 1
+		if line <= n {
+			if line < 1 {
+				return z.Code
+			}
+			return fmt.Sprintf("%s:%d", l.Code, line)
+		}
+		line -= n
+	}
+	if line < 1 {
+		return z.Code
+	}
+	return fmt.Sprintf("%s:%d", z.Code, line)
+}
@@ -0,0 +1,70 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	code, err := readFile(h.path, "zome_jsZome.js")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should find no errors in a good app", t, func() {
+		problems, err := s.Check("test")
+		So(err, ShouldBeNil)
+		So(problems.Errors(), ShouldEqual, 0)
+		So(fileExists(filepath.Join(h.path, StoreFileName+".dat")), ShouldBeFalse)
+	})
+
+	Convey("it should fail on chains that aren't installed", t, func() {
+		_, err := s.Check("nochain")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should report the file and line of code that doesn't load", t, func() {
+		So(writeFile(h.path, "zome_jsZome.js", []byte("\n\nfunction (")), ShouldBeNil)
+		problems, err := s.Check("test")
+		So(err, ShouldBeNil)
+		So(len(problemsContaining(problems, "zome jsZome: code doesn't load: zome_jsZome.js:3")), ShouldEqual, 1)
+	})
+
+	Convey("it should report zomes whose genesis fails", t, func() {
+		broken := strings.Replace(string(code), "function genesis() {return true}", "function genesis() {return false}", 1)
+		So(writeFile(h.path, "zome_jsZome.js", []byte(broken)), ShouldBeNil)
+		problems, err := s.Check("test")
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", problems), ShouldContainSubstring, "zome jsZome: genesis fails")
+		So(problems.Errors(), ShouldEqual, 1)
+	})
+}
+
+func TestCodeLocation(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	z := h.Zomes["jsZome"]
+
+	Convey("it should give the code file when the error has no line", t, func() {
+		So(h.codeLocation(z, fmt.Errorf("boom")), ShouldEqual, "zome_jsZome.js")
+	})
+
+	Convey("it should give the line in the code file", t, func() {
+		So(h.codeLocation(z, fmt.Errorf("(anonymous): Line 7:3 Unexpected token")), ShouldEqual, "zome_jsZome.js:7")
+	})
+
+	Convey("it should give the line in a library the zome depends on", t, func() {
+		So(writeFile(h.path, "lib.js", []byte("var a;\nvar b;\n")), ShouldBeNil)
+		h.Libraries = map[string]*Library{"lib": {Name: "lib", Code: "lib.js", NucleusType: JSNucleusType}}
+		var lib Hash
+		lib.Sum(h.hashSpec, []byte("var a;\nvar b;\n"))
+		z.Libraries = []string{lib.String()}
+		So(h.codeLocation(z, fmt.Errorf("Line 2:1 bad")), ShouldEqual, "lib.js:2")
+		So(h.codeLocation(z, fmt.Errorf("Line 5:1 bad")), ShouldEqual, "zome_jsZome.js:2")
+	})
+}
@@ -0,0 +1,374 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// clock implements checks on the times agents put in the headers of their entries, which are
+// otherwise taken on their word.  Entries whose headers are dated further in the future than
+// the maximum clock skew are rejected, and the offsets of peers' clocks from this node's are
+// recorded from the times they send with heartbeats and time attestations, so that hc doctor
+// can tell whether this node's clock or a peer's is off.  Nodes can also ask some of their
+// gossip partners to countersign the time of each header they commit, backing the time with
+// more than the author's word; the attestations are sent along with the header when the
+// entry is put, and kept by the nodes that hold it.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultMaxClockSkew = "5m"
+	MaxTimeWitnesses    = 8 // most gossip partners asked to attest to the time of a header
+
+	// this node's clock isn't judged until the offsets of this many peers are known
+	minPeersForClockCheck = 3
+)
+
+var ErrHeaderFromFuture error = errors.New("header is dated further in the future than the maximum clock skew")
+var ErrDHTExpectedTimeAttestReqInBody error = errors.New("expected time attestation request")
+var ErrBadTimeAttestation error = errors.New("bad time attestation")
+
+// MaxClockSkewDuration returns how far the clocks of nodes may differ from this node's
+func (config *Config) MaxClockSkewDuration() (skew time.Duration, err error) {
+	s := config.MaxClockSkew
+	if s == "" {
+		s = DefaultMaxClockSkew
+	}
+	skew, err = time.ParseDuration(s)
+	if err == nil && skew <= 0 {
+		err = fmt.Errorf("max clock skew must be positive, got: %s", s)
+	}
+	return
+}
+
+func (h *Holochain) maxClockSkew() time.Duration {
+	config := h.Config()
+	skew, err := config.MaxClockSkewDuration()
+	if err != nil {
+		skew, _ = time.ParseDuration(DefaultMaxClockSkew)
+	}
+	return skew
+}
+
+// checkHeaderTime rejects header times further in the future than the maximum clock skew
+func (h *Holochain) checkHeaderTime(t time.Time) error {
+	if t.After(time.Now().Add(h.maxClockSkew())) {
+		return ErrHeaderFromFuture
+	}
+	return nil
+}
+
+// checkPutHeader checks the header a source sent with an entry it put: that it is the header
+// of the entry signed by the source and isn't dated in the future, and that the time
+// attestations sent with it attest to its time, keeping those that do
+func (dht *DHT) checkPutHeader(from peer.ID, hash Hash, resp *ValidateResponse) (err error) {
+	if resp.Header == nil {
+		err = fmt.Errorf("%v: no header sent", ErrBadSourceHeader)
+		return
+	}
+	sh := SourceHeader{Header: *resp.Header, PubKey: resp.PubKey}
+	if err = sh.check(from, hash); err != nil {
+		return
+	}
+	if err = dht.h.checkHeaderTime(resp.Header.Time); err != nil {
+		return
+	}
+	if len(resp.TimeAttestations) == 0 {
+		return
+	}
+	var header Hash
+	if header, _, err = resp.Header.Sum(dht.h.hashSpec); err != nil {
+		return
+	}
+	skew := dht.h.maxClockSkew()
+	for _, a := range resp.TimeAttestations {
+		if !a.Header.Equal(&header) || !a.Time.Equal(resp.Header.Time) {
+			err = fmt.Errorf("%v: time attestation by %v isn't of the header", ErrBadTimeAttestation, a.Witness)
+			return
+		}
+		if absDuration(a.WitnessTime.Sub(a.Time)) > skew {
+			err = fmt.Errorf("%v: %v attested to a time off by %v from its clock", ErrBadTimeAttestation, a.Witness, a.WitnessTime.Sub(a.Time))
+			return
+		}
+		if err = a.Verify(); err != nil {
+			err = fmt.Errorf("%v: %v", ErrBadTimeAttestation, err)
+			return
+		}
+	}
+	err = dht.putTimeAttestations(header, resp.TimeAttestations)
+	return
+}
+
+// ClockOffset is how far a peer's clock was found to be ahead of this node's
+type ClockOffset struct {
+	Id     peer.ID
+	Offset time.Duration // negative if the peer's clock is behind
+	At     time.Time     // when the offset was observed
+}
+
+func clockKey(id peer.ID) string {
+	return "clock:" + peer.IDB58Encode(id)
+}
+
+// observeClock records the offset of a peer's clock from a time it has just sent
+func (dht *DHT) observeClock(id peer.ID, theirs time.Time) error {
+	now := time.Now()
+	b, err := json.Marshal(ClockOffset{Id: id, Offset: theirs.Sub(now), At: now})
+	if err != nil {
+		return err
+	}
+	return dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(clockKey(id), string(b), nil)
+		return err
+	})
+}
+
+// ClockOffsets returns the latest offset observed of each peer's clock
+func (dht *DHT) ClockOffsets() (offsets []ClockOffset, err error) {
+	offsets = make([]ClockOffset, 0)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("clock:*", func(key, value string) bool {
+			var o ClockOffset
+			if e = json.Unmarshal([]byte(value), &o); e != nil {
+				return false
+			}
+			offsets = append(offsets, o)
+			return true
+		})
+		return e
+	})
+	return
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// ClockReport summarizes how this node's clock compares with its peers'
+type ClockReport struct {
+	MaxSkew  time.Duration
+	Peers    []ClockOffset
+	Median   time.Duration // median offset of the peers' clocks, i.e. how far behind this node's clock seems
+	Problems []string
+}
+
+// ClockReport reports the offsets of the peers' clocks, flagging this node's clock if most
+// peers disagree with it and any peer's clock that is off by more than the maximum skew
+func (h *Holochain) ClockReport() (r ClockReport, err error) {
+	r.MaxSkew = h.maxClockSkew()
+	if r.Peers, err = h.dht.ClockOffsets(); err != nil {
+		return
+	}
+	if len(r.Peers) == 0 {
+		return
+	}
+	offsets := make(durations, len(r.Peers))
+	for i, o := range r.Peers {
+		offsets[i] = o.Offset
+	}
+	sort.Sort(offsets)
+	r.Median = offsets[len(offsets)/2]
+	if len(offsets) >= minPeersForClockCheck && absDuration(r.Median) > r.MaxSkew {
+		r.Problems = append(r.Problems, fmt.Sprintf("this node's clock seems off by %v compared to %d peers", -r.Median, len(offsets)))
+		return
+	}
+	for _, o := range r.Peers {
+		if absDuration(o.Offset) > r.MaxSkew {
+			r.Problems = append(r.Problems, fmt.Sprintf("clock of peer %v is off by %v", o.Id.Pretty(), o.Offset))
+		}
+	}
+	return
+}
+
+// TimeAttestReq asks a gossip partner to attest to the time of a header
+type TimeAttestReq struct {
+	Header Hash
+	Time   time.Time
+}
+
+// TimeAttestation is a witness's signed statement that a header's time agreed with its clock
+type TimeAttestation struct {
+	Header      Hash
+	Time        time.Time // the header's time
+	Witness     peer.ID
+	WitnessTime time.Time // the witness's clock when it attested
+	PubKey      []byte
+	Sig         []byte
+}
+
+func (a *TimeAttestation) signedBytes() ([]byte, error) {
+	return ByteEncoder(TimeAttestation{Header: a.Header, Time: a.Time, Witness: a.Witness, WitnessTime: a.WitnessTime})
+}
+
+// NewTimeAttestation returns a signed attestation that the header's time was seen at now
+func (h *Holochain) NewTimeAttestation(header Hash, at time.Time, now time.Time) (a *TimeAttestation, err error) {
+	x := TimeAttestation{Header: header, Time: at, Witness: h.id, WitnessTime: now}
+	priv := h.Agent().PrivKey()
+	if x.PubKey, err = ic.MarshalPublicKey(priv.GetPublic()); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = x.signedBytes(); err != nil {
+		return
+	}
+	if x.Sig, err = priv.Sign(b); err != nil {
+		return
+	}
+	a = &x
+	return
+}
+
+// Verify checks that the attestation was signed by its witness
+func (a *TimeAttestation) Verify() (err error) {
+	var pub ic.PubKey
+	if pub, err = ic.UnmarshalPublicKey(a.PubKey); err != nil {
+		return
+	}
+	if !a.Witness.MatchesPublicKey(pub) {
+		err = fmt.Errorf("time attestation by %v signed with another key", a.Witness)
+		return
+	}
+	var b []byte
+	if b, err = a.signedBytes(); err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(b, a.Sig)
+	if err == nil && !ok {
+		err = fmt.Errorf("bad time attestation signature from %v", a.Witness)
+	}
+	return
+}
+
+// handleTimeAttestReq attests to the time of a header if it agrees with this node's clock
+func (dht *DHT) handleTimeAttestReq(from peer.ID, req *TimeAttestReq) (response interface{}, err error) {
+	now := time.Now()
+	if off := req.Time.Sub(now); absDuration(off) > dht.h.maxClockSkew() {
+		err = fmt.Errorf("header time is off by %v from the witness's clock", off)
+		return
+	}
+	var a *TimeAttestation
+	if a, err = dht.h.NewTimeAttestation(req.Header, req.Time, now); err != nil {
+		return
+	}
+	dht.h.metrics.Inc("clock.attested", 1)
+	response = *a
+	return
+}
+
+func timeAttestationsKey(header Hash) string {
+	return "tattest:" + header.String()
+}
+
+// AttestTime asks up to the configured number of gossip partners to attest to the time of a
+// header on the chain, storing and returning the attestations they give
+func (h *Holochain) AttestTime(header Hash) (attestations []TimeAttestation, err error) {
+	want := h.Config().TimeWitnesses
+	if want <= 0 || h.Offline() {
+		return
+	}
+	var hd *Header
+	if hd, err = h.chain.Get(header); err != nil {
+		return
+	}
+	dht := h.dht
+	var glist []Gossiper
+	if glist, err = dht.Gossipers(); err != nil {
+		return
+	}
+	for _, i := range rand.Perm(len(glist)) {
+		if len(attestations) == want {
+			break
+		}
+		id := glist[i].Id
+		if h.peerLacks(id, FeatureTimeAttest) {
+			continue
+		}
+		r, e := dht.send(id, TIME_ATTEST_REQUEST, TimeAttestReq{Header: header, Time: hd.Time})
+		if e != nil {
+			dht.glog.Logf("time attestation from %v refused: %v", id, e)
+			continue
+		}
+		a, ok := r.(TimeAttestation)
+		if !ok || a.Witness != id || a.Header.String() != header.String() || !a.Time.Equal(hd.Time) {
+			dht.glog.Logf("unexpected time attestation from %v", id)
+			continue
+		}
+		if e = a.Verify(); e != nil {
+			dht.glog.Logf("bad time attestation from %v: %v", id, e)
+			continue
+		}
+		if e = dht.observeClock(id, a.WitnessTime); e != nil {
+			dht.glog.Logf("error recording clock of %v: %v", id, e)
+		}
+		attestations = append(attestations, a)
+	}
+	if len(attestations) > 0 {
+		err = dht.putTimeAttestations(header, attestations)
+	}
+	return
+}
+
+func (dht *DHT) putTimeAttestations(header Hash, attestations []TimeAttestation) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		var all []TimeAttestation
+		if val, e := tx.Get(timeAttestationsKey(header)); e == nil {
+			if e = json.Unmarshal([]byte(val), &all); e != nil {
+				return e
+			}
+		}
+		// each witness's latest attestation is kept, as a put can be received more than once
+		for _, a := range attestations {
+			i := 0
+			for ; i < len(all) && all[i].Witness != a.Witness; i++ {
+			}
+			if i < len(all) {
+				all[i] = a
+			} else {
+				all = append(all, a)
+			}
+		}
+		b, e := json.Marshal(all)
+		if e != nil {
+			return e
+		}
+		_, _, e = tx.Set(timeAttestationsKey(header), string(b), nil)
+		return e
+	})
+	return
+}
+
+// TimeAttestations returns the attestations gathered of the time of a header
+func (h *Holochain) TimeAttestations(header Hash) (attestations []TimeAttestation, err error) {
+	attestations = make([]TimeAttestation, 0)
+	err = h.dht.db.View(func(tx *buntdb.Tx) error {
+		val, e := tx.Get(timeAttestationsKey(header))
+		if e == buntdb.ErrNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		return json.Unmarshal([]byte(val), &attestations)
+	})
+	return
+}
@@ -0,0 +1,148 @@
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestMaxClockSkew(t *testing.T) {
+	Convey("it should default to five minutes and reject bad durations", t, func() {
+		var c Config
+		skew, err := c.MaxClockSkewDuration()
+		So(err, ShouldBeNil)
+		So(skew, ShouldEqual, 5*time.Minute)
+		c.MaxClockSkew = "-1m"
+		_, err = c.MaxClockSkewDuration()
+		So(err.Error(), ShouldEqual, "max clock skew must be positive, got: -1m")
+		c.MaxClockSkew = "fish"
+		_, err = c.MaxClockSkewDuration()
+		So(err, ShouldNotBeNil)
+	})
+
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should reject headers dated too far in the future", t, func() {
+		So(h.checkHeaderTime(time.Now()), ShouldBeNil)
+		So(h.checkHeaderTime(time.Now().Add(-24*time.Hour)), ShouldBeNil)
+		So(h.checkHeaderTime(time.Now().Add(4*time.Minute)), ShouldBeNil)
+		So(h.checkHeaderTime(time.Now().Add(6*time.Minute)), ShouldEqual, ErrHeaderFromFuture)
+	})
+
+	Convey("sources should send the header of entries to validate", t, func() {
+		hash, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		m := h.node.NewMessage(SRC_VALIDATE, hash)
+		r, err := SrcReceiver(h, m)
+		So(err, ShouldBeNil)
+		resp := r.(*ValidateResponse)
+		So(resp.Header, ShouldNotBeNil)
+		So(resp.Header.EntryLink.String(), ShouldEqual, hash.String())
+	})
+}
+
+func TestClockReport(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	peers := make([]peer.ID, 4)
+	for i := range peers {
+		peers[i], _ = makePeer(string(rune('a' + i)))
+	}
+
+	Convey("it should report nothing before any peer clocks are observed", t, func() {
+		r, err := h.ClockReport()
+		So(err, ShouldBeNil)
+		So(len(r.Peers), ShouldEqual, 0)
+		So(len(r.Problems), ShouldEqual, 0)
+	})
+
+	Convey("it should flag a peer whose clock is off", t, func() {
+		So(dht.observeClock(peers[0], time.Now()), ShouldBeNil)
+		So(dht.observeClock(peers[1], time.Now().Add(time.Second)), ShouldBeNil)
+		So(dht.observeClock(peers[2], time.Now().Add(-time.Hour)), ShouldBeNil)
+		r, err := h.ClockReport()
+		So(err, ShouldBeNil)
+		So(len(r.Peers), ShouldEqual, 3)
+		So(len(r.Problems), ShouldEqual, 1)
+		So(r.Problems[0], ShouldStartWith, "clock of peer "+peers[2].Pretty())
+	})
+
+	Convey("it should flag its own clock when most peers disagree with it", t, func() {
+		for _, id := range peers {
+			So(dht.observeClock(id, time.Now().Add(time.Hour)), ShouldBeNil)
+		}
+		r, err := h.ClockReport()
+		So(err, ShouldBeNil)
+		So(len(r.Problems), ShouldEqual, 1)
+		So(r.Problems[0], ShouldStartWith, "this node's clock seems off by -")
+	})
+}
+
+func TestTimeAttestation(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	hash, _ := h.Commit("myData", "2")
+
+	Convey("a witness should attest to header times that agree with its clock", t, func() {
+		at := time.Now()
+		r, err := dht.handleTimeAttestReq(h.id, &TimeAttestReq{Header: hash, Time: at})
+		So(err, ShouldBeNil)
+		a := r.(TimeAttestation)
+		So(a.Witness, ShouldEqual, h.id)
+		So(a.Time.Equal(at), ShouldBeTrue)
+		So(a.Verify(), ShouldBeNil)
+		a.Time = at.Add(time.Hour)
+		So(a.Verify().Error(), ShouldStartWith, "bad time attestation signature")
+	})
+
+	Convey("a witness should refuse header times that are off", t, func() {
+		_, err := dht.handleTimeAttestReq(h.id, &TimeAttestReq{Header: hash, Time: time.Now().Add(-time.Hour)})
+		So(err.Error(), ShouldStartWith, "header time is off by -1h")
+	})
+
+	Convey("attestations should be stored by header", t, func() {
+		a, _ := h.NewTimeAttestation(hash, time.Now(), time.Now())
+		So(dht.putTimeAttestations(hash, []TimeAttestation{*a}), ShouldBeNil)
+		So(dht.putTimeAttestations(hash, []TimeAttestation{*a}), ShouldBeNil)
+		atts, err := h.TimeAttestations(hash)
+		So(err, ShouldBeNil)
+		So(len(atts), ShouldEqual, 1)
+		So(atts[0].Verify(), ShouldBeNil)
+	})
+
+	Convey("puts should carry the header and its attestations", t, func() {
+		m := h.node.NewMessage(SRC_VALIDATE, hash)
+		r, err := SrcReceiver(h, m)
+		So(err, ShouldBeNil)
+		resp := r.(*ValidateResponse)
+		So(dht.checkPutHeader(h.id, hash, resp), ShouldBeNil)
+
+		header, _, _ := resp.Header.Sum(h.hashSpec)
+		a, _ := h.NewTimeAttestation(header, resp.Header.Time, time.Now())
+		resp.TimeAttestations = []TimeAttestation{*a}
+		So(dht.checkPutHeader(h.id, hash, resp), ShouldBeNil)
+		atts, err := h.TimeAttestations(header)
+		So(err, ShouldBeNil)
+		So(len(atts), ShouldEqual, 1)
+
+		a, _ = h.NewTimeAttestation(hash, resp.Header.Time, time.Now())
+		resp.TimeAttestations = []TimeAttestation{*a}
+		err = dht.checkPutHeader(h.id, hash, resp)
+		So(isError(err, ErrBadTimeAttestation), ShouldBeTrue)
+
+		resp.Header = nil
+		err = dht.checkPutHeader(h.id, hash, resp)
+		So(isError(err, ErrBadSourceHeader), ShouldBeTrue)
+	})
+
+	Convey("no attestations should be asked for unless configured", t, func() {
+		atts, err := h.AttestTime(hash)
+		So(err, ShouldBeNil)
+		So(len(atts), ShouldEqual, 0)
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strings"
 )
 
@@ -53,7 +54,7 @@ func setupApp() (app *cli.App) {
 					return err
 				}
 				userPath := u.HomeDir
-				dbpath = userPath + "/.hcboostrapdb"
+				dbpath = filepath.Join(userPath, ".hcboostrapdb")
 			}
 		}
 		store, err = buntdb.Open(dbpath)
@@ -0,0 +1,304 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the built in node administration web UI for the hc serve command
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	holo "github.com/metacurrency/holochain"
+	"net/http"
+	"strconv"
+)
+
+const adminLogLines = 200
+
+// adminAuth wraps a handler so that it requires the service's admin token, given as a
+// bearer token in the Authorization header so that it never appears in urls or logs
+func adminAuth(s *holo.Service, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.CheckAdminToken(bearerToken(r)) {
+			http.Error(w, "admin token required", 401)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serveAdmin adds the admin UI and its api to the http server
+func serveAdmin(h *holo.Holochain, s *holo.Service, root string) {
+	logs := holo.NewLogBuffer(adminLogLines)
+	h.CaptureLogs(logs)
+	log.Tee(logs)
+	errs.Tee(logs)
+
+	// the page holds nothing secret, it asks for the token and sends it with each api call
+	http.HandleFunc("/_admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, adminHTML)
+	})
+
+	http.HandleFunc("/_admin/api/status", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"Name":    h.Name,
+			"DNAHash": h.DNAHash().String(),
+			"Agent":   string(h.Agent().Name()),
+			"Started": h.Started(),
+			"Metrics": h.Metrics().Snapshot(),
+		})
+	}))
+
+	http.HandleFunc("/_admin/api/peers", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		glist, err := h.DHT().Gossipers()
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		peers := make([]map[string]interface{}, 0)
+		for _, g := range glist {
+			r, err := h.DHT().GetPeerRecord(g.Id)
+			if err != nil {
+				http.Error(w, err.Error(), holo.StatusCode(err))
+				return
+			}
+			peers = append(peers, map[string]interface{}{"Id": g.Id.Pretty(), "Idx": g.Idx, "Score": r.Score(), "Banned": r.Banned()})
+		}
+		writeJSON(w, peers)
+	}))
+
+	http.HandleFunc("/_admin/api/dht", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 {
+			limit = 100
+		}
+		entries, err := h.DHT().ListEntries(limit)
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		writeJSON(w, entries)
+	}))
+
+	http.HandleFunc("/_admin/api/dht/stats", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		st, err := h.DHT().Stats()
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"Entries":         st.Entries,
+			"Bytes":           st.Bytes,
+			"ByType":          st.ByType,
+			"Rejected":        st.Rejected,
+			"Deleted":         st.Deleted,
+			"Redacted":        st.Redacted,
+			"Quarantined":     st.Quarantined,
+			"Oldest":          st.Oldest,
+			"Newest":          st.Newest,
+			"Peers":           st.Peers,
+			"Resilience":      st.Resilience,
+			"UnderReplicated": st.UnderReplicated,
+			"Coverage":        st.Coverage(),
+		})
+	}))
+
+	http.HandleFunc("/_admin/api/progress", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		ops := make([]map[string]interface{}, 0)
+		// cloning and joining are done by the service, before there is a chain to report them
+		for _, p := range append(s.Progress(), h.Progress()...) {
+			ops = append(ops, map[string]interface{}{"Op": p.Op, "Stage": p.Stage, "Done": p.Done, "Total": p.Total, "Percent": p.Percent()})
+		}
+		writeJSON(w, ops)
+	}))
+
+	http.HandleFunc("/_admin/api/logs", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, logs.Lines())
+	}))
+
+	http.HandleFunc("/_admin/api/call", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "call requires POST", 405)
+			return
+		}
+		var req struct{ Zome, Fn, Arg string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		result, err := call(w, h, req.Zome, req.Fn, req.Arg, "")
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		switch t := result.(type) {
+		case []byte:
+			result = string(t)
+		}
+		writeJSON(w, map[string]interface{}{"Result": result})
+	}))
+
+	http.HandleFunc("/_admin/api/commit", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "commit requires POST", 405)
+			return
+		}
+		var req struct{ Type, Content string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		hash, err := h.Commit(req.Type, req.Content)
+		if err == nil {
+			err = h.DHT().SendPut(hash)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{"Hash": hash.String()})
+	}))
+
+	http.HandleFunc("/_admin/api/tokens", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			tokens, err := s.Tokens(chainName(h))
+			if err != nil {
+				http.Error(w, err.Error(), holo.StatusCode(err))
+				return
+			}
+			writeJSON(w, tokens)
+		case "POST":
+			var req struct {
+				Name       string
+				Origin     string
+				Functions  []string
+				EntryTypes []string
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			t, err := s.IssueNamedToken(chainName(h), req.Name, req.Origin, req.Functions, req.EntryTypes)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			writeJSON(w, t)
+		case "DELETE":
+			if err := s.RevokeToken(r.URL.Query().Get("revoke")); err != nil {
+				http.Error(w, err.Error(), holo.StatusCode(err))
+				return
+			}
+			writeJSON(w, map[string]interface{}{"Revoked": true})
+		default:
+			http.Error(w, "tokens requires GET, POST or DELETE", 405)
+		}
+	}))
+
+	http.HandleFunc("/_admin/api/tokens/rotate", adminAuth(s, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "rotate requires POST", 405)
+			return
+		}
+		t, err := s.RotateToken(r.URL.Query().Get("rotate"))
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		writeJSON(w, t)
+	}))
+
+	if _, err := s.AdminToken(); err != nil {
+		errs.Logf("Couldn't get admin token: %v", err)
+		return
+	}
+	fmt.Printf("admin UI at %s/_admin, sign in with the token in %s\n", root, s.AdminTokenPath())
+}
+
+const adminHTML = `<html>
+<head>
+  <title>Holochain Admin</title>
+  <style>
+    body { font-family: sans-serif; margin: 1em 2em; }
+    h2 { border-bottom: 1px solid #ccc; }
+    table { border-collapse: collapse; }
+    td, th { padding: 2px 8px; text-align: left; font-family: monospace; }
+    pre { background: #f4f4f4; padding: 0.5em; max-height: 20em; overflow: auto; }
+  </style>
+</head>
+<body>
+  <h1>Holochain Admin</h1>
+  <h2>Status</h2><table id="status"></table>
+  <h2>Operations</h2><table id="progress"></table>
+  <h2>Peers</h2><table id="peers"></table>
+  <h2>DHT</h2><table id="dht"></table>
+  <h2>Call</h2>
+  <input id="zome" placeholder="zome"> <input id="fn" placeholder="function"> <input id="arg" placeholder="argument" size="40">
+  <button onclick="doCall()">Call</button>
+  <pre id="result"></pre>
+  <h2>Recent Logs</h2><pre id="logs"></pre>
+<script>
+var token = sessionStorage.getItem("token");
+if (!token) {
+  token = window.prompt("Admin token");
+  sessionStorage.setItem("token", token);
+}
+function api(path, opts) {
+  opts = opts || {};
+  opts.headers = {"Authorization": "Bearer " + token};
+  return fetch("_admin/api/" + path, opts).then(function(r) {
+    if (r.status == 401) { sessionStorage.removeItem("token"); }
+    if (!r.ok) { return r.text().then(function(t) { throw new Error(t); }); }
+    return r.json();
+  });
+}
+function esc(s) {
+  return String(s).replace(/&/g, "&amp;").replace(/</g, "&lt;");
+}
+function table(id, rows, cols) {
+  var html = "<tr>" + cols.map(function(c) { return "<th>" + c + "</th>"; }).join("") + "</tr>";
+  rows.forEach(function(r) {
+    html += "<tr>" + cols.map(function(c) { return "<td>" + esc(r[c]) + "</td>"; }).join("") + "</tr>";
+  });
+  document.getElementById(id).innerHTML = html;
+}
+function refresh() {
+  api("status").then(function(s) {
+    var rows = [["Name", s.Name], ["DNA Hash", s.DNAHash], ["Agent", s.Agent], ["Started", s.Started]];
+    Object.keys(s.Metrics).sort().forEach(function(k) { rows.push([k, s.Metrics[k]]); });
+    table("status", rows.map(function(r) { return {key: r[0], value: r[1]}; }), ["key", "value"]);
+  });
+  api("progress").then(function(p) { table("progress", p, ["Op", "Stage", "Percent"]); });
+  api("peers").then(function(p) { table("peers", p, ["Id", "Idx", "Score", "Banned"]); });
+  api("dht").then(function(d) { table("dht", d, ["Hash", "Type", "Source", "Status"]); });
+  api("logs").then(function(l) {
+    document.getElementById("logs").textContent = l.join("\n").replace(/\x1b\[[0-9;]*m/g, "");
+  });
+}
+function doCall() {
+  var req = {Zome: zome.value, Fn: fn.value, Arg: arg.value};
+  api("call", {method: "POST", body: JSON.stringify(req)}).then(function(r) {
+    document.getElementById("result").textContent = r.Result;
+  }, function(e) {
+    document.getElementById("result").textContent = "Error: " + e.message;
+  });
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,101 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements serving the public audit log of a chain whose app publishes one
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	holo "github.com/metacurrency/holochain"
+	"net/http"
+	"strconv"
+)
+
+// serveAudit adds the audit log's endpoints to the http server.  They need no token, as the
+// log is for third parties to check.
+func serveAudit(h *holo.Holochain) {
+	http.HandleFunc("/_audit/head", func(w http.ResponseWriter, r *http.Request) {
+		head, err := h.AuditHead()
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		writeJSON(w, head)
+	})
+
+	http.HandleFunc("/_audit/log", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := auditRange(h, r)
+		if err != nil {
+			auditError(w, err)
+			return
+		}
+		headers, err := h.AuditLogHeaders(from, to)
+		if err != nil {
+			auditError(w, err)
+			return
+		}
+		// headers are streamed one JSON object per line so long chains can be read as they come
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		for _, hd := range headers {
+			if err = enc.Encode(hd); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	})
+
+	http.HandleFunc("/_audit/proof", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := auditRange(h, r)
+		if err != nil {
+			auditError(w, err)
+			return
+		}
+		proof, err := h.AuditProof(from, to)
+		if err != nil {
+			auditError(w, err)
+			return
+		}
+		writeJSON(w, proof)
+	})
+}
+
+// auditError reports an error with an audit request, which is the request's fault unless the
+// app doesn't publish an audit log
+func auditError(w http.ResponseWriter, err error) {
+	code := 400
+	if err == holo.ErrAuditLogDisabled {
+		code = holo.StatusCode(err)
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// auditRange reads the lengths of the chain an audit request is from and to, to being the
+// chain's current length if not given
+func auditRange(h *holo.Holochain, r *http.Request) (from int, to int, err error) {
+	q := r.URL.Query()
+	if s := q.Get("from"); s != "" {
+		if from, err = strconv.Atoi(s); err != nil {
+			err = fmt.Errorf("bad from: %v", err)
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		if to, err = strconv.Atoi(s); err != nil {
+			err = fmt.Errorf("bad to: %v", err)
+		}
+		return
+	}
+	var head *holo.AuditHead
+	if head, err = h.AuditHead(); err != nil {
+		return
+	}
+	to = head.Length
+	return
+}
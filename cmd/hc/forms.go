@@ -0,0 +1,190 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the html forms, generated from entry schemas, that the hc serve command offers
+// for committing entries from a browser
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	holo "github.com/metacurrency/holochain"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var formsTemplate = template.Must(template.New("forms").Parse(`<html>
+<head><title>{{.Name}} forms</title></head>
+<body>
+<h1>{{.Name}}</h1>
+<ul>
+{{range .Forms}}<li><a href="{{.EntryType}}{{$.Query}}">{{.Title}}</a></li>
+{{else}}<li>no entry types can be committed from a form</li>
+{{end}}</ul>
+</body>
+</html>`))
+
+var formTemplate = template.Must(template.New("form").Parse(`<html>
+<head><title>{{.Form.Title}}</title></head>
+<body>
+<h1>{{.Form.Title}}</h1>
+{{if .Form.Description}}<p>{{.Form.Description}}</p>{{end}}
+{{if .Committed}}<p>committed {{.Committed}}</p>{{end}}
+<form method="POST" action="../_commit/{{.Form.EntryType}}{{.Query}}">
+<input type="hidden" name="{{.CSRFField}}" value="{{.CSRF}}">
+{{range .Form.Fields}}<p>
+<label for="{{.Name}}">{{.Label}}{{if .Required}} *{{end}}</label><br>
+{{if .Enum}}<select id="{{.Name}}" name="{{.Name}}">{{range .Enum}}<option>{{.}}</option>{{end}}</select>
+{{else if eq .Type "boolean"}}<input type="checkbox" id="{{.Name}}" name="{{.Name}}" value="true">
+{{else if eq .Type "integer"}}<input type="number" step="1" id="{{.Name}}" name="{{.Name}}"{{if .Required}} required{{end}}>
+{{else if eq .Type "number"}}<input type="number" step="any" id="{{.Name}}" name="{{.Name}}"{{if .Required}} required{{end}}>
+{{else if eq .Type "string"}}<input type="text" id="{{.Name}}" name="{{.Name}}"{{if .Required}} required{{end}}>
+{{else}}<textarea id="{{.Name}}" name="{{.Name}}" rows="6" cols="60"{{if .Required}} required{{end}}></textarea>
+{{end}}{{if .Description}}<br><small>{{.Description}}</small>{{end}}
+</p>
+{{end}}<input type="submit" value="Commit">
+</form>
+<p><a href="./{{.Query}}">all forms</a></p>
+</body>
+</html>`))
+
+// tokenQuery returns the query string that passes the request's token parameter on to links
+func tokenQuery(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return "?token=" + url.QueryEscape(t)
+	}
+	return ""
+}
+
+// csrfField is the form field that carries the token proving a post came from one of our forms
+const csrfField = "_csrf"
+
+// formsCSRF makes the csrf tokens the forms embed, keyed to the api token the form was fetched
+// with so that a token seen by one client can't be replayed by another
+type formsCSRF struct {
+	secret []byte
+}
+
+func newFormsCSRF() (c *formsCSRF, err error) {
+	c = &formsCSRF{secret: make([]byte, 32)}
+	_, err = rand.Read(c.secret)
+	return
+}
+
+// token returns the csrf token for forms served to the given request
+func (c *formsCSRF) token(r *http.Request) string {
+	m := hmac.New(sha256.New, c.secret)
+	m.Write([]byte(requestToken(r)))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// check reports whether a posted form carries the csrf token for its request
+func (c *formsCSRF) check(r *http.Request) bool {
+	return hmac.Equal([]byte(r.PostForm.Get(csrfField)), []byte(c.token(r)))
+}
+
+// devOnly wraps a handler so that it refuses requests unless the chain is in development mode,
+// checked per request as DevMode can change on a config reload
+func devOnly(fn func(http.ResponseWriter, *http.Request, *holo.Holochain)) func(http.ResponseWriter, *http.Request, *holo.Holochain) {
+	return func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		if !h.Config().DevMode {
+			http.Error(w, holo.ErrNotDevMode.Error(), holo.StatusCode(holo.ErrNotDevMode))
+			return
+		}
+		fn(w, r, h)
+	}
+}
+
+// serveForms adds the entry forms, and the endpoint they commit entries through, to the http
+// server. Both are only available in development mode, and a form post must carry the csrf
+// token of the form it came from; scripts post JSON instead, which browsers won't send cross
+// site without a preflight.
+func serveForms(h *holo.Holochain, s *holo.Service) {
+	csrf, err := newFormsCSRF()
+	if err != nil {
+		errs.Logf("unable to make csrf secret, entry forms disabled: %v", err)
+		return
+	}
+	http.HandleFunc("/forms/", apiAuth(h, s, devOnly(func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		entryType := strings.TrimPrefix(r.URL.Path, "/forms/")
+		w.Header().Set("Content-Type", "text/html")
+		if entryType == "" {
+			forms, err := h.EntryForms()
+			if err != nil {
+				http.Error(w, err.Error(), holo.StatusCode(err))
+				return
+			}
+			formsTemplate.Execute(w, map[string]interface{}{"Name": h.Name, "Forms": forms, "Query": template.URL(tokenQuery(r))})
+			return
+		}
+		f, err := h.EntryForm(entryType)
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		formTemplate.Execute(w, map[string]interface{}{"Form": f, "Committed": r.URL.Query().Get("committed"), "Query": template.URL(tokenQuery(r)), "CSRFField": csrfField, "CSRF": csrf.token(r)})
+	})))
+
+	http.HandleFunc("/_commit/", apiAuth(h, s, devOnly(func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		if r.Method != "POST" {
+			http.Error(w, "commit requires POST", 405)
+			return
+		}
+		entryType := strings.TrimPrefix(r.URL.Path, "/_commit/")
+		var content string
+		fromForm := !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+		if fromForm {
+			f, err := h.EntryForm(entryType)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err = r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if !csrf.check(r) {
+				http.Error(w, "missing or invalid csrf token", 403)
+				return
+			}
+			if content, err = f.Content(r.PostForm); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		} else {
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			content = string(b)
+		}
+		hash, err := h.Commit(entryType, content)
+		if err == nil {
+			err = h.DHT().SendPut(hash)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		log.Logf("committed %s from form: %v\n", entryType, hash)
+		if fromForm {
+			q := tokenQuery(r)
+			if q == "" {
+				q = "?"
+			} else {
+				q += "&"
+			}
+			http.Redirect(w, r, "../forms/"+entryType+q+"committed="+hash.String(), http.StatusSeeOther)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"Hash": hash.String()})
+	})))
+}
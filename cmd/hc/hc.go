@@ -1,18 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	holo "github.com/metacurrency/holochain"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
 	"os"
-	"os/user"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 )
 
-var uninitialized error
 var initialized bool
 
 var verbose bool
@@ -26,6 +30,54 @@ func setupApp() (app *cli.App) {
 
 	var force bool
 	var root string
+	var dirs holo.ChainDirs
+	var asAgent string
+	var provenance bool
+	var seedOut, seedVerify string
+	var resetTo string
+	var fromPeer, joinDNA string
+	var coverage bool
+	var fastSync string
+	var fastSyncSample int
+	var liveness bool
+	var registry string
+	var tokenOrigin, tokenFns, tokenEntries, tokenName string
+	var follow bool
+	var dumpFormat string
+	var retention string
+	var purgeInvalid bool
+	var revalidateSince string
+	var dhtDumpType, dhtDumpStatus, dhtDumpFormat string
+	var template bool
+	var answers string
+	var deferPublish bool
+	var socket, basePath string
+	var open bool
+	var dryRun bool
+	var appHash string
+	var appTemplate string
+	var checkNotarized bool
+	var backupInterval string
+	var verifyRestore bool
+	var statsHistory bool
+	var statsDays int
+	var inviteToken string
+	var identityToken, identityCommand string
+	var topInterval string
+	var topOnce bool
+	var exportType string
+	var systemService bool
+	var serviceUser string
+	var keyAlgorithm, keySeed, keyDir string
+	var keyBits int
+	var sandboxNodes, sandboxPort int
+	var trace bool
+	var loop bool
+	var loadRate float64
+	var loadCalls, loadConcurrency int
+	var loadDuration string
+	var nonInteractive, encryptChains bool
+	var bootstrapServer string
 	var service *holo.Service
 
 	app.Flags = []cli.Flag{
@@ -41,7 +93,7 @@ func setupApp() (app *cli.App) {
 		},
 		cli.StringFlag{
 			Name:        "path",
-			Usage:       "path to holochain directory (default: ~/.holochain)",
+			Usage:       "path to holochain directory (default: $HOLOPATH, ~/.holochain if it exists, or the XDG config and data directories)",
 			Destination: &root,
 		},
 	}
@@ -54,12 +106,27 @@ func setupApp() (app *cli.App) {
 					Usage:       "overwrite existing holochain",
 					Destination: &force,
 				},
+				cli.BoolFlag{
+					Name:        "template",
+					Usage:       "fill in {{NAME}} placeholders in the source's DNA and config",
+					Destination: &template,
+				},
+				cli.StringSliceFlag{
+					Name:  "var",
+					Usage: "template variable as NAME=value, may be repeated",
+				},
+				cli.StringFlag{
+					Name:        "answers",
+					Usage:       "file of template variable values, in any DNA format",
+					Destination: &answers,
+				},
 			},
 			Name:      "clone",
 			Aliases:   []string{"c"},
 			Usage:     "clone a holochain instance from a source",
 			ArgsUsage: "src-path holochain-name",
 			Action: func(c *cli.Context) error {
+				service.OnProgress = showProgress
 				srcPath := c.Args().First()
 				if srcPath == "" {
 					return errors.New("clone: missing required source path argument")
@@ -69,12 +136,23 @@ func setupApp() (app *cli.App) {
 				}
 				name := c.Args()[1]
 				if force {
-					e := os.RemoveAll(root + "/" + name)
+					e := os.RemoveAll(dirs.ChainPath(name))
 					if e != nil {
 						return e
 					}
 				}
-				h, err := service.Clone(srcPath, root+"/"+name, true)
+				var h *holo.Holochain
+				var err error
+				if template {
+					var vars map[string]string
+					vars, err = templateVars(answers, c.StringSlice("var"))
+					if err != nil {
+						return err
+					}
+					h, err = service.CloneTemplate(srcPath, dirs.ChainPath(name), vars)
+				} else {
+					h, err = service.Clone(srcPath, dirs.ChainPath(name), true)
+				}
 				if err == nil {
 					if verbose {
 						fmt.Printf("cloned %s from %s with new id: %v\n", name, srcPath, h.Id)
@@ -84,11 +162,55 @@ func setupApp() (app *cli.App) {
 			},
 		},
 		{
-			Name:      "join",
-			Aliases:   []string{"c"},
+			Name:    "join",
+			Aliases: []string{"c"},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "from-peer",
+					Usage:       "fetch the DNA from a running node at <multiaddr>/ipfs/<peer-id> instead of a source path",
+					Destination: &fromPeer,
+				},
+				cli.StringFlag{
+					Name:        "dna",
+					Usage:       "the expected DNA hash, required with --from-peer",
+					Destination: &joinDNA,
+				},
+				cli.StringFlag{
+					Name:        "invite",
+					Usage:       "the invite to present if the chain's DNA requires one to join (see: hc invite)",
+					Destination: &inviteToken,
+				},
+				cli.StringFlag{
+					Name:        "identity",
+					Usage:       "the identity assertion to present if the chain's DNA requires one, e.g. an OIDC ID token or a signed record (see: hc identity)",
+					Destination: &identityToken,
+				},
+			},
 			Usage:     "joins a holochain by copying an instance from a source and generating genesis blocks",
-			ArgsUsage: "src-path holochain-name",
+			ArgsUsage: "src-path holochain-name, or holochain-name with --from-peer",
 			Action: func(c *cli.Context) error {
+				service.OnProgress = showProgress
+				if fromPeer != "" {
+					name, err := checkForName(c, "join")
+					if err != nil {
+						return err
+					}
+					if joinDNA == "" {
+						return errors.New("join: --dna hash required with --from-peer")
+					}
+					expected, err := holo.NewHash(joinDNA)
+					if err != nil {
+						return err
+					}
+					_, err = service.JoinFromPeer(fromPeer, expected, dirs.ChainPath(name))
+					if err == nil {
+						if verbose {
+							fmt.Printf("joined %s from peer %s\n", name, fromPeer)
+						}
+						err = genChain(service, name, inviteToken, identityToken)
+					}
+					return err
+				}
 				srcPath := c.Args().First()
 				if srcPath == "" {
 					return errors.New("join: missing required source path argument")
@@ -97,38 +219,229 @@ func setupApp() (app *cli.App) {
 					return errors.New("join: missing required holochain-name argument")
 				}
 				name := c.Args()[1]
-				_, err := service.Clone(srcPath, root+"/"+name, false)
+				_, err := service.Clone(srcPath, dirs.ChainPath(name), false)
 				if err == nil {
 					if verbose {
 						fmt.Printf("joined %s from %s\n", name, srcPath)
 					}
-					err = genChain(service, name)
+					err = genChain(service, name, inviteToken, identityToken)
 				}
 				return err
 			},
 		},
 		{
-			Name:      "seed",
-			Usage:     "seed calculates DNA hashes and builds DNA file without generating genesis entries.  Useful only for testing and development.",
+			Name:  "search",
+			Usage: "search an app registry",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "registry",
+					Usage:       "url of the app registry index (default: the service's DefaultRegistry)",
+					Destination: &registry,
+				},
+			},
+			ArgsUsage: "[query]",
+			Action: func(c *cli.Context) error {
+				if !initialized {
+					return holo.ErrNotInitialized
+				}
+				index, err := holo.FetchRegistryIndex(registryURL(service, registry))
+				if err != nil {
+					return err
+				}
+				apps := index.Search(strings.Join(c.Args(), " "))
+				if len(apps) == 0 {
+					fmt.Println("no apps found")
+				}
+				for _, a := range apps {
+					fmt.Printf("%s %s %s\n    %s\n", a.Id, a.Version, a.Name, a.Description)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "install",
+			Usage: "install an app from a registry, verifying its DNA hash, and generate genesis blocks",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "registry",
+					Usage:       "url of the app registry index (default: the service's DefaultRegistry)",
+					Destination: &registry,
+				},
+			},
+			ArgsUsage: "app-id [holochain-name]",
+			Action: func(c *cli.Context) error {
+				if !initialized {
+					return holo.ErrNotInitialized
+				}
+				id := c.Args().First()
+				if id == "" {
+					return errors.New("install: missing required app-id argument")
+				}
+				name := id
+				if len(c.Args()) > 1 {
+					name = c.Args()[1]
+				}
+				index, err := holo.FetchRegistryIndex(registryURL(service, registry))
+				if err != nil {
+					return err
+				}
+				_, err = service.Install(index, id, dirs.ChainPath(name))
+				if err == nil {
+					if verbose {
+						fmt.Printf("installed %s as %s\n", id, name)
+					}
+					err = genChain(service, name, "", "")
+				}
+				return err
+			},
+		},
+		{
+			Name:      "bundle",
+			Usage:     "write a chain's DNA bundle, with a manifest of its files signed by the chain's agent, for publishing in a registry",
+			ArgsUsage: "holochain-name bundle-file",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "bundle")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("bundle: missing required bundle-file argument")
+				}
+				b, err := h.SignedBundle()
+				if err != nil {
+					return err
+				}
+				data, err := json.Marshal(b)
+				if err != nil {
+					return err
+				}
+				if err = ioutil.WriteFile(c.Args()[1], data, 0644); err != nil {
+					return err
+				}
+				fmt.Printf("wrote bundle to %s, list it in the registry with Publisher: %s\n", c.Args()[1], h.NodeIDStr())
+				return nil
+			},
+		},
+		{
+			Name:  "verify-app",
+			Usage: "check an app's DNA bundle for problems before installing it",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "hash",
+					Usage:       "also check that the DNA builds to this hash",
+					Destination: &appHash,
+				},
+			},
+			ArgsUsage: "bundle-file-url-or-dir",
+			Action: func(c *cli.Context) error {
+				src := c.Args().First()
+				if src == "" {
+					return errors.New("verify-app: missing required bundle argument")
+				}
+				var expected holo.Hash
+				var err error
+				if appHash != "" {
+					if expected, err = holo.NewHash(appHash); err != nil {
+						return err
+					}
+				}
+				b, err := holo.ReadBundle(src)
+				if err != nil {
+					return err
+				}
+				problems, err := holo.VerifyApp(b, expected)
+				if err != nil {
+					return err
+				}
+				for _, p := range problems {
+					fmt.Println(p)
+				}
+				if n := problems.Errors(); n > 0 {
+					return fmt.Errorf("verify-app: found %d errors", n)
+				}
+				fmt.Printf("no errors found (%d warnings)\n", len(problems))
+				return nil
+			},
+		},
+		{
+			Name:      "check",
+			Usage:     "check that an installed chain's zomes load, its schemas compile and its genesis runs",
 			ArgsUsage: "holochain-name",
 			Action: func(c *cli.Context) error {
-				h, err := getHolochain(c, service, "seed")
+				name, err := checkForName(c, "check")
+				if err != nil {
+					return err
+				}
+				problems, err := service.Check(name)
 				if err != nil {
 					return err
 				}
-				err = h.GenDNAHashes()
+				for _, p := range problems {
+					fmt.Println(p)
+				}
+				if n := problems.Errors(); n > 0 {
+					return fmt.Errorf("check: found %d errors", n)
+				}
+				fmt.Printf("no errors found (%d warnings)\n", len(problems))
+				return nil
+			},
+		},
+		{
+			Name: "seed",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "out",
+					Usage:       "directory to write the encoded DNA and its hash to",
+					Destination: &seedOut,
+				},
+				cli.StringFlag{
+					Name:        "verify",
+					Usage:       "check the built DNA against a published hash, or a file containing one",
+					Destination: &seedVerify,
+				},
+			},
+			Usage:     "seed calculates DNA hashes and builds DNA file without generating genesis entries.  Useful only for testing and development.",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "seed")
 				if err != nil {
 					return err
 				}
-				var buf bytes.Buffer
-				err = h.EncodeDNA(&buf)
+				dna, hash, err := h.BuildDNA()
 				if err != nil {
 					return err
 				}
-				e := holo.GobEntry{C: buf.Bytes()}
-				hash, err := e.Sum(h.HashSpec())
 				fmt.Printf("holochain id:%v\n", hash)
-				return err
+				if seedOut != "" {
+					if err = os.MkdirAll(seedOut, os.ModePerm); err != nil {
+						return err
+					}
+					dnaFile := filepath.Join(seedOut, holo.DNAFileName+"."+h.EncodingFormat())
+					if err = ioutil.WriteFile(dnaFile, dna, 0644); err != nil {
+						return err
+					}
+					hashFile := filepath.Join(seedOut, holo.DNAHashFileName)
+					if err = ioutil.WriteFile(hashFile, []byte(hash.String()), 0644); err != nil {
+						return err
+					}
+					fmt.Printf("wrote %s and %s\n", dnaFile, hashFile)
+				}
+				if seedVerify != "" {
+					expected := seedVerify
+					if b, e := ioutil.ReadFile(seedVerify); e == nil {
+						expected = strings.TrimSpace(string(b))
+					}
+					var expectedHash holo.Hash
+					expectedHash, err = holo.NewHash(expected)
+					if err != nil {
+						return err
+					}
+					if _, err = h.VerifyDNA(expectedHash); err != nil {
+						return fmt.Errorf("DNA verification failed: %v, built %v, expected %v", err, hash, expectedHash)
+					}
+					fmt.Printf("DNA verified\n")
+				}
+				return nil
 			},
 		},
 		{
@@ -139,6 +452,11 @@ func setupApp() (app *cli.App) {
 					Usage:       "overwrite existing holochain",
 					Destination: &force,
 				},
+				cli.StringFlag{
+					Name:        "template",
+					Usage:       "start from an app template, one of: " + strings.Join(holo.AppTemplateNames(), ", "),
+					Destination: &appTemplate,
+				},
 			},
 			Aliases:   []string{"d"},
 			Usage:     "generate a default configuration files, suitable for editing",
@@ -151,17 +469,22 @@ func setupApp() (app *cli.App) {
 				format := "toml"
 				if len(c.Args()) == 2 {
 					format = c.Args()[1]
-					if !(format == "json" || format == "yaml" || format == "toml") {
-						return errors.New("gen dev: format must be one of yaml,toml,json")
+					if !holo.IsCodecFormat(format) {
+						return errors.New("gen dev: format must be one of " + strings.Join(holo.CodecFormats(), ","))
 					}
 				}
 				if force {
-					e := os.RemoveAll(root + "/" + name)
+					e := os.RemoveAll(dirs.ChainPath(name))
 					if e != nil {
 						return e
 					}
 				}
-				h, err := service.GenDev(root+"/"+name, format)
+				var h *holo.Holochain
+				if appTemplate != "" {
+					h, err = service.GenDevTemplate(dirs.ChainPath(name), format, appTemplate)
+				} else {
+					h, err = service.GenDev(dirs.ChainPath(name), format)
+				}
 				if err == nil {
 					if verbose {
 						fmt.Printf("created %s with new id: %v\n", name, h.Id)
@@ -206,39 +529,229 @@ func setupApp() (app *cli.App) {
 					Aliases:   []string{"c"},
 					Usage:     "generate the genesis blocks from the configuration and keys",
 					ArgsUsage: "holochain-name",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "defer-publish",
+							Usage:       "create the genesis entries offline without announcing them to the DHT (see: hc publish)",
+							Destination: &deferPublish,
+						},
+						cli.StringFlag{
+							Name:        "invite",
+							Usage:       "the invite to present if the chain's DNA requires one to join (see: hc invite)",
+							Destination: &inviteToken,
+						},
+						cli.StringFlag{
+							Name:        "identity",
+							Usage:       "the identity assertion to present if the chain's DNA requires one, e.g. an OIDC ID token or a signed record (see: hc identity)",
+							Destination: &identityToken,
+						},
+					},
 					Action: func(c *cli.Context) error {
 						name, err := checkForName(c, "gen chain")
 						if err != nil {
 							return err
 						}
+						service.OnProgress = showProgress
 
-						err = genChain(service, name)
+						if deferPublish {
+							err = genChainDeferred(service, name, inviteToken, identityToken)
+						} else {
+							err = genChain(service, name, inviteToken, identityToken)
+						}
 						return err
 					},
 				},
 			},
 		},
+		{
+			Name:      "keygen",
+			Usage:     "generate an agent key, printing its fingerprints, and optionally write it to an agent directory",
+			ArgsUsage: "[agent-id]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "algo",
+					Usage:       "key algorithm: " + strings.Join(holo.KeyAlgorithmNames(), ", "),
+					Value:       holo.DefaultKeyAlgorithm,
+					Destination: &keyAlgorithm,
+				},
+				cli.IntFlag{
+					Name:        "bits",
+					Usage:       "size of rsa keys",
+					Value:       holo.DefaultRSABits,
+					Destination: &keyBits,
+				},
+				cli.StringFlag{
+					Name:        "seed",
+					Usage:       "derive the key from a seed or mnemonic phrase, for reproducible test identities only",
+					Destination: &keySeed,
+				},
+				cli.StringFlag{
+					Name:        "dir",
+					Usage:       "agent directory to write the agent-id and key to, e.g. a service's config directory",
+					Destination: &keyDir,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				agent := c.Args().First()
+				if keyDir != "" && agent == "" {
+					return errors.New("keygen: missing required agent-id argument to write the key to a directory")
+				}
+				priv, err := holo.GenerateKey(keyAlgorithm, keyBits, keySeed)
+				if err != nil {
+					return err
+				}
+				f, err := holo.Fingerprints(priv.GetPublic())
+				if err != nil {
+					return err
+				}
+				if keyDir != "" {
+					for _, f := range []string{holo.AgentFileName, holo.PrivKeyFileName} {
+						if _, err = os.Stat(filepath.Join(keyDir, f)); err == nil {
+							return fmt.Errorf("keygen: %s already holds an agent", keyDir)
+						}
+					}
+					if err = os.MkdirAll(keyDir, os.ModePerm); err != nil {
+						return err
+					}
+					if err = holo.SaveAgent(keyDir, holo.NewAgentWithKey(holo.AgentName(agent), priv)); err != nil {
+						return err
+					}
+					fmt.Printf("wrote key of %s to %s\n", agent, keyDir)
+				}
+				fmt.Printf("node id: %s\n", f.NodeID)
+				fmt.Printf("base58:  %s\n", f.Base58)
+				fmt.Printf("base64:  %s\n", f.Base64)
+				fmt.Printf("sha256:  %s\n", f.SHA256)
+				return nil
+			},
+		},
+		{
+			Name:      "sandbox",
+			Usage:     "run several nodes of an app on this machine, each with its own agent, until interrupted",
+			ArgsUsage: "[installed-chain-name | app-directory]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:        "n, nodes",
+					Usage:       "number of nodes to run",
+					Value:       holo.DefaultSandboxNodes,
+					Destination: &sandboxNodes,
+				},
+				cli.IntFlag{
+					Name:        "port",
+					Usage:       "port to serve the first node on, the rest being served on the ports after it",
+					Value:       holo.DefaultSandboxPort,
+					Destination: &sandboxPort,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				app := c.Args().First()
+				var installed string
+				if initialized && app != "" {
+					installed = dirs.ChainPath(app)
+				}
+				return runSandbox(installed, app, sandboxNodes, sandboxPort)
+			},
+		},
 		{
 			Name:      "init",
 			Aliases:   []string{"i"},
-			Usage:     "bootstrap the holochain service",
-			ArgsUsage: "agent-id",
+			Usage:     "bootstrap the holochain service, or check and repair an existing one",
+			ArgsUsage: "[agent-id]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "non-interactive",
+					Usage:       "don't ask any questions, taking all answers from the arguments and flags",
+					Destination: &nonInteractive,
+				},
+				cli.StringFlag{
+					Name:        "bootstrap",
+					Usage:       "default bootstrap server for new chains",
+					Destination: &bootstrapServer,
+				},
+				cli.StringFlag{
+					Name:        "registry",
+					Usage:       "url of the app registry index",
+					Destination: &registry,
+				},
+				cli.BoolFlag{
+					Name:        "encrypt-chains",
+					Usage:       "keep the source chains of new chains encrypted with a passphrase",
+					Destination: &encryptChains,
+				},
+				cli.StringFlag{
+					Name:        "identity-command",
+					Usage:       "command printing the agent's identity assertion when joining chains that require one, e.g. from the organization's OIDC provider or LDAP",
+					Destination: &identityCommand,
+				},
+			},
 			Action: func(c *cli.Context) error {
 				agent := c.Args().First()
-				if agent == "" {
-					return errors.New("missing required agent-id argument to init")
-				}
-				_, err := holo.Init(root, holo.AgentName(agent))
-				if err == nil {
+				interactive := !nonInteractive && agent == ""
+				in := bufio.NewReader(os.Stdin)
+
+				var s *holo.Service
+				var err error
+				if dirs.HasService() {
+					if interactive {
+						ok, err := confirm(in, fmt.Sprintf("a holochain service already exists in %s, check and repair it?", dirs.Config), true)
+						if err != nil || !ok {
+							return err
+						}
+					}
+					var repairs []string
+					s, repairs, err = holo.RepairDirs(dirs, holo.AgentName(agent))
+					if err != nil {
+						return err
+					}
+					if len(repairs) == 0 {
+						fmt.Println("Holochain service already initialized, nothing to repair")
+					} else {
+						fmt.Println("Holochain service repaired:")
+						for _, r := range repairs {
+							fmt.Printf("    %s\n", r)
+						}
+					}
+				} else {
+					settings := holo.DefaultServiceConfig()
+					if interactive {
+						agent, err = initWizard(in, &settings)
+						if err != nil {
+							return err
+						}
+					}
+					if agent == "" {
+						return errors.New("missing required agent-id argument to init")
+					}
+					s, err = holo.InitDirs(dirs, holo.AgentName(agent))
+					if err != nil {
+						return err
+					}
+					s.Settings = settings
 					fmt.Println("Holochain service initialized")
 					if verbose {
-						fmt.Println("    ~/.holochain directory created")
+						if dirs.Config == dirs.Data {
+							fmt.Printf("    %s directory created\n", dirs.Data)
+						} else {
+							fmt.Printf("    %s and %s directories created\n", dirs.Config, dirs.Data)
+						}
 						fmt.Printf("    defaults stored to %s\n", holo.SysFileName)
 						fmt.Println("    key-pair generated")
 						fmt.Printf("    default agent stored to %s\n", holo.AgentFileName)
 					}
 				}
-				return err
+				if bootstrapServer != "" {
+					s.Settings.DefaultBootstrapServer = bootstrapServer
+				}
+				if registry != "" {
+					s.Settings.DefaultRegistry = registry
+				}
+				if encryptChains {
+					s.Settings.DefaultEncryptChain = true
+				}
+				if identityCommand != "" {
+					s.Settings.IdentityCommand = identityCommand
+				}
+				return s.SaveSettings()
 			},
 		},
 		{
@@ -246,6 +759,19 @@ func setupApp() (app *cli.App) {
 			Aliases:   []string{"d"},
 			Usage:     "display a text dump of a chain",
 			ArgsUsage: "holochain-name",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "follow",
+					Usage:       "keep running as a node, printing entries as they are committed or received into the DHT",
+					Destination: &follow,
+				},
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "text, or dot or mermaid for a graph of the chain's headers, type links and app links",
+					Value:       "text",
+					Destination: &dumpFormat,
+				},
+			},
 			Action: func(c *cli.Context) error {
 				h, err := getHolochain(c, service, "dump")
 				if err != nil {
@@ -255,164 +781,1445 @@ func setupApp() (app *cli.App) {
 				if !h.Started() {
 					return errors.New("No data to dump, chain not yet initialized.")
 				}
+				if dumpFormat != "text" {
+					if follow {
+						return errors.New("dump: --follow only works with the text format")
+					}
+					g, err := h.Graph()
+					if err != nil {
+						return err
+					}
+					return g.Write(os.Stdout, dumpFormat)
+				}
 				dnaHash := h.DNAHash()
 
 				fmt.Printf("Chain: %s\n", dnaHash)
 
-				links := make(map[string]holo.Header)
-				index := make(map[int]string)
-				entries := make(map[int]interface{})
-				idx := 0
-				err = h.Walk(func(key *holo.Hash, header *holo.Header, entry holo.Entry) (err error) {
-					ks := (*key).String()
-					index[idx] = ks
-					links[ks] = *header
-					entries[idx] = entry
-					idx++
-					return nil
-				}, true)
-
-				for i := 0; i < idx; i++ {
-					k := index[i]
-					hdr := links[k]
-					fmt.Printf("%s:%s @ %v\n", hdr.Type, k, hdr.Time)
-					fmt.Printf("    Next Header: %v\n", hdr.HeaderLink)
-					fmt.Printf("    Next %s: %v\n", hdr.Type, hdr.TypeLink)
-					fmt.Printf("    Entry: %v\n", hdr.EntryLink)
-					e := entries[i]
-					switch hdr.Type {
-					case holo.KeyEntryType:
-						fmt.Printf("       %v\n", e.(*holo.GobEntry).C)
-					case holo.DNAEntryType:
-						fmt.Printf("       %s\n", e.(*holo.GobEntry).C)
-					case holo.AgentEntryType:
-						fmt.Printf("       %v\n", e.(*holo.GobEntry).C.(holo.AgentEntry))
-					default:
-						fmt.Printf("       %v\n", e)
+				it, err := h.Iterator(true)
+				if err != nil {
+					return err
+				}
+				for it.Next() {
+					e, err := it.Entry()
+					if err != nil {
+						it.Close()
+						return err
 					}
+					dumpHeader(it.Hash().String(), it.Header(), e)
+				}
+				it.Close()
+				if err = it.Err(); err != nil {
+					return err
+				}
+				if follow {
+					return followChain(h)
 				}
 				return nil
 			},
 		},
 		{
+			Name:      "export",
+			Usage:     "print the chain's entries as signed JSON-LD verifiable credentials",
+			ArgsUsage: "holochain-name",
 			Flags: []cli.Flag{
-				cli.BoolFlag{
-					Name:        "force",
-					Usage:       "overwrite existing holochain",
-					Destination: &force,
+				cli.StringFlag{
+					Name:        "type",
+					Usage:       "only export entries of this type",
+					Destination: &exportType,
 				},
 			},
-			Name:    "test",
-			Aliases: []string{"t"},
-			Usage:   "run validation against test data for a chain in development",
 			Action: func(c *cli.Context) error {
-				h, err := getHolochain(c, service, "test")
+				h, err := getHolochain(c, service, "export")
 				if err != nil {
 					return err
 				}
-				if force {
-					err = h.Reset()
-					if err != nil {
-						return err
-					}
+				if !h.Started() {
+					return errors.New("No data to export, chain not yet initialized.")
 				}
-				err = h.Activate()
+				creds, err := h.ExportCredentials(exportType)
 				if err != nil {
 					return err
 				}
-				var errs = h.Test()
-				var s string
-				for _, e := range errs {
-					s += e.Error()
+				if creds == nil {
+					creds = []*holo.Credential{}
 				}
-				return errors.New(s)
+				b, err := json.MarshalIndent(creds, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(b))
+				return nil
 			},
 		},
 		{
-			Name:    "status",
-			Aliases: []string{"s"},
-			Usage:   "display information about installed chains",
+			Name:      "verify-credential",
+			Usage:     "check the signature of a credential exported by hc export",
+			ArgsUsage: "credential-file",
 			Action: func(c *cli.Context) error {
-				if !initialized {
-					return uninitialized
+				if len(c.Args()) != 1 {
+					return errors.New("verify-credential: expected credential-file argument")
+				}
+				b, err := ioutil.ReadFile(c.Args()[0])
+				if err != nil {
+					return err
+				}
+				cred, err := holo.VerifyCredential(b)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("credential %s verified, issued by %s\n", cred.ID, cred.Issuer)
+				return nil
+			},
+		},
+		{
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "force",
+					Usage:       "overwrite existing holochain",
+					Destination: &force,
+				},
+				cli.BoolFlag{
+					Name:        "coverage",
+					Usage:       "report which zome functions and validation outcomes the tests exercised",
+					Destination: &coverage,
+				},
+			},
+			Name:    "test",
+			Aliases: []string{"t"},
+			Usage:   "run validation against test data for a chain in development",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "test")
+				if err != nil {
+					return err
+				}
+				if force {
+					err = h.Reset()
+					if err != nil {
+						return err
+					}
+				}
+				err = h.Activate()
+				if err != nil {
+					return err
+				}
+				if coverage {
+					h.EnableCoverage()
+				}
+				var errs = h.Test()
+				if coverage {
+					if err = printCoverage(h); err != nil {
+						return err
+					}
+				}
+				var s string
+				for _, e := range errs {
+					s += e.Error()
+				}
+				return errors.New(s)
+			},
+		},
+		{
+			Name:      "record",
+			Usage:     "record zome calls made on a chain in development as a test file for hc test",
+			ArgsUsage: "holochain-name test-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "record")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("record: missing required test-name argument")
+				}
+				err = h.Activate()
+				if err != nil {
+					return err
+				}
+				r, err := h.Record(c.Args()[1])
+				if err != nil {
+					return err
+				}
+				return recordCalls(r)
+			},
+		},
+		{
+			Name:      "sim",
+			Usage:     "run a network simulation of in-process nodes from a scenario file",
+			ArgsUsage: "scenario-file",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return errors.New("sim: missing required scenario-file argument")
+				}
+				sc, err := holo.LoadSimScenario(c.Args()[0])
+				if err != nil {
+					return err
+				}
+				r, err := holo.RunSimulation(sc)
+				if err != nil {
+					return err
+				}
+				fmt.Print(r.String())
+				if len(r.Failures) > 0 {
+					return errors.New("simulation failed")
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "status",
+			Aliases: []string{"s"},
+			Usage:   "display information about installed chains",
+			Action: func(c *cli.Context) error {
+				if !initialized {
+					return holo.ErrNotInitialized
 				}
 				listChains(service)
 				return nil
 			},
 		},
 		{
-			Name:      "call",
-			Aliases:   []string{"c"},
-			Usage:     "call an exposed function",
-			ArgsUsage: "holochain-name zome-name function args",
+			Name:      "doctor",
+			Usage:     "check a chain's health and whether this node's clock agrees with its peers'",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "doctor")
+				if err != nil {
+					return err
+				}
+				fmt.Println(h.Name)
+				printHealth(h)
+				return printClock(h)
+			},
+		},
+		{
+			Name:      "stats",
+			Usage:     "show a chain's activity today, or day by day with --history",
+			ArgsUsage: "holochain-name",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "history",
+					Usage:       "show the activity of each day, oldest first",
+					Destination: &statsHistory,
+				},
+				cli.IntFlag{
+					Name:        "days",
+					Usage:       "how many days of history to show, or 0 for every day since the first recorded",
+					Value:       30,
+					Destination: &statsDays,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "stats")
+				if err != nil {
+					return err
+				}
+				days := 1
+				if statsHistory {
+					days = statsDays
+				}
+				history, err := h.StatsHistory(days)
+				if err != nil {
+					return err
+				}
+				if !statsHistory {
+					printDayStats(&history[len(history)-1])
+					return nil
+				}
+				printStatsHistory(history)
+				return nil
+			},
+		},
+		{
+			Name:  "top",
+			Usage: "show the resource usage of the chains being served in a refreshing table",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "interval",
+					Usage:       "how often to refresh the table",
+					Value:       "2s",
+					Destination: &topInterval,
+				},
+				cli.BoolFlag{
+					Name:        "once",
+					Usage:       "print the table once, after measuring a single interval",
+					Destination: &topOnce,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if !initialized {
+					return holo.ErrNotInitialized
+				}
+				interval, err := time.ParseDuration(topInterval)
+				if err != nil {
+					return err
+				}
+				if interval <= 0 {
+					return errors.New("top: interval must be positive")
+				}
+				return top(service, interval, topOnce)
+			},
+		},
+		{
+			Name:    "call",
+			Aliases: []string{"c"},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "as",
+					Usage:       "make the call as a locally provisioned agent (development mode only)",
+					Destination: &asAgent,
+				},
+				cli.BoolFlag{
+					Name:        "trace",
+					Usage:       "print each host function call made by the zome code, with its arguments and duration",
+					Destination: &trace,
+				},
+				cli.BoolFlag{
+					Name:        "loop",
+					Usage:       "call the function repeatedly to generate load, replacing {n}, {rand} and {time} in the args, and report on the latencies",
+					Destination: &loop,
+				},
+				cli.Float64Flag{
+					Name:        "rate",
+					Usage:       "calls per second with --loop",
+					Value:       holo.DefaultLoadRate,
+					Destination: &loadRate,
+				},
+				cli.IntFlag{
+					Name:        "count",
+					Usage:       "number of calls to make with --loop, until interrupted if 0",
+					Destination: &loadCalls,
+				},
+				cli.StringFlag{
+					Name:        "duration",
+					Usage:       "how long to call for with --loop, e.g. 30s",
+					Destination: &loadDuration,
+				},
+				cli.IntFlag{
+					Name:        "concurrency",
+					Usage:       "most calls in progress at once with --loop",
+					Value:       holo.DefaultLoadConcurrency,
+					Destination: &loadConcurrency,
+				},
+			},
+			Usage:     "call an exposed function",
+			ArgsUsage: "holochain-name zome-name function args",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "call")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 3 {
+					return errors.New("call: missing required zome-name and function arguments")
+				}
+				if asAgent != "" {
+					h, err = h.AsAgent(holo.AgentName(asAgent))
+					if err != nil {
+						return err
+					}
+					defer h.CloseAgent()
+					if verbose {
+						fmt.Printf("calling as agent: %s\n", asAgent)
+					}
+				}
+				zome := c.Args()[1]
+				function := c.Args()[2]
+				args := c.Args()[3:]
+				if loop {
+					return runLoad(h, holo.LoadOptions{Zome: zome, Function: function, Args: strings.Join(args, " "), Rate: loadRate, Calls: loadCalls, Concurrency: loadConcurrency}, loadDuration)
+				}
+				fmt.Printf("calling %s on zome %s with params %v\n", function, zome, args)
+				if trace {
+					h.SetTracer(func(c holo.HostCall) {
+						fmt.Printf("trace: %v\n", c)
+					})
+				}
+				result, err := h.Call(zome, function, strings.Join(args, " "))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%v\n", result)
+				return nil
+			},
+		},
+		{
+			Name:      "commit",
+			Usage:     "validate and commit an entry directly, without a zome function",
+			ArgsUsage: "holochain-name entry-type content",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "commit")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 3 {
+					return errors.New("commit: missing required entry-type and content arguments")
+				}
+				if err = goOnline(h); err != nil {
+					return err
+				}
+				hash, err := h.Commit(c.Args()[1], strings.Join(c.Args()[2:], " "))
+				if err == nil {
+					// a put that can't be sent now stays in the outbox for the next run
+					err = h.DHT().SendPut(hash)
+				}
+				if e := h.Shutdown(holo.DefaultShutdownTimeout); e != nil && err == nil {
+					err = e
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%v\n", hash)
+				return nil
+			},
+		},
+		{
+			Name: "load",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "dry-run",
+					Usage:       "only validate the entries, without committing them",
+					Destination: &dryRun,
+				},
+			},
+			Usage:     "validate, commit and publish the entries in a directory of JSON files named after their entry types",
+			ArgsUsage: "holochain-name data-dir",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "load")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("load: missing required data-dir argument")
+				}
+				if !dryRun {
+					if err = goOnline(h); err != nil {
+						return err
+					}
+				}
+				h.SetProgress(showProgress)
+				n, err := h.LoadFixtures(c.Args()[1], dryRun)
+				if !dryRun {
+					// shutting down waits for the puts to be handled and retries any that failed
+					if e := h.Shutdown(holo.DefaultShutdownTimeout); e != nil && err == nil {
+						err = e
+					}
+				}
+				if err != nil {
+					fmt.Printf("\n")
+					return err
+				}
+				if dryRun {
+					fmt.Printf("%d entries valid\n", n)
+				} else {
+					fmt.Printf("loaded %d entries\n", n)
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "inspect",
+			Aliases: []string{"i"},
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "provenance",
+					Usage:       "also show the entry's signature and the peers known to hold it",
+					Destination: &provenance,
+				},
+			},
+			Usage:     "display information about an entry",
+			ArgsUsage: "holochain-name entry-hash",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "inspect")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("inspect: missing required entry-hash argument")
+				}
+				hash, err := holo.NewHash(c.Args()[1])
+				if err != nil {
+					return err
+				}
+				p, err := h.Provenance(hash)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Entry: %v\n", p.Hash)
+				fmt.Printf("    Author: %s\n", p.Author.Pretty())
+				if p.Header == nil {
+					fmt.Printf("    Header: unavailable (not on this chain)\n")
+				} else {
+					fmt.Printf("    Header: %s @ %v\n", p.Header.Type, p.Header.Time)
+				}
+				if provenance {
+					if p.Header != nil {
+						fmt.Printf("    Signature: %x\n", p.Signature().S)
+					}
+					fmt.Printf("    Holders:\n")
+					for _, id := range p.Holders {
+						fmt.Printf("       %s\n", id.Pretty())
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "bs",
+			Aliases:   []string{"b"},
+			Usage:     "send bootstrap tickler to the chain bootstrap server",
+			ArgsUsage: "bs",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "bs")
+				if err != nil {
+					return err
+				}
+				err = h.BSpost()
+				return err
+			},
+		},
+		{
+			Name:    "serve",
+			Aliases: []string{"w"},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "fast-sync",
+					Usage:       "comma separated addresses of peers to load DHT snapshots from before gossiping",
+					Destination: &fastSync,
+				},
+				cli.IntFlag{
+					Name:        "fast-sync-sample",
+					Usage:       "number of snapshot entries to fully validate with their sources",
+					Value:       holo.DefaultSnapshotSample,
+					Destination: &fastSyncSample,
+				},
+				cli.StringFlag{
+					Name:        "socket",
+					Usage:       "listen on this unix domain socket instead of a port",
+					Destination: &socket,
+				},
+				cli.StringFlag{
+					Name:        "base-path",
+					Usage:       "url path to serve all routes and ui files under, e.g. /myapp when behind a reverse proxy",
+					Destination: &basePath,
+				},
+				cli.BoolFlag{
+					Name:        "open",
+					Usage:       "print a QR code that pairs a mobile UI with the node, giving it a new API token",
+					Destination: &open,
+				},
+			},
+			Usage:     "serve a chain to the web",
+			ArgsUsage: "holochain-name [port]",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "serve")
+				if err != nil {
+					return err
+				}
+				if !h.Started() {
+					return fmt.Errorf("Can't serve an un-started chain. Run 'gen chain %s' to generate genesis entries and start the chain.", h.Name)
+				}
+				if h.Disabled() {
+					return fmt.Errorf("Can't serve a disabled chain. Run 'hc enable %s' to resume it.", c.Args().First())
+				}
+
+				if verbose {
+					fmt.Printf("Serving holochain with DNA hash:%v\n", h.DNAHash())
+				}
+
+				var port string
+				if len(c.Args()) == 1 {
+					port = "3141"
+				} else {
+					port = c.Args()[1]
+				}
+				err = h.Activate()
+				if err != nil {
+					return err
+				}
+				if fastSync != "" {
+					h.SetProgress(showProgress)
+					n, err := h.FastSync(strings.Split(fastSync, ","), fastSyncSample)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("fast sync loaded %d entries\n", n)
+				}
+				go h.DHT().HandlePutReqs()
+				go h.DHT().Gossip(2 * time.Second)
+				go h.DHT().RepublishEvery(holo.DefaultRepublishInterval)
+				go h.DHT().ExpireEvery(holo.DefaultExpireInterval)
+				go h.DHT().HeartbeatEvery(holo.DefaultHeartbeatInterval)
+				go h.DHT().CollectGarbageEvery(holo.DefaultGCInterval)
+				go h.DHT().MaintainResilienceEvery(holo.DefaultHoldInterval)
+				go h.SampleStatsEvery(holo.DefaultStatsInterval)
+				if n := h.NotaryConfig(); n != nil {
+					interval, _ := n.IntervalDuration()
+					go h.NotarizeEvery(interval)
+				}
+				if b := h.BackupConfig(); b != nil {
+					interval, _ := b.IntervalDuration()
+					if passphrase, err := holo.BackupPassphrase(); err != nil {
+						fmt.Printf("not backing up while serving: %v\n", err)
+					} else {
+						go service.BackupEvery(h, interval, passphrase)
+					}
+				}
+				if interval := h.SyncInterval(); interval > 0 {
+					go h.FsyncEvery(interval)
+				}
+				if h.HasRetention() {
+					go h.CompactEvery(holo.DefaultCompactInterval)
+				}
+				if err = h.ReceiveSubscriptions(nil); err != nil {
+					return err
+				}
+				if err = h.ForwardIntegrations(nil); err != nil {
+					return err
+				}
+				if _, err := h.WatchConfig(); err != nil {
+					fmt.Printf("unable to watch config for changes: %v\n", err)
+				}
+				fmt.Printf("node address for joining: %s\n", h.PeerAddr())
+				if open {
+					if socket != "" {
+						return errors.New("can't pair with a node served on a unix socket")
+					}
+					if err := offerPairing(h, service, port, basePath); err != nil {
+						return err
+					}
+				}
+				serve(h, service, port, socket, basePath)
+				return err
+			},
+		},
+		{
+			Name:  "service",
+			Usage: "keep a chain served after reboots by installing hc serve as a service of the operating system",
+			Subcommands: []cli.Command{
+				{
+					Name:      "install",
+					Usage:     "install and start a systemd unit, launchd job or windows scheduled task serving the chain",
+					ArgsUsage: "holochain-name [port]",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "system",
+							Usage:       "install for the whole system, starting at boot, instead of for the current user (needs root or administrator)",
+							Destination: &systemService,
+						},
+						cli.StringFlag{
+							Name:        "user",
+							Usage:       "user a system service runs as, the current user if not set",
+							Destination: &serviceUser,
+						},
+						cli.BoolFlag{
+							Name:        "dry-run",
+							Usage:       "print what would be installed without installing it",
+							Destination: &dryRun,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						name, err := checkForName(c, "service install")
+						if err != nil {
+							return err
+						}
+						sp, err := newServiceSpec(service, dirs, name, c.Args().Get(1), systemService, serviceUser)
+						if err != nil {
+							return err
+						}
+						return installService(sp, dryRun)
+					},
+				},
+				{
+					Name:      "uninstall",
+					Usage:     "stop and remove the service serving the chain",
+					ArgsUsage: "holochain-name",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "system",
+							Usage:       "remove the service installed for the whole system",
+							Destination: &systemService,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						name, err := checkForName(c, "service uninstall")
+						if err != nil {
+							return err
+						}
+						return uninstallService(serviceSpec{Name: serviceName(name), Chain: name, System: systemService})
+					},
+				},
+			},
+		},
+		{
+			Name:  "token",
+			Usage: "manage API tokens that limit served clients to particular functions and entry types",
+			Subcommands: []cli.Command{
+				{
+					Name:      "issue",
+					Usage:     "issue a new API token for a chain",
+					ArgsUsage: "holochain-name",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "name",
+							Usage:       "who the token is issued to, as zome code sees the caller",
+							Destination: &tokenName,
+						},
+						cli.StringFlag{
+							Name:        "origin",
+							Usage:       "web origin the token may only be used from",
+							Destination: &tokenOrigin,
+						},
+						cli.StringFlag{
+							Name:        "fn",
+							Usage:       "comma separated zome:function list the token may call, * for any",
+							Destination: &tokenFns,
+						},
+						cli.StringFlag{
+							Name:        "entry",
+							Usage:       "comma separated entry types the token may commit, * for any",
+							Destination: &tokenEntries,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						name, err := checkForName(c, "token issue")
+						if err != nil {
+							return err
+						}
+						t, err := service.IssueNamedToken(name, tokenName, tokenOrigin, splitList(tokenFns), splitList(tokenEntries))
+						if err != nil {
+							return err
+						}
+						fmt.Printf("%s\n", t.Token)
+						return nil
+					},
+				},
+				{
+					Name:      "list",
+					Usage:     "list the API tokens issued for a chain",
+					ArgsUsage: "holochain-name",
+					Action: func(c *cli.Context) error {
+						name, err := checkForName(c, "token list")
+						if err != nil {
+							return err
+						}
+						tokens, err := service.Tokens(name)
+						if err != nil {
+							return err
+						}
+						for _, t := range tokens {
+							fmt.Printf("%s name:%q origin:%q fn:%s entry:%s\n", t.Token, t.Name, t.Origin, strings.Join(t.Functions, ","), strings.Join(t.EntryTypes, ","))
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "rotate",
+					Usage:     "replace an API token with a new one of the same scope",
+					ArgsUsage: "token",
+					Action: func(c *cli.Context) error {
+						if !initialized {
+							return holo.ErrNotInitialized
+						}
+						t, err := service.RotateToken(c.Args().First())
+						if err != nil {
+							return err
+						}
+						fmt.Printf("%s\n", t.Token)
+						return nil
+					},
+				},
+				{
+					Name:      "revoke",
+					Usage:     "revoke an API token",
+					ArgsUsage: "token",
+					Action: func(c *cli.Context) error {
+						if !initialized {
+							return holo.ErrNotInitialized
+						}
+						return service.RevokeToken(c.Args().First())
+					},
+				},
+			},
+		},
+		{
+			Name:      "publish",
+			Usage:     "announce the genesis of a chain generated with gen chain --defer-publish to the DHT",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "publish")
+				if err != nil {
+					return err
+				}
+				if err = goOnline(h); err != nil {
+					return err
+				}
+				err = h.PublishGenesis()
+				// shutting down waits for the puts to be handled and retries any that failed
+				if e := h.Shutdown(holo.DefaultShutdownTimeout); e != nil && err == nil {
+					err = e
+				}
+				if err != nil {
+					return err
+				}
+				pending, err := h.DHT().Outbox()
+				if err != nil {
+					return err
+				}
+				if len(pending) > 0 {
+					fmt.Printf("%d genesis publications couldn't be sent yet and will be retried when the chain is served\n", len(pending))
+				} else if verbose {
+					fmt.Printf("Genesis published for holochain with ID: %s\n", h.DNAHash().String())
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "gc",
+			Usage:     "purge rejected, retracted and orphaned data from the DHT store",
+			ArgsUsage: "holochain-name",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "retention",
+					Usage:       "only purge data that has been garbage this long, e.g. 1h (default: the chain's GCRetention)",
+					Destination: &retention,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "gc")
+				if err != nil {
+					return err
+				}
+				r, err := h.CollectGarbage(retention)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("purged %d entries, %d links, %d holder records, %d expiry records and %d peer addresses, reclaiming %d bytes\n", r.Entries, r.Metas, r.Holders, r.Expired, r.PeerAddrs, r.Reclaimed)
+				return nil
+			},
+		},
+		{
+			Name:      "compact",
+			Usage:     "prune entries the entry types' retention policies no longer keep from the local chain",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "compact")
+				if err != nil {
+					return err
+				}
+				r, err := h.CompactChain()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("pruned %d entries, reclaiming %d bytes\n", r.Pruned, r.Reclaimed)
+				return nil
+			},
+		},
+		{
+			Name:      "disable",
+			Usage:     "park a chain, keeping its data but not serving it until it is enabled",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "disable")
+				if err != nil {
+					return err
+				}
+				return h.SetDisabled(true)
+			},
+		},
+		{
+			Name:      "enable",
+			Usage:     "resume a disabled chain",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "enable")
+				if err != nil {
+					return err
+				}
+				return h.SetDisabled(false)
+			},
+		},
+		{
+			Name:      "offline",
+			Usage:     "keep a chain from contacting other nodes, so that puts of entries committed wait in its outbox",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "offline")
+				if err != nil {
+					return err
+				}
+				return h.SetOffline(true)
+			},
+		},
+		{
+			Name:      "online",
+			Usage:     "let an offline chain contact other nodes again, publishing its outbox in order",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "online")
+				if err != nil {
+					return err
+				}
+				return h.SetOffline(false)
+			},
+		},
+		{
+			Name:      "pin",
+			Usage:     "keep an entry from ever being evicted from the DHT store",
+			ArgsUsage: "holochain-name hash",
+			Action: func(c *cli.Context) error {
+				return pinCommand(c, service, "pin", true)
+			},
+		},
+		{
+			Name:      "unpin",
+			Usage:     "let a pinned entry be evicted from the DHT store again",
+			ArgsUsage: "holochain-name hash",
+			Action: func(c *cli.Context) error {
+				return pinCommand(c, service, "unpin", false)
+			},
+		},
+		{
+			Name:      "pins",
+			Usage:     "list the pinned entries",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "pins")
+				if err != nil {
+					return err
+				}
+				pins, err := h.DHT().Pins()
+				if err != nil {
+					return err
+				}
+				for _, p := range pins {
+					held := ""
+					if !p.Held {
+						held = " (not held)"
+					}
+					fmt.Printf("%v pinned at:%v%s\n", p.Hash, p.Pinned.Format(time.Stamp), held)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "redact",
+			Usage:     "publish a redaction of an entry, which has the nodes holding it drop its content",
+			ArgsUsage: "holochain-name hash [reason]",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "redact")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("redact: missing required hash argument")
+				}
+				key, err := holo.NewHash(c.Args()[1])
+				if err != nil {
+					return err
+				}
+				err = h.Activate()
+				if err != nil {
+					return err
+				}
+				hash, err := h.Redact(key, strings.Join(c.Args()[2:], " "))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("redaction: %v\n", hash)
+				return nil
+			},
+		},
+		{
+			Name:      "invite",
+			Usage:     "invite an agent to join a chain whose DNA requires invites, printing the invite to give them",
+			ArgsUsage: "holochain-name node-id",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "invite")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("invite: missing required node-id argument")
+				}
+				token, err := h.NewInvite(c.Args()[1])
+				if err != nil {
+					return err
+				}
+				fmt.Println(token)
+				return nil
+			},
+		},
+		{
+			Name:      "sign",
+			Usage:     "sign a payload with the chain's agent key, printing the signature as json",
+			ArgsUsage: "holochain-name [payload], reading the payload from stdin if not given",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "sign")
+				if err != nil {
+					return err
+				}
+				data, err := payloadArg(c, 1)
+				if err != nil {
+					return err
+				}
+				s, err := h.Sign(data)
+				if err != nil {
+					return err
+				}
+				j, err := json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(j))
+				return nil
+			},
+		},
+		{
+			Name:      "verify-signature",
+			Usage:     "check a signature made with hc sign or a zome's sign(), printing its signer",
+			ArgsUsage: "signature-json [payload], reading the payload from stdin if not given",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) < 1 {
+					return errors.New("verify-signature: missing required signature argument")
+				}
+				s, err := holo.ParseMessageSignature(c.Args()[0])
+				if err != nil {
+					return err
+				}
+				data, err := payloadArg(c, 1)
+				if err != nil {
+					return err
+				}
+				if err = s.Verify(data); err != nil {
+					return err
+				}
+				fmt.Printf("valid signature by %s\n", s.Signer)
+				return nil
+			},
+		},
+		{
+			Name:      "identity",
+			Usage:     "sign a record of an agent's identity for a chain whose DNA requires signed identities, printing the record to give them",
+			ArgsUsage: "holochain-name node-id claim=value...",
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "identity")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) < 2 {
+					return errors.New("identity: missing required node-id argument")
+				}
+				claims := make(map[string]string)
+				for _, arg := range c.Args()[2:] {
+					i := strings.Index(arg, "=")
+					if i <= 0 {
+						return fmt.Errorf("identity: expected claim=value, got: %s", arg)
+					}
+					claims[arg[:i]] = arg[i+1:]
+				}
+				token, err := h.NewSignedIdentity(c.Args()[1], claims)
+				if err != nil {
+					return err
+				}
+				fmt.Println(token)
+				return nil
+			},
+		},
+		{
+			Name:      "notarize",
+			Usage:     "anchor the chain's head with its configured notary now",
+			ArgsUsage: "holochain-name",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "check",
+					Usage:       "check that the heads notarized so far still match the chain instead",
+					Destination: &checkNotarized,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				h, err := getHolochain(c, service, "notarize")
+				if err != nil {
+					return err
+				}
+				err = h.Activate()
+				if err != nil {
+					return err
+				}
+				if checkNotarized {
+					checks, err := h.CheckNotarizations()
+					if err != nil {
+						return err
+					}
+					failed := 0
+					for _, n := range checks {
+						s := n.Notarization.Statement
+						if n.Err != nil {
+							failed++
+							fmt.Printf("%v: FAILED %v\n", n.Hash, n.Err)
+							continue
+						}
+						fmt.Printf("%v: head %s at %v notarized by %s %s\n", n.Hash, s.Head, s.Time, n.Notarization.Notary, n.Notarization.Where)
+					}
+					if failed > 0 {
+						return fmt.Errorf("notarize: %d of %d notarized heads no longer match the chain", failed, len(checks))
+					}
+					return nil
+				}
+				hash, n, err := h.Notarize()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("notarized head %s: %v\n", n.Statement.Head, hash)
+				return nil
+			},
+		},
+		{
+			Name:  "backup",
+			Usage: "configure and run encrypted backups of a chain and its agent's keys",
+			Subcommands: []cli.Command{
+				{
+					Name:      "configure",
+					Usage:     "set where, and how often while serving, a chain is backed up",
+					ArgsUsage: "holochain-name target",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "interval",
+							Usage:       "how often to back up while serving, e.g. 6h (default: 24h)",
+							Destination: &backupInterval,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						h, err := getHolochain(c, service, "backup configure")
+						if err != nil {
+							return err
+						}
+						if len(c.Args()) != 2 {
+							return errors.New("backup configure: a directory, s3://bucket/prefix or sftp://user@host/path target must be given")
+						}
+						err = h.SetBackupConfig(&holo.BackupConfig{Target: c.Args()[1], Interval: backupInterval})
+						if err != nil {
+							return err
+						}
+						fmt.Printf("%s will be backed up to %s\n", h.Name, c.Args()[1])
+						return nil
+					},
+				},
+				{
+					Name:      "run",
+					Usage:     fmt.Sprintf("back up a chain to its configured target now, encrypted with the passphrase in %s", holo.BackupPassphraseEnvVar),
+					ArgsUsage: "holochain-name",
+					Action: func(c *cli.Context) error {
+						h, err := getHolochain(c, service, "backup run")
+						if err != nil {
+							return err
+						}
+						passphrase, err := holo.BackupPassphrase()
+						if err != nil {
+							return err
+						}
+						b, err := service.Backup(h, passphrase)
+						if err == holo.ErrNothingToBackUp {
+							fmt.Printf("%v\n", err)
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						kind := "incremental"
+						if b.Offset == 0 {
+							kind = "full"
+						}
+						fmt.Printf("%s backup %d of %s: %d bytes of chain, %d files, %d pairs\n", kind, b.Seq, b.Chain, len(b.Data), len(b.Files), b.Pairs)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:      "restore",
+			Usage:     fmt.Sprintf("restore a chain from its backups at a target, decrypted with the passphrase in %s", holo.BackupPassphraseEnvVar),
+			ArgsUsage: "holochain-name target",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "verify",
+					Usage:       "only check that the backups restore to a valid chain, leaving the service untouched",
+					Destination: &verifyRestore,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				name, err := checkForName(c, "restore")
+				if err != nil {
+					return err
+				}
+				if len(c.Args()) != 2 {
+					return errors.New("restore: the target the chain was backed up to must be given")
+				}
+				target, err := holo.NewBackupTarget(c.Args()[1])
+				if err != nil {
+					return err
+				}
+				passphrase, err := holo.BackupPassphrase()
+				if err != nil {
+					return err
+				}
+				var r *holo.Restored
+				if verifyRestore {
+					r, err = service.VerifyBackups(target, name, passphrase)
+				} else {
+					r, err = service.Restore(target, name, passphrase)
+				}
+				if err != nil {
+					return err
+				}
+				verb := "restored"
+				if verifyRestore {
+					verb = "verified"
+				}
+				fmt.Printf("%s %s from %d backups up to backup %d of %v: %d pairs, top %s\n", verb, r.Chain, r.Backups, r.Seq, r.Time, r.Pairs, r.Top)
+				return nil
+			},
+		},
+		{
+			Name:  "dht",
+			Usage: "inspect and maintain a chain's DHT store",
+			Subcommands: []cli.Command{
+				{
+					Name:      "verify",
+					Usage:     "re-validate every held entry against the chain's current validation rules",
+					ArgsUsage: "holochain-name",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:        "purge-invalid",
+							Usage:       "remove entries that fail verification from the DHT store",
+							Destination: &purgeInvalid,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						h, err := getHolochain(c, service, "dht verify")
+						if err != nil {
+							return err
+						}
+						r, err := h.VerifyDHT(purgeInvalid)
+						if err != nil {
+							return err
+						}
+						for _, inv := range r.Invalid {
+							fmt.Printf("%v (%s): %v\n", inv.Hash, inv.EntryType, inv.Err)
+						}
+						fmt.Printf("checked %d entries, %d invalid", r.Checked, len(r.Invalid))
+						if purgeInvalid {
+							fmt.Printf(", %d purged", r.Purged)
+						}
+						fmt.Printf("\n")
+						return nil
+					},
+				},
+				{
+					Name:      "revalidate",
+					Usage:     "re-validate the entries received in a window against the chain's current validation rules, quarantining those that fail",
+					ArgsUsage: "holochain-name",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "since",
+							Usage:       "start of the window, as an RFC3339 time or a duration before now, e.g. 48h",
+							Destination: &revalidateSince,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if revalidateSince == "" {
+							return errors.New("dht revalidate: --since must be given")
+						}
+						since, err := holo.ParseSince(revalidateSince, time.Now())
+						if err != nil {
+							return err
+						}
+						h, err := getHolochain(c, service, "dht revalidate")
+						if err != nil {
+							return err
+						}
+						r, err := h.RevalidateDHT(since)
+						if err != nil {
+							return err
+						}
+						for _, inv := range r.Invalid {
+							fmt.Printf("%v (%s): %v\n", inv.Hash, inv.EntryType, inv.Err)
+						}
+						fmt.Printf("checked %d entries received since %s, %d quarantined\n", r.Checked, r.Since.Format(time.RFC3339), r.Quarantined)
+						return nil
+					},
+				},
+				{
+					Name:      "release",
+					Usage:     "return a quarantined entry to the DHT store if it passes the chain's current validation rules",
+					ArgsUsage: "holochain-name hash",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 2 {
+							return errors.New("dht release: missing required holochain-name and hash arguments")
+						}
+						h, err := getHolochain(c, service, "dht release")
+						if err != nil {
+							return err
+						}
+						hash, err := holo.NewHash(c.Args()[1])
+						if err != nil {
+							return err
+						}
+						if err = h.ReleaseQuarantined(hash); err != nil {
+							return err
+						}
+						fmt.Printf("released %v\n", hash)
+						return nil
+					},
+				},
+				{
+					Name:      "dump",
+					Usage:     "list the entries held in the DHT store with their types, sources, statuses and receipt times",
+					ArgsUsage: "holochain-name",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "type",
+							Usage:       "only list entries of this type",
+							Destination: &dhtDumpType,
+						},
+						cli.StringFlag{
+							Name:        "status",
+							Usage:       "only list entries with this status: live, rejected, deleted, updated, redacted or quarantined",
+							Destination: &dhtDumpStatus,
+						},
+						cli.StringFlag{
+							Name:        "format",
+							Usage:       "text, or json",
+							Value:       "text",
+							Destination: &dhtDumpFormat,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if dhtDumpFormat != "text" && dhtDumpFormat != "json" {
+							return fmt.Errorf("dht dump: unknown format %s", dhtDumpFormat)
+						}
+						h, err := getHolochain(c, service, "dht dump")
+						if err != nil {
+							return err
+						}
+						entries, err := h.DHT().Dump(holo.DHTDumpFilter{Type: dhtDumpType, Status: dhtDumpStatus})
+						if err != nil {
+							return err
+						}
+						if dhtDumpFormat == "json" {
+							if entries == nil {
+								entries = []holo.DHTDumpEntry{}
+							}
+							b, err := json.MarshalIndent(entries, "", "  ")
+							if err != nil {
+								return err
+							}
+							fmt.Println(string(b))
+							return nil
+						}
+						fmt.Printf("DHT: %s\n", h.DNAHash())
+						for i := range entries {
+							dumpDHTEntry(&entries[i])
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "stats",
+					Usage:     "summarize the data held in the DHT store and how widely it is replicated",
+					ArgsUsage: "holochain-name",
+					Action: func(c *cli.Context) error {
+						h, err := getHolochain(c, service, "dht stats")
+						if err != nil {
+							return err
+						}
+						st, err := h.DHT().Stats()
+						if err != nil {
+							return err
+						}
+						printDHTStats(&st)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:      "peers",
+			Usage:     "list known peers with their reputation scores",
+			ArgsUsage: "holochain-name",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "liveness",
+					Usage:       "list the latest heartbeat received from each agent instead",
+					Destination: &liveness,
+				},
+			},
 			Action: func(c *cli.Context) error {
-				h, err := getHolochain(c, service, "call")
+				h, err := getHolochain(c, service, "peers")
 				if err != nil {
 					return err
 				}
-				zome := os.Args[3]
-				function := os.Args[4]
-				args := os.Args[5:]
-				fmt.Printf("calling %s on zome %s with params %v\n", function, zome, args)
-				result, err := h.Call(zome, function, strings.Join(args, " "))
+				if liveness {
+					beats, err := h.DHT().Heartbeats()
+					if err != nil {
+						return err
+					}
+					for _, hb := range beats {
+						status := "gone"
+						if hb.Alive() {
+							status = "alive"
+						}
+						fmt.Printf("%v %s head:%v at:%v\n", hb.Id.Pretty(), status, hb.Head, hb.Time.Format(time.Stamp))
+					}
+					return nil
+				}
+				glist, err := h.DHT().Gossipers()
 				if err != nil {
 					return err
 				}
-				fmt.Printf("%v\n", result)
+				for _, g := range glist {
+					r, err := h.DHT().GetPeerRecord(g.Id)
+					if err != nil {
+						return err
+					}
+					status := ""
+					if r.Banned() {
+						status = fmt.Sprintf(" banned until %v", r.BannedUntil.Format(time.Stamp))
+					}
+					fmt.Printf("%v score:%.2f ok:%d invalid:%d timeouts:%d violations:%d%s\n",
+						g.Id.Pretty(), r.Score(), r.Successes, r.InvalidPuts, r.Timeouts, r.Violations, status)
+				}
 				return nil
 			},
 		},
 		{
-			Name:      "bs",
-			Aliases:   []string{"b"},
-			Usage:     "send bootstrap tickler to the chain bootstrap server",
-			ArgsUsage: "bs",
+			Name:      "republish",
+			Usage:     "re-send puts to the DHT that haven't been acknowledged",
+			ArgsUsage: "holochain-name",
 			Action: func(c *cli.Context) error {
-				h, err := getHolochain(c, service, "bs")
+				h, err := getHolochain(c, service, "republish")
 				if err != nil {
 					return err
 				}
-				err = h.BSpost()
-				return err
-			},
-		},
-		{
-			Name:      "serve",
-			Aliases:   []string{"w"},
-			Usage:     "serve a chain to the web",
-			ArgsUsage: "holochain-name [port]",
-			Action: func(c *cli.Context) error {
-				h, err := getHolochain(c, service, "serve")
+				err = h.Activate()
 				if err != nil {
 					return err
 				}
-				if !h.Started() {
-					return fmt.Errorf("Can't serve an un-started chain. Run 'gen chain %s' to generate genesis entries and start the chain.", h.Name)
-				}
-
-				if verbose {
-					fmt.Printf("Serving holochain with DNA hash:%v\n", h.DNAHash())
-				}
-
-				var port string
-				if len(c.Args()) == 1 {
-					port = "3141"
-				} else {
-					port = c.Args()[1]
-				}
-				err = h.Activate()
+				acked, pending, err := h.DHT().Republish()
 				if err != nil {
 					return err
 				}
-				go h.DHT().HandlePutReqs()
-				go h.DHT().Gossip(2 * time.Second)
-				serve(h, port)
-				return err
+				fmt.Printf("republished %d entries, %d still awaiting acknowledgment\n", acked, pending)
+				return nil
 			},
 		},
 		{
-			Name:      "reset",
-			Aliases:   []string{"r"},
+			Name:    "reset",
+			Aliases: []string{"r"},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "to",
+					Usage:       "roll the chain back to the given header hash instead (development mode only)",
+					Destination: &resetTo,
+				},
+			},
 			Usage:     "reset a chain. Warning this destroys all chain data!",
 			ArgsUsage: "holochain-name",
 			Action: func(c *cli.Context) error {
@@ -420,6 +2227,21 @@ func setupApp() (app *cli.App) {
 				if err != nil {
 					return err
 				}
+				if resetTo != "" {
+					hash, err := holo.NewHash(resetTo)
+					if err != nil {
+						return err
+					}
+					removed, err := h.ResetTo(hash)
+					if err != nil {
+						return err
+					}
+					for _, hd := range removed {
+						fmt.Printf("removed %s entry %v, marked for retraction\n", hd.Type, hd.EntryLink)
+					}
+					fmt.Printf("chain rolled back to %v\n", hash)
+					return nil
+				}
 				err = h.Reset()
 				return err
 			},
@@ -435,21 +2257,15 @@ func setupApp() (app *cli.App) {
 			fmt.Printf("app version: %s; Holochain lib version %s\n", app.Version, holo.Version)
 		}
 		var err error
-		if root == "" {
-			root = os.Getenv("HOLOPATH")
-			if root == "" {
-				u, err := user.Current()
-				if err != nil {
-					return err
-				}
-				userPath := u.HomeDir
-				root = userPath + "/" + holo.DefaultDirectoryName
-			}
+		dirs, err = holo.ResolveChainDirs(root)
+		if err != nil {
+			return err
 		}
-		if initialized = holo.IsInitialized(root); !initialized {
-			uninitialized = errors.New("service not initialized, run 'hc init'")
-		} else {
-			service, err = holo.LoadService(root)
+		if initialized = dirs.IsInitialized(); initialized {
+			service, err = holo.LoadServiceDirs(dirs)
+			if err == nil {
+				service.GetPassphrase = promptPassphrase
+			}
 		}
 		return err
 	}
@@ -465,16 +2281,245 @@ func setupApp() (app *cli.App) {
 	return
 }
 
+// promptPassphrase gets the passphrase of an encrypted chain from the environment, or else by
+// asking for it on the terminal without echoing it
+func promptPassphrase(chain string) (string, error) {
+	if p := os.Getenv(holo.PassphraseEnvVar); p != "" {
+		return p, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", holo.ErrNoPassphrase
+	}
+	fmt.Printf("passphrase for chain %s: ", chain)
+	p, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(p), nil
+}
+
+// ask prints a question and returns the answer, or def if the answer is empty
+func ask(in *bufio.Reader, question string, def string) (answer string, err error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, err = in.ReadString('\n')
+	if err != nil {
+		return
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		answer = def
+	}
+	return
+}
+
+// confirm asks a yes or no question
+func confirm(in *bufio.Reader, question string, def bool) (yes bool, err error) {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	var answer string
+	if answer, err = ask(in, question+" ("+d+")", ""); err != nil {
+		return
+	}
+	switch strings.ToLower(answer) {
+	case "":
+		yes = def
+	case "y", "yes":
+		yes = true
+	case "n", "no":
+	default:
+		err = fmt.Errorf("expected yes or no, got %s", answer)
+	}
+	return
+}
+
+// initWizard asks for the agent and the service settings of a new service
+func initWizard(in *bufio.Reader, settings *holo.ServiceConfig) (agent string, err error) {
+	fmt.Println("Setting up a new holochain service")
+	for agent == "" {
+		if agent, err = ask(in, "agent identity, e.g. Name <name@example.com>", ""); err != nil {
+			return
+		}
+	}
+	if settings.DefaultBootstrapServer, err = ask(in, "bootstrap server", settings.DefaultBootstrapServer); err != nil {
+		return
+	}
+	if settings.DefaultRegistry, err = ask(in, "app registry index url (none if left empty)", settings.DefaultRegistry); err != nil {
+		return
+	}
+	if settings.DefaultEncryptChain, err = confirm(in, "protect the source chains of new chains with a passphrase?", false); err != nil {
+		return
+	}
+	if settings.DefaultEncryptChain {
+		fmt.Printf("you'll be asked for a chain's passphrase when it's first used, or it can be given in %s\n", holo.PassphraseEnvVar)
+	}
+	return
+}
+
 func main() {
 	app := setupApp()
 
 	err := app.Run(os.Args)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
+		if err == holo.ErrChainNotStarted {
+			fmt.Printf("Run 'hc gen chain' to generate the chain's genesis entries and start it.\n")
+		}
 		os.Exit(1)
 	}
 }
 
+// pinCommand pins or unpins the entry given on the command line
+func pinCommand(c *cli.Context, service *holo.Service, cmd string, pin bool) error {
+	h, err := getHolochain(c, service, cmd)
+	if err != nil {
+		return err
+	}
+	if len(c.Args()) < 2 {
+		return errors.New(cmd + ": missing required hash argument")
+	}
+	key, err := holo.NewHash(c.Args()[1])
+	if err != nil {
+		return err
+	}
+	if pin {
+		return h.DHT().Pin(key)
+	}
+	return h.DHT().Unpin(key)
+}
+
+// recordCalls reads calls of the form "zome function args" from stdin until it ends, making
+// them with the recorder and saving the test file after each one
+func recordCalls(r *holo.Recorder) (err error) {
+	fmt.Printf("enter calls as: zome function args, end with ctrl-d\n")
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(in.Text()), " ", 3)
+		if len(fields) < 2 {
+			if fields[0] != "" {
+				fmt.Printf("expected: zome function args\n")
+			}
+			continue
+		}
+		args := ""
+		if len(fields) == 3 {
+			args = fields[2]
+		}
+		result, e := r.Call(fields[0], fields[1], args)
+		if e != nil {
+			fmt.Printf("error: %v\n", e)
+		} else {
+			fmt.Printf("%v\n", result)
+		}
+		if err = r.Save(); err != nil {
+			return
+		}
+	}
+	if err = in.Err(); err != nil {
+		return
+	}
+	err = r.Close()
+	if err == nil {
+		fmt.Printf("recorded %d calls\n", len(r.Tests()))
+	}
+	return
+}
+
+// printDHTStats prints a summary of the data held in a DHT store
+func printDHTStats(st *holo.DHTStats) {
+	fmt.Printf("entries held: %d (%d bytes)\n", st.Entries, st.Bytes)
+	var types []string
+	for t := range st.ByType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Printf("    %s: %d\n", t, st.ByType[t])
+	}
+	fmt.Printf("rejected: %d, deleted: %d, redacted: %d, quarantined: %d\n", st.Rejected, st.Deleted, st.Redacted, st.Quarantined)
+	if !st.Oldest.IsZero() {
+		fmt.Printf("received: %v to %v\n", st.Oldest, st.Newest)
+	}
+	fmt.Printf("reachable peers: %d\n", st.Peers)
+	fmt.Printf("coverage: %.0f%% of entries known to be held by at least %d nodes (%d under-replicated)\n", st.Coverage()*100, st.Resilience, st.UnderReplicated)
+}
+
+// printDayStats prints a summary of a chain's activity on a day
+func printDayStats(st *holo.DayStats) {
+	fmt.Printf("%s\n", st.Day)
+	fmt.Printf("commits: %d\n", st.Commits)
+	for _, t := range sortedKeys(st.EntryTypes) {
+		fmt.Printf("    %s: %d\n", t, st.EntryTypes[t])
+	}
+	fmt.Printf("entries received: %d, new agents: %d\n", st.Received, st.NewAgents)
+	fmt.Printf("most peers known: %d\n", st.Peers)
+}
+
+// printStatsHistory prints a chain's activity day by day followed by the totals
+func printStatsHistory(history []holo.DayStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tCOMMITS\tRECEIVED\tNEW AGENTS\tPEERS\tENTRY TYPES")
+	total := holo.DayStats{Day: "total", EntryTypes: make(map[string]int)}
+	for _, st := range history {
+		var types []string
+		for _, t := range sortedKeys(st.EntryTypes) {
+			types = append(types, fmt.Sprintf("%s:%d", t, st.EntryTypes[t]))
+			total.EntryTypes[t] += st.EntryTypes[t]
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", st.Day, st.Commits, st.Received, st.NewAgents, st.Peers, strings.Join(types, " "))
+		total.Commits += st.Commits
+		total.Received += st.Received
+		total.NewAgents += st.NewAgents
+		if st.Peers > total.Peers {
+			total.Peers = st.Peers
+		}
+	}
+	var types []string
+	for _, t := range sortedKeys(total.EntryTypes) {
+		types = append(types, fmt.Sprintf("%s:%d", t, total.EntryTypes[t]))
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", total.Day, total.Commits, total.Received, total.NewAgents, total.Peers, strings.Join(types, " "))
+	w.Flush()
+}
+
+// sortedKeys returns the keys of a count map in order
+func sortedKeys(m map[string]int) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// payloadArg returns the payload given as the argument at i, or read from stdin if there isn't one
+func payloadArg(c *cli.Context, i int) ([]byte, error) {
+	if len(c.Args()) > i {
+		return []byte(c.Args()[i]), nil
+	}
+	return ioutil.ReadAll(os.Stdin)
+}
+
+// showProgress renders a progress bar for a long running operation
+func showProgress(p holo.Progress) {
+	const width = 30
+	if p.Total <= 0 {
+		fmt.Printf("\r%s: %-40s", p.Op, p.Stage)
+		return
+	}
+	n := p.Done * width / p.Total
+	fmt.Printf("\r%s [%s%s] %3d%% %-40s", p.Op, strings.Repeat("#", n), strings.Repeat(" ", width-n), p.Percent(), p.Stage)
+	if p.Finished() {
+		fmt.Printf("\n")
+	}
+}
+
 func getHolochain(c *cli.Context, service *holo.Service, cmd string) (h *holo.Holochain, err error) {
 	name, err := checkForName(c, cmd)
 	if err != nil {
@@ -487,9 +2532,19 @@ func getHolochain(c *cli.Context, service *holo.Service, cmd string) (h *holo.Ho
 	return
 }
 
+// goOnline brings up the chain's node and starts handling puts, so that what a command
+// publishes is sent rather than only recorded; the caller shuts the chain down when done
+func goOnline(h *holo.Holochain) (err error) {
+	if err = h.Activate(); err != nil {
+		return
+	}
+	go h.DHT().HandlePutReqs()
+	return
+}
+
 func checkForName(c *cli.Context, cmd string) (name string, err error) {
 	if !initialized {
-		err = uninitialized
+		err = holo.ErrNotInitialized
 		return
 	}
 	name = c.Args().First()
@@ -509,23 +2564,223 @@ func listChains(s *holo.Service) {
 			if id.String() != "" {
 				sid = id.String()
 			}
+			if chains[k].Disabled() {
+				fmt.Println("    ", k, sid, "(disabled)")
+				continue
+			}
 			fmt.Println("    ", k, sid)
+			printHealth(chains[k])
 		}
 	} else {
 		fmt.Println("no installed chains")
 	}
 }
 
+var degraded = holo.Logger{Format: "%{color:red}%{message}", Enabled: true}
+
+// printHealth prints a summary of a chain's health, flagging it in red if it is degraded
+func printHealth(h *holo.Holochain) {
+	hl, err := h.Health()
+	if err != nil {
+		fmt.Printf("        health unknown: %v\n", err)
+		return
+	}
+	gossip := "never"
+	if !hl.LastGossip.IsZero() {
+		gossip = hl.LastGossip.Format(time.RFC3339)
+	}
+	fmt.Printf("        last gossip: %s with %d gossipers, publish backlog: %d, validation errors in the last %v: %d of %d, disk: %d bytes\n",
+		gossip, hl.Gossipers, hl.PublishBacklog, hl.ValidationWindow, hl.ValidationFailures, hl.Validations, hl.DiskBytes)
+	if pubs, err := h.DHT().Outbox(); err == nil && len(pubs) > 0 {
+		fmt.Printf("        outbox: %d waiting to be published\n", len(pubs))
+	}
+	if hl.Degraded() {
+		degraded.New(nil)
+		for _, p := range hl.Problems {
+			degraded.Logf("        DEGRADED: %s", p)
+		}
+	}
+}
+
+// printClock prints how far the clocks of a chain's peers are from this node's, flagging in
+// red a clock that is off by more than the maximum skew
+func printClock(h *holo.Holochain) (err error) {
+	r, err := h.ClockReport()
+	if err != nil {
+		return
+	}
+	if len(r.Peers) == 0 {
+		fmt.Println("        clock: no peer clocks observed yet")
+		return
+	}
+	fmt.Printf("        clock: median peer offset %v over %d peers, max skew %v\n", r.Median, len(r.Peers), r.MaxSkew)
+	for _, o := range r.Peers {
+		fmt.Printf("            %s: %v (at %s)\n", o.Id.Pretty(), o.Offset, o.At.Format(time.RFC3339))
+	}
+	if len(r.Problems) > 0 {
+		degraded.New(nil)
+		for _, p := range r.Problems {
+			degraded.Logf("        SKEW: %s", p)
+		}
+	}
+	return
+}
+
+// dumpHeader prints a header and its entry
+func dumpHeader(k string, hdr *holo.Header, e holo.Entry) {
+	fmt.Printf("%s:%s @ %v\n", hdr.Type, k, hdr.Time)
+	fmt.Printf("    Next Header: %v\n", hdr.HeaderLink)
+	fmt.Printf("    Next %s: %v\n", hdr.Type, hdr.TypeLink)
+	fmt.Printf("    Entry: %v\n", hdr.EntryLink)
+	switch hdr.Type {
+	case holo.KeyEntryType:
+		fmt.Printf("       %v\n", e.(*holo.GobEntry).C)
+	case holo.DNAEntryType:
+		fmt.Printf("       %s\n", e.(*holo.GobEntry).C)
+	case holo.AgentEntryType:
+		fmt.Printf("       %v\n", e.(*holo.GobEntry).C.(holo.AgentEntry))
+	default:
+		fmt.Printf("       %v\n", e)
+	}
+}
+
+func dumpDHTEntry(d *holo.DHTDumpEntry) {
+	fmt.Printf("%s:%s %s\n", d.Type, d.Hash, d.Status)
+	fmt.Printf("    Source: %s\n", d.Source)
+	if d.Received != nil {
+		fmt.Printf("    Received: %v\n", d.Received)
+	}
+	fmt.Printf("    Holders: %d, Links: %d, Size: %d\n", d.Holders, d.Links, d.Size)
+	if d.Content != nil {
+		fmt.Printf("       %v\n", d.Content)
+	}
+}
+
+// followChain runs the chain as a node, printing each entry committed to it or received into
+// its DHT store until interrupted
+func followChain(h *holo.Holochain) (err error) {
+	err = h.Activate()
+	if err != nil {
+		return
+	}
+	go h.DHT().HandlePutReqs()
+	go h.DHT().Gossip(2 * time.Second)
+	fmt.Printf("following %s, node address: %s\n", h.Name, h.PeerAddr())
+	for f := range h.Follow(nil) {
+		ev := f.Event
+		if f.Err != nil {
+			fmt.Printf("%s %v: %v\n", ev.EntryType, ev.Hash, f.Err)
+			continue
+		}
+		if ev.Type == holo.EventCommit {
+			dumpHeader(f.HeaderHash.String(), f.Header, f.Entry)
+			continue
+		}
+		fmt.Printf("%s:%s received from %s @ %v\n", ev.EntryType, ev.Hash, ev.Peer.Pretty(), ev.Time)
+		fmt.Printf("       %v\n", f.Entry.Content())
+	}
+	return
+}
+
+// templateVars collects template variable values from an answers file and NAME=value flags,
+// the flags taking precedence
+func templateVars(answers string, flags []string) (vars map[string]string, err error) {
+	vars = make(map[string]string)
+	if answers != "" {
+		if vars, err = holo.LoadTemplateAnswers(answers); err != nil {
+			return
+		}
+	}
+	for _, f := range flags {
+		x := strings.SplitN(f, "=", 2)
+		if len(x) != 2 {
+			err = fmt.Errorf("template variable should be NAME=value, got: %s", f)
+			return
+		}
+		vars[x[0]] = x[1]
+	}
+	return
+}
+
+// splitList splits a comma separated flag value, returning nil for an empty one
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+func registryURL(s *holo.Service, flag string) string {
+	if flag != "" {
+		return flag
+	}
+	return s.Settings.DefaultRegistry
+}
+
+func printCoverage(h *holo.Holochain) error {
+	report, err := h.CoverageReport()
+	if err != nil {
+		return err
+	}
+	fmt.Println("coverage:")
+	for _, z := range report {
+		fmt.Printf("  %s: %.1f%%\n", z.Zome, z.Percent)
+		for _, items := range [][]holo.CoverageItem{z.Functions, z.Validations} {
+			for _, i := range items {
+				if i.Count == 0 {
+					fmt.Printf("    not covered: %s\n", i.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func mkErr(etext string, code int) (int, error) {
 	fmt.Println("Error:", code, etext)
 	return code, errors.New(etext)
 }
 
-func genChain(service *holo.Service, name string) error {
+func genChainDeferred(service *holo.Service, name string, invite string, identity string) error {
+	h, err := service.Load(name)
+	if err != nil {
+		return err
+	}
+	if invite != "" {
+		if err = h.SetInvite(invite); err != nil {
+			return err
+		}
+	}
+	if identity != "" {
+		h.SetIdentityProvider(holo.IdentityToken(identity))
+	}
+	err = h.GenDNAHashes()
+	if err != nil {
+		return err
+	}
+	_, err = h.GenChainDeferred()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Genesis entries added for agent %s with node id %s\n", h.Agent().Name(), h.NodeIDStr())
+	fmt.Printf("Agent entry hash: %s\n", h.Agenthash().String())
+	fmt.Println("The chain won't join the DHT until published with: hc publish " + name)
+	return nil
+}
+
+func genChain(service *holo.Service, name string, invite string, identity string) error {
 	h, err := service.Load(name)
 	if err != nil {
 		return err
 	}
+	if invite != "" {
+		if err = h.SetInvite(invite); err != nil {
+			return err
+		}
+	}
+	if identity != "" {
+		h.SetIdentityProvider(holo.IdentityToken(identity))
+	}
 	err = h.GenDNAHashes()
 	if err != nil {
 		return err
@@ -12,3 +12,14 @@ func TestSetupApp(t *testing.T) {
 		So(app.Name, ShouldEqual, "hc")
 	})
 }
+
+func TestWindowsTask(t *testing.T) {
+	Convey("tasks started at boot shouldn't need a stored password", t, func() {
+		sp := serviceSpec{Name: "holochain-test", Chain: "test", Exec: `C:\hc.exe`, User: "art", System: true}
+		So(sp.windowsTask(), ShouldContainSubstring, "<LogonType>S4U</LogonType>")
+		sp.User = `NT AUTHORITY\SYSTEM`
+		So(sp.windowsTask(), ShouldContainSubstring, "<LogonType>ServiceAccount</LogonType>")
+		sp.System = false
+		So(sp.windowsTask(), ShouldContainSubstring, "<LogonType>InteractiveToken</LogonType>")
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements hc call --loop, which calls a zome function at a target rate until it has made
+// the calls asked for, its time is up or it is interrupted, then reports on the calls
+
+package main
+
+import (
+	"fmt"
+	holo "github.com/metacurrency/holochain"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runLoad generates load on a chain with the given options, printing the report of the run
+func runLoad(h *holo.Holochain, opts holo.LoadOptions, duration string) (err error) {
+	if duration != "" {
+		if opts.Duration, err = time.ParseDuration(duration); err != nil {
+			return
+		}
+	}
+	if err = opts.Check(); err != nil {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	fmt.Printf("calling %s on zome %s at %v/s, press Ctrl-C to stop\n", opts.Function, opts.Zome, opts.Rate)
+	var r holo.LoadReport
+	if r, err = h.RunLoad(opts, stop); err != nil {
+		return
+	}
+	fmt.Println(r)
+	return
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements hc sandbox, which runs several nodes of an app on this machine, each with its own
+// agent, for trying out apps with more than one user.  The nodes find each other through a
+// bootstrap server run in the hc sandbox process, and are served by hc serve processes that
+// are stopped and deleted along with their chains on Ctrl-C.
+
+package main
+
+import (
+	"fmt"
+	holo "github.com/metacurrency/holochain"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// sandboxSource returns the app the sandbox runs: the installed chain at installed, the
+// directory app, or the development app if app is empty
+func sandboxSource(installed string, app string) (src string, err error) {
+	if app == "" {
+		return
+	}
+	if installed != "" && dirExists(installed) {
+		src = installed
+		return
+	}
+	if !dirExists(app) {
+		err = fmt.Errorf("no installed chain or directory named %s", app)
+		return
+	}
+	src, err = filepath.Abs(app)
+	return
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// runSandbox sets up and serves n nodes of an app until interrupted
+func runSandbox(installed string, app string, n int, port int) (err error) {
+	var src string
+	if src, err = sandboxSource(installed, app); err != nil {
+		return
+	}
+	var l net.Listener
+	if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return
+	}
+	defer l.Close()
+	go http.Serve(l, holo.NewBootstrapServer())
+
+	var sb *holo.Sandbox
+	if sb, err = holo.NewSandbox(src, n, port, l.Addr().String()); err != nil {
+		return
+	}
+	defer sb.Remove()
+
+	var exe string
+	if exe, err = os.Executable(); err != nil {
+		return
+	}
+	var procs []*exec.Cmd
+	defer func() {
+		stopSandbox(procs)
+	}()
+	for _, node := range sb.Nodes {
+		var logFile *os.File
+		if logFile, err = os.Create(filepath.Join(sb.Root, node.Name+".log")); err != nil {
+			return
+		}
+		defer logFile.Close()
+		cmd := exec.Command(exe, "-path", node.Service.Path, "serve", holo.SandboxChainName, strconv.Itoa(node.Port))
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		if err = cmd.Start(); err != nil {
+			return
+		}
+		procs = append(procs, cmd)
+		fmt.Printf("%s: %s (log: %s)\n", node.Name, node.URL(), logFile.Name())
+	}
+	fmt.Printf("sandbox of %d nodes running in %s, press Ctrl-C to stop it\n", n, sb.Root)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	<-sigs
+	fmt.Println("stopping sandbox")
+	return
+}
+
+// stopSandbox interrupts the nodes' processes so they shut down gracefully, killing those that
+// haven't exited after the shutdown timeout
+func stopSandbox(procs []*exec.Cmd) {
+	done := make(chan bool, len(procs))
+	for _, cmd := range procs {
+		cmd.Process.Signal(os.Interrupt)
+		go func(cmd *exec.Cmd) {
+			cmd.Wait()
+			done <- true
+		}(cmd)
+	}
+	timeout := time.After(holo.DefaultShutdownTimeout + time.Second)
+	for range procs {
+		select {
+		case <-done:
+		case <-timeout:
+			for _, cmd := range procs {
+				cmd.Process.Kill()
+			}
+			return
+		}
+	}
+}
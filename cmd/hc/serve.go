@@ -7,25 +7,33 @@
 package main
 
 import (
-	_ "encoding/json"
+	"encoding/json"
+	"errors"
 	"fmt"
 	websocket "github.com/gorilla/websocket"
 	holo "github.com/metacurrency/holochain"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"rsc.io/qr"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 var log = holo.Logger{Format: "%{color:magenta}%{message}"}
 var errs = holo.Logger{Format: "%{color:red}%{time} %{message}", Enabled: true}
 
-func serve(h *holo.Holochain, port string) {
+func serve(h *holo.Holochain, s *holo.Service, port string, socket string, basePath string) {
 
 	log.New(nil)
 	errs.New(os.Stderr)
 
-	fs := http.FileServer(http.Dir(h.Path() + "/ui"))
+	fs := http.FileServer(http.Dir(filepath.Join(h.Path(), "ui")))
 	http.Handle("/", fs)
 
 	var upgrader = websocket.Upgrader{
@@ -34,7 +42,7 @@ func serve(h *holo.Holochain, port string) {
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
 
-	http.HandleFunc("/_sock/", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/_sock/", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			errs.Log(err)
@@ -53,7 +61,7 @@ func serve(h *holo.Holochain, port string) {
 			}
 			zome := v["zome"]
 			function := v["fn"]
-			result, err := call(w, h, zome, function, v["arg"])
+			result, err := call(w, h, zome, function, v["arg"], v["key"])
 			switch t := result.(type) {
 			case string:
 				err = conn.WriteMessage(websocket.TextMessage, []byte(t))
@@ -69,9 +77,9 @@ func serve(h *holo.Holochain, port string) {
 				return
 			}
 		}
-	})
+	}))
 
-	http.HandleFunc("/fn/", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/fn/", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
 
 		var err error
 		var errCode int = 400
@@ -88,22 +96,24 @@ func serve(h *holo.Holochain, port string) {
 					http.Redirect(w, r, "/static", http.StatusSeeOther)
 				}
 		*/
-		body, err := ioutil.ReadAll(r.Body)
+		path := strings.Split(r.URL.Path, "/")
+
+		zome := path[2]
+		function := path[3]
+
+		// reading one byte past the function's limit is enough for the call to reject it
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(h.MaxArgSize(zome, function))+1))
 		if err != nil {
 			errCode, err = mkErr("unable to read body", 500)
 			return
 		}
 		fmt.Printf("processing req:%s\n  Body:%v\n", r.URL.Path, string(body))
 
-		path := strings.Split(r.URL.Path, "/")
-
-		zome := path[2]
-		function := path[3]
 		args := string(body)
-		result, err := call(w, h, zome, function, args)
+		result, err := call(w, h, zome, function, args, r.Header.Get("Idempotency-Key"))
 		if err != nil {
 			log.Logf("HC Serve: call of %s:%s resulted in error: %v\n", zome, function, err)
-			http.Error(w, err.Error(), 500)
+			http.Error(w, err.Error(), holo.StatusCode(err))
 
 			return
 		} else {
@@ -117,15 +127,571 @@ func serve(h *holo.Holochain, port string) {
 				err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
 			}
 		}
-	}) // set router
-	fmt.Printf("starting server on localhost:%s\n", port)
-	err := http.ListenAndServe(":"+port, nil) // set listen port
+	}))
+
+	http.HandleFunc("/fn/_batch", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		if r.Method != "POST" {
+			http.Error(w, "batch requires POST", 405)
+			return
+		}
+		var calls []holo.BatchCall
+		if err := json.NewDecoder(r.Body).Decode(&calls); err != nil {
+			http.Error(w, "unable to decode batch: "+err.Error(), 400)
+			return
+		}
+		log.Logf("processing batch of %d calls\n", len(calls))
+		results, err := h.CallBatch(calls)
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		b, err := json.Marshal(results)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+
+	// a relay takes entries signed by thin clients, which hold keys but no chain, and gives
+	// back its countersigned receipt once it has validated and queued them for publishing
+	http.HandleFunc("/_relay", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		if r.Method != "POST" {
+			http.Error(w, "relay requires POST", 405)
+			return
+		}
+		var entry holo.RelayedEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, "unable to decode relayed entry: "+err.Error(), 400)
+			return
+		}
+		receipt, err := h.Relay(&entry)
+		if err != nil {
+			code := holo.StatusCode(err)
+			if code == 500 {
+				code = 400
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, receipt)
+	}))
+
+	http.HandleFunc("/_token/rotate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "rotate requires POST", 405)
+			return
+		}
+		if s.CheckToken(chainName(h), requestToken(r)) == nil {
+			http.Error(w, "api token required", 401)
+			return
+		}
+		t, err := s.RotateToken(requestToken(r))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		writeJSON(w, t)
+	})
+
+	// the ui posts the code from the fragment of the pairing url, and gets the token in the
+	// response body, so that neither is kept in logs or history
+	http.HandleFunc("/_pair", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "pairing requires POST", 405)
+			return
+		}
+		t, err := s.Pair(chainName(h), r.PostFormValue("code"))
+		if err != nil {
+			http.Error(w, err.Error(), 403)
+			return
+		}
+		log.Logf("paired new client with token scope %v\n", t.Functions)
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, t)
+	})
+
+	http.HandleFunc("/fn/_schema", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		schemas, err := h.FunctionSchemas()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		b, err := json.Marshal(schemas)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+
+	// other chains run by the service read the entries this one grants them through here
+	http.HandleFunc("/_bridge/", h.ServeBridge)
+
+	http.HandleFunc("/_get/", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		hash, err := holo.NewHash(strings.TrimPrefix(r.URL.Path, "/_get/"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		q := r.URL.Query()
+		opts := holo.GetOptions{LocalOnly: q.Get("local") == "true"}
+		opts.Quorum, _ = strconv.Atoi(q.Get("quorum"))
+		opts.Timeout, _ = strconv.Atoi(q.Get("timeout"))
+		opts.Retries, _ = strconv.Atoi(q.Get("retries"))
+		opts.RetryWait, _ = strconv.Atoi(q.Get("retryWait"))
+		response, err := h.DHT().SendGetWithOptions(hash, opts)
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		e, ok := response.(*holo.GobEntry)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unexpected response type from get: %T", response), 500)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"Hash": hash.String(), "Entry": e.C})
+	}))
+
+	http.HandleFunc("/agents", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		entries, err := h.DHT().FindAgent(r.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		if entries == nil {
+			entries = []holo.DirectoryEntry{}
+		}
+		writeJSON(w, entries)
+	}))
+
+	// aggregates count the links of a type from a base, or count or sum the entries a query
+	// given as json in q selects
+	http.HandleFunc("/_count", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		q := r.URL.Query()
+		var n int
+		if base := q.Get("base"); base != "" {
+			hash, err := holo.NewHash(base)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if n, err = h.CountLinks(hash, q.Get("tag")); err != nil {
+				http.Error(w, err.Error(), holo.StatusCode(err))
+				return
+			}
+		} else {
+			query, err := holo.ParseQuery(h, q.Get("q"))
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if n, err = h.QueryCount(query); err != nil {
+				http.Error(w, err.Error(), holo.StatusCode(err))
+				return
+			}
+		}
+		writeJSON(w, map[string]int{"Count": n})
+	}))
+
+	http.HandleFunc("/_sum", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		query, err := holo.ParseQuery(h, r.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		a, err := h.QuerySum(query, r.URL.Query().Get("field"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		writeJSON(w, a)
+	}))
+
+	// the chain's activity day by day over the last days given, 30 by default, or since the
+	// first recorded day if days is 0, and never for more than holo.MaxStatsDays
+	http.HandleFunc("/_stats", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		days := 30
+		if d := r.URL.Query().Get("days"); d != "" {
+			var err error
+			if days, err = strconv.Atoi(d); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if days < 0 || days > holo.MaxStatsDays {
+				http.Error(w, fmt.Sprintf("days must be between 0 and %d", holo.MaxStatsDays), 400)
+				return
+			}
+		}
+		history, err := h.StatsHistory(days)
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		writeJSON(w, history)
+	}))
+
+	// a standing query, given as json in q, pushes its matches over a websocket if the
+	// request asks for one, or otherwise as server-sent events
+	http.HandleFunc("/_subscribe", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		q, err := holo.ParseQuery(h, r.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		stop := make(chan struct{})
+		defer close(stop)
+		matches, err := h.SubscribeQuery(q, stop)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				errs.Log(err)
+				return
+			}
+			defer conn.Close()
+			closed := make(chan struct{})
+			go func() {
+				// reading notices the client closing the connection
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						close(closed)
+						return
+					}
+				}
+			}()
+			for {
+				select {
+				case m := <-matches:
+					if err = conn.WriteJSON(m); err != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", 500)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		flusher.Flush()
+		notify := w.(http.CloseNotifier).CloseNotify()
+		for {
+			select {
+			case m := <-matches:
+				b, err := json.Marshal(m)
+				if err != nil {
+					errs.Log(err)
+					return
+				}
+				if _, err = fmt.Fprintf(w, "event: match\ndata: %s\n\n", b); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-notify:
+				return
+			}
+		}
+	}))
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		hl, err := h.Health()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if hl.Degraded() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(503)
+			json.NewEncoder(w).Encode(hl)
+			return
+		}
+		writeJSON(w, hl)
+	})
+
+	http.HandleFunc("/_metrics", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		h.SampleRuntime()
+		b, err := json.Marshal(h.Metrics().Snapshot())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+
+	http.HandleFunc("/_provenance/", apiAuth(h, s, func(w http.ResponseWriter, r *http.Request, h *holo.Holochain) {
+		hash, err := holo.NewHash(strings.TrimPrefix(r.URL.Path, "/_provenance/"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		p, err := h.Provenance(hash)
+		if err != nil {
+			http.Error(w, err.Error(), holo.StatusCode(err))
+			return
+		}
+		b, err := json.Marshal(provenanceJSON(p))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})) // set router
+	basePath = cleanBasePath(basePath)
+	root := "http://localhost:" + port
+	if socket != "" {
+		root = "unix:" + socket
+	}
+	serveForms(h, s)
+	serveAudit(h)
+	serveAdmin(h, s, root+basePath)
+	serving := holo.Serving{URL: "http://localhost:" + port + basePath}
+	if socket != "" {
+		serving = holo.Serving{URL: "http://unix" + basePath, Socket: socket}
+	}
+	if err := h.SetServing(serving); err != nil {
+		errs.Logf("unable to record where the chain is served: %v", err)
+	}
+	defer h.ClearServing()
+	shutdownOnSignal(h)
+	err := listen(port, socket, basePath)
 	if err != nil {
 		errs.Logf("Couldn't start server: %v", err)
 	}
 }
 
-func call(w http.ResponseWriter, h *holo.Holochain, zome string, function string, args string) (result interface{}, err error) {
+// shutdownOnSignal stops the chain gracefully when the process is interrupted or terminated,
+// so that queued puts are handled and the chain's file is synced before exiting
+func shutdownOnSignal(h *holo.Holochain) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Printf("%v: draining queues and shutting down\n", sig)
+		h.ClearServing()
+		code := 0
+		if err := h.Shutdown(holo.DefaultShutdownTimeout); err != nil {
+			errs.Logf("shutdown: %v", err)
+			code = 1
+		}
+		os.Exit(code)
+	}()
+}
+
+// offerPairing prints a QR code of the url a mobile UI can visit to pair with the node
+func offerPairing(h *holo.Holochain, s *holo.Service, port string, basePath string) (err error) {
+	var host string
+	if host, err = lanAddr(); err != nil {
+		return
+	}
+	var p holo.Pairing
+	p, err = s.NewPairing(chainName(h), []string{holo.ScopeAll}, []string{holo.ScopeAll}, holo.DefaultPairingTTL)
+	if err != nil {
+		return
+	}
+	u := p.URL("http://" + net.JoinHostPort(host, port) + cleanBasePath(basePath))
+	if err = printQR(u); err != nil {
+		return
+	}
+	fmt.Printf("scan to pair, or visit %s (valid for %v)\n", u, holo.DefaultPairingTTL)
+	return
+}
+
+// lanAddr returns the first non-loopback IPv4 address of this machine, which other devices on
+// the local network can reach the node on
+func lanAddr() (addr string, err error) {
+	var addrs []net.Addr
+	if addrs, err = net.InterfaceAddrs(); err != nil {
+		return
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			addr = n.IP.String()
+			return
+		}
+	}
+	err = errors.New("no network address to pair on")
+	return
+}
+
+// printQR renders text as a QR code on the terminal, two modules per character, drawing the
+// light modules so it scans on a dark background
+func printQR(text string) (err error) {
+	var code *qr.Code
+	if code, err = qr.Encode(text, qr.L); err != nil {
+		return
+	}
+	const quiet = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		line := ""
+		for x := -quiet; x < code.Size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				line += "█"
+			case top:
+				line += "▀"
+			case bottom:
+				line += "▄"
+			default:
+				line += " "
+			}
+		}
+		fmt.Println(line)
+	}
+	return
+}
+
+// cleanBasePath normalizes a base path to have a leading slash and no trailing slash, with the
+// root being the empty string
+func cleanBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// underBasePath serves handler's routes under basePath, so that a node can share a host with
+// other services behind a reverse proxy.  A request for basePath itself is redirected to
+// basePath/ by the mux.
+func underBasePath(basePath string, handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(basePath+"/", http.StripPrefix(basePath, handler))
+	return mux
+}
+
+// listen serves the registered routes, under basePath if there is one, on a unix domain socket
+// if one is given or else on port
+func listen(port string, socket string, basePath string) (err error) {
+	var handler http.Handler = http.DefaultServeMux
+	if basePath != "" {
+		handler = underBasePath(basePath, handler)
+	}
+	if socket == "" {
+		fmt.Printf("starting server on localhost:%s%s\n", port, basePath)
+		err = http.ListenAndServe(":"+port, handler) // set listen port
+		return
+	}
+	// clear away the socket left by a previous run, but nothing else
+	if fi, e := os.Lstat(socket); e == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			err = fmt.Errorf("%s exists and isn't a socket", socket)
+			return
+		}
+		if err = os.Remove(socket); err != nil {
+			return
+		}
+	}
+	var l net.Listener
+	l, err = net.Listen("unix", socket)
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	fmt.Printf("starting server on unix socket %s%s\n", socket, basePath)
+	err = http.Serve(l, handler)
+	return
+}
+
+// chainName returns the name the service knows a chain by, which API tokens are issued against
+func chainName(h *holo.Holochain) string {
+	return filepath.Base(h.Path())
+}
+
+// requestToken returns the token given either as a bearer token in the Authorization header
+// or as a token query parameter
+func requestToken(r *http.Request) string {
+	if t := bearerToken(r); t != "" {
+		return t
+	}
+	return r.URL.Query().Get("token")
+}
+
+// bearerToken returns the token given in the Authorization header of a request, which is the
+// only way the admin token is accepted
+func bearerToken(r *http.Request) string {
+	if a := r.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") {
+		return strings.TrimPrefix(a, "Bearer ")
+	}
+	return ""
+}
+
+// apiAuth wraps a handler so that, once any API tokens have been issued for the chain, it
+// requires one of them or the admin token.  The handler is given a holochain limited to the
+// scope of the token, so a client can only call the functions and commit the entry types it
+// was issued for.
+func apiAuth(h *holo.Holochain, s *holo.Service, handler func(http.ResponseWriter, *http.Request, *holo.Holochain)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.CheckAdminToken(bearerToken(r)) {
+			handler(w, r, h)
+			return
+		}
+		token := requestToken(r)
+		tokens, err := s.Tokens(chainName(h))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(tokens) == 0 {
+			handler(w, r, h)
+			return
+		}
+		t := s.CheckToken(chainName(h), token)
+		if t == nil {
+			http.Error(w, "api token required", 401)
+			return
+		}
+		if !t.AllowsOrigin(r.Header.Get("Origin")) {
+			http.Error(w, "api token not valid from origin "+r.Header.Get("Origin"), 403)
+			return
+		}
+		handler(w, r, h.WithScope(t))
+	}
+}
+
+// provenanceJSON converts a provenance into a structure with readable hashes and ids for json encoding
+func provenanceJSON(p *holo.Provenance) map[string]interface{} {
+	holders := make([]string, 0)
+	for _, id := range p.Holders {
+		holders = append(holders, id.Pretty())
+	}
+	j := map[string]interface{}{
+		"Hash":    p.Hash.String(),
+		"Author":  p.Author.Pretty(),
+		"Holders": holders,
+	}
+	if p.Header != nil {
+		j["Header"] = map[string]interface{}{
+			"Type":       p.Header.Type,
+			"Time":       p.Header.Time,
+			"HeaderLink": p.Header.HeaderLink.String(),
+			"EntryLink":  p.Header.EntryLink.String(),
+			"TypeLink":   p.Header.TypeLink.String(),
+		}
+		j["Signature"] = fmt.Sprintf("%x", p.Signature().S)
+	}
+	return j
+}
+
+func call(w http.ResponseWriter, h *holo.Holochain, zome string, function string, args string, key string) (result interface{}, err error) {
 	var n holo.Nucleus
 	n, err = h.MakeNucleus(zome)
 	if err == nil {
@@ -134,7 +700,12 @@ func call(w http.ResponseWriter, h *holo.Holochain, zome string, function string
 		for _, f := range i {
 			if f.Name == function {
 				log.Logf("calling %s:%s(%s)\n", zome, function, args)
-				result, err = h.Call(zome, function, args)
+				var replayed bool
+				result, replayed, err = h.CallIdempotent(key, zome, function, args)
+				if replayed {
+					log.Logf("replaying result of call with idempotency key %s\n", key)
+					w.Header().Set("Idempotent-Replayed", "true")
+				}
 				return
 			}
 		}
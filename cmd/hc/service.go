@@ -0,0 +1,382 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements hc service, which installs hc serve as a service of the operating system so that
+// a node keeps running after reboots: a systemd unit on linux, a launchd job on macOS, and a
+// scheduled task on windows, as hc can't yet run as a native windows service
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	holo "github.com/metacurrency/holochain"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// serviceSpec describes how the operating system should run hc serve for a chain
+type serviceSpec struct {
+	Name    string   // name of the unit, job or task
+	Chain   string   // the chain served
+	Exec    string   // path of the hc executable
+	Args    []string // arguments to hc
+	Env     []string // environment of hc, as NAME=value
+	User    string   // the user the service runs as
+	Dir     string   // the working directory, which is the chain's directory
+	System  bool     // installed for the whole system, started at boot, rather than for the user
+	LogPath string   // where output goes on systems without a journal
+}
+
+var serviceNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// serviceName returns the name of the unit, job or task serving a chain
+func serviceName(chain string) string {
+	return "holochain-" + serviceNameChars.ReplaceAllString(chain, "_")
+}
+
+// newServiceSpec works out how to serve a chain of the service as a system service, with the
+// same directories this run of hc is using
+func newServiceSpec(s *holo.Service, dirs holo.ChainDirs, chain string, port string, system bool, as string) (sp serviceSpec, err error) {
+	h, err := s.Load(chain)
+	if err != nil {
+		return
+	}
+	if !h.Started() {
+		err = fmt.Errorf("Can't serve an un-started chain. Run 'gen chain %s' first.", chain)
+		return
+	}
+	if h.Disabled() {
+		err = fmt.Errorf("Can't serve a disabled chain. Run 'hc enable %s' first.", chain)
+		return
+	}
+	if h.Encrypted() {
+		fmt.Printf("warning: the chain is encrypted, so the service will need %s set in its environment to start\n", holo.PassphraseEnvVar)
+	}
+	sp = serviceSpec{
+		Name:    serviceName(chain),
+		Chain:   chain,
+		System:  system,
+		Dir:     h.Path(),
+		LogPath: filepath.Join(h.Path(), holo.ServeLogFileName),
+	}
+	if sp.Exec, err = os.Executable(); err != nil {
+		return
+	}
+	if sp.Exec, err = filepath.Abs(sp.Exec); err != nil {
+		return
+	}
+	if as == "" {
+		var u *user.User
+		if u, err = user.Current(); err != nil {
+			return
+		}
+		as = u.Username
+	}
+	sp.User = as
+	if dirs.Config == dirs.Data {
+		sp.Args = append(sp.Args, "-path", dirs.Data)
+	} else {
+		sp.Env = append(sp.Env,
+			"XDG_CONFIG_HOME="+filepath.Dir(dirs.Config),
+			"XDG_DATA_HOME="+filepath.Dir(dirs.Data))
+	}
+	sp.Args = append(sp.Args, "serve", chain)
+	if port != "" {
+		sp.Args = append(sp.Args, port)
+	}
+	return
+}
+
+// systemdQuote quotes an argument of a systemd ExecStart line if it needs it
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// systemdUnit returns the unit file of the service.  hc serve shuts down gracefully on
+// SIGTERM, so it is given longer than its shutdown timeout to stop.
+func (sp serviceSpec) systemdUnit() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[Unit]\nDescription=Holochain node serving %s\nWants=network-online.target\nAfter=network-online.target\n\n", sp.Chain)
+	fmt.Fprintf(&b, "[Service]\nType=simple\n")
+	if sp.System {
+		fmt.Fprintf(&b, "User=%s\n", sp.User)
+	}
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdQuote(sp.Dir))
+	for _, e := range sp.Env {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(e))
+	}
+	args := []string{systemdQuote(sp.Exec)}
+	for _, a := range sp.Args {
+		args = append(args, systemdQuote(a))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(args, " "))
+	fmt.Fprintf(&b, "Restart=on-failure\nRestartSec=5\nTimeoutStopSec=%d\n\n", int(holo.DefaultShutdownTimeout.Seconds())+5)
+	target := "default.target"
+	if sp.System {
+		target = "multi-user.target"
+	}
+	fmt.Fprintf(&b, "[Install]\nWantedBy=%s\n", target)
+	return b.String()
+}
+
+// xmlEscape escapes text for an xml document
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// launchdLabel returns the label of the service's launchd job
+func (sp serviceSpec) launchdLabel() string {
+	return "org.holochain." + strings.TrimPrefix(sp.Name, "holochain-")
+}
+
+// launchdPlist returns the property list of the service's launchd job, which launchd keeps
+// alive unless it exits cleanly
+func (sp serviceSpec) launchdPlist() string {
+	var b bytes.Buffer
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	b.WriteString("<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	key := func(k string, v string) {
+		fmt.Fprintf(&b, "\t<key>%s</key>\n\t<string>%s</string>\n", k, xmlEscape(v))
+	}
+	key("Label", sp.launchdLabel())
+	if sp.System {
+		key("UserName", sp.User)
+	}
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range append([]string{sp.Exec}, sp.Args...) {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(a))
+	}
+	b.WriteString("\t</array>\n")
+	if len(sp.Env) > 0 {
+		b.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, e := range sp.Env {
+			kv := strings.SplitN(e, "=", 2)
+			fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", xmlEscape(kv[0]), xmlEscape(kv[1]))
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	key("WorkingDirectory", sp.Dir)
+	key("StandardOutPath", sp.LogPath)
+	key("StandardErrorPath", sp.LogPath)
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	b.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>5</integer>\n")
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// windowsQuote quotes a command line argument for windows if it needs it
+func windowsQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+}
+
+// windowsServiceAccounts are the built in accounts a task can run as without a password
+var windowsServiceAccounts = map[string]bool{
+	"system":                       true,
+	"localsystem":                  true,
+	"nt authority\\system":         true,
+	"localservice":                 true,
+	"nt authority\\localservice":   true,
+	"networkservice":               true,
+	"nt authority\\networkservice": true,
+}
+
+// windowsLogonType returns how a task started at boot logs on as a user.  No password is
+// stored with the task, so built in service accounts log on as themselves and other users
+// through S4U, which runs the task as them without access to network resources needing their
+// credentials, which hc doesn't need.
+func windowsLogonType(user string) string {
+	if windowsServiceAccounts[strings.ToLower(user)] {
+		return "ServiceAccount"
+	}
+	return "S4U"
+}
+
+// windowsTask returns the task scheduler definition of the service, started at boot for
+// system services or else at the user's logon, and restarted if it fails
+func (sp serviceSpec) windowsTask() string {
+	var b bytes.Buffer
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	b.WriteString("<Task version=\"1.2\" xmlns=\"http://schemas.microsoft.com/windows/2004/02/mit/task\">\n")
+	fmt.Fprintf(&b, "  <RegistrationInfo>\n    <Description>Holochain node serving %s</Description>\n  </RegistrationInfo>\n", xmlEscape(sp.Chain))
+	if sp.System {
+		b.WriteString("  <Triggers>\n    <BootTrigger>\n      <Enabled>true</Enabled>\n    </BootTrigger>\n  </Triggers>\n")
+		fmt.Fprintf(&b, "  <Principals>\n    <Principal id=\"Author\">\n      <UserId>%s</UserId>\n      <LogonType>%s</LogonType>\n    </Principal>\n  </Principals>\n", xmlEscape(sp.User), windowsLogonType(sp.User))
+	} else {
+		fmt.Fprintf(&b, "  <Triggers>\n    <LogonTrigger>\n      <Enabled>true</Enabled>\n      <UserId>%s</UserId>\n    </LogonTrigger>\n  </Triggers>\n", xmlEscape(sp.User))
+		fmt.Fprintf(&b, "  <Principals>\n    <Principal id=\"Author\">\n      <UserId>%s</UserId>\n      <LogonType>InteractiveToken</LogonType>\n    </Principal>\n  </Principals>\n", xmlEscape(sp.User))
+	}
+	b.WriteString("  <Settings>\n")
+	b.WriteString("    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>\n")
+	b.WriteString("    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>\n")
+	b.WriteString("    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>\n")
+	b.WriteString("    <ExecutionTimeLimit>PT0S</ExecutionTimeLimit>\n")
+	b.WriteString("    <RestartOnFailure>\n      <Interval>PT1M</Interval>\n      <Count>999</Count>\n    </RestartOnFailure>\n")
+	b.WriteString("  </Settings>\n")
+	args := make([]string, len(sp.Args))
+	for i, a := range sp.Args {
+		args[i] = windowsQuote(a)
+	}
+	fmt.Fprintf(&b, "  <Actions Context=\"Author\">\n    <Exec>\n      <Command>%s</Command>\n      <Arguments>%s</Arguments>\n      <WorkingDirectory>%s</WorkingDirectory>\n    </Exec>\n  </Actions>\n",
+		xmlEscape(sp.Exec), xmlEscape(strings.Join(args, " ")), xmlEscape(sp.Dir))
+	b.WriteString("</Task>\n")
+	return b.String()
+}
+
+// serviceFile returns where the service's definition is installed, and its contents
+func (sp serviceSpec) serviceFile() (path string, contents string, err error) {
+	switch runtime.GOOS {
+	case "linux":
+		contents = sp.systemdUnit()
+		if sp.System {
+			path = filepath.Join("/etc/systemd/system", sp.Name+".service")
+		} else {
+			// systemd looks for user units under XDG_CONFIG_HOME, if it is set
+			config := os.Getenv("XDG_CONFIG_HOME")
+			if config == "" {
+				var u *user.User
+				if u, err = user.Current(); err != nil {
+					return
+				}
+				config = filepath.Join(u.HomeDir, ".config")
+			}
+			path = filepath.Join(config, "systemd/user", sp.Name+".service")
+		}
+	case "darwin":
+		contents = sp.launchdPlist()
+		if sp.System {
+			path = filepath.Join("/Library/LaunchDaemons", sp.launchdLabel()+".plist")
+		} else {
+			var u *user.User
+			if u, err = user.Current(); err != nil {
+				return
+			}
+			path = filepath.Join(u.HomeDir, "Library/LaunchAgents", sp.launchdLabel()+".plist")
+		}
+	case "windows":
+		// the task's definition is only needed while it is being created
+		contents = sp.windowsTask()
+		path = filepath.Join(os.TempDir(), sp.Name+".xml")
+	default:
+		err = fmt.Errorf("installing services isn't supported on %s", runtime.GOOS)
+	}
+	return
+}
+
+// run runs a command, showing its output
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if verbose {
+		fmt.Printf("running: %s %s\n", name, strings.Join(args, " "))
+	}
+	return cmd.Run()
+}
+
+// systemctl runs systemctl on the user's or the system's units
+func systemctl(system bool, args ...string) error {
+	if !system {
+		args = append([]string{"--user"}, args...)
+	}
+	return run("systemctl", args...)
+}
+
+// installService installs and starts the service, or if dryRun just prints its definition
+func installService(sp serviceSpec, dryRun bool) (err error) {
+	path, contents, err := sp.serviceFile()
+	if err != nil {
+		return
+	}
+	if dryRun {
+		fmt.Printf("# %s\n%s", path, contents)
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		return
+	}
+	fmt.Printf("wrote %s\n", path)
+	switch runtime.GOOS {
+	case "linux":
+		if err = systemctl(sp.System, "daemon-reload"); err != nil {
+			return
+		}
+		if err = systemctl(sp.System, "enable", "--now", sp.Name+".service"); err != nil {
+			return
+		}
+		if !sp.System {
+			fmt.Printf("to keep it running when you aren't logged in, run: loginctl enable-linger %s\n", sp.User)
+		}
+	case "darwin":
+		err = run("launchctl", "load", "-w", path)
+	case "windows":
+		err = run("schtasks", "/Create", "/TN", sp.Name, "/XML", path, "/F")
+		os.Remove(path)
+		if err != nil {
+			return
+		}
+		err = run("schtasks", "/Run", "/TN", sp.Name)
+	}
+	if err == nil {
+		fmt.Printf("installed %s serving %s\n", sp.Name, sp.Chain)
+	}
+	return
+}
+
+// uninstallService stops and removes the service
+func uninstallService(sp serviceSpec) (err error) {
+	path, _, err := sp.serviceFile()
+	if err != nil {
+		return
+	}
+	switch runtime.GOOS {
+	case "linux":
+		if err = systemctl(sp.System, "disable", "--now", sp.Name+".service"); err != nil {
+			return
+		}
+	case "darwin":
+		if err = run("launchctl", "unload", "-w", path); err != nil {
+			return
+		}
+	case "windows":
+		if err = run("schtasks", "/End", "/TN", sp.Name); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+		if err = run("schtasks", "/Delete", "/TN", sp.Name, "/F"); err != nil {
+			return
+		}
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = nil
+	if runtime.GOOS == "linux" {
+		err = systemctl(sp.System, "daemon-reload")
+	}
+	if err == nil {
+		fmt.Printf("uninstalled %s\n", sp.Name)
+	}
+	return
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the hc top command, a refreshing table of the resource usage of served chains
+
+package main
+
+import (
+	"fmt"
+	holo "github.com/metacurrency/holochain"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// topSample is a chain's metrics at a point in time
+type topSample struct {
+	name    string
+	at      time.Time
+	metrics map[string]int64
+	err     error
+}
+
+// topRow is a line of the table, worked out from two samples of a chain
+type topRow struct {
+	name    string
+	busy    float64 // percent of the time between the samples that zome code was running
+	calls   float64 // per second
+	in, out float64 // network bytes per second
+	m       map[string]int64
+	err     error
+}
+
+type byBusy []topRow
+
+func (p byBusy) Len() int      { return len(p) }
+func (p byBusy) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byBusy) Less(i, j int) bool {
+	if (p[i].err == nil) != (p[j].err == nil) {
+		return p[i].err == nil
+	}
+	if p[i].busy != p[j].busy {
+		return p[i].busy > p[j].busy
+	}
+	return p[i].name < p[j].name
+}
+
+// sampleChains fetches the metrics of each of the service's chains that is being served
+func sampleChains(s *holo.Service) (samples map[string]topSample, err error) {
+	var chains map[string]*holo.Holochain
+	if chains, err = s.ConfiguredChains(); err != nil {
+		return
+	}
+	// the admin token lets top read the metrics of chains that have issued api tokens
+	var token string
+	if token, err = s.AdminToken(); err != nil {
+		return
+	}
+	samples = make(map[string]topSample)
+	for name, h := range chains {
+		sample := topSample{name: name, at: time.Now()}
+		var serving holo.Serving
+		if serving, sample.err = h.GetServing(); sample.err == nil {
+			sample.metrics, sample.err = serving.FetchMetrics(token)
+		}
+		samples[name] = sample
+	}
+	return
+}
+
+// topRows works out the table from the last and current samples
+func topRows(last map[string]topSample, current map[string]topSample) (rows []topRow) {
+	for name, c := range current {
+		r := topRow{name: name, m: c.metrics, err: c.err}
+		if l, ok := last[name]; ok && l.err == nil && c.err == nil {
+			secs := c.at.Sub(l.at).Seconds()
+			rate := func(metric string) float64 {
+				return float64(c.metrics[metric]-l.metrics[metric]) / secs
+			}
+			r.busy = rate("nucleus.ns") / 1e9 * 100
+			r.calls = rate("calls")
+			r.in = rate("net.in")
+			r.out = rate("net.out")
+		}
+		rows = append(rows, r)
+	}
+	sort.Sort(byBusy(rows))
+	return
+}
+
+// humanBytes formats a number of bytes in the largest unit it has at least one of
+func humanBytes(n float64) string {
+	units := []string{"B", "K", "M", "G"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
+
+// printTop prints the table of chains
+func printTop(rows []topRow) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "CHAIN\tNUCLEUS BUSY\tCALLS/S\tHEAP\tGOROUTINES\tWORKERS\tDHT STORE\tPUT QUEUE\tPUBLISH QUEUE\tNET IN/S\tNET OUT/S\t")
+	for _, r := range rows {
+		if r.err != nil {
+			status := "not responding"
+			if r.err == holo.ErrNotServing {
+				status = "not served"
+			}
+			fmt.Fprintf(w, "%s\t%s\t\t\t\t\t\t\t\t\t\t\n", r.name, status)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%.1f%%\t%.1f\t%s\t%d\t%d\t%s\t%d\t%d\t%s\t%s\t\n",
+			r.name, r.busy, r.calls,
+			humanBytes(float64(r.m["runtime.heap"])), r.m["runtime.goroutines"], r.m["workers"],
+			humanBytes(float64(r.m["dht.bytes"])), r.m["dht.putqueue"], r.m["publish.pending"],
+			humanBytes(r.in), humanBytes(r.out))
+	}
+	w.Flush()
+}
+
+// top shows the resource usage of the service's served chains, refreshing every interval,
+// or just once after a single interval has been measured
+func top(s *holo.Service, interval time.Duration, once bool) (err error) {
+	var last, current map[string]topSample
+	if last, err = sampleChains(s); err != nil {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		if current, err = sampleChains(s); err != nil {
+			return
+		}
+		if !once {
+			// clear the terminal and go back to its top left
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("hc top - %s, every %v\n\n", time.Now().Format("15:04:05"), interval)
+		}
+		printTop(topRows(last, current))
+		if once {
+			return
+		}
+		last = current
+	}
+}
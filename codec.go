@@ -0,0 +1,156 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// codec implements the pluggable encoding formats used for DNA and config files.  The format
+// of a file is identified by its extension.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Codec encodes and decodes DNA and config files in a particular format
+type Codec interface {
+	Encode(writer io.Writer, data interface{}) error
+	Decode(reader io.Reader, data interface{}) error
+}
+
+var codecs = make(map[string]Codec)
+
+// RegisterCodec sets up a codec to be used for files with the given format extension
+func RegisterCodec(format string, codec Codec) {
+	if codec == nil {
+		panic("Codec for format " + format + " does not exist.")
+	}
+	if _, registered := codecs[format]; registered {
+		panic("Codec for format " + format + " already registered.")
+	}
+	codecs[format] = codec
+}
+
+// CodecFormats returns the sorted list of registered format extensions
+func CodecFormats() (formats []string) {
+	formats = make([]string, 0)
+	for f := range codecs {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return
+}
+
+// IsCodecFormat returns true if there is a codec registered for the format
+func IsCodecFormat(format string) bool {
+	_, ok := codecs[format]
+	return ok
+}
+
+func getCodec(format string) (c Codec, err error) {
+	c, ok := codecs[format]
+	if !ok {
+		err = fmt.Errorf("unknown encoding format: %s, must be one of: %s", format, strings.Join(CodecFormats(), ", "))
+	}
+	return
+}
+
+// findEncodedFile returns the format of the first file found in path named base with the
+// extension of a registered codec
+func findEncodedFile(path string, base string) (format string, err error) {
+	for _, f := range CodecFormats() {
+		if fileExists(filepath.Join(path, base+"."+f)) {
+			format = f
+			return
+		}
+	}
+	err = fmt.Errorf("%s not found, looked for %s.{%s}", base, base, strings.Join(CodecFormats(), ","))
+	return
+}
+
+// Encode encodes data to the writer according to the given format
+func Encode(writer io.Writer, format string, data interface{}) (err error) {
+	var c Codec
+	if c, err = getCodec(format); err != nil {
+		return
+	}
+	err = c.Encode(writer, data)
+	return
+}
+
+// Decode extracts data from the reader according to the type
+func Decode(reader io.Reader, format string, data interface{}) (err error) {
+	var c Codec
+	if c, err = getCodec(format); err != nil {
+		return
+	}
+	err = c.Decode(reader, data)
+	return
+}
+
+// TOMLCodec encodes toml files
+type TOMLCodec struct{}
+
+func (c TOMLCodec) Encode(writer io.Writer, data interface{}) error {
+	return toml.NewEncoder(writer).Encode(data)
+}
+
+func (c TOMLCodec) Decode(reader io.Reader, data interface{}) (err error) {
+	_, err = toml.DecodeReader(reader, data)
+	return
+}
+
+// JSONCodec encodes indented json files
+type JSONCodec struct{}
+
+func (c JSONCodec) Encode(writer io.Writer, data interface{}) error {
+	enc := json.NewEncoder(writer)
+	enc.SetIndent("", "    ")
+	return enc.Encode(data)
+}
+
+func (c JSONCodec) Decode(reader io.Reader, data interface{}) error {
+	return json.NewDecoder(reader).Decode(data)
+}
+
+// YAMLCodec encodes yaml files
+type YAMLCodec struct{}
+
+func (c YAMLCodec) Encode(writer io.Writer, data interface{}) (err error) {
+	y, err := yaml.Marshal(data)
+	if err != nil {
+		return
+	}
+	n, err := writer.Write(y)
+	if err != nil {
+		return
+	}
+	if n != len(y) {
+		err = errors.New("unable to write all bytes while encoding")
+	}
+	return
+}
+
+func (c YAMLCodec) Decode(reader io.Reader, data interface{}) (err error) {
+	y, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return
+	}
+	err = yaml.Unmarshal(y, data)
+	return
+}
+
+func init() {
+	RegisterCodec("toml", TOMLCodec{})
+	RegisterCodec("json", JSONCodec{})
+	RegisterCodec("yaml", YAMLCodec{})
+	RegisterCodec("yml", YAMLCodec{})
+}
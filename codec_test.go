@@ -0,0 +1,97 @@
+package holochain
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	Convey("the built in formats should be registered", t, func() {
+		So(CodecFormats(), ShouldResemble, []string{"json", "toml", "yaml", "yml"})
+		So(IsCodecFormat("toml"), ShouldBeTrue)
+		So(IsCodecFormat("xml"), ShouldBeFalse)
+	})
+
+	Convey("it should fail on unknown formats", t, func() {
+		var buf bytes.Buffer
+		err := Encode(&buf, "xml", map[string]string{})
+		So(err.Error(), ShouldEqual, "unknown encoding format: xml, must be one of: json, toml, yaml, yml")
+		err = Decode(&buf, "xml", map[string]string{})
+		So(err.Error(), ShouldEqual, "unknown encoding format: xml, must be one of: json, toml, yaml, yml")
+	})
+
+	Convey("it should panic on duplicate registration", t, func() {
+		So(func() { RegisterCodec("toml", TOMLCodec{}) }, ShouldPanic)
+	})
+}
+
+func TestFormatRoundTrips(t *testing.T) {
+	for _, format := range []string{"toml", "json", "yaml"} {
+		d, s := setupTestService()
+		name := "test_" + format
+		path := s.Path + "/" + name
+
+		Convey("a "+format+" chain should round trip through dev, load, clone and join", t, func() {
+			h, err := s.GenDev(path, format)
+			So(err, ShouldBeNil)
+			So(fileExists(path+"/"+DNAFileName+"."+format), ShouldBeTrue)
+			So(fileExists(path+"/"+ConfigFileName+"."+format), ShouldBeTrue)
+			_, hash, err := h.BuildDNA()
+			So(err, ShouldBeNil)
+
+			f, err := s.IsConfigured(name)
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, format)
+
+			lh, err := s.Load(name)
+			So(err, ShouldBeNil)
+			So(lh.EncodingFormat(), ShouldEqual, format)
+			So(lh.Id, ShouldEqual, h.Id)
+			So(lh.Zomes["myZome"].CodeHash.String(), ShouldEqual, h.Zomes["myZome"].CodeHash.String())
+			So(lh.Zomes["jsZome"].Functions, ShouldResemble, h.Zomes["jsZome"].Functions)
+			So(lh.Properties, ShouldResemble, h.Properties)
+			_, err = lh.VerifyDNA(hash)
+			So(err, ShouldBeNil)
+
+			var buf bytes.Buffer
+			err = lh.EncodeDNA(&buf)
+			So(err, ShouldBeNil)
+			dh, err := DecodeDNA(&buf, format)
+			So(err, ShouldBeNil)
+			So(dh.Id, ShouldEqual, h.Id)
+			So(dh.Zomes["myZome"].Entries, ShouldResemble, lh.Zomes["myZome"].Entries)
+
+			ch, err := s.Clone(path, s.Path+"/joined_"+format, false)
+			So(err, ShouldBeNil)
+			So(ch.EncodingFormat(), ShouldEqual, format)
+			jh, err := s.Load("joined_" + format)
+			So(err, ShouldBeNil)
+			So(jh.Id, ShouldEqual, h.Id)
+			_, err = jh.VerifyDNA(hash)
+			So(err, ShouldBeNil)
+		})
+		cleanupTestDir(d)
+	}
+}
+
+func TestLoadMixedFormats(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should load a config in a different format from the DNA", t, func() {
+		h.config.Port = 7777
+		os.Remove(h.path + "/" + ConfigFileName + ".toml")
+		f, err := os.Create(h.path + "/" + ConfigFileName + ".yaml")
+		So(err, ShouldBeNil)
+		err = Encode(f, "yaml", &h.config)
+		f.Close()
+		So(err, ShouldBeNil)
+
+		lh, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(lh.EncodingFormat(), ShouldEqual, "toml")
+		So(lh.config.Port, ShouldEqual, 7777)
+	})
+}
@@ -0,0 +1,400 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// countersign implements entries, like trades and agreements, that are only valid once two or
+// more agents have signed them.  A DNA marks such an entry type with "Countersigners", the
+// least number of agents that must sign each of its entries.  The agent starting a session
+// proposes a countersigned header, naming the entry's type and content and the agents who
+// must sign it, to each of the other agents' nodes.  Each node checks the proposal against the
+// validation rules and asks its zome's exposed approveCountersign function whether its agent
+// agrees, and if so signs the header and remembers the session for a while.  Once every agent
+// has signed, the initiator commits the entry, holding the header and all the signatures, and
+// sends it to the other agents, who commit it to their chains too.  Every node validating the
+// entry checks that all the agents named signed it, and that it was committed by one of them,
+// before the validation rules see its content, so no agent can commit it alone.
+
+package holochain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"strings"
+	"time"
+)
+
+const (
+	CountersignFunctionName = "approveCountersign" // the zome function asked whether its agent signs a proposal
+	CountersignSessionTTL   = 10 * time.Minute     // how long a signed session waits to be completed
+
+	countersignTag        = "holochain countersigned header v1" // signed first, so a countersignature can't be passed off as any other signature
+	countersignSessionLen = 32                                  // hex digits of a session's random id
+)
+
+var ErrNotCountersigned error = errors.New("entry type is not countersigned")
+var ErrCountersignInValidation error = errors.New("countersigning isn't available in validation")
+var ErrCountersignRefused error = errors.New("agent refused to countersign")
+var ErrBadCountersignature error = errors.New("countersignature doesn't verify")
+var ErrUnknownCountersignSession error = errors.New("unknown or expired countersigning session")
+var ErrBadCountersignSession error = errors.New("countersigning session id must be 32 hex digits")
+var ErrDHTExpectedCountersignReqInBody error = errors.New("expected countersign request")
+var ErrDHTExpectedCountersignCompleteInBody error = errors.New("expected countersigned entry")
+
+// CountersignedHeader is what each of the agents of a countersigned entry signs
+type CountersignedHeader struct {
+	Session string   // random id of the countersigning session
+	Type    string   // the entry's type
+	Content string   // the entry's content
+	Agents  []string // node ids of the agents who must sign, the initiator first
+	Time    time.Time
+}
+
+// Countersignature is an agent's signature of a countersigned header
+type Countersignature struct {
+	Agent     string
+	PubKey    string // base58 encoded public key of the agent
+	Signature string // base58 signature by PubKey of the header
+}
+
+// CountersignedEntry is the content of a countersigned entry, its header signed by each of
+// its agents in order
+type CountersignedEntry struct {
+	Header     CountersignedHeader
+	Signatures []Countersignature
+}
+
+// CountersignReq asks an agent's node to countersign a header
+type CountersignReq struct {
+	Header CountersignedHeader
+}
+
+// CountersignComplete sends the agents the countersigned entry to commit
+type CountersignComplete struct {
+	Entry string // the countersigned entry's content as committed by the initiator
+}
+
+// signed returns the bytes of the header that the agents sign
+func (hd *CountersignedHeader) signed() []byte {
+	return []byte(strings.Join([]string{countersignTag, hd.Session, hd.Type, hd.Content, strings.Join(hd.Agents, ","), hd.Time.UTC().Format(time.RFC3339Nano)}, "\n"))
+}
+
+// Sign signs the header as the holder of a key
+func (hd *CountersignedHeader) Sign(key ic.PrivKey) (s Countersignature, err error) {
+	var pub []byte
+	if pub, err = ic.MarshalPublicKey(key.GetPublic()); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(key.GetPublic()); err != nil {
+		return
+	}
+	var sig []byte
+	if sig, err = key.Sign(hd.signed()); err != nil {
+		return
+	}
+	s = Countersignature{Agent: peer.IDB58Encode(id), PubKey: b58.Encode(pub), Signature: b58.Encode(sig)}
+	return
+}
+
+// Verify checks that the countersignature is its agent's signature of a header
+func (s *Countersignature) Verify(hd *CountersignedHeader) (err error) {
+	if err = verifySigned(s.Agent, s.PubKey, hd.signed(), s.Signature); err == ErrBadRelaySignature {
+		err = ErrBadCountersignature
+	}
+	return
+}
+
+// check checks that a header has a well formed session id and names enough agents, none of
+// them twice
+func (hd *CountersignedHeader) check(min int) (err error) {
+	if len(hd.Session) != countersignSessionLen {
+		return ErrBadCountersignSession
+	}
+	if _, err = hex.DecodeString(hd.Session); err != nil {
+		return ErrBadCountersignSession
+	}
+	if len(hd.Agents) < min {
+		return fmt.Errorf("%s entries must be countersigned by %d agents, got %d", hd.Type, min, len(hd.Agents))
+	}
+	seen := make(map[string]bool)
+	for _, a := range hd.Agents {
+		if seen[a] {
+			return fmt.Errorf("agent %s is named twice in the countersigned header", a)
+		}
+		seen[a] = true
+	}
+	return
+}
+
+// Verify checks that every agent named in the header signed it
+func (e *CountersignedEntry) Verify(min int) (err error) {
+	if err = e.Header.check(min); err != nil {
+		return
+	}
+	if len(e.Signatures) != len(e.Header.Agents) {
+		return fmt.Errorf("countersigned entry has %d signatures for %d agents", len(e.Signatures), len(e.Header.Agents))
+	}
+	for i, s := range e.Signatures {
+		if s.Agent != e.Header.Agents[i] {
+			return fmt.Errorf("countersignature %d is by %s, not %s", i, s.Agent, e.Header.Agents[i])
+		}
+		if err = s.Verify(&e.Header); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// checkCountersigners checks an entry type's count of countersigners
+func (d *EntryDef) checkCountersigners() (err error) {
+	if d.Countersigners < 0 || d.Countersigners == 1 {
+		err = fmt.Errorf("entry type %s countersigners must be at least 2, got %d", d.Name, d.Countersigners)
+	} else if d.Countersigners > 0 && d.CRDT != "" {
+		err = fmt.Errorf("crdt entry type %s can't be countersigned", d.Name)
+	}
+	return
+}
+
+// checkCountersigned checks that a countersigned entry is signed by all its agents and was
+// committed by one of them, returning its content for the validation rules
+func (h *Holochain) checkCountersigned(entryType string, d *EntryDef, entry Entry, props *ValidationProps) (content Entry, err error) {
+	c, ok := entry.Content().(string)
+	if !ok {
+		return nil, errors.New("countersigned entry should be a string")
+	}
+	var e CountersignedEntry
+	if err = json.Unmarshal([]byte(c), &e); err != nil {
+		return nil, fmt.Errorf("bad countersigned entry: %v", err)
+	}
+	if e.Header.Type != entryType {
+		return nil, fmt.Errorf("countersigned entry of type %s committed as %s", e.Header.Type, entryType)
+	}
+	if err = e.Verify(d.Countersigners); err != nil {
+		return
+	}
+	if len(props.Sources) > 0 && !hasString(e.Header.Agents, props.Sources[0]) {
+		return nil, fmt.Errorf("countersigned entry committed by %s, who isn't one of its agents", props.Sources[0])
+	}
+	props.Agents = e.Header.Agents
+	content = &GobEntry{C: e.Header.Content}
+	return
+}
+
+func hasString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func countersignSessionKey(session string) string {
+	return "csign:" + session
+}
+
+// checkCountersignProposal checks a header proposed by an agent for this node's agent to
+// sign, returning the zome and definition of its entry type
+func (h *Holochain) checkCountersignProposal(from peer.ID, hd *CountersignedHeader) (z *Zome, d *EntryDef, err error) {
+	if z, d, err = h.GetEntryDef(hd.Type); err != nil {
+		return
+	}
+	if d.Countersigners == 0 {
+		err = ErrNotCountersigned
+		return
+	}
+	if err = hd.check(d.Countersigners); err != nil {
+		return
+	}
+	if hd.Agents[0] != peer.IDB58Encode(from) {
+		err = fmt.Errorf("countersigning session of %s proposed by %v", hd.Agents[0], from)
+		return
+	}
+	me := peer.IDB58Encode(h.id)
+	if hd.Agents[0] == me || !hasString(hd.Agents, me) {
+		err = errors.New("this node's agent isn't asked to countersign")
+		return
+	}
+	if err = h.checkHeaderTime(hd.Time); err != nil {
+		return
+	}
+	if time.Since(hd.Time) > CountersignSessionTTL {
+		err = errors.New("stale countersigning session")
+	}
+	return
+}
+
+// handleCountersignReq signs a proposed header if it validates and the agent's zome approves
+// it, remembering the session so the entry can be committed when it is complete
+func (dht *DHT) handleCountersignReq(from peer.ID, req *CountersignReq) (response interface{}, err error) {
+	h := dht.h
+	hd := req.Header
+	var z *Zome
+	var d *EntryDef
+	if z, d, err = h.checkCountersignProposal(from, &hd); err != nil {
+		return
+	}
+	p := ValidationProps{Sources: []string{peer.IDB58Encode(from)}, Agents: hd.Agents}
+	if err = h.validateEntryContent(z, d, &GobEntry{C: hd.Content}, &p); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(hd); err != nil {
+		return
+	}
+	// the zome decides on behalf of its agent, knowing which agent proposed the session
+	var n Nucleus
+	if n, err = h.WithCaller(from).makeNucleus(z); err != nil {
+		return
+	}
+	var approved interface{}
+	if approved, err = n.Call(CountersignFunctionName, string(b)); err != nil {
+		err = fmt.Errorf("%v: %v", ErrCountersignRefused, err)
+		return
+	}
+	if fmt.Sprintf("%v", approved) != "true" {
+		h.metrics.Inc("countersign.refused", 1)
+		err = ErrCountersignRefused
+		return
+	}
+	var s Countersignature
+	if s, err = hd.Sign(h.agent.PrivKey()); err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(countersignSessionKey(hd.Session), sumBytes(hd.signed()), &buntdb.SetOptions{Expires: true, TTL: CountersignSessionTTL})
+		return err
+	})
+	if err != nil {
+		return
+	}
+	h.metrics.Inc("countersign.signed", 1)
+	response = s
+	return
+}
+
+// handleCountersignComplete commits a countersigned entry whose session this node's agent
+// signed, responding with its hash
+func (dht *DHT) handleCountersignComplete(from peer.ID, c *CountersignComplete) (response interface{}, err error) {
+	var e CountersignedEntry
+	if err = json.Unmarshal([]byte(c.Entry), &e); err != nil {
+		return
+	}
+	if len(e.Header.Agents) == 0 || e.Header.Agents[0] != peer.IDB58Encode(from) {
+		err = fmt.Errorf("countersigned entry sent by %v, who didn't start its session", from)
+		return
+	}
+	key := countersignSessionKey(e.Header.Session)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		sum, err := tx.Get(key)
+		if err == buntdb.ErrNotFound || (err == nil && sum != sumBytes(e.Header.signed())) {
+			return ErrUnknownCountersignSession
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+	var hash Hash
+	if hash, err = dht.h.Commit(e.Header.Type, c.Entry); err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(key)
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	})
+	response = hash
+	return
+}
+
+// Countersign proposes an entry to the other agents who must sign it, and once they all have
+// commits it and sends it to them to commit too.  Agents that can't be sent the signed entry
+// are logged, as they can still find it in the DHT by its hash.
+func (h *Holochain) Countersign(entryType string, content string, agents []peer.ID) (hash Hash, e *CountersignedEntry, err error) {
+	if entryType, err = h.EntryTypeName(entryType); err != nil {
+		return
+	}
+	var z *Zome
+	var d *EntryDef
+	if z, d, err = h.GetEntryDef(entryType); err != nil {
+		return
+	}
+	if d.Countersigners == 0 {
+		err = ErrNotCountersigned
+		return
+	}
+	session := make([]byte, countersignSessionLen/2)
+	if _, err = rand.Read(session); err != nil {
+		return
+	}
+	x := CountersignedEntry{Header: CountersignedHeader{
+		Session: hex.EncodeToString(session),
+		Type:    entryType,
+		Content: content,
+		Agents:  []string{peer.IDB58Encode(h.id)},
+		Time:    time.Now(),
+	}}
+	for _, a := range agents {
+		x.Header.Agents = append(x.Header.Agents, peer.IDB58Encode(a))
+	}
+	if err = x.Header.check(d.Countersigners); err != nil {
+		return
+	}
+	p := ValidationProps{Sources: []string{peer.IDB58Encode(h.id)}, Agents: x.Header.Agents}
+	if err = h.validateEntryContent(z, d, &GobEntry{C: content}, &p); err != nil {
+		return
+	}
+	var s Countersignature
+	if s, err = x.Header.Sign(h.agent.PrivKey()); err != nil {
+		return
+	}
+	x.Signatures = append(x.Signatures, s)
+
+	// every agent must sign before anything is committed
+	for _, a := range agents {
+		if h.peerLacks(a, FeatureCountersign) {
+			err = fmt.Errorf("agent %v's node can't countersign", a)
+			return
+		}
+		var r interface{}
+		if r, err = h.dht.send(a, COUNTERSIGN_REQUEST, CountersignReq{Header: x.Header}); err != nil {
+			err = fmt.Errorf("agent %v didn't countersign: %v", a, err)
+			return
+		}
+		s, ok := r.(Countersignature)
+		if !ok || s.Agent != peer.IDB58Encode(a) {
+			err = fmt.Errorf("unexpected countersignature from %v", a)
+			return
+		}
+		if err = s.Verify(&x.Header); err != nil {
+			return
+		}
+		x.Signatures = append(x.Signatures, s)
+	}
+
+	var b []byte
+	if b, err = json.Marshal(&x); err != nil {
+		return
+	}
+	if hash, err = h.Commit(entryType, string(b)); err != nil {
+		return
+	}
+	e = &x
+	h.metrics.Inc("countersign.committed", 1)
+	for _, a := range agents {
+		if _, err := h.dht.send(a, COUNTERSIGN_COMPLETE, CountersignComplete{Entry: string(b)}); err != nil {
+			h.dht.dlog.Logf("countersigned entry %v not committed by %v: %v", hash, a, err)
+		}
+	}
+	return
+}
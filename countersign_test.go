@@ -0,0 +1,152 @@
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountersigners(t *testing.T) {
+	Convey("countersigned entry types should need at least two agents", t, func() {
+		So((&EntryDef{Name: "trade"}).checkCountersigners(), ShouldBeNil)
+		So((&EntryDef{Name: "trade", Countersigners: 2}).checkCountersigners(), ShouldBeNil)
+		So((&EntryDef{Name: "trade", Countersigners: 1}).checkCountersigners(), ShouldNotBeNil)
+		So((&EntryDef{Name: "trade", Countersigners: -1}).checkCountersigners(), ShouldNotBeNil)
+		So((&EntryDef{Name: "trade", Countersigners: 2, CRDT: CRDTCounter}).checkCountersigners(), ShouldNotBeNil)
+	})
+}
+
+func TestCountersign(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	h.Zomes["jsZome"].Entries["trade"] = EntryDef{Name: "trade", DataFormat: DataFormatJSON, Countersigners: 2}
+	code, _ := readFile(h.path, h.Zomes["jsZome"].Code)
+	code = append(code, []byte("\nfunction validate(entry_type,entry,props) {return entry_type != \"trade\" || props.Agents.length == 2}\nfunction approveCountersign(p) {return p.Content.indexOf(\"ok\") >= 0 && caller().ID == p.Agents[0]}\nexpose(\"approveCountersign\",HC.JSON);\n")...)
+	ioutil.WriteFile(h.path+"/"+h.Zomes["jsZome"].Code, code, os.ModePerm)
+
+	key, _, err := ic.GenerateEd25519Key(strings.NewReader("countersigning agent12345678901234567890123456789012345678"))
+	if err != nil {
+		panic(err)
+	}
+	other, err := peer.IDFromPublicKey(key.GetPublic())
+	if err != nil {
+		panic(err)
+	}
+	me := peer.IDB58Encode(h.id)
+	session := func(n int) string {
+		return fmt.Sprintf("%032x", n)
+	}
+	propose := func(session string, content string) CountersignedHeader {
+		return CountersignedHeader{Session: session, Type: "trade", Content: content, Agents: []string{peer.IDB58Encode(other), me}, Time: time.Now()}
+	}
+
+	Convey("a node should countersign proposals its agent's zome approves", t, func() {
+		hd := propose(session(1), `{"item":"ok"}`)
+		r, err := h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldBeNil)
+		s := r.(Countersignature)
+		So(s.Agent, ShouldEqual, me)
+		So(s.Verify(&hd), ShouldBeNil)
+		hd.Content = `{"item":"changed ok"}`
+		So(s.Verify(&hd), ShouldEqual, ErrBadCountersignature)
+		So(h.Metrics().Get("countersign.signed"), ShouldEqual, 1)
+	})
+
+	Convey("a node should refuse proposals its agent's zome doesn't approve", t, func() {
+		_, err := h.dht.handleCountersignReq(other, &CountersignReq{Header: propose(session(2), `{"item":"no"}`)})
+		So(err, ShouldEqual, ErrCountersignRefused)
+	})
+
+	Convey("a node should refuse malformed proposals", t, func() {
+		_, err := h.dht.handleCountersignReq(h.id, &CountersignReq{Header: propose(session(3), `{"item":"ok"}`)})
+		So(err, ShouldNotBeNil)
+		hd := propose(session(3), "2")
+		hd.Type = "myData"
+		_, err = h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldEqual, ErrNotCountersigned)
+		hd = propose(session(3), `{"item":"ok"}`)
+		hd.Agents = hd.Agents[:1]
+		_, err = h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldNotBeNil)
+		hd = propose("s3", `{"item":"ok"}`)
+		_, err = h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldEqual, ErrBadCountersignSession)
+		hd = propose(strings.Repeat("z", 32), `{"item":"ok"}`)
+		_, err = h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldEqual, ErrBadCountersignSession)
+		hd = propose(session(3), `{"item":"ok"}`)
+		hd.Time = time.Now().Add(-time.Hour)
+		_, err = h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("countersigned entries should only be valid with all their agents' signatures", t, func() {
+		hd := propose(session(4), `{"item":"ok"}`)
+		mine, err := hd.Sign(h.agent.PrivKey())
+		So(err, ShouldBeNil)
+		theirs, err := hd.Sign(key)
+		So(err, ShouldBeNil)
+		entry := func(sigs ...Countersignature) string {
+			b, _ := json.Marshal(CountersignedEntry{Header: hd, Signatures: sigs})
+			return string(b)
+		}
+		_, err = h.Commit("trade", entry(theirs))
+		So(err, ShouldNotBeNil)
+		_, err = h.Commit("trade", entry(mine, theirs))
+		So(err, ShouldNotBeNil)
+		_, err = h.Commit("trade", `{"item":"ok"}`)
+		So(err, ShouldNotBeNil)
+
+		p := ValidationProps{Sources: []string{"someone else"}}
+		So(h.ValidateEntry("trade", &GobEntry{C: entry(theirs, mine)}, &p), ShouldNotBeNil)
+		p = ValidationProps{Sources: []string{peer.IDB58Encode(other)}}
+		So(h.ValidateEntry("trade", &GobEntry{C: entry(theirs, mine)}, &p), ShouldBeNil)
+		So(p.Agents, ShouldResemble, hd.Agents)
+	})
+
+	Convey("a node should commit the completed entries of sessions it signed", t, func() {
+		hd := propose(session(5), `{"item":"ok"}`)
+		r, err := h.dht.handleCountersignReq(other, &CountersignReq{Header: hd})
+		So(err, ShouldBeNil)
+		theirs, _ := hd.Sign(key)
+		b, _ := json.Marshal(CountersignedEntry{Header: hd, Signatures: []Countersignature{theirs, r.(Countersignature)}})
+		c := CountersignComplete{Entry: string(b)}
+
+		_, err = h.dht.handleCountersignComplete(h.id, &c)
+		So(err, ShouldNotBeNil)
+		resp, err := h.dht.handleCountersignComplete(other, &c)
+		So(err, ShouldBeNil)
+		hash := resp.(Hash)
+		e, entryType, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "trade")
+		So(e.Content(), ShouldEqual, string(b))
+
+		_, err = h.dht.handleCountersignComplete(other, &c)
+		So(err, ShouldEqual, ErrUnknownCountersignSession)
+
+		hd.Session = session(6)
+		theirs, _ = hd.Sign(key)
+		mine, _ := hd.Sign(h.agent.PrivKey())
+		b, _ = json.Marshal(CountersignedEntry{Header: hd, Signatures: []Countersignature{theirs, mine}})
+		_, err = h.dht.handleCountersignComplete(other, &CountersignComplete{Entry: string(b)})
+		So(err, ShouldEqual, ErrUnknownCountersignSession)
+	})
+
+	Convey("starting a session should need a countersigned type and enough agents", t, func() {
+		_, _, err := h.Countersign("myData", "2", []peer.ID{other})
+		So(err, ShouldEqual, ErrNotCountersigned)
+		_, _, err = h.Countersign("trade", `{"item":"ok"}`, nil)
+		So(err, ShouldNotBeNil)
+		_, _, err = h.Countersign("trade", `{"item":"ok"}`, []peer.ID{h.id})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// coverage implements recording of which zome functions and validation outcomes were
+// exercised, so that app developers can see what their test data actually covers.  Nuclei
+// count a function each time its body runs, so calls that fail before reaching the body
+// don't count and calls from other functions in the zome do.
+
+package holochain
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	ValidationAccepted = "accepted"
+	ValidationRejected = "rejected"
+)
+
+// Coverage counts calls to zome functions and the validation outcomes of each entry type
+// All methods are safe to call on a nil Coverage, in which case they do nothing.
+type Coverage struct {
+	lk          sync.Mutex
+	calls       map[string]int // keyed by zome:function
+	validations map[string]int // keyed by entryType:outcome
+}
+
+// CoverageItem reports how many times a function or validation outcome was exercised
+type CoverageItem struct {
+	Name  string
+	Count int
+}
+
+// ZomeCoverage reports the coverage of a single zome
+type ZomeCoverage struct {
+	Zome        string
+	Functions   []CoverageItem
+	Validations []CoverageItem // named entryType:accepted or entryType:rejected
+	Percent     float64
+}
+
+// NewCoverage creates an empty coverage record
+func NewCoverage() *Coverage {
+	return &Coverage{calls: make(map[string]int), validations: make(map[string]int)}
+}
+
+func (c *Coverage) call(zome string, function string) {
+	if c == nil {
+		return
+	}
+	c.lk.Lock()
+	c.calls[zome+":"+function]++
+	c.lk.Unlock()
+}
+
+func (c *Coverage) validation(entryType string, err error) {
+	if c == nil {
+		return
+	}
+	outcome := ValidationAccepted
+	if err != nil {
+		outcome = ValidationRejected
+	}
+	c.lk.Lock()
+	c.validations[entryType+":"+outcome]++
+	c.lk.Unlock()
+}
+
+// EnableCoverage starts recording coverage of the holochain's zomes
+func (h *Holochain) EnableCoverage() {
+	h.coverage = NewCoverage()
+}
+
+// CoverageReport returns the coverage of each of the holochain's zomes, sorted by zome name.
+// A zome is fully covered when each of its exposed functions has been called and each of its
+// entry types has been both accepted and rejected by validation.
+func (h *Holochain) CoverageReport() (report []ZomeCoverage, err error) {
+	c := h.coverage
+	if c == nil {
+		c = NewCoverage()
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	var names []string
+	for name := range h.Zomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		z := h.Zomes[name]
+		var n Nucleus
+		n, err = h.makeNucleus(z)
+		if err != nil {
+			return
+		}
+		zc := ZomeCoverage{Zome: name}
+		var total, covered int
+		for _, i := range n.Interfaces() {
+			count := c.calls[name+":"+i.Name]
+			zc.Functions = append(zc.Functions, CoverageItem{Name: i.Name, Count: count})
+			total++
+			if count > 0 {
+				covered++
+			}
+		}
+		var types []string
+		for t := range z.Entries {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			for _, outcome := range []string{ValidationAccepted, ValidationRejected} {
+				item := t + ":" + outcome
+				count := c.validations[item]
+				zc.Validations = append(zc.Validations, CoverageItem{Name: item, Count: count})
+				total++
+				if count > 0 {
+					covered++
+				}
+			}
+		}
+		if total > 0 {
+			zc.Percent = 100 * float64(covered) / float64(total)
+		}
+		report = append(report, zc)
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func findCoverageItem(items []CoverageItem, name string) (count int, found bool) {
+	for _, i := range items {
+		if i.Name == name {
+			return i.Count, true
+		}
+	}
+	return
+}
+
+func TestCoverage(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should do nothing when not enabled", t, func() {
+		h.Call("myZome", "getDNA", "")
+		report, err := h.CoverageReport()
+		So(err, ShouldBeNil)
+		So(report[0].Zome, ShouldEqual, "jsZome")
+		So(report[1].Zome, ShouldEqual, "myZome")
+		count, found := findCoverageItem(report[1].Functions, "getDNA")
+		So(found, ShouldBeTrue)
+		So(count, ShouldEqual, 0)
+		So(report[1].Percent, ShouldEqual, 0)
+	})
+
+	Convey("it should record calls and validation outcomes", t, func() {
+		h.EnableCoverage()
+		_, err := h.Call("myZome", "getDNA", "")
+		So(err, ShouldBeNil)
+		p := ValidationProps{}
+		So(h.ValidateEntry("myData", &GobEntry{C: "2"}, &p), ShouldBeNil)
+		So(h.ValidateEntry("myData", &GobEntry{C: "1"}, &p), ShouldNotBeNil)
+
+		report, err := h.CoverageReport()
+		So(err, ShouldBeNil)
+		z := report[1]
+		count, _ := findCoverageItem(z.Functions, "getDNA")
+		So(count, ShouldEqual, 1)
+		count, _ = findCoverageItem(z.Validations, "myData:"+ValidationAccepted)
+		So(count, ShouldEqual, 1)
+		count, _ = findCoverageItem(z.Validations, "myData:"+ValidationRejected)
+		So(count, ShouldEqual, 1)
+		_, found := findCoverageItem(z.Validations, "primes:"+ValidationRejected)
+		So(found, ShouldBeTrue)
+
+		total := len(z.Functions) + len(z.Validations)
+		So(z.Percent, ShouldAlmostEqual, 100*3/float64(total))
+	})
+}
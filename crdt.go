@@ -0,0 +1,322 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// crdt implements built in conflict-free replicated data types.  Updates to a CRDT object
+// are committed as entries whose type is declared as a CRDT in the DNA, and DHT nodes merge
+// them deterministically as they receive them, so all nodes converge on the same value
+// regardless of the order in which updates arrive.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CRDTGrowOnlySet = "gset"    // a set to which values can only be added
+	CRDTLWWRegister = "lww"     // a single value where the latest write wins
+	CRDTCounter     = "counter" // an integer which can be incremented or decremented
+)
+
+var ErrCRDTObjectNotFound error = errors.New("crdt object not found")
+
+// CRDTOp is the content of an entry that updates a CRDT object
+type CRDTOp struct {
+	Object string      // identifies the object being updated
+	Value  interface{} // the value to add, set, or increment by
+	Time   time.Time   // when the update was made, used to order LWW register writes
+	Cause  string      `json:",omitempty"` // the hash of the entry that led to the update, if any, for validation to check the update against
+}
+
+// CRDTState holds the merged state of a CRDT object
+type CRDTState struct {
+	Kind    string
+	Members []string    `json:",omitempty"` // gset: sorted json encoded members
+	Value   interface{} `json:",omitempty"` // lww: the current value
+	Time    time.Time   // lww: time of the current value
+	Author  string      `json:",omitempty"` // lww: author of the current value, used to break time ties
+	Count   int64       // counter: the current count
+}
+
+func checkCRDTKind(kind string) (err error) {
+	switch kind {
+	case "", CRDTGrowOnlySet, CRDTLWWRegister, CRDTCounter:
+	default:
+		err = fmt.Errorf("unknown crdt kind: %s", kind)
+	}
+	return
+}
+
+// parseCRDTOp extracts the update from the content of a CRDT entry
+func parseCRDTOp(kind string, entry Entry) (op CRDTOp, err error) {
+	s, ok := entry.Content().(string)
+	if !ok {
+		err = errors.New("crdt entry should be json string")
+		return
+	}
+	if err = json.Unmarshal([]byte(s), &op); err != nil {
+		return
+	}
+	if op.Object == "" {
+		err = errors.New("crdt entry missing object")
+		return
+	}
+	if kind == CRDTCounter {
+		if _, ok := op.Value.(float64); !ok {
+			err = fmt.Errorf("counter value should be a number, got: %v", op.Value)
+		}
+	}
+	return
+}
+
+// apply merges an update into the state
+func (s *CRDTState) apply(op *CRDTOp, author string) (err error) {
+	switch s.Kind {
+	case CRDTGrowOnlySet:
+		var b []byte
+		b, err = json.Marshal(op.Value)
+		if err != nil {
+			return
+		}
+		m := string(b)
+		i := sort.SearchStrings(s.Members, m)
+		if i == len(s.Members) || s.Members[i] != m {
+			s.Members = append(s.Members, "")
+			copy(s.Members[i+1:], s.Members[i:])
+			s.Members[i] = m
+		}
+	case CRDTLWWRegister:
+		if op.Time.After(s.Time) || (op.Time.Equal(s.Time) && author > s.Author) {
+			s.Value = op.Value
+			s.Time = op.Time
+			s.Author = author
+		}
+	case CRDTCounter:
+		s.Count += int64(op.Value.(float64))
+	default:
+		err = fmt.Errorf("unknown crdt kind: %s", s.Kind)
+	}
+	return
+}
+
+// Current returns the current value of the object: a list for sets, the value of a register,
+// or the count of a counter
+func (s *CRDTState) Current() (v interface{}, err error) {
+	switch s.Kind {
+	case CRDTGrowOnlySet:
+		members := make([]interface{}, len(s.Members))
+		for i, m := range s.Members {
+			if err = json.Unmarshal([]byte(m), &members[i]); err != nil {
+				return
+			}
+		}
+		v = members
+	case CRDTLWWRegister:
+		v = s.Value
+	case CRDTCounter:
+		v = s.Count
+	}
+	return
+}
+
+// crdtKey returns the key the state of a CRDT object is kept under.  Objects are namespaced
+// by the entry type that updates them and its kind of CRDT, so that entry types using the
+// same object names don't collide.
+func crdtKey(entryType string, kind string, object string) string {
+	return entryType + ":" + kind + ":" + object
+}
+
+// crdtUpdateOf returns the update an entry makes and the kind of CRDT it updates, which is
+// empty if the entry's type isn't a CRDT, so that malformed updates are refused before the
+// entry is stored
+func (dht *DHT) crdtUpdateOf(entryType string, entry Entry) (kind string, op CRDTOp, err error) {
+	_, d, e := dht.h.GetEntryDef(entryType)
+	if e != nil || d.CRDT == "" {
+		// system entry types have no def
+		return
+	}
+	if op, err = parseCRDTOp(d.CRDT, entry); err == nil {
+		kind = d.CRDT
+	}
+	return
+}
+
+// applyCRDT merges an update entry into the stored state of its object.  Updates are
+// recorded by hash so that receiving the same update again (i.e. by gossip) has no effect.
+func (dht *DHT) applyCRDT(key Hash, entryType string, kind string, op *CRDTOp, author peer.ID) (err error) {
+	ns := crdtKey(entryType, kind, op.Object)
+	opKey := "crdtop:" + ns + ":" + key.String()
+	stateKey := "crdt:" + ns
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(opKey)
+		if err == nil {
+			return nil
+		}
+		if err != buntdb.ErrNotFound {
+			return err
+		}
+		state := CRDTState{Kind: kind}
+		val, err := tx.Get(stateKey)
+		if err == nil {
+			if err = json.Unmarshal([]byte(val), &state); err != nil {
+				return err
+			}
+		} else if err != buntdb.ErrNotFound {
+			return err
+		}
+		if err = state.apply(op, peer.IDB58Encode(author)); err != nil {
+			return err
+		}
+		b, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if _, _, err = tx.Set(stateKey, string(b), nil); err != nil {
+			return err
+		}
+		_, _, err = tx.Set(opKey, "", nil)
+		return err
+	})
+	return
+}
+
+// rebuildCRDT recomputes the state of the CRDT object that an entry held in the DHT store
+// updates from the object's updates that aren't quarantined, for when the entry is quarantined
+// or released
+func (dht *DHT) rebuildCRDT(tx *buntdb.Tx, k string) (err error) {
+	var entryType, kind string
+	var op CRDTOp
+	if entryType, kind, op, err = storedCRDTOp(tx, dht.h, k); err != nil || kind == "" {
+		// entries that aren't well formed updates were never merged
+		err = nil
+		return
+	}
+	ns := crdtKey(entryType, kind, op.Object)
+	prefix := "crdtop:" + ns + ":"
+	var keys []string
+	tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		// the updates of objects whose names extend this one's follow it
+		if key = strings.TrimPrefix(key, prefix); !strings.Contains(key, ":") {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	state := CRDTState{Kind: kind}
+	quarantined := fmt.Sprintf("%d", QUARANTINED)
+	for _, key := range keys {
+		if status, e := tx.Get("status:" + key); e != nil || status == quarantined {
+			continue
+		}
+		var o CRDTOp
+		if _, _, o, err = storedCRDTOp(tx, dht.h, key); err != nil {
+			return
+		}
+		src, _ := tx.Get("src:" + key)
+		if err = state.apply(&o, src); err != nil {
+			return
+		}
+	}
+	var b []byte
+	if b, err = json.Marshal(state); err != nil {
+		return
+	}
+	_, _, err = tx.Set("crdt:"+ns, string(b), nil)
+	return
+}
+
+// storedCRDTOp returns the update an entry held in the DHT store makes, its type, and the
+// kind of CRDT it updates, which is empty if the entry's type isn't a CRDT
+func storedCRDTOp(tx *buntdb.Tx, h *Holochain, k string) (entryType string, kind string, op CRDTOp, err error) {
+	var data string
+	if entryType, err = tx.Get("type:" + k); err != nil {
+		return
+	}
+	_, d, e := h.GetEntryDef(entryType)
+	if e != nil || d.CRDT == "" {
+		return
+	}
+	if data, err = tx.Get("entry:" + k); err != nil {
+		return
+	}
+	var entry GobEntry
+	if err = entry.Unmarshal([]byte(data)); err != nil {
+		return
+	}
+	if op, err = parseCRDTOp(d.CRDT, &entry); err == nil {
+		kind = d.CRDT
+	}
+	return
+}
+
+// GetCRDT returns the merged state of a CRDT object updated by entries of the given type
+func (dht *DHT) GetCRDT(entryType string, object string) (state *CRDTState, err error) {
+	var d *EntryDef
+	if _, d, err = dht.h.GetEntryDef(entryType); err != nil {
+		return
+	}
+	if d.CRDT == "" {
+		err = fmt.Errorf("entry type %s is not a crdt", entryType)
+		return
+	}
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("crdt:" + crdtKey(d.Name, d.CRDT, object))
+		if err == buntdb.ErrNotFound {
+			return ErrCRDTObjectNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var s CRDTState
+		err = json.Unmarshal([]byte(val), &s)
+		state = &s
+		return err
+	})
+	return
+}
+
+// CRDTUpdate commits an update to a CRDT object and publishes it to the DHT.  The entry type
+// must be declared in the DNA as the given kind of CRDT.  If cause isn't empty it is the hash
+// of the entry that led to the update, which the entry type can declare as a dependency.
+func (h *Holochain) CRDTUpdate(kind string, entryType string, object string, value interface{}, cause string) (hash Hash, err error) {
+	var d *EntryDef
+	_, d, err = h.GetEntryDef(entryType)
+	if err != nil {
+		return
+	}
+	if d.CRDT != kind {
+		err = fmt.Errorf("entry type %s is not a %s crdt", entryType, kind)
+		return
+	}
+	var b []byte
+	b, err = json.Marshal(CRDTOp{Object: object, Value: value, Time: time.Now(), Cause: cause})
+	if err != nil {
+		return
+	}
+	if hash, err = h.Commit(entryType, string(b)); err != nil {
+		return
+	}
+	err = h.dht.SendPut(hash)
+	return
+}
+
+// CRDTValue returns the current value of a CRDT object updated by entries of the given type
+func (h *Holochain) CRDTValue(entryType string, object string) (v interface{}, err error) {
+	var s *CRDTState
+	s, err = h.dht.GetCRDT(entryType, object)
+	if err != nil {
+		return
+	}
+	v, err = s.Current()
+	return
+}
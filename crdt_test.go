@@ -0,0 +1,124 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCRDTState(t *testing.T) {
+	now := time.Now()
+	Convey("grow only sets should converge regardless of order", t, func() {
+		s1 := CRDTState{Kind: CRDTGrowOnlySet}
+		s2 := CRDTState{Kind: CRDTGrowOnlySet}
+		ops := []CRDTOp{{Value: "b"}, {Value: "a"}, {Value: "b"}, {Value: 3.0}}
+		for i := range ops {
+			So(s1.apply(&ops[i], "x"), ShouldBeNil)
+			So(s2.apply(&ops[len(ops)-1-i], "x"), ShouldBeNil)
+		}
+		So(s1.Members, ShouldResemble, s2.Members)
+		v, err := s1.Current()
+		So(err, ShouldBeNil)
+		So(v, ShouldResemble, []interface{}{"a", "b", 3.0})
+	})
+
+	Convey("lww registers should take the latest write, breaking ties by author", t, func() {
+		s := CRDTState{Kind: CRDTLWWRegister}
+		s.apply(&CRDTOp{Value: "new", Time: now}, "a")
+		s.apply(&CRDTOp{Value: "old", Time: now.Add(-time.Second)}, "z")
+		v, _ := s.Current()
+		So(v, ShouldEqual, "new")
+		s.apply(&CRDTOp{Value: "tie", Time: now}, "b")
+		v, _ = s.Current()
+		So(v, ShouldEqual, "tie")
+		s.apply(&CRDTOp{Value: "tie-lost", Time: now}, "a")
+		v, _ = s.Current()
+		So(v, ShouldEqual, "tie")
+	})
+
+	Convey("counters should sum", t, func() {
+		s := CRDTState{Kind: CRDTCounter}
+		s.apply(&CRDTOp{Value: 5.0}, "a")
+		s.apply(&CRDTOp{Value: -2.0}, "b")
+		v, _ := s.Current()
+		So(v, ShouldEqual, int64(3))
+	})
+}
+
+func TestCRDTUpdate(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	h.Zomes["jsZome"].Entries["likes"] = EntryDef{Name: "likes", DataFormat: DataFormatJSON, CRDT: CRDTCounter}
+	h.Zomes["jsZome"].Entries["tags"] = EntryDef{Name: "tags", DataFormat: DataFormatJSON, CRDT: CRDTGrowOnlySet}
+	h.Zomes["jsZome"].Entries["dislikes"] = EntryDef{Name: "dislikes", DataFormat: DataFormatJSON, CRDT: CRDTCounter}
+	code, _ := readFile(h.path, h.Zomes["jsZome"].Code)
+	code = append(code, []byte("\nfunction validate(entry_type,entry,props) {return true}\n")...)
+	ioutil.WriteFile(h.path+"/"+h.Zomes["jsZome"].Code, code, os.ModePerm)
+
+	for len(h.dht.puts) > 0 {
+		<-h.dht.puts
+	}
+
+	Convey("it should reject updates of the wrong kind", t, func() {
+		_, err := h.CRDTUpdate(CRDTGrowOnlySet, "likes", "post1", 1, "")
+		So(err.Error(), ShouldEqual, "entry type likes is not a gset crdt")
+	})
+
+	Convey("it should reject malformed updates", t, func() {
+		_, err := h.CRDTUpdate(CRDTCounter, "likes", "post1", "fish", "")
+		So(err.Error(), ShouldEqual, "counter value should be a number, got: fish")
+	})
+
+	Convey("it should merge updates as the DHT receives them", t, func() {
+		_, err := h.CRDTValue("likes", "post1")
+		So(err, ShouldEqual, ErrCRDTObjectNotFound)
+
+		_, err = h.CRDTUpdate(CRDTCounter, "likes", "post1", 1, "")
+		So(err, ShouldBeNil)
+		hash, err := h.CRDTUpdate(CRDTCounter, "likes", "post1", 2, "")
+		So(err, ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		v, err := h.CRDTValue("likes", "post1")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, int64(3))
+
+		// receiving the same update again shouldn't change the count
+		h.dht.SendPut(hash)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		v, _ = h.CRDTValue("likes", "post1")
+		So(v, ShouldEqual, int64(3))
+	})
+
+	Convey("it should record the entry that caused an update", t, func() {
+		hash, err := h.CRDTUpdate(CRDTCounter, "likes", "post2", 1, "QmCause")
+		So(err, ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		entry, _, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		op, err := parseCRDTOp(CRDTCounter, entry)
+		So(err, ShouldBeNil)
+		So(op.Cause, ShouldEqual, "QmCause")
+	})
+
+	Convey("it should keep the objects of different entry types apart", t, func() {
+		_, err := h.CRDTUpdate(CRDTGrowOnlySet, "tags", "post1", "fish", "")
+		So(err, ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		_, err = h.CRDTUpdate(CRDTCounter, "dislikes", "post1", 5, "")
+		So(err, ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		v, err := h.CRDTValue("tags", "post1")
+		So(err, ShouldBeNil)
+		So(v, ShouldResemble, []interface{}{"fish"})
+		v, _ = h.CRDTValue("dislikes", "post1")
+		So(v, ShouldEqual, int64(5))
+		v, _ = h.CRDTValue("likes", "post1")
+		So(v, ShouldEqual, int64(3))
+		_, err = h.CRDTValue("myData", "post1")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// deps implements the resolution of the entries that an entry being validated refers to, so
+// that validation callbacks are given the context they need rather than having to fail for
+// lack of it.  Entry types declare which of their fields hold the hashes of other entries, and
+// those entries are looked up in the chain, the local DHT store and then the network before
+// the callback runs.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// DependencyBase names the dependency on the entry being linked from when validating a link
+	DependencyBase = "%base"
+
+	DefaultDependencyTimeout   = 5000 // milliseconds to wait for a dependency from the network
+	DefaultDependencyCacheSize = 1000
+)
+
+var ErrDependencyCycle error = errors.New("validation dependency cycle")
+
+// depResolver caches resolved dependencies
+type depResolver struct {
+	lk    sync.Mutex
+	cache map[string]interface{}
+	order []string // cache keys in the order they were added, for eviction
+}
+
+func newDepResolver() *depResolver {
+	return &depResolver{cache: make(map[string]interface{})}
+}
+
+func (r *depResolver) cached(hash string) (content interface{}, ok bool) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	content, ok = r.cache[hash]
+	return
+}
+
+func (r *depResolver) add(hash string, content interface{}) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if _, ok := r.cache[hash]; ok {
+		return
+	}
+	if len(r.order) >= DefaultDependencyCacheSize {
+		delete(r.cache, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.cache[hash] = content
+	r.order = append(r.order, hash)
+}
+
+// checkDependencies confirms that an entry type's dependencies can be found in its entries
+func (d *EntryDef) checkDependencies() (err error) {
+	for _, dep := range d.Dependencies {
+		if dep != DependencyBase && d.DataFormat != DataFormatJSON {
+			err = fmt.Errorf("entry type %s must have json data format to declare dependency %s", d.Name, dep)
+			return
+		}
+	}
+	return
+}
+
+// dependencyHashes returns the hashes an entry depends on, from the fields named in its
+// type's dependencies, which may hold a hash or a list of hashes
+func dependencyHashes(d *EntryDef, entry Entry, props *ValidationProps) (hashes []string, err error) {
+	var fields map[string]interface{}
+	for _, dep := range d.Dependencies {
+		if dep == DependencyBase {
+			if props.MetaBase != "" {
+				hashes = append(hashes, props.MetaBase)
+			}
+			continue
+		}
+		if fields == nil {
+			s, ok := entry.Content().(string)
+			if !ok {
+				err = fmt.Errorf("entry type %s content should be a string", d.Name)
+				return
+			}
+			if err = json.Unmarshal([]byte(s), &fields); err != nil {
+				return
+			}
+		}
+		switch v := fields[dep].(type) {
+		case nil:
+		case string:
+			hashes = append(hashes, v)
+		case []interface{}:
+			for _, x := range v {
+				s, ok := x.(string)
+				if !ok {
+					err = fmt.Errorf("dependency %s should hold hashes", dep)
+					return
+				}
+				hashes = append(hashes, s)
+			}
+		default:
+			err = fmt.Errorf("dependency %s should hold hashes", dep)
+			return
+		}
+	}
+	return
+}
+
+// dependencyContent returns the content of an entry as a validation callback sees it, with
+// json entries decoded
+func dependencyContent(entryType string, b []byte, h *Holochain) (content interface{}, err error) {
+	var e GobEntry
+	if err = e.Unmarshal(b); err != nil {
+		return
+	}
+	content = e.C
+	if _, d, e2 := h.GetEntryDef(entryType); e2 == nil && d.DataFormat == DataFormatJSON {
+		if s, ok := e.C.(string); ok {
+			var v interface{}
+			if json.Unmarshal([]byte(s), &v) == nil {
+				content = v
+			}
+		}
+	}
+	return
+}
+
+// resolveDependency finds an entry looking in the chain, then the local DHT store, and then
+// the network.  chain holds the entries whose validation led to this one being needed, which
+// it mustn't be one of.
+func (h *Holochain) resolveDependency(hash string, chain []string) (content interface{}, err error) {
+	for _, c := range chain {
+		if c == hash {
+			err = ErrDependencyCycle
+			return
+		}
+	}
+	if c, ok := h.deps.cached(hash); ok {
+		content = c
+		h.metrics.Inc("validation.deps.cached", 1)
+		return
+	}
+	var key Hash
+	key, err = NewHash(hash)
+	if err != nil {
+		return
+	}
+	var b []byte
+	var entryType string
+	if entry, t, e := h.chain.GetEntry(key); e == nil && entry != nil {
+		if b, err = entry.Marshal(); err != nil {
+			return
+		}
+		entryType = t
+	} else if h.dht != nil {
+		var status int
+		b, entryType, status, err = h.dht.get(key)
+		if err == nil && status != LIVE {
+			err = ErrHashNotFound
+		}
+		if err == ErrHashNotFound && h.node != nil {
+			h.metrics.Inc("validation.deps.fetched", 1)
+			var r interface{}
+			r, err = h.dht.SendGetWithOptions(key, GetOptions{Timeout: DefaultDependencyTimeout})
+			if err == nil {
+				e, ok := r.(*GobEntry)
+				if !ok {
+					err = fmt.Errorf("unexpected response type from get: %T", r)
+					return
+				}
+				// what a peer sends must be the entry asked for
+				var sum Hash
+				if sum, err = e.Sum(h.hashSpec); err != nil {
+					return
+				}
+				if sum.String() != key.String() {
+					err = errors.New("dependency fetched doesn't match its hash")
+					return
+				}
+				b, err = e.Marshal()
+			}
+		}
+	} else {
+		err = ErrHashNotFound
+	}
+	if err != nil {
+		return
+	}
+	content, err = dependencyContent(entryType, b, h)
+	if err == nil {
+		h.deps.add(hash, content)
+	}
+	return
+}
+
+// resolveDependencies finds the entries that an entry depends on, keyed by their hashes
+func (h *Holochain) resolveDependencies(d *EntryDef, entry Entry, props *ValidationProps) (deps map[string]interface{}, err error) {
+	var hashes []string
+	hashes, err = dependencyHashes(d, entry, props)
+	if err != nil || len(hashes) == 0 {
+		return
+	}
+	// the chain is particular to this validation, so concurrent validations of the same entry
+	// don't look like a cycle to each other
+	var chain []string
+	if props.Hash != "" {
+		chain = []string{props.Hash}
+	}
+	deps = make(map[string]interface{})
+	for _, hash := range hashes {
+		var content interface{}
+		content, err = h.resolveDependency(hash, chain)
+		if err != nil {
+			err = fmt.Errorf("unable to resolve validation dependency %s: %v", hash, err)
+			return
+		}
+		deps[hash] = content
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDependencyHashes(t *testing.T) {
+	d := &EntryDef{Name: "post", DataFormat: DataFormatJSON, Dependencies: []string{"author", "replyTo", DependencyBase}}
+
+	Convey("it should find hashes in single and list fields", t, func() {
+		e := GobEntry{C: `{"author":"QmA","replyTo":["QmB","QmC"]}`}
+		hashes, err := dependencyHashes(d, &e, &ValidationProps{})
+		So(err, ShouldBeNil)
+		So(hashes, ShouldResemble, []string{"QmA", "QmB", "QmC"})
+	})
+
+	Convey("it should skip missing fields and include the base of links", t, func() {
+		e := GobEntry{C: `{"author":"QmA"}`}
+		hashes, err := dependencyHashes(d, &e, &ValidationProps{MetaBase: "QmBase"})
+		So(err, ShouldBeNil)
+		So(hashes, ShouldResemble, []string{"QmA", "QmBase"})
+	})
+
+	Convey("it should reject fields that don't hold hashes", t, func() {
+		e := GobEntry{C: `{"author":3}`}
+		_, err := dependencyHashes(d, &e, &ValidationProps{})
+		So(err.Error(), ShouldEqual, "dependency author should hold hashes")
+	})
+
+	Convey("only json entry types should declare field dependencies", t, func() {
+		So(d.checkDependencies(), ShouldBeNil)
+		raw := &EntryDef{Name: "raw", DataFormat: DataFormatRawJS, Dependencies: []string{"author"}}
+		So(raw.checkDependencies().Error(), ShouldEqual, "entry type raw must have json data format to declare dependency author")
+		raw.Dependencies = []string{DependencyBase}
+		So(raw.checkDependencies(), ShouldBeNil)
+	})
+}
+
+func TestValidationDependencies(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	z := h.Zomes["myZome"]
+	def := z.Entries["primes"]
+	def.Dependencies = []string{"ref"}
+	z.Entries["primes"] = def
+
+	dataHash, err := h.Commit("myData", "2")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should resolve dependencies from the chain and cache them", t, func() {
+		e := GobEntry{C: fmt.Sprintf(`{"prime":7,"ref":"%s"}`, dataHash.String())}
+		deps, err := h.resolveDependencies(&def, &e, &ValidationProps{})
+		So(err, ShouldBeNil)
+		So(deps[dataHash.String()], ShouldEqual, "2")
+		_, ok := h.deps.cached(dataHash.String())
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("entries with resolvable dependencies should validate", t, func() {
+		_, err := h.Commit("primes", fmt.Sprintf(`{"prime":7,"ref":"%s"}`, dataHash.String()))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("entries with unresolvable dependencies should fail validation", t, func() {
+		var missing Hash
+		missing.Sum(h.hashSpec, []byte("nothing commits this"))
+		_, err := h.Commit("primes", fmt.Sprintf(`{"prime":7,"ref":"%s"}`, missing.String()))
+		So(err.Error(), ShouldStartWith, "unable to resolve validation dependency "+missing.String())
+	})
+
+	Convey("depending on an entry being validated should fail as a cycle", t, func() {
+		var pending Hash
+		pending.Sum(h.hashSpec, []byte("pending"))
+		_, err := h.resolveDependency(pending.String(), []string{pending.String()})
+		So(err, ShouldEqual, ErrDependencyCycle)
+	})
+}
@@ -11,9 +11,11 @@ import (
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/tidwall/buntdb"
-	"math/rand"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,12 +28,18 @@ var ErrDHTErrNoGossipersAvailable error = errors.New("no gossipers available")
 
 // DHT struct holds the data necessary to run the distributed hash table
 type DHT struct {
-	h         *Holochain // pointer to the holochain this DHT is part of
-	db        *buntdb.DB
-	puts      chan *Message
-	gossiping bool
-	glog      Logger // the gossip logger
-	dlog      Logger // the dht logger
+	h            *Holochain // pointer to the holochain this DHT is part of
+	db           *buntdb.DB
+	puts         chan *Message
+	gossiping    bool
+	republishing sync.Mutex // held while re-publishing so runs don't overlap
+	outboxFull   int32      // set atomically when publications may be waiting in the outbox
+	inflight     int32      // puts queued or being handled, changed atomically
+	holdLimits   holdLimiter
+	backlog      int        // number of gossiped puts waiting in the backlog
+	backlogLock  sync.Mutex // held while moving puts to or from the backlog
+	glog         Logger     // the gossip logger
+	dlog         Logger     // the dht logger
 }
 
 // Meta holds data that can be associated with a hash
@@ -54,8 +62,20 @@ const (
 	REJECTED
 	DELETED
 	UPDATED
+	REDACTED
+	QUARANTINED
 )
 
+// StatusNames are the names of the DHT statuses, as shown in DHT dumps and used in test files
+var StatusNames = map[int]string{
+	LIVE:        "live",
+	REJECTED:    "rejected",
+	DELETED:     "deleted",
+	UPDATED:     "updated",
+	REDACTED:    "redacted",
+	QUARANTINED: "quarantined",
+}
+
 // PutReq holds the data of a put request
 type PutReq struct {
 	H Hash
@@ -77,8 +97,9 @@ type MetaReq struct {
 
 // MetaQuery holds a getMeta query
 type MetaQuery struct {
-	H Hash
-	T string
+	H     Hash
+	T     string
+	Count bool // only count the values, rather than returning them
 	// order
 	// filter, etc
 }
@@ -92,6 +113,7 @@ type MetaEntry struct {
 // MetaQueryResp holds response to getMeta query
 type MetaQueryResp struct {
 	Entries []MetaEntry
+	Count   int
 }
 
 // Put holds a put or putmeta for gossiping
@@ -101,13 +123,18 @@ type Put struct {
 
 // Gossip holds a gossip message
 type Gossip struct {
-	Puts []Put
+	Puts  []Put
+	Peers *PeerExchange // a sample of the gossiper's known good peers
+	More  bool          // true if the gossiper has more puts than it sent
 }
 
 // GossipReq holds a gossip request
 type GossipReq struct {
 	MyIdx   int
 	YourIdx int
+	Peers   *PeerExchange
+	Max     int      // most puts to send, all if 0
+	Topics  []string // the topics whose puts to send, all if empty
 }
 
 // Gossiper holds data about a gossiper
@@ -122,20 +149,30 @@ func NewDHT(h *Holochain) *DHT {
 	dht := DHT{
 		h: h,
 	}
-	db, err := buntdb.Open(h.path + "/dht.db")
+	db, err := buntdb.Open(filepath.Join(h.path, "dht.db"))
 	if err != nil {
 		panic(err)
 	}
 	db.CreateIndex("meta", "meta:*", buntdb.IndexString)
 	db.CreateIndex("idx", "idx:*", buntdb.IndexInt)
 	db.CreateIndex("peer", "peer:*", buntdb.IndexString)
+	db.CreateIndex("type", "type:*", buntdb.IndexString)
 
 	dht.db = db
-	dht.puts = make(chan *Message, 10)
+	l := h.config.Quotas.PutQueueLength
+	if l <= 0 {
+		l = DefaultPutQueueLength
+	}
+	dht.puts = make(chan *Message, l)
+	dht.outboxFull = 1
 
 	dht.glog = h.config.Loggers.Gossip
 	dht.dlog = h.config.Loggers.DHT
 
+	if err = dht.loadBacklog(); err != nil {
+		panic(err)
+	}
+
 	return &dht
 }
 
@@ -212,6 +249,37 @@ func incIdx(tx *buntdb.Tx, m *Message) (err error) {
 	return
 }
 
+// incSize adds to the recorded size of the DHT store, failing if that would exceed the quota
+func (dht *DHT) incSize(tx *buntdb.Tx, n int) (err error) {
+	var size int
+	size, err = getIntVal("_size", tx)
+	if err != nil {
+		return
+	}
+	size += n
+	max := dht.h.Config().Quotas.MaxDHTBytes
+	if max > 0 && n > 0 && int64(size) > max {
+		dht.h.quotaExceeded("dht", "DHT store limit of %d bytes reached", max)
+		return ErrDHTQuotaExceeded
+	}
+	_, _, err = tx.Set("_size", fmt.Sprintf("%d", size), nil)
+	if err == nil {
+		dht.h.metrics.Set("dht.bytes", int64(size))
+	}
+	return
+}
+
+// resize adds to the recorded size of the DHT store the difference a value about to be
+// stored at a key makes, so that putting a key again doesn't count it twice
+func (dht *DHT) resize(tx *buntdb.Tx, key string, n int) (err error) {
+	if old, e := tx.Get(key); e == nil {
+		n -= len(old)
+	} else if e != buntdb.ErrNotFound {
+		return e
+	}
+	return dht.incSize(tx, n)
+}
+
 // getIntVal returns an integer value at a given key, and assumes the value 0 if the key doesn't exist
 func getIntVal(key string, tx *buntdb.Tx) (idx int, err error) {
 	var val string
@@ -245,6 +313,7 @@ func (dht *DHT) GetIdx() (idx int, err error) {
 // GetPuts returns a list of puts after the given index
 func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
 	puts = make([]Put, 0)
+	now := time.Now()
 	err = dht.db.View(func(tx *buntdb.Tx) error {
 		err = tx.AscendGreaterOrEqual("idx", string(since), func(key, value string) bool {
 			x := strings.Split(key, ":")
@@ -257,6 +326,10 @@ func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
 						return false
 					}
 				}
+				if r, ok := p.M.Body.(PutReq); ok && isExpired(tx, r.H.String(), now) {
+					// keep the place of expired puts so that indexes still line up
+					p = Put{}
+				}
 				puts = append(puts, p)
 			}
 			return true
@@ -280,9 +353,9 @@ func (dht *DHT) GetGossiper(id peer.ID) (idx int, err error) {
 	return
 }
 
-// FindGossiper picks a random DHT node to gossip with
-func (dht *DHT) FindGossiper() (g *Gossiper, err error) {
-	glist := make([]Gossiper, 0)
+// Gossipers returns the list of known gossipers
+func (dht *DHT) Gossipers() (glist []Gossiper, err error) {
+	glist = make([]Gossiper, 0)
 
 	err = dht.db.View(func(tx *buntdb.Tx) error {
 		err = tx.Ascend("peer", func(key, value string) bool {
@@ -298,20 +371,68 @@ func (dht *DHT) FindGossiper() (g *Gossiper, err error) {
 		})
 		return nil
 	})
+	return
+}
+
+// FindGossiper picks a random DHT node to gossip with
+func (dht *DHT) FindGossiper() (g *Gossiper, err error) {
+	var glist []Gossiper
+	glist, err = dht.Gossipers()
+	if err != nil {
+		return
+	}
 
 	if len(glist) == 0 {
 		err = ErrDHTErrNoGossipersAvailable
 	} else {
-		g = &glist[rand.Intn(len(glist))]
+		g, err = dht.pickGossiper(glist)
 	}
 	return
 }
 
+// EntryInfo summarizes an entry held in the DHT store
+type EntryInfo struct {
+	Hash   string
+	Type   string
+	Source string
+	Status int
+}
+
+// ListEntries returns a summary of up to limit entries held in the DHT store, or all of
+// them if limit is 0
+func (dht *DHT) ListEntries(limit int) (entries []EntryInfo, err error) {
+	entries = make([]EntryInfo, 0)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			info := EntryInfo{Hash: k}
+			info.Type, _ = tx.Get("type:" + k)
+			info.Source, _ = tx.Get("src:" + k)
+			var status string
+			status, e = tx.Get("status:" + k)
+			if e == nil {
+				info.Status, e = strconv.Atoi(status)
+			}
+			if e != nil {
+				return false
+			}
+			entries = append(entries, info)
+			return limit == 0 || len(entries) < limit
+		})
+		return e
+	})
+	return
+}
+
 // UpdateGossiper updates a gossiper
 func (dht *DHT) UpdateGossiper(id peer.ID, count int) (err error) {
 	dht.glog.Logf("updaing %v with %d", id, count)
+	var joined bool
 	err = dht.db.Update(func(tx *buntdb.Tx) error {
 		key := "peer:" + peer.IDB58Encode(id)
+		_, e := tx.Get(key)
+		joined = e == buntdb.ErrNotFound
 		idx, e := getIntVal(key, tx)
 		if e != nil {
 			return e
@@ -323,6 +444,73 @@ func (dht *DHT) UpdateGossiper(id peer.ID, count int) (err error) {
 		}
 		return nil
 	})
+	if err == nil && joined {
+		dht.h.emit(Event{Type: EventPeerJoined, Peer: id})
+	}
+	return
+}
+
+// MarkRetracted records that this node's entries with the given hashes have been rolled back
+// off its source chain: they are no longer re-published, any local copies are marked deleted,
+// and they are listed by Retractions so that retraction notices can be sent for them
+func (dht *DHT) MarkRetracted(hashes []Hash) (err error) {
+	retracted := make(map[string]bool)
+	for _, hash := range hashes {
+		retracted[hash.String()] = true
+	}
+	self := peer.IDB58Encode(dht.h.id)
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		var pubs []string
+		var e error
+		tx.AscendKeys("pub:*", func(k, v string) bool {
+			var p Publication
+			if e = ByteDecoder([]byte(v), &p); e != nil {
+				return false
+			}
+			if (p.Type == PUTMETA_REQUEST && retracted[p.Meta.M.String()]) || (p.Type != PUTMETA_REQUEST && retracted[p.Put.H.String()]) {
+				pubs = append(pubs, k)
+			}
+			return true
+		})
+		if e != nil {
+			return e
+		}
+		for _, k := range pubs {
+			if _, err := tx.Delete(k); err != nil {
+				return err
+			}
+		}
+		now := time.Now().Format(time.RFC3339)
+		for k := range retracted {
+			if src, err := tx.Get("src:" + k); err == nil && src == self {
+				if _, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", DELETED), nil); err != nil {
+					return err
+				}
+			}
+			if _, _, err := tx.Set("retract:"+k, now, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Retractions returns the hashes of entries marked as retracted by MarkRetracted
+func (dht *DHT) Retractions() (hashes []Hash, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("retract:*", func(k, v string) bool {
+			var h Hash
+			h, e = NewHash(strings.TrimPrefix(k, "retract:"))
+			if e != nil {
+				return false
+			}
+			hashes = append(hashes, h)
+			return true
+		})
+		return e
+	})
 	return
 }
 
@@ -332,7 +520,11 @@ func (dht *DHT) put(m *Message, entryType string, key Hash, src peer.ID, value [
 	k := key.String()
 	dht.dlog.Logf("put %v=>%s", key, string(value))
 	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		err := incIdx(tx, m)
+		err := dht.resize(tx, "entry:"+k, len(value))
+		if err != nil {
+			return err
+		}
+		err = incIdx(tx, m)
 		if err != nil {
 			return err
 		}
@@ -352,7 +544,60 @@ func (dht *DHT) put(m *Message, entryType string, key Hash, src peer.ID, value [
 		if err != nil {
 			return err
 		}
-		return err
+		if _, e := tx.Get("recv:" + k); e == buntdb.ErrNotFound {
+			now := time.Now()
+			_, _, err = tx.Set("recv:"+k, now.Format(time.RFC3339), nil)
+			if err != nil {
+				return err
+			}
+			if err = recordReceived(tx, entryType, now); err != nil {
+				return err
+			}
+		}
+		err = setHolder(tx, key, dht.h.id)
+		if err != nil {
+			return err
+		}
+		if src != dht.h.id {
+			err = setHolder(tx, key, src)
+			if err != nil {
+				return err
+			}
+		}
+		return dht.indexAgent(tx, entryType, key, src, value)
+	})
+	return
+}
+
+// setHolder records that a peer is known to hold the data for a hash
+func setHolder(tx *buntdb.Tx, key Hash, id peer.ID) (err error) {
+	_, _, err = tx.Set("holder:"+key.String()+":"+peer.IDB58Encode(id), fmt.Sprintf("%d", time.Now().Unix()), nil)
+	return
+}
+
+// AddHolder records that a peer is known to hold the data for a hash
+func (dht *DHT) AddHolder(key Hash, id peer.ID) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		return setHolder(tx, key, id)
+	})
+	return
+}
+
+// GetHolders returns the peers known to hold the data for a hash
+func (dht *DHT) GetHolders(key Hash) (holders []peer.ID, err error) {
+	prefix := "holder:" + key.String() + ":"
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys(prefix+"*", func(k, v string) bool {
+			var id peer.ID
+			id, e = peer.IDB58Decode(k[len(prefix):])
+			if e != nil {
+				return false
+			}
+			holders = append(holders, id)
+			return true
+		})
+		return e
 	})
 	return
 }
@@ -395,6 +640,9 @@ func (dht *DHT) get(key Hash) (data []byte, entryType string, status int, err er
 			}
 			return err
 		}
+		if isExpired(tx, k, time.Now()) {
+			return ErrHashNotFound
+		}
 		entryType, err = tx.Get("type:" + k)
 		if err != nil {
 			return err
@@ -426,8 +674,12 @@ func (dht *DHT) putMeta(m *Message, key Hash, metaKey Hash, metaTag string, entr
 		if err != nil {
 			return err
 		}
-
 		x := "meta:" + k + ":" + mk + ":" + metaTag
+		err = dht.resize(tx, x, len(b))
+		if err != nil {
+			return err
+		}
+
 		_, _, err = tx.Set(x, string(b), nil)
 		if err != nil {
 			return err
@@ -485,12 +737,10 @@ func (dht *DHT) getMeta(key Hash, metaTag string) (results []MetaEntry, err erro
 // SendPut initiates publishing a particular Hash to the DHT.
 // This command only sends the hash, because the expectation is that DHT nodes will start to
 // communicate back to Source node (the node that makes this call) to get the data for validation
+// The put is recorded so that it can be re-published if it isn't acknowledged.
 func (dht *DHT) SendPut(key Hash) (err error) {
-	n, err := dht.FindNodeForHash(key)
-	if err != nil {
-		return
-	}
-	_, err = dht.send(n.HashAddr, PUT_REQUEST, PutReq{H: key})
+	p := Publication{Type: PUT_REQUEST, Put: PutReq{H: key}}
+	err = dht.publish(&p)
 	return
 }
 
@@ -506,13 +756,13 @@ func (dht *DHT) SendGet(key Hash) (response interface{}, err error) {
 
 // SendPutMeta initiates associating Meta data with particular Hash on the DHT.
 // This command assumes that the data has been committed to your local chain, and the hash of that
-// data is what get's sent in the MetaReq
+// data is what get's sent in the MetaReq.  As with SendPut the request is recorded for re-publishing.
 func (dht *DHT) SendPutMeta(req MetaReq) (err error) {
-	n, err := dht.FindNodeForHash(req.O)
-	if err != nil {
+	if err = dht.checkMetaReq(req, ""); err != nil {
 		return
 	}
-	_, err = dht.send(n.HashAddr, PUTMETA_REQUEST, req)
+	p := Publication{Type: PUTMETA_REQUEST, Meta: req}
+	err = dht.publish(&p)
 	return
 }
 
@@ -549,16 +799,26 @@ func (dht *DHT) FindNodeForHash(key Hash) (n *Node, err error) {
 
 // HandlePutReqs waits on a chanel for messages to handle
 func (dht *DHT) HandlePutReqs() (err error) {
+	if err = dht.refillPuts(); err != nil {
+		dht.dlog.Logf("HandlePutReq: error refilling from backlog: %v", err)
+	}
 	for {
 		dht.dlog.Log("HandlePutReq: waiting for put request")
 		m, ok := <-dht.puts
 		if !ok {
 			break
 		}
+		dht.h.metrics.Set("dht.putqueue", int64(len(dht.puts)))
 		err = dht.handlePutReq(m)
 		if err != nil {
 			dht.dlog.Logf("HandlePutReq: got err: %v", err)
 		}
+		atomic.AddInt32(&dht.inflight, -1)
+		if len(dht.puts) == 0 {
+			if err = dht.refillPuts(); err != nil {
+				dht.dlog.Logf("HandlePutReq: error refilling from backlog: %v", err)
+			}
+		}
 	}
 	return nil
 }
@@ -568,44 +828,143 @@ func (dht *DHT) handlePutReq(m *Message) (err error) {
 	switch t := m.Body.(type) {
 	case PutReq:
 		dht.dlog.Logf("handling put: %v", m)
+		// a put handed off by another node is fetched from its source, but it was the node
+		// handing it off that vouched for it
+		blamed := from
+		if m.handedOffBy != "" {
+			blamed = m.handedOffBy
+		}
+		if dht.isRedacted(t.H) {
+			dht.dlog.Logf("ignoring put of redacted entry %v", t.H)
+			return
+		}
 		var r interface{}
 		r, err = dht.h.Send(SourceProtocol, from, SRC_VALIDATE, t.H, SrcReceiver)
 		if err != nil {
 			return
 		}
 		resp := r.(*ValidateResponse)
+		if !dht.h.holds(resp.Type) {
+			err = fmt.Errorf("%v: %s entry %v", ErrTopicNotHeld, resp.Type, t.H)
+			return
+		}
+		// the author of a relayed entry is the relay's client, whose signed entry is sent
+		// instead of a header
+		author := from
+		source := peer.IDB58Encode(from)
+		if resp.Relay != nil {
+			if source, err = dht.checkRelayed(resp.Relay, t.H, resp.Type); err == nil {
+				if author, err = peer.IDB58Decode(source); err == nil {
+					err = dht.h.checkHeaderTime(resp.Relay.Time)
+				}
+			}
+		} else {
+			err = dht.checkPutHeader(from, t.H, resp)
+		}
+		if err != nil {
+			dht.recordPeerEvent(blamed, PeerInvalidPut)
+			return
+		}
+		if err = dht.checkJoin(author, resp.Join); err != nil {
+			dht.recordPeerEvent(blamed, PeerInvalidPut)
+			return
+		}
+		var claims map[string]interface{}
+		if claims, err = dht.checkIdentity(author, resp.Identity); err != nil {
+			dht.recordPeerEvent(blamed, PeerInvalidPut)
+			return
+		}
 		p := ValidationProps{
-			Sources: []string{peer.IDB58Encode(from)},
+			Sources: []string{source},
 			Hash:    t.H.String(),
+			Claims:  claims,
 		}
 		err = dht.h.ValidateEntry(resp.Type, resp.Entry, &p)
 		if err != nil {
 			//@todo store as INVALID
+			dht.recordPeerEvent(blamed, PeerInvalidPut)
 		} else {
 			entry := resp.Entry
-			b, err := entry.Marshal()
+			var expires time.Time
+			expires, err = dht.entryExpiry(resp.Type, resp.Header)
+			if err != nil {
+				return
+			}
+			if !expires.IsZero() && !expires.After(time.Now()) {
+				err = ErrEntryExpired
+				return
+			}
+			// the entry is recorded as from its validated source, which for relayed
+			// puts is the relay's client rather than the relay
+			var src peer.ID
+			if src, err = peer.IDB58Decode(source); err != nil {
+				return
+			}
+			var kind string
+			var op CRDTOp
+			if kind, op, err = dht.crdtUpdateOf(resp.Type, entry); err != nil {
+				dht.recordPeerEvent(blamed, PeerInvalidPut)
+				return
+			}
+			var b []byte
+			b, err = entry.Marshal()
+			if err == nil {
+				err = dht.put(m, resp.Type, t.H, src, b, LIVE)
+			}
+			if err == nil && !expires.IsZero() {
+				err = dht.setExpiry(t.H, expires)
+			}
+			if err == nil && kind != "" {
+				err = dht.applyCRDT(t.H, resp.Type, kind, &op, src)
+			}
 			if err == nil {
-				err = dht.put(m, resp.Type, t.H, from, b, LIVE)
+				dht.h.emit(Event{Type: EventPutReceived, Hash: t.H, EntryType: resp.Type, Peer: from})
 			}
 		}
 	case MetaReq:
 		dht.dlog.Logf("handling putmeta: %v", m)
+		// links can't be judged, or held, until their base is
+		if err = dht.exists(t.O); err != nil {
+			return
+		}
 		var r interface{}
 		r, err = dht.h.Send(SourceProtocol, from, SRC_VALIDATE, t.M, SrcReceiver)
 		if err != nil {
 			return
 		}
 		resp := r.(*ValidateResponse)
+		if err = dht.checkJoin(from, resp.Join); err != nil {
+			dht.recordPeerEvent(from, PeerInvalidPut)
+			return
+		}
+		var claims map[string]interface{}
+		if claims, err = dht.checkIdentity(from, resp.Identity); err != nil {
+			dht.recordPeerEvent(from, PeerInvalidPut)
+			return
+		}
+		if err = dht.checkPutHeader(from, t.M, resp); err != nil {
+			dht.recordPeerEvent(from, PeerInvalidPut)
+			return
+		}
 		p := ValidationProps{
 			MetaTag:  t.T,
 			Sources:  []string{peer.IDB58Encode(from)},
 			MetaHash: t.M.String(),
+			MetaBase: t.O.String(),
+			Claims:   claims,
 		}
 		err = dht.h.ValidateEntry(resp.Type, resp.Entry, &p)
 		if err != nil {
 			//@todo store as INVALID
+			dht.recordPeerEvent(from, PeerInvalidPut)
 		} else {
 			err = dht.putMeta(m, t.O, t.M, t.T, resp.Entry)
+			if err == nil && t.T == RedactionMetaTag && resp.Type == RedactionEntryType {
+				err = dht.redact(t.O, t.M)
+			}
+			if err == nil {
+				dht.h.emit(Event{Type: EventPutReceived, Hash: t.M, EntryType: resp.Type, Peer: from})
+			}
 		}
 	default:
 		err = errors.New("unexpected body type in handlePutReq")
@@ -613,16 +972,38 @@ func (dht *DHT) handlePutReq(m *Message) (err error) {
 	return
 }
 
+// queuePut adds a put request to the put queue for handling, failing if the queue is full
+func (dht *DHT) queuePut(m *Message) (response interface{}, err error) {
+	if dht.h.ShuttingDown() {
+		err = ErrShuttingDown
+		return
+	}
+	if dht.tryQueuePut(m) {
+		response = "queued"
+	} else {
+		dht.h.quotaExceeded("putqueue", "put queue full (%d), dropping put from %v", cap(dht.puts), m.From)
+		err = ErrDHTPutQueueFull
+	}
+	return
+}
+
 // DHTReceiver handles messages on the dht protocol
 func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 	dht := h.dht
+	defer func() {
+		switch err {
+		case ErrDHTExpectedGetReqInBody, ErrDHTExpectedPutReqInBody, ErrDHTExpectedMetaReqInBody,
+			ErrDHTExpectedMetaQueryInBody, ErrDHTExpectedGossipReqInBody, ErrDHTExpectedHeartbeatInBody,
+			ErrDHTExpectedHoldReqInBody, ErrDHTExpectedRangeTransferInBody, ErrDHTExpectedTimeAttestReqInBody:
+			dht.recordPeerEvent(m.From, PeerViolation)
+		}
+	}()
 	switch m.Type {
 	case PUT_REQUEST:
 		dht.dlog.Logf("DHTRecevier got PUT_REQUEST: %v", m)
 		switch m.Body.(type) {
 		case PutReq:
-			h.dht.puts <- m
-			response = "queued"
+			response, err = h.dht.queuePut(m)
 		default:
 			err = ErrDHTExpectedPutReqInBody
 		}
@@ -632,7 +1013,14 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		switch t := m.Body.(type) {
 		case GetReq:
 			var b []byte
-			b, _, _, err = h.dht.get(t.H)
+			var status int
+			b, _, status, err = h.dht.get(t.H)
+			if err == nil && status == REDACTED {
+				err = ErrEntryRedacted
+			}
+			if err == nil && status == QUARANTINED {
+				err = ErrEntryQuarantined
+			}
 			if err == nil {
 				var e GobEntry
 				err = e.Unmarshal(b)
@@ -651,8 +1039,7 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		case MetaReq:
 			err = h.dht.exists(t.O)
 			if err == nil {
-				h.dht.puts <- m
-				response = "queued"
+				response, err = h.dht.queuePut(m)
 			} else {
 				dht.dlog.Logf("DHTRecevier key %v doesn't exist, ignoring", t.O)
 			}
@@ -665,7 +1052,11 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		switch t := m.Body.(type) {
 		case MetaQuery:
 			var r MetaQueryResp
-			r.Entries, err = h.dht.getMeta(t.H, t.T)
+			if t.Count {
+				r.Count, err = h.dht.countMeta(t.H, t.T)
+			} else {
+				r.Entries, err = h.dht.getMeta(t.H, t.T)
+			}
 			response = r
 		default:
 			err = ErrDHTExpectedMetaQueryInBody
@@ -677,9 +1068,24 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 			dht.glog.Logf("%v wants my puts since %d and is at %d", m.From, t.YourIdx, t.MyIdx)
 
 			// give the gossiper what they want
-			var puts []Put
-			puts, err = h.dht.GetPuts(t.YourIdx)
-			g := Gossip{Puts: puts}
+			var g Gossip
+			if t.Max > 0 {
+				g.Puts, g.More, err = h.dht.GetPutsBatch(t.YourIdx, t.Max)
+			} else {
+				g.Puts, err = h.dht.GetPuts(t.YourIdx)
+			}
+			g.Puts = h.dht.filterTopics(g.Puts, t.Topics)
+			if t.Peers != nil {
+				if _, e := h.dht.putPeerExchange(m.From, t.Peers); e != nil {
+					dht.glog.Logf("bad peer exchange from %v: %v", m.From, e)
+					h.dht.recordPeerEvent(m.From, PeerViolation)
+				}
+			}
+			if x, e := h.NewPeerExchange(); e == nil {
+				g.Peers = x
+			} else {
+				dht.glog.Logf("error making peer exchange: %v", e)
+			}
 			response = g
 
 			// check to see what we know they said, and if our record is less
@@ -687,17 +1093,88 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 			idx, e := h.dht.GetGossiper(m.From)
 			if e == nil && idx < t.MyIdx {
 				dht.glog.Logf("we only have %d from %v so gossiping back", idx, m.From)
-				go func() {
+				h.goWorker(func() {
 					e := h.dht.gossipWith(m.From, idx)
 					if e != nil {
 						dht.glog.Logf("gossip back returned error: %v", e)
 					}
-				}()
+				})
 			}
 
 		default:
 			err = ErrDHTExpectedGossipReqInBody
 		}
+	case SNAPSHOT_REQUEST:
+		dht.glog.Logf("DHTRecevier got SNAPSHOT_REQUEST: %v", m)
+		var s *DHTSnapshot
+		s, err = h.dht.Snapshot()
+		if err == nil {
+			response = *s
+		}
+	case HEARTBEAT:
+		dht.glog.Logf("DHTRecevier got HEARTBEAT: %v", m)
+		switch t := m.Body.(type) {
+		case Heartbeat:
+			var fresh bool
+			if fresh, err = h.dht.putHeartbeat(&t); err != nil {
+				break
+			}
+			// only a heartbeat straight from its agent says how far its clock is from ours
+			if t.Id == m.From && t.Hops == 0 {
+				if e := h.dht.observeClock(t.Id, t.Time); e != nil {
+					dht.glog.Logf("error recording clock of %v: %v", t.Id, e)
+				}
+			}
+			if fresh {
+				from := m.From
+				if e := h.goWorker(func() { h.dht.relayHeartbeat(t, from) }); e != nil {
+					dht.glog.Logf("not relaying heartbeat of %v: %v", t.Id, e)
+				}
+			}
+			response = "ok"
+		default:
+			err = ErrDHTExpectedHeartbeatInBody
+		}
+	case HOLD_REQUEST:
+		dht.dlog.Logf("DHTRecevier got HOLD_REQUEST: %v", m)
+		switch t := m.Body.(type) {
+		case HoldReq:
+			response, err = h.dht.handleHoldReq(m.From, &t)
+		default:
+			err = ErrDHTExpectedHoldReqInBody
+		}
+	case RANGE_TRANSFER:
+		dht.dlog.Logf("DHTRecevier got RANGE_TRANSFER from %v", m.From)
+		switch t := m.Body.(type) {
+		case RangeTransfer:
+			response, err = h.dht.handleRangeTransfer(m.From, &t)
+		default:
+			err = ErrDHTExpectedRangeTransferInBody
+		}
+	case TIME_ATTEST_REQUEST:
+		dht.glog.Logf("DHTRecevier got TIME_ATTEST_REQUEST from %v", m.From)
+		switch t := m.Body.(type) {
+		case TimeAttestReq:
+			response, err = h.dht.handleTimeAttestReq(m.From, &t)
+		default:
+			err = ErrDHTExpectedTimeAttestReqInBody
+		}
+	case COUNTERSIGN_REQUEST:
+		dht.dlog.Logf("DHTRecevier got COUNTERSIGN_REQUEST from %v", m.From)
+		switch t := m.Body.(type) {
+		case CountersignReq:
+			response, err = h.dht.handleCountersignReq(m.From, &t)
+		default:
+			err = ErrDHTExpectedCountersignReqInBody
+		}
+	case COUNTERSIGN_COMPLETE:
+		dht.dlog.Logf("DHTRecevier got COUNTERSIGN_COMPLETE from %v", m.From)
+		switch t := m.Body.(type) {
+		case CountersignComplete:
+			response, err = h.dht.handleCountersignComplete(m.From, &t)
+		default:
+			err = ErrDHTExpectedCountersignCompleteInBody
+		}
 
 	default:
 		err = fmt.Errorf("message type %d not in holochain-dht protocol", int(m.Type))
@@ -721,30 +1198,73 @@ func (dht *DHT) gossipWith(id peer.ID, after int) (err error) {
 		return
 	}
 
+	req := GossipReq{MyIdx: myIdx, YourIdx: after + 1, Max: MaxGossipPuts, Topics: dht.h.Config().Topics}
+	if req.Peers, err = dht.h.NewPeerExchange(); err != nil {
+		return
+	}
 	var r interface{}
-	r, err = dht.send(id, GOSSIP_REQUEST, GossipReq{MyIdx: myIdx, YourIdx: after + 1})
+	r, err = dht.send(id, GOSSIP_REQUEST, req)
 	if err != nil {
+		dht.recordPeerEvent(id, PeerTimeout)
 		return
 	}
 
-	gossip := r.(Gossip)
-	puts := gossip.Puts
-	dht.glog.Logf("received puts: %v", puts)
+	gossip, ok := r.(Gossip)
+	if !ok {
+		dht.recordPeerEvent(id, PeerViolation)
+		err = fmt.Errorf("expected gossip response from %v, got %T", id, r)
+		return
+	}
+	dht.recordPeerEvent(id, PeerSuccess)
+	if err = dht.recordGossip(); err != nil {
+		return
+	}
+	if gossip.Peers != nil {
+		if _, e := dht.putPeerExchange(id, gossip.Peers); e != nil {
+			dht.glog.Logf("bad peer exchange from %v: %v", id, e)
+			dht.recordPeerEvent(id, PeerViolation)
+		}
+	}
 
 	// gossiper has more stuff that we new about before so update the gossipers status
-	// and also run their puts
-	if len(puts) > 0 {
-		err = dht.UpdateGossiper(id, len(puts))
-		for _, p := range puts {
-			dht.glog.Log("running puts")
-			DHTReceiver(dht.h, &p.M)
+	// and also run their puts, asking for more while they have it and the backlog has room
+	var received int
+	for {
+		puts := gossip.Puts
+		dht.glog.Logf("received puts: %v", puts)
+		taken, paused := dht.receiveGossipedPuts(id, puts)
+		if taken > 0 {
+			if err = dht.UpdateGossiper(id, taken); err != nil {
+				return
+			}
+			received += taken
+		}
+		if !gossip.More || paused || taken == 0 {
+			break
+		}
+		req = GossipReq{MyIdx: myIdx, YourIdx: after + 1 + received, Max: MaxGossipPuts, Topics: dht.h.Config().Topics}
+		if r, err = dht.send(id, GOSSIP_REQUEST, req); err != nil {
+			dht.recordPeerEvent(id, PeerTimeout)
+			return
+		}
+		if gossip, ok = r.(Gossip); !ok {
+			dht.recordPeerEvent(id, PeerViolation)
+			err = fmt.Errorf("expected gossip response from %v, got %T", id, r)
+			return
 		}
 	}
+	dht.h.emit(Event{Type: EventGossip, Peer: id, Count: received})
+
+	// we've reached a peer, so we may be back online with an outbox to publish
+	dht.flushOutbox()
 	return
 }
 
 // gossip picks a random node in my neighborhood and sends gossips with it
 func (dht *DHT) gossip() (err error) {
+	if dht.h.Offline() {
+		return
+	}
 
 	var g *Gossiper
 	g, err = dht.FindGossiper()
@@ -764,6 +1284,6 @@ func (dht *DHT) Gossip(interval time.Duration) {
 		if err != nil {
 			dht.glog.Logf("error: %v", err)
 		}
-		time.Sleep(interval)
+		time.Sleep(dht.gossipInterval(interval))
 	}
 }
@@ -86,6 +86,24 @@ func TestPutGet(t *testing.T) {
 		So(err, ShouldEqual, ErrHashNotFound)
 	})
 
+	Convey("It should list entries", t, func() {
+		entries, err := dht.ListEntries(0)
+		So(err, ShouldBeNil)
+		var found bool
+		for _, e := range entries {
+			if e.Hash == "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2" {
+				found = true
+				So(e.Type, ShouldEqual, "someType")
+				So(e.Source, ShouldEqual, peer.IDB58Encode(id))
+				So(e.Status, ShouldEqual, LIVE)
+			}
+		}
+		So(found, ShouldBeTrue)
+		entries, err = dht.ListEntries(1)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+	})
+
 }
 
 func TestPutGetMeta(t *testing.T) {
@@ -325,6 +343,13 @@ func TestGossiper(t *testing.T) {
 		So(g.Id, ShouldEqual, h.node.HashAddr)
 	})
 
+	Convey("Gossipers should list the gossipers", t, func() {
+		glist, err := dht.Gossipers()
+		So(err, ShouldBeNil)
+		So(len(glist), ShouldEqual, 1)
+		So(glist[0].Id, ShouldEqual, h.node.HashAddr)
+	})
+
 }
 
 func TestGossipData(t *testing.T) {
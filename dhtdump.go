@@ -0,0 +1,118 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// dhtdump implements listing what the local DHT store holds, as hc dump does for the chain,
+// so that operators and app developers can see which entries a node holds for the network,
+// who published them, what became of them and when they arrived.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownStatus error = errors.New("unknown DHT status")
+
+// DHTDumpEntry is an entry held in the DHT store
+type DHTDumpEntry struct {
+	Hash     string
+	Type     string
+	Source   string      `json:",omitempty"` // node id of the agent that published the entry
+	Status   string      // live, rejected, deleted, updated, redacted or quarantined
+	Received *time.Time  `json:",omitempty"` // when the entry first arrived, if known
+	Holders  int         // nodes known to hold the entry, including this one
+	Links    int         // links held on the entry
+	Size     int         // bytes of the entry as stored
+	Content  interface{} `json:",omitempty"` // the entry's content, unless it has been redacted
+}
+
+// DHTDumpFilter selects the entries of a dump of the DHT store
+type DHTDumpFilter struct {
+	Type   string // only entries of this type if set
+	Status string // only entries with this status if set
+}
+
+// StatusName returns the name of a DHT status
+func StatusName(status int) string {
+	if name, ok := StatusNames[status]; ok {
+		return name
+	}
+	return strconv.Itoa(status)
+}
+
+// Check confirms that the filter's status is one the DHT store uses
+func (f *DHTDumpFilter) Check() (err error) {
+	if f.Status == "" {
+		return
+	}
+	for _, name := range StatusNames {
+		if name == f.Status {
+			return
+		}
+	}
+	err = fmt.Errorf("%v: %s", ErrUnknownStatus, f.Status)
+	return
+}
+
+// Dump lists the entries held in the DHT store that the filter selects, in hash order
+func (dht *DHT) Dump(filter DHTDumpFilter) (entries []DHTDumpEntry, err error) {
+	if err = filter.Check(); err != nil {
+		return
+	}
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		links := make(map[string]int)
+		tx.Ascend("meta", func(key, value string) bool {
+			links[strings.SplitN(key, ":", 3)[1]]++
+			return true
+		})
+		var e error
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			d := DHTDumpEntry{Hash: k, Links: links[k], Size: len(value)}
+			if d.Type, e = tx.Get("type:" + k); e != nil {
+				return false
+			}
+			if filter.Type != "" && d.Type != filter.Type {
+				return true
+			}
+			var s string
+			if s, e = tx.Get("status:" + k); e != nil {
+				return false
+			}
+			var status int
+			if status, e = strconv.Atoi(s); e != nil {
+				return false
+			}
+			d.Status = StatusName(status)
+			if filter.Status != "" && d.Status != filter.Status {
+				return true
+			}
+			d.Source, _ = tx.Get("src:" + k)
+			if r, err := tx.Get("recv:" + k); err == nil {
+				if t, err := time.Parse(time.RFC3339, r); err == nil {
+					d.Received = &t
+				}
+			}
+			tx.AscendKeys("holder:"+k+":*", func(key, value string) bool {
+				d.Holders++
+				return true
+			})
+			if status != REDACTED {
+				var g GobEntry
+				if g.Unmarshal([]byte(value)) == nil {
+					d.Content = g.C
+				}
+			}
+			entries = append(entries, d)
+			return true
+		})
+		return e
+	})
+	return
+}
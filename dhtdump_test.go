@@ -0,0 +1,69 @@
+package holochain
+
+import (
+	"encoding/json"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDHTDump(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	other, _ := makePeer("other")
+
+	put := func(content string, status int) Hash {
+		e := GobEntry{C: content}
+		key, _ := e.Sum(h.hashSpec)
+		b, _ := e.Marshal()
+		dht.put(nil, "myData", key, other, b, status)
+		return key
+	}
+	live := put("2", LIVE)
+	rejected := put("1", REJECTED)
+	dht.putMeta(nil, live, rejected, "tag", &GobEntry{C: "linked"})
+
+	Convey("it should list the held entries", t, func() {
+		entries, err := dht.Dump(DHTDumpFilter{})
+		So(err, ShouldBeNil)
+		found := make(map[string]DHTDumpEntry)
+		for _, e := range entries {
+			found[e.Hash] = e
+		}
+		So(len(found), ShouldBeGreaterThan, 2)
+		e := found[live.String()]
+		So(e.Type, ShouldEqual, "myData")
+		So(e.Status, ShouldEqual, "live")
+		So(e.Source, ShouldEqual, peer.IDB58Encode(other))
+		So(e.Received, ShouldNotBeNil)
+		So(e.Holders, ShouldEqual, 2)
+		So(e.Links, ShouldEqual, 1)
+		So(e.Content, ShouldEqual, "2")
+		So(found[rejected.String()].Status, ShouldEqual, "rejected")
+	})
+
+	Convey("it should filter by type and status", t, func() {
+		entries, err := dht.Dump(DHTDumpFilter{Type: "myData"})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 2)
+		entries, err = dht.Dump(DHTDumpFilter{Type: "myData", Status: "rejected"})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Hash, ShouldEqual, rejected.String())
+		_, err = dht.Dump(DHTDumpFilter{Status: "lost"})
+		So(isError(err, ErrUnknownStatus), ShouldBeTrue)
+	})
+
+	Convey("it should name statuses", t, func() {
+		So(StatusName(QUARANTINED), ShouldEqual, "quarantined")
+		So(StatusName(99), ShouldEqual, "99")
+		So((&DHTDumpFilter{Status: "quarantined"}).Check(), ShouldBeNil)
+	})
+
+	Convey("it should leave out unknown receipt times when encoding entries", t, func() {
+		b, err := json.Marshal(DHTDumpEntry{Hash: live.String()})
+		So(err, ShouldBeNil)
+		So(string(b), ShouldNotContainSubstring, "Received")
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// dhtstats implements reporting on the data held in a node's DHT store and how widely it is
+// known to be replicated, so that operators can see whether entries are held by as many
+// nodes as the DNA's resilience calls for.
+
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultResilience is how many nodes should hold each entry if the DNA doesn't say
+const DefaultResilience = 3
+
+// DHTStats summarizes the data held in a DHT store
+type DHTStats struct {
+	Entries         int // live entries held
+	Bytes           int // size of the live entries held
+	ByType          map[string]int
+	Rejected        int // entries that failed validation, kept until garbage collected
+	Deleted         int
+	Redacted        int
+	Quarantined     int       // entries that failed re-validation, kept until reviewed
+	Oldest          time.Time // when the earliest received live entry arrived
+	Newest          time.Time
+	Peers           int // gossipers that have answered and aren't banned or unreachable
+	Resilience      int // how many nodes should hold each entry not in a topic with its own resilience
+	UnderReplicated int // live entries known to be held by fewer nodes than their resilience
+}
+
+// Coverage estimates the fraction of the held entries that are replicated as widely as the
+// resilience calls for, based on the holders this node knows of
+func (s *DHTStats) Coverage() float64 {
+	if s.Entries == 0 {
+		return 1
+	}
+	return float64(s.Entries-s.UnderReplicated) / float64(s.Entries)
+}
+
+// resilience returns how many nodes should hold each entry
+func (h *Holochain) resilience() int {
+	if h.Resilience > 0 {
+		return h.Resilience
+	}
+	return DefaultResilience
+}
+
+// Stats returns a summary of the data held in the DHT store
+func (dht *DHT) Stats() (stats DHTStats, err error) {
+	stats = DHTStats{ByType: make(map[string]int), Resilience: dht.h.resilience()}
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			var s string
+			if s, e = tx.Get("status:" + k); e != nil {
+				return false
+			}
+			var status int
+			if status, e = strconv.Atoi(s); e != nil {
+				return false
+			}
+			if status != LIVE {
+				switch status {
+				case REJECTED:
+					stats.Rejected++
+				case DELETED:
+					stats.Deleted++
+				case REDACTED:
+					stats.Redacted++
+				case QUARANTINED:
+					stats.Quarantined++
+				}
+				return true
+			}
+			stats.Entries++
+			stats.Bytes += len(value)
+			t, _ := tx.Get("type:" + k)
+			stats.ByType[t]++
+			if r, err := tx.Get("recv:" + k); err == nil {
+				if when, err := time.Parse(time.RFC3339, r); err == nil {
+					if stats.Oldest.IsZero() || when.Before(stats.Oldest) {
+						stats.Oldest = when
+					}
+					if when.After(stats.Newest) {
+						stats.Newest = when
+					}
+				}
+			}
+			holders := 0
+			tx.AscendKeys("holder:"+k+":*", func(key, value string) bool {
+				holders++
+				return true
+			})
+			if holders < dht.h.resilienceOf(t) {
+				stats.UnderReplicated++
+			}
+			return true
+		})
+		if e != nil {
+			return e
+		}
+		// gossipers are recorded as soon as they are heard of, so only count those known good
+		tx.AscendKeys("peer:*", func(key, value string) bool {
+			var id peer.ID
+			if id, e = peer.IDB58Decode(strings.TrimPrefix(key, "peer:")); e != nil {
+				return false
+			}
+			var r PeerRecord
+			if r, e = getPeerRecord(tx, id); e != nil {
+				return false
+			}
+			if r.Successes > 0 && !r.Banned() && !r.Unreachable {
+				stats.Peers++
+			}
+			return true
+		})
+		return e
+	})
+	return
+}
@@ -0,0 +1,63 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDHTStats(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	before, err := dht.Stats()
+	if err != nil {
+		panic(err)
+	}
+
+	other, _ := makePeer("other")
+	third, _ := makePeer("third")
+	wide, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	narrow, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	rejected, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+	dht.put(nil, "myData", wide, other, []byte("wide"), LIVE)
+	dht.AddHolder(wide, third)
+	dht.put(nil, "myData", narrow, other, []byte("narrow"), LIVE)
+	dht.put(nil, "myData", rejected, other, []byte("rejected"), REJECTED)
+
+	Convey("it should summarize the entries held", t, func() {
+		st, err := dht.Stats()
+		So(err, ShouldBeNil)
+		So(st.Entries, ShouldEqual, before.Entries+2)
+		So(st.Bytes, ShouldEqual, before.Bytes+len("wide")+len("narrow"))
+		So(st.ByType["myData"], ShouldEqual, 2)
+		So(st.Rejected, ShouldEqual, 1)
+		So(st.Newest.IsZero(), ShouldBeFalse)
+		So(st.Oldest.After(st.Newest), ShouldBeFalse)
+	})
+
+	Convey("it should estimate coverage against the resilience", t, func() {
+		st, _ := dht.Stats()
+		So(st.Resilience, ShouldEqual, DefaultResilience)
+		So(st.UnderReplicated, ShouldEqual, before.UnderReplicated+1)
+
+		h.Resilience = 2
+		st, _ = dht.Stats()
+		So(st.UnderReplicated, ShouldEqual, before.UnderReplicated)
+		h.Resilience = 0
+
+		st = DHTStats{Entries: 4, UnderReplicated: 1}
+		So(st.Coverage(), ShouldEqual, 0.75)
+	})
+
+	Convey("it should only count gossipers that have answered as peers", t, func() {
+		dht.UpdateGossiper(other, 0)
+		dht.UpdateGossiper(third, 0)
+		dht.RecordPeerEvent(other, PeerSuccess)
+		st, err := dht.Stats()
+		So(err, ShouldBeNil)
+		So(st.Peers, ShouldEqual, before.Peers+1)
+		dht.RecordPeerEvent(other, PeerTimeout)
+		st, _ = dht.Stats()
+		So(st.Peers, ShouldEqual, before.Peers)
+	})
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// directory implements the agent directory, so apps can look up users by handle instead of
+// each rebuilding it.  As agent entries, and the profile entries the DNA may declare, are
+// put to the DHT store the agent's name or handle is indexed against the agent's key, which
+// findAgent and the /agents endpoint search.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultProfileHandle = "handle" // the field of a profile entry holding the handle if not declared
+
+	// sources of directory handles
+	DirectoryAgent   = "agent"   // the name in the agent entry
+	DirectoryProfile = "profile" // the handle in the agent's latest profile entry
+)
+
+var ErrDirectoryInValidation error = errors.New("the agent directory can't be searched during validation")
+
+// ProfileDef declares in the DNA the entry type agents' profiles are committed as, so their
+// handles are indexed in the agent directory
+type ProfileDef struct {
+	Entry  string // the entry type, which must be json
+	Handle string `json:",omitempty" toml:",omitempty"` // the field holding the handle, DefaultProfileHandle if not set
+}
+
+// DirectoryEntry is an agent found in the directory
+type DirectoryEntry struct {
+	Handle string // the name or handle as given
+	Agent  string // the agent's key, which is the hash of its KeyEntry, for use as a link base
+	Entry  string // hash of the agent or profile entry the handle is from
+	Source string // DirectoryAgent or DirectoryProfile
+}
+
+func (p *ProfileDef) handle() string {
+	if p.Handle == "" {
+		return DefaultProfileHandle
+	}
+	return p.Handle
+}
+
+// Check confirms that the profile entry type is declared as json in one of the zomes
+func (p *ProfileDef) Check(h *Holochain) (err error) {
+	var d *EntryDef
+	if _, d, err = h.GetEntryDef(p.Entry); err != nil {
+		err = fmt.Errorf("profile entry: %v", err)
+		return
+	}
+	if d.DataFormat != DataFormatJSON {
+		err = fmt.Errorf("profile entry %s must be json, not %s", p.Entry, d.DataFormat)
+	}
+	return
+}
+
+// directoryKey returns the key a handle is indexed at.  The handle is ended with a NUL, which
+// handles can't hold, rather than a ':' so that the handle is all that a search matches.
+func directoryKey(handle string, agent string) string {
+	return "agent:" + strings.ToLower(handle) + "\x00" + agent
+}
+
+func directorySourceKey(agent string, source string) string {
+	return "agentsrc:" + agent + ":" + source
+}
+
+// directoryHandle returns the handle an entry being put gives its source, if it gives one
+func (dht *DHT) directoryHandle(entryType string, value []byte) (handle string, source string) {
+	var e GobEntry
+	if entryType == AgentEntryType {
+		if e.Unmarshal(value) != nil {
+			return
+		}
+		if a, ok := e.C.(AgentEntry); ok && !strings.ContainsRune(string(a.Name), 0) {
+			handle, source = string(a.Name), DirectoryAgent
+		}
+		return
+	}
+	p := dht.h.Profiles
+	if p == nil {
+		return
+	}
+	if _, name := SplitEntryType(p.Entry); entryType != name || e.Unmarshal(value) != nil {
+		return
+	}
+	s, ok := e.C.(string)
+	if !ok {
+		return
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(s), &fields) != nil {
+		return
+	}
+	if handle, ok = fields[p.handle()].(string); ok {
+		source = DirectoryProfile
+	}
+	if strings.ContainsRune(handle, 0) {
+		handle, source = "", ""
+	}
+	return
+}
+
+// indexAgent adds the handle an agent or profile entry gives its source to the directory,
+// replacing any handle the source's earlier entry of the same kind gave
+func (dht *DHT) indexAgent(tx *buntdb.Tx, entryType string, key Hash, src peer.ID, value []byte) (err error) {
+	handle, source := dht.directoryHandle(entryType, value)
+	if handle == "" {
+		return
+	}
+	agent, err := NewHash(peer.IDB58Encode(src))
+	if err != nil {
+		return
+	}
+	a := agent.String()
+	sk := directorySourceKey(a, source)
+	if old, e := tx.Get(sk); e == nil {
+		if _, err = tx.Delete(old); err != nil && err != buntdb.ErrNotFound {
+			return
+		}
+	}
+	var b []byte
+	if b, err = json.Marshal(DirectoryEntry{Handle: handle, Agent: a, Entry: key.String(), Source: source}); err != nil {
+		return
+	}
+	k := directoryKey(handle, a)
+	if _, _, err = tx.Set(k, string(b), nil); err != nil {
+		return
+	}
+	_, _, err = tx.Set(sk, k, nil)
+	return
+}
+
+type byMatch struct {
+	entries []DirectoryEntry
+	query   string
+}
+
+func (p byMatch) Len() int      { return len(p.entries) }
+func (p byMatch) Swap(i, j int) { p.entries[i], p.entries[j] = p.entries[j], p.entries[i] }
+func (p byMatch) Less(i, j int) bool {
+	a, b := strings.ToLower(p.entries[i].Handle), strings.ToLower(p.entries[j].Handle)
+	if (a == p.query) != (b == p.query) {
+		return a == p.query
+	}
+	if a != b {
+		return a < b
+	}
+	return p.entries[i].Agent < p.entries[j].Agent
+}
+
+// FindAgent searches the directory for agents whose handles start with the query, ignoring
+// case, with exact matches first.  An empty query lists the whole directory.
+func (dht *DHT) FindAgent(query string) (entries []DirectoryEntry, err error) {
+	q := strings.ToLower(query)
+	prefix := "agent:" + q
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendGreaterOrEqual("", prefix, func(k, v string) bool {
+			if !strings.HasPrefix(k, prefix) {
+				return false
+			}
+			var d DirectoryEntry
+			if e = json.Unmarshal([]byte(v), &d); e != nil {
+				return false
+			}
+			entries = append(entries, d)
+			return true
+		})
+		return e
+	})
+	sort.Sort(byMatch{entries: entries, query: q})
+	return
+}
+
+// findAgent searches the directory for zome code, which can't during validation as the
+// directory is the node's own view
+func (h *Holochain) findAgent(query string, validating bool) (entries []DirectoryEntry, err error) {
+	if validating {
+		err = ErrDirectoryInValidation
+		return
+	}
+	if entries, err = h.dht.FindAgent(query); err == nil && entries == nil {
+		entries = []DirectoryEntry{}
+	}
+	return
+}
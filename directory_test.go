@@ -0,0 +1,101 @@
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestProfileDefCheck(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should check the profile entry is declared as json", t, func() {
+		So((&ProfileDef{Entry: "profile"}).Check(h), ShouldBeNil)
+		So((&ProfileDef{Entry: "bogus"}).Check(h).Error(), ShouldEqual, "profile entry: no definition for entry type: bogus")
+		So((&ProfileDef{Entry: "myOdds"}).Check(h).Error(), ShouldEqual, "profile entry myOdds must be json, not js")
+	})
+}
+
+func TestAgentDirectory(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	h.Profiles = &ProfileDef{Entry: "profile", Handle: "firstName"}
+	key, _ := NewHash(peer.IDB58Encode(h.id))
+	other, _ := makePeer("other")
+	otherKey, _ := NewHash(peer.IDB58Encode(other))
+
+	putProfile := func(src peer.ID, content string) Hash {
+		e := GobEntry{C: content}
+		hash, _ := e.Sum(h.hashSpec)
+		b, _ := e.Marshal()
+		if err := h.dht.put(nil, "profile", hash, src, b, LIVE); err != nil {
+			panic(err)
+		}
+		return hash
+	}
+
+	Convey("it should index the agent entries put at genesis", t, func() {
+		entries, err := h.dht.FindAgent("")
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Handle, ShouldEqual, string(h.agent.Name()))
+		So(entries[0].Agent, ShouldEqual, key.String())
+		So(entries[0].Entry, ShouldEqual, h.agentHash.String())
+		So(entries[0].Source, ShouldEqual, DirectoryAgent)
+	})
+
+	Convey("it should index handles from profile entries, keeping only the latest", t, func() {
+		putProfile(other, `{"firstName":"Zippy","lastName":"Pinhead"}`)
+		entries, _ := h.dht.FindAgent("zip")
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Agent, ShouldEqual, otherKey.String())
+		So(entries[0].Source, ShouldEqual, DirectoryProfile)
+
+		hash := putProfile(other, `{"firstName":"Zappa","lastName":"Pinhead"}`)
+		entries, _ = h.dht.FindAgent("zip")
+		So(len(entries), ShouldEqual, 0)
+		entries, _ = h.dht.FindAgent("ZAPPA")
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Handle, ShouldEqual, "Zappa")
+		So(entries[0].Entry, ShouldEqual, hash.String())
+	})
+
+	Convey("it should list exact matches first", t, func() {
+		putProfile(h.id, `{"firstName":"Zappafan","lastName":"Fan"}`)
+		entries, _ := h.dht.FindAgent("zappa")
+		So(len(entries), ShouldEqual, 2)
+		So(entries[0].Handle, ShouldEqual, "Zappa")
+		So(entries[1].Handle, ShouldEqual, "Zappafan")
+		So(entries[1].Agent, ShouldEqual, key.String())
+	})
+
+	Convey("handles holding a ':' should only match searches for the handle", t, func() {
+		third, _ := makePeer("third")
+		putProfile(third, `{"firstName":"Zappa:`+otherKey.String()+`"}`)
+		entries, _ := h.dht.FindAgent("zappa:")
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Handle, ShouldEqual, "Zappa:"+otherKey.String())
+		entries, _ = h.dht.FindAgent("zappa")
+		So(len(entries), ShouldEqual, 3)
+		So(entries[0].Handle, ShouldEqual, "Zappa")
+		putProfile(third, `{"firstName":"Nul\u0000"}`)
+		entries, _ = h.dht.FindAgent("nul")
+		So(len(entries), ShouldEqual, 0)
+		putProfile(third, `{"firstName":"Third"}`)
+	})
+
+	Convey("it shouldn't index entries without a handle", t, func() {
+		putProfile(other, `{"lastName":"Nobody"}`)
+		entries, _ := h.dht.FindAgent("zappa")
+		So(len(entries), ShouldEqual, 2)
+	})
+
+	Convey("zome code shouldn't search the directory while validating", t, func() {
+		_, err := h.findAgent("zappa", true)
+		So(err, ShouldEqual, ErrDirectoryInValidation)
+		entries, err := h.findAgent("nomatch", false)
+		So(err, ShouldBeNil)
+		So(entries, ShouldNotBeNil)
+	})
+}
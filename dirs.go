@@ -0,0 +1,111 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// dirs implements the resolution of where a holochain service keeps its files.  An explicit
+// path or HOLOPATH puts everything in one directory, as does an existing ~/.holochain.
+// Otherwise the service settings and agent go in the XDG config directory and the chains in
+// the XDG data directory, or both under %APPDATA% on Windows.
+
+package holochain
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	PathEnvVar    = "HOLOPATH"  // environment variable naming the holochain directory
+	XDGAppDirName = "holochain" // name of the service's directory under the XDG base directories
+)
+
+// ChainDirs holds the directories of a holochain service: Config for the service settings
+// and agent, and Data for the chains.  They are the same directory unless the XDG layout is used.
+type ChainDirs struct {
+	Config string
+	Data   string
+}
+
+// SingleChainDirs returns ChainDirs keeping everything in the one directory
+func SingleChainDirs(path string) ChainDirs {
+	return ChainDirs{Config: path, Data: path}
+}
+
+// homeDir returns the current user's home directory
+func homeDir() (home string, err error) {
+	home = os.Getenv("HOME")
+	if home == "" {
+		var u *user.User
+		u, err = user.Current()
+		if err != nil {
+			return
+		}
+		home = u.HomeDir
+	}
+	if home == "" {
+		err = errors.New("unable to find home directory")
+	}
+	return
+}
+
+// ResolveChainDirs returns the directories of the service, using path if it isn't empty,
+// then HOLOPATH, then an existing ~/.holochain, and finally the platform's standard locations
+func ResolveChainDirs(path string) (dirs ChainDirs, err error) {
+	if path == "" {
+		path = os.Getenv(PathEnvVar)
+	}
+	if path != "" {
+		dirs = SingleChainDirs(filepath.Clean(path))
+		return
+	}
+	var home string
+	if home, err = homeDir(); err != nil {
+		return
+	}
+	legacy := filepath.Join(home, DefaultDirectoryName)
+	if dirExists(legacy) {
+		dirs = SingleChainDirs(legacy)
+		return
+	}
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			dirs = SingleChainDirs(filepath.Join(appData, XDGAppDirName))
+		} else {
+			dirs = SingleChainDirs(legacy)
+		}
+		return
+	}
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		config = filepath.Join(home, ".config")
+	}
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		data = filepath.Join(home, ".local", "share")
+	}
+	dirs = ChainDirs{Config: filepath.Join(config, XDGAppDirName), Data: filepath.Join(data, XDGAppDirName)}
+	return
+}
+
+// IsInitialized checks the directories for a correctly set up service
+func (d ChainDirs) IsInitialized() bool {
+	return dirExists(d.Data) && fileExists(filepath.Join(d.Config, SysFileName)) && fileExists(filepath.Join(d.Config, AgentFileName))
+}
+
+// HasService returns true if any part of a service installation is found in the directories
+func (d ChainDirs) HasService() bool {
+	for _, f := range []string{SysFileName, AgentFileName, PrivKeyFileName} {
+		if fileExists(filepath.Join(d.Config, f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChainPath returns the directory of the named chain
+func (d ChainDirs) ChainPath(name string) string {
+	return filepath.Join(d.Data, name)
+}
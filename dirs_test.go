@@ -0,0 +1,87 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolveChainDirs(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+
+	env := map[string]string{}
+	for _, k := range []string{"HOME", PathEnvVar, "XDG_CONFIG_HOME", "XDG_DATA_HOME"} {
+		env[k] = os.Getenv(k)
+		os.Unsetenv(k)
+	}
+	defer func() {
+		for k, v := range env {
+			os.Setenv(k, v)
+		}
+	}()
+	os.Setenv("HOME", d)
+
+	Convey("an explicit path should hold everything", t, func() {
+		os.Setenv(PathEnvVar, "/fish")
+		dirs, err := ResolveChainDirs("/some/where/")
+		So(err, ShouldBeNil)
+		So(dirs, ShouldResemble, ChainDirs{Config: "/some/where", Data: "/some/where"})
+		So(dirs.ChainPath("test"), ShouldEqual, filepath.Join("/some/where", "test"))
+	})
+
+	Convey("HOLOPATH should be used if there's no explicit path", t, func() {
+		dirs, err := ResolveChainDirs("")
+		So(err, ShouldBeNil)
+		So(dirs, ShouldResemble, SingleChainDirs("/fish"))
+		os.Unsetenv(PathEnvVar)
+	})
+
+	if runtime.GOOS != "windows" {
+		Convey("otherwise the XDG directories should be used", t, func() {
+			dirs, err := ResolveChainDirs("")
+			So(err, ShouldBeNil)
+			So(dirs.Config, ShouldEqual, filepath.Join(d, ".config", XDGAppDirName))
+			So(dirs.Data, ShouldEqual, filepath.Join(d, ".local", "share", XDGAppDirName))
+			os.Setenv("XDG_CONFIG_HOME", filepath.Join(d, "conf"))
+			os.Setenv("XDG_DATA_HOME", filepath.Join(d, "data"))
+			dirs, err = ResolveChainDirs("")
+			So(err, ShouldBeNil)
+			So(dirs, ShouldResemble, ChainDirs{Config: filepath.Join(d, "conf", XDGAppDirName), Data: filepath.Join(d, "data", XDGAppDirName)})
+		})
+	}
+
+	Convey("an existing ~/.holochain should still be used", t, func() {
+		legacy := filepath.Join(d, DefaultDirectoryName)
+		So(os.Mkdir(legacy, os.ModePerm), ShouldBeNil)
+		dirs, err := ResolveChainDirs("")
+		So(err, ShouldBeNil)
+		So(dirs, ShouldResemble, SingleChainDirs(legacy))
+	})
+}
+
+func TestInitDirs(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	dirs := ChainDirs{Config: filepath.Join(d, "config"), Data: filepath.Join(d, "data")}
+
+	Convey("it should keep the settings and the chains apart", t, func() {
+		So(dirs.IsInitialized(), ShouldBeFalse)
+		s, err := InitDirs(dirs, "Herbert <h@bert.com>")
+		So(err, ShouldBeNil)
+		So(dirs.IsInitialized(), ShouldBeTrue)
+		So(fileExists(filepath.Join(dirs.Config, AgentFileName)), ShouldBeTrue)
+		So(s.Dirs(), ShouldResemble, dirs)
+
+		s, err = LoadServiceDirs(dirs)
+		So(err, ShouldBeNil)
+		So(s.Path, ShouldEqual, dirs.Data)
+		_, err = s.GenDev(dirs.ChainPath("test"), "toml")
+		So(err, ShouldBeNil)
+		h, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(h.Agent().Name(), ShouldEqual, AgentName("Herbert <h@bert.com>"))
+	})
+}
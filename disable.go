@@ -0,0 +1,64 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// disable implements parking an installed chain: a disabled chain keeps all its data but
+// isn't served, bridged to or otherwise run until it is enabled again.
+
+package holochain
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var ErrChainDisabled error = errors.New("chain disabled")
+
+// Disabled returns true if the chain has been disabled
+func (h *Holochain) Disabled() bool {
+	return h.Config().Disabled
+}
+
+// SetDisabled disables or enables the chain, saving the change to its config file
+func (h *Holochain) SetDisabled(disabled bool) (err error) {
+	h.updateConfig(func(config *Config) { config.Disabled = disabled })
+	err = h.saveConfig()
+	return
+}
+
+// saveConfig writes the chain's config back to its config file, in the file's format.  The
+// config is written to a temporary file that then replaces the old one, so a crash while
+// saving can't leave a truncated config behind.
+func (h *Holochain) saveConfig() (err error) {
+	var format string
+	if format, err = findEncodedFile(h.path, ConfigFileName); err != nil {
+		return
+	}
+	path := filepath.Join(h.path, ConfigFileName+"."+format)
+	var f *os.File
+	if f, err = ioutil.TempFile(h.path, ConfigFileName+"."); err != nil {
+		return
+	}
+	config := h.Config()
+	err = Encode(f, format, &config)
+	if err == nil {
+		if info, e := os.Stat(path); e == nil {
+			err = f.Chmod(info.Mode())
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return
+}
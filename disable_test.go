@@ -0,0 +1,47 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"path/filepath"
+	"testing"
+)
+
+func TestDisable(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+	for _, n := range []string{"reader", "parked"} {
+		if _, err := s.GenDev(filepath.Join(s.Path, n), "toml"); err != nil {
+			panic(err)
+		}
+	}
+	h, err := s.Load("parked")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should save the disabled flag to the chain's config", t, func() {
+		So(h.Disabled(), ShouldBeFalse)
+		So(h.SetDisabled(true), ShouldBeNil)
+		h2, err := s.Load("parked")
+		So(err, ShouldBeNil)
+		So(h2.Disabled(), ShouldBeTrue)
+		So(h2.config.Loggers.App.Enabled, ShouldBeTrue)
+		configs, err := filepath.Glob(filepath.Join(h.path, ConfigFileName+".*"))
+		So(err, ShouldBeNil)
+		So(len(configs), ShouldEqual, 1)
+	})
+
+	Convey("it should refuse to bridge to disabled chains", t, func() {
+		reader, err := s.Load("reader")
+		So(err, ShouldBeNil)
+		_, err = reader.bridgeServing("parked")
+		So(err, ShouldEqual, ErrChainDisabled)
+	})
+
+	Convey("it should enable a disabled chain", t, func() {
+		So(h.SetDisabled(false), ShouldBeNil)
+		h2, err := s.Load("parked")
+		So(err, ShouldBeNil)
+		So(h2.Disabled(), ShouldBeFalse)
+	})
+}
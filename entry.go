@@ -13,6 +13,7 @@ import (
 	"github.com/lestrrat/go-jsval"
 	"github.com/lestrrat/go-jsval/builder"
 	"io"
+	"path/filepath"
 )
 
 const (
@@ -30,11 +31,18 @@ const (
 
 // EntryDef struct holds an entry definition
 type EntryDef struct {
-	Name       string
-	DataFormat string
-	Schema     string // file name of schema or language schema directive
-	SchemaHash Hash
-	validator  SchemaValidator
+	Name           string
+	DataFormat     string
+	Schema         string // file name of schema or language schema directive
+	SchemaHash     Hash
+	CRDT           string     // if set entries of this type are updates to a CRDT of this kind (gset, lww or counter)
+	TTL            string     // if set entries of this type expire from the DHT after this duration, e.g. "10m"
+	Dependencies   []string   `json:",omitempty" toml:",omitempty"` // fields holding hashes of entries to resolve before validation
+	Retention      *Retention `json:",omitempty" toml:",omitempty"` // if set older entries of this type are pruned from the local chain
+	Links          []LinkDef  `json:",omitempty" toml:",omitempty"` // if set the only links that may be made from entries of this type
+	Countersigners int        `json:",omitempty" toml:",omitempty"` // if set entries of this type must be signed by at least this many agents, including their author
+	MaxSize        int        `json:",omitempty" toml:",omitempty"` // largest entries of this type may be in bytes, DefaultMaxEntrySize if not set
+	validator      SchemaValidator
 }
 
 // Entry describes serialization and deserialziation of entry data
@@ -152,7 +160,7 @@ func (v *JSONSchemaValidator) Validate(entry interface{}) (err error) {
 // BuildJSONSchemaValidator builds a validator in an EntryDef
 func (d *EntryDef) BuildJSONSchemaValidator(path string) (err error) {
 	var s *schema.Schema
-	s, err = schema.ReadFile(path + "/" + d.Schema)
+	s, err = schema.ReadFile(filepath.Join(path, d.Schema))
 	if err != nil {
 		return
 	}
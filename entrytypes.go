@@ -0,0 +1,207 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// entrytypes implements the namespacing of entry types by the zomes that define them.  As
+// entries are stored under the bare names of their types, a name may only be defined by one
+// zome, and a DNA in which two zomes define the same name differently fails to load rather
+// than having one silently shadow the other.  Wherever an entry type is named, in zome code, queries, profiles
+// and link declarations, it may be qualified by its zome as zome:type, making references to
+// other zomes' types explicit and checked against the zome that defines them.  Entry types can
+// also declare the links that may be made from their entries, so that links of undeclared
+// tags, or to entries of the wrong type, are rejected when they are validated.
+
+package holochain
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// EntryTypeSeparator separates the zome from the name of a qualified entry type
+const EntryTypeSeparator = ":"
+
+// LinkDef declares that entries of a type may be linked to entries of another type
+type LinkDef struct {
+	Tag   string // the meta tag of the link
+	Entry string // the type of the linked entries, which may be qualified by its zome
+}
+
+// QualifiedEntryType returns the name of an entry type qualified by the zome that defines it
+func QualifiedEntryType(zome string, entryType string) string {
+	return zome + EntryTypeSeparator + entryType
+}
+
+// SplitEntryType splits a reference to an entry type into its zome, empty if it isn't
+// qualified, and its name
+func SplitEntryType(ref string) (zome string, name string) {
+	if i := strings.Index(ref, EntryTypeSeparator); i >= 0 {
+		return ref[:i], ref[i+len(EntryTypeSeparator):]
+	}
+	return "", ref
+}
+
+// EntryTypeName returns the bare name under which entries of a referenced type are stored,
+// checking that a qualified reference names the zome that defines the type
+func (h *Holochain) EntryTypeName(ref string) (name string, err error) {
+	zome, name := SplitEntryType(ref)
+	if zome == "" {
+		return
+	}
+	z, ok := h.Zomes[zome]
+	if !ok {
+		err = fmt.Errorf("unknown zome %s in entry type %s", zome, ref)
+		return
+	}
+	if _, ok = z.Entries[name]; !ok {
+		err = fmt.Errorf("zome %s doesn't define entry type %s", zome, name)
+	}
+	return
+}
+
+// sameEntryDef returns true if two zomes' definitions of an entry type are the same
+func sameEntryDef(a EntryDef, b EntryDef) bool {
+	a.validator, b.validator = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// zomesDefining returns the zomes that define an entry type, in order of their names
+func (h *Holochain) zomesDefining(name string) (zomes []*Zome) {
+	var names []string
+	for zn, z := range h.Zomes {
+		if _, ok := z.Entries[name]; ok {
+			names = append(names, zn)
+		}
+	}
+	sort.Strings(names)
+	for _, zn := range names {
+		zomes = append(zomes, h.Zomes[zn])
+	}
+	return
+}
+
+// checkEntryTypeNames rejects entry types defined differently by more than one zome, and
+// names that would be taken as qualified.  Zomes may share an entry type by defining it the
+// same way, in which case its entries must pass each of their validation rules.
+func (h *Holochain) checkEntryTypeNames() (err error) {
+	var zomes []string
+	for name := range h.Zomes {
+		zomes = append(zomes, name)
+	}
+	sort.Strings(zomes)
+	defined := make(map[string]string)
+	for _, zome := range zomes {
+		var names []string
+		for name := range h.Zomes[zome].Entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if strings.Contains(name, EntryTypeSeparator) {
+				return fmt.Errorf("entry type %s of zome %s can't contain %q", name, zome, EntryTypeSeparator)
+			}
+			if other, ok := defined[name]; ok {
+				if !sameEntryDef(h.Zomes[other].Entries[name], h.Zomes[zome].Entries[name]) {
+					return fmt.Errorf("entry type %s is defined by both zome %s and zome %s", name, other, zome)
+				}
+				continue
+			}
+			defined[name] = zome
+		}
+	}
+	return
+}
+
+// checkLinks confirms that an entry type's link declarations name types defined by the DNA
+func (d *EntryDef) checkLinks(h *Holochain) (err error) {
+	tags := make(map[string]bool)
+	for _, l := range d.Links {
+		if l.Tag == "" {
+			return fmt.Errorf("entry type %s declares a link without a tag", d.Name)
+		}
+		if tags[l.Tag] {
+			return fmt.Errorf("entry type %s declares link %s more than once", d.Name, l.Tag)
+		}
+		tags[l.Tag] = true
+		if _, _, err = h.GetEntryDef(l.Entry); err != nil {
+			return fmt.Errorf("entry type %s link %s: %v", d.Name, l.Tag, err)
+		}
+	}
+	return
+}
+
+// checkLink rejects a link from an entry of baseType to one of linkedType if baseType declares
+// its links and doesn't declare this one.  Links made by the system, e.g. redactions, and
+// links from types without declarations are always allowed.
+func (h *Holochain) checkLink(baseType string, tag string, linkedType string) (err error) {
+	if strings.HasPrefix(linkedType, "%") {
+		return
+	}
+	_, d, e := h.GetEntryDef(baseType)
+	if e != nil || len(d.Links) == 0 {
+		return
+	}
+	for _, l := range d.Links {
+		if l.Tag != tag {
+			continue
+		}
+		var want string
+		if want, err = h.EntryTypeName(l.Entry); err != nil {
+			return
+		}
+		if want != linkedType {
+			err = fmt.Errorf("link %s from %s must be to a %s, not a %s", tag, baseType, want, linkedType)
+		}
+		return
+	}
+	err = fmt.Errorf("entry type %s declares no link %s", baseType, tag)
+	return
+}
+
+// entryType returns the type of an entry on the chain or in the DHT store, if it is known
+func (dht *DHT) entryType(key Hash) (entryType string, ok bool) {
+	var err error
+	if _, entryType, err = dht.h.chain.GetEntry(key); err == nil || err == ErrEntryPruned {
+		return entryType, true
+	}
+	if _, entryType, _, err = dht.get(key); err == nil {
+		return entryType, true
+	}
+	return "", false
+}
+
+// checkMetaReq checks a putmeta against the link declarations of its base's type, if the types
+// of both entries are known
+func (dht *DHT) checkMetaReq(req MetaReq, linkedType string) (err error) {
+	baseType, ok := dht.entryType(req.O)
+	if !ok {
+		return
+	}
+	if linkedType == "" {
+		if linkedType, ok = dht.entryType(req.M); !ok {
+			return
+		}
+	}
+	return dht.h.checkLink(baseType, req.T, linkedType)
+}
+
+// checkMetaLink checks a putmeta being validated against the link declarations of its base's
+// type.  Unlike checkMetaReq the base's type must be known, as a link can't be judged without it.
+func (h *Holochain) checkMetaLink(linkedType string, props *ValidationProps) (err error) {
+	if h.dht == nil || strings.HasPrefix(linkedType, "%") {
+		return
+	}
+	var base Hash
+	if base, err = NewHash(props.MetaBase); err != nil {
+		return
+	}
+	baseType, ok := h.dht.entryType(base)
+	if !ok {
+		err = fmt.Errorf("%v: base %s of link %s", ErrHashNotFound, props.MetaBase, props.MetaTag)
+		return
+	}
+	err = h.checkLink(baseType, props.MetaTag, linkedType)
+	return
+}
@@ -0,0 +1,114 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestEntryTypeNames(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should split qualified entry types", t, func() {
+		zome, name := SplitEntryType(QualifiedEntryType("myZome", "profile"))
+		So(zome, ShouldEqual, "myZome")
+		So(name, ShouldEqual, "profile")
+		zome, name = SplitEntryType("profile")
+		So(zome, ShouldEqual, "")
+		So(name, ShouldEqual, "profile")
+	})
+
+	Convey("it should resolve qualified entry types in the zome that defines them", t, func() {
+		z, def, err := h.GetEntryDef("myZome:profile")
+		So(err, ShouldBeNil)
+		So(z.Name, ShouldEqual, "myZome")
+		So(def.Name, ShouldEqual, "profile")
+		_, _, err = h.GetEntryDef("jsZome:myData")
+		So(err.Error(), ShouldEqual, "no definition for entry type: jsZome:myData")
+
+		name, err := h.EntryTypeName("myZome:profile")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, "profile")
+		_, err = h.EntryTypeName("jsZome:myData")
+		So(err.Error(), ShouldEqual, "zome jsZome doesn't define entry type myData")
+		_, err = h.EntryTypeName("noZome:profile")
+		So(err.Error(), ShouldEqual, "unknown zome noZome in entry type noZome:profile")
+	})
+
+	Convey("entries committed by qualified type should be stored under the bare name", t, func() {
+		hash, err := h.Commit("myZome:profile", `{"firstName":"Art","lastName":"Brock"}`)
+		So(err, ShouldBeNil)
+		_, entryType, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "profile")
+		_, err = h.Commit("jsZome:myData", "2")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("zome code should commit entry types shared with other zomes", t, func() {
+		_, err := h.Call("jsZome", "addProfile", `{"firstName":"Eric","lastName":"H"}`)
+		So(err, ShouldBeNil)
+		So(h.chain.Top().Type, ShouldEqual, "profile")
+	})
+
+	Convey("it should reject entry types defined differently by more than one zome", t, func() {
+		So(h.checkEntryTypeNames(), ShouldBeNil)
+		h.Zomes["jsZome"].Entries["primes"] = EntryDef{Name: "primes", DataFormat: DataFormatString}
+		defer delete(h.Zomes["jsZome"].Entries, "primes")
+		So(h.checkEntryTypeNames().Error(), ShouldEqual, "entry type primes is defined by both zome jsZome and zome myZome")
+	})
+
+	Convey("entries of types shared by zomes should pass each zome's rules", t, func() {
+		zomes := h.zomesDefining("profile")
+		So(len(zomes), ShouldEqual, 2)
+		So(zomes[0].Name, ShouldEqual, "jsZome")
+		So(zomes[1].Name, ShouldEqual, "myZome")
+		So(h.ValidateEntry("profile", &GobEntry{C: `{"firstName":"Art","lastName":"Brock"}`}, nil), ShouldBeNil)
+	})
+}
+
+func TestLinkDeclarations(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	z, def, _ := h.GetEntryDef("myData")
+	def.Links = []LinkDef{{Tag: "odd", Entry: "jsZome:myOdds"}}
+	z.Entries["myData"] = *def
+
+	Convey("link declarations should name defined entry types", t, func() {
+		So(def.checkLinks(h), ShouldBeNil)
+		bad := EntryDef{Name: "myData", Links: []LinkDef{{Tag: "odd", Entry: "myZome:myOdds"}}}
+		So(bad.checkLinks(h).Error(), ShouldEqual, "entry type myData link odd: no definition for entry type: myZome:myOdds")
+		bad.Links = []LinkDef{{Entry: "myOdds"}}
+		So(bad.checkLinks(h).Error(), ShouldEqual, "entry type myData declares a link without a tag")
+	})
+
+	Convey("only declared links should be allowed from types that declare links", t, func() {
+		So(h.checkLink("myData", "odd", "myOdds"), ShouldBeNil)
+		So(h.checkLink("myData", "odd", "primes").Error(), ShouldEqual, "link odd from myData must be to a myOdds, not a primes")
+		So(h.checkLink("myData", "even", "myOdds").Error(), ShouldEqual, "entry type myData declares no link even")
+		So(h.checkLink("myData", RedactionMetaTag, RedactionEntryType), ShouldBeNil)
+		So(h.checkLink("primes", "anything", "myOdds"), ShouldBeNil)
+	})
+
+	Convey("putmeta should check the types of the linked entries", t, func() {
+		base, _ := h.Commit("myData", "2")
+		prime, _ := h.Commit("primes", `{"prime":7}`)
+		odd, _ := h.Commit("myOdds", "7")
+		So(h.dht.checkMetaReq(MetaReq{O: base, M: odd, T: "odd"}, ""), ShouldBeNil)
+		err := h.dht.SendPutMeta(MetaReq{O: base, M: prime, T: "odd"})
+		So(err.Error(), ShouldEqual, "link odd from myData must be to a myOdds, not a primes")
+	})
+
+	Convey("validating a putmeta should check the link is declared", t, func() {
+		base, _ := h.Commit("myData", "4")
+		prime, _ := h.Commit("primes", `{"prime":11}`)
+		p := ValidationProps{MetaTag: "odd", MetaHash: prime.String(), MetaBase: base.String()}
+		err := h.ValidateEntry("primes", &GobEntry{C: `{"prime":11}`}, &p)
+		So(err.Error(), ShouldEqual, "link odd from myData must be to a myOdds, not a primes")
+		unknown, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		p.MetaBase = unknown.String()
+		err = h.ValidateEntry("primes", &GobEntry{C: `{"prime":11}`}, &p)
+		So(isError(err, ErrHashNotFound), ShouldBeTrue)
+	})
+}
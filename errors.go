@@ -0,0 +1,95 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// errors implements the errors callers commonly need to tell apart, and the codes the web
+// server reports them with.  Callers should test for them by comparing with the error values,
+// or with isError for errors that add detail after the error's own message.
+
+package holochain
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrNotInitialized error = errors.New("service not initialized, run 'hc init'")
+var ErrChainNotStarted error = errors.New("holochain: chain not started")
+var ErrChainStarted error = errors.New("holochain: chain already started")
+var ErrNoEntryDef error = errors.New("no definition for entry type")
+
+// ErrEntryNotFound is returned when an entry isn't on the chain or in the DHT
+var ErrEntryNotFound = ErrHashNotFound
+
+// ErrValidationFailed is returned when an entry fails its zome's validation rules
+type ErrValidationFailed struct {
+	Reason string
+}
+
+func (e *ErrValidationFailed) Error() string {
+	return "Invalid entry: " + e.Reason
+}
+
+// statusCodes maps errors to the HTTP status codes they are reported with
+var statusCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrHashNotFound, 404},
+	{ErrTokenNotFound, 404},
+	{ErrCRDTObjectNotFound, 404},
+	{ErrNoHeartbeat, 404},
+	{ErrNotPinned, 404},
+	{ErrPairingNotFound, 404},
+	{ErrAuditLogDisabled, 404},
+	{ErrRelayDisabled, 404},
+	{ErrNoEntryDef, 404},
+	{ErrEntryExpired, 410},
+	{ErrEntryPruned, 410},
+	{ErrEntryRedacted, 451},
+	{ErrEntryQuarantined, 410},
+	{ErrNotDevMode, 403},
+	{ErrBridgeNotGranted, 403},
+	{ErrBridgeNotServed, 503},
+	{ErrRelayClientNotAllowed, 403},
+	{ErrIdempotencyKeyReused, 409},
+	{ErrEntryTooLarge, 413},
+	{ErrArgTooLarge, 413},
+	{ErrBatchTooLarge, 413},
+	{ErrChainNotStarted, 503},
+	{ErrChainDisabled, 503},
+	{ErrGenesisPending, 503},
+	{ErrCallQuotaExceeded, 503},
+	{ErrWorkerQuotaExceeded, 503},
+	{ErrDHTPutQueueFull, 503},
+	{ErrGetTimeout, 504},
+}
+
+// isError returns true if err is target, or is target with detail added as "target: detail"
+func isError(err error, target error) bool {
+	return err == target || (err != nil && strings.HasPrefix(err.Error(), target.Error()+": "))
+}
+
+// StatusCode returns the HTTP status code to report an error to a web client with
+func StatusCode(err error) int {
+	if _, ok := err.(*ErrValidationFailed); ok {
+		return 400
+	}
+	for _, s := range statusCodes {
+		if isError(err, s.err) {
+			return s.code
+		}
+	}
+	return 500
+}
+
+// knownError returns the error with the given message if it is one of those with a status
+// code, so that errors reported by another node's web server can be told apart again
+func knownError(msg string) error {
+	for _, s := range statusCodes {
+		if s.err.Error() == msg {
+			return s.err
+		}
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,38 @@
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("validation failures should be typed", t, func() {
+		err := h.ValidateEntry("myData", &GobEntry{C: "1"}, nil)
+		v, ok := err.(*ErrValidationFailed)
+		So(ok, ShouldBeTrue)
+		So(v.Reason, ShouldEqual, "1")
+		So(err.Error(), ShouldEqual, "Invalid entry: 1")
+	})
+
+	Convey("starting a started chain should return a sentinel error", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldEqual, ErrChainStarted)
+		So(ErrEntryNotFound, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should give errors HTTP status codes", t, func() {
+		So(StatusCode(ErrHashNotFound), ShouldEqual, 404)
+		So(StatusCode(fmt.Errorf("%v: getting", ErrGetTimeout)), ShouldEqual, 504)
+		So(StatusCode(fmt.Errorf("getting: %v", ErrGetTimeout)), ShouldEqual, 500)
+		_, _, err := h.GetEntryDef("fish")
+		So(StatusCode(err), ShouldEqual, 404)
+		So(StatusCode(&ErrValidationFailed{Reason: "x"}), ShouldEqual, 400)
+		So(StatusCode(ErrChainNotStarted), ShouldEqual, 503)
+		So(StatusCode(errors.New("something else")), ShouldEqual, 500)
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// events implements subscriptions to the lifecycle events of a holochain instance so that
+// embedding applications can react to activity without polling
+
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	"sync"
+	"time"
+)
+
+// EventMask selects kinds of events, each kind being a single bit
+type EventMask uint32
+
+const (
+	EventCommit           EventMask = 1 << iota // an entry was committed to the local chain
+	EventPutReceived                            // a put from another node was stored in the DHT
+	EventValidationFailed                       // an entry failed validation
+	EventPeerJoined                             // a peer became known or reachable
+	EventPeerLeft                               // a peer stopped responding
+	EventGossip                                 // a gossip round with a peer completed
+
+	AllEvents EventMask = 1<<iota - 1
+)
+
+// EventNames are the names of the kinds of events, as used in test files
+var EventNames = map[EventMask]string{
+	EventCommit:           "commit",
+	EventPutReceived:      "put",
+	EventValidationFailed: "validation-failed",
+	EventPeerJoined:       "peer-joined",
+	EventPeerLeft:         "peer-left",
+	EventGossip:           "gossip",
+}
+
+// DefaultEventBuffer is the number of events buffered for each subscriber.  Events for a
+// subscriber whose buffer is full are dropped rather than blocking the holochain.
+const DefaultEventBuffer = 100
+
+// Event describes something that happened in a holochain instance
+type Event struct {
+	Type      EventMask
+	Time      time.Time
+	Hash      Hash    // the entry committed, put or that failed validation
+	EntryType string  // the type of that entry, if known
+	Peer      peer.ID // the peer involved, if any
+	Count     int     // for gossip, the number of puts received
+	Err       error   // for validation failures, the reason
+	Received  bool    // for commits, true if made by a zome's receive function
+}
+
+type subscription struct {
+	mask EventMask
+	c    chan Event
+}
+
+// Events dispatches events to subscribers
+// All methods are safe to call on a nil Events, in which case they do nothing.
+type Events struct {
+	lk   sync.RWMutex
+	subs []*subscription
+}
+
+// NewEvents creates an event dispatcher with no subscribers
+func NewEvents() *Events {
+	return &Events{}
+}
+
+// Subscribe returns a channel on which the events selected by mask will be sent
+func (e *Events) Subscribe(mask EventMask) <-chan Event {
+	s := &subscription{mask: mask, c: make(chan Event, DefaultEventBuffer)}
+	if e != nil {
+		e.lk.Lock()
+		e.subs = append(e.subs, s)
+		e.lk.Unlock()
+	}
+	return s.c
+}
+
+// Unsubscribe stops sending events on a channel returned by Subscribe and closes it
+func (e *Events) Unsubscribe(c <-chan Event) {
+	if e == nil {
+		return
+	}
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	for i, s := range e.subs {
+		if s.c == c {
+			close(s.c)
+			e.subs = append(e.subs[:i], e.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// emit sends an event to the subscribers of its type, returning the number of subscribers
+// the event had to be dropped for because their buffers were full
+func (e *Events) emit(event Event) (dropped int) {
+	if e == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	e.lk.RLock()
+	defer e.lk.RUnlock()
+	for _, s := range e.subs {
+		if s.mask&event.Type == 0 {
+			continue
+		}
+		select {
+		case s.c <- event:
+		default:
+			dropped++
+		}
+	}
+	return
+}
+
+// Subscribe returns a channel on which the holochain's events selected by mask will be sent
+func (h *Holochain) Subscribe(mask EventMask) <-chan Event {
+	return h.events.Subscribe(mask)
+}
+
+// Unsubscribe stops sending events on a channel returned by Subscribe and closes it
+func (h *Holochain) Unsubscribe(c <-chan Event) {
+	h.events.Unsubscribe(c)
+}
+
+// emit sends an event to subscribers, counting any that had to be dropped
+func (h *Holochain) emit(event Event) {
+	if dropped := h.events.emit(event); dropped > 0 {
+		h.metrics.Inc("events.dropped", int64(dropped))
+	}
+}
+
+// Followed is an entry newly committed to the local chain or stored in the DHT, as sent by Follow
+type Followed struct {
+	Event      Event
+	Header     *Header // the entry's header, for commits
+	HeaderHash Hash    // and the hash of that header
+	Entry      Entry   // the entry, if it could be retrieved
+	Err        error   // why the entry couldn't be retrieved
+}
+
+// Follow returns a channel on which each entry committed to the local chain or received into
+// the DHT store is sent, until stop is closed, which must be done once the caller stops reading
+func (h *Holochain) Follow(stop <-chan struct{}) <-chan Followed {
+	c := h.Subscribe(EventCommit | EventPutReceived)
+	out := make(chan Followed, DefaultEventBuffer)
+	go func() {
+		defer close(out)
+		defer h.Unsubscribe(c)
+		for {
+			select {
+			case ev, ok := <-c:
+				if !ok {
+					return
+				}
+				select {
+				case out <- h.followed(ev):
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// followed looks up the entry a commit or put event refers to
+func (h *Holochain) followed(ev Event) (f Followed) {
+	f.Event = ev
+	if ev.Type == EventCommit {
+		f.Header, f.HeaderHash, f.Entry, f.Err = h.chain.getCommitted(ev.Hash)
+		return
+	}
+	var data []byte
+	data, _, _, f.Err = h.dht.get(ev.Hash)
+	if f.Err != nil {
+		return
+	}
+	var e GobEntry
+	if f.Err = e.Unmarshal(data); f.Err == nil {
+		f.Entry = &e
+	}
+	return
+}
@@ -0,0 +1,150 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func nextEvent(c <-chan Event) (e Event, ok bool) {
+	select {
+	case e, ok = <-c:
+	case <-time.After(time.Second):
+	}
+	return
+}
+
+func TestEvents(t *testing.T) {
+	Convey("a nil Events should do nothing", t, func() {
+		var e *Events
+		c := e.Subscribe(AllEvents)
+		So(e.emit(Event{Type: EventCommit}), ShouldEqual, 0)
+		e.Unsubscribe(c)
+	})
+
+	Convey("it should only send subscribed events", t, func() {
+		e := NewEvents()
+		c := e.Subscribe(EventCommit | EventGossip)
+		e.emit(Event{Type: EventPeerJoined})
+		e.emit(Event{Type: EventGossip, Count: 3})
+		ev, ok := nextEvent(c)
+		So(ok, ShouldBeTrue)
+		So(ev.Type, ShouldEqual, EventGossip)
+		So(ev.Count, ShouldEqual, 3)
+		So(ev.Time.IsZero(), ShouldBeFalse)
+		So(len(c), ShouldEqual, 0)
+	})
+
+	Convey("it should drop events for full subscribers", t, func() {
+		e := NewEvents()
+		e.Subscribe(AllEvents)
+		for i := 0; i < DefaultEventBuffer; i++ {
+			So(e.emit(Event{Type: EventCommit}), ShouldEqual, 0)
+		}
+		So(e.emit(Event{Type: EventCommit}), ShouldEqual, 1)
+	})
+
+	Convey("unsubscribing should close the channel", t, func() {
+		e := NewEvents()
+		c := e.Subscribe(AllEvents)
+		e.Unsubscribe(c)
+		_, ok := <-c
+		So(ok, ShouldBeFalse)
+		So(len(e.subs), ShouldEqual, 0)
+	})
+}
+
+func TestHolochainEvents(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	c := h.Subscribe(AllEvents)
+	defer h.Unsubscribe(c)
+
+	Convey("it should emit commit events", t, func() {
+		_, err := h.Call("myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		ev, ok := nextEvent(c)
+		So(ok, ShouldBeTrue)
+		So(ev.Type, ShouldEqual, EventCommit)
+		So(ev.EntryType, ShouldEqual, "myData")
+		So(ev.Hash.String(), ShouldEqual, h.chain.Top().EntryLink.String())
+	})
+
+	Convey("it should emit validation failure events", t, func() {
+		_, err := h.Call("myZome", "addData", "5")
+		So(err, ShouldNotBeNil)
+		ev, ok := nextEvent(c)
+		So(ok, ShouldBeTrue)
+		So(ev.Type, ShouldEqual, EventValidationFailed)
+		So(ev.EntryType, ShouldEqual, "myData")
+		So(ev.Err, ShouldNotBeNil)
+	})
+
+	Convey("it should emit peer events", t, func() {
+		p, _ := makePeer("peer")
+		err := h.dht.UpdateGossiper(p, 0)
+		So(err, ShouldBeNil)
+		ev, _ := nextEvent(c)
+		So(ev.Type, ShouldEqual, EventPeerJoined)
+		So(ev.Peer, ShouldEqual, p)
+
+		h.dht.UpdateGossiper(p, 1)
+		h.dht.RecordPeerEvent(p, PeerTimeout)
+		ev, _ = nextEvent(c)
+		So(ev.Type, ShouldEqual, EventPeerLeft)
+
+		h.dht.RecordPeerEvent(p, PeerTimeout)
+		h.dht.RecordPeerEvent(p, PeerSuccess)
+		ev, _ = nextEvent(c)
+		So(ev.Type, ShouldEqual, EventPeerJoined)
+	})
+}
+
+func TestFollow(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	for len(h.dht.puts) > 0 {
+		<-h.dht.puts
+	}
+	stop := make(chan struct{})
+	c := h.Follow(stop)
+
+	next := func() (f Followed, ok bool) {
+		select {
+		case f, ok = <-c:
+		case <-time.After(time.Second):
+		}
+		return
+	}
+
+	Convey("it should follow commits with their headers and entries", t, func() {
+		hash, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		f, ok := next()
+		So(ok, ShouldBeTrue)
+		So(f.Err, ShouldBeNil)
+		So(f.Event.Type, ShouldEqual, EventCommit)
+		So(f.Header.EntryLink.String(), ShouldEqual, hash.String())
+		So(f.HeaderHash.String(), ShouldEqual, h.chain.Hashes[len(h.chain.Hashes)-1].String())
+		So(f.Entry.Content(), ShouldEqual, "2")
+
+		Convey("and follow their puts into the DHT", func() {
+			So(h.dht.SendPut(hash), ShouldBeNil)
+			So(h.dht.simHandlePutReqs(), ShouldBeNil)
+			f, ok := next()
+			So(ok, ShouldBeTrue)
+			So(f.Err, ShouldBeNil)
+			So(f.Event.Type, ShouldEqual, EventPutReceived)
+			So(f.Event.EntryType, ShouldEqual, "myData")
+			So(f.Header, ShouldBeNil)
+			So(f.Entry.Content(), ShouldEqual, "2")
+		})
+	})
+
+	Convey("it should stop following when stopped", t, func() {
+		close(stop)
+		_, ok := <-c
+		So(ok, ShouldBeFalse)
+	})
+}
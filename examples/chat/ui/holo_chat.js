@@ -1,12 +1,12 @@
 
  function getMyProfile() {
-   $.get("/fn/profiles/myProfile", "", function(profile){
+   $.get("fn/profiles/myProfile", "", function(profile){
      $("#title-username").text(JSON.parse(profile).firstName)
    });
  }
 
  function getRooms() {
-   $.get("/fn/rooms/listRooms", "", function(rooms){
+   $.get("fn/rooms/listRooms", "", function(rooms){
      rooms = JSON.parse(rooms)
      $("#rooms").empty()
      for(i=0;i<rooms.length;i++){
@@ -21,7 +21,7 @@
      purpose: "..."
    }
    $("#room-name-input").val('')
-   $.post("/fn/rooms/newRoom", JSON.stringify(room), getRooms)
+   $.post("fn/rooms/newRoom", JSON.stringify(room), getRooms)
  }
 
  function selectRoom(event) {
@@ -39,10 +39,10 @@
      email: $("#signupEmail").val()
    };
    console.log('signup clicked');
-   $.post("/fn/profiles/register", JSON.stringify(arg),
+   $.post("fn/profiles/register", JSON.stringify(arg),
      function(hash) {
        console.log('register: '+hash)
-       $.post("/fn/profiles/isRegistered", "",
+       $.post("fn/profiles/isRegistered", "",
            function(registered) {
                console.log('registered: '+registered)
                if(JSON.parse(registered)) {
@@ -59,7 +59,7 @@
 
 
  $(window).ready(function() {
-    $.post("/fn/profiles/isRegistered", "",
+    $.post("fn/profiles/isRegistered", "",
         function(registered) {
 
             if(!JSON.parse(registered)){
@@ -2,7 +2,7 @@ var Clutter = {posts:{},users:{},follows:{},nick:""};
 
 function send(fn,data,resultFn) {
     $.post(
-        "/fn/clutter/"+fn,
+        "fn/clutter/"+fn,
         JSON.stringify(data),
         function(response) {
             resultFn(JSON.parse(response));
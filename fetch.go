@@ -0,0 +1,130 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// fetch implements outbound http requests from zome functions, for apps that act as oracles
+// of external data.  The DNA must opt in by declaring the hosts that may be fetched from, and
+// fetching is refused during validation, which must give the same answer on every node.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultFetchMaxBytes = 1024 * 1024
+	DefaultFetchTimeout  = 10000 // milliseconds
+)
+
+var ErrFetchNotDeclared error = errors.New("DNA doesn't declare http fetch")
+var ErrFetchInValidation error = errors.New("fetch can't be used during validation")
+
+// HTTPFetchPolicy declares in the DNA which hosts zome functions may fetch from
+type HTTPFetchPolicy struct {
+	Hosts    []string // allowed hosts, where *.example.com allows any subdomain of example.com
+	MaxBytes int64    // largest response body accepted, defaults to DefaultFetchMaxBytes
+	Timeout  int      // milliseconds to wait for a response, defaults to DefaultFetchTimeout
+}
+
+// Check confirms that the policy allows some hosts
+func (p *HTTPFetchPolicy) Check() (err error) {
+	if len(p.Hosts) == 0 {
+		err = errors.New("http fetch declared without any allowed hosts")
+	}
+	return
+}
+
+// allows returns true if the policy allows fetching from host
+func (p *HTTPFetchPolicy) allows(host string) bool {
+	for _, h := range p.Hosts {
+		if h == host || strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpFetch gets the body of an http(s) url that the DNA's fetch policy allows
+func (h *Holochain) httpFetch(u string, validating bool) (body string, err error) {
+	if validating {
+		err = ErrFetchInValidation
+		return
+	}
+	p := h.HTTPFetch
+	if p == nil {
+		err = ErrFetchNotDeclared
+		return
+	}
+	var parsed *url.URL
+	parsed, err = url.Parse(u)
+	if err != nil {
+		return
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		err = fmt.Errorf("fetch only supports http and https urls, got: %s", u)
+		return
+	}
+	host := parsed.Host
+	if hp, _, e := net.SplitHostPort(host); e == nil {
+		host = hp
+	}
+	if !p.allows(host) {
+		err = fmt.Errorf("fetch from %s not allowed by DNA", host)
+		return
+	}
+	max := p.MaxBytes
+	if max <= 0 {
+		max = DefaultFetchMaxBytes
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+	h.metrics.Inc("fetch.calls", 1)
+	client := http.Client{
+		Timeout: time.Duration(timeout) * time.Millisecond,
+		// redirects must stay on hosts the policy allows too
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("fetch redirected to unsupported url: %s", req.URL)
+			}
+			if rh := req.URL.Hostname(); !p.allows(rh) {
+				return fmt.Errorf("fetch redirect to %s not allowed by DNA", rh)
+			}
+			return nil
+		},
+	}
+	var resp *http.Response
+	resp, err = client.Get(u)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("fetching %s: %s", u, resp.Status)
+		return
+	}
+	var b []byte
+	b, err = ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return
+	}
+	if int64(len(b)) > max {
+		err = fmt.Errorf("fetch response from %s is larger than %d bytes", host, max)
+		return
+	}
+	body = string(b)
+	return
+}
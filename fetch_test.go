@@ -0,0 +1,81 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPFetchPolicy(t *testing.T) {
+	Convey("it should require some hosts", t, func() {
+		p := HTTPFetchPolicy{}
+		So(p.Check().Error(), ShouldEqual, "http fetch declared without any allowed hosts")
+		p.Hosts = []string{"example.com"}
+		So(p.Check(), ShouldBeNil)
+	})
+	Convey("it should match hosts and wildcard subdomains", t, func() {
+		p := HTTPFetchPolicy{Hosts: []string{"example.com", "*.oracle.org"}}
+		So(p.allows("example.com"), ShouldBeTrue)
+		So(p.allows("www.example.com"), ShouldBeFalse)
+		So(p.allows("prices.oracle.org"), ShouldBeTrue)
+		So(p.allows("oracle.org"), ShouldBeFalse)
+		So(p.allows("evil.org"), ShouldBeFalse)
+	})
+}
+
+func TestHTTPFetch(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/big" {
+			fmt.Fprint(w, strings.Repeat("x", 100))
+			return
+		}
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, "http://localhost:1234/", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "42")
+	}))
+	defer ts.Close()
+
+	Convey("it should refuse if the DNA doesn't declare fetch", t, func() {
+		_, err := h.httpFetch(ts.URL, false)
+		So(err, ShouldEqual, ErrFetchNotDeclared)
+	})
+
+	h.HTTPFetch = &HTTPFetchPolicy{Hosts: []string{"127.0.0.1"}, MaxBytes: 50}
+	defer func() { h.HTTPFetch = nil }()
+
+	Convey("it should refuse during validation", t, func() {
+		_, err := h.httpFetch(ts.URL, true)
+		So(err, ShouldEqual, ErrFetchInValidation)
+	})
+
+	Convey("it should refuse hosts and schemes not allowed", t, func() {
+		_, err := h.httpFetch("http://localhost:1234/", false)
+		So(err.Error(), ShouldEqual, "fetch from localhost not allowed by DNA")
+		_, err = h.httpFetch("file:///etc/passwd", false)
+		So(err.Error(), ShouldEqual, "fetch only supports http and https urls, got: file:///etc/passwd")
+	})
+
+	Convey("it should refuse redirects to hosts not allowed", t, func() {
+		_, err := h.httpFetch(ts.URL+"/away", false)
+		So(err.Error(), ShouldContainSubstring, "fetch redirect to localhost not allowed by DNA")
+	})
+
+	Convey("it should limit the size of responses", t, func() {
+		_, err := h.httpFetch(ts.URL+"/big", false)
+		So(err.Error(), ShouldEqual, "fetch response from 127.0.0.1 is larger than 50 bytes")
+	})
+
+	Convey("it should fetch from allowed hosts", t, func() {
+		body, err := h.httpFetch(ts.URL, false)
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "42")
+	})
+}
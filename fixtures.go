@@ -0,0 +1,114 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// fixtures implements bulk loading of entries, e.g. demo data, from a directory of JSON files.
+// Each file holds an array of entries of the type it is named after, optionally preceded by a
+// number to set the order files are loaded in, e.g. 01-profile.json.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fixture is an entry to be loaded from a fixture file
+type Fixture struct {
+	File      string
+	Index     int // position of the entry in its file, from 1
+	EntryType string
+	Content   string
+}
+
+// fixtureEntryType returns the entry type a fixture file holds, which is its name without any
+// ordering prefix
+func fixtureEntryType(file string) string {
+	t := strings.TrimSuffix(filepath.Base(file), ".json")
+	if i := strings.IndexAny(t, "-_"); i > 0 && strings.Trim(t[:i], "0123456789") == "" {
+		t = t[i+1:]
+	}
+	return t
+}
+
+// ReadFixtures reads the entries in the JSON files in a directory and its subdirectories, in
+// the order they are to be loaded
+func (h *Holochain) ReadFixtures(dir string) (fixtures []Fixture, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		entryType := fixtureEntryType(path)
+		_, d, err := h.GetEntryDef(entryType)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var entries []json.RawMessage
+		if err = json.Unmarshal(b, &entries); err != nil {
+			return fmt.Errorf("%s: expected an array of entries: %v", path, err)
+		}
+		for i, e := range entries {
+			var content string
+			if d.DataFormat == DataFormatJSON || json.Unmarshal(e, &content) != nil {
+				var c bytes.Buffer
+				if err = json.Compact(&c, e); err != nil {
+					return err
+				}
+				content = c.String()
+			}
+			fixtures = append(fixtures, Fixture{File: path, Index: i + 1, EntryType: entryType, Content: content})
+		}
+		return nil
+	})
+	return
+}
+
+// LoadFixtures validates, commits and publishes the entries in a directory of fixture files,
+// returning the number loaded.  Puts that can't be sent stay in the outbox to be published
+// later.  With dryRun the entries are only validated, so an entry whose validity depends on
+// entries loaded before it may pass a dry run but fail a real one.
+func (h *Holochain) LoadFixtures(dir string, dryRun bool) (loaded int, err error) {
+	if !h.Started() {
+		err = ErrChainNotStarted
+		return
+	}
+	var fixtures []Fixture
+	if fixtures, err = h.ReadFixtures(dir); err != nil {
+		return
+	}
+	stage := "committing"
+	if dryRun {
+		stage = "validating"
+	}
+	for i, f := range fixtures {
+		h.progress(ProgressLoad, stage+" "+f.EntryType, i, len(fixtures))
+		if dryRun {
+			p := ValidationProps{Sources: []string{peer.IDB58Encode(h.id)}}
+			err = h.ValidateEntry(f.EntryType, &GobEntry{C: f.Content}, &p)
+		} else {
+			var hash Hash
+			if hash, err = h.Commit(f.EntryType, f.Content); err == nil {
+				if e := h.dht.SendPut(hash); e != nil {
+					h.dht.dlog.Logf("%s: entry %d left in outbox: %v", f.File, f.Index, e)
+				}
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: entry %d: %v", f.File, f.Index, err)
+			return
+		}
+		loaded++
+	}
+	h.progress(ProgressLoad, stage+" done", len(fixtures), len(fixtures))
+	return
+}
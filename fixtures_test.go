@@ -0,0 +1,73 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dir := filepath.Join(d, "fixtures")
+	os.MkdirAll(filepath.Join(dir, "more"), os.ModePerm)
+	writeFile(dir, "01-myData.json", []byte(`[2, 4]`))
+	writeFile(dir, "02_profile.json", []byte(`[{"firstName":"Art","lastName":"Brock"}]`))
+	writeFile(filepath.Join(dir, "more"), "myData.json", []byte(`["6"]`))
+	writeFile(dir, "README.md", []byte(`not a fixture`))
+
+	Convey("it should name entry types after fixture files", t, func() {
+		So(fixtureEntryType("/x/01-myData.json"), ShouldEqual, "myData")
+		So(fixtureEntryType("02_profile.json"), ShouldEqual, "profile")
+		So(fixtureEntryType("my-data.json"), ShouldEqual, "my-data")
+	})
+
+	Convey("it should read fixtures in order", t, func() {
+		f, err := h.ReadFixtures(dir)
+		So(err, ShouldBeNil)
+		So(len(f), ShouldEqual, 4)
+		So(f[0], ShouldResemble, Fixture{File: filepath.Join(dir, "01-myData.json"), Index: 1, EntryType: "myData", Content: "2"})
+		So(f[2].Content, ShouldEqual, `{"firstName":"Art","lastName":"Brock"}`)
+		So(f[3].Content, ShouldEqual, "6")
+	})
+
+	Convey("a dry run should validate without committing", t, func() {
+		l := h.chain.Length()
+		n, err := h.LoadFixtures(dir, true)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 4)
+		So(h.chain.Length(), ShouldEqual, l)
+	})
+
+	Convey("it should commit and publish fixtures and report progress", t, func() {
+		var last Progress
+		h.SetProgress(func(p Progress) { last = p })
+		l := h.chain.Length()
+		n, err := h.LoadFixtures(dir, false)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 4)
+		So(h.chain.Length(), ShouldEqual, l+4)
+		pubs, err := h.dht.Publications(false)
+		So(err, ShouldBeNil)
+		published := make(map[string]bool)
+		for _, p := range pubs {
+			published[p.Put.H.String()] = true
+		}
+		f, _ := h.ReadFixtures(dir)
+		for _, x := range f {
+			e := GobEntry{C: x.Content}
+			hash, _ := e.Sum(h.hashSpec)
+			So(published[hash.String()], ShouldBeTrue)
+		}
+		So(last.Op, ShouldEqual, ProgressLoad)
+		So(last.Finished(), ShouldBeTrue)
+	})
+
+	Convey("it should stop at the first invalid entry", t, func() {
+		writeFile(dir, "03-myData.json", []byte(`[8, 5]`))
+		n, err := h.LoadFixtures(dir, true)
+		So(n, ShouldEqual, 4)
+		So(err.Error(), ShouldEqual, filepath.Join(dir, "03-myData.json")+": entry 2: Invalid entry: 5")
+	})
+}
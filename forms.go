@@ -0,0 +1,199 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// forms implements describing forms for committing entries, generated from the JSON schemas
+// of their entry types, so that an app without a UI of its own can still be used from a
+// browser while it is being prototyped.  Entry types without a schema get a single field
+// holding the whole of the entry's content.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentField is the name of the field holding the whole content of entries whose type has
+// no schema to generate fields from
+const ContentField = "content"
+
+var ErrNoForm error = errors.New("entries of this type can't be committed from a form")
+
+// FormField describes an input of an entry form
+type FormField struct {
+	Name        string
+	Label       string
+	Description string
+	Type        string // the schema type: string, integer, number, boolean, or object or array entered as json
+	Required    bool
+	Enum        []string // if set the values the field may take
+}
+
+// EntryForm describes a form for committing entries of a type
+type EntryForm struct {
+	EntryType   string
+	Title       string
+	Description string
+	Fields      []FormField
+	json        bool // the entries are json, so the fields are the properties of an object
+}
+
+// formSchema is the part of a JSON schema that forms are generated from
+type formSchema struct {
+	Title       string
+	Description string
+	Properties  map[string]struct {
+		Title       string
+		Description string
+		Type        interface{}
+		Enum        []interface{}
+	}
+	Required []string
+}
+
+// EntryForm returns a form for committing entries of the given type
+func (h *Holochain) EntryForm(entryType string) (f *EntryForm, err error) {
+	var d *EntryDef
+	if _, d, err = h.GetEntryDef(entryType); err != nil {
+		return
+	}
+	form := EntryForm{EntryType: entryType, Title: entryType}
+	switch d.DataFormat {
+	case DataFormatString:
+		form.Fields = []FormField{{Name: ContentField, Label: ContentField, Type: StringType, Required: true}}
+	case DataFormatJSON:
+		if !strings.HasSuffix(d.Schema, ".json") {
+			form.Fields = []FormField{{Name: ContentField, Label: ContentField, Type: "object", Required: true}}
+			break
+		}
+		form.json = true
+		var b []byte
+		if b, err = readFile(h.path, d.Schema); err != nil {
+			return
+		}
+		var s formSchema
+		if err = json.Unmarshal(b, &s); err != nil {
+			err = fmt.Errorf("bad schema %s: %v", d.Schema, err)
+			return
+		}
+		if s.Title != "" {
+			form.Title = s.Title
+		}
+		form.Description = s.Description
+		required := make(map[string]bool)
+		for _, name := range s.Required {
+			required[name] = true
+		}
+		for name, p := range s.Properties {
+			field := FormField{Name: name, Label: name, Description: p.Description, Type: StringType, Required: required[name]}
+			if p.Title != "" {
+				field.Label = p.Title
+			}
+			if t, ok := p.Type.(string); ok {
+				field.Type = t
+			}
+			for _, v := range p.Enum {
+				field.Enum = append(field.Enum, fmt.Sprintf("%v", v))
+			}
+			form.Fields = append(form.Fields, field)
+		}
+		sort.Sort(formFields(form.Fields))
+	default:
+		err = ErrNoForm
+		return
+	}
+	f = &form
+	return
+}
+
+// formFields sorts fields with the required ones first and then by name
+type formFields []FormField
+
+func (p formFields) Len() int      { return len(p) }
+func (p formFields) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p formFields) Less(i, j int) bool {
+	if p[i].Required != p[j].Required {
+		return p[i].Required
+	}
+	return p[i].Name < p[j].Name
+}
+
+// EntryForms returns forms for all the entry types that can be committed from one, ordered
+// by entry type
+func (h *Holochain) EntryForms() (forms []*EntryForm, err error) {
+	var types []string
+	seen := make(map[string]bool)
+	for _, z := range h.Zomes {
+		for t := range z.Entries {
+			if !seen[t] {
+				seen[t] = true
+				types = append(types, t)
+			}
+		}
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		f, e := h.EntryForm(t)
+		if e == ErrNoForm {
+			continue
+		}
+		if e != nil {
+			err = e
+			return
+		}
+		forms = append(forms, f)
+	}
+	return
+}
+
+// Content builds the content of an entry from the values posted from the form
+func (f *EntryForm) Content(values url.Values) (content string, err error) {
+	if !f.json {
+		content = values.Get(ContentField)
+		if content == "" {
+			err = fmt.Errorf("%s is required", ContentField)
+		}
+		return
+	}
+	obj := make(map[string]interface{})
+	for _, field := range f.Fields {
+		s := values.Get(field.Name)
+		if s == "" && field.Type != "boolean" {
+			if field.Required {
+				err = fmt.Errorf("%s is required", field.Label)
+				return
+			}
+			continue
+		}
+		var v interface{}
+		switch field.Type {
+		case "integer":
+			v, err = strconv.ParseInt(s, 10, 64)
+		case "number":
+			v, err = strconv.ParseFloat(s, 64)
+		case "boolean":
+			v = s != "" && s != "false"
+		case "object", "array":
+			err = json.Unmarshal([]byte(s), &v)
+		default:
+			v = s
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", field.Label, err)
+			return
+		}
+		obj[field.Name] = v
+	}
+	var b []byte
+	if b, err = json.Marshal(obj); err != nil {
+		return
+	}
+	content = string(b)
+	return
+}
@@ -0,0 +1,60 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"testing"
+)
+
+func TestEntryForm(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should generate fields from the entry type's schema", t, func() {
+		f, err := h.EntryForm("profile")
+		So(err, ShouldBeNil)
+		So(f.Title, ShouldEqual, "Profile Schema")
+		So(f.Fields, ShouldResemble, []FormField{
+			{Name: "firstName", Label: "firstName", Type: "string", Required: true},
+			{Name: "lastName", Label: "lastName", Type: "string", Required: true},
+			{Name: "age", Label: "age", Description: "Age in years", Type: "integer"},
+		})
+	})
+
+	Convey("it should give entry types without a schema a single content field", t, func() {
+		f, err := h.EntryForm("primes")
+		So(err, ShouldBeNil)
+		So(f.Fields, ShouldResemble, []FormField{{Name: ContentField, Label: ContentField, Type: "object", Required: true}})
+		content, err := f.Content(url.Values{ContentField: {"[2,3,5]"}})
+		So(err, ShouldBeNil)
+		So(content, ShouldEqual, "[2,3,5]")
+	})
+
+	Convey("it should not generate forms for raw code entry types", t, func() {
+		_, err := h.EntryForm("myData")
+		So(err, ShouldEqual, ErrNoForm)
+		forms, err := h.EntryForms()
+		So(err, ShouldBeNil)
+		So(len(forms), ShouldEqual, 2)
+		So(forms[0].EntryType, ShouldEqual, "primes")
+		So(forms[1].EntryType, ShouldEqual, "profile")
+	})
+
+	Convey("it should build entry content from posted values", t, func() {
+		f, _ := h.EntryForm("profile")
+		content, err := f.Content(url.Values{"firstName": {"Eric"}, "lastName": {"Harris-Braun"}, "age": {"42"}})
+		So(err, ShouldBeNil)
+		So(content, ShouldEqual, `{"age":42,"firstName":"Eric","lastName":"Harris-Braun"}`)
+
+		_, err = f.Content(url.Values{"firstName": {"Eric"}})
+		So(err.Error(), ShouldEqual, "lastName is required")
+
+		_, err = f.Content(url.Values{"firstName": {"Eric"}, "lastName": {"H"}, "age": {"old"}})
+		So(err.Error(), ShouldStartWith, "age: ")
+
+		content, _ = f.Content(url.Values{"firstName": {"Eric"}, "lastName": {"H"}})
+		hash, err := h.Commit("profile", content)
+		So(err, ShouldBeNil)
+		So(hash.String(), ShouldNotEqual, "")
+	})
+}
@@ -0,0 +1,262 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// function implements DNA declarations of the argument and return types of exposed functions
+// and the checking and marshaling of values to and from those types
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	StringType = "string"
+	JSONType   = "json"
+	HashType   = "hash"
+	IntType    = "int"
+
+	MaxBatchCalls = 100 // largest number of calls a batch may hold
+)
+
+var ErrBatchTooLarge error = errors.New("too many calls in batch")
+
+// FunctionDef declares the argument and return types of an exposed zome function
+type FunctionDef struct {
+	Name        string
+	Description string
+	Arg         string // type of the argument: string, json, hash or int
+	Returns     string // type of the return value: string, json, hash or int
+	ReadOnly    bool   // the function doesn't commit or put, so it's safe to call concurrently
+	MaxArgSize  int    `json:",omitempty" toml:",omitempty"` // largest argument the function may be called with in bytes, DefaultMaxArgSize if not set
+}
+
+// FunctionSchema describes an exposed function for clients
+type FunctionSchema struct {
+	Name        string
+	Description string `json:",omitempty"`
+	CallingType string // how the nucleus receives the argument: string or json
+	Arg         string `json:",omitempty"`
+	Returns     string `json:",omitempty"`
+	ReadOnly    bool   `json:",omitempty"`
+	MaxArgSize  int    // largest argument the function may be called with in bytes
+}
+
+// GetFunctionDef returns the declaration of a function, or nil if it wasn't declared
+func (z *Zome) GetFunctionDef(name string) *FunctionDef {
+	for i := range z.Functions {
+		if z.Functions[i].Name == name {
+			return &z.Functions[i]
+		}
+	}
+	return nil
+}
+
+func checkTypeName(t string) (err error) {
+	switch t {
+	case "", StringType, JSONType, HashType, IntType:
+	default:
+		err = fmt.Errorf("unknown type: %s, must be one of: %s", t, strings.Join([]string{StringType, JSONType, HashType, IntType}, ", "))
+	}
+	return
+}
+
+// Check confirms that the declared types of the function are known
+func (f *FunctionDef) Check() (err error) {
+	if err = checkTypeName(f.Arg); err != nil {
+		return
+	}
+	if err = checkTypeName(f.Returns); err != nil {
+		return
+	}
+	if f.MaxArgSize < 0 {
+		err = errors.New("max argument size must not be negative")
+	}
+	return
+}
+
+// marshalValue checks that a value is of the given type and converts it to the string
+// representation that nucleii receive
+func marshalValue(t string, value interface{}) (s string, err error) {
+	if t == JSONType {
+		if str, ok := value.(string); ok {
+			var v interface{}
+			if err = json.Unmarshal([]byte(str), &v); err != nil {
+				err = fmt.Errorf("expected json: %v", err)
+				return
+			}
+			s = str
+		} else if b, ok := value.([]byte); ok {
+			s, err = marshalValue(t, string(b))
+		} else {
+			var b []byte
+			b, err = json.Marshal(value)
+			s = string(b)
+		}
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprintf("%v", v)
+	}
+	switch t {
+	case IntType:
+		_, err = strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			err = fmt.Errorf("expected int, got: %s", s)
+		} else {
+			s = strings.TrimSpace(s)
+		}
+	case HashType:
+		_, err = NewHash(strings.Trim(s, "\""))
+		if err != nil {
+			err = fmt.Errorf("expected hash, got: %s", s)
+		}
+	}
+	return
+}
+
+// marshalArg checks and converts an argument being passed to a declared function
+func (f *FunctionDef) marshalArg(arg interface{}) (s string, err error) {
+	s, err = marshalValue(f.Arg, arg)
+	if err != nil {
+		err = fmt.Errorf("argument to %s: %v", f.Name, err)
+	}
+	return
+}
+
+// checkResult checks that the result of calling a declared function matches its return type
+func (f *FunctionDef) checkResult(result interface{}) (err error) {
+	if f.Returns == "" || f.Returns == StringType {
+		return
+	}
+	_, err = marshalValue(f.Returns, result)
+	if err != nil {
+		err = fmt.Errorf("result of %s: %v", f.Name, err)
+	}
+	return
+}
+
+// FunctionSchemas returns a description of the exposed functions of all zomes, keyed by zome name
+func (h *Holochain) FunctionSchemas() (schemas map[string][]FunctionSchema, err error) {
+	schemas = make(map[string][]FunctionSchema)
+	for name, z := range h.Zomes {
+		var n Nucleus
+		n, err = h.makeNucleus(z)
+		if err != nil {
+			return
+		}
+		fns := make([]FunctionSchema, 0)
+		for _, i := range n.Interfaces() {
+			s := FunctionSchema{Name: i.Name, CallingType: StringType}
+			if i.Schema == JSON {
+				s.CallingType = JSONType
+			}
+			if f := z.GetFunctionDef(i.Name); f != nil {
+				s.Description = f.Description
+				s.Arg = f.Arg
+				s.Returns = f.Returns
+				s.ReadOnly = f.ReadOnly
+			}
+			s.MaxArgSize = h.MaxArgSize(name, i.Name)
+			fns = append(fns, s)
+		}
+		schemas[name] = fns
+	}
+	fns := make([]FunctionSchema, 0)
+	for _, f := range SystemFunctions() {
+		fns = append(fns, FunctionSchema{Name: f.Name, Description: f.Description, CallingType: StringType, Arg: f.Arg, Returns: f.Returns, ReadOnly: f.ReadOnly, MaxArgSize: DefaultMaxArgSize})
+	}
+	schemas[SystemZomeName] = fns
+	return
+}
+
+// BatchCall holds one of the calls in a batch
+type BatchCall struct {
+	Zome           string
+	Fn             string
+	Arg            interface{}
+	IdempotencyKey string `json:",omitempty"` // if set, a retry of the call returns the first result
+}
+
+// BatchResult holds the result, or the error, of one of the calls in a batch
+type BatchResult struct {
+	Result interface{} `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+// readOnly returns true if the call is to a function declared as read only
+func (h *Holochain) readOnly(c *BatchCall) bool {
+	if c.Zome == SystemZomeName {
+		return systemFunctions[c.Fn].ReadOnly
+	}
+	if z, ok := h.Zomes[c.Zome]; ok {
+		if f := z.GetFunctionDef(c.Fn); f != nil {
+			return f.ReadOnly
+		}
+	}
+	return false
+}
+
+// batchWorkers returns how many read only calls of a batch may run at once, which is kept
+// below the concurrent call quota so that a batch doesn't use it all up and fail its own calls
+func (h *Holochain) batchWorkers() int {
+	n := h.Config().Quotas.MaxConcurrentCalls
+	if n <= 0 {
+		n = DefaultMaxConcurrentCalls
+	}
+	if n = n / 2; n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// CallBatch makes a list of calls returning their results in the same order.  Calls are made
+// in order, except that consecutive calls to read only functions are made concurrently by a
+// pool of workers.
+func (h *Holochain) CallBatch(calls []BatchCall) (results []BatchResult, err error) {
+	if len(calls) > MaxBatchCalls {
+		err = ErrBatchTooLarge
+		return
+	}
+	results = make([]BatchResult, len(calls))
+	call := func(i int) {
+		r, _, err := h.CallIdempotent(calls[i].IdempotencyKey, calls[i].Zome, calls[i].Fn, calls[i].Arg)
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		if b, ok := r.([]byte); ok {
+			r = string(b)
+		}
+		results[i].Result = r
+	}
+	var wg sync.WaitGroup
+	pool := make(chan bool, h.batchWorkers())
+	for i := range calls {
+		if h.readOnly(&calls[i]) {
+			pool <- true
+			wg.Add(1)
+			go func(i int) {
+				defer func() { <-pool; wg.Done() }()
+				call(i)
+			}(i)
+			continue
+		}
+		wg.Wait()
+		call(i)
+	}
+	wg.Wait()
+	return
+}
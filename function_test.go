@@ -0,0 +1,129 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestFunctionDef(t *testing.T) {
+	Convey("it should check declared types", t, func() {
+		f := FunctionDef{Name: "fn", Arg: IntType, Returns: HashType}
+		So(f.Check(), ShouldBeNil)
+		f.Returns = "float"
+		So(f.Check().Error(), ShouldEqual, "unknown type: float, must be one of: string, json, hash, int")
+	})
+
+	Convey("it should check and marshal arguments", t, func() {
+		f := FunctionDef{Name: "fn", Arg: IntType}
+		a, err := f.marshalArg(" 42")
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, "42")
+		_, err = f.marshalArg("fish")
+		So(err.Error(), ShouldEqual, "argument to fn: expected int, got: fish")
+
+		f.Arg = JSONType
+		a, err = f.marshalArg(map[string]int{"prime": 7})
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, `{"prime":7}`)
+		a, err = f.marshalArg(`{"prime":7}`)
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, `{"prime":7}`)
+		_, err = f.marshalArg(`{"prime":`)
+		So(err, ShouldNotBeNil)
+
+		f.Arg = HashType
+		a, err = f.marshalArg("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err = f.marshalArg("fish")
+		So(err.Error(), ShouldEqual, "argument to fn: expected hash, got: fish")
+	})
+
+	Convey("it should check results", t, func() {
+		f := FunctionDef{Name: "fn", Returns: JSONType}
+		So(f.checkResult([]byte(`"QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"`)), ShouldBeNil)
+		So(f.checkResult("fish").Error(), ShouldStartWith, "result of fn: expected json")
+		f.Returns = HashType
+		So(f.checkResult(`"QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"`), ShouldBeNil)
+		f.Returns = StringType
+		So(f.checkResult(`anything`), ShouldBeNil)
+	})
+}
+
+func TestCallDeclaredFunctions(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should reject arguments not of the declared type", t, func() {
+		_, err := h.Call("myZome", "addData", "fish")
+		So(err.Error(), ShouldEqual, "argument to addData: expected int, got: fish")
+	})
+
+	Convey("it should marshal non string arguments", t, func() {
+		result, err := h.Call("myZome", "addPrime", map[string]int{"prime": 7})
+		So(err, ShouldBeNil)
+		ph := h.chain.Top().EntryLink
+		So(string(result.([]byte)), ShouldEqual, `"`+ph.String()+`"`)
+	})
+
+	Convey("it should describe the exposed functions", t, func() {
+		schemas, err := h.FunctionSchemas()
+		So(err, ShouldBeNil)
+		var found bool
+		for _, s := range schemas["myZome"] {
+			if s.Name == "addPrime" {
+				found = true
+				So(s.CallingType, ShouldEqual, JSONType)
+				So(s.Arg, ShouldEqual, JSONType)
+				So(s.Returns, ShouldEqual, JSONType)
+			}
+		}
+		So(found, ShouldBeTrue)
+	})
+}
+
+func TestCallBatch(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should know which calls are read only", t, func() {
+		So(h.readOnly(&BatchCall{Zome: "myZome", Fn: "getDNA"}), ShouldBeTrue)
+		So(h.readOnly(&BatchCall{Zome: "myZome", Fn: "addData"}), ShouldBeFalse)
+		So(h.readOnly(&BatchCall{Zome: "fishZome", Fn: "getDNA"}), ShouldBeFalse)
+	})
+
+	Convey("it should return the results of the calls in order", t, func() {
+		results, err := h.CallBatch([]BatchCall{
+			{Zome: "myZome", Fn: "getDNA", Arg: ""},
+			{Zome: "myZome", Fn: "addData", Arg: "2"},
+			{Zome: "myZome", Fn: "exposedfn", Arg: "fish"},
+			{Zome: "myZome", Fn: "addData", Arg: "fish"},
+			{Zome: "jsZome", Fn: "getProperty", Arg: "language"},
+		})
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 5)
+		So(results[0].Result, ShouldEqual, h.DNAHash().String())
+		So(results[1].Error, ShouldEqual, "")
+		So(results[1].Result, ShouldEqual, h.chain.Top().EntryLink.String())
+		So(results[2].Result, ShouldEqual, "result: fish")
+		So(results[3].Error, ShouldEqual, "argument to addData: expected int, got: fish")
+		So(results[4].Result, ShouldEqual, "en")
+	})
+
+	Convey("read only calls shouldn't exceed the concurrent call quota", t, func() {
+		calls := make([]BatchCall, 4*DefaultMaxConcurrentCalls)
+		for i := range calls {
+			calls[i] = BatchCall{Zome: "myZome", Fn: "getDNA", Arg: ""}
+		}
+		results, err := h.CallBatch(calls)
+		So(err, ShouldBeNil)
+		for _, r := range results {
+			So(r.Error, ShouldEqual, "")
+		}
+	})
+
+	Convey("it should refuse batches that are too large", t, func() {
+		_, err := h.CallBatch(make([]BatchCall, MaxBatchCalls+1))
+		So(StatusCode(err), ShouldEqual, 413)
+	})
+}
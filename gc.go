@@ -0,0 +1,288 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// gc implements garbage collection of DHT data that is no longer of use: entries that were
+// rejected or retracted, entries put by peers that have since been banned, expiry records of
+// long expired entries, and links and holder records left without the entry they belong to.
+// Data found to be garbage is only purged once it has stayed garbage for the retention period,
+// which gives a chance to recover from mistakes such as a mistaken ban.
+
+package holochain
+
+import (
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultGCRetention = "24h"
+	DefaultGCInterval  = 10 * time.Minute
+)
+
+// GCReport describes what a garbage collection pass purged
+type GCReport struct {
+	Entries   int // rejected, retracted and banned source entries
+	Metas     int // links whose entry is gone
+	Holders   int // holder records whose entry is gone
+	Expired   int // expiry records of long expired entries
+	Reclaimed int // bytes of entry and link data freed
+	PeerAddrs int // addresses of peers long banned or unreachable
+}
+
+// GCRetentionDuration returns how long garbage is kept before being purged
+func (config *Config) GCRetentionDuration() (retention time.Duration, err error) {
+	r := config.GCRetention
+	if r == "" {
+		r = DefaultGCRetention
+	}
+	retention, err = time.ParseDuration(r)
+	if err == nil && retention < 0 {
+		err = fmt.Errorf("gc retention can't be negative, got: %s", r)
+	}
+	return
+}
+
+// garbageEntry returns true if the entry at k should be collected, which pinned entries never are
+func (dht *DHT) garbageEntry(tx *buntdb.Tx, k string) bool {
+	if isPinned(tx, k) {
+		return false
+	}
+	// tombstones of redacted entries are kept so that the entries aren't accepted again, and
+	// quarantined entries until they are reviewed
+	status, err := tx.Get("status:" + k)
+	if err == nil && status != fmt.Sprintf("%d", LIVE) && status != fmt.Sprintf("%d", REDACTED) && status != fmt.Sprintf("%d", QUARANTINED) {
+		return true
+	}
+	src, err := tx.Get("src:" + k)
+	if err != nil {
+		return false
+	}
+	id, err := peer.IDB58Decode(src)
+	if err != nil || id == dht.h.id {
+		return false
+	}
+	r, err := getPeerRecord(tx, id)
+	return err == nil && r.Banned()
+}
+
+// condemned returns true if key has been garbage for the retention period, marking it as
+// garbage if it hasn't been seen as such before
+func condemned(tx *buntdb.Tx, key string, now time.Time, retention time.Duration, marks map[string]bool) (bool, error) {
+	marks["gc:"+key] = true
+	val, err := tx.Get("gc:" + key)
+	if err == buntdb.ErrNotFound {
+		_, _, err = tx.Set("gc:"+key, fmt.Sprintf("%d", now.UnixNano()), nil)
+		return retention == 0, err
+	}
+	if err != nil {
+		return false, err
+	}
+	t, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(time.Unix(0, t)) >= retention, nil
+}
+
+// deleteKeys deletes keys from the store, returning the bytes of the values freed
+func deleteKeys(tx *buntdb.Tx, keys ...string) (freed int, err error) {
+	for _, key := range keys {
+		var val string
+		val, err = tx.Delete(key)
+		if err == buntdb.ErrNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		freed += len(val)
+	}
+	return
+}
+
+// deleteMetas deletes the meta records on the entry with hash k, and those that have it as
+// their meta, returning how many bytes were freed
+func deleteMetas(tx *buntdb.Tx, k string) (freed int, err error) {
+	var keys []string
+	tx.Ascend("meta", func(key, value string) bool {
+		x := strings.SplitN(key, ":", 4)
+		if len(x) == 4 && (x[1] == k || x[2] == k) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return deleteKeys(tx, keys...)
+}
+
+// CollectGarbage purges DHT data that has been garbage for at least the retention period.
+// Purged entries keep their status record so that they aren't accepted again.
+func (dht *DHT) CollectGarbage(retention time.Duration) (report GCReport, err error) {
+	now := time.Now()
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		marks := make(map[string]bool)
+		exists := func(k string) bool {
+			_, err := tx.Get("entry:" + k)
+			return err == nil || isPinned(tx, k)
+		}
+
+		var keys []string
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			keys = append(keys, strings.TrimPrefix(key, "entry:"))
+			return true
+		})
+		for _, k := range keys {
+			if !dht.garbageEntry(tx, k) {
+				continue
+			}
+			ok, err := condemned(tx, "entry:"+k, now, retention, marks)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			val, err := tx.Get("entry:" + k)
+			if err != nil {
+				return err
+			}
+			if status, _ := tx.Get("status:" + k); status == fmt.Sprintf("%d", LIVE) {
+				if _, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", REJECTED), nil); err != nil {
+					return err
+				}
+			}
+			var holders []string
+			tx.AscendKeys("holder:"+k+":*", func(key, value string) bool {
+				holders = append(holders, key)
+				return true
+			})
+			if _, err = deleteKeys(tx, append(holders, "entry:"+k, "type:"+k, "src:"+k, "expires:"+k, "recv:"+k)...); err != nil {
+				return err
+			}
+			report.Entries++
+			report.Holders += len(holders)
+			report.Reclaimed += len(val)
+		}
+
+		var metas, holders []string
+		tx.AscendKeys("meta:*", func(key, value string) bool {
+			if !exists(strings.SplitN(key, ":", 3)[1]) {
+				metas = append(metas, key)
+			}
+			return true
+		})
+		tx.AscendKeys("holder:*", func(key, value string) bool {
+			if !exists(strings.SplitN(key, ":", 3)[1]) {
+				holders = append(holders, key)
+			}
+			return true
+		})
+		for _, key := range metas {
+			ok, err := condemned(tx, key, now, retention, marks)
+			if err != nil {
+				return err
+			}
+			if ok {
+				freed, err := deleteKeys(tx, key)
+				if err != nil {
+					return err
+				}
+				report.Metas++
+				report.Reclaimed += freed
+			}
+		}
+		for _, key := range holders {
+			ok, err := condemned(tx, key, now, retention, marks)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if _, err = deleteKeys(tx, key); err != nil {
+					return err
+				}
+				report.Holders++
+			}
+		}
+
+		var expired []string
+		tx.AscendKeys("expires:*", func(key, value string) bool {
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && now.Sub(time.Unix(0, t)) >= retention && !exists(strings.TrimPrefix(key, "expires:")) {
+				expired = append(expired, key)
+			}
+			return true
+		})
+		if _, err := deleteKeys(tx, expired...); err != nil {
+			return err
+		}
+		report.Expired = len(expired)
+
+		pruned, err := prunePeerAddrs(tx, now)
+		if err != nil {
+			return err
+		}
+		report.PeerAddrs = pruned
+
+		// forget marks on data that is no longer garbage, or that has been purged
+		var stale []string
+		tx.AscendKeys("gc:*", func(key, value string) bool {
+			if !marks[key] || gone(tx, strings.TrimPrefix(key, "gc:")) {
+				stale = append(stale, key)
+			}
+			return true
+		})
+		if _, err := deleteKeys(tx, stale...); err != nil {
+			return err
+		}
+		if report.Reclaimed > 0 {
+			return dht.incSize(tx, -report.Reclaimed)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	n := report.Entries + report.Metas + report.Holders + report.Expired
+	if n > 0 {
+		dht.dlog.Logf("gc purged %d items, reclaiming %d bytes", n, report.Reclaimed)
+		dht.h.metrics.Inc("dht.gc.purged", int64(n))
+		dht.h.metrics.Inc("dht.gc.reclaimed", int64(report.Reclaimed))
+	}
+	return
+}
+
+// gone returns true if the key a gc mark refers to no longer exists
+func gone(tx *buntdb.Tx, key string) bool {
+	_, err := tx.Get(key)
+	return err == buntdb.ErrNotFound
+}
+
+// CollectGarbage runs a garbage collection pass over the DHT store, purging data that has been
+// garbage for the given retention, or for the chain's configured retention if it is empty
+func (h *Holochain) CollectGarbage(retention string) (report GCReport, err error) {
+	config := h.Config()
+	if retention != "" {
+		config.GCRetention = retention
+	}
+	var r time.Duration
+	if r, err = config.GCRetentionDuration(); err != nil {
+		return
+	}
+	report, err = h.dht.CollectGarbage(r)
+	return
+}
+
+// CollectGarbageEvery runs a garbage collection pass every interval
+func (dht *DHT) CollectGarbageEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := dht.h.CollectGarbage(""); err != nil {
+			dht.dlog.Logf("gc error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func dhtSize(dht *DHT) (size int) {
+	dht.db.View(func(tx *buntdb.Tx) (err error) {
+		size, err = getIntVal("_size", tx)
+		return
+	})
+	return
+}
+
+func TestCollectGarbage(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	rejected, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	banned, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	good, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+	missing, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh5")
+	bad, _ := makePeer("bad")
+	other, _ := makePeer("other")
+
+	dht.put(nil, "myData", rejected, other, []byte("rejected"), REJECTED)
+	dht.put(nil, "myData", banned, bad, []byte("banned"), LIVE)
+	dht.put(nil, "myData", good, other, []byte("good"), LIVE)
+	dht.db.Update(func(tx *buntdb.Tx) error {
+		tx.Set("meta:"+missing.String()+":"+good.String()+":tag", "orphan", nil)
+		return nil
+	})
+	dht.AddHolder(missing, other)
+	for i := 0; i < MinBanEvents; i++ {
+		dht.RecordPeerEvent(bad, PeerInvalidPut)
+	}
+
+	Convey("it should validate the retention setting", t, func() {
+		config := Config{}
+		r, err := config.GCRetentionDuration()
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, 24*time.Hour)
+		config.GCRetention = "fish"
+		_, err = config.GCRetentionDuration()
+		So(err, ShouldNotBeNil)
+		_, err = h.CollectGarbage("-1h")
+		So(err.Error(), ShouldEqual, "gc retention can't be negative, got: -1h")
+	})
+
+	Convey("it should keep garbage for the retention period", t, func() {
+		report, err := h.CollectGarbage("1h")
+		So(err, ShouldBeNil)
+		So(report, ShouldResemble, GCReport{})
+		So(dht.exists(rejected), ShouldBeNil)
+	})
+
+	Convey("it should purge rejected, banned and orphaned data", t, func() {
+		r, _ := dht.GetPeerRecord(bad)
+		So(r.Banned(), ShouldBeTrue)
+		before := dhtSize(dht)
+		report, err := dht.CollectGarbage(0)
+		So(err, ShouldBeNil)
+		So(report.Entries, ShouldEqual, 2)
+		So(report.Metas, ShouldEqual, 1)
+		So(report.Holders, ShouldEqual, 5)
+		So(report.Reclaimed, ShouldEqual, len("rejected")+len("banned")+len("orphan"))
+		So(dhtSize(dht), ShouldEqual, before-report.Reclaimed)
+
+		So(dht.exists(rejected), ShouldNotBeNil)
+		So(dht.exists(banned), ShouldNotBeNil)
+		So(dht.exists(good), ShouldBeNil)
+		_, _, status, _ := dht.get(good)
+		So(status, ShouldEqual, LIVE)
+		So(h.Metrics().Get("dht.gc.purged"), ShouldEqual, int64(8))
+		So(h.Metrics().Get("dht.gc.reclaimed"), ShouldEqual, int64(report.Reclaimed))
+
+		dht.db.View(func(tx *buntdb.Tx) error {
+			status, _ := tx.Get("status:" + banned.String())
+			So(status, ShouldEqual, "1")
+			n := 0
+			tx.AscendKeys("gc:*", func(k, v string) bool { n++; return true })
+			So(n, ShouldEqual, 0)
+			return nil
+		})
+	})
+}
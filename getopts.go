@@ -0,0 +1,168 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// getopts implements DHT gets that query several holders, retry failures and give up after a
+// timeout, so that apps can trade latency for confidence in what they get back
+
+package holochain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"time"
+)
+
+var ErrGetTimeout error = errors.New("get timed out")
+
+// GetOptions controls how a DHT get is made.  The zero value asks a single node once.
+type GetOptions struct {
+	Quorum    int  // number of holders that must return the same entry, defaults to 1
+	Timeout   int  // milliseconds to wait for a quorum, 0 for no limit
+	Retries   int  // extra attempts to make of each holder that fails
+	RetryWait int  // milliseconds to wait between attempts
+	LocalOnly bool // only look in this node's own DHT store
+}
+
+// ParseGetOptions decodes get options given as JSON, as they are by nuclei and the web API
+func ParseGetOptions(j string) (opts GetOptions, err error) {
+	if j == "" {
+		return
+	}
+	if err = json.Unmarshal([]byte(j), &opts); err != nil {
+		err = fmt.Errorf("bad get options: %v", err)
+	}
+	return
+}
+
+// getCandidates returns the nodes to ask for a hash: the node nearest it, followed by the
+// other peers known to hold it
+func (dht *DHT) getCandidates(key Hash) (candidates []peer.ID, err error) {
+	var n *Node
+	n, err = dht.FindNodeForHash(key)
+	if err != nil {
+		return
+	}
+	candidates = append(candidates, n.HashAddr)
+	var holders []peer.ID
+	holders, err = dht.GetHolders(key)
+	if err != nil {
+		return
+	}
+	for _, id := range holders {
+		if id != n.HashAddr {
+			candidates = append(candidates, id)
+		}
+	}
+	return
+}
+
+// getFrom asks a node for a hash, retrying as the options allow until ctx is done
+func (dht *DHT) getFrom(ctx context.Context, id peer.ID, key Hash, opts *GetOptions) (response interface{}, err error) {
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 {
+			dht.h.metrics.Inc("dht.get.retries", 1)
+			select {
+			case <-time.After(time.Duration(opts.RetryWait) * time.Millisecond):
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+		response, err = dht.h.sendContext(ctx, DHTProtocol, id, GET_REQUEST, GetReq{H: key}, DHTReceiver)
+		if err == nil || err == ErrHashNotFound || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// getQuorum asks candidates in turn until a quorum of them return the same entry or ctx is done
+func (dht *DHT) getQuorum(ctx context.Context, key Hash, opts GetOptions) (response interface{}, err error) {
+	var candidates []peer.ID
+	candidates, err = dht.getCandidates(key)
+	if err != nil {
+		return
+	}
+	quorum := opts.Quorum
+	if quorum < 1 {
+		quorum = 1
+	}
+	agree := make(map[string]int)
+	var lastErr error
+	for _, id := range candidates {
+		var r interface{}
+		r, lastErr = dht.getFrom(ctx, id, key, &opts)
+		if ctx.Err() != nil {
+			err = ErrGetTimeout
+			return
+		}
+		if lastErr != nil {
+			continue
+		}
+		e, ok := r.(*GobEntry)
+		if !ok {
+			lastErr = fmt.Errorf("unexpected response type from get: %T", r)
+			continue
+		}
+		var b []byte
+		if b, lastErr = e.Marshal(); lastErr != nil {
+			continue
+		}
+		k := string(b)
+		agree[k]++
+		if agree[k] >= quorum {
+			response = r
+			return
+		}
+	}
+	if quorum == 1 && lastErr != nil {
+		err = lastErr
+		return
+	}
+	most := 0
+	for _, n := range agree {
+		if n > most {
+			most = n
+		}
+	}
+	err = fmt.Errorf("get quorum of %d not reached, best agreement was %d of %d holders", quorum, most, len(candidates))
+	return
+}
+
+// SendGetWithOptions retrieves a value from the DHT as the options direct
+func (dht *DHT) SendGetWithOptions(key Hash, opts GetOptions) (response interface{}, err error) {
+	if opts.LocalOnly {
+		var b []byte
+		var status int
+		b, _, status, err = dht.get(key)
+		if err == nil && status == REDACTED {
+			err = ErrEntryRedacted
+		}
+		if err == nil && status == QUARANTINED {
+			err = ErrEntryQuarantined
+		}
+		if err != nil {
+			return
+		}
+		var e GobEntry
+		if err = e.Unmarshal(b); err == nil {
+			response = &e
+		}
+		return
+	}
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+	response, err = dht.getQuorum(ctx, key, opts)
+	if err == ErrGetTimeout {
+		dht.h.metrics.Inc("dht.get.timeouts", 1)
+	}
+	return
+}
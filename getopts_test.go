@@ -0,0 +1,57 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestGetOptions(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	for len(h.dht.puts) > 0 {
+		<-h.dht.puts
+	}
+
+	Convey("it should parse options", t, func() {
+		opts, err := ParseGetOptions("")
+		So(err, ShouldBeNil)
+		So(opts, ShouldResemble, GetOptions{})
+		opts, err = ParseGetOptions(`{"Atype":"hash", "Quorum":2, "Timeout":500, "LocalOnly":true, "zKeyOrder":["Quorum"]}`)
+		So(err, ShouldBeNil)
+		So(opts, ShouldResemble, GetOptions{Quorum: 2, Timeout: 500, LocalOnly: true})
+		_, err = ParseGetOptions("{fish")
+		So(err.Error(), ShouldStartWith, "bad get options")
+	})
+
+	hash, err := h.Commit("myData", "2")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should get from the local store only", t, func() {
+		_, err := h.dht.SendGetWithOptions(hash, GetOptions{LocalOnly: true})
+		So(err, ShouldEqual, ErrHashNotFound)
+		h.dht.SendPut(hash)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		r, err := h.dht.SendGetWithOptions(hash, GetOptions{LocalOnly: true})
+		So(err, ShouldBeNil)
+		So(r.(*GobEntry).C, ShouldEqual, "2")
+	})
+
+	Convey("it should get with a quorum", t, func() {
+		r, err := h.dht.SendGetWithOptions(hash, GetOptions{Quorum: 1, Timeout: 1000})
+		So(err, ShouldBeNil)
+		So(r.(*GobEntry).C, ShouldEqual, "2")
+
+		_, err = h.dht.SendGetWithOptions(hash, GetOptions{Quorum: 2})
+		So(err.Error(), ShouldEqual, "get quorum of 2 not reached, best agreement was 1 of 1 holders")
+	})
+
+	Convey("it should retry holders that fail", t, func() {
+		other, _ := makePeer("other")
+		So(h.dht.AddHolder(hash, other), ShouldBeNil)
+		_, err := h.dht.SendGetWithOptions(hash, GetOptions{Quorum: 2, Retries: 2})
+		So(err.Error(), ShouldEqual, "get quorum of 2 not reached, best agreement was 1 of 2 holders")
+		So(h.Metrics().Get("dht.get.retries"), ShouldEqual, int64(2))
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// graph implements drawing the structure of a chain, for developers debugging their data
+// models.  Each header is a node, with edges to the header before it, to the previous header
+// of its type, and to the entries its entry links to in the DHT store, which are drawn as
+// nodes of their own if they aren't on the chain.  Graphs are written in graphviz's DOT
+// language or as Mermaid flowcharts, which render where developers already look, e.g. in
+// markdown on github.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+
+	// kinds of edges
+	GraphEdgeHeader = "header" // to the header before
+	GraphEdgeType   = "type"   // to the previous header of the same type
+	GraphEdgeLink   = "link"   // to an entry linked to
+)
+
+var ErrUnknownGraphFormat error = errors.New("unknown graph format, expected dot or mermaid")
+
+// GraphNode is a header of the chain, or an entry off the chain that one links to
+type GraphNode struct {
+	ID    string
+	Type  string // the header's entry type, empty for entries off the chain
+	Hash  string // of the header, or of the entry off the chain
+	Entry string // hash of the header's entry
+}
+
+// GraphEdge joins two nodes of a chain's graph
+type GraphEdge struct {
+	From  string
+	To    string
+	Kind  string
+	Label string
+}
+
+// ChainGraph is the structure of a chain
+type ChainGraph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// metaLink is a link from an entry in the DHT store
+type metaLink struct {
+	Hash string
+	Tag  string
+}
+
+// linksFrom returns the links from an entry held in the DHT store, leaving out links to
+// entries the store knows to have been deleted
+func (dht *DHT) linksFrom(key Hash) (links []metaLink, err error) {
+	prefix := "meta:" + key.String() + ":"
+	live := strconv.Itoa(LIVE)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(prefix+"*", func(k, value string) bool {
+			x := strings.SplitN(strings.TrimPrefix(k, prefix), ":", 2)
+			if len(x) != 2 {
+				return true
+			}
+			if status, e := tx.Get("status:" + x[0]); e == nil && status != live {
+				return true
+			}
+			links = append(links, metaLink{Hash: x[0], Tag: x[1]})
+			return true
+		})
+	})
+	return
+}
+
+// Graph returns the structure of the chain and the links from its entries
+func (h *Holochain) Graph() (g *ChainGraph, err error) {
+	var it *ChainIterator
+	if it, err = h.Iterator(false); err != nil {
+		return
+	}
+	defer it.Close()
+	g = &ChainGraph{}
+	headers := make(map[string]string) // node ids of headers by hash
+	entries := make(map[string]string) // node ids of headers by the hash of their entry
+	for it.Next() {
+		hd := it.Header()
+		id := fmt.Sprintf("n%d", it.Index())
+		hash := it.Hash().String()
+		node := GraphNode{ID: id, Type: hd.Type, Hash: hash, Entry: hd.EntryLink.String()}
+		g.Nodes = append(g.Nodes, node)
+		headers[hash] = id
+		entries[node.Entry] = id
+		prev := hd.HeaderLink.String()
+		if to, ok := headers[prev]; ok && !hd.HeaderLink.IsNullHash() {
+			g.Edges = append(g.Edges, GraphEdge{From: id, To: to, Kind: GraphEdgeHeader})
+		}
+		if !hd.TypeLink.IsNullHash() && hd.TypeLink.String() != prev {
+			if to, ok := headers[hd.TypeLink.String()]; ok {
+				g.Edges = append(g.Edges, GraphEdge{From: id, To: to, Kind: GraphEdgeType})
+			}
+		}
+	}
+	if err = it.Err(); err != nil {
+		return
+	}
+
+	// links are added once all the headers are known, as entries can link to later ones
+	off := 0
+	for _, node := range g.Nodes {
+		var key Hash
+		if key, err = NewHash(node.Entry); err != nil {
+			return
+		}
+		var links []metaLink
+		if links, err = h.dht.linksFrom(key); err != nil {
+			return
+		}
+		for _, l := range links {
+			to, ok := entries[l.Hash]
+			if !ok {
+				to = fmt.Sprintf("e%d", off)
+				off++
+				entries[l.Hash] = to
+				g.Nodes = append(g.Nodes, GraphNode{ID: to, Hash: l.Hash})
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: node.ID, To: to, Kind: GraphEdgeLink, Label: l.Tag})
+		}
+	}
+	return
+}
+
+// shortHash abbreviates a hash for labelling a node
+func shortHash(hash string) string {
+	if len(hash) <= 8 {
+		return hash
+	}
+	return "..." + hash[len(hash)-8:]
+}
+
+// label returns the text a node is labelled with
+func (n *GraphNode) label() string {
+	if n.Type == "" {
+		return "entry " + shortHash(n.Hash)
+	}
+	return n.Type + " " + shortHash(n.Hash)
+}
+
+// Write writes the graph in a format
+func (g *ChainGraph) Write(w io.Writer, format string) error {
+	switch format {
+	case GraphDOT:
+		return g.WriteDOT(w)
+	case GraphMermaid:
+		return g.WriteMermaid(w)
+	}
+	return ErrUnknownGraphFormat
+}
+
+// WriteDOT writes the graph in graphviz's DOT language
+func (g *ChainGraph) WriteDOT(w io.Writer) (err error) {
+	q := func(s string) string {
+		return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	}
+	lines := []string{"digraph chain {", "  rankdir=BT;", "  node [shape=box];"}
+	for _, n := range g.Nodes {
+		attrs := "label=" + q(n.label())
+		if n.Type == "" {
+			attrs += ", style=dashed"
+		}
+		lines = append(lines, fmt.Sprintf("  %s [%s];", n.ID, attrs))
+	}
+	for _, e := range g.Edges {
+		var attrs string
+		switch e.Kind {
+		case GraphEdgeType:
+			attrs = "style=dashed, color=gray"
+		case GraphEdgeLink:
+			attrs = "label=" + q(e.Label) + ", color=blue, constraint=false"
+		}
+		if attrs != "" {
+			attrs = " [" + attrs + "]"
+		}
+		lines = append(lines, fmt.Sprintf("  %s -> %s%s;", e.From, e.To, attrs))
+	}
+	lines = append(lines, "}")
+	_, err = io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart
+func (g *ChainGraph) WriteMermaid(w io.Writer) (err error) {
+	q := func(s string) string {
+		return `"` + strings.Replace(s, `"`, "#quot;", -1) + `"`
+	}
+	lines := []string{"flowchart BT"}
+	for _, n := range g.Nodes {
+		if n.Type == "" {
+			lines = append(lines, fmt.Sprintf("  %s([%s])", n.ID, q(n.label())))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s[%s]", n.ID, q(n.label())))
+		}
+	}
+	for _, e := range g.Edges {
+		switch e.Kind {
+		case GraphEdgeHeader:
+			lines = append(lines, fmt.Sprintf("  %s --> %s", e.From, e.To))
+		case GraphEdgeType:
+			lines = append(lines, fmt.Sprintf("  %s -.-> %s", e.From, e.To))
+		case GraphEdgeLink:
+			lines = append(lines, fmt.Sprintf("  %s ==>|%s| %s", e.From, q(e.Label), e.To))
+		}
+	}
+	_, err = io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return
+}
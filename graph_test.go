@@ -0,0 +1,85 @@
+package holochain
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestGraph(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	now := time.Now()
+	commit := func(entryType string, content string) *Header {
+		e := GobEntry{C: content}
+		_, hd, err := h.NewEntry(now, entryType, &e)
+		if err != nil {
+			panic(err)
+		}
+		return hd
+	}
+	data := commit("myData", "2")
+	commit("profile", `{"firstName":"Zippy","lastName":"Pinhead"}`)
+	commit("myData", "3")
+
+	// a link from the first myData entry to an entry off the chain
+	var off Hash
+	off.Sum(h.hashSpec, []byte("off chain"))
+	if err := h.dht.put(nil, "myData", data.EntryLink, h.id, []byte("2"), LIVE); err != nil {
+		panic(err)
+	}
+	if err := h.dht.putMeta(nil, data.EntryLink, off, "likes", &GobEntry{C: "off chain"}); err != nil {
+		panic(err)
+	}
+
+	Convey("it should graph the chain's headers and links", t, func() {
+		g, err := h.Graph()
+		So(err, ShouldBeNil)
+		n := len(h.chain.Headers)
+		So(len(g.Nodes), ShouldEqual, n+1)
+		So(g.Nodes[n-1].Type, ShouldEqual, "myData")
+		So(g.Nodes[n].ID, ShouldEqual, "e0")
+		So(g.Nodes[n].Hash, ShouldEqual, off.String())
+
+		kinds := make(map[string][]GraphEdge)
+		for _, e := range g.Edges {
+			kinds[e.Kind] = append(kinds[e.Kind], e)
+		}
+		So(len(kinds[GraphEdgeHeader]), ShouldEqual, n-1)
+		So(kinds[GraphEdgeType], ShouldResemble, []GraphEdge{{From: g.Nodes[n-1].ID, To: g.Nodes[n-3].ID, Kind: GraphEdgeType}})
+		So(kinds[GraphEdgeLink], ShouldResemble, []GraphEdge{{From: g.Nodes[n-3].ID, To: "e0", Kind: GraphEdgeLink, Label: "likes"}})
+	})
+
+	Convey("it should write the graph as DOT and Mermaid", t, func() {
+		g, err := h.Graph()
+		So(err, ShouldBeNil)
+		var b bytes.Buffer
+		So(g.Write(&b, GraphDOT), ShouldBeNil)
+		dot := b.String()
+		So(dot, ShouldStartWith, "digraph chain {")
+		So(dot, ShouldContainSubstring, `-> e0 [label="likes"`)
+		So(dot, ShouldContainSubstring, "style=dashed, color=gray")
+
+		b.Reset()
+		So(g.Write(&b, GraphMermaid), ShouldBeNil)
+		mermaid := b.String()
+		So(mermaid, ShouldStartWith, "flowchart BT")
+		So(mermaid, ShouldContainSubstring, `==>|"likes"| e0`)
+		So(mermaid, ShouldContainSubstring, " -.-> ")
+		So(mermaid, ShouldContainSubstring, `e0(["entry ...`+off.String()[len(off.String())-8:]+`"])`)
+
+		So(g.Write(&b, "svg"), ShouldEqual, ErrUnknownGraphFormat)
+	})
+
+	Convey("it should leave out links to deleted entries", t, func() {
+		var gone Hash
+		gone.Sum(h.hashSpec, []byte("deleted"))
+		So(h.dht.put(nil, "myData", gone, h.id, []byte("4"), DELETED), ShouldBeNil)
+		So(h.dht.putMeta(nil, data.EntryLink, gone, "likes", &GobEntry{C: "deleted"}), ShouldBeNil)
+		links, err := h.dht.linksFrom(data.EntryLink)
+		So(err, ShouldBeNil)
+		So(links, ShouldResemble, []metaLink{{Hash: off.String(), Tag: "likes"}})
+	})
+}
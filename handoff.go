@@ -0,0 +1,230 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// handoff implements a node leaving the DHT gracefully.  Rather than leaving its peers to
+// rediscover by gossip the entries it held, a node that shuts down hands off in bulk the range
+// of entries that would be left with too few holders, sending each peer a signed range
+// transfer listing those entries and their sources.  A peer queues a put of each entry it
+// doesn't already hold, fetching and validating it from its source as for any put, though an
+// invalid put counts against the node that handed it off.  Likewise when a peer stops
+// responding, so that the neighborhood holding its entries shrinks, the nodes still holding
+// them hand off those left with too few holders.  As every node currently holds the whole
+// DHT, the range handed off is the entries known to be held by fewer nodes than the DNA's
+// resilience calls for.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"math/rand"
+	"time"
+)
+
+// MaxRangeTransferEntries is the most entries listed in a single range transfer
+const MaxRangeTransferEntries = 256
+
+var ErrDHTExpectedRangeTransferInBody error = errors.New("expected range transfer")
+
+// RangeItem is an entry handed off in a range transfer
+type RangeItem struct {
+	H      Hash
+	Source peer.ID // the entry's source, from which the entry is fetched and validated
+}
+
+// RangeTransfer is a node's signed hand off of entries it holds to a peer
+type RangeTransfer struct {
+	Entries []RangeItem
+	From    peer.ID
+	Time    time.Time
+	PubKey  []byte
+	Sig     []byte
+}
+
+func (t *RangeTransfer) signedBytes() ([]byte, error) {
+	return ByteEncoder(RangeTransfer{Entries: t.Entries, From: t.From, Time: t.Time})
+}
+
+// NewRangeTransfer returns a signed transfer of the given entries
+func (h *Holochain) NewRangeTransfer(entries []RangeItem) (t *RangeTransfer, err error) {
+	x := RangeTransfer{Entries: entries, From: h.id, Time: time.Now()}
+	priv := h.Agent().PrivKey()
+	if x.PubKey, err = ic.MarshalPublicKey(priv.GetPublic()); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = x.signedBytes(); err != nil {
+		return
+	}
+	if x.Sig, err = priv.Sign(b); err != nil {
+		return
+	}
+	t = &x
+	return
+}
+
+// verify checks that the transfer was signed by the node it claims to be from
+func (t *RangeTransfer) verify() (err error) {
+	var pub ic.PubKey
+	if pub, err = ic.UnmarshalPublicKey(t.PubKey); err != nil {
+		return
+	}
+	if !t.From.MatchesPublicKey(pub) {
+		err = fmt.Errorf("range transfer from %v signed with another key", t.From)
+		return
+	}
+	var b []byte
+	if b, err = t.signedBytes(); err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(b, t.Sig)
+	if err == nil && !ok {
+		err = fmt.Errorf("bad range transfer signature from %v", t.From)
+	}
+	return
+}
+
+// handleRangeTransfer queues puts of the transferred entries that aren't already held,
+// responding with the hashes of those that are already held, so that the sender only counts
+// this node as holding what it actually holds.  Queued entries are counted as held once their
+// puts have been handled, by a later hand off or by gossip.  Queuing stops once the put queue
+// is full.
+func (dht *DHT) handleRangeTransfer(from peer.ID, t *RangeTransfer) (response interface{}, err error) {
+	if t.From != from {
+		err = fmt.Errorf("range transfer from %v sent by %v", t.From, from)
+		return
+	}
+	if len(t.Entries) > MaxRangeTransferEntries {
+		err = fmt.Errorf("range transfer of %d entries is over the limit of %d", len(t.Entries), MaxRangeTransferEntries)
+		return
+	}
+	if err = t.verify(); err != nil {
+		return
+	}
+	if age := time.Since(t.Time); age > HoldRequestWindow || age < -HoldRequestWindow {
+		err = fmt.Errorf("stale range transfer from %v", from)
+		return
+	}
+	if !dht.holdLimits.allow(from) {
+		dht.h.metrics.Inc("handoff.limited", 1)
+		err = ErrHoldRateLimited
+		return
+	}
+	held := make([]Hash, 0)
+	var queued int
+	for _, item := range t.Entries {
+		if dht.isRedacted(item.H) {
+			continue
+		}
+		if dht.exists(item.H) == nil {
+			held = append(held, item.H)
+			continue
+		}
+		m := Message{Type: PUT_REQUEST, Time: time.Now(), From: item.Source, Body: PutReq{H: item.H}, handedOffBy: from}
+		if _, e := dht.queuePut(&m); e != nil {
+			break
+		}
+		queued++
+	}
+	dht.h.metrics.Inc("handoff.accepted", int64(len(held)+queued))
+	dht.dlog.Logf("queued %d and already held %d of %d entries handed off by %v", queued, len(held), len(t.Entries), from)
+	response = held
+	return
+}
+
+// HandOff transfers the entries that would be left held by too few nodes once this node
+// leaves to peers that don't hold them, returning the number of entries the peers hold
+func (dht *DHT) HandOff() (handed int, err error) {
+	handed, err = dht.handOff(dht.h.id)
+	return
+}
+
+// handOffFrom hands off the entries that a peer that stopped responding was holding, as the
+// neighborhood holding them has shrunk
+func (dht *DHT) handOffFrom(gone peer.ID) {
+	if n, err := dht.handOff(gone); err != nil {
+		dht.dlog.Logf("handing off entries held by %v: %v", gone, err)
+	} else if n > 0 {
+		dht.dlog.Logf("handed off %d entries held by %v", n, gone)
+	}
+}
+
+// handOff transfers the entries that are held by too few nodes without the given one to
+// peers that don't hold them
+func (dht *DHT) handOff(gone peer.ID) (handed int, err error) {
+	if dht.h.Offline() {
+		return
+	}
+	var entries []underReplicated
+	if entries, err = dht.heldByTooFew(gone); err != nil || len(entries) == 0 {
+		return
+	}
+	var glist []Gossiper
+	if glist, err = dht.Gossipers(); err != nil {
+		return
+	}
+	for _, i := range rand.Perm(len(glist)) {
+		id := glist[i].Id
+		r, e := dht.GetPeerRecord(id)
+		if id == gone || e != nil || r.Banned() || r.Unreachable || dht.h.peerLacks(id, FeatureHandoff) {
+			continue
+		}
+		var items []RangeItem
+		for _, u := range entries {
+			if !u.holders[id] && len(u.holders) < u.resilience && dht.h.peerHolds(id, u.entryType) {
+				items = append(items, RangeItem{H: u.key, Source: u.source})
+			}
+		}
+		for len(items) > 0 {
+			batch := items
+			if len(batch) > MaxRangeTransferEntries {
+				batch = items[:MaxRangeTransferEntries]
+			}
+			items = items[len(batch):]
+			var n int
+			if n, err = dht.transferRange(id, batch, entries); err != nil {
+				return
+			}
+			handed += n
+		}
+	}
+	return
+}
+
+// transferRange sends a batch of entries to a peer, recording the peer as a holder of those
+// it already holds
+func (dht *DHT) transferRange(id peer.ID, batch []RangeItem, entries []underReplicated) (accepted int, err error) {
+	var t *RangeTransfer
+	if t, err = dht.h.NewRangeTransfer(batch); err != nil {
+		return
+	}
+	dht.h.metrics.Inc("handoff.sent", int64(len(batch)))
+	response, e := dht.send(id, RANGE_TRANSFER, *t)
+	if e != nil {
+		dht.dlog.Logf("range transfer of %d entries refused by %v: %v", len(batch), id, e)
+		return
+	}
+	hashes, ok := response.([]Hash)
+	if !ok {
+		dht.dlog.Logf("unexpected response to range transfer from %v: %T", id, response)
+		return
+	}
+	held := make(map[string]bool)
+	for _, k := range hashes {
+		if err = dht.AddHolder(k, id); err != nil {
+			return
+		}
+		held[k.String()] = true
+	}
+	for _, u := range entries {
+		if held[u.key.String()] {
+			u.holders[id] = true
+		}
+	}
+	accepted = len(hashes)
+	return
+}
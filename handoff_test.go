@@ -0,0 +1,98 @@
+package holochain
+
+import (
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedRangeTransfer(name string, entries []RangeItem, at time.Time) (t *RangeTransfer) {
+	reader := strings.NewReader(name + "1234567890123456789012345678901234567890")
+	priv, _, _ := ic.GenerateEd25519Key(reader)
+	id, _ := peer.IDFromPrivateKey(priv)
+	t = &RangeTransfer{Entries: entries, From: id, Time: at}
+	t.PubKey, _ = ic.MarshalPublicKey(priv.GetPublic())
+	b, _ := t.signedBytes()
+	t.Sig, _ = priv.Sign(b)
+	return
+}
+
+func TestRangeTransfer(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	for len(dht.puts) > 0 {
+		<-dht.puts
+	}
+
+	hash, _ := h.Commit("myData", "2")
+	e := GobEntry{C: "2"}
+	b, _ := e.Marshal()
+	dht.put(nil, "myData", hash, h.id, b, LIVE)
+	other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	Convey("range transfers should be signed by the leaving node", t, func() {
+		x, err := h.NewRangeTransfer([]RangeItem{{H: hash, Source: h.id}})
+		So(err, ShouldBeNil)
+		So(x.verify(), ShouldBeNil)
+		x.Entries[0].H = other
+		So(x.verify().Error(), ShouldStartWith, "bad range transfer signature")
+	})
+
+	Convey("it should queue puts of the entries it doesn't hold", t, func() {
+		x := signedRangeTransfer("leaver", []RangeItem{{H: other, Source: h.id}, {H: hash, Source: h.id}}, time.Now())
+		resp, err := dht.handleRangeTransfer(x.From, x)
+		So(err, ShouldBeNil)
+		So(len(resp.([]Hash)), ShouldEqual, 1)
+		So(resp.([]Hash)[0].String(), ShouldEqual, hash.String())
+		So(len(dht.puts), ShouldEqual, 1)
+		m := <-dht.puts
+		So(m.From, ShouldEqual, h.id)
+		So(m.handedOffBy, ShouldEqual, x.From)
+		So(m.Body.(PutReq).H.String(), ShouldEqual, other.String())
+		So(h.Metrics().Get("handoff.accepted"), ShouldEqual, 2)
+	})
+
+	Convey("it should reject forged, stale and oversized transfers", t, func() {
+		x := signedRangeTransfer("leaver", []RangeItem{{H: other, Source: h.id}}, time.Now())
+		_, err := dht.handleRangeTransfer(h.id, x)
+		So(err.Error(), ShouldStartWith, "range transfer from")
+		x = signedRangeTransfer("leaver", []RangeItem{{H: other, Source: h.id}}, time.Now().Add(-2*HoldRequestWindow))
+		_, err = dht.handleRangeTransfer(x.From, x)
+		So(err.Error(), ShouldStartWith, "stale range transfer")
+		x = signedRangeTransfer("leaver", make([]RangeItem, MaxRangeTransferEntries+1), time.Now())
+		_, err = dht.handleRangeTransfer(x.From, x)
+		So(err.Error(), ShouldEndWith, "over the limit of 256")
+	})
+
+	Convey("entries only it holds should be handed off when it leaves", t, func() {
+		entries, err := dht.heldByTooFew(h.id)
+		So(err, ShouldBeNil)
+		var found bool
+		for _, u := range entries {
+			if u.key.String() == hash.String() {
+				found = true
+				So(u.holders[h.id], ShouldBeFalse)
+			}
+		}
+		So(found, ShouldBeTrue)
+	})
+
+	Convey("entries held by too few should be handed off when a holder stops responding", t, func() {
+		gone, _ := makePeer("gone")
+		So(dht.AddHolder(hash, gone), ShouldBeNil)
+		entries, err := dht.heldByTooFew(gone)
+		So(err, ShouldBeNil)
+		var found bool
+		for _, u := range entries {
+			if u.key.String() == hash.String() {
+				found = true
+				So(u.holders[gone], ShouldBeFalse)
+			}
+		}
+		So(found, ShouldBeTrue)
+	})
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// handshake implements the negotiation of the node-to-node protocol version and optional
+// features between two nodes when they first talk, so that the protocol can evolve without
+// every node having to upgrade at once.  Nodes whose versions don't overlap refuse to talk,
+// and features a peer doesn't support are skipped when dealing with it.  Nodes also say which
+// of the app's topics they hold, so entries are only offered to peers that hold them.  Peers
+// that predate the handshake are treated as speaking an unknown version and holding every
+// topic.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	"sync"
+)
+
+const (
+	HandshakeProtocol = protocol.ID("/holochain-hello/0.0.0")
+
+	ProtocolVersion    = 1 // version of the node-to-node protocol spoken by this node
+	MinProtocolVersion = 1 // oldest version of the protocol this node can still speak
+
+	FeatureFastSync    = "fast-sync"   // serving snapshots of the DHT store
+	FeatureHeartbeat   = "heartbeat"   // accepting heartbeats of chain heads
+	FeatureHold        = "hold"        // accepting requests to hold entries
+	FeatureHandoff     = "handoff"     // accepting range transfers from leaving nodes
+	FeatureTimeAttest  = "time-attest" // attesting to the times of headers
+	FeatureCountersign = "countersign" // countersigning entries with other agents
+	FeatureCount       = "count"       // answering getmeta queries with a count of the links
+)
+
+// ProtocolFeatures are the optional protocol features this node supports
+var ProtocolFeatures = []string{FeatureFastSync, FeatureHeartbeat, FeatureHold, FeatureHandoff, FeatureTimeAttest, FeatureCountersign, FeatureCount}
+
+var ErrDHTExpectedHandshakeInBody error = errors.New("expected handshake")
+var ErrIncompatibleProtocol error = errors.New("peer speaks an incompatible protocol version")
+
+// Handshake is what a node advertises about the protocol it speaks
+type Handshake struct {
+	Version    int
+	MinVersion int
+	Features   []string
+	Topics     []string // topics of the app the node holds, all if empty
+}
+
+// PeerProtocol is the protocol negotiated with a peer
+type PeerProtocol struct {
+	Version      int      // 0 if the peer predates the handshake
+	Features     []string // features supported by both nodes
+	Topics       []string // topics the peer holds, all if empty
+	Incompatible bool
+}
+
+// Supports returns true if the feature was agreed with the peer
+func (p *PeerProtocol) Supports(feature string) bool {
+	for _, f := range p.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// peerProtocols caches the protocols negotiated with a node's peers, for as long as they stay
+// reachable, so the handshake is done once per connection rather than once per message
+type peerProtocols struct {
+	lk    sync.Mutex
+	peers map[peer.ID]*PeerProtocol
+}
+
+func (pp *peerProtocols) get(id peer.ID) (p *PeerProtocol) {
+	pp.lk.Lock()
+	defer pp.lk.Unlock()
+	return pp.peers[id]
+}
+
+func (pp *peerProtocols) set(id peer.ID, p *PeerProtocol) {
+	pp.lk.Lock()
+	defer pp.lk.Unlock()
+	if pp.peers == nil {
+		pp.peers = make(map[peer.ID]*PeerProtocol)
+	}
+	pp.peers[id] = p
+}
+
+// forget drops what was negotiated with a peer, so the handshake is done again when it is
+// next reached, as it may have been restarted with another version
+func (pp *peerProtocols) forget(id peer.ID) {
+	pp.lk.Lock()
+	defer pp.lk.Unlock()
+	delete(pp.peers, id)
+}
+
+// handshake returns what the node advertises about its protocol
+func (node *Node) handshake() Handshake {
+	if node.Hello != nil {
+		return *node.Hello
+	}
+	return Handshake{Version: ProtocolVersion, MinVersion: MinProtocolVersion, Features: ProtocolFeatures, Topics: node.topics}
+}
+
+// negotiate picks the highest version both sides speak and the features both support
+func negotiate(ours Handshake, theirs Handshake) (p *PeerProtocol, err error) {
+	p = &PeerProtocol{Version: ours.Version, Topics: theirs.Topics}
+	if theirs.Version < p.Version {
+		p.Version = theirs.Version
+	}
+	if p.Version < ours.MinVersion || p.Version < theirs.MinVersion {
+		p.Incompatible = true
+		err = fmt.Errorf("%v: we speak %d-%d, peer speaks %d-%d", ErrIncompatibleProtocol,
+			ours.MinVersion, ours.Version, theirs.MinVersion, theirs.Version)
+		return
+	}
+	supported := make(map[string]bool)
+	for _, f := range theirs.Features {
+		supported[f] = true
+	}
+	for _, f := range ours.Features {
+		if supported[f] {
+			p.Features = append(p.Features, f)
+		}
+	}
+	return
+}
+
+// HandshakeReceiver handles messages on the handshake protocol
+func HandshakeReceiver(h *Holochain, m *Message) (response interface{}, err error) {
+	switch m.Type {
+	case HANDSHAKE:
+		theirs, ok := m.Body.(Handshake)
+		if !ok {
+			err = ErrDHTExpectedHandshakeInBody
+			return
+		}
+		ours := h.node.handshake()
+		var p *PeerProtocol
+		p, err = negotiate(ours, theirs)
+		h.node.protocols.set(m.From, p)
+		if err == nil {
+			response = ours
+		}
+	default:
+		err = fmt.Errorf("message type %d not in holochain-hello protocol", int(m.Type))
+	}
+	return
+}
+
+// StartHandshake initiates listening for handshake messages on the node
+func (node *Node) StartHandshake(h *Holochain) (err error) {
+	return node.StartProtocol(h, HandshakeProtocol, HandshakeReceiver)
+}
+
+// PeerProtocol returns the protocol negotiated with a peer, doing the handshake if it hasn't
+// yet been done.  It returns ErrIncompatibleProtocol if the peer's versions don't overlap ours.
+func (node *Node) PeerProtocol(id peer.ID) (p *PeerProtocol, err error) {
+	if p = node.protocols.get(id); p == nil {
+		var r Message
+		r, err = node.Send(HandshakeProtocol, id, node.NewMessage(HANDSHAKE, node.handshake()))
+		if err != nil {
+			// the peer can't be reached on the handshake protocol, which may be because it
+			// predates it, so don't hold that against it until it fails to respond otherwise
+			p = &PeerProtocol{}
+			err = nil
+			node.protocols.set(id, p)
+			return
+		}
+		if r.Type == ERROR_RESPONSE {
+			p = &PeerProtocol{Incompatible: true}
+			err = fmt.Errorf("%v: %v", ErrIncompatibleProtocol, r.Body)
+			node.protocols.set(id, p)
+			return
+		}
+		theirs, ok := r.Body.(Handshake)
+		if !ok {
+			err = ErrDHTExpectedHandshakeInBody
+			return
+		}
+		p, err = negotiate(node.handshake(), theirs)
+		node.protocols.set(id, p)
+		return
+	}
+	if p.Incompatible {
+		err = ErrIncompatibleProtocol
+	}
+	return
+}
+
+// peerLacks returns true if a peer is known not to support a protocol feature
+func (h *Holochain) peerLacks(id peer.ID, feature string) bool {
+	if id == h.node.HashAddr {
+		return false
+	}
+	p, err := h.node.PeerProtocol(id)
+	if err != nil {
+		return true
+	}
+	return p.Version > 0 && !p.Supports(feature)
+}
@@ -0,0 +1,89 @@
+package holochain
+
+import (
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	ours := Handshake{Version: 3, MinVersion: 2, Features: []string{"a", "b"}}
+
+	Convey("it should agree the highest common version and shared features", t, func() {
+		p, err := negotiate(ours, Handshake{Version: 2, MinVersion: 1, Features: []string{"b", "c"}})
+		So(err, ShouldBeNil)
+		So(p.Version, ShouldEqual, 2)
+		So(p.Features, ShouldResemble, []string{"b"})
+		So(p.Supports("b"), ShouldBeTrue)
+		So(p.Supports("a"), ShouldBeFalse)
+	})
+
+	Convey("it should refuse versions that don't overlap", t, func() {
+		p, err := negotiate(ours, Handshake{Version: 1, MinVersion: 1})
+		So(err.Error(), ShouldEqual, ErrIncompatibleProtocol.Error()+": we speak 2-3, peer speaks 1-1")
+		So(p.Incompatible, ShouldBeTrue)
+		_, err = negotiate(ours, Handshake{Version: 5, MinVersion: 4})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPeerProtocol(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+
+	node1, err := makeNode(1240, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node1.Close()
+	node2, err := makeNode(1241, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	node3, err := makeNode(1242, "node3")
+	if err != nil {
+		panic(err)
+	}
+	defer node3.Close()
+
+	h2 := Holochain{node: node2}
+	node2.StartHandshake(&h2)
+	h3 := Holochain{node: node3}
+	node3.StartHandshake(&h3)
+	node3.Hello = &Handshake{Version: ProtocolVersion + 2, MinVersion: ProtocolVersion + 1}
+	for _, n := range []*Node{node2, node3} {
+		node1.Host.Peerstore().AddAddr(n.HashAddr, n.NetAddr, pstore.PermanentAddrTTL)
+		n.Host.Peerstore().AddAddr(node1.HashAddr, node1.NetAddr, pstore.PermanentAddrTTL)
+	}
+
+	Convey("it should negotiate with peers on first contact", t, func() {
+		p, err := node1.PeerProtocol(node2.HashAddr)
+		So(err, ShouldBeNil)
+		So(p.Version, ShouldEqual, ProtocolVersion)
+		So(p.Features, ShouldResemble, ProtocolFeatures)
+		So(node2.protocols.get(node1.HashAddr), ShouldResemble, p)
+	})
+
+	Convey("it should refuse peers with incompatible versions", t, func() {
+		_, err := node1.PeerProtocol(node3.HashAddr)
+		So(err.Error(), ShouldStartWith, ErrIncompatibleProtocol.Error())
+		_, err = node1.PeerProtocol(node3.HashAddr)
+		So(err, ShouldEqual, ErrIncompatibleProtocol)
+		h1 := Holochain{node: node1}
+		_, err = h1.Send(SourceProtocol, node3.HashAddr, SRC_DNA, nil, SrcReceiver)
+		So(err, ShouldEqual, ErrIncompatibleProtocol)
+	})
+
+	Convey("it should treat peers that don't handshake as predating it", t, func() {
+		node2.Transport.SetStreamHandler(HandshakeProtocol, func(s Stream) { s.Close() })
+		node1.protocols.forget(node2.HashAddr)
+		p, err := node1.PeerProtocol(node2.HashAddr)
+		So(err, ShouldBeNil)
+		So(p.Version, ShouldEqual, 0)
+		So(node1.protocols.get(node2.HashAddr), ShouldEqual, p)
+		h1 := Holochain{node: node1}
+		So(h1.peerLacks(node2.HashAddr, FeatureFastSync), ShouldBeFalse)
+		So(h1.peerLacks(node3.HashAddr, FeatureFastSync), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,239 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// health implements a summary of how well a chain's node is doing, flagging it as degraded when
+// it falls behind on gossip, builds up a backlog of unacknowledged publications, sees too many
+// invalid entries recently, or uses too much disk.  Validations are counted in per minute
+// buckets that expire, so that the error rate reflects the recent window rather than the
+// chain's whole life.  The figures are read from the DHT store so that they
+// can be reported by a separate process from the one serving the chain.
+
+package holochain
+
+import (
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultGossipStaleAfter       = "10m"
+	DefaultMaxPublishBacklog      = 100
+	DefaultMaxValidationErrorRate = 0.1
+	DefaultValidationWindow       = "1h"
+
+	validationBucket = time.Minute
+
+	// the validation error rate isn't judged until there have been this many validations
+	minValidationsForRate = 20
+)
+
+// HealthLimits sets the thresholds past which a chain is reported as degraded, with zero
+// values meaning the defaults
+type HealthLimits struct {
+	GossipStaleAfter       string  // how long since the last successful gossip round is too long
+	MaxPublishBacklog      int     // most unacknowledged publications to allow
+	MaxValidationErrorRate float64 // largest fraction of validations that may fail
+	ValidationWindow       string  // how far back validations are counted in the error rate
+	MaxDiskBytes           int64   // largest disk usage of the chain's directory, 0 for no limit
+}
+
+// GossipStaleDuration returns how long gossip may go without succeeding
+func (l *HealthLimits) GossipStaleDuration() (d time.Duration, err error) {
+	stale := l.GossipStaleAfter
+	if stale == "" {
+		stale = DefaultGossipStaleAfter
+	}
+	if d, err = time.ParseDuration(stale); err != nil {
+		err = fmt.Errorf("bad gossip stale duration: %v", err)
+	}
+	return
+}
+
+// ValidationWindowDuration returns how far back validations are counted
+func (l *HealthLimits) ValidationWindowDuration() (d time.Duration, err error) {
+	w := l.ValidationWindow
+	if w == "" {
+		w = DefaultValidationWindow
+	}
+	if d, err = time.ParseDuration(w); err != nil {
+		err = fmt.Errorf("bad validation window: %v", err)
+	} else if d < validationBucket {
+		d = validationBucket
+	}
+	return
+}
+
+// Health summarizes the state of a chain's node
+type Health struct {
+	Started             bool
+	LastGossip          time.Time // zero if gossip has never succeeded
+	Gossipers           int
+	PublishBacklog      int
+	ValidationWindow    time.Duration // the recent period the validation counts cover
+	Validations         int
+	ValidationFailures  int
+	ValidationErrorRate float64
+	DiskBytes           int64
+	Problems            []string // why the chain is degraded, if it is
+}
+
+// Degraded returns true if any of the health checks failed
+func (hl *Health) Degraded() bool {
+	return len(hl.Problems) > 0
+}
+
+// recordGossip notes the time of a successful gossip round
+func (dht *DHT) recordGossip() error {
+	return dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("_gossiped", fmt.Sprintf("%d", time.Now().UnixNano()), nil)
+		return err
+	})
+}
+
+// validationBucketOf returns the number of the bucket that validations at a time are counted in
+func validationBucketOf(t time.Time) int64 {
+	return t.Unix() / int64(validationBucket/time.Second)
+}
+
+// validationBucketKey returns the key of the count of the given kind of validation in a bucket
+func validationBucketKey(kind string, bucket int64) string {
+	return fmt.Sprintf("_%s:%d", kind, bucket)
+}
+
+// recordValidation counts a validation and whether it failed in the current bucket, which
+// expires once it falls out of the validation window
+func (dht *DHT) recordValidation(failed bool) error {
+	limits := dht.h.Config().Health
+	window, err := limits.ValidationWindowDuration()
+	if err != nil {
+		return err
+	}
+	bucket := validationBucketOf(time.Now())
+	return dht.db.Update(func(tx *buntdb.Tx) error {
+		kinds := []string{"validated"}
+		if failed {
+			kinds = append(kinds, "invalid")
+		}
+		for _, kind := range kinds {
+			k := validationBucketKey(kind, bucket)
+			n, err := getIntVal(k, tx)
+			if err != nil {
+				return err
+			}
+			opts := buntdb.SetOptions{Expires: true, TTL: window + validationBucket}
+			if _, _, err = tx.Set(k, fmt.Sprintf("%d", n+1), &opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recentValidations sums the validation counts of the buckets within the window
+func recentValidations(tx *buntdb.Tx, kind string, window time.Duration) (n int, err error) {
+	now := time.Now()
+	last := validationBucketOf(now)
+	for b := validationBucketOf(now.Add(-window)) + 1; b <= last; b++ {
+		var c int
+		if c, err = getIntVal(validationBucketKey(kind, b), tx); err != nil {
+			return
+		}
+		n += c
+	}
+	return
+}
+
+// dirSize returns the total size of the files in a directory tree
+func dirSize(path string) (size int64, err error) {
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// Health computes the health of the chain and checks it against the configured limits
+func (h *Holochain) Health() (hl Health, err error) {
+	hl.Started = h.Started()
+	limits := h.Config().Health
+	if hl.ValidationWindow, err = limits.ValidationWindowDuration(); err != nil {
+		return
+	}
+	if hl.DiskBytes, err = dirSize(h.path); err != nil {
+		return
+	}
+	if h.dht != nil {
+		err = h.dht.db.View(func(tx *buntdb.Tx) error {
+			if v, e := tx.Get("_gossiped"); e == nil {
+				if t, e := strconv.ParseInt(v, 10, 64); e == nil {
+					hl.LastGossip = time.Unix(0, t)
+				}
+			}
+			var e error
+			if hl.Validations, e = recentValidations(tx, "validated", hl.ValidationWindow); e != nil {
+				return e
+			}
+			hl.ValidationFailures, e = recentValidations(tx, "invalid", hl.ValidationWindow)
+			return e
+		})
+		if err != nil {
+			return
+		}
+		var pubs []Publication
+		if pubs, err = h.dht.Publications(true); err != nil {
+			return
+		}
+		hl.PublishBacklog = len(pubs)
+		var glist []Gossiper
+		if glist, err = h.dht.Gossipers(); err != nil {
+			return
+		}
+		hl.Gossipers = len(glist)
+	}
+	if hl.Validations > 0 {
+		hl.ValidationErrorRate = float64(hl.ValidationFailures) / float64(hl.Validations)
+	}
+
+	if !hl.Started {
+		hl.Problems = append(hl.Problems, "chain not started")
+	}
+	var staleAfter time.Duration
+	if staleAfter, err = limits.GossipStaleDuration(); err != nil {
+		return
+	}
+	if hl.Gossipers > 0 {
+		if hl.LastGossip.IsZero() {
+			hl.Problems = append(hl.Problems, "gossip has never succeeded")
+		} else if since := time.Since(hl.LastGossip); since > staleAfter {
+			hl.Problems = append(hl.Problems, fmt.Sprintf("behind on gossip, last successful round %v ago", since/time.Second*time.Second))
+		}
+	}
+	maxBacklog := limits.MaxPublishBacklog
+	if maxBacklog <= 0 {
+		maxBacklog = DefaultMaxPublishBacklog
+	}
+	if hl.PublishBacklog > maxBacklog {
+		hl.Problems = append(hl.Problems, fmt.Sprintf("publish backlog of %d exceeds %d", hl.PublishBacklog, maxBacklog))
+	}
+	maxRate := limits.MaxValidationErrorRate
+	if maxRate <= 0 {
+		maxRate = DefaultMaxValidationErrorRate
+	}
+	if hl.Validations >= minValidationsForRate && hl.ValidationErrorRate > maxRate {
+		hl.Problems = append(hl.Problems, fmt.Sprintf("validation error rate of %.0f%% exceeds %.0f%%", hl.ValidationErrorRate*100, maxRate*100))
+	}
+	if limits.MaxDiskBytes > 0 && hl.DiskBytes > limits.MaxDiskBytes {
+		hl.Problems = append(hl.Problems, fmt.Sprintf("disk usage of %d bytes exceeds %d", hl.DiskBytes, limits.MaxDiskBytes))
+	}
+	return
+}
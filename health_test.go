@@ -0,0 +1,93 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("a fresh chain should be healthy", t, func() {
+		hl, err := h.Health()
+		So(err, ShouldBeNil)
+		So(hl.Started, ShouldBeTrue)
+		So(hl.DiskBytes, ShouldBeGreaterThan, 0)
+		So(hl.Degraded(), ShouldBeFalse)
+	})
+
+	Convey("it should count validations and their failures", t, func() {
+		hl, _ := h.Health()
+		n := hl.Validations
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		_, err = h.Commit("myData", "3")
+		So(err, ShouldNotBeNil)
+		hl, _ = h.Health()
+		So(hl.Validations, ShouldEqual, n+2)
+		So(hl.ValidationFailures, ShouldEqual, 1)
+	})
+
+	Convey("a high validation error rate should degrade the chain", t, func() {
+		for i := 0; i < minValidationsForRate; i++ {
+			h.dht.recordValidation(true)
+		}
+		hl, err := h.Health()
+		So(err, ShouldBeNil)
+		So(hl.Degraded(), ShouldBeTrue)
+		So(hl.Problems[0], ShouldStartWith, "validation error rate of")
+		h.config.Health.MaxValidationErrorRate = 1
+		hl, _ = h.Health()
+		So(hl.Degraded(), ShouldBeFalse)
+	})
+
+	Convey("falling behind on gossip should degrade the chain", t, func() {
+		h.dht.UpdateGossiper(h.node.HashAddr, 0)
+		hl, _ := h.Health()
+		So(hl.Problems, ShouldResemble, []string{"gossip has never succeeded"})
+		So(h.dht.recordGossip(), ShouldBeNil)
+		hl, _ = h.Health()
+		So(hl.Degraded(), ShouldBeFalse)
+		So(time.Since(hl.LastGossip), ShouldBeLessThan, time.Minute)
+		h.config.Health.GossipStaleAfter = "1ns"
+		hl, _ = h.Health()
+		So(hl.Problems[0], ShouldStartWith, "behind on gossip")
+		h.config.Health.GossipStaleAfter = ""
+	})
+
+	Convey("exceeding the disk limit should degrade the chain", t, func() {
+		h.config.Health.MaxDiskBytes = 1
+		hl, _ := h.Health()
+		So(hl.Problems[0], ShouldStartWith, "disk usage of")
+		h.config.Health.MaxDiskBytes = 0
+	})
+
+	Convey("only validations within the window should count", t, func() {
+		hl, _ := h.Health()
+		So(hl.ValidationWindow, ShouldEqual, time.Hour)
+		n := hl.Validations
+		old := validationBucketOf(time.Now().Add(-2 * time.Hour))
+		h.dht.db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(validationBucketKey("validated", old), "1000", nil)
+			return err
+		})
+		hl, _ = h.Health()
+		So(hl.Validations, ShouldEqual, n)
+		h.config.Health.ValidationWindow = "3h"
+		hl, _ = h.Health()
+		So(hl.Validations, ShouldEqual, n+1000)
+		h.config.Health.ValidationWindow = ""
+	})
+
+	Convey("bad limits should be rejected", t, func() {
+		l := HealthLimits{GossipStaleAfter: "fish"}
+		_, err := l.GossipStaleDuration()
+		So(err.Error(), ShouldStartWith, "bad gossip stale duration")
+		l = HealthLimits{ValidationWindow: "fish"}
+		_, err = l.ValidationWindowDuration()
+		So(err.Error(), ShouldStartWith, "bad validation window")
+	})
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// heartbeat implements nodes periodically sending their peers a signed statement of their
+// current chain head, so that the liveness of agents can be known for presence features.
+// Nodes relay heartbeats newer than any they hold from the agent on to their own peers, for
+// up to MaxHeartbeatHops hops, so that liveness is known beyond an agent's direct neighbors.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHeartbeatInterval = 30 * time.Second
+
+	// LivenessWindow is how long after its last heartbeat an agent is still considered alive
+	LivenessWindow = 3 * DefaultHeartbeatInterval
+
+	// MaxHeartbeatHops is how many times a heartbeat is relayed on from its agent's neighbors
+	MaxHeartbeatHops = 3
+)
+
+var ErrDHTExpectedHeartbeatInBody error = errors.New("expected heartbeat")
+var ErrNoHeartbeat error = errors.New("no heartbeat received from agent")
+
+// Heartbeat is a node's signed statement of its chain head at a point in time
+type Heartbeat struct {
+	Id     peer.ID
+	Head   Hash
+	Time   time.Time
+	PubKey []byte
+	Sig    []byte
+	Hops   int // how many times the heartbeat has been relayed, which isn't signed
+}
+
+// Alive returns true if the heartbeat was sent recently enough for its agent to be considered
+// alive
+func (hb *Heartbeat) Alive() bool {
+	return time.Since(hb.Time) < LivenessWindow
+}
+
+func (hb *Heartbeat) signedBytes() ([]byte, error) {
+	return ByteEncoder(Heartbeat{Id: hb.Id, Head: hb.Head, Time: hb.Time})
+}
+
+// NewHeartbeat returns a signed heartbeat for the current head of the chain
+func (h *Holochain) NewHeartbeat() (hb *Heartbeat, err error) {
+	beat := Heartbeat{Id: h.id, Time: time.Now()}
+	if top := h.chain.Top(); top != nil {
+		beat.Head = top.EntryLink
+	}
+	priv := h.Agent().PrivKey()
+	beat.PubKey, err = ic.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = beat.signedBytes()
+	if err != nil {
+		return
+	}
+	beat.Sig, err = priv.Sign(b)
+	if err != nil {
+		return
+	}
+	hb = &beat
+	return
+}
+
+// verify checks that the heartbeat was signed by the agent it claims to be from
+func (hb *Heartbeat) verify() (err error) {
+	var pub ic.PubKey
+	pub, err = ic.UnmarshalPublicKey(hb.PubKey)
+	if err != nil {
+		return
+	}
+	if !hb.Id.MatchesPublicKey(pub) {
+		err = fmt.Errorf("heartbeat from %v signed with another key", hb.Id)
+		return
+	}
+	var b []byte
+	b, err = hb.signedBytes()
+	if err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(b, hb.Sig)
+	if err == nil && !ok {
+		err = fmt.Errorf("bad heartbeat signature from %v", hb.Id)
+	}
+	return
+}
+
+func heartbeatKey(id peer.ID) string {
+	return "beat:" + peer.IDB58Encode(id)
+}
+
+// putHeartbeat verifies a heartbeat and stores it if it is newer than the last one from its
+// agent, returning whether it was
+func (dht *DHT) putHeartbeat(hb *Heartbeat) (fresh bool, err error) {
+	if err = hb.verify(); err != nil {
+		return
+	}
+	if hb.Time.After(time.Now().Add(time.Minute)) {
+		err = fmt.Errorf("heartbeat from %v is from the future", hb.Id)
+		return
+	}
+	var b []byte
+	b, err = json.Marshal(hb)
+	if err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if val, err := tx.Get(heartbeatKey(hb.Id)); err == nil {
+			var last Heartbeat
+			if err = json.Unmarshal([]byte(val), &last); err == nil && !hb.Time.After(last.Time) {
+				return nil
+			}
+		}
+		_, _, err := tx.Set(heartbeatKey(hb.Id), string(b), nil)
+		fresh = err == nil
+		return err
+	})
+	return
+}
+
+// relayHeartbeat sends a heartbeat on to the known gossipers other than the node it came
+// from and its agent, unless it has been relayed as far as it may go
+func (dht *DHT) relayHeartbeat(hb Heartbeat, from peer.ID) {
+	if hb.Hops >= MaxHeartbeatHops {
+		return
+	}
+	hb.Hops++
+	glist, err := dht.Gossipers()
+	if err != nil {
+		dht.glog.Logf("error relaying heartbeat: %v", err)
+		return
+	}
+	for _, g := range glist {
+		if g.Id == from || g.Id == hb.Id || dht.h.peerLacks(g.Id, FeatureHeartbeat) {
+			continue
+		}
+		if _, e := dht.send(g.Id, HEARTBEAT, hb); e != nil {
+			dht.glog.Logf("relaying heartbeat of %v to %v failed: %v", hb.Id, g.Id, e)
+		}
+	}
+}
+
+// GetHeartbeat returns the latest heartbeat received from an agent
+func (dht *DHT) GetHeartbeat(id peer.ID) (hb Heartbeat, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(heartbeatKey(id))
+		if err == buntdb.ErrNotFound {
+			return ErrNoHeartbeat
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(val), &hb)
+	})
+	return
+}
+
+// Heartbeats returns the latest heartbeat received from each agent
+func (dht *DHT) Heartbeats() (beats []Heartbeat, err error) {
+	beats = make([]Heartbeat, 0)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("beat:*", func(key, value string) bool {
+			var hb Heartbeat
+			if e = json.Unmarshal([]byte(value), &hb); e != nil {
+				return false
+			}
+			beats = append(beats, hb)
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// SendHeartbeat sends a heartbeat to each of the known gossipers, returning how many
+// accepted it
+func (dht *DHT) SendHeartbeat() (n int, err error) {
+	var hb *Heartbeat
+	hb, err = dht.h.NewHeartbeat()
+	if err != nil {
+		return
+	}
+	var glist []Gossiper
+	glist, err = dht.Gossipers()
+	if err != nil {
+		return
+	}
+	for _, g := range glist {
+		if dht.h.peerLacks(g.Id, FeatureHeartbeat) {
+			continue
+		}
+		if _, e := dht.send(g.Id, HEARTBEAT, *hb); e != nil {
+			dht.glog.Logf("heartbeat to %v failed: %v", g.Id, e)
+			continue
+		}
+		n++
+	}
+	return
+}
+
+// HeartbeatEvery sends a heartbeat to the known gossipers every interval
+func (dht *DHT) HeartbeatEvery(interval time.Duration) {
+	for {
+		if _, err := dht.SendHeartbeat(); err != nil {
+			dht.glog.Logf("heartbeat error: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Liveness returns the latest chain head and heartbeat time of the agent with the given node
+// id, and whether it is considered alive
+func (h *Holochain) Liveness(id string) (head Hash, at time.Time, alive bool, err error) {
+	var pid peer.ID
+	pid, err = peer.IDB58Decode(strings.TrimSpace(id))
+	if err != nil {
+		return
+	}
+	if pid == h.id {
+		if top := h.chain.Top(); top != nil {
+			head = top.EntryLink
+		}
+		at, alive = time.Now(), true
+		return
+	}
+	var hb Heartbeat
+	hb, err = h.dht.GetHeartbeat(pid)
+	if err != nil {
+		return
+	}
+	head, at, alive = hb.Head, hb.Time, hb.Alive()
+	return
+}
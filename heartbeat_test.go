@@ -0,0 +1,105 @@
+package holochain
+
+import (
+	"encoding/json"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeHeartbeat(seed string, head Hash, at time.Time) *Heartbeat {
+	key, _, _ := ic.GenerateEd25519Key(strings.NewReader(seed + "1234567890123456789012345678901234567890"))
+	id, _ := peer.IDFromPrivateKey(key)
+	hb := Heartbeat{Id: id, Head: head, Time: at}
+	hb.PubKey, _ = ic.MarshalPublicKey(key.GetPublic())
+	b, _ := hb.signedBytes()
+	hb.Sig, _ = key.Sign(b)
+	return &hb
+}
+
+func TestHeartbeat(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	Convey("it should sign heartbeats of the chain head", t, func() {
+		hb, err := h.NewHeartbeat()
+		So(err, ShouldBeNil)
+		So(hb.Id, ShouldEqual, h.id)
+		So(hb.Head.String(), ShouldEqual, h.chain.Top().EntryLink.String())
+		So(hb.verify(), ShouldBeNil)
+		So(hb.Alive(), ShouldBeTrue)
+
+		hb.Time = hb.Time.Add(time.Second)
+		So(hb.verify().Error(), ShouldStartWith, "bad heartbeat signature")
+	})
+
+	now := time.Unix(time.Now().Unix(), 0)
+	hb := makeHeartbeat("peer", h.agentHash, now)
+
+	Convey("it should store the latest heartbeat of each agent", t, func() {
+		_, err := dht.GetHeartbeat(hb.Id)
+		So(err, ShouldEqual, ErrNoHeartbeat)
+
+		fresh, err := dht.putHeartbeat(hb)
+		So(err, ShouldBeNil)
+		So(fresh, ShouldBeTrue)
+		older := makeHeartbeat("peer", h.dnaHash, now.Add(-time.Minute))
+		fresh, err = dht.putHeartbeat(older)
+		So(err, ShouldBeNil)
+		So(fresh, ShouldBeFalse)
+
+		last, err := dht.GetHeartbeat(hb.Id)
+		So(err, ShouldBeNil)
+		So(last.Head.String(), ShouldEqual, h.agentHash.String())
+		So(last.Time.Equal(now), ShouldBeTrue)
+
+		beats, err := dht.Heartbeats()
+		So(err, ShouldBeNil)
+		So(len(beats), ShouldEqual, 1)
+
+		_, err = dht.putHeartbeat(makeHeartbeat("peer", h.agentHash, now.Add(time.Hour)))
+		So(err.Error(), ShouldEndWith, "is from the future")
+	})
+
+	Convey("it should accept heartbeats relayed from other nodes if signed by their agent", t, func() {
+		relayed := makeHeartbeat("other", h.agentHash, now)
+		relayed.Hops = 1
+		_, err := DHTReceiver(h, h.node.NewMessage(HEARTBEAT, *relayed))
+		So(err, ShouldBeNil)
+		last, err := dht.GetHeartbeat(relayed.Id)
+		So(err, ShouldBeNil)
+		So(last.Time.Equal(now), ShouldBeTrue)
+
+		forged := makeHeartbeat("other", h.dnaHash, now.Add(time.Second))
+		forged.Head = h.agentHash
+		_, err = DHTReceiver(h, h.node.NewMessage(HEARTBEAT, *forged))
+		So(err.Error(), ShouldStartWith, "bad heartbeat signature")
+	})
+
+	Convey("it should report agent liveness to zome code", t, func() {
+		result, err := h.Call(SystemZomeName, "liveness", peer.IDB58Encode(hb.Id))
+		So(err, ShouldBeNil)
+		var l map[string]interface{}
+		json.Unmarshal([]byte(result.(string)), &l)
+		So(l["Head"], ShouldEqual, h.agentHash.String())
+		So(l["Alive"], ShouldBeTrue)
+
+		old := makeHeartbeat("old", h.agentHash, now.Add(-LivenessWindow))
+		dht.putHeartbeat(old)
+		_, _, alive, err := h.Liveness(peer.IDB58Encode(old.Id))
+		So(err, ShouldBeNil)
+		So(alive, ShouldBeFalse)
+
+		_, _, alive, err = h.Liveness(peer.IDB58Encode(h.id))
+		So(err, ShouldBeNil)
+		So(alive, ShouldBeTrue)
+
+		unknown, _ := makePeer("unknown")
+		_, _, _, err = h.Liveness(peer.IDB58Encode(unknown))
+		So(err, ShouldEqual, ErrNoHeartbeat)
+	})
+}
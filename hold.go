@@ -0,0 +1,300 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// hold implements maintaining the resilience of the DHT.  Rather than waiting for gossip to
+// spread them, a node that holds entries known to be held by fewer nodes than the DNA's
+// resilience calls for sends signed hold requests asking its peers to hold copies.  A peer
+// that accepts fetches the entry from its source and validates it, just as for any put, and
+// each peer limits how many hold requests it accepts from any one node.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHoldInterval = 10 * time.Minute
+
+	// MaxHoldRequestsPerRound is the most hold requests a node sends each time it checks how
+	// widely its entries are held
+	MaxHoldRequestsPerRound = 32
+
+	// MaxHoldRequestsPerPeer is the most hold requests accepted from a node in each
+	// HoldRequestWindow
+	MaxHoldRequestsPerPeer = 64
+	HoldRequestWindow      = time.Minute
+)
+
+var ErrDHTExpectedHoldReqInBody error = errors.New("expected hold request")
+var ErrHoldRateLimited error = errors.New("too many hold requests")
+
+// HoldReq is a node's signed request that a peer hold a copy of an entry
+type HoldReq struct {
+	H      Hash
+	Source peer.ID // the entry's source, from which the entry is fetched and validated
+	From   peer.ID
+	Time   time.Time
+	PubKey []byte
+	Sig    []byte
+}
+
+func (r *HoldReq) signedBytes() ([]byte, error) {
+	return ByteEncoder(HoldReq{H: r.H, Source: r.Source, From: r.From, Time: r.Time})
+}
+
+// NewHoldReq returns a signed request that a peer hold the entry with the given source
+func (h *Holochain) NewHoldReq(key Hash, source peer.ID) (r *HoldReq, err error) {
+	req := HoldReq{H: key, Source: source, From: h.id, Time: time.Now()}
+	priv := h.Agent().PrivKey()
+	req.PubKey, err = ic.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = req.signedBytes()
+	if err != nil {
+		return
+	}
+	req.Sig, err = priv.Sign(b)
+	if err != nil {
+		return
+	}
+	r = &req
+	return
+}
+
+// verify checks that the hold request was signed by the node it claims to be from
+func (r *HoldReq) verify() (err error) {
+	var pub ic.PubKey
+	pub, err = ic.UnmarshalPublicKey(r.PubKey)
+	if err != nil {
+		return
+	}
+	if !r.From.MatchesPublicKey(pub) {
+		err = fmt.Errorf("hold request from %v signed with another key", r.From)
+		return
+	}
+	var b []byte
+	b, err = r.signedBytes()
+	if err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(b, r.Sig)
+	if err == nil && !ok {
+		err = fmt.Errorf("bad hold request signature from %v", r.From)
+	}
+	return
+}
+
+// holdLimiter counts the hold requests accepted from each node in the current window
+type holdLimiter struct {
+	lk     sync.Mutex
+	counts map[peer.ID]holdCount
+}
+
+type holdCount struct {
+	start time.Time
+	n     int
+}
+
+// allow returns true if another hold request may be accepted from the node
+func (l *holdLimiter) allow(id peer.ID) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if l.counts == nil {
+		l.counts = make(map[peer.ID]holdCount)
+	}
+	now := time.Now()
+	c := l.counts[id]
+	if now.Sub(c.start) >= HoldRequestWindow {
+		c = holdCount{start: now}
+	}
+	if c.n >= MaxHoldRequestsPerPeer {
+		return false
+	}
+	c.n++
+	l.counts[id] = c
+	return true
+}
+
+// handleHoldReq decides whether to accept a hold request, queuing a put of the entry from its
+// source if it isn't already held
+func (dht *DHT) handleHoldReq(from peer.ID, r *HoldReq) (response interface{}, err error) {
+	if r.From != from {
+		err = fmt.Errorf("hold request for %v sent by %v", r.From, from)
+		return
+	}
+	if err = r.verify(); err != nil {
+		return
+	}
+	if age := time.Since(r.Time); age > HoldRequestWindow || age < -HoldRequestWindow {
+		err = fmt.Errorf("stale hold request from %v", from)
+		return
+	}
+	if !dht.holdLimits.allow(from) {
+		dht.h.metrics.Inc("hold.limited", 1)
+		err = ErrHoldRateLimited
+		return
+	}
+	if dht.isRedacted(r.H) {
+		err = ErrEntryRedacted
+		return
+	}
+	if dht.isQuarantined(r.H) {
+		err = ErrEntryQuarantined
+		return
+	}
+	if dht.exists(r.H) == nil {
+		dht.h.metrics.Inc("hold.held", 1)
+		response = "held"
+		return
+	}
+	m := Message{Type: PUT_REQUEST, Time: time.Now(), From: r.Source, Body: PutReq{H: r.H}}
+	if response, err = dht.queuePut(&m); err == nil {
+		dht.h.metrics.Inc("hold.accepted", 1)
+		dht.dlog.Logf("accepted hold request for %v from %v", r.H, from)
+	}
+	return
+}
+
+// underReplicated is an entry held by fewer nodes than the resilience calls for
+type underReplicated struct {
+	key        Hash
+	source     peer.ID
+	holders    map[peer.ID]bool
+	entryType  string
+	resilience int // how many nodes should hold the entry
+}
+
+// underReplicated returns the live entries held that are known to be held by fewer nodes than
+// the resilience calls for
+func (dht *DHT) underReplicated() (entries []underReplicated, err error) {
+	return dht.heldByTooFew("")
+}
+
+// heldByTooFew returns the live entries held that are known to be held by fewer nodes than the
+// resilience calls for once the leaving node, if any, no longer holds them
+func (dht *DHT) heldByTooFew(leaving peer.ID) (entries []underReplicated, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			var s string
+			if s, e = tx.Get("status:" + k); e != nil {
+				return false
+			}
+			if status, err := strconv.Atoi(s); err != nil || status != LIVE {
+				return true
+			}
+			t, _ := tx.Get("type:" + k)
+			u := underReplicated{holders: make(map[peer.ID]bool), entryType: t, resilience: dht.h.resilienceOf(t)}
+			tx.AscendKeys("holder:"+k+":*", func(key, value string) bool {
+				if id, err := peer.IDB58Decode(strings.TrimPrefix(key, "holder:"+k+":")); err == nil && id != leaving {
+					u.holders[id] = true
+				}
+				return true
+			})
+			if len(u.holders) >= u.resilience {
+				return true
+			}
+			if u.key, e = NewHash(k); e != nil {
+				return false
+			}
+			var src string
+			if src, e = tx.Get("src:" + k); e != nil {
+				return false
+			}
+			if u.source, e = peer.IDB58Decode(src); e != nil {
+				return false
+			}
+			entries = append(entries, u)
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// MaintainResilience sends hold requests to peers for the entries held that are known to be
+// held by too few nodes, sending at most MaxHoldRequestsPerRound.  It returns how many
+// requests the peers accepted.
+func (dht *DHT) MaintainResilience() (accepted int, err error) {
+	if dht.h.Offline() {
+		return
+	}
+	var entries []underReplicated
+	if entries, err = dht.underReplicated(); err != nil {
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	var glist []Gossiper
+	if glist, err = dht.Gossipers(); err != nil {
+		return
+	}
+	var peers []peer.ID
+	for _, g := range glist {
+		r, e := dht.GetPeerRecord(g.Id)
+		if e != nil || r.Banned() || r.Unreachable || dht.h.peerLacks(g.Id, FeatureHold) {
+			continue
+		}
+		peers = append(peers, g.Id)
+	}
+
+	sent := 0
+	for _, u := range entries {
+		need := u.resilience - len(u.holders)
+		for _, i := range rand.Perm(len(peers)) {
+			if need <= 0 || sent >= MaxHoldRequestsPerRound {
+				break
+			}
+			id := peers[i]
+			if u.holders[id] || !dht.h.peerHolds(id, u.entryType) {
+				continue
+			}
+			var req *HoldReq
+			if req, err = dht.h.NewHoldReq(u.key, u.source); err != nil {
+				return
+			}
+			sent++
+			dht.h.metrics.Inc("hold.sent", 1)
+			if _, e := dht.send(id, HOLD_REQUEST, *req); e != nil {
+				dht.dlog.Logf("hold request for %v refused by %v: %v", u.key, id, e)
+				dht.h.metrics.Inc("hold.refused", 1)
+				continue
+			}
+			if err = dht.AddHolder(u.key, id); err != nil {
+				return
+			}
+			accepted++
+			need--
+		}
+		if sent >= MaxHoldRequestsPerRound {
+			break
+		}
+	}
+	return
+}
+
+// MaintainResilienceEvery asks peers to hold under-replicated entries every interval
+func (dht *DHT) MaintainResilienceEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := dht.MaintainResilience(); err != nil {
+			dht.dlog.Logf("resilience maintenance error: %v", err)
+		}
+	}
+}
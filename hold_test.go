@@ -0,0 +1,102 @@
+package holochain
+
+import (
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedHoldReq(name string, key Hash, source peer.ID, at time.Time) (r *HoldReq) {
+	reader := strings.NewReader(name + "1234567890123456789012345678901234567890")
+	priv, _, _ := ic.GenerateEd25519Key(reader)
+	id, _ := peer.IDFromPrivateKey(priv)
+	r = &HoldReq{H: key, Source: source, From: id, Time: at}
+	r.PubKey, _ = ic.MarshalPublicKey(priv.GetPublic())
+	b, _ := r.signedBytes()
+	r.Sig, _ = priv.Sign(b)
+	return
+}
+
+func TestHoldRequests(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	for len(dht.puts) > 0 {
+		<-dht.puts
+	}
+
+	hash, _ := h.Commit("myData", "2")
+	e := GobEntry{C: "2"}
+	b, _ := e.Marshal()
+	dht.put(nil, "myData", hash, h.id, b, LIVE)
+	other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	Convey("hold requests should be signed by the requesting node", t, func() {
+		r, err := h.NewHoldReq(hash, h.id)
+		So(err, ShouldBeNil)
+		So(r.verify(), ShouldBeNil)
+		r.H = other
+		So(r.verify().Error(), ShouldStartWith, "bad hold request signature")
+	})
+
+	Convey("it should find entries held by too few nodes", t, func() {
+		entries, err := dht.underReplicated()
+		So(err, ShouldBeNil)
+		var found bool
+		for _, u := range entries {
+			if u.key.String() == hash.String() {
+				found = true
+				So(u.source, ShouldEqual, h.id)
+				So(u.holders[h.id], ShouldBeTrue)
+			}
+		}
+		So(found, ShouldBeTrue)
+	})
+
+	Convey("it should accept hold requests by queuing a put from the entry's source", t, func() {
+		r := signedHoldReq("requester", other, h.id, time.Now())
+		resp, err := dht.handleHoldReq(r.From, r)
+		So(err, ShouldBeNil)
+		So(resp, ShouldEqual, "queued")
+		So(len(dht.puts), ShouldEqual, 1)
+		m := <-dht.puts
+		So(m.From, ShouldEqual, h.id)
+		So(m.Body.(PutReq).H.String(), ShouldEqual, other.String())
+		So(h.Metrics().Get("hold.accepted"), ShouldEqual, 1)
+
+		r = signedHoldReq("requester", hash, h.id, time.Now())
+		resp, err = dht.handleHoldReq(r.From, r)
+		So(err, ShouldBeNil)
+		So(resp, ShouldEqual, "held")
+		So(len(dht.puts), ShouldEqual, 0)
+	})
+
+	Convey("it should reject forged and stale hold requests", t, func() {
+		r := signedHoldReq("requester", other, h.id, time.Now())
+		_, err := dht.handleHoldReq(h.id, r)
+		So(err.Error(), ShouldStartWith, "hold request for")
+
+		r = signedHoldReq("requester", other, h.id, time.Now().Add(-2*HoldRequestWindow))
+		_, err = dht.handleHoldReq(r.From, r)
+		So(err.Error(), ShouldStartWith, "stale hold request")
+	})
+
+	Convey("it should limit the hold requests accepted from a node", t, func() {
+		var err error
+		for i := 0; i <= MaxHoldRequestsPerPeer && err == nil; i++ {
+			r := signedHoldReq("greedy", hash, h.id, time.Now())
+			_, err = dht.handleHoldReq(r.From, r)
+		}
+		So(err, ShouldEqual, ErrHoldRateLimited)
+		So(h.Metrics().Get("hold.limited"), ShouldEqual, 1)
+	})
+
+	Convey("it should not send hold requests without suitable peers", t, func() {
+		accepted, err := dht.MaintainResilience()
+		So(err, ShouldBeNil)
+		So(accepted, ShouldEqual, 0)
+	})
+}
@@ -24,27 +24,42 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
 const Version int = 3
 const VersionStr string = "3"
 
+// DevAgentsDir is the sub-directory of a chain where development agents are provisioned
+const DevAgentsDir = "agents"
+
+var ErrDNAHashMismatch error = errors.New("DNA hash does not match")
+var ErrNotDevMode error = errors.New("only available for chains in development mode")
+var ErrGenesisPending error = errors.New("chain genesis hasn't been published")
+var ErrGenesisPublished error = errors.New("chain genesis already published")
+
 // AgentEntry structure for building KeyEntryType entries
 type AgentEntry struct {
-	Name    AgentName
-	KeyType KeytypeType
-	Key     []byte // marshaled public key
+	Name     AgentName
+	KeyType  KeytypeType
+	Key      []byte             // marshaled public key
+	Join     *JoinProof         // the agent's solution to the DNA's join puzzle, if it declares one
+	Identity *IdentityAssertion `json:",omitempty"` // the agent's attested identity, if the DNA requires one
 }
 
 // Zome struct encapsulates logically related code, from "chromosome"
 type Zome struct {
-	Name        string
-	Description string
-	Code        string // file name of DNA code
-	CodeHash    Hash
-	Entries     map[string]EntryDef
-	NucleusType string
+	Name          string
+	Description   string
+	Code          string // file name of DNA code
+	CodeHash      Hash
+	Entries       map[string]EntryDef
+	NucleusType   string
+	Functions     []FunctionDef // optional declarations of exposed function types
+	Libraries     []string      `json:",omitempty" toml:",omitempty"` // code hashes of shared libraries loaded before the zome's code
+	Subscriptions []Query       `json:",omitempty" toml:",omitempty"` // queries whose matches are passed to the zome's receive function
+	API           int           `json:",omitempty" toml:",omitempty"` // version of the nucleus API the zome's code is written against, the current one if not set
 }
 
 // Loggers holds the logging structures for the different parts of the system
@@ -55,6 +70,7 @@ type Loggers struct {
 	TestPassed Logger
 	TestFailed Logger
 	TestInfo   Logger
+	Zomes      map[string]*Logger `toml:",omitempty"` // loggers for zome code's debug messages, which otherwise go to App
 }
 
 // Config holds the non-DNA configuration for a holo-chain
@@ -64,18 +80,49 @@ type Config struct {
 	PeerModeDHTNode bool
 	BootstrapServer string
 	Loggers         Loggers
+	Quotas          Quotas
+	DevMode         bool     // enables development only features like calling as other agents
+	Transports      []string // transports to use for talking to other nodes, in order of preference
+	GCRetention     string   // how long rejected and orphaned DHT data is kept before being purged
+	Health          HealthLimits
+	EncryptChain    bool          // keep the source chain encrypted on disk with a key derived from a passphrase
+	ZomeLogLevel    string        // least level of zome debug messages to log: debug, info, warn or error
+	IdempotencyTTL  string        // how long results of calls with idempotency keys are kept for retries
+	BridgeGrants    []BridgeGrant `toml:",omitempty"` // other chains allowed to read from this chain's DHT store
+	GossipInterval  string        `toml:",omitempty"` // how often to gossip, overriding the interval the node was started with
+	Disabled        bool          `toml:",omitempty"` // the chain is parked and won't be served until enabled again
+	Offline         bool          `toml:",omitempty"` // don't contact other nodes, keeping authored puts in the outbox
+	Notary          *NotaryConfig `toml:",omitempty"` // where to periodically anchor the chain's head
+	Fsync           string        `toml:",omitempty"` // when chain writes are forced to disk: always (the default), interval or never
+	FsyncInterval   string        `toml:",omitempty"` // how often the interval policy forces chain writes to disk, 1s if not set
+	MaxClockSkew    string        `toml:",omitempty"` // how far in the future received headers may be dated, 5m if not set
+	TimeWitnesses   int           `toml:",omitempty"` // how many gossip partners to ask to attest to the time of each commit
+	Integrations    []Integration `toml:",omitempty"` // webhooks and MQTT topics the chain's events are forwarded to
+	Topics          []string      `toml:",omitempty"` // the DNA's topics whose entries this node holds, all entries if not set
+	Relay           *RelayConfig  `toml:",omitempty"` // accept entries authored by thin clients without chains, publishing them for them
+	Backup          *BackupConfig `toml:",omitempty"` // where and how often the chain and its agent's keys are backed up
 }
 
 // Holochain struct holds the full "DNA" of the holochain
 type Holochain struct {
-	Version          int
-	Id               uuid.UUID
-	Name             string
-	Properties       map[string]string
-	PropertiesSchema string
-	HashType         string
-	BasedOn          Hash // holochain hash for base schemas and code
-	Zomes            map[string]*Zome
+	Version           int
+	Id                uuid.UUID
+	Name              string
+	Properties        map[string]string
+	PropertiesSchema  string
+	HashType          string
+	BasedOn           Hash // holochain hash for base schemas and code
+	Zomes             map[string]*Zome
+	Libraries         map[string]*Library `json:",omitempty" toml:",omitempty"`
+	HTTPFetch         *HTTPFetchPolicy    `json:",omitempty" toml:",omitempty"` // hosts zome functions may fetch from
+	Redactors         []string            `json:",omitempty" toml:",omitempty"` // node ids of agents who may redact any entry
+	Resilience        int                 `json:",omitempty" toml:",omitempty"` // how many nodes should hold each entry, DefaultResilience if not set
+	JoinPuzzle        *JoinPuzzle         `json:",omitempty" toml:",omitempty"` // what agents must do to join, to make throwaway agents costly
+	Identity          *IdentityPolicy     `json:",omitempty" toml:",omitempty"` // the attested identity agents must present to join
+	Profiles          *ProfileDef         `json:",omitempty" toml:",omitempty"` // the entry type agents' profiles are, indexed in the agent directory
+	PreferencesSchema string              `json:",omitempty" toml:",omitempty"` // file name of the JSON schema agents' preferences must match
+	AuditLog          bool                `json:",omitempty" toml:",omitempty"` // whether nodes serve a public audit log of their chains' headers
+	Topics            map[string]*Topic   `json:",omitempty" toml:",omitempty"` // groups of entry types that nodes can choose to hold
 	//---- private values not serialized; initialized on Load
 	id             peer.ID // this is hash of the id, also used in the node
 	dnaHash        Hash
@@ -88,6 +135,28 @@ type Holochain struct {
 	dht            *DHT
 	node           *Node
 	chain          *Chain // the chain itself
+	metrics        *Metrics
+	quota          quotaState
+	configLk       *sync.RWMutex // guards config and quota, which a reload replaces; shared by copies
+	events         *Events
+	coverage       *Coverage
+	scope          *APIToken // if set, limits calls and commits to those the token allows
+	caller         *Caller   // who zome calls are made by, the chain's own agent if nil
+	deps           *depResolver
+	idempotency    *idempotency
+	service        *Service       // the service that loaded the chain, if any
+	tracer         func(HostCall) // if set, called with each host function call zome code makes
+	progressFn     func(Progress) // if set, called as long running operations make progress
+	progressState  *progressState
+	nucleusBusy    *nucleusBusy
+	hooks          *commitHooks
+	prefsDef       *EntryDef         // the preferences schema's validator
+	topics         map[string]string // the topic of each entry type in one
+	invite         *Invite           // presented to join at genesis, if the join puzzle needs one
+	identity       IdentityProvider  // where the agent's identity assertion is fetched from at genesis
+	shuttingDown   int32             // set atomically once Shutdown begins
+	stop           chan struct{}     // closed once Shutdown begins, to stop background work
+	receiving      bool              // set on the copy zome receive functions are called through
 }
 
 var debugLog Logger
@@ -112,6 +181,7 @@ func Infof(m string, args ...interface{}) {
 // Register function that must be called once at startup by any client app
 func Register() {
 	gob.Register(Header{})
+	gob.Register(SourceHeader{})
 	gob.Register(AgentEntry{})
 	gob.Register(Hash{})
 	gob.Register(PutReq{})
@@ -120,13 +190,26 @@ func Register() {
 	gob.Register(MetaQuery{})
 	gob.Register(GossipReq{})
 	gob.Register(Gossip{})
+	gob.Register(HoldReq{})
 	gob.Register(ValidateResponse{})
 	gob.Register(Put{})
 	gob.Register(GobEntry{})
+	gob.Register(PrunedEntry{})
 	gob.Register(MetaQueryResp{})
 	gob.Register(MetaEntry{})
+	gob.Register(DNABundle{})
+	gob.Register(DHTSnapshot{})
+	gob.Register(Heartbeat{})
+	gob.Register(Handshake{})
+	gob.Register(PeerExchange{})
+	gob.Register(RangeTransfer{})
+	gob.Register([]Hash{})
+	gob.Register(TimeAttestReq{})
+	gob.Register(TimeAttestation{})
+	gob.Register(CountersignReq{})
+	gob.Register(Countersignature{})
+	gob.Register(CountersignComplete{})
 
-	RegisterBultinNucleii()
 	RegisterBultinPersisters()
 
 	infoLog.New(nil)
@@ -136,30 +219,16 @@ func Register() {
 }
 
 func findDNA(path string) (f string, err error) {
-	p := path + "/" + DNAFileName
-	matches, err := filepath.Glob(p + ".*")
+	f, err = findEncodedFile(path, DNAFileName)
 	if err != nil {
-		return
-	}
-	for _, fn := range matches {
-		s := strings.Split(fn, ".")
-		f = s[len(s)-1]
-		if f == "json" || f == "yaml" || f == "toml" {
-			break
-		}
-		f = ""
-	}
-
-	if f == "" {
 		err = errors.New("DNA not found")
-		return
 	}
 	return
 }
 
 // IsConfigured checks a directory for correctly set up holochain configuration files
 func (s *Service) IsConfigured(name string) (f string, err error) {
-	path := s.Path + "/" + name
+	path := filepath.Join(s.Path, name)
 
 	f, err = findDNA(path)
 	if err != nil {
@@ -167,7 +236,7 @@ func (s *Service) IsConfigured(name string) (f string, err error) {
 	}
 
 	/*	// found a format now check that there's a store
-		p := path + "/" + StoreFileName + ".db"
+		p := filepath.Join(path, StoreFileName+".db")
 		if !fileExists(p) {
 			err = errors.New("chain store missing: " + p)
 			return
@@ -198,6 +267,15 @@ func NewHolochain(agent Agent, path string, format string, zomes ...Zome) Holoch
 		agent:          agent,
 		path:           path,
 		encodingFormat: format,
+		metrics:        NewMetrics(),
+		events:         NewEvents(),
+		deps:           newDepResolver(),
+		idempotency:    newIdempotency(),
+		progressState:  newProgressState(),
+		nucleusBusy:    newNucleusBusy(),
+		stop:           make(chan struct{}),
+		hooks:          newCommitHooks(),
+		configLk:       new(sync.RWMutex),
 	}
 
 	// once the agent is set up we can calculate the id
@@ -225,6 +303,15 @@ func DecodeDNA(reader io.Reader, format string) (hP *Holochain, err error) {
 	}
 	hP = &h
 	hP.encodingFormat = format
+	hP.metrics = NewMetrics()
+	hP.events = NewEvents()
+	hP.deps = newDepResolver()
+	hP.idempotency = newIdempotency()
+	hP.progressState = newProgressState()
+	hP.nucleusBusy = newNucleusBusy()
+	hP.stop = make(chan struct{})
+	hP.hooks = newCommitHooks()
+	hP.configLk = new(sync.RWMutex)
 
 	return
 }
@@ -232,9 +319,9 @@ func DecodeDNA(reader io.Reader, format string) (hP *Holochain, err error) {
 // load unmarshals a holochain structure for the named chain and format
 func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 
-	path := s.Path + "/" + name
+	path := filepath.Join(s.Path, name)
 	var f *os.File
-	f, err = os.Open(path + "/" + DNAFileName + "." + format)
+	f, err = os.Open(filepath.Join(path, DNAFileName+"."+format))
 	if err != nil {
 		return
 	}
@@ -245,14 +332,19 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	}
 	h.path = path
 	h.encodingFormat = format
+	h.service = s
 
-	// load the config
-	f, err = os.Open(path + "/" + ConfigFileName + "." + format)
+	// load the config, which may have been written in a different format from the DNA
+	configFormat, err := findEncodedFile(path, ConfigFileName)
+	if err != nil {
+		return
+	}
+	f, err = os.Open(filepath.Join(path, ConfigFileName+"."+configFormat))
 	if err != nil {
 		return
 	}
 	defer f.Close()
-	err = Decode(f, format, &h.config)
+	err = Decode(f, configFormat, &h.config)
 	if err != nil {
 		return
 	}
@@ -264,7 +356,7 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	agent, err := LoadAgent(path)
 	if err != nil {
 		// get the default if not available
-		agent, err = LoadAgent(filepath.Dir(path))
+		agent, err = LoadAgent(s.Dirs().Config)
 	}
 	if err != nil {
 		return
@@ -277,7 +369,7 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 
-	/*	h.store, err = CreatePersister(BoltPersisterName, path+"/"+StoreFileName+".db")
+	/*	h.store, err = CreatePersister(BoltPersisterName, filepath.Join(path, StoreFileName+".db"))
 		if err != nil {
 			return
 		}
@@ -291,10 +383,22 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, path+"/"+StoreFileName+".dat")
+	chainPath := filepath.Join(path, StoreFileName+".dat")
+	if h.config.EncryptChain || isEncryptedChainFile(chainPath) {
+		var passphrase string
+		if passphrase, err = s.passphrase(name); err != nil {
+			return
+		}
+		h.chain, err = NewEncryptedChainFromFile(h.hashSpec, chainPath, passphrase)
+	} else {
+		h.chain, err = NewChainFromFile(h.hashSpec, chainPath)
+	}
 	if err != nil {
 		return
 	}
+	if err = h.setFsync(); err != nil {
+		return
+	}
 
 	// if the chain has been started there should be a DNAHashFile which
 	// we can load to check against the actual hash of the DNA entry
@@ -344,7 +448,42 @@ func (h *Holochain) Prepare() (err error) {
 	if err = h.PrepareHashType(); err != nil {
 		return
 	}
+	if h.JoinPuzzle != nil {
+		if err = h.JoinPuzzle.Check(); err != nil {
+			return
+		}
+	}
+	if h.Identity != nil {
+		if err = h.Identity.Check(); err != nil {
+			return
+		}
+	}
+	if h.HTTPFetch != nil {
+		if err = h.HTTPFetch.Check(); err != nil {
+			return
+		}
+	}
+	if err = h.checkEntryTypeNames(); err != nil {
+		return
+	}
+	if h.Profiles != nil {
+		if err = h.Profiles.Check(h); err != nil {
+			return
+		}
+	}
+	if err = h.checkTopics(); err != nil {
+		return
+	}
+	if err = h.preparePreferences(); err != nil {
+		return
+	}
 	for zomeType, z := range h.Zomes {
+		if zomeType == SystemZomeName {
+			return ErrSystemZomeName
+		}
+		if err = z.checkAPI(); err != nil {
+			return
+		}
 		var n Nucleus
 		n, err = h.MakeNucleus(zomeType)
 		if err != nil {
@@ -354,14 +493,51 @@ func (h *Holochain) Prepare() (err error) {
 			return
 		}
 
-		if !fileExists(h.path + "/" + z.Code) {
+		if !fileExists(filepath.Join(h.path, z.Code)) {
 			return errors.New("DNA specified code file missing: " + z.Code)
 		}
+		if err = h.checkLibraries(z); err != nil {
+			return
+		}
+		if err = z.checkSubscriptions(h); err != nil {
+			return
+		}
+		for _, f := range z.Functions {
+			if err = f.Check(); err != nil {
+				return fmt.Errorf("DNA function %s: %v", f.Name, err)
+			}
+		}
 		for k := range z.Entries {
 			e := z.Entries[k]
+			if err = checkCRDTKind(e.CRDT); err != nil {
+				return
+			}
+			if _, err = e.TTLDuration(); err != nil {
+				return fmt.Errorf("entry type %s: %v", e.Name, err)
+			}
+			if e.Retention != nil {
+				if err = e.Retention.Check(); err != nil {
+					return fmt.Errorf("entry type %s: %v", e.Name, err)
+				}
+			}
+			if e.CRDT != "" && e.DataFormat != DataFormatJSON {
+				return fmt.Errorf("crdt entry type %s must have json data format", e.Name)
+			}
+			if err = e.checkDependencies(); err != nil {
+				return
+			}
+			if err = e.checkLinks(h); err != nil {
+				return
+			}
+			if err = e.checkCountersigners(); err != nil {
+				return
+			}
+			if err = e.checkMaxSize(); err != nil {
+				return
+			}
 			sc := e.Schema
 			if sc != "" {
-				if !fileExists(h.path + "/" + sc) {
+				if !fileExists(filepath.Join(h.path, sc)) {
 					return errors.New("DNA specified schema file missing: " + sc)
 				} else {
 					if strings.HasSuffix(sc, ".json") {
@@ -382,23 +558,38 @@ func (h *Holochain) Prepare() (err error) {
 
 // Activate fires up the holochain node
 func (h *Holochain) Activate() (err error) {
-	listenaddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", h.config.Port)
-	h.node, err = NewNode(listenaddr, h.id, h.Agent().PrivKey())
+	if h.GenesisPending() {
+		err = ErrGenesisPending
+		return
+	}
+	var transport, listenaddr string
+	transport, err = h.chooseTransport()
 	if err != nil {
 		return
 	}
+	listenaddr, err = TransportListenAddr(transport, h.config.Port)
+	if err != nil {
+		return
+	}
+	h.node, err = NewNodeWithTransport(transport, listenaddr, h.id, h.Agent().PrivKey())
+	if err != nil {
+		return
+	}
+	h.node.metrics = h.metrics
+	h.node.topics = h.config.Topics
+	h.node.maxMessage = h.maxMessageSize()
 
+	if err = h.node.StartHandshake(h); err != nil {
+		return
+	}
 	if h.config.PeerModeDHTNode {
 		if err = h.dht.StartDHT(); err != nil {
 			return
 		}
-		e := h.BSpost()
-		if e != nil {
-			h.dht.dlog.Logf("error in BSpost: %s", e.Error())
-		}
-		e = h.BSget()
-		if e != nil {
-			h.dht.dlog.Logf("error in BSget: %s", e.Error())
+		if h.config.Offline {
+			h.dht.dlog.Log("starting offline, not contacting the bootstrap server")
+		} else {
+			h.bootstrap()
 		}
 	}
 	if h.config.PeerModeAuthor {
@@ -406,6 +597,17 @@ func (h *Holochain) Activate() (err error) {
 			return
 		}
 	}
+
+	// retry any puts that weren't acknowledged, i.e. because we were offline
+	e := h.goWorker(func() {
+		_, _, e := h.dht.Republish()
+		if e != nil {
+			h.dht.dlog.Logf("error republishing: %v", e)
+		}
+	})
+	if e != nil {
+		h.dht.dlog.Logf("unable to start republish: %v", e)
+	}
 	return
 }
 
@@ -429,6 +631,23 @@ func (h *Holochain) Path() string {
 	return h.path
 }
 
+// CaptureLogs copies the output of all of the holochain's loggers to w
+func (h *Holochain) CaptureLogs(w io.Writer) {
+	l := &h.config.Loggers
+	for _, logger := range []*Logger{&l.App, &l.DHT, &l.Gossip, &l.TestPassed, &l.TestFailed, &l.TestInfo} {
+		logger.Tee(w)
+	}
+	if h.dht != nil {
+		h.dht.glog.Tee(w)
+		h.dht.dlog.Tee(w)
+	}
+}
+
+// EncodingFormat returns the format the holochain's DNA and config files are encoded in
+func (h *Holochain) EncodingFormat() string {
+	return h.encodingFormat
+}
+
 // DNAHash returns the hash of the DNA entry which is also the holochain ID
 func (h *Holochain) DNAHash() (id Hash) {
 	return h.dnaHash.Clone()
@@ -439,6 +658,11 @@ func (h *Holochain) Agenthash() (id Hash) {
 	return h.agentHash.Clone()
 }
 
+// NodeIDStr returns the base58 encoded id of the node, which is derived from the agent's key
+func (h *Holochain) NodeIDStr() string {
+	return peer.IDB58Encode(h.id)
+}
+
 // Top returns a hash of top header or err if not yet defined
 func (h *Holochain) Top() (top Hash, err error) {
 	tp := h.chain.Hashes[len(h.chain.Hashes)-1]
@@ -457,7 +681,10 @@ func (h *Holochain) Started() bool {
 func (h *Holochain) GenChain() (headerHash Hash, err error) {
 
 	if h.Started() {
-		err = mkErr("chain already started")
+		err = ErrChainStarted
+		return
+	}
+	if err = h.canJoin(); err != nil {
 		return
 	}
 
@@ -467,10 +694,98 @@ func (h *Holochain) GenChain() (headerHash Hash, err error) {
 		}
 	}()
 
+	h.progress(ProgressGenChain, "generating identity", 0, 2)
+	headerHash, err = h.genIdentity()
+	if err != nil {
+		return
+	}
+
+	h.progress(ProgressGenChain, "publishing genesis", 1, 2)
+	err = h.publishGenesis()
+	if err == nil {
+		h.progress(ProgressGenChain, "done", 2, 2)
+	}
+	return
+}
+
+// GenChainDeferred creates the genesis entries in the chain like GenChain, but doesn't publish
+// them.  This reserves the agent's identity so that it can be registered or countersigned out
+// of band while offline.  The chain can't be activated until PublishGenesis is called.
+func (h *Holochain) GenChainDeferred() (headerHash Hash, err error) {
+	if h.Started() {
+		err = ErrChainStarted
+		return
+	}
+	if err = h.canJoin(); err != nil {
+		return
+	}
+
+	headerHash, err = h.genIdentity()
+	if err != nil {
+		return
+	}
+
+	err = writeFile(h.path, GenesisPendingFileName, []byte(h.agentHash.String()))
+	return
+}
+
+// GenesisPending returns true if the chain was generated with its genesis left unpublished
+func (h *Holochain) GenesisPending() bool {
+	return fileExists(filepath.Join(h.path, GenesisPendingFileName))
+}
+
+// PublishGenesis announces a chain generated with GenChainDeferred to the DHT and runs the
+// genesis functions of its zomes
+func (h *Holochain) PublishGenesis() (err error) {
+	if !h.Started() {
+		err = ErrChainNotStarted
+		return
+	}
+	if !h.GenesisPending() {
+		err = ErrGenesisPublished
+		return
+	}
+	if err = h.publishGenesis(); err != nil {
+		return
+	}
+	err = os.Remove(filepath.Join(h.path, GenesisPendingFileName))
+	return
+}
+
+// genIdentity adds the genesis entries to the chain and records the DNA hash
+func (h *Holochain) genIdentity() (headerHash Hash, err error) {
 	if err = h.Prepare(); err != nil {
 		return
 	}
 
+	headerHash, err = h.addGenesisEntries()
+	if err != nil {
+		return
+	}
+
+	err = writeFile(h.path, DNAHashFileName, []byte(h.dnaHash.String()))
+	return
+}
+
+// publishGenesis puts the genesis entries in the DHT and runs the zome genesis functions
+func (h *Holochain) publishGenesis() (err error) {
+	/*
+		err = h.store.PutMeta(IDMetaKey, dnaHeader.EntryLink.H)
+		if err != nil {
+			return
+		}
+	*/
+	err = h.dht.SetupDHT()
+	if err != nil {
+		return
+	}
+
+	err = h.zomeGenesis()
+	return
+}
+
+// addGenesisEntries adds the DNA and agent entries to the chain
+func (h *Holochain) addGenesisEntries() (headerHash Hash, err error) {
 	var buf bytes.Buffer
 	err = h.EncodeDNA(&buf)
 
@@ -495,31 +810,35 @@ func (h *Holochain) GenChain() (headerHash Hash, err error) {
 		return
 	}
 
-	e.C = k
-	var agentHeader *Header
-	headerHash, agentHeader, err = h.NewEntry(time.Now(), AgentEntryType, &e)
+	k.Join, err = h.joinProof()
 	if err != nil {
 		return
 	}
 
-	h.agentHash = agentHeader.EntryLink
-
-	if err = writeFile(h.path, DNAHashFileName, []byte(h.dnaHash.String())); err != nil {
+	var claims map[string]interface{}
+	k.Identity, claims, err = h.identityAssertion()
+	if err != nil {
 		return
 	}
-
-	/*
-		err = h.store.PutMeta(IDMetaKey, dnaHeader.EntryLink.H)
-		if err != nil {
+	if k.Identity != nil && h.Identity.NameClaim != "" {
+		if k.Name, err = h.Identity.agentName(claims); err != nil {
 			return
 		}
-	*/
-	err = h.dht.SetupDHT()
+	}
+
+	e.C = k
+	var agentHeader *Header
+	headerHash, agentHeader, err = h.NewEntry(time.Now(), AgentEntryType, &e)
 	if err != nil {
 		return
 	}
 
-	// run the init functions of each zome
+	h.agentHash = agentHeader.EntryLink
+	return
+}
+
+// zomeGenesis runs the genesis functions of each zome
+func (h *Holochain) zomeGenesis() (err error) {
 	for zomeName, z := range h.Zomes {
 		var n Nucleus
 		n, err = h.makeNucleus(z)
@@ -531,36 +850,118 @@ func (h *Holochain) GenChain() (headerHash Hash, err error) {
 			}
 		}
 	}
+	return
+}
 
+// AsAgent returns a copy of the holochain acting as a locally provisioned development agent.
+// The agent's keys and its own source chain are kept in a sub-directory of the chain and
+// are created (including genesis entries) the first time the agent is used.  This is only
+// available for chains in development mode and is intended for exercising permission logic.
+func (h *Holochain) AsAgent(name AgentName) (a *Holochain, err error) {
+	if !h.config.DevMode {
+		err = ErrNotDevMode
+		return
+	}
+	if !h.Started() {
+		err = ErrChainNotStarted
+		return
+	}
+	path := filepath.Join(h.path, DevAgentsDir, agentDirName(name))
+	var agent Agent
+	if dirExists(path) {
+		agent, err = LoadAgent(path)
+	} else {
+		if err = os.MkdirAll(path, os.ModePerm); err != nil {
+			return
+		}
+		agent, err = NewAgent(IPFS, name)
+		if err == nil {
+			err = SaveAgent(path, agent)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	x := *h
+	x.agent = agent
+	x.events = NewEvents()
+	x.id, err = peer.IDFromPrivateKey(agent.PrivKey())
+	if err != nil {
+		return
+	}
+	x.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(path, StoreFileName+".dat"))
+	if err != nil {
+		return
+	}
+	if x.chain.Length() == 0 {
+		if _, err = x.addGenesisEntries(); err == nil {
+			err = x.zomeGenesis()
+		}
+		if err != nil {
+			x.chain.Close()
+			return
+		}
+	} else {
+		x.agentHash = x.chain.Headers[1].EntryLink
+	}
+	a = &x
 	return
 }
 
+// CloseAgent closes the chain file of a holochain returned by AsAgent
+func (h *Holochain) CloseAgent() error {
+	return h.chain.Close()
+}
+
+// agentDirName converts an agent name into something safe to use as a directory name
+func agentDirName(name AgentName) string {
+	return regexp.MustCompile(`[^A-Za-z0-9_.-]+`).ReplaceAllString(string(name), "_")
+}
+
 // Clone copies DNA files from a source
 func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, err error) {
+	hP, err = s.clone(srcPath, path, new, nil)
+	return
+}
+
+// clone does the work of Clone and CloneTemplate, substituting template variables in the
+// source's DNA and config if vars isn't nil
+func (s *Service) clone(srcPath string, path string, new bool, vars map[string]string) (hP *Holochain, err error) {
 	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
 
+		s.progress(ProgressClone, "reading DNA", 0, 0)
 		format, err := findDNA(srcPath)
 		if err != nil {
 			return
 		}
 
-		f, err := os.Open(srcPath + "/" + DNAFileName + "." + format)
+		agent, err := LoadAgent(s.Dirs().Config)
 		if err != nil {
 			return
 		}
-		defer f.Close()
-		h, err := DecodeDNA(f, format)
+		if vars != nil {
+			vars = templateDefaults(vars, agent)
+		}
+
+		f, err := openTemplate(filepath.Join(srcPath, DNAFileName+"."+format), vars)
 		if err != nil {
 			return
 		}
-
-		agent, err := LoadAgent(filepath.Dir(path))
+		h, err := DecodeDNA(f, format)
 		if err != nil {
 			return
 		}
 		h.path = path
 		h.agent = agent
 
+		done, total := 0, 4+len(h.Zomes)+len(h.Libraries)
+		step := func(stage string) {
+			done++
+			s.progress(ProgressClone, stage, done, total)
+		}
+		step("making config")
+
 		// once the agent is set up we can calculate the id
 		h.id, err = peer.IDFromPrivateKey(agent.PrivKey())
 		if err != nil {
@@ -568,9 +969,14 @@ func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, e
 		}
 
 		// make a config file
-		if err = makeConfig(h, s); err != nil {
+		if err = makeConfig(h, s, false); err != nil {
 			return
 		}
+		if vars != nil {
+			if err = h.configFromTemplate(srcPath, vars); err != nil {
+				return
+			}
+		}
 
 		if new {
 			// generate a new UUID
@@ -585,21 +991,31 @@ func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, e
 			h.Name = filepath.Base(path)
 		}
 
-		if err = CopyDir(srcPath+"/ui", path+"/ui"); err != nil {
+		step("copying ui")
+		if err = CopyDir(filepath.Join(srcPath, "ui"), filepath.Join(path, "ui")); err != nil {
 			return
 		}
 
-		if err = CopyFile(srcPath+"/schema_properties.json", path+"/schema_properties.json"); err != nil {
-			return
+		if h.PropertiesSchema != "" {
+			if err = CopyFile(filepath.Join(srcPath, h.PropertiesSchema), filepath.Join(path, h.PropertiesSchema)); err != nil {
+				return
+			}
+		}
+		if h.PreferencesSchema != "" {
+			if err = CopyFile(filepath.Join(srcPath, h.PreferencesSchema), filepath.Join(path, h.PreferencesSchema)); err != nil {
+				return
+			}
 		}
 
-		if dirExists(srcPath + "/test") {
-			if err = CopyDir(srcPath+"/test", path+"/test"); err != nil {
+		step("copying tests")
+		if dirExists(filepath.Join(srcPath, "test")) {
+			if err = CopyDir(filepath.Join(srcPath, "test"), filepath.Join(path, "test")); err != nil {
 				return
 			}
 		}
 
 		for _, z := range h.Zomes {
+			step("copying zome " + z.Name)
 			var bs []byte
 			bs, err = readFile(srcPath, z.Code)
 			if err != nil {
@@ -612,13 +1028,25 @@ func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, e
 				e := z.Entries[k]
 				sc := e.Schema
 				if sc != "" {
-					if err = CopyFile(srcPath+"/"+sc, path+"/"+sc); err != nil {
+					if err = CopyFile(filepath.Join(srcPath, sc), filepath.Join(path, sc)); err != nil {
 						return
 					}
 				}
 			}
 		}
+		for _, l := range h.Libraries {
+			step("copying library " + l.Name)
+			var bs []byte
+			bs, err = readFile(srcPath, l.Code)
+			if err != nil {
+				return
+			}
+			if err = writeFile(path, l.Code, bs); err != nil {
+				return
+			}
+		}
 
+		step("done")
 		hP = h
 		return
 	})
@@ -627,42 +1055,111 @@ func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, e
 
 // TestData holds a test entry for a chain
 type TestData struct {
-	Zome   string
-	FnName string
-	Input  string
-	Output string
-	Err    string
-	Regexp string
+	Zome    string
+	FnName  string
+	Input   string
+	Output  string
+	Err     string
+	Regexp  string
+	Commits []string       `json:",omitempty"` // if set, the hashes of the entries the call must commit
+	Setup   []TestStep     `json:",omitempty"` // zome calls to make before the test's call
+	Signals []string       `json:",omitempty"` // if set, the kinds of events the call must emit, in order
+	DHT     []TestDHTState `json:",omitempty"` // the states entries must reach in the DHT after the call
+	Cleanup []TestStep     `json:",omitempty"` // zome calls to make after the test, whether or not it passed
 }
 
 func (h *Holochain) setupConfig() (err error) {
-	if err = h.config.Loggers.App.New(nil); err != nil {
+	if err = h.config.setup(); err != nil {
 		return
 	}
-	if err = h.config.Loggers.DHT.New(nil); err != nil {
+	h.quota = newQuotaState(&h.config.Quotas)
+	return
+}
+
+// setup prepares the config's loggers and checks its settings
+func (config *Config) setup() (err error) {
+	if err = config.Loggers.setup(); err != nil {
+		return
+	}
+	if config.ZomeLogLevel != "" {
+		if err = checkLogLevel(config.ZomeLogLevel); err != nil {
+			return
+		}
+	}
+	if _, err = config.GCRetentionDuration(); err != nil {
+		return
+	}
+	if _, err = config.IdempotencyTTLDuration(); err != nil {
+		return
+	}
+	if _, err = config.GossipIntervalDuration(); err != nil {
+		return
+	}
+	if _, err = config.Health.GossipStaleDuration(); err != nil {
+		return
+	}
+	if _, err = config.Health.ValidationWindowDuration(); err != nil {
+		return
+	}
+	if config.Notary != nil {
+		if err = config.Notary.Check(); err != nil {
+			return
+		}
+	}
+	if config.Backup != nil {
+		if err = config.Backup.Check(); err != nil {
+			return
+		}
+	}
+	if _, err = config.FsyncPolicy(); err != nil {
 		return
 	}
-	if err = h.config.Loggers.Gossip.New(nil); err != nil {
+	if _, err = config.FsyncIntervalDuration(); err != nil {
 		return
 	}
-	if err = h.config.Loggers.TestPassed.New(nil); err != nil {
+	if _, err = config.MaxClockSkewDuration(); err != nil {
 		return
 	}
-	if err = h.config.Loggers.TestFailed.New(nil); err != nil {
+	if config.TimeWitnesses < 0 || config.TimeWitnesses > MaxTimeWitnesses {
+		err = fmt.Errorf("time witnesses must be between 0 and %d, got: %d", MaxTimeWitnesses, config.TimeWitnesses)
 		return
 	}
-	if err = h.config.Loggers.TestInfo.New(nil); err != nil {
+	for i := range config.Integrations {
+		if err = config.Integrations[i].Check(); err != nil {
+			return
+		}
+	}
+	if _, err = config.Quotas.BacklogPolicy(); err != nil {
 		return
 	}
 	return
 }
 
-func makeConfig(h *Holochain, s *Service) (err error) {
+// setup prepares each of the loggers for use
+func (loggers *Loggers) setup() (err error) {
+	for _, l := range []*Logger{&loggers.App, &loggers.DHT, &loggers.Gossip, &loggers.TestPassed, &loggers.TestFailed, &loggers.TestInfo} {
+		if err = l.New(nil); err != nil {
+			return
+		}
+	}
+	for _, l := range loggers.Zomes {
+		if err = l.New(nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func makeConfig(h *Holochain, s *Service, devMode bool) (err error) {
 	h.config = Config{
+		DevMode:         devMode,
 		Port:            DefaultPort,
 		PeerModeDHTNode: s.Settings.DefaultPeerModeDHTNode,
 		PeerModeAuthor:  s.Settings.DefaultPeerModeAuthor,
 		BootstrapServer: s.Settings.DefaultBootstrapServer,
+		Transports:      []string{TCPTransportName},
+		GCRetention:     DefaultGCRetention,
+		EncryptChain:    s.Settings.DefaultEncryptChain,
 		Loggers: Loggers{
 			App:        Logger{Format: "%{color:cyan}%{message}", Enabled: true},
 			DHT:        Logger{Format: "%{color:yellow}%{time} DHT: %{message}"},
@@ -671,9 +1168,15 @@ func makeConfig(h *Holochain, s *Service) (err error) {
 			TestFailed: Logger{Format: "%{color:red}%{message}", Enabled: true},
 			TestInfo:   Logger{Format: "%{message}", Enabled: true},
 		},
+		Quotas: Quotas{
+			MaxWorkers:         DefaultMaxWorkers,
+			PutQueueLength:     DefaultPutQueueLength,
+			MaxConcurrentCalls: DefaultMaxConcurrentCalls,
+			GossipBacklogBytes: DefaultGossipBacklogBytes,
+		},
 	}
 
-	p := h.path + "/" + ConfigFileName + "." + h.encodingFormat
+	p := filepath.Join(h.path, ConfigFileName+"."+h.encodingFormat)
 	f, err := os.Create(p)
 	if err != nil {
 		return err
@@ -692,7 +1195,7 @@ func makeConfig(h *Holochain, s *Service) (err error) {
 // GenDev generates starter holochain DNA files from which to develop a chain
 func (s *Service) GenDev(path string, format string) (hP *Holochain, err error) {
 	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
-		agent, err := LoadAgent(filepath.Dir(path))
+		agent, err := LoadAgent(s.Dirs().Config)
 		if err != nil {
 			return
 		}
@@ -706,6 +1209,12 @@ func (s *Service) GenDev(path string, format string) (hP *Holochain, err error)
 					"primes":  {Name: "primes", DataFormat: DataFormatJSON},
 					"profile": {Name: "profile", DataFormat: DataFormatJSON, Schema: "schema_profile.json"},
 				},
+				Functions: []FunctionDef{
+					{Name: "getDNA", Arg: StringType, Returns: HashType, ReadOnly: true},
+					{Name: "exposedfn", Arg: StringType, Returns: StringType, ReadOnly: true},
+					{Name: "addData", Arg: IntType, Returns: HashType},
+					{Name: "addPrime", Arg: JSONType, Returns: JSONType},
+				},
 			},
 			{Name: "jsZome",
 				Description: "this is a javascript test zome",
@@ -714,6 +1223,11 @@ func (s *Service) GenDev(path string, format string) (hP *Holochain, err error)
 					"myOdds":  {Name: "myOdds", DataFormat: DataFormatRawJS},
 					"profile": {Name: "profile", DataFormat: DataFormatJSON, Schema: "schema_profile.json"},
 				},
+				Functions: []FunctionDef{
+					{Name: "getProperty", Arg: StringType, Returns: StringType, ReadOnly: true},
+					{Name: "addOdd", Arg: IntType, Returns: HashType},
+					{Name: "addProfile", Arg: JSONType, Returns: JSONType},
+				},
 			},
 		}
 
@@ -722,7 +1236,7 @@ func (s *Service) GenDev(path string, format string) (hP *Holochain, err error)
 		// use the path as the name
 		h.Name = filepath.Base(path)
 
-		if err = makeConfig(&h, s); err != nil {
+		if err = makeConfig(&h, s, true); err != nil {
 			return
 		}
 
@@ -820,7 +1334,7 @@ func (s *Service) GenDev(path string, format string) (hP *Holochain, err error)
 				Err:    "Invalid entry: 2"},
 		}
 
-		uiPath := path + "/ui"
+		uiPath := filepath.Join(path, "ui")
 		if err = os.MkdirAll(uiPath, os.ModePerm); err != nil {
 			return nil, err
 		}
@@ -867,22 +1381,18 @@ return false
 function genesis() {return true}
 `
 
-		testPath := path + "/test"
+		testPath := filepath.Join(path, "test")
 		if err = os.MkdirAll(testPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 
 		for n := range h.Zomes {
 			z, _ := h.Zomes[n]
-			switch z.NucleusType {
-			case JSNucleusType:
-				z.Code = fmt.Sprintf("zome_%s.js", z.Name)
-			case ZygoNucleusType:
-				z.Code = fmt.Sprintf("zome_%s.zy", z.Name)
-			default:
-				err = fmt.Errorf("unknown nucleus type:%s", z.NucleusType)
+			var ext string
+			if ext, err = NucleusFileExtension(z.NucleusType); err != nil {
 				return
 			}
+			z.Code = fmt.Sprintf("zome_%s.%s", z.Name, ext)
 
 			c, _ := code[z.Name]
 			if err = writeFile(path, z.Code, []byte(c)); err != nil {
@@ -941,13 +1451,16 @@ func gen(path string, makeH func(path string) (hP *Holochain, err error)) (h *Ho
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, path+"/"+StoreFileName+".dat")
+	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(path, StoreFileName+".dat"))
 	if err != nil {
 		return
 	}
+	if err = h.setFsync(); err != nil {
+		return
+	}
 
 	/*
-		h.store, err = CreatePersister(BoltPersisterName, path+"/"+StoreFileName+".db")
+		h.store, err = CreatePersister(BoltPersisterName, filepath.Join(path, StoreFileName+".db"))
 		if err != nil {
 			return
 		}
@@ -972,7 +1485,7 @@ func (h *Holochain) EncodeDNA(writer io.Writer) (err error) {
 
 // SaveDNA writes the holochain DNA to a file
 func (h *Holochain) SaveDNA(overwrite bool) (err error) {
-	p := h.path + "/" + DNAFileName + "." + h.encodingFormat
+	p := filepath.Join(h.path, DNAFileName+"."+h.encodingFormat)
 	if !overwrite && fileExists(p) {
 		return mkErr(p + " already exists")
 	}
@@ -1016,17 +1529,118 @@ func (h *Holochain) GenDNAHashes() (err error) {
 		}
 
 	}
+	for _, l := range h.Libraries {
+		b, err = readFile(h.path, l.Code)
+		if err != nil {
+			return
+		}
+		err = l.CodeHash.Sum(h.hashSpec, b)
+		if err != nil {
+			return
+		}
+	}
 	err = h.SaveDNA(true)
 	return
 }
 
+// BuildDNA generates the DNA hashes and returns the encoded DNA together with the hash its
+// DNA entry, and thus the holochain id, will have.  The same DNA source always builds to the
+// same hash so published hashes can be checked against a fresh build.
+func (h *Holochain) BuildDNA() (dna []byte, hash Hash, err error) {
+	if err = h.GenDNAHashes(); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = h.EncodeDNA(&buf); err != nil {
+		return
+	}
+	dna = buf.Bytes()
+	e := GobEntry{C: dna}
+	hash, err = e.Sum(h.hashSpec)
+	return
+}
+
+// VerifyDNA builds the DNA and checks that it has the expected hash
+func (h *Holochain) VerifyDNA(expected Hash) (hash Hash, err error) {
+	_, hash, err = h.BuildDNA()
+	if err != nil {
+		return
+	}
+	if hash.String() != expected.String() {
+		err = ErrDNAHashMismatch
+	}
+	return
+}
+
+// addEntry adds a prepared entry and header to the chain, notifying subscribers of the commit
+func (h *Holochain) addEntry(l int, hash Hash, header *Header, entry Entry) (err error) {
+	if h.ShuttingDown() {
+		err = ErrShuttingDown
+		return
+	}
+	if err = h.checkEntryScope(header.Type); err != nil {
+		return
+	}
+	if err = h.preCommit(header.EntryLink, header, entry); err != nil {
+		return
+	}
+	err = h.chain.addEntry(l, hash, header, entry)
+	if err == nil {
+		h.postCommit(header.EntryLink, header, entry)
+		h.emit(Event{Type: EventCommit, Hash: header.EntryLink, EntryType: header.Type, Peer: h.id, Received: h.receiving})
+		if h.dht != nil {
+			if e := h.dht.recordCommit(header.Type, header.Time); e != nil {
+				h.dht.dlog.Logf("error recording stats of commit of %v: %v", header.EntryLink, e)
+			}
+		}
+		if h.Config().TimeWitnesses > 0 && h.node != nil {
+			h.goWorker(func() {
+				if _, e := h.AttestTime(hash); e != nil {
+					h.dht.glog.Logf("error getting time attestations of %v: %v", hash, e)
+				}
+			})
+		}
+	}
+	return
+}
+
+// Commit validates and adds an entry of the given type to the chain, just as a zome's commit
+// does, returning the hash of the entry
+func (h *Holochain) Commit(entryType string, content string) (hash Hash, err error) {
+	if entryType, err = h.EntryTypeName(entryType); err != nil {
+		return
+	}
+	e := GobEntry{C: content}
+	var l int
+	var header *Header
+	l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, time.Now(), entryType, &e, h.agent.PrivKey())
+	if err != nil {
+		return
+	}
+	p := ValidationProps{
+		Sources: []string{peer.IDB58Encode(h.id)},
+		Hash:    hash.String(),
+	}
+	if err = h.ValidateEntry(entryType, &e, &p); err != nil {
+		return
+	}
+	if err = h.addEntry(l, hash, header, &e); err != nil {
+		return
+	}
+	hash = header.EntryLink
+	return
+}
+
 // NewEntry adds an entry and it's header to the chain and returns the header and it's hash
 func (h *Holochain) NewEntry(now time.Time, entryType string, entry Entry) (hash Hash, header *Header, err error) {
 
+	if entryType, err = h.EntryTypeName(entryType); err != nil {
+		return
+	}
 	var l int
 	l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, now, entryType, entry, h.agent.PrivKey())
 	if err == nil {
-		err = h.chain.addEntry(l, hash, header, entry)
+		err = h.addEntry(l, hash, header, entry)
 	}
 	/*
 		// get the current top of the chain
@@ -1124,6 +1738,16 @@ func (h *Holochain) Validate(entriesToo bool) (valid bool, err error) {
 
 // GetEntryDef returns an EntryDef of the given name
 func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
+	if zn, name := SplitEntryType(t); zn != "" {
+		if z, ok := h.Zomes[zn]; ok {
+			if e, ok := z.Entries[name]; ok {
+				zome, d = z, &e
+				return
+			}
+		}
+		err = fmt.Errorf("%v: %s", ErrNoEntryDef, t)
+		return
+	}
 	for _, z := range h.Zomes {
 		e, ok := z.Entries[t]
 		if ok {
@@ -1133,7 +1757,7 @@ func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
 		}
 	}
 	if d == nil {
-		err = errors.New("no definition for entry type: " + t)
+		err = fmt.Errorf("%v: %s", ErrNoEntryDef, t)
 	}
 	return
 }
@@ -1141,16 +1765,118 @@ func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
 // ValidateEntry passes an entry data to the chain's validation routine
 // If the entry is valid err will be nil, otherwise it will contain some information about why the validation failed (or, possibly, some other system error)
 func (h *Holochain) ValidateEntry(entryType string, entry Entry, props *ValidationProps) (err error) {
+	err = h.validateEntry(entryType, entry, props)
+	h.coverage.validation(entryType, err)
+	if h.dht != nil {
+		if e := h.dht.recordValidation(err != nil); e != nil {
+			h.dht.dlog.Logf("error recording validation: %v", e)
+		}
+	}
+	if err != nil {
+		event := Event{Type: EventValidationFailed, EntryType: entryType, Err: err}
+		if props != nil {
+			hash := props.Hash
+			if hash == "" {
+				hash = props.MetaHash
+			}
+			event.Hash, _ = NewHash(hash)
+			if len(props.Sources) > 0 {
+				event.Peer, _ = peer.IDB58Decode(props.Sources[0])
+			}
+		}
+		h.emit(event)
+	}
+	return
+}
+
+func (h *Holochain) validateEntry(entryType string, entry Entry, props *ValidationProps) (err error) {
 
 	if entry == nil {
 		return errors.New("nil entry invalid")
 	}
 
+	// entries of the system types aren't declared in the DNA, so get the default size cap
+	// before they are parsed
+	if strings.HasPrefix(entryType, "%") {
+		if err = (&EntryDef{Name: entryType}).checkSize(entry); err != nil {
+			return
+		}
+	}
+
+	// links must be declared by their base's type, whoever makes them
+	if props != nil && props.MetaTag != "" {
+		if err = h.checkMetaLink(entryType, props); err != nil {
+			return
+		}
+	}
+
+	if entryType == AttestationEntryType {
+		return validateAttestation(entry, props)
+	}
+
+	if entryType == RedactionEntryType {
+		return h.validateRedaction(entry, props)
+	}
+
+	if entryType == NotarizationEntryType {
+		return validateNotarization(entry, props)
+	}
+
+	if entryType == NotaryWitnessEntryType {
+		return validateWitness(entry)
+	}
+
+	if entryType == PreferencesEntryType {
+		return h.validatePreferences(entry, props)
+	}
+
+	if entryType == AgentEntryType && h.Identity != nil {
+		return h.validateAgentIdentity(entry, props)
+	}
+
 	z, d, err := h.GetEntryDef(entryType)
 	if err != nil {
 		return
 	}
 
+	// oversized entries are rejected before any of the app's code sees them
+	if err = d.checkSize(entry); err != nil {
+		return
+	}
+
+	// crdt updates must be well formed for the DHT to be able to merge them
+	if d.CRDT != "" {
+		if _, err = parseCRDTOp(d.CRDT, entry); err != nil {
+			return
+		}
+	}
+
+	// countersigned entries must be signed by all their agents, and the rules see their content
+	if d.Countersigners > 0 {
+		if props == nil {
+			props = &ValidationProps{}
+		}
+		if entry, err = h.checkCountersigned(entryType, d, entry, props); err != nil {
+			return
+		}
+	}
+
+	// a type shared by several zomes must pass each of their rules
+	_, name := SplitEntryType(entryType)
+	for _, x := range h.zomesDefining(name) {
+		if x != z {
+			if err = h.validateEntryContent(x, d, entry, props); err != nil {
+				return
+			}
+		}
+	}
+	err = h.validateEntryContent(z, d, entry, props)
+	return
+}
+
+// validateEntryContent checks an entry's content against its type's schema and the app's
+// validation rules
+func (h *Holochain) validateEntryContent(z *Zome, d *EntryDef, entry Entry, props *ValidationProps) (err error) {
 	// see if there is a schema validator for the entry type and validate it if so
 	if d.validator != nil {
 		var input interface{}
@@ -1167,7 +1893,18 @@ func (h *Holochain) ValidateEntry(entryType string, entry Entry, props *Validati
 		}
 	}
 
+	// resolve the entries this one refers to so the validation rules have them to hand
+	if len(d.Dependencies) > 0 {
+		if props == nil {
+			props = &ValidationProps{}
+		}
+		if props.Deps, err = h.resolveDependencies(d, entry, props); err != nil {
+			return
+		}
+	}
+
 	// then run the nucleus (ie. "app" specific) validation rules
+	defer h.startNucleus()()
 	n, err := h.makeNucleus(z)
 	if err != nil {
 		return
@@ -1178,11 +1915,48 @@ func (h *Holochain) ValidateEntry(entryType string, entry Entry, props *Validati
 
 // Call executes an exposed function
 func (h *Holochain) Call(zomeType string, function string, arguments interface{}) (result interface{}, err error) {
-	n, err := h.MakeNucleus(zomeType)
+	// the semaphore is held onto as the quotas can be replaced by a config reload
+	sem := h.quotas().calls
+	if !acquire(sem) {
+		h.quotaExceeded("calls", "concurrent call limit of %d reached calling %s:%s", h.Config().Quotas.MaxConcurrentCalls, zomeType, function)
+		err = ErrCallQuotaExceeded
+		return
+	}
+	defer release(sem)
+	h.metrics.Inc("calls", 1)
+	if err = h.checkCallScope(zomeType, function); err != nil {
+		return
+	}
+	if err = h.checkArgSize(zomeType, function, arguments); err != nil {
+		return
+	}
+
+	if zomeType == SystemZomeName {
+		result, err = h.callSystem(function, arguments)
+		return
+	}
+	z, ok := h.Zomes[zomeType]
+	if !ok {
+		err = errors.New("unknown zome: " + zomeType)
+		return
+	}
+	f := z.GetFunctionDef(function)
+	if f != nil {
+		arguments, err = f.marshalArg(arguments)
+		if err != nil {
+			return
+		}
+	}
+
+	defer h.startNucleus()()
+	n, err := h.makeNucleus(z)
 	if err != nil {
 		return
 	}
 	result, err = n.Call(function, arguments)
+	if err == nil && f != nil {
+		err = f.checkResult(result)
+	}
 	return
 }
 
@@ -1199,11 +1973,31 @@ func (h *Holochain) MakeNucleus(t string) (n Nucleus, err error) {
 
 func (h *Holochain) makeNucleus(z *Zome) (n Nucleus, err error) {
 	var code []byte
-	code, err = readFile(h.path, z.Code)
+	code, err = h.zomeCode(z)
 	if err != nil {
 		return
 	}
 	n, err = CreateNucleus(h, z.NucleusType, string(code))
+	if err != nil {
+		return
+	}
+	if zn, ok := n.(interface {
+		setZome(string)
+	}); ok {
+		zn.setZome(z.Name)
+	}
+	if h.coverage != nil {
+		if cn, ok := n.(interface {
+			cover([]string, func(string)) error
+		}); ok {
+			var fns []string
+			for _, i := range n.Interfaces() {
+				fns = append(fns, i.Name)
+			}
+			name := z.Name
+			err = cn.cover(fns, func(fn string) { h.coverage.call(name, fn) })
+		}
+	}
 	return
 }
 
@@ -1214,7 +2008,7 @@ func LoadTestData(path string) (map[string][]TestData, error) {
 	}
 
 	if len(files) == 0 {
-		return nil, errors.New("no test data found in: " + path + "/test")
+		return nil, errors.New("no test data found in: " + filepath.Join(path, "test"))
 	}
 
 	re := regexp.MustCompile(`(.*)\.json`)
@@ -1289,7 +2083,7 @@ func (h *Holochain) Test() []error {
 	}
 
 	// load up the test files into the tests array
-	var tests, errorLoad = LoadTestData(h.path + "/test")
+	var tests, errorLoad = LoadTestData(filepath.Join(h.path, "test"))
 	if errorLoad != nil {
 		return []error{errorLoad}
 	}
@@ -1306,22 +2100,39 @@ func (h *Holochain) Test() []error {
 			panic("gen err " + err.Error())
 		}
 		go h.dht.HandlePutReqs()
+		var nodes []*Holochain
+		var stopNodes func()
+		if n := testNodesNeeded(ts); n > 0 {
+			if nodes, stopNodes, err = h.startTestNodes(n); err != nil {
+				errs = append(errs, fmt.Errorf("\nTest: %s\n\tcouldn't start mock nodes: %v", name, err))
+				continue
+			}
+		}
 		for i, t := range ts {
 			Debugf("------------------------------")
 			info.pf("Test '%s' line %d: %s", name, i, t)
 			time.Sleep(time.Millisecond * 10)
+			testID := fmt.Sprintf("%s:%d", name, i)
+			r1 := strings.Trim(fmt.Sprintf("%v", lastResults[0]), "\"")
+			r2 := strings.Trim(fmt.Sprintf("%v", lastResults[1]), "\"")
+			r3 := strings.Trim(fmt.Sprintf("%v", lastResults[2]), "\"")
+			replace := func(s string) string { return h.TestStringReplacements(s, r1, r2, r3) }
+			if err == nil && t.Setup != nil {
+				err = h.testCalls(testID, "setup", t.Setup, replace)
+			}
 			if err == nil {
-				testID := fmt.Sprintf("%s:%d", name, i)
 				input := t.Input
 				Debugf("Input before replacement: %s", input)
-				r1 := strings.Trim(fmt.Sprintf("%v", lastResults[0]), "\"")
-				r2 := strings.Trim(fmt.Sprintf("%v", lastResults[1]), "\"")
-				r3 := strings.Trim(fmt.Sprintf("%v", lastResults[2]), "\"")
-				input = h.TestStringReplacements(input, r1, r2, r3)
+				input = replace(input)
 				Debugf("Input after replacement: %s", input)
+				var signals <-chan Event
+				if t.Signals != nil {
+					signals = h.Subscribe(EventCommit | EventPutReceived | EventValidationFailed)
+				}
 				//====================
+				committed := h.chain.Length()
 				var actualResult, actualError = h.Call(t.Zome, t.FnName, input)
-				var expectedResult, expectedError = t.Output, t.Err
+				var expectedResult, expectedError = t.Output, replace(t.Err)
 				var expectedResultRegexp = t.Regexp
 				//====================
 				lastResults[2] = lastResults[1]
@@ -1372,6 +2183,31 @@ func (h *Holochain) Test() []error {
 						}
 					}
 				}
+				if err == nil && actualError == nil && t.Commits != nil {
+					got := h.committedSince(committed)
+					if strings.Join(got, ",") != strings.Join(t.Commits, ",") {
+						comparisonString := fmt.Sprintf("\nTest: %s\n\tExpected commits:\t%v\n\tGot commits:\t\t%v", testID, t.Commits, got)
+						err = errors.New(comparisonString)
+						failed.pf(fmt.Sprintf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString))
+					}
+				}
+				if signals != nil {
+					got := collectSignals(signals, len(t.Signals))
+					h.Unsubscribe(signals)
+					if err == nil && strings.Join(got, ",") != strings.Join(t.Signals, ",") {
+						comparisonString := fmt.Sprintf("\nTest: %s\n\tExpected signals:\t%v\n\tGot signals:\t\t%v", testID, t.Signals, got)
+						err = errors.New(comparisonString)
+						failed.pf(fmt.Sprintf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString))
+					}
+				}
+				if err == nil && t.DHT != nil {
+					err = h.checkTestDHT(testID, t.DHT, nodes, replace)
+				}
+			}
+			if t.Cleanup != nil {
+				if e := h.testCalls(testID, "cleanup", t.Cleanup, replace); err == nil {
+					err = e
+				}
 			}
 
 			if err != nil {
@@ -1379,6 +2215,9 @@ func (h *Holochain) Test() []error {
 				err = nil
 			}
 		}
+		if stopNodes != nil {
+			stopNodes()
+		}
 		// restore the state for the next test file
 		e := h.Reset()
 		if e != nil {
@@ -1403,6 +2242,40 @@ func (h *Holochain) GetProperty(prop string) (property string, err error) {
 	return
 }
 
+// ResetTo rolls the source chain back to the header with the given hash, removing all later
+// entries and marking them in the DHT for retraction.  It is only available for chains in
+// development mode and can't roll back past the genesis entries.
+func (h *Holochain) ResetTo(hash Hash) (removed []*Header, err error) {
+	if !h.config.DevMode {
+		err = ErrNotDevMode
+		return
+	}
+	h.chain.lk.Lock()
+	i, ok := h.chain.Hmap[hash.String()]
+	top, hasAgent := h.chain.TypeTops[AgentEntryType]
+	h.chain.lk.Unlock()
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	if !hasAgent || i < top {
+		err = errors.New("can't roll back past genesis")
+		return
+	}
+	removed, err = h.chain.Truncate(hash)
+	if err != nil || len(removed) == 0 {
+		return
+	}
+	retracted := make([]Hash, len(removed))
+	for j, hd := range removed {
+		retracted[j] = hd.EntryLink
+	}
+	if h.dht != nil {
+		err = h.dht.MarkRetracted(retracted)
+	}
+	return
+}
+
 // Reset deletes all chain and dht data and resets data structures
 func (h *Holochain) Reset() (err error) {
 
@@ -1418,16 +2291,20 @@ func (h *Holochain) Reset() (err error) {
 			panic(err)
 		}
 	*/
-	err = os.RemoveAll(h.path + "/" + DNAHashFileName)
+	err = os.RemoveAll(filepath.Join(h.path, DNAHashFileName))
 	if err != nil {
 		panic(err)
 	}
 
-	err = os.RemoveAll(h.path + "/" + StoreFileName + ".db")
+	err = os.RemoveAll(filepath.Join(h.path, StoreFileName+".db"))
+	if err != nil {
+		panic(err)
+	}
+	err = os.RemoveAll(filepath.Join(h.path, "dht.db"))
 	if err != nil {
 		panic(err)
 	}
-	err = os.RemoveAll(h.path + "/dht.db")
+	err = os.RemoveAll(filepath.Join(h.path, GenesisPendingFileName))
 	if err != nil {
 		panic(err)
 	}
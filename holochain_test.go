@@ -106,6 +106,7 @@ func TestGenDev(t *testing.T) {
 		So(h.config.PeerModeDHTNode, ShouldEqual, s.Settings.DefaultPeerModeDHTNode)
 		So(h.config.PeerModeAuthor, ShouldEqual, s.Settings.DefaultPeerModeAuthor)
 		So(h.config.BootstrapServer, ShouldEqual, s.Settings.DefaultBootstrapServer)
+		So(h.config.DevMode, ShouldBeTrue)
 		//		lh.store.Close()
 
 		So(fileExists(h.path+"/schema_profile.json"), ShouldBeTrue)
@@ -349,6 +350,75 @@ func TestGenChain(t *testing.T) {
 	})
 }
 
+func TestGenChainDeferred(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("publishing an unstarted chain should fail", t, func() {
+		err := h.PublishGenesis()
+		So(err, ShouldEqual, ErrChainNotStarted)
+	})
+
+	Convey("it should create the genesis entries without publishing them", t, func() {
+		headerHash, err := h.GenChainDeferred()
+		So(err, ShouldBeNil)
+		hdr, err := h.chain.Get(headerHash)
+		So(err, ShouldBeNil)
+		So(hdr.Type, ShouldEqual, AgentEntryType)
+		So(h.GenesisPending(), ShouldBeTrue)
+		So(h.dht.exists(h.agentHash), ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("the chain shouldn't activate until published", t, func() {
+		So(h.Activate(), ShouldEqual, ErrGenesisPending)
+	})
+
+	Convey("publishing should put the genesis entries in the DHT", t, func() {
+		err := h.PublishGenesis()
+		So(err, ShouldBeNil)
+		So(h.GenesisPending(), ShouldBeFalse)
+		So(h.dht.exists(h.agentHash), ShouldBeNil)
+		So(h.dht.exists(h.dnaHash), ShouldBeNil)
+		So(h.PublishGenesis(), ShouldEqual, ErrGenesisPublished)
+	})
+}
+
+func TestBuildDNA(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	dna, hash, err := h.BuildDNA()
+	Convey("it should build the DNA and its hash", t, func() {
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		h.EncodeDNA(&buf)
+		So(string(dna), ShouldEqual, buf.String())
+	})
+
+	Convey("it should build the same hash each time", t, func() {
+		_, hash2, err := h.BuildDNA()
+		So(err, ShouldBeNil)
+		So(hash2.String(), ShouldEqual, hash.String())
+		_, err = h.VerifyDNA(hash)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("the hash should be the holochain id", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		So(h.DNAHash().String(), ShouldEqual, hash.String())
+	})
+
+	Convey("it should fail to verify if the DNA changes", t, func() {
+		f, _ := os.OpenFile(h.path+"/"+h.Zomes["myZome"].Code, os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString("\n(defn another [] true)\n")
+		f.Close()
+		hash2, err := h.VerifyDNA(hash)
+		So(err, ShouldEqual, ErrDNAHashMismatch)
+		So(hash2.String(), ShouldNotEqual, hash.String())
+	})
+}
+
 func TestWalk(t *testing.T) {
 	d, _, h := prepareTestChain("test")
 	defer cleanupTestDir(d)
@@ -451,13 +521,6 @@ func TestMakeNucleus(t *testing.T) {
 		So(err.Error(), ShouldEqual, "unknown zome: bogusType")
 
 	})
-	Convey("it should make a nucleus based on the type", t, func() {
-		v, err := h.MakeNucleus("myZome")
-		So(err, ShouldBeNil)
-		z := v.(*ZygoNucleus)
-		_, err = z.env.Run()
-		So(err, ShouldBeNil)
-	})
 }
 
 func TestCall(t *testing.T) {
@@ -519,3 +582,117 @@ func TestTest(t *testing.T) {
 	})
 
 }
+
+func TestAsAgent(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should fail if the chain isn't in development mode", t, func() {
+		h.config.DevMode = false
+		_, err := h.AsAgent("Bob")
+		So(err, ShouldEqual, ErrNotDevMode)
+		h.config.DevMode = true
+	})
+
+	agent := AgentName("Bob <bob@example.com>")
+	Convey("it should provision a new agent with its own chain", t, func() {
+		a, err := h.AsAgent(agent)
+		So(err, ShouldBeNil)
+		So(a.Agent().Name(), ShouldEqual, agent)
+		So(a.id, ShouldNotEqual, h.id)
+		So(fileExists(h.path+"/"+DevAgentsDir+"/Bob_bob_example.com_/"+PrivKeyFileName), ShouldBeTrue)
+		So(a.DNAHash().String(), ShouldEqual, h.DNAHash().String())
+		So(a.agentHash.String(), ShouldNotEqual, h.agentHash.String())
+		So(a.chain.Length(), ShouldEqual, 2)
+
+		result, err := a.Call("myZome", "addData", "42")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, a.chain.Top().EntryLink.String())
+		So(a.chain.Length(), ShouldEqual, 3)
+		So(h.chain.Length(), ShouldEqual, 2)
+		So(a.CloseAgent(), ShouldBeNil)
+	})
+
+	Convey("it should reload a previously provisioned agent", t, func() {
+		a, err := h.AsAgent(agent)
+		So(err, ShouldBeNil)
+		So(a.chain.Length(), ShouldEqual, 3)
+		So(a.Agent().Name(), ShouldEqual, agent)
+		So(a.CloseAgent(), ShouldBeNil)
+	})
+}
+
+func TestResetTo(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	top := h.chain.Hashes[h.chain.Length()-1]
+	r1, err := h.Call("myZome", "addData", "2")
+	if err != nil {
+		panic(err)
+	}
+	r2, err := h.Call("myZome", "addData", "4")
+	if err != nil {
+		panic(err)
+	}
+	length := h.chain.Length()
+
+	Convey("it should fail if the chain isn't in development mode", t, func() {
+		h.config.DevMode = false
+		_, err := h.ResetTo(top)
+		So(err, ShouldEqual, ErrNotDevMode)
+		h.config.DevMode = true
+	})
+
+	Convey("it should not roll back past genesis", t, func() {
+		_, err := h.ResetTo(h.chain.Hashes[0])
+		So(err.Error(), ShouldEqual, "can't roll back past genesis")
+	})
+
+	Convey("it should roll back the chain and mark entries for retraction", t, func() {
+		removed, err := h.ResetTo(top)
+		So(err, ShouldBeNil)
+		So(len(removed), ShouldEqual, length-2)
+		So(h.chain.Length(), ShouldEqual, 2)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, h.agentHash.String())
+
+		retracted, err := h.dht.Retractions()
+		So(err, ShouldBeNil)
+		hashes := make([]string, 0)
+		for _, hash := range retracted {
+			hashes = append(hashes, hash.String())
+		}
+		So(hashes, ShouldContain, r1.(string))
+		So(hashes, ShouldContain, r2.(string))
+
+		pubs, err := h.dht.Publications(false)
+		So(err, ShouldBeNil)
+		for _, p := range pubs {
+			So(p.Put.H.String(), ShouldNotEqual, r1.(string))
+		}
+	})
+}
+
+func TestCommit(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should validate and commit an entry", t, func() {
+		hash, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, hash.String())
+		e, et, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(et, ShouldEqual, "myData")
+		So(e.(*GobEntry).C, ShouldEqual, "2")
+	})
+
+	Convey("it should not commit invalid entries", t, func() {
+		top := h.chain.Top().EntryLink
+		_, err := h.Commit("myData", "1")
+		So(err.Error(), ShouldEqual, "Invalid entry: 1")
+		_, err = h.Commit("bogusType", "1")
+		So(err.Error(), ShouldEqual, "no definition for entry type: bogusType")
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, top.String())
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// hooks implements Go callbacks that programs embedding a holochain can register to be run
+// as entries are committed: pre-commit hooks can veto a commit, and post-commit hooks can,
+// e.g., index committed entries into an external search system.  Hooks run synchronously in
+// the order they were added, and a hook that panics is treated as having returned an error.
+
+package holochain
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CommitHook is called with the hash, header and content of an entry being committed
+type CommitHook func(hash Hash, header *Header, entry Entry) error
+
+type namedHook struct {
+	name string
+	fn   CommitHook
+}
+
+// commitHooks holds the hooks registered on a chain
+type commitHooks struct {
+	lk   sync.RWMutex
+	pre  []namedHook
+	post []namedHook
+}
+
+func newCommitHooks() *commitHooks {
+	return &commitHooks{}
+}
+
+// AddPreCommitHook adds a hook that is run before an entry is committed, after it has been
+// validated.  If the hook returns an error the entry isn't committed and Commit returns it.
+func (h *Holochain) AddPreCommitHook(name string, fn CommitHook) {
+	h.hooks.lk.Lock()
+	defer h.hooks.lk.Unlock()
+	h.hooks.pre = append(h.hooks.pre, namedHook{name: name, fn: fn})
+}
+
+// AddPostCommitHook adds a hook that is run after an entry has been committed.  As the entry
+// is already on the chain, errors returned by the hook are only logged.
+func (h *Holochain) AddPostCommitHook(name string, fn CommitHook) {
+	h.hooks.lk.Lock()
+	defer h.hooks.lk.Unlock()
+	h.hooks.post = append(h.hooks.post, namedHook{name: name, fn: fn})
+}
+
+// RemoveCommitHooks removes the pre and post commit hooks of the given name
+func (h *Holochain) RemoveCommitHooks(name string) {
+	h.hooks.lk.Lock()
+	defer h.hooks.lk.Unlock()
+	remove := func(hooks []namedHook) (kept []namedHook) {
+		for _, x := range hooks {
+			if x.name != name {
+				kept = append(kept, x)
+			}
+		}
+		return
+	}
+	h.hooks.pre = remove(h.hooks.pre)
+	h.hooks.post = remove(h.hooks.post)
+}
+
+// runHook calls a hook, turning a panic in it into an error
+func runHook(x namedHook, hash Hash, header *Header, entry Entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("commit hook %s panicked: %v", x.name, r)
+		}
+	}()
+	err = x.fn(hash, header, entry)
+	return
+}
+
+// registered returns copies of the pre and post commit hooks, so that they can be run
+// without holding the lock, as hooks may themselves add or remove hooks, or commit
+func (hooks *commitHooks) registered() (pre []namedHook, post []namedHook) {
+	hooks.lk.RLock()
+	defer hooks.lk.RUnlock()
+	pre = append([]namedHook{}, hooks.pre...)
+	post = append([]namedHook{}, hooks.post...)
+	return
+}
+
+// preCommit runs the pre-commit hooks in order, stopping at the first that vetoes the commit
+func (h *Holochain) preCommit(hash Hash, header *Header, entry Entry) (err error) {
+	pre, _ := h.hooks.registered()
+	for _, x := range pre {
+		if err = runHook(x, hash, header, entry); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// postCommit runs all the post-commit hooks in order, logging any errors
+func (h *Holochain) postCommit(hash Hash, header *Header, entry Entry) {
+	_, post := h.hooks.registered()
+	for _, x := range post {
+		if err := runHook(x, hash, header, entry); err != nil {
+			Infof("%s: post-commit hook %s failed for %v: %v", h.Name, x.name, header.EntryLink, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package holochain
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCommitHooks(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	var calls []string
+	var indexed []string
+	h.AddPreCommitHook("first", func(hash Hash, header *Header, entry Entry) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	h.AddPreCommitHook("veto", func(hash Hash, header *Header, entry Entry) error {
+		calls = append(calls, "veto")
+		if entry.Content() == "4" {
+			return errors.New("no fours")
+		}
+		return nil
+	})
+	h.AddPostCommitHook("index", func(hash Hash, header *Header, entry Entry) error {
+		indexed = append(indexed, hash.String())
+		return nil
+	})
+	h.AddPostCommitHook("broken", func(hash Hash, header *Header, entry Entry) error {
+		panic("oops")
+	})
+
+	Convey("it should run the hooks in order around a commit", t, func() {
+		hash, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		So(calls, ShouldResemble, []string{"first", "veto"})
+		So(indexed, ShouldResemble, []string{hash.String()})
+	})
+
+	Convey("a pre-commit hook should be able to veto a commit", t, func() {
+		calls = nil
+		l := h.chain.Length()
+		_, err := h.Commit("myData", "4")
+		So(err.Error(), ShouldEqual, "no fours")
+		So(h.chain.Length(), ShouldEqual, l)
+		So(len(indexed), ShouldEqual, 1)
+	})
+
+	Convey("a panicking pre-commit hook should veto the commit", t, func() {
+		h.AddPreCommitHook("panics", func(hash Hash, header *Header, entry Entry) error {
+			panic("boom")
+		})
+		_, err := h.Commit("myData", "6")
+		So(err.Error(), ShouldEqual, "commit hook panics panicked: boom")
+	})
+
+	Convey("it should remove hooks by name", t, func() {
+		h.RemoveCommitHooks("panics")
+		h.RemoveCommitHooks("veto")
+		_, err := h.Commit("myData", "4")
+		So(err, ShouldBeNil)
+		So(len(indexed), ShouldEqual, 2)
+	})
+	Convey("a hook should be able to add and remove hooks and commit", t, func() {
+		var added bool
+		h.AddPostCommitHook("once", func(hash Hash, header *Header, entry Entry) error {
+			h.RemoveCommitHooks("once")
+			h.AddPostCommitHook("added", func(hash Hash, header *Header, entry Entry) error {
+				added = true
+				return nil
+			})
+			_, err := h.Commit("myData", "8")
+			return err
+		})
+		_, err := h.Commit("myData", "10")
+		So(err, ShouldBeNil)
+		So(added, ShouldBeTrue)
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// idempotency implements zome calls that carry an idempotency key, so that a client which
+// retries a call it didn't hear back from doesn't commit twice.  The result of each successful
+// call to a function that isn't read only is recorded under its key for a limited time, and a
+// retry with the same key gets the recorded result instead of making the call again.
+
+package holochain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultIdempotencyTTL = "24h"
+)
+
+var ErrIdempotencyKeyReused error = errors.New("idempotency key already used for a different call")
+
+// idempotentResult is the record of a call made with an idempotency key
+type idempotentResult struct {
+	Call   string // identifies the function and arguments called
+	Result interface{}
+	Bytes  []byte // the result if it was a byte slice, kept as one
+}
+
+// idempotency tracks the calls with idempotency keys that are under way
+type idempotency struct {
+	lk      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+func newIdempotency() *idempotency {
+	return &idempotency{pending: make(map[string]chan struct{})}
+}
+
+// IdempotencyTTLDuration returns how long the results of calls with idempotency keys are kept
+func (config *Config) IdempotencyTTLDuration() (ttl time.Duration, err error) {
+	t := config.IdempotencyTTL
+	if t == "" {
+		t = DefaultIdempotencyTTL
+	}
+	ttl, err = time.ParseDuration(t)
+	if err == nil && ttl <= 0 {
+		err = fmt.Errorf("idempotency ttl must be positive, got: %s", t)
+	}
+	return
+}
+
+// callSignature identifies a function and its arguments
+func callSignature(zome string, function string, arguments interface{}) (sig string, err error) {
+	var b []byte
+	if b, err = json.Marshal([]interface{}{zome, function, arguments}); err != nil {
+		return
+	}
+	s := sha256.Sum256(b)
+	sig = hex.EncodeToString(s[:])
+	return
+}
+
+// recordedCall returns the recorded result of the call with the idempotency key, if any
+func (h *Holochain) recordedCall(key string, sig string) (r *idempotentResult, err error) {
+	err = h.dht.db.View(func(tx *buntdb.Tx) error {
+		val, e := tx.Get("idem:" + key)
+		if e == buntdb.ErrNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		var x idempotentResult
+		if e = json.Unmarshal([]byte(val), &x); e != nil {
+			return e
+		}
+		r = &x
+		return nil
+	})
+	if err == nil && r != nil && r.Call != sig {
+		err = ErrIdempotencyKeyReused
+	}
+	return
+}
+
+// recordCall keeps the result of a call under its idempotency key for the configured time
+func (h *Holochain) recordCall(key string, r *idempotentResult) (err error) {
+	var ttl time.Duration
+	config := h.Config()
+	if ttl, err = config.IdempotencyTTLDuration(); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(r); err != nil {
+		return
+	}
+	err = h.dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, e := tx.Set("idem:"+key, string(b), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return e
+	})
+	return
+}
+
+// CallIdempotent makes a call like Call, but if a call with the same idempotency key was
+// already made successfully it returns that call's result instead, with replayed set.  Calls
+// to read only functions, or without a key, are always made.  Concurrent calls with the same
+// key wait for the first one to finish.
+func (h *Holochain) CallIdempotent(key string, zomeType string, function string, arguments interface{}) (result interface{}, replayed bool, err error) {
+	if key == "" || h.readOnly(&BatchCall{Zome: zomeType, Fn: function}) {
+		result, err = h.Call(zomeType, function, arguments)
+		return
+	}
+	// a replay must be as out of reach of a scoped caller as the call itself
+	if err = h.checkCallScope(zomeType, function); err != nil {
+		return
+	}
+	var sig string
+	if sig, err = callSignature(zomeType, function, arguments); err != nil {
+		return
+	}
+	var done chan struct{}
+	for done == nil {
+		// the record is checked with the lock held, as a finished call records its result
+		// before it stops being pending
+		h.idempotency.lk.Lock()
+		var r *idempotentResult
+		if r, err = h.recordedCall(key, sig); err != nil || r != nil {
+			h.idempotency.lk.Unlock()
+			if r != nil {
+				result, replayed = r.Result, true
+				if r.Bytes != nil {
+					result = r.Bytes
+				}
+				h.metrics.Inc("calls.replayed", 1)
+			}
+			return
+		}
+		if wait, ok := h.idempotency.pending[key]; ok {
+			h.idempotency.lk.Unlock()
+			<-wait
+			continue
+		}
+		done = make(chan struct{})
+		h.idempotency.pending[key] = done
+		h.idempotency.lk.Unlock()
+	}
+	defer func() {
+		h.idempotency.lk.Lock()
+		delete(h.idempotency.pending, key)
+		h.idempotency.lk.Unlock()
+		close(done)
+	}()
+
+	result, err = h.Call(zomeType, function, arguments)
+	if err != nil {
+		return
+	}
+	r := idempotentResult{Call: sig, Result: result}
+	if b, ok := result.([]byte); ok {
+		r.Result, r.Bytes = nil, b
+	}
+	if e := h.recordCall(key, &r); e != nil {
+		Infof("unable to record result of call with idempotency key %s: %v", key, e)
+	}
+	return
+}
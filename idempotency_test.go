@@ -0,0 +1,82 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+)
+
+func TestCallIdempotent(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should validate the ttl setting", t, func() {
+		config := Config{IdempotencyTTL: "0s"}
+		_, err := config.IdempotencyTTLDuration()
+		So(err.Error(), ShouldEqual, "idempotency ttl must be positive, got: 0s")
+	})
+
+	Convey("calls without a key should always be made", t, func() {
+		l := h.chain.Length()
+		_, replayed, err := h.CallIdempotent("", "myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		So(replayed, ShouldBeFalse)
+		_, _, err = h.CallIdempotent("", "myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+2)
+	})
+
+	Convey("retries with the same key should return the first result without committing", t, func() {
+		l := h.chain.Length()
+		r1, replayed, err := h.CallIdempotent("key1", "myZome", "addData", "4")
+		So(err, ShouldBeNil)
+		So(replayed, ShouldBeFalse)
+		r2, replayed, err := h.CallIdempotent("key1", "myZome", "addData", "4")
+		So(err, ShouldBeNil)
+		So(replayed, ShouldBeTrue)
+		So(r2, ShouldResemble, r1)
+		So(h.chain.Length(), ShouldEqual, l+1)
+		So(h.Metrics().Get("calls.replayed"), ShouldEqual, int64(1))
+	})
+
+	Convey("replays should be refused to callers the function is out of scope for", t, func() {
+		scoped := h.WithScope(&APIToken{Functions: []string{"myZome:getDNA"}})
+		_, replayed, err := scoped.CallIdempotent("key1", "myZome", "addData", "4")
+		So(err.Error(), ShouldEqual, "myZome:addData is not in the scope of this token")
+		So(replayed, ShouldBeFalse)
+	})
+
+	Convey("byte results should be replayed as bytes", t, func() {
+		So(h.recordCall("key3", &idempotentResult{Call: "sig", Bytes: []byte{0xff, 0}}), ShouldBeNil)
+		r, err := h.recordedCall("key3", "sig")
+		So(err, ShouldBeNil)
+		So(r.Bytes, ShouldResemble, []byte{0xff, 0})
+	})
+
+	Convey("a key shouldn't be reused for a different call", t, func() {
+		_, _, err := h.CallIdempotent("key1", "myZome", "addData", "6")
+		So(err, ShouldEqual, ErrIdempotencyKeyReused)
+	})
+
+	Convey("failed calls shouldn't be recorded", t, func() {
+		_, _, err := h.CallIdempotent("key2", "myZome", "addData", "5")
+		So(err, ShouldNotBeNil)
+		_, replayed, err := h.CallIdempotent("key2", "myZome", "addData", "5")
+		So(err, ShouldNotBeNil)
+		So(replayed, ShouldBeFalse)
+	})
+
+	Convey("concurrent retries should only commit once", t, func() {
+		l := h.chain.Length()
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.CallIdempotent("key3", "myZome", "addData", "8")
+			}()
+		}
+		wg.Wait()
+		So(h.chain.Length(), ShouldEqual, l+1)
+	})
+}
@@ -0,0 +1,486 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// identity implements attested identities, so that an organization's chains can tie their
+// agents to its own directory of people.  A DNA declaring an Identity policy has each agent
+// fetch an assertion of who it is from an identity provider at genesis, e.g. an OIDC ID token
+// whose nonce is the agent's node id, or a record from an HR system signed by one of the
+// DNA's identity signers.  The assertion is kept in the agent entry, whose name can be taken
+// from one of its claims, and DHT nodes refuse the entries of agents whose assertion doesn't
+// verify, passing the claims of those that do to the validation rules in props.Claims.
+// Expiry isn't checked, as other nodes validate the agent entry long after genesis.
+
+package holochain
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const (
+	// kinds of identity assertion
+	IdentityOIDC   = "oidc"   // an OIDC ID token signed with RS256 by the DNA's issuer
+	IdentitySigned = "signed" // a record signed by one of the DNA's identity signers
+)
+
+var ErrNoIdentity error = errors.New("agent hasn't presented the identity the chain requires")
+var ErrBadIdentity error = errors.New("agent's identity assertion doesn't verify")
+var ErrNoIdentityProvider error = errors.New("chain requires an identity assertion but no identity provider is set")
+
+// IdentityPolicy declares in the DNA the attested identity agents must present to join
+type IdentityPolicy struct {
+	Kind      string
+	Issuer    string   `json:",omitempty" toml:",omitempty"` // for oidc, the iss claim tokens must have
+	Audience  string   `json:",omitempty" toml:",omitempty"` // for oidc, the aud claim tokens must have, if set
+	Keys      []string `json:",omitempty" toml:",omitempty"` // for oidc, the issuer's PEM encoded RSA public keys
+	Signers   []string `json:",omitempty" toml:",omitempty"` // for signed, node ids of the agents whose records are accepted
+	NameClaim string   `json:",omitempty" toml:",omitempty"` // if set, the claim agents' names are taken from
+}
+
+// IdentityAssertion is an agent's attested identity, kept in its agent entry
+type IdentityAssertion struct {
+	Kind  string
+	Token string // the assertion as its provider issued it
+}
+
+// SignedIdentity is a record of who an agent is, e.g. from an HR system, signed by one of the
+// DNA's identity signers
+type SignedIdentity struct {
+	Agent     string            // node id of the agent the record identifies
+	Claims    map[string]string // what the signer asserts about the agent, e.g. its name
+	Signer    string            // node id of the signer
+	PubKey    string            // b58 encoded marshaled public key of the signer
+	Signature string            // b58 encoded signature of the agent and claims
+}
+
+// IdentityVerifier checks an assertion of a kind against the DNA's policy and that it was
+// issued to the agent, returning its claims
+type IdentityVerifier func(p *IdentityPolicy, token string, agent peer.ID) (claims map[string]interface{}, err error)
+
+// IdentityProvider fetches the assertion of an agent's identity required to join a chain
+type IdentityProvider interface {
+	Assert(p *IdentityPolicy, agent peer.ID, dna Hash) (token string, err error)
+}
+
+// IdentityToken provides an assertion the agent was already issued, e.g. an ID token from
+// signing in to the organization's OIDC provider with its node id as the nonce
+type IdentityToken string
+
+// Assert returns the token
+func (t IdentityToken) Assert(p *IdentityPolicy, agent peer.ID, dna Hash) (string, error) {
+	return string(t), nil
+}
+
+// IdentityCommand provides assertions by running a shell command, e.g. one that signs in to an
+// OIDC provider or looks the agent up in LDAP and has its record signed, which prints the
+// assertion.  The command is given the agent's node id, the DNA hash and the policy in the
+// environment as HC_AGENT_ID, HC_DNA_HASH, HC_IDENTITY_KIND, HC_IDENTITY_ISSUER and
+// HC_IDENTITY_AUDIENCE.
+type IdentityCommand string
+
+// Assert runs the command and returns what it prints
+func (c IdentityCommand) Assert(p *IdentityPolicy, agent peer.ID, dna Hash) (token string, err error) {
+	cmd := exec.Command("sh", "-c", string(c))
+	cmd.Env = append(os.Environ(),
+		"HC_AGENT_ID="+peer.IDB58Encode(agent),
+		"HC_DNA_HASH="+dna.String(),
+		"HC_IDENTITY_KIND="+p.Kind,
+		"HC_IDENTITY_ISSUER="+p.Issuer,
+		"HC_IDENTITY_AUDIENCE="+p.Audience,
+	)
+	cmd.Stderr = os.Stderr
+	var out []byte
+	if out, err = cmd.Output(); err != nil {
+		err = fmt.Errorf("identity command failed: %v", err)
+		return
+	}
+	token = strings.TrimSpace(string(out))
+	return
+}
+
+var identityVerifiers = map[string]IdentityVerifier{
+	IdentityOIDC:   verifyOIDCToken,
+	IdentitySigned: verifySignedIdentity,
+}
+
+// RegisterIdentityVerifier adds a kind of identity assertion that DNAs can require
+func RegisterIdentityVerifier(kind string, verifier IdentityVerifier) {
+	if verifier == nil {
+		panic("Identity verifier for kind " + kind + " does not exist.")
+	}
+	if _, registered := identityVerifiers[kind]; registered {
+		panic("Identity verifier for kind " + kind + " already registered.")
+	}
+	identityVerifiers[kind] = verifier
+}
+
+// Check confirms that the policy is of a known kind and says whose assertions to accept
+func (p *IdentityPolicy) Check() (err error) {
+	if _, ok := identityVerifiers[p.Kind]; !ok {
+		err = fmt.Errorf("unknown identity kind: %s", p.Kind)
+		return
+	}
+	switch p.Kind {
+	case IdentityOIDC:
+		if p.Issuer == "" {
+			err = errors.New("oidc identity declared without an issuer")
+			return
+		}
+		if len(p.Keys) == 0 {
+			err = errors.New("oidc identity declared without any issuer keys")
+			return
+		}
+		for _, k := range p.Keys {
+			if _, e := parseRSAKey(k); e != nil {
+				err = fmt.Errorf("bad issuer key: %v", e)
+				return
+			}
+		}
+	case IdentitySigned:
+		if len(p.Signers) == 0 {
+			err = errors.New("signed identity declared without any signers")
+			return
+		}
+		for _, s := range p.Signers {
+			if _, e := peer.IDB58Decode(s); e != nil {
+				err = fmt.Errorf("bad identity signer %s: %v", s, e)
+				return
+			}
+		}
+	}
+	return
+}
+
+// agentName returns the name the policy takes from an agent's claims
+func (p *IdentityPolicy) agentName(claims map[string]interface{}) (name AgentName, err error) {
+	v, ok := claims[p.NameClaim]
+	if !ok {
+		err = fmt.Errorf("identity has no %s claim to name the agent", p.NameClaim)
+		return
+	}
+	name = AgentName(fmt.Sprintf("%v", v))
+	return
+}
+
+// parseRSAKey parses a PEM encoded RSA public key
+func parseRSAKey(s string) (key *rsa.PublicKey, err error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		err = errors.New("expected PEM encoded public key")
+		return
+	}
+	var k interface{}
+	if k, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return
+	}
+	key, ok := k.(*rsa.PublicKey)
+	if !ok {
+		err = errors.New("expected RSA public key")
+	}
+	return
+}
+
+// hasAudience returns true if an aud claim, a string or a list of them, includes the audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyOIDCToken checks that an ID token was signed by the DNA's issuer for its audience,
+// with the agent's node id as its nonce
+func verifyOIDCToken(p *IdentityPolicy, token string, agent peer.ID) (claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = errors.New("expected an ID token of three parts")
+		return
+	}
+	var b []byte
+	if b, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		return
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(b, &header); err != nil {
+		return
+	}
+	if header.Alg != "RS256" {
+		err = fmt.Errorf("unsupported ID token algorithm: %s", header.Alg)
+		return
+	}
+	var sig []byte
+	if sig, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, k := range p.Keys {
+		if key, e := parseRSAKey(k); e == nil && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		err = ErrBadIdentity
+		return
+	}
+	if b, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &claims); err != nil {
+		return
+	}
+	if claims["iss"] != p.Issuer {
+		err = fmt.Errorf("ID token issued by %v, not %s", claims["iss"], p.Issuer)
+		return
+	}
+	if p.Audience != "" && !hasAudience(claims["aud"], p.Audience) {
+		err = fmt.Errorf("ID token not issued for %s", p.Audience)
+		return
+	}
+	if claims["nonce"] != peer.IDB58Encode(agent) {
+		err = errors.New("ID token not issued to the agent")
+	}
+	return
+}
+
+// signed returns what an identity signer signs
+func (r *SignedIdentity) signed() []byte {
+	var keys []string
+	for k := range r.Claims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := []string{"identity", r.Agent}
+	for _, k := range keys {
+		lines = append(lines, k+"="+r.Claims[k])
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Verify checks that the record was signed by its signer
+func (r *SignedIdentity) Verify() (err error) {
+	if err = verifySigned(r.Signer, r.PubKey, r.signed(), r.Signature); err == ErrBadRelaySignature {
+		err = ErrBadIdentity
+	}
+	return
+}
+
+// NewSignedIdentity returns a record of an agent's identity, signed by this chain's agent, for
+// the agent to present at genesis
+func (h *Holochain) NewSignedIdentity(agent string, claims map[string]string) (token string, err error) {
+	if _, err = peer.IDB58Decode(agent); err != nil {
+		return
+	}
+	r := SignedIdentity{Agent: agent, Claims: claims, Signer: peer.IDB58Encode(h.id)}
+	var pk []byte
+	if pk, err = ic.MarshalPublicKey(h.agent.PubKey()); err != nil {
+		return
+	}
+	r.PubKey = b58.Encode(pk)
+	var sig []byte
+	if sig, err = h.agent.PrivKey().Sign(r.signed()); err != nil {
+		return
+	}
+	r.Signature = b58.Encode(sig)
+	var b []byte
+	if b, err = json.Marshal(r); err != nil {
+		return
+	}
+	token = b58.Encode(b)
+	return
+}
+
+// verifySignedIdentity checks that a record was signed for the agent by one of the DNA's
+// identity signers
+func verifySignedIdentity(p *IdentityPolicy, token string, agent peer.ID) (claims map[string]interface{}, err error) {
+	var r SignedIdentity
+	if err = json.Unmarshal(b58.Decode(token), &r); err != nil {
+		err = fmt.Errorf("bad identity record: %v", err)
+		return
+	}
+	if r.Agent != peer.IDB58Encode(agent) {
+		err = errors.New("identity record not issued to the agent")
+		return
+	}
+	if !hasString(p.Signers, r.Signer) {
+		err = fmt.Errorf("%s isn't one of the chain's identity signers", r.Signer)
+		return
+	}
+	if err = r.Verify(); err != nil {
+		return
+	}
+	claims = make(map[string]interface{})
+	for k, v := range r.Claims {
+		claims[k] = v
+	}
+	return
+}
+
+// verifyIdentity checks an agent's identity assertion against the DNA's policy
+func (h *Holochain) verifyIdentity(agent peer.ID, a *IdentityAssertion) (claims map[string]interface{}, err error) {
+	p := h.Identity
+	if p == nil {
+		return
+	}
+	if a == nil {
+		err = ErrNoIdentity
+		return
+	}
+	if a.Kind != p.Kind {
+		err = fmt.Errorf("chain requires a %s identity, got: %s", p.Kind, a.Kind)
+		return
+	}
+	verify, ok := identityVerifiers[p.Kind]
+	if !ok {
+		err = fmt.Errorf("unknown identity kind: %s", p.Kind)
+		return
+	}
+	claims, err = verify(p, a.Token, agent)
+	return
+}
+
+// SetIdentityProvider sets where the agent's identity assertion is fetched from at genesis
+func (h *Holochain) SetIdentityProvider(p IdentityProvider) {
+	h.identity = p
+}
+
+// identityProvider returns the provider set for the chain, or the service's identity command
+func (h *Holochain) identityProvider() IdentityProvider {
+	if h.identity != nil {
+		return h.identity
+	}
+	if h.service != nil && h.service.Settings.IdentityCommand != "" {
+		return IdentityCommand(h.service.Settings.IdentityCommand)
+	}
+	return nil
+}
+
+// identityAssertion fetches the agent's identity assertion for its genesis, if the DNA requires
+// one, returning its claims
+func (h *Holochain) identityAssertion() (a *IdentityAssertion, claims map[string]interface{}, err error) {
+	p := h.Identity
+	if p == nil {
+		return
+	}
+	provider := h.identityProvider()
+	if provider == nil {
+		err = ErrNoIdentityProvider
+		return
+	}
+	var token string
+	if token, err = provider.Assert(p, h.id, h.dnaHash); err != nil {
+		return
+	}
+	a = &IdentityAssertion{Kind: p.Kind, Token: token}
+	if claims, err = h.verifyIdentity(h.id, a); err != nil {
+		err = fmt.Errorf("can't join: %v", err)
+	}
+	return
+}
+
+// agentIdentity returns the identity assertion in the agent entry of the chain
+func (h *Holochain) agentIdentity() *IdentityAssertion {
+	i, ok := h.chain.TypeTops[AgentEntryType]
+	if !ok {
+		return nil
+	}
+	if a, ok := h.chain.Entries[i].Content().(AgentEntry); ok {
+		return a.Identity
+	}
+	return nil
+}
+
+// validateAgentIdentity checks the identity assertion of an agent entry, and that the agent is
+// named as it asserts if the DNA takes names from a claim
+func (h *Holochain) validateAgentIdentity(entry Entry, props *ValidationProps) (err error) {
+	a, ok := entry.Content().(AgentEntry)
+	if !ok {
+		err = errors.New("expected agent entry")
+		return
+	}
+	var key ic.PubKey
+	if key, err = ic.UnmarshalPublicKey(a.Key); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(key); err != nil {
+		return
+	}
+	var claims map[string]interface{}
+	if claims, err = h.verifyIdentity(id, a.Identity); err != nil {
+		return
+	}
+	if h.Identity.NameClaim != "" {
+		var name AgentName
+		if name, err = h.Identity.agentName(claims); err != nil {
+			return
+		}
+		if name != a.Name {
+			err = fmt.Errorf("agent named %s, but its identity asserts %s", a.Name, name)
+			return
+		}
+	}
+	if props != nil {
+		props.Claims = claims
+	}
+	return
+}
+
+func identityKey(id peer.ID) string {
+	return "identity:" + peer.IDB58Encode(id)
+}
+
+// checkIdentity confirms that the source of a put has a verified identity, remembering the
+// claims of sources that do so that their assertions aren't verified again
+func (dht *DHT) checkIdentity(from peer.ID, a *IdentityAssertion) (claims map[string]interface{}, err error) {
+	if dht.h.Identity == nil {
+		return
+	}
+	var known string
+	dht.db.View(func(tx *buntdb.Tx) error {
+		known, _ = tx.Get(identityKey(from))
+		return nil
+	})
+	if known != "" {
+		err = json.Unmarshal([]byte(known), &claims)
+		return
+	}
+	if claims, err = dht.h.verifyIdentity(from, a); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(claims); err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(identityKey(from), string(b), nil)
+		return err
+	})
+	return
+}
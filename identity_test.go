@@ -0,0 +1,156 @@
+package holochain
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// makeIDToken returns an RS256 ID token with the given claims signed by key
+func makeIDToken(key *rsa.PrivateKey, alg string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestIdentityPolicyCheck(t *testing.T) {
+	Convey("it should check identity policies", t, func() {
+		So((&IdentityPolicy{Kind: "kerberos"}).Check().Error(), ShouldEqual, "unknown identity kind: kerberos")
+		So((&IdentityPolicy{Kind: IdentityOIDC, Keys: []string{"key"}}).Check().Error(), ShouldEqual, "oidc identity declared without an issuer")
+		So((&IdentityPolicy{Kind: IdentityOIDC, Issuer: "https://id.example.com"}).Check().Error(), ShouldEqual, "oidc identity declared without any issuer keys")
+		So((&IdentityPolicy{Kind: IdentityOIDC, Issuer: "https://id.example.com", Keys: []string{"key"}}).Check(), ShouldNotBeNil)
+		So((&IdentityPolicy{Kind: IdentitySigned}).Check().Error(), ShouldEqual, "signed identity declared without any signers")
+		So((&IdentityPolicy{Kind: IdentitySigned, Signers: []string{"fish"}}).Check(), ShouldNotBeNil)
+	})
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	p := IdentityPolicy{Kind: IdentityOIDC, Issuer: "https://id.example.com", Audience: "app", Keys: []string{pub}, NameClaim: "email"}
+	agent, _ := makePeer("agent")
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{"iss": p.Issuer, "aud": []string{"app", "other"}, "nonce": peer.IDB58Encode(agent), "email": "herbert@example.com"}
+	}
+
+	Convey("it should accept ID tokens from the issuer for the agent", t, func() {
+		So(p.Check(), ShouldBeNil)
+		c, err := verifyOIDCToken(&p, makeIDToken(key, "RS256", claims()), agent)
+		So(err, ShouldBeNil)
+		name, err := p.agentName(c)
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, AgentName("herbert@example.com"))
+	})
+
+	Convey("it should refuse ID tokens not issued to the agent by the issuer", t, func() {
+		other, _ := makePeer("other")
+		_, err := verifyOIDCToken(&p, makeIDToken(key, "RS256", claims()), other)
+		So(err.Error(), ShouldEqual, "ID token not issued to the agent")
+		c := claims()
+		c["iss"] = "https://evil.example.com"
+		_, err = verifyOIDCToken(&p, makeIDToken(key, "RS256", c), agent)
+		So(err, ShouldNotBeNil)
+		c = claims()
+		c["aud"] = "other"
+		_, err = verifyOIDCToken(&p, makeIDToken(key, "RS256", c), agent)
+		So(err.Error(), ShouldEqual, "ID token not issued for app")
+		_, err = verifyOIDCToken(&p, makeIDToken(key, "HS256", claims()), agent)
+		So(err.Error(), ShouldEqual, "unsupported ID token algorithm: HS256")
+
+		other2, _ := rsa.GenerateKey(rand.Reader, 2048)
+		_, err = verifyOIDCToken(&p, makeIDToken(other2, "RS256", claims()), agent)
+		So(err, ShouldEqual, ErrBadIdentity)
+		_, err = verifyOIDCToken(&p, "not.a-token", agent)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSignedIdentity(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	me := peer.IDB58Encode(h.id)
+	h.Identity = &IdentityPolicy{Kind: IdentitySigned, Signers: []string{me}, NameClaim: "name"}
+
+	Convey("genesis should need an identity provider", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldEqual, ErrNoIdentityProvider)
+	})
+
+	token, err := h.NewSignedIdentity(me, map[string]string{"name": "Herbert", "dept": "accounts"})
+	if err != nil {
+		panic(err)
+	}
+	h.SetIdentityProvider(IdentityToken(token))
+	if _, err := h.GenChain(); err != nil {
+		panic(err)
+	}
+
+	Convey("genesis should keep the identity in the agent entry named from its claims", t, func() {
+		a := h.chain.Entries[h.chain.TypeTops[AgentEntryType]].Content().(AgentEntry)
+		So(a.Identity.Kind, ShouldEqual, IdentitySigned)
+		So(a.Name, ShouldEqual, AgentName("Herbert"))
+
+		p := ValidationProps{}
+		So(h.ValidateEntry(AgentEntryType, &GobEntry{C: a}, &p), ShouldBeNil)
+		So(p.Claims["dept"], ShouldEqual, "accounts")
+
+		a.Name = "Imposter"
+		So(h.ValidateEntry(AgentEntryType, &GobEntry{C: a}, &p).Error(), ShouldEqual, "agent named Imposter, but its identity asserts Herbert")
+		a.Name = "Herbert"
+		a.Identity = nil
+		So(h.ValidateEntry(AgentEntryType, &GobEntry{C: a}, &p), ShouldEqual, ErrNoIdentity)
+	})
+
+	Convey("records should only be good for the agent they identify", t, func() {
+		other, _ := makePeer("other")
+		_, err := verifySignedIdentity(h.Identity, token, other)
+		So(err.Error(), ShouldEqual, "identity record not issued to the agent")
+
+		record, err := h.NewSignedIdentity(peer.IDB58Encode(other), map[string]string{"name": "Other"})
+		So(err, ShouldBeNil)
+		_, err = verifySignedIdentity(&IdentityPolicy{Kind: IdentitySigned, Signers: []string{peer.IDB58Encode(other)}}, record, other)
+		So(err.Error(), ShouldEqual, me+" isn't one of the chain's identity signers")
+		claims, err := verifySignedIdentity(h.Identity, record, other)
+		So(err, ShouldBeNil)
+		So(claims["name"], ShouldEqual, "Other")
+	})
+
+	Convey("DHT nodes should remember the claims of sources with verified identities", t, func() {
+		other, _ := makePeer("other")
+		_, err := h.dht.checkIdentity(other, nil)
+		So(err, ShouldEqual, ErrNoIdentity)
+		record, _ := h.NewSignedIdentity(peer.IDB58Encode(other), map[string]string{"name": "Other"})
+		claims, err := h.dht.checkIdentity(other, &IdentityAssertion{Kind: IdentitySigned, Token: record})
+		So(err, ShouldBeNil)
+		So(claims["name"], ShouldEqual, "Other")
+		claims, err = h.dht.checkIdentity(other, nil)
+		So(err, ShouldBeNil)
+		So(claims["name"], ShouldEqual, "Other")
+	})
+
+	Convey("identity commands should be given the agent", t, func() {
+		token, err := IdentityCommand("echo token-$HC_AGENT_ID-$HC_IDENTITY_KIND").Assert(h.Identity, h.id, h.dnaHash)
+		So(err, ShouldBeNil)
+		So(token, ShouldEqual, "token-"+me+"-signed")
+	})
+}
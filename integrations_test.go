@@ -0,0 +1,165 @@
+package holochain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIntegrationCheck(t *testing.T) {
+	Convey("it should check integrations' configuration", t, func() {
+		x := Integration{}
+		So(x.Check().Error(), ShouldEqual, "integration missing name")
+		x.Name = "hook"
+		So(x.Check().Error(), ShouldEqual, "integration hook: exactly one of Webhook or MQTT must be set")
+		x.Webhook = "ftp://example.com"
+		So(x.Check().Error(), ShouldEqual, "integration hook: webhook must be an http or https url, got: ftp://example.com")
+		x.Webhook = "https://example.com/hook"
+		So(x.Check(), ShouldBeNil)
+		x.Events = []string{"commit", "bogus"}
+		So(x.Check().Error(), ShouldEqual, "integration hook: unknown event: bogus")
+
+		x = Integration{Name: "mq", MQTT: "mqtt://localhost"}
+		So(x.Check().Error(), ShouldEqual, "integration mq: missing MQTT topic")
+		x.Topic = "chain/{event}"
+		So(x.Check(), ShouldBeNil)
+		x.MQTT = "amqp://localhost"
+		So(x.Check().Error(), ShouldEqual, "integration mq: unknown MQTT broker scheme: amqp")
+	})
+
+	Convey("it should forward commits by default", t, func() {
+		x := Integration{Name: "hook", Webhook: "http://localhost"}
+		mask, err := x.eventMask()
+		So(err, ShouldBeNil)
+		So(mask, ShouldEqual, EventCommit)
+		x.Events = []string{"put", "validation-failed"}
+		mask, _ = x.eventMask()
+		So(mask, ShouldEqual, EventPutReceived|EventValidationFailed)
+	})
+}
+
+func TestWebhookIntegration(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	bodies := make(chan []byte, 10)
+	sigs := make(chan string, 10)
+	var failures int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "try again", 503)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- b
+		sigs <- r.Header.Get(SignatureHeader)
+	}))
+	defer ts.Close()
+	integrationRetryDelay = time.Millisecond
+
+	Convey("it should post signed events of the selected entry types", t, func() {
+		h.config.Integrations = []Integration{{Name: "hook", Webhook: ts.URL, EntryTypes: []string{"myData"}, Secret: "shh"}}
+		stop := make(chan struct{})
+		defer close(stop)
+		So(h.ForwardIntegrations(stop), ShouldBeNil)
+
+		h.Commit("primes", `{"prime":7}`)
+		hash, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+
+		var b []byte
+		select {
+		case b = <-bodies:
+		case <-time.After(time.Second):
+		}
+		So(b, ShouldNotBeNil)
+		So(<-sigs, ShouldEqual, SignIntegrationPayload("shh", b))
+		var m IntegrationMessage
+		So(json.Unmarshal(b, &m), ShouldBeNil)
+		So(m.Event, ShouldEqual, "commit")
+		So(m.EntryType, ShouldEqual, "myData")
+		So(m.Hash, ShouldEqual, hash.String())
+		So(m.Chain, ShouldEqual, h.DNAHash().String())
+		So(m.Entry, ShouldEqual, "2")
+	})
+
+	Convey("it shouldn't forward the content of private entries", t, func() {
+		hash, err := h.SetPref("theme", `"dark"`)
+		So(err, ShouldBeNil)
+		m := h.integrationMessage(Event{Type: EventCommit, Hash: hash, EntryType: PreferencesEntryType})
+		So(m.Hash, ShouldEqual, hash.String())
+		So(m.Entry, ShouldBeNil)
+	})
+
+	Convey("it should retry failed deliveries", t, func() {
+		x := Integration{Name: "hook", Webhook: ts.URL, Retries: 2}
+		failures = 2
+		So(h.deliverWithRetries(&x, IntegrationMessage{Event: "commit"}, nil), ShouldBeNil)
+		<-bodies
+		<-sigs
+		failures = 3
+		So(h.deliverWithRetries(&x, IntegrationMessage{Event: "commit"}, nil).Error(), ShouldEqual, "webhook responded 503 Service Unavailable")
+		So(h.Metrics().Get("integrations.failed"), ShouldEqual, 1)
+	})
+}
+
+// fakeBroker accepts a single MQTT connection, acknowledging each publish and sending on
+// published the topic and payload it was given
+func fakeBroker(l net.Listener, published chan<- [2]string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if kind, _, err := readMQTTPacket(r); err != nil || kind != mqttConnect {
+		return
+	}
+	conn.Write(mqttPacket(mqttConnack, []byte{0, 0}))
+	for {
+		kind, body, err := readMQTTPacket(r)
+		if err != nil || kind != mqttPublishQoS {
+			return
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		topic := string(body[2 : 2+n])
+		id := body[2+n : 4+n]
+		conn.Write(mqttPacket(mqttPuback, id))
+		published <- [2]string{topic, string(body[4+n:])}
+	}
+}
+
+func TestMQTTIntegration(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should publish signed events to the topic", t, func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		published := make(chan [2]string, 1)
+		go fakeBroker(l, published)
+
+		x := Integration{Name: "mq", MQTT: "mqtt://" + l.Addr().String(), Topic: "chains/{event}/{type}", Secret: "shh"}
+		So(h.deliver(&x, IntegrationMessage{Event: "commit", EntryType: "myData"}), ShouldBeNil)
+		p := <-published
+		So(p[0], ShouldEqual, "chains/commit/myData")
+		var s SignedIntegrationMessage
+		So(json.Unmarshal([]byte(p[1]), &s), ShouldBeNil)
+		So(s.Signature, ShouldEqual, SignIntegrationPayload("shh", s.Payload))
+
+		// the fake broker only accepts one connection, so this must reuse it
+		So(h.deliver(&x, IntegrationMessage{Event: "put", EntryType: "myData"}), ShouldBeNil)
+		p = <-published
+		So(p[0], ShouldEqual, "chains/put/myData")
+		x.mqtt.Close()
+	})
+}
@@ -0,0 +1,361 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// join implements fetching the DNA of a holochain directly from a running node, so that a
+// new participant can join without the DNA files being distributed out of band.  The DNA hash
+// only covers the DNA and the code and schemas it names, so bundles also carry a manifest of
+// the hashes of all their files, ui and tests included, signed by the agent that made them.
+
+package holochain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const bundleManifestTag = "holochain bundle manifest:\n"
+
+var ErrBadBundleManifest error = errors.New("bundle manifest doesn't match the bundle")
+
+// DNABundle holds the DNA file of a holochain along with the files it refers to, keyed by
+// their path relative to the holochain's directory
+type DNABundle struct {
+	Format   string
+	Files    map[string][]byte
+	Manifest *BundleManifest `json:",omitempty"`
+}
+
+// BundleManifest lists the hashes of a bundle's files, signed by the agent that made it, and
+// the DNA hash they build to
+type BundleManifest struct {
+	DNAHash string
+	Files   map[string]string // hash of each file by its path in the bundle
+	PubKey  []byte            // marshaled public key of the signing agent
+	Sig     []byte
+}
+
+// signed returns the bytes of the manifest its signature covers
+func (m *BundleManifest) signed() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(bundleManifestTag + m.DNAHash + "\n")
+	files := make([]string, 0, len(m.Files))
+	for f := range m.Files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		buf.WriteString(f + "\x00" + m.Files[f] + "\n")
+	}
+	return buf.Bytes()
+}
+
+// Sign adds a manifest of the bundle's files and the DNA hash they build to, signed with the
+// given key
+func (b *DNABundle) Sign(dnaHash Hash, hashSpec HashSpec, priv ic.PrivKey) (err error) {
+	m := BundleManifest{DNAHash: dnaHash.String(), Files: make(map[string]string)}
+	for file, data := range b.Files {
+		var h Hash
+		if err = h.Sum(hashSpec, data); err != nil {
+			return
+		}
+		m.Files[file] = h.String()
+	}
+	if m.PubKey, err = ic.MarshalPublicKey(priv.GetPublic()); err != nil {
+		return
+	}
+	if m.Sig, err = priv.Sign(m.signed()); err != nil {
+		return
+	}
+	b.Manifest = &m
+	return
+}
+
+// Verify checks that the bundle's manifest was signed by the given agent for the expected DNA
+// hash and that it lists exactly the bundle's files with their hashes
+func (b *DNABundle) Verify(signer peer.ID, expected Hash, hashSpec HashSpec) (err error) {
+	m := b.Manifest
+	if m == nil {
+		err = fmt.Errorf("%v: no manifest", ErrBadBundleManifest)
+		return
+	}
+	var pub ic.PubKey
+	if pub, err = ic.UnmarshalPublicKey(m.PubKey); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(pub); err != nil {
+		return
+	}
+	if id != signer {
+		err = fmt.Errorf("%v: signed by %v rather than %v", ErrBadBundleManifest, id.Pretty(), signer.Pretty())
+		return
+	}
+	var ok bool
+	if ok, err = pub.Verify(m.signed(), m.Sig); err != nil {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("%v: bad signature", ErrBadBundleManifest)
+		return
+	}
+	if m.DNAHash != expected.String() {
+		err = ErrDNAHashMismatch
+		return
+	}
+	if len(m.Files) != len(b.Files) {
+		err = fmt.Errorf("%v: %d files listed but %d bundled", ErrBadBundleManifest, len(m.Files), len(b.Files))
+		return
+	}
+	for file, data := range b.Files {
+		var h Hash
+		if err = h.Sum(hashSpec, data); err != nil {
+			return
+		}
+		if m.Files[file] != h.String() {
+			err = fmt.Errorf("%v: %s", ErrBadBundleManifest, file)
+			return
+		}
+	}
+	return
+}
+
+// Bundle collects the files that make up the holochain's DNA, as copied by Clone
+func (h *Holochain) Bundle() (b *DNABundle, err error) {
+	bundle := DNABundle{Format: h.encodingFormat, Files: make(map[string][]byte)}
+	add := func(file string) (err error) {
+		bundle.Files[file], err = readFile(h.path, file)
+		return
+	}
+	if err = add(DNAFileName + "." + h.encodingFormat); err != nil {
+		return
+	}
+	if h.PropertiesSchema != "" {
+		if err = add(h.PropertiesSchema); err != nil {
+			return
+		}
+	}
+	if h.PreferencesSchema != "" {
+		if err = add(h.PreferencesSchema); err != nil {
+			return
+		}
+	}
+	for _, z := range h.Zomes {
+		if err = add(z.Code); err != nil {
+			return
+		}
+		for _, e := range z.Entries {
+			if e.Schema != "" {
+				if err = add(e.Schema); err != nil {
+					return
+				}
+			}
+		}
+	}
+	for _, l := range h.Libraries {
+		if err = add(l.Code); err != nil {
+			return
+		}
+	}
+	for _, dir := range []string{"ui", "test"} {
+		if !dirExists(filepath.Join(h.path, dir)) {
+			continue
+		}
+		err = filepath.Walk(filepath.Join(h.path, dir), func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(h.path, p)
+			if err != nil {
+				return err
+			}
+			return add(filepath.ToSlash(rel))
+		})
+		if err != nil {
+			return
+		}
+	}
+	b = &bundle
+	return
+}
+
+// SignedBundle collects the files that make up the holochain's DNA with a manifest of them
+// signed by the chain's agent.  The DNA is built for its hash if the chain hasn't started.
+func (h *Holochain) SignedBundle() (b *DNABundle, err error) {
+	dnaHash := h.dnaHash
+	if dnaHash.String() == "" {
+		if _, dnaHash, err = h.BuildDNA(); err != nil {
+			return
+		}
+	}
+	if b, err = h.Bundle(); err != nil {
+		return
+	}
+	err = b.Sign(dnaHash, h.hashSpec, h.agent.PrivKey())
+	return
+}
+
+// write writes the bundle's files into a directory
+func (b *DNABundle) write(path string) (err error) {
+	for file, data := range b.Files {
+		p := filepath.Join(path, filepath.FromSlash(file))
+		if !strings.HasPrefix(p, filepath.Clean(path)+string(filepath.Separator)) {
+			err = fmt.Errorf("bundle file outside of holochain directory: %s", file)
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			return
+		}
+		if err = ioutil.WriteFile(p, data, 0644); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ParsePeerAddr splits an address of the form <multiaddr>/ipfs/<peer-id> into its parts
+func ParsePeerAddr(s string) (id peer.ID, addr ma.Multiaddr, err error) {
+	i := strings.LastIndex(s, "/ipfs/")
+	if i < 0 {
+		err = errors.New("peer address must end with /ipfs/<peer-id>")
+		return
+	}
+	id, err = peer.IDB58Decode(s[i+len("/ipfs/"):])
+	if err != nil {
+		return
+	}
+	addr, err = ma.NewMultiaddr(s[:i])
+	return
+}
+
+// FetchDNA requests the DNA bundle from the node at the given address, using a temporary
+// node with the service's agent identity.  The bundle isn't checked, see installBundle.
+func (s *Service) FetchDNA(peerAddr string) (b *DNABundle, err error) {
+	id, addr, err := ParsePeerAddr(peerAddr)
+	if err != nil {
+		return
+	}
+	transport, err := TransportForAddr(addr)
+	if err != nil {
+		return
+	}
+	priv := s.DefaultAgent.PrivKey()
+	me, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return
+	}
+	listenAddr, err := TransportListenAddr(transport, 0)
+	if err != nil {
+		return
+	}
+	node, err := NewNodeWithTransport(transport, listenAddr, me, priv)
+	if err != nil {
+		return
+	}
+	defer node.Close()
+	node.Transport.AddPeerAddr(id, addr)
+
+	r, err := node.Send(SourceProtocol, id, node.NewMessage(SRC_DNA, ""))
+	if err != nil {
+		return
+	}
+	if r.Type == ERROR_RESPONSE {
+		err = fmt.Errorf("response error: %v", r.Body)
+		return
+	}
+	bundle, ok := r.Body.(DNABundle)
+	if !ok {
+		err = fmt.Errorf("expected DNA bundle, got %T", r.Body)
+		return
+	}
+	b = &bundle
+	return
+}
+
+// JoinFromPeer fetches the DNA of a holochain from a running node, checks that it builds to
+// the expected DNA hash and that the node signed its files, and clones it to path ready for
+// genesis
+func (s *Service) JoinFromPeer(peerAddr string, expected Hash, path string) (h *Holochain, err error) {
+	var id peer.ID
+	if id, _, err = ParsePeerAddr(peerAddr); err != nil {
+		return
+	}
+	var b *DNABundle
+	s.progress(ProgressJoin, "fetching DNA", 0, 2)
+	b, err = s.FetchDNA(peerAddr)
+	if err != nil {
+		return
+	}
+	s.progress(ProgressJoin, "installing DNA", 1, 2)
+	h, err = s.installBundle(b, id, expected, path)
+	if err == nil {
+		s.progress(ProgressJoin, "done", 2, 2)
+	}
+	return
+}
+
+// installBundle checks that a DNA bundle builds to the expected DNA hash, and that its files
+// are those listed in a manifest signed by the expected agent, and clones it to path ready
+// for genesis
+func (s *Service) installBundle(b *DNABundle, signer peer.ID, expected Hash, path string) (h *Holochain, err error) {
+	var tmp string
+	tmp, err = ioutil.TempDir("", "hc-bundle")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	if err = b.write(tmp); err != nil {
+		return
+	}
+
+	// confirm that the DNA, including the hashes of all its code and schemas, is what we expect
+	var format string
+	format, err = findDNA(tmp)
+	if err != nil {
+		return
+	}
+	var f *os.File
+	f, err = os.Open(filepath.Join(tmp, DNAFileName+"."+format))
+	if err != nil {
+		return
+	}
+	var dna *Holochain
+	dna, err = DecodeDNA(f, format)
+	f.Close()
+	if err != nil {
+		return
+	}
+	dna.path = tmp
+	if err = dna.PrepareHashType(); err != nil {
+		return
+	}
+	if _, err = dna.VerifyDNA(expected); err != nil {
+		return
+	}
+	// the files the DNA hash doesn't cover, like the ui, are only trusted if the agent we
+	// expected to make the bundle vouches for them
+	if err = b.Verify(signer, expected, dna.hashSpec); err != nil {
+		return
+	}
+
+	h, err = s.Clone(tmp, path, false)
+	return
+}
+
+// PeerAddr returns the address at which other nodes can reach this holochain's node, in the
+// form accepted by ParsePeerAddr
+func (h *Holochain) PeerAddr() string {
+	if h.node == nil {
+		return ""
+	}
+	return h.node.NetAddr.String() + "/ipfs/" + peer.IDB58Encode(h.node.HashAddr)
+}
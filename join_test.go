@@ -0,0 +1,110 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+)
+
+func TestParsePeerAddr(t *testing.T) {
+	Convey("it should split a peer address", t, func() {
+		p, _ := makePeer("peer")
+		id, addr, err := ParsePeerAddr("/ip4/127.0.0.1/tcp/6283/ipfs/" + p.Pretty())
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, p)
+		So(addr.String(), ShouldEqual, "/ip4/127.0.0.1/tcp/6283")
+	})
+	Convey("it should require a peer id", t, func() {
+		_, _, err := ParsePeerAddr("/ip4/127.0.0.1/tcp/6283")
+		So(err.Error(), ShouldEqual, "peer address must end with /ipfs/<peer-id>")
+	})
+}
+
+func TestBundle(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should bundle the DNA and the files it refers to", t, func() {
+		b, err := h.Bundle()
+		So(err, ShouldBeNil)
+		So(b.Format, ShouldEqual, "toml")
+		for _, f := range []string{"dna.toml", "schema_properties.json", "schema_profile.json", "zome_myZome.zy", "zome_jsZome.js", "ui/index.html"} {
+			_, ok := b.Files[f]
+			So(ok, ShouldBeTrue)
+		}
+	})
+
+	Convey("it should sign a manifest of the bundle's files", t, func() {
+		_, dnaHash, err := h.BuildDNA()
+		So(err, ShouldBeNil)
+		b, err := h.SignedBundle()
+		So(err, ShouldBeNil)
+		So(b.Verify(h.id, dnaHash, h.hashSpec), ShouldBeNil)
+
+		other, _ := makePeer("other")
+		So(b.Verify(other, dnaHash, h.hashSpec), ShouldNotBeNil)
+		wrong, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		So(b.Verify(h.id, wrong, h.hashSpec), ShouldEqual, ErrDNAHashMismatch)
+
+		b.Files["ui/index.html"] = []byte("<script>evil()</script>")
+		So(b.Verify(h.id, dnaHash, h.hashSpec).Error(), ShouldEqual, ErrBadBundleManifest.Error()+": ui/index.html")
+		delete(b.Files, "ui/index.html")
+		So(b.Verify(h.id, dnaHash, h.hashSpec), ShouldNotBeNil)
+		b.Manifest = nil
+		So(b.Verify(h.id, dnaHash, h.hashSpec), ShouldNotBeNil)
+	})
+
+	Convey("it should not write files outside the directory", t, func() {
+		b := DNABundle{Files: map[string][]byte{"../evil": []byte("x")}}
+		err := b.write(d + "/bundle")
+		So(err.Error(), ShouldEqual, "bundle file outside of holochain directory: ../evil")
+	})
+}
+
+func TestJoinFromPeer(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	_, dnaHash, err := h.BuildDNA()
+	if err != nil {
+		panic(err)
+	}
+	if _, err = h.GenChain(); err != nil {
+		panic(err)
+	}
+	if err = h.Activate(); err != nil {
+		panic(err)
+	}
+	defer h.node.Close()
+
+	d2, s2 := setupTestService()
+	defer cleanupTestDir(d2)
+	// the joining node needs a different agent from the node it joins from
+	agent, err := NewAgent(IPFS, "Jane <j@ne.com>")
+	if err != nil {
+		panic(err)
+	}
+	s2.DefaultAgent = agent
+	os.Remove(s2.Path + "/" + PrivKeyFileName)
+	os.Remove(s2.Path + "/" + AgentFileName)
+	if err = SaveAgent(s2.Path, agent); err != nil {
+		panic(err)
+	}
+
+	Convey("it should fail if the DNA hash doesn't match", t, func() {
+		wrong, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err := s2.JoinFromPeer(h.PeerAddr(), wrong, s2.Path+"/joined")
+		So(err, ShouldEqual, ErrDNAHashMismatch)
+		So(dirExists(s2.Path+"/joined"), ShouldBeFalse)
+	})
+
+	Convey("it should fetch and clone the DNA from a running node", t, func() {
+		h2, err := s2.JoinFromPeer(h.PeerAddr(), dnaHash, s2.Path+"/joined")
+		So(err, ShouldBeNil)
+		So(h2.Id, ShouldEqual, h.Id)
+		So(fileExists(s2.Path+"/joined/zome_myZome.zy"), ShouldBeTrue)
+		So(fileExists(s2.Path+"/joined/ui/index.html"), ShouldBeTrue)
+		_, err = h2.GenChain()
+		So(err, ShouldBeNil)
+		So(h2.DNAHash().String(), ShouldEqual, dnaHash.String())
+	})
+}
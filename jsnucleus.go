@@ -3,6 +3,8 @@
 //----------------------------------------------------------------------------------------
 // JSNucleus implements a javascript use of the Nucleus interface
 
+// +build !nojs
+
 package holochain
 
 import (
@@ -11,19 +13,51 @@ import (
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/ast"
+	"github.com/robertkrimen/otto/parser"
 	_ "math"
 	"strings"
 	"time"
 )
 
-const (
-	JSNucleusType = "js"
-)
+func init() {
+	RegisterNucleusType(JSNucleusType, "js", NewJSNucleus)
+	RegisterNucleusParser(JSNucleusType, parseJSExposed)
+	RegisterNucleusPrefixLines(JSNucleusType, strings.Count(JSLibrary, "\n"))
+}
+
+// parseJSExposed parses javascript code, returning the names its top level expose calls give
+func parseJSExposed(code string) (exposed []string, err error) {
+	var program *ast.Program
+	if program, err = parser.ParseFile(nil, "", code, 0); err != nil {
+		return
+	}
+	for _, s := range program.Body {
+		es, ok := s.(*ast.ExpressionStatement)
+		if !ok {
+			continue
+		}
+		call, ok := es.Expression.(*ast.CallExpression)
+		if !ok || len(call.ArgumentList) == 0 {
+			continue
+		}
+		if callee, ok := call.Callee.(*ast.Identifier); !ok || callee.Name != "expose" {
+			continue
+		}
+		if name, ok := call.ArgumentList[0].(*ast.StringLiteral); ok {
+			exposed = append(exposed, name.Value)
+		}
+	}
+	return
+}
 
 type JSNucleus struct {
 	vm         *otto.Otto
 	interfaces []Interface
 	lastResult *otto.Value
+	validating bool
+	zome       string
+	hostFns    []string // names of the host functions made available to the zome code
 }
 
 // Name returns the string value under which this nucleus is registered
@@ -111,6 +145,8 @@ func (z *JSNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationPro
 		return
 	}
 	code := fmt.Sprintf(`validate("%s",%s,JSON.parse("%s"))`, d.Name, e, jsSanitizeString(string(b)))
+	z.validating = true
+	defer func() { z.validating = false }()
 	v, err := z.vm.Run(code)
 	if err != nil {
 		err = fmt.Errorf("Error executing validate: %v", err)
@@ -124,7 +160,7 @@ func (z *JSNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationPro
 				return
 			}
 			if !b {
-				err = fmt.Errorf("Invalid entry: %v", entry.Content())
+				err = &ErrValidationFailed{Reason: fmt.Sprintf("%v", entry.Content())}
 			}
 		}
 	} else {
@@ -212,15 +248,62 @@ func (z *JSNucleus) Call(iface string, params interface{}) (result interface{},
 	return
 }
 
+// setZome tells the nucleus which zome its code belongs to
+func (z *JSNucleus) setZome(zome string) {
+	z.zome = zome
+}
+
+// cover wraps each of the functions so that hit is called whenever its body runs, whether
+// from a zome call or from other code in the zome
+func (z *JSNucleus) cover(fns []string, hit func(fn string)) (err error) {
+	err = z.vm.Set("__cover", func(call otto.FunctionCall) otto.Value {
+		fn, _ := call.Argument(0).ToString()
+		hit(fn)
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return
+	}
+	for _, fn := range fns {
+		_, err = z.vm.Run(fmt.Sprintf(`%s = (function(f) { return function() { __cover("%s"); return f.apply(this, arguments); }; })(%s);`, fn, fn, fn))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// setHostFn makes a host function available to the zome code, tracing its calls
+func (z *JSNucleus) setHostFn(h *Holochain, name string, fn func(otto.FunctionCall) otto.Value) error {
+	z.hostFns = append(z.hostFns, name)
+	return z.vm.Set(name, func(call otto.FunctionCall) otto.Value {
+		if h.tracer == nil {
+			return fn(call)
+		}
+		start := time.Now()
+		result := fn(call)
+		args := make([]string, len(call.ArgumentList))
+		for i, a := range call.ArgumentList {
+			args[i] = a.String()
+		}
+		var err error
+		if result.IsObject() && result.Class() == "Error" {
+			err = errors.New(result.String())
+		}
+		h.traceHostCall(z.zome, name, args, start, err)
+		return result
+	})
+}
+
 // NewJSNucleus builds a javascript execution environment with user specified code
 func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	var z JSNucleus
 	z.vm = otto.New()
 
-	err = z.vm.Set("property", func(call otto.FunctionCall) otto.Value {
+	err = z.setHostFn(h, "property", func(call otto.FunctionCall) otto.Value {
 		prop, _ := call.Argument(0).ToString()
 
-		p, err := h.GetProperty(prop)
+		p, err := h.zomeProperty(z.zome, prop)
 		if err != nil {
 			return otto.UndefinedValue()
 		}
@@ -231,13 +314,19 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		return nil, err
 	}
 
-	err = z.vm.Set("debug", func(call otto.FunctionCall) otto.Value {
+	err = z.setHostFn(h, "debug", func(call otto.FunctionCall) otto.Value {
 		msg, _ := call.Argument(0).ToString()
-		h.config.Loggers.App.p(msg)
+		var level string
+		if l := call.Argument(1); l.IsDefined() {
+			level, _ = l.ToString()
+		}
+		if err := h.zomeLog(z.zome, level, msg); err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
 		return otto.UndefinedValue()
 	})
 
-	err = z.vm.Set("expose", func(call otto.FunctionCall) otto.Value {
+	err = z.setHostFn(h, "expose", func(call otto.FunctionCall) otto.Value {
 		fnName, _ := call.Argument(0).ToString()
 		schema, _ := call.Argument(1).ToInteger()
 		i := Interface{Name: fnName, Schema: InterfaceSchemaType(schema)}
@@ -251,7 +340,7 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		return nil, err
 	}
 
-	err = z.vm.Set("commit", func(call otto.FunctionCall) otto.Value {
+	err = z.setHostFn(h, "commit", func(call otto.FunctionCall) otto.Value {
 		entryType, _ := call.Argument(0).ToString()
 		var entry string
 		v := call.Argument(1)
@@ -265,37 +354,19 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return z.vm.MakeCustomError("HolochainError", "commit expected string as second argument")
 		}
 
-		e := GobEntry{C: entry}
-		var l int
-		var hash Hash
-		var header *Header
-		l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, time.Now(), entryType, &e, h.agent.PrivKey())
-		if err != nil {
-			return z.vm.MakeCustomError("HolochainError", err.Error())
-		}
-
-		p := ValidationProps{
-			Sources: []string{peer.IDB58Encode(h.id)},
-			Hash:    hash.String(),
-		}
-
-		err = h.ValidateEntry(entryType, &e, &p)
-
-		if err == nil {
-			err = h.chain.addEntry(l, hash, header, &e)
-		}
+		hash, err := h.Commit(entryType, entry)
 		if err != nil {
 			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
 
-		result, _ := z.vm.ToValue(header.EntryLink.String())
+		result, _ := z.vm.ToValue(hash.String())
 		return result
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	err = z.vm.Set("put", func(call otto.FunctionCall) otto.Value {
+	err = z.setHostFn(h, "put", func(call otto.FunctionCall) otto.Value {
 		v := call.Argument(0)
 		var hashstr string
 
@@ -321,7 +392,7 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		return nil, err
 	}
 
-	err = z.vm.Set("get", func(call otto.FunctionCall) (result otto.Value) {
+	err = z.setHostFn(h, "get", func(call otto.FunctionCall) (result otto.Value) {
 		v := call.Argument(0)
 		var hashstr string
 
@@ -331,11 +402,24 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return z.vm.MakeCustomError("HolochainError", "get expected string as argument")
 		}
 
+		var opts GetOptions
+		if o := call.Argument(1); o.IsObject() {
+			var x interface{}
+			if x, err = o.Export(); err == nil {
+				var j []byte
+				if j, err = json.Marshal(x); err == nil {
+					opts, err = ParseGetOptions(string(j))
+				}
+			}
+		}
+
 		var key Hash
-		key, err = NewHash(hashstr)
+		if err == nil {
+			key, err = NewHash(hashstr)
+		}
 		if err == nil {
 			var response interface{}
-			response, err = h.dht.SendGet(key)
+			response, err = h.dht.SendGetWithOptions(key, opts)
 			if err == nil {
 				switch t := response.(type) {
 				case *GobEntry:
@@ -359,7 +443,7 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		return nil, err
 	}
 
-	err = z.vm.Set("putmeta", func(call otto.FunctionCall) otto.Value {
+	err = z.setHostFn(h, "putmeta", func(call otto.FunctionCall) otto.Value {
 		hashstr, _ := call.Argument(0).ToString()
 		metahashstr, _ := call.Argument(1).ToString()
 		typestr, _ := call.Argument(2).ToString()
@@ -384,7 +468,7 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		return nil, err
 	}
 
-	err = z.vm.Set("getmeta", func(call otto.FunctionCall) (result otto.Value) {
+	err = z.setHostFn(h, "getmeta", func(call otto.FunctionCall) (result otto.Value) {
 		hashstr, _ := call.Argument(0).ToString()
 		typestr, _ := call.Argument(1).ToString()
 
@@ -407,6 +491,510 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	if err != nil {
 		return nil, err
 	}
+	err = z.setHostFn(h, "countLinks", func(call otto.FunctionCall) (result otto.Value) {
+		hashstr, _ := call.Argument(0).ToString()
+		typestr, _ := call.Argument(1).ToString()
+		key, err := NewHash(hashstr)
+		if err == nil && z.validating {
+			err = ErrAggregateInValidation
+		}
+		if err == nil {
+			var n int
+			if n, err = h.CountLinks(key, typestr); err == nil {
+				result, err = z.vm.ToValue(n)
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// queries are given as objects, or as their json
+	query := func(v otto.Value) (q Query, err error) {
+		if v.IsObject() {
+			v, _ = z.vm.Call("JSON.stringify", nil, v)
+		}
+		if z.validating {
+			err = ErrAggregateInValidation
+			return
+		}
+		j, _ := v.ToString()
+		q, err = ParseQuery(h, j)
+		return
+	}
+
+	err = z.setHostFn(h, "queryCount", func(call otto.FunctionCall) (result otto.Value) {
+		q, err := query(call.Argument(0))
+		if err == nil {
+			var n int
+			if n, err = h.QueryCount(q); err == nil {
+				result, err = z.vm.ToValue(n)
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "querySum", func(call otto.FunctionCall) (result otto.Value) {
+		q, err := query(call.Argument(0))
+		if err == nil {
+			field, _ := call.Argument(1).ToString()
+			var a Aggregate
+			if a, err = h.QuerySum(q, field); err == nil {
+				result, err = z.vm.ToValue(a.Sum)
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "bridgeGet", func(call otto.FunctionCall) (result otto.Value) {
+		chain, _ := call.Argument(0).ToString()
+		hashstr, _ := call.Argument(1).ToString()
+		key, err := NewHash(hashstr)
+		if err == nil && z.validating {
+			err = ErrBridgeInValidation
+		}
+		if err == nil {
+			var entry *GobEntry
+			if entry, _, err = h.BridgeGet(chain, key); err == nil {
+				result, err = z.vm.ToValue(entry)
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "bridgeQuery", func(call otto.FunctionCall) (result otto.Value) {
+		chain, _ := call.Argument(0).ToString()
+		hashstr, _ := call.Argument(1).ToString()
+		typestr, _ := call.Argument(2).ToString()
+		key, err := NewHash(hashstr)
+		if err == nil && z.validating {
+			err = ErrBridgeInValidation
+		}
+		if err == nil {
+			var entries []MetaEntry
+			if entries, err = h.BridgeQuery(chain, MetaQuery{H: key, T: typestr}); err == nil {
+				result, err = z.vm.ToValue(MetaQueryResp{Entries: entries})
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "deterministicRandom", func(call otto.FunctionCall) (result otto.Value) {
+		hashstr, _ := call.Argument(0).ToString()
+		n, _ := call.Argument(1).ToInteger()
+		seed, err := NewHash(hashstr)
+		if err == nil {
+			var r int64
+			if r, err = DeterministicRandom(seed, n); err == nil {
+				result, err = z.vm.ToValue(r)
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	crdtUpdate := func(kind string) func(call otto.FunctionCall) otto.Value {
+		return func(call otto.FunctionCall) otto.Value {
+			entryType, _ := call.Argument(0).ToString()
+			object, _ := call.Argument(1).ToString()
+			value, err := call.Argument(2).Export()
+			var cause string
+			if arg := call.Argument(3); !arg.IsUndefined() {
+				cause, _ = arg.ToString()
+			}
+			if err == nil {
+				var hash Hash
+				hash, err = h.CRDTUpdate(kind, entryType, object, value, cause)
+				if err == nil {
+					result, _ := z.vm.ToValue(hash.String())
+					return result
+				}
+			}
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+	}
+	for fn, kind := range map[string]string{"crdtAdd": CRDTGrowOnlySet, "crdtSet": CRDTLWWRegister, "crdtIncrement": CRDTCounter} {
+		if err = z.setHostFn(h, fn, crdtUpdate(kind)); err != nil {
+			return nil, err
+		}
+	}
+
+	err = z.setHostFn(h, "crdtGet", func(call otto.FunctionCall) (result otto.Value) {
+		entryType, _ := call.Argument(0).ToString()
+		object, _ := call.Argument(1).ToString()
+		v, err := h.CRDTValue(entryType, object)
+		if err == nil {
+			result, err = z.vm.ToValue(v)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "fetch", func(call otto.FunctionCall) (result otto.Value) {
+		u, _ := call.Argument(0).ToString()
+		body, err := h.httpFetch(u, z.validating)
+		if err == nil {
+			result, err = z.vm.ToValue(body)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fn := range []string{"pin", "unpin"} {
+		pin := fn == "pin"
+		err = z.setHostFn(h, fn, func(call otto.FunctionCall) otto.Value {
+			hashstr, _ := call.Argument(0).ToString()
+			if err := h.pin(hashstr, pin, z.validating); err != nil {
+				return z.vm.MakeCustomError("HolochainError", err.Error())
+			}
+			return otto.UndefinedValue()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = z.setHostFn(h, "kvSet", func(call otto.FunctionCall) otto.Value {
+		key, _ := call.Argument(0).ToString()
+		var value string
+		v := call.Argument(1)
+		if v.IsString() {
+			value, _ = v.ToString()
+		} else if v.IsObject() {
+			v, _ = z.vm.Call("JSON.stringify", nil, v)
+			value, _ = v.ToString()
+		} else {
+			return z.vm.MakeCustomError("HolochainError", "kvSet expected string as second argument")
+		}
+		err := ErrKVInValidation
+		if !z.validating {
+			err = h.dht.KVSet(z.zome, key, value)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "kvGet", func(call otto.FunctionCall) (result otto.Value) {
+		key, _ := call.Argument(0).ToString()
+		err := ErrKVInValidation
+		var value string
+		if !z.validating {
+			value, err = h.dht.KVGet(z.zome, key)
+		}
+		if err == ErrKVNotFound {
+			return otto.NullValue()
+		}
+		if err == nil {
+			result, err = z.vm.ToValue(value)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "kvDelete", func(call otto.FunctionCall) otto.Value {
+		key, _ := call.Argument(0).ToString()
+		err := ErrKVInValidation
+		if !z.validating {
+			err = h.dht.KVDelete(z.zome, key)
+		}
+		if err != nil && err != ErrKVNotFound {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "kvList", func(call otto.FunctionCall) (result otto.Value) {
+		var prefix string
+		if p := call.Argument(0); p.IsDefined() {
+			prefix, _ = p.ToString()
+		}
+		err := ErrKVInValidation
+		var keys []string
+		if !z.validating {
+			keys, err = h.dht.KVList(z.zome, prefix)
+		}
+		if err == nil {
+			if keys == nil {
+				keys = []string{}
+			}
+			result, err = z.vm.ToValue(keys)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "getPref", func(call otto.FunctionCall) (result otto.Value) {
+		key, _ := call.Argument(0).ToString()
+		err := ErrPrefInValidation
+		var value string
+		if !z.validating {
+			value, err = h.GetPref(key)
+		}
+		if err == nil && value == "" {
+			return otto.NullValue()
+		}
+		if err == nil {
+			result, err = z.vm.Call("JSON.parse", nil, value)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "setPref", func(call otto.FunctionCall) (result otto.Value) {
+		key, _ := call.Argument(0).ToString()
+		var value string
+		if v := call.Argument(1); v.IsDefined() && !v.IsNull() {
+			v, _ = z.vm.Call("JSON.stringify", nil, v)
+			value, _ = v.ToString()
+		}
+		err := ErrPrefInValidation
+		var hash Hash
+		if !z.validating {
+			hash, err = h.SetPref(key, value)
+		}
+		if err == nil {
+			result, err = z.vm.ToValue(hash.String())
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "findAgent", func(call otto.FunctionCall) (result otto.Value) {
+		query, _ := call.Argument(0).ToString()
+		entries, err := h.findAgent(query, z.validating)
+		var j []byte
+		if err == nil {
+			j, err = json.Marshal(entries)
+		}
+		if err == nil {
+			result, err = z.vm.Call("JSON.parse", nil, string(j))
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "caller", func(call otto.FunctionCall) (result otto.Value) {
+		err := ErrCallerInValidation
+		var j []byte
+		if !z.validating {
+			j, err = json.Marshal(h.Caller())
+		}
+		if err == nil {
+			result, err = z.vm.Call("JSON.parse", nil, string(j))
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "countersign", func(call otto.FunctionCall) otto.Value {
+		if z.validating {
+			return z.vm.MakeCustomError("HolochainError", ErrCountersignInValidation.Error())
+		}
+		entryType, _ := call.Argument(0).ToString()
+		var entry string
+		v := call.Argument(1)
+		if v.IsString() {
+			entry, _ = v.ToString()
+		} else if v.IsObject() {
+			v, _ = z.vm.Call("JSON.stringify", nil, v)
+			entry, _ = v.ToString()
+		} else {
+			return z.vm.MakeCustomError("HolochainError", "countersign expected string as second argument")
+		}
+		var agents []peer.ID
+		a, _ := call.Argument(2).Export()
+		ids, ok := a.([]interface{})
+		if !ok {
+			return z.vm.MakeCustomError("HolochainError", "countersign expected array of agents as third argument")
+		}
+		for _, x := range ids {
+			id, err := peer.IDB58Decode(fmt.Sprintf("%v", x))
+			if err != nil {
+				return z.vm.MakeCustomError("HolochainError", err.Error())
+			}
+			agents = append(agents, id)
+		}
+		hash, _, err := h.Countersign(entryType, entry, agents)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		result, _ := z.vm.ToValue(hash.String())
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// payloads given as objects are signed as their json
+	payload := func(v otto.Value) (s string, err error) {
+		if v.IsObject() {
+			if v, err = z.vm.Call("JSON.stringify", nil, v); err != nil {
+				return
+			}
+		}
+		s, err = v.ToString()
+		return
+	}
+
+	err = z.setHostFn(h, "sign", func(call otto.FunctionCall) (result otto.Value) {
+		err := ErrSignInValidation
+		var j []byte
+		if !z.validating {
+			var data string
+			if data, err = payload(call.Argument(0)); err == nil {
+				var s MessageSignature
+				if s, err = h.Sign([]byte(data)); err == nil {
+					j, err = json.Marshal(s)
+				}
+			}
+		}
+		if err == nil {
+			result, err = z.vm.Call("JSON.parse", nil, string(j))
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "verify", func(call otto.FunctionCall) (result otto.Value) {
+		data, err := payload(call.Argument(0))
+		var sig string
+		if err == nil {
+			sig, err = payload(call.Argument(1))
+		}
+		var s MessageSignature
+		if err == nil {
+			s, err = ParseMessageSignature(sig)
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		result, _ = z.vm.ToValue(s.Verify([]byte(data)) == nil)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "hostCapabilities", func(call otto.FunctionCall) (result otto.Value) {
+		j, err := json.Marshal(h.hostCapabilities(z.zome, JSNucleusType, z.hostFns))
+		if err == nil {
+			result, err = z.vm.Call("JSON.parse", nil, string(j))
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.setHostFn(h, "hc", func(call otto.FunctionCall) (result otto.Value) {
+		fn, _ := call.Argument(0).ToString()
+		arg, err := call.Argument(1).Export()
+		if err == nil {
+			var r interface{}
+			// called directly as the zome call already holds a call slot and passed the scope check
+			r, err = h.callSystem(fn, arg)
+			if err == nil {
+				result, err = z.vm.ToValue(r)
+			}
+		}
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	l := JSLibrary
 	if h != nil {
 		l += fmt.Sprintf(`var App = {DNAHash:"%s",Agent:{Hash:"%s",String:"%s"},Key:{Hash:"%s"}};`, h.dnaHash, h.agentHash, h.Agent().Name(), peer.IDB58Encode(h.id))
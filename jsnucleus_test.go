@@ -1,14 +1,46 @@
+// +build !nojs
+
 package holochain
 
 import (
+	"encoding/json"
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/robertkrimen/otto"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 )
 
+func init() {
+	builtNucleusTypes = append(builtNucleusTypes, JSNucleusType)
+}
+
+func TestJSNucleusType(t *testing.T) {
+	Convey("it should return the file extension for js", t, func() {
+		ext, err := NucleusFileExtension(JSNucleusType)
+		So(err, ShouldBeNil)
+		So(ext, ShouldEqual, "js")
+	})
+	Convey("it should panic on duplicate registration", t, func() {
+		So(func() { RegisterNucleusType(JSNucleusType, "js", NewJSNucleus) }, ShouldPanic)
+	})
+}
+
+func TestParseJSExposed(t *testing.T) {
+	Convey("it should read the functions code exposes without running it", t, func() {
+		exposed, err := parseJSExposed(`expose("getDNA", HC.STRING); function f() { expose("nested", HC.STRING) }; throw "ran";`)
+		So(err, ShouldBeNil)
+		So(exposed, ShouldResemble, []string{"getDNA"})
+		_, err = parseJSExposed("function (")
+		So(err, ShouldNotBeNil)
+	})
+}
+
 func TestNewJSNucleus(t *testing.T) {
 	Convey("new should create a nucleus", t, func() {
 		v, err := NewJSNucleus(nil, `1 + 1`)
@@ -276,3 +308,338 @@ func TestJSDHT(t *testing.T) {
 	})
 
 }
+
+func TestJSFetch(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "42")
+	}))
+	defer ts.Close()
+	h.HTTPFetch = &HTTPFetchPolicy{Hosts: []string{"127.0.0.1"}}
+
+	Convey("it should fetch from zome functions but not during validation", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(fmt.Sprintf(`fetch("%s")`, ts.URL))
+		So(err, ShouldBeNil)
+		s, _ := z.lastResult.Export()
+		So(s, ShouldEqual, "42")
+		z.validating = true
+		_, err = z.Run(fmt.Sprintf(`fetch("%s")`, ts.URL))
+		So(err.Error(), ShouldContainSubstring, ErrFetchInValidation.Error())
+	})
+}
+
+func TestJSTrace(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should report host function calls to the tracer", t, func() {
+		var calls []HostCall
+		h.SetTracer(func(c HostCall) { calls = append(calls, c) })
+		defer h.SetTracer(nil)
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		z.setZome("myZome")
+		_, err = z.Run(`property("description")`)
+		So(err, ShouldBeNil)
+		So(len(calls), ShouldEqual, 1)
+		So(calls[0].Zome, ShouldEqual, "myZome")
+		So(calls[0].Function, ShouldEqual, "property")
+		So(calls[0].Args, ShouldResemble, []string{"description"})
+		So(calls[0].Err, ShouldBeNil)
+	})
+
+	Convey("debug should reject bad levels", t, func() {
+		v, _ := NewJSNucleus(h, "")
+		_, err := v.(*JSNucleus).Run(`debug("fish","loud")`)
+		So(err.Error(), ShouldContainSubstring, "unknown log level: loud")
+	})
+}
+
+func TestJSPin(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should pin and unpin entries", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(`pin("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")`)
+		So(err, ShouldBeNil)
+		pins, _ := h.dht.Pins()
+		So(len(pins), ShouldEqual, 1)
+		_, err = z.Run(`unpin("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")`)
+		So(err, ShouldBeNil)
+		_, err = z.Run(`unpin("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")`)
+		So(err.Error(), ShouldContainSubstring, ErrNotPinned.Error())
+	})
+}
+
+func TestJSKV(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should keep node-local values for the zome", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		z.setZome("jsZome")
+		_, err = z.Run(`kvGet("draft")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.IsNull(), ShouldBeTrue)
+		_, err = z.Run(`kvSet("draft",{text:"hi"})`)
+		So(err, ShouldBeNil)
+		_, err = z.Run(`kvGet("draft")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, `{"text":"hi"}`)
+		_, err = z.Run(`kvList().join(",")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "draft")
+		value, _ := h.dht.KVGet("jsZome", "draft")
+		So(value, ShouldEqual, `{"text":"hi"}`)
+		_, err = z.Run(`kvDelete("draft"); kvList().length`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "0")
+
+		z.validating = true
+		_, err = z.Run(`kvGet("draft")`)
+		So(err.Error(), ShouldContainSubstring, ErrKVInValidation.Error())
+	})
+}
+
+func TestJSPrefs(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should get and set the agent's preferences", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(`getPref("theme")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.IsNull(), ShouldBeTrue)
+		_, err = z.Run(`setPref("theme",{name:"dark",contrast:2})`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldStartWith, "Qm")
+		_, err = z.Run(`getPref("theme").contrast`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "2")
+		value, _ := h.GetPref("theme")
+		So(value, ShouldEqual, `{"name":"dark","contrast":2}`)
+		_, err = z.Run(`setPref("theme",null); getPref("theme")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.IsNull(), ShouldBeTrue)
+
+		z.validating = true
+		_, err = z.Run(`getPref("theme")`)
+		So(err.Error(), ShouldContainSubstring, ErrPrefInValidation.Error())
+	})
+}
+
+func TestJSFindAgent(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should search the agent directory", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(`findAgent("").length`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "1")
+		_, err = z.Run(`findAgent("")[0].Source`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, DirectoryAgent)
+		_, err = z.Run(`findAgent("nomatch").length`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "0")
+
+		z.validating = true
+		_, err = z.Run(`findAgent("")`)
+		So(err.Error(), ShouldContainSubstring, ErrDirectoryInValidation.Error())
+	})
+}
+
+func TestJSCaller(t *testing.T) {
+	d, s, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should tell zome code who is calling", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(`caller().Kind`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, CallerSelf)
+
+		tk, _ := s.IssueNamedToken("test", "alice", "", []string{"*"}, nil)
+		v, err = NewJSNucleus(h.WithScope(&tk), "")
+		So(err, ShouldBeNil)
+		z = v.(*JSNucleus)
+		_, err = z.Run(`caller().Kind+":"+caller().ID`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "token:alice")
+
+		z.validating = true
+		_, err = z.Run(`caller()`)
+		So(err.Error(), ShouldContainSubstring, ErrCallerInValidation.Error())
+	})
+}
+
+func TestJSDeterministicRandom(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	v, err := NewJSNucleus(h, "")
+	if err != nil {
+		panic(err)
+	}
+	z := v.(*JSNucleus)
+
+	Convey("deterministicRandom should derive a number from a hash", t, func() {
+		_, err := z.Run(`deterministicRandom("` + h.dnaHash.String() + `", 6)`)
+		So(err, ShouldBeNil)
+		i, _ := z.lastResult.ToInteger()
+		r, _ := DeterministicRandom(h.dnaHash, 6)
+		So(i, ShouldEqual, r)
+
+		_, err = z.Run(`deterministicRandom("` + h.dnaHash.String() + `", 0)`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "HolochainError: deterministicRandom range must be positive, got: 0")
+	})
+}
+
+func TestJSAggregates(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	e := GobEntry{C: `{"firstName":"Zippy","age":30}`}
+	b, _ := e.Marshal()
+	var base, meta Hash
+	base.Sum(h.hashSpec, b)
+	meta.Sum(h.hashSpec, []byte("meta"))
+	if err := h.dht.put(nil, "profile", base, h.id, b, LIVE); err != nil {
+		panic(err)
+	}
+	if err := h.dht.putMeta(nil, base, meta, "follows", &GobEntry{C: "x"}); err != nil {
+		panic(err)
+	}
+
+	Convey("it should have aggregate functions", t, func() {
+		v, err := NewJSNucleus(h, fmt.Sprintf(`countLinks("%s","follows") + queryCount({Entry:"profile"}) + querySum('{"Entry":"profile"}',"age")`, base.String()))
+		So(err, ShouldBeNil)
+		n, _ := v.(*JSNucleus).lastResult.ToInteger()
+		So(n, ShouldEqual, 32)
+
+		z := v.(*JSNucleus)
+		z.validating = true
+		_, err = z.Run(`queryCount({Entry:"profile"})`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, "HolochainError: "+ErrAggregateInValidation.Error())
+	})
+}
+
+func TestJSSign(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("zome code should be able to sign and verify payloads", t, func() {
+		v, err := NewJSNucleus(h, `var s = sign({challenge:42}); s.Signer + ":" + verify({challenge:42}, s) + ":" + verify({challenge:43}, s) + ":" + verify("{\"challenge\":42}", JSON.stringify(s))`)
+		So(err, ShouldBeNil)
+		So(v.(*JSNucleus).lastResult.String(), ShouldEqual, h.NodeIDStr()+":true:false:true")
+	})
+
+	Convey("payloads signed in go should verify in zome code", t, func() {
+		s, err := h.Sign([]byte("login"))
+		So(err, ShouldBeNil)
+		j, _ := json.Marshal(s)
+		v, err := NewJSNucleus(h, `verify("login", `+string(j)+`)`)
+		So(err, ShouldBeNil)
+		So(v.(*JSNucleus).lastResult.String(), ShouldEqual, "true")
+	})
+}
+
+func TestJSHostCapabilities(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("zome code should be able to feature-detect host functions", t, func() {
+		v, err := NewJSNucleus(h, `var c = hostCapabilities(); c.API + ":" + c.Nucleus + ":" + (c.Functions.indexOf("countLinks") >= 0) + ":" + (c.Functions.indexOf("nosuchfn") >= 0)`)
+		So(err, ShouldBeNil)
+		So(v.(*JSNucleus).lastResult.String(), ShouldEqual, fmt.Sprintf("%d:js:true:false", NucleusAPIVersion))
+	})
+
+	Convey("zomes written for API version 1 should get the special properties", t, func() {
+		v, err := NewJSNucleus(h, `property("_agent_name")`)
+		So(err, ShouldBeNil)
+		So(v.(*JSNucleus).lastResult.IsUndefined(), ShouldBeTrue)
+
+		h.Zomes["jsZome"].API = 1
+		defer func() { h.Zomes["jsZome"].API = 0 }()
+		v, err = h.MakeNucleus("jsZome")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(`property("_agent_name") + ":" + hostCapabilities().API`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, string(h.Agent().Name())+":1")
+	})
+}
+
+func TestJSCRDT(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	h.Zomes["jsZome"].Entries["tags"] = EntryDef{Name: "tags", DataFormat: DataFormatJSON, CRDT: CRDTGrowOnlySet}
+	code, _ := readFile(h.path, h.Zomes["jsZome"].Code)
+	code = append(code, []byte("\nfunction validate(entry_type,entry,props) {return true}\n")...)
+	ioutil.WriteFile(h.path+"/"+h.Zomes["jsZome"].Code, code, os.ModePerm)
+
+	for len(h.dht.puts) > 0 {
+		<-h.dht.puts
+	}
+
+	Convey("crdts should be available to js", t, func() {
+		v, err := NewJSNucleus(h, `crdtAdd("tags","post2","fish");`)
+		So(err, ShouldBeNil)
+		h.dht.simHandlePutReqs()
+		z := v.(*JSNucleus)
+		_, err = z.Run(`crdtGet("tags","post2")`)
+		So(err, ShouldBeNil)
+		s, _ := z.lastResult.Export()
+		So(s, ShouldResemble, []interface{}{"fish"})
+	})
+}
+
+func TestJSSystemZome(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("the system zome should be callable from js", t, func() {
+		v, err := NewJSNucleus(h, `var x = hc("jsonGet", {Object:{a:"b"},Path:"a"});`)
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		_, err = z.Run(`x`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.String(), ShouldEqual, `"b"`)
+	})
+}
+
+func TestJSCoverage(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("js should count function bodies, including calls from other functions", t, func() {
+		hits := make(map[string]int)
+		hit := func(fn string) { hits[fn]++ }
+		v, err := NewJSNucleus(h, `function a(x) { return b(x); } function b(x) { return x; }`)
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		So(z.cover([]string{"a", "b"}, hit), ShouldBeNil)
+		_, err = z.Run(`a(1)`)
+		So(err, ShouldBeNil)
+		So(hits["a"], ShouldEqual, 1)
+		So(hits["b"], ShouldEqual, 1)
+	})
+}
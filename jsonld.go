@@ -0,0 +1,342 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// jsonld implements exporting chain entries as JSON-LD verifiable credentials, so that
+// systems outside of holochain can check claims made on a chain without running a node.
+// Each credential is signed by the agent's key over its canonical JSON, which is the
+// credential without its proof with object keys sorted and no insignificant whitespace,
+// and links to the chain and the entry's header and previous header.  It also carries the
+// entry and header as committed, so that it is bound to the agent's original signature in
+// the chain rather than only to the signature made when it was exported.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	mh "github.com/multiformats/go-multihash"
+	"strings"
+	"time"
+)
+
+const (
+	CredentialProofType = "HolochainSignature2017"
+	CredentialURNPrefix = "urn:holochain:"
+)
+
+var ErrBadCredential error = errors.New("credential signature doesn't verify")
+
+// CredentialContext is the JSON-LD context of exported credentials
+var CredentialContext = []interface{}{
+	"https://www.w3.org/2018/credentials/v1",
+	map[string]interface{}{
+		"hc":                     CredentialURNPrefix + "vocab#",
+		"HolochainEntry":         "hc:Entry",
+		"HolochainSignature2017": "hc:Signature2017",
+		"chain":                  map[string]string{"@id": "hc:chain", "@type": "@id"},
+		"entryType":              "hc:entryType",
+		"content":                map[string]string{"@id": "hc:content", "@type": "@json"},
+		"header":                 map[string]string{"@id": "hc:header", "@type": "@id"},
+		"previousHeader":         map[string]string{"@id": "hc:previousHeader", "@type": "@id"},
+		"committedEntry":         "hc:committedEntry",
+		"committedHeader":        "hc:committedHeader",
+		"publicKeyBase58":        "hc:publicKeyBase58",
+	},
+}
+
+// Credential is a chain entry rendered as a verifiable credential
+type Credential struct {
+	Context           []interface{}     `json:"@context"`
+	ID                string            `json:"id"`
+	Type              []string          `json:"type"`
+	Issuer            string            `json:"issuer"`
+	IssuanceDate      string            `json:"issuanceDate"`
+	CredentialSubject CredentialSubject `json:"credentialSubject"`
+	Proof             *CredentialProof  `json:"proof,omitempty"`
+}
+
+// CredentialSubject is the entry a credential is about
+type CredentialSubject struct {
+	ID             string      `json:"id"`
+	Chain          string      `json:"chain"`
+	EntryType      string      `json:"entryType"`
+	Content        interface{} `json:"content"`
+	Header         string      `json:"header"`
+	PreviousHeader string      `json:"previousHeader,omitempty"`
+	Entry          string      `json:"committedEntry"`  // base58 of the entry as committed, which the header links to
+	HeaderData     string      `json:"committedHeader"` // base58 of the header as committed, holding the agent's signature of the entry
+}
+
+// CredentialProof is the agent's signature of a credential
+type CredentialProof struct {
+	Type               string `json:"type"`
+	Created            string `json:"created"`
+	VerificationMethod string `json:"verificationMethod"`
+	ProofPurpose       string `json:"proofPurpose"`
+	PublicKeyBase58    string `json:"publicKeyBase58"`
+	ProofValue         string `json:"proofValue"` // base58 signature of the canonical JSON
+}
+
+func credentialURN(kind string, id string) string {
+	return CredentialURNPrefix + kind + ":" + id
+}
+
+// decodeJSON decodes json keeping numbers as they were written
+func decodeJSON(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// canonicalJSON encodes a decoded json value with sorted keys and without escaping html
+func canonicalJSON(v interface{}) (b []byte, err error) {
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err = e.Encode(v); err != nil {
+		return
+	}
+	b = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return
+}
+
+// unsignedCredential returns the decoded credential without its proof
+func unsignedCredential(b []byte) (doc map[string]interface{}, err error) {
+	if err = decodeJSON(b, &doc); err != nil {
+		return
+	}
+	delete(doc, "proof")
+	return
+}
+
+// credential renders an entry of the chain as a signed credential
+func (h *Holochain) credential(hash Hash, header *Header, entry Entry) (c *Credential, err error) {
+	var content interface{}
+	if s, ok := entry.Content().(string); ok {
+		content = s
+		if _, d, e := h.GetEntryDef(header.Type); e == nil && d.DataFormat == DataFormatJSON {
+			if err = decodeJSON([]byte(s), &content); err != nil {
+				err = fmt.Errorf("entry %v: %v", header.EntryLink, err)
+				return
+			}
+		}
+	} else {
+		content = entry.Content()
+	}
+	agent := credentialURN("agent", peer.IDB58Encode(h.id))
+	c = &Credential{
+		Context:      CredentialContext,
+		ID:           credentialURN("entry", header.EntryLink.String()),
+		Type:         []string{"VerifiableCredential", "HolochainEntry"},
+		Issuer:       agent,
+		IssuanceDate: header.Time.UTC().Format(time.RFC3339),
+		CredentialSubject: CredentialSubject{
+			ID:        credentialURN("entry", header.EntryLink.String()),
+			Chain:     credentialURN("dna", h.dnaHash.String()),
+			EntryType: header.Type,
+			Content:   content,
+			Header:    credentialURN("header", hash.String()),
+		},
+	}
+	if !header.HeaderLink.IsNullHash() {
+		c.CredentialSubject.PreviousHeader = credentialURN("header", header.HeaderLink.String())
+	}
+	var b []byte
+	if b, err = entry.Marshal(); err != nil {
+		return
+	}
+	c.CredentialSubject.Entry = b58.Encode(b)
+	if b, err = header.Marshal(); err != nil {
+		return
+	}
+	c.CredentialSubject.HeaderData = b58.Encode(b)
+
+	if b, err = json.Marshal(c); err != nil {
+		return
+	}
+	var doc map[string]interface{}
+	if doc, err = unsignedCredential(b); err != nil {
+		return
+	}
+	if b, err = canonicalJSON(doc); err != nil {
+		return
+	}
+	var pub, sig []byte
+	if pub, err = ic.MarshalPublicKey(h.agent.PubKey()); err != nil {
+		return
+	}
+	if sig, err = h.agent.PrivKey().Sign(b); err != nil {
+		return
+	}
+	c.Proof = &CredentialProof{
+		Type:               CredentialProofType,
+		Created:            c.IssuanceDate,
+		VerificationMethod: agent + "#key",
+		ProofPurpose:       "assertionMethod",
+		PublicKeyBase58:    b58.Encode(pub),
+		ProofValue:         b58.Encode(sig),
+	}
+	return
+}
+
+// ExportCredentials renders the app entries of the chain, oldest first, as signed
+// credentials.  If entryType is set only entries of that type are exported.
+func (h *Holochain) ExportCredentials(entryType string) (creds []*Credential, err error) {
+	var it *ChainIterator
+	if it, err = h.Iterator(false); err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Next() {
+		header := it.Header()
+		if strings.HasPrefix(header.Type, "%") || (entryType != "" && header.Type != entryType) {
+			continue
+		}
+		var entry Entry
+		if entry, err = it.Entry(); err != nil {
+			return
+		}
+		var c *Credential
+		if c, err = h.credential(it.Hash(), header, entry); err != nil {
+			return
+		}
+		creds = append(creds, c)
+	}
+	err = it.Err()
+	return
+}
+
+// VerifyCredential checks that an exported credential is signed by the key in its proof, that
+// the key belongs to the issuing agent, and that the entry and header it carries are the ones
+// it describes, with the header signed in the chain by the same key.  It needs nothing but the
+// credential itself.
+func VerifyCredential(b []byte) (c *Credential, err error) {
+	c = &Credential{}
+	if err = json.Unmarshal(b, c); err != nil {
+		return
+	}
+	p := c.Proof
+	if p == nil {
+		err = errors.New("credential has no proof")
+		return
+	}
+	if p.Type != CredentialProofType {
+		err = fmt.Errorf("unknown credential proof type: %s", p.Type)
+		return
+	}
+	var key ic.PubKey
+	if key, err = ic.UnmarshalPublicKey(b58.Decode(p.PublicKeyBase58)); err != nil {
+		err = fmt.Errorf("bad credential key: %v", err)
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(key); err != nil {
+		return
+	}
+	if c.Issuer != credentialURN("agent", peer.IDB58Encode(id)) {
+		err = errors.New("credential key doesn't belong to the issuing agent")
+		return
+	}
+	var doc map[string]interface{}
+	if doc, err = unsignedCredential(b); err != nil {
+		return
+	}
+	var signed []byte
+	if signed, err = canonicalJSON(doc); err != nil {
+		return
+	}
+	if ok, e := key.Verify(signed, b58.Decode(p.ProofValue)); e != nil || !ok {
+		err = ErrBadCredential
+		return
+	}
+	subject, _ := doc["credentialSubject"].(map[string]interface{})
+	if err = c.verifyCommitted(key, subject["content"]); err != nil {
+		err = fmt.Errorf("%v: %v", ErrBadCredential, err)
+	}
+	return
+}
+
+// verifyCommitted checks that the entry and header a credential carries are what it
+// describes, and that the header's signature of the entry is the agent's
+func (c *Credential) verifyCommitted(key ic.PubKey, content interface{}) (err error) {
+	s := &c.CredentialSubject
+	var hash Hash
+	if hash, err = NewHash(strings.TrimPrefix(s.Header, CredentialURNPrefix+"header:")); err != nil {
+		return
+	}
+	var d *mh.DecodedMultihash
+	if d, err = mh.Decode(hash.H); err != nil {
+		return
+	}
+	spec := HashSpec{Code: d.Code, Length: d.Length}
+
+	hb := b58.Decode(s.HeaderData)
+	var sum Hash
+	if err = sum.Sum(spec, hb); err != nil {
+		return
+	}
+	if sum.String() != hash.String() {
+		err = errors.New("committed header doesn't match the header it links")
+		return
+	}
+	var header Header
+	if err = header.Unmarshal(hb, len(hash.H)); err != nil {
+		return
+	}
+	previous := ""
+	if !header.HeaderLink.IsNullHash() {
+		previous = credentialURN("header", header.HeaderLink.String())
+	}
+	if header.Type != s.EntryType || credentialURN("entry", header.EntryLink.String()) != s.ID ||
+		header.Time.UTC().Format(time.RFC3339) != c.IssuanceDate || previous != s.PreviousHeader {
+		err = errors.New("committed header doesn't match the credential")
+		return
+	}
+	if ok, e := key.Verify(header.EntryLink.H, header.Sig.S); e != nil || !ok {
+		err = errors.New("committed header isn't signed by the issuing agent")
+		return
+	}
+
+	var entry GobEntry
+	if err = entry.Unmarshal(b58.Decode(s.Entry)); err != nil {
+		return
+	}
+	if sum, err = entry.Sum(spec); err != nil {
+		return
+	}
+	if sum.String() != header.EntryLink.String() {
+		err = errors.New("committed entry doesn't match its header")
+		return
+	}
+	committed, ok := entry.C.(string)
+	if !ok {
+		var b []byte
+		if b, err = json.Marshal(entry.C); err != nil {
+			return
+		}
+		committed = string(b)
+	}
+	var want interface{} = committed
+	if _, isString := content.(string); !isString {
+		if err = decodeJSON([]byte(committed), &want); err != nil {
+			return
+		}
+	}
+	var a, b []byte
+	if a, err = canonicalJSON(want); err != nil {
+		return
+	}
+	if b, err = canonicalJSON(content); err != nil {
+		return
+	}
+	if !bytes.Equal(a, b) {
+		err = errors.New("content isn't that of the committed entry")
+	}
+	return
+}
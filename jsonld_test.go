@@ -0,0 +1,107 @@
+package holochain
+
+import (
+	"encoding/json"
+	b58 "github.com/jbenet/go-base58"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	Convey("it should sort keys and keep numbers and html as written", t, func() {
+		var v interface{}
+		So(decodeJSON([]byte(`{"b": 12345678901234567890, "a": "<&>", "c": [1.50]}`), &v), ShouldBeNil)
+		b, err := canonicalJSON(v)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `{"a":"<&>","b":12345678901234567890,"c":[1.50]}`)
+	})
+}
+
+func TestExportCredentials(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	if _, err := h.Call("myZome", "addData", "2"); err != nil {
+		panic(err)
+	}
+	profile, err := h.Commit("profile", `{"firstName":"Art","lastName":"Brock <&>","age":42}`)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should export the app entries oldest first", t, func() {
+		creds, err := h.ExportCredentials("")
+		So(err, ShouldBeNil)
+		So(len(creds), ShouldEqual, 2)
+		So(creds[0].CredentialSubject.EntryType, ShouldEqual, "myData")
+		So(creds[1].ID, ShouldEqual, "urn:holochain:entry:"+profile.String())
+		So(creds[1].Issuer, ShouldEqual, "urn:holochain:agent:"+peer.IDB58Encode(h.id))
+		So(creds[1].CredentialSubject.Chain, ShouldEqual, "urn:holochain:dna:"+h.dnaHash.String())
+		So(creds[1].CredentialSubject.PreviousHeader, ShouldEqual, creds[0].CredentialSubject.Header)
+		top := h.chain.Hashes[len(h.chain.Hashes)-1]
+		So(creds[1].CredentialSubject.Header, ShouldEqual, "urn:holochain:header:"+top.String())
+
+		content := creds[1].CredentialSubject.Content.(map[string]interface{})
+		So(content["firstName"], ShouldEqual, "Art")
+
+		creds, err = h.ExportCredentials("profile")
+		So(err, ShouldBeNil)
+		So(len(creds), ShouldEqual, 1)
+	})
+
+	Convey("exported credentials should verify on their own", t, func() {
+		creds, _ := h.ExportCredentials("profile")
+		b, err := json.MarshalIndent(creds[0], "", "  ")
+		So(err, ShouldBeNil)
+		So(string(b), ShouldContainSubstring, `"@context"`)
+		c, err := VerifyCredential(b)
+		So(err, ShouldBeNil)
+		So(c.ID, ShouldEqual, creds[0].ID)
+
+		tampered := strings.Replace(string(b), "Art", "Bob", 1)
+		_, err = VerifyCredential([]byte(tampered))
+		So(err, ShouldEqual, ErrBadCredential)
+
+		other, _ := makePeer("other")
+		forged := strings.Replace(string(b), peer.IDB58Encode(h.id), peer.IDB58Encode(other), -1)
+		_, err = VerifyCredential([]byte(forged))
+		So(err.Error(), ShouldEqual, "credential key doesn't belong to the issuing agent")
+
+		creds[0].Proof = nil
+		b, _ = json.Marshal(creds[0])
+		_, err = VerifyCredential(b)
+		So(err.Error(), ShouldEqual, "credential has no proof")
+	})
+
+	Convey("credentials should be bound to the agent's signature in the chain", t, func() {
+		creds, _ := h.ExportCredentials("")
+		resign := func(c *Credential) []byte {
+			b, _ := json.Marshal(c)
+			doc, _ := unsignedCredential(b)
+			signed, _ := canonicalJSON(doc)
+			sig, _ := h.agent.PrivKey().Sign(signed)
+			c.Proof.ProofValue = b58.Encode(sig)
+			b, _ = json.Marshal(c)
+			return b
+		}
+		c := *creds[1]
+		c.CredentialSubject.Content = map[string]interface{}{"firstName": "Bob"}
+		_, err := VerifyCredential(resign(&c))
+		So(err.Error(), ShouldEqual, ErrBadCredential.Error()+": content isn't that of the committed entry")
+
+		c = *creds[1]
+		c.CredentialSubject.HeaderData = creds[0].CredentialSubject.HeaderData
+		_, err = VerifyCredential(resign(&c))
+		So(err.Error(), ShouldEqual, ErrBadCredential.Error()+": committed header doesn't match the header it links")
+
+		c = *creds[1]
+		c.CredentialSubject.Entry = creds[0].CredentialSubject.Entry
+		_, err = VerifyCredential(resign(&c))
+		So(err.Error(), ShouldEqual, ErrBadCredential.Error()+": committed entry doesn't match its header")
+
+		b, _ := json.Marshal(creds[0])
+		_, err = VerifyCredential(b)
+		So(err, ShouldBeNil)
+	})
+}
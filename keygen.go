@@ -0,0 +1,139 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// keygen implements generating agent keys outside of a service, of a chosen algorithm and,
+// for reproducible test identities, derived from a seed such as a mnemonic phrase.  The same
+// seed always yields the same key, so seeds must never be used for real identities.
+
+package holochain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultKeyAlgorithm = "ed25519"
+	DefaultRSABits      = 2048
+)
+
+// KeyAlgorithms maps the names of the key algorithms agents can use to their key types
+var KeyAlgorithms = map[string]int{
+	"ed25519": ic.Ed25519,
+	"rsa":     ic.RSA,
+}
+
+// KeyAlgorithmNames returns the sorted names of the key algorithms
+func KeyAlgorithmNames() (names []string) {
+	for name := range KeyAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// KeyFingerprints are the encodings a public key is commonly referred to by
+type KeyFingerprints struct {
+	NodeID string // the peer id of the key, as used for node and agent ids
+	Base58 string // the marshaled public key
+	Base64 string
+	SHA256 string // hex of the sha256 of the marshaled public key
+}
+
+// seedReader is a deterministic stream of bytes derived from a seed, being the sha256 of
+// the seed and a counter for each block
+type seedReader struct {
+	seed  []byte
+	n     uint64
+	block []byte
+}
+
+// normalizeSeed makes seeds that differ only in case or spacing, as mnemonic phrases typed
+// out often do, the same
+func normalizeSeed(seed string) string {
+	return strings.Join(strings.Fields(strings.ToLower(seed)), " ")
+}
+
+func newSeedReader(seed string) *seedReader {
+	return &seedReader{seed: []byte("holochain-keygen:" + normalizeSeed(seed))}
+}
+
+func (r *seedReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(r.block) == 0 {
+			var ctr [8]byte
+			binary.BigEndian.PutUint64(ctr[:], r.n)
+			r.n++
+			sum := sha256.Sum256(append(append([]byte{}, r.seed...), ctr[:]...))
+			r.block = sum[:]
+		}
+		c := copy(p[n:], r.block)
+		r.block = r.block[c:]
+		n += c
+	}
+	return
+}
+
+// GenerateKey generates a private key with the named algorithm, deriving it from seed if
+// that isn't empty.  bits is only used by rsa, and defaults to DefaultRSABits.
+func GenerateKey(algorithm string, bits int, seed string) (priv ic.PrivKey, err error) {
+	typ, ok := KeyAlgorithms[algorithm]
+	if !ok {
+		err = fmt.Errorf("unknown key algorithm: %s, must be one of: %s", algorithm, strings.Join(KeyAlgorithmNames(), ", "))
+		return
+	}
+	var src io.Reader = rand.Reader
+	if seed != "" {
+		// rsa key generation reads an unpredictable amount of randomness so it can't be seeded
+		if typ != ic.Ed25519 {
+			err = fmt.Errorf("only %s keys can be derived from a seed", DefaultKeyAlgorithm)
+			return
+		}
+		src = newSeedReader(seed)
+	}
+	if typ == ic.Ed25519 {
+		priv, _, err = ic.GenerateEd25519Key(src)
+		return
+	}
+	if bits == 0 {
+		bits = DefaultRSABits
+	}
+	priv, _, err = ic.GenerateKeyPairWithReader(typ, bits, src)
+	return
+}
+
+// NewAgentWithKey creates an agent with an existing private key
+func NewAgentWithKey(name AgentName, priv ic.PrivKey) Agent {
+	return &IPFSAgent{name: name, priv: priv}
+}
+
+// Fingerprints returns the encodings of a public key
+func Fingerprints(pub ic.PubKey) (f KeyFingerprints, err error) {
+	var b []byte
+	if b, err = ic.MarshalPublicKey(pub); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(pub); err != nil {
+		return
+	}
+	sum := sha256.Sum256(b)
+	f = KeyFingerprints{
+		NodeID: peer.IDB58Encode(id),
+		Base58: b58.Encode(b),
+		Base64: base64.StdEncoding.EncodeToString(b),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package holochain
+
+import (
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	Convey("it should derive the same key from the same seed", t, func() {
+		k1, err := GenerateKey("ed25519", 0, "correct horse battery staple")
+		So(err, ShouldBeNil)
+		k2, err := GenerateKey("ed25519", 0, "  Correct Horse\tbattery staple ")
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(k1, k2), ShouldBeTrue)
+		k3, err := GenerateKey("ed25519", 0, "another seed")
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(k1, k3), ShouldBeFalse)
+	})
+
+	Convey("it should generate random keys without a seed", t, func() {
+		k1, err := GenerateKey("ed25519", 0, "")
+		So(err, ShouldBeNil)
+		k2, _ := GenerateKey("ed25519", 0, "")
+		So(ic.KeyEqual(k1, k2), ShouldBeFalse)
+		k, err := GenerateKey("rsa", 1024, "")
+		So(err, ShouldBeNil)
+		So(k, ShouldNotBeNil)
+	})
+
+	Convey("it should reject unknown algorithms and seeded rsa keys", t, func() {
+		_, err := GenerateKey("dsa", 0, "")
+		So(err.Error(), ShouldEqual, "unknown key algorithm: dsa, must be one of: ed25519, rsa")
+		_, err = GenerateKey("rsa", 0, "seed")
+		So(err.Error(), ShouldEqual, "only ed25519 keys can be derived from a seed")
+	})
+}
+
+func TestFingerprints(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+
+	Convey("it should print the key's node id and encodings", t, func() {
+		priv, _ := GenerateKey("ed25519", 0, "test identity")
+		f, err := Fingerprints(priv.GetPublic())
+		So(err, ShouldBeNil)
+		id, _ := peer.IDFromPublicKey(priv.GetPublic())
+		So(f.NodeID, ShouldEqual, peer.IDB58Encode(id))
+		So(len(f.SHA256), ShouldEqual, 64)
+	})
+
+	Convey("a generated key should save and load as an agent", t, func() {
+		priv, _ := GenerateKey("ed25519", 0, "test identity")
+		So(SaveAgent(d, NewAgentWithKey("tester", priv)), ShouldBeNil)
+		a, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(a.Name(), ShouldEqual, "tester")
+		So(ic.KeyEqual(a.PrivKey(), priv), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// kv implements a key-value store for node-local zome state that isn't committed, like sync
+// cursors, drafts or caches.  Each zome has its own namespace.  The values are kept in the
+// chain's store but aren't entries, so they are never hashed, validated or gossiped, though
+// they do count towards the store's size quota.
+
+package holochain
+
+import (
+	"errors"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+)
+
+var ErrKVNotFound error = errors.New("key not found")
+var ErrKVInValidation error = errors.New("the key-value store can't be used during validation")
+var ErrKVBadKey error = errors.New("keys must not be empty")
+
+// kvKey returns the key in the store of a zome's key.  The zome name is prefixed with its
+// length so that a zome whose name holds a ':' can't reach into another zome's namespace.
+func kvKey(zome string, key string) string {
+	return "kv:" + strconv.Itoa(len(zome)) + ":" + zome + ":" + key
+}
+
+// KVSet sets the value of a key in a zome's store
+func (dht *DHT) KVSet(zome string, key string, value string) (err error) {
+	if key == "" {
+		err = ErrKVBadKey
+		return
+	}
+	k := kvKey(zome, key)
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if err := dht.resize(tx, k, len(value)); err != nil {
+			return err
+		}
+		_, _, err := tx.Set(k, value, nil)
+		return err
+	})
+	return
+}
+
+// KVGet returns the value of a key in a zome's store
+func (dht *DHT) KVGet(zome string, key string) (value string, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) (e error) {
+		value, e = tx.Get(kvKey(zome, key))
+		if e == buntdb.ErrNotFound {
+			e = ErrKVNotFound
+		}
+		return
+	})
+	return
+}
+
+// KVDelete removes a key from a zome's store
+func (dht *DHT) KVDelete(zome string, key string) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		old, err := tx.Delete(kvKey(zome, key))
+		if err == buntdb.ErrNotFound {
+			return ErrKVNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return dht.incSize(tx, -len(old))
+	})
+	return
+}
+
+// KVList returns the keys in a zome's store that start with prefix, in order
+func (dht *DHT) KVList(zome string, prefix string) (keys []string, err error) {
+	start := kvKey(zome, prefix)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		// the prefix is matched literally, as keys may hold the pattern characters * and ?
+		tx.AscendGreaterOrEqual("", start, func(key, value string) bool {
+			if !strings.HasPrefix(key, start) {
+				return false
+			}
+			keys = append(keys, strings.TrimPrefix(key, kvKey(zome, "")))
+			return true
+		})
+		return nil
+	})
+	return
+}
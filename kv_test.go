@@ -0,0 +1,84 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestKV(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	Convey("it should set and get values", t, func() {
+		_, err := dht.KVGet("myZome", "cursor")
+		So(err, ShouldEqual, ErrKVNotFound)
+		So(dht.KVSet("myZome", "cursor", "42"), ShouldBeNil)
+		v, err := dht.KVGet("myZome", "cursor")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "42")
+		So(dht.KVSet("myZome", "cursor", "43"), ShouldBeNil)
+		v, _ = dht.KVGet("myZome", "cursor")
+		So(v, ShouldEqual, "43")
+		So(dht.KVSet("myZome", "", "43"), ShouldEqual, ErrKVBadKey)
+	})
+
+	Convey("it should keep each zome's keys separate", t, func() {
+		_, err := dht.KVGet("jsZome", "cursor")
+		So(err, ShouldEqual, ErrKVNotFound)
+		So(dht.KVSet("jsZome", "cursor", "1"), ShouldBeNil)
+		v, _ := dht.KVGet("myZome", "cursor")
+		So(v, ShouldEqual, "43")
+	})
+
+	Convey("it should list keys by prefix", t, func() {
+		So(dht.KVSet("myZome", "draft:2", "b"), ShouldBeNil)
+		So(dht.KVSet("myZome", "draft:1", "a"), ShouldBeNil)
+		So(dht.KVSet("myZome", "draft*", "c"), ShouldBeNil)
+		keys, err := dht.KVList("myZome", "draft:")
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, []string{"draft:1", "draft:2"})
+		keys, _ = dht.KVList("myZome", "draft*")
+		So(keys, ShouldResemble, []string{"draft*"})
+		keys, _ = dht.KVList("myZome", "")
+		So(keys, ShouldResemble, []string{"cursor", "draft*", "draft:1", "draft:2"})
+		keys, _ = dht.KVList("otherZome", "")
+		So(len(keys), ShouldEqual, 0)
+	})
+
+	Convey("it should delete keys", t, func() {
+		So(dht.KVDelete("myZome", "draft*"), ShouldBeNil)
+		So(dht.KVDelete("myZome", "draft*"), ShouldEqual, ErrKVNotFound)
+		keys, _ := dht.KVList("myZome", "draft")
+		So(keys, ShouldResemble, []string{"draft:1", "draft:2"})
+	})
+
+	Convey("values shouldn't be gossiped", t, func() {
+		idx, _ := dht.GetIdx()
+		So(dht.KVSet("myZome", "cache", "x"), ShouldBeNil)
+		after, _ := dht.GetIdx()
+		So(after, ShouldEqual, idx)
+	})
+
+	Convey("zome names holding a ':' shouldn't reach other zomes' keys", t, func() {
+		So(dht.KVSet("a", "b:c", "x"), ShouldBeNil)
+		_, err := dht.KVGet("a:b", "c")
+		So(err, ShouldEqual, ErrKVNotFound)
+		keys, _ := dht.KVList("a:b", "")
+		So(len(keys), ShouldEqual, 0)
+	})
+
+	Convey("values should count towards the store's size", t, func() {
+		size := dhtSize(dht)
+		So(dht.KVSet("myZome", "sized", "12345"), ShouldBeNil)
+		So(dhtSize(dht), ShouldEqual, size+5)
+		So(dht.KVSet("myZome", "sized", "123"), ShouldBeNil)
+		So(dhtSize(dht), ShouldEqual, size+3)
+		So(dht.KVDelete("myZome", "sized"), ShouldBeNil)
+		So(dhtSize(dht), ShouldEqual, size)
+
+		h.config.Quotas.MaxDHTBytes = int64(size) + 2
+		So(dht.KVSet("myZome", "sized", "12345"), ShouldEqual, ErrDHTQuotaExceeded)
+		h.config.Quotas.MaxDHTBytes = 0
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// library implements shared code libraries declared in the DNA, which zomes may depend on to
+// have common helpers loaded into their nucleus before the zome's own code.  A zome names the
+// libraries it depends on by the hash of their code, so a dependency can't silently change
+// under it.
+
+package holochain
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// Library holds the DNA definition of a shared code library
+type Library struct {
+	Name        string
+	Description string
+	Code        string // file name of library code
+	CodeHash    Hash
+	NucleusType string
+}
+
+// library finds the library a zome depends on by the hash of its code, returning the library
+// and its code, which is thereby known to match the hash
+func (h *Holochain) library(hash string) (l *Library, code []byte, err error) {
+	names := make([]string, 0, len(h.Libraries))
+	for name := range h.Libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var c []byte
+		c, err = readFile(h.path, h.Libraries[name].Code)
+		if err != nil {
+			return
+		}
+		var sum Hash
+		if err = sum.Sum(h.hashSpec, c); err != nil {
+			return
+		}
+		if sum.String() == hash {
+			l = h.Libraries[name]
+			code = c
+			return
+		}
+	}
+	err = fmt.Errorf("no library with code hash: %s", hash)
+	return
+}
+
+// checkLibraries confirms that the libraries a zome depends on exist and suit its nucleus
+func (h *Holochain) checkLibraries(z *Zome) (err error) {
+	for _, l := range h.Libraries {
+		if !fileExists(filepath.Join(h.path, l.Code)) {
+			return fmt.Errorf("DNA specified library code file missing: %s", l.Code)
+		}
+	}
+	for _, hash := range z.Libraries {
+		var l *Library
+		if l, _, err = h.library(hash); err != nil {
+			return fmt.Errorf("zome %s depends on unknown library: %s", z.Name, hash)
+		}
+		if l.NucleusType != z.NucleusType {
+			return fmt.Errorf("zome %s is %s but library %s is %s", z.Name, z.NucleusType, l.Name, l.NucleusType)
+		}
+	}
+	return
+}
+
+// zomeCode returns the code to load into a zome's nucleus: the code of the libraries it
+// depends on, in the order declared, followed by the zome's own code
+func (h *Holochain) zomeCode(z *Zome) (code []byte, err error) {
+	for _, hash := range z.Libraries {
+		var lc []byte
+		_, lc, err = h.library(hash)
+		if err != nil {
+			return
+		}
+		code = append(code, lc...)
+		code = append(code, '\n')
+	}
+	var zc []byte
+	zc, err = readFile(h.path, z.Code)
+	if err != nil {
+		return
+	}
+	code = append(code, zc...)
+	return
+}
@@ -0,0 +1,65 @@
+// +build !nojs
+
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLibraries(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	ioutil.WriteFile(h.path+"/lib_helpers.js", []byte(`function double(x) {return x*2}`), 0644)
+	h.Libraries = map[string]*Library{
+		"helpers": {Name: "helpers", Code: "lib_helpers.js", NucleusType: JSNucleusType},
+	}
+	z := h.Zomes["jsZome"]
+	var libHash Hash
+	libHash.Sum(h.hashSpec, []byte(`function double(x) {return x*2}`))
+
+	Convey("it should check that zome dependencies exist and suit the zome", t, func() {
+		z.Libraries = []string{"fish"}
+		So(h.checkLibraries(z).Error(), ShouldEqual, "zome jsZome depends on unknown library: fish")
+		h.Zomes["myZome"].Libraries = []string{libHash.String()}
+		So(h.checkLibraries(h.Zomes["myZome"]).Error(), ShouldEqual, "zome myZome is zygo but library helpers is js")
+		h.Zomes["myZome"].Libraries = nil
+		z.Libraries = []string{libHash.String()}
+		So(h.checkLibraries(z), ShouldBeNil)
+	})
+
+	Convey("it should load libraries before the zome code", t, func() {
+		n, err := h.makeNucleus(z)
+		So(err, ShouldBeNil)
+		js := n.(*JSNucleus)
+		_, err = js.Run("double(21)")
+		So(err, ShouldBeNil)
+		So(js.lastResult.String(), ShouldEqual, "42")
+	})
+
+	Convey("libraries should be part of the hashed DNA", t, func() {
+		_, before, err := h.BuildDNA()
+		So(err, ShouldBeNil)
+		So(h.Libraries["helpers"].CodeHash.String(), ShouldNotEqual, "")
+
+		ioutil.WriteFile(h.path+"/lib_helpers.js", []byte(`function double(x) {return x+x}`), 0644)
+		_, err = h.makeNucleus(z)
+		So(err.Error(), ShouldEqual, "no library with code hash: "+libHash.String())
+
+		_, after, err := h.BuildDNA()
+		So(err, ShouldBeNil)
+		So(after.String(), ShouldNotEqual, before.String())
+		z.Libraries = []string{h.Libraries["helpers"].CodeHash.String()}
+		_, err = h.makeNucleus(z)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("libraries should be bundled with the DNA", t, func() {
+		b, err := h.Bundle()
+		So(err, ShouldBeNil)
+		_, ok := b.Files["lib_helpers.js"]
+		So(ok, ShouldBeTrue)
+	})
+}
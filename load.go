@@ -0,0 +1,202 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// load implements generating load on a chain for capacity planning, by calling a zome function
+// over and over at a target rate.  The arguments of each call are made from a template, so
+// that calls can commit distinct entries, and the run reports the percentiles of the calls'
+// latencies and counts their errors by kind.  Latencies are measured from when each call was
+// due to start at the target rate, rather than from when it actually started, so that calls
+// held up by slow ones before them count the time they waited.  While it runs its progress is also kept in the
+// chain's metrics, alongside those of the work the calls cause.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLoadRate        = 10
+	DefaultLoadConcurrency = 8
+	MaxLoadRate            = 1e6 // calls per second, beyond which the interval between calls is too small to time
+)
+
+// LoadOptions describes a load run
+type LoadOptions struct {
+	Zome        string
+	Function    string
+	Args        string        // template of the arguments, in which {n} is replaced by the call's number, {rand} by a random number and {time} by the time in milliseconds
+	Rate        float64       // calls started per second, DefaultLoadRate if 0
+	Calls       int           // calls to make, 0 to run until stopped or Duration is up
+	Duration    time.Duration // how long to run for, 0 to run until stopped or Calls have been made
+	Concurrency int           // most calls in progress at once, DefaultLoadConcurrency if 0
+}
+
+// LoadReport summarizes a load run
+type LoadReport struct {
+	Calls    int           // calls completed
+	Errors   int           // calls that failed
+	Skipped  int           // calls not started because Concurrency calls were already in progress
+	Elapsed  time.Duration // time from the first call being started to the last finishing
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+	ErrorsBy map[string]int // counts of the errors by message
+}
+
+// Rate returns the calls completed per second
+func (r *LoadReport) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Calls) / r.Elapsed.Seconds()
+}
+
+func (r LoadReport) String() string {
+	s := fmt.Sprintf("%d calls in %v (%.1f/s), %d errors", r.Calls, r.Elapsed, r.Rate(), r.Errors)
+	if r.Skipped > 0 {
+		s += fmt.Sprintf(", %d skipped", r.Skipped)
+	}
+	s += fmt.Sprintf("\nlatency p50 %v p90 %v p99 %v max %v", r.P50, r.P90, r.P99, r.Max)
+	var msgs []string
+	for msg := range r.ErrorsBy {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+	for _, msg := range msgs {
+		s += fmt.Sprintf("\n%6d %s", r.ErrorsBy[msg], msg)
+	}
+	return s
+}
+
+// Check validates the load run's options
+func (o *LoadOptions) Check() (err error) {
+	switch {
+	case o.Zome == "" || o.Function == "":
+		err = errors.New("load: missing zome or function")
+	case o.Rate < 0:
+		err = fmt.Errorf("load: rate must be positive, got: %v", o.Rate)
+	case o.Rate > MaxLoadRate:
+		err = fmt.Errorf("load: rate can't be over %v, got: %v", MaxLoadRate, o.Rate)
+	case o.Calls < 0:
+		err = fmt.Errorf("load: calls can't be negative, got: %d", o.Calls)
+	case o.Duration < 0:
+		err = fmt.Errorf("load: duration can't be negative, got: %v", o.Duration)
+	case o.Concurrency < 0:
+		err = fmt.Errorf("load: concurrency can't be negative, got: %d", o.Concurrency)
+	}
+	return
+}
+
+// LoadArgs returns the arguments of the nth call of a load run from its template
+func LoadArgs(template string, n int) string {
+	return strings.NewReplacer(
+		"{n}", strconv.Itoa(n),
+		"{rand}", strconv.Itoa(rand.Int()),
+		"{time}", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+	).Replace(template)
+}
+
+// percentile returns the pth percentile of sorted latencies
+func percentile(sorted durations, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// RunLoad calls a zome function at the options' rate until the options' calls have been made,
+// their duration is up or stop is closed, and reports on the calls
+func (h *Holochain) RunLoad(opts LoadOptions, stop <-chan struct{}) (r LoadReport, err error) {
+	if err = opts.Check(); err != nil {
+		return
+	}
+	rate := opts.Rate
+	if rate == 0 {
+		rate = DefaultLoadRate
+	}
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultLoadConcurrency
+	}
+	var deadline <-chan time.Time
+	if opts.Duration > 0 {
+		deadline = time.After(opts.Duration)
+	}
+
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	var latencies durations
+	r.ErrorsBy = make(map[string]int)
+	slots := make(chan bool, concurrency)
+	call := func(n int, due time.Time) {
+		defer func() {
+			<-slots
+			wg.Done()
+		}()
+		_, e := h.Call(opts.Zome, opts.Function, LoadArgs(opts.Args, n))
+		d := time.Since(due)
+		lk.Lock()
+		defer lk.Unlock()
+		r.Calls++
+		latencies = append(latencies, d)
+		h.metrics.Inc("load.calls", 1)
+		if e != nil {
+			r.Errors++
+			r.ErrorsBy[e.Error()]++
+			h.metrics.Inc("load.errors", 1)
+		}
+	}
+
+	start := time.Now()
+	interval := time.Duration(float64(time.Second) / rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for n := 1; opts.Calls == 0 || n <= opts.Calls; {
+		select {
+		case slots <- true:
+			wg.Add(1)
+			go call(n, start.Add(time.Duration(n-1)*interval))
+			n++
+		default:
+			lk.Lock()
+			r.Skipped++
+			lk.Unlock()
+			h.metrics.Inc("load.skipped", 1)
+		}
+		if opts.Calls > 0 && n > opts.Calls {
+			break
+		}
+		select {
+		case <-ticker.C:
+			continue
+		case <-deadline:
+		case <-stop:
+		}
+		break
+	}
+	wg.Wait()
+	r.Elapsed = time.Since(start)
+
+	sort.Sort(latencies)
+	r.P50 = percentile(latencies, 50)
+	r.P90 = percentile(latencies, 90)
+	r.P99 = percentile(latencies, 99)
+	if len(latencies) > 0 {
+		r.Max = latencies[len(latencies)-1]
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestLoadArgs(t *testing.T) {
+	Convey("it should fill in the call number", t, func() {
+		So(LoadArgs("item {n}", 7), ShouldEqual, "item 7")
+		So(LoadArgs("plain", 7), ShouldEqual, "plain")
+		So(LoadArgs("{rand}", 1), ShouldNotEqual, "{rand}")
+		So(LoadArgs("{time}", 1), ShouldNotEqual, "{time}")
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	Convey("it should pick the nearest rank", t, func() {
+		var d durations
+		for i := 1; i <= 100; i++ {
+			d = append(d, time.Duration(i))
+		}
+		So(percentile(d, 50), ShouldEqual, 50)
+		So(percentile(d, 99), ShouldEqual, 99)
+		So(percentile(d[:1], 90), ShouldEqual, 1)
+		So(percentile(nil, 50), ShouldEqual, 0)
+	})
+}
+
+func TestRunLoad(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should reject bad options", t, func() {
+		_, err := h.RunLoad(LoadOptions{Zome: "myZome"}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = h.RunLoad(LoadOptions{Zome: "myZome", Function: "exposedfn", Rate: -1}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = h.RunLoad(LoadOptions{Zome: "myZome", Function: "exposedfn", Rate: 2e9}, nil)
+		So(err.Error(), ShouldEqual, "load: rate can't be over 1e+06, got: 2e+09")
+	})
+
+	Convey("it should make the calls asked for and report on them", t, func() {
+		r, err := h.RunLoad(LoadOptions{Zome: "myZome", Function: "exposedfn", Args: "arg {n}", Rate: 200, Calls: 5}, nil)
+		So(err, ShouldBeNil)
+		So(r.Calls, ShouldEqual, 5)
+		So(r.Errors, ShouldEqual, 0)
+		So(r.Max, ShouldBeGreaterThanOrEqualTo, r.P50)
+		So(h.Metrics().Get("load.calls"), ShouldEqual, 5)
+	})
+
+	Convey("it should count errors by message", t, func() {
+		r, err := h.RunLoad(LoadOptions{Zome: "myZome", Function: "nosuchfn", Rate: 200, Calls: 3}, nil)
+		So(err, ShouldBeNil)
+		So(r.Errors, ShouldEqual, 3)
+		So(len(r.ErrorsBy), ShouldEqual, 1)
+		So(h.Metrics().Get("load.errors"), ShouldEqual, 3)
+	})
+
+	Convey("it should stop when its duration is up", t, func() {
+		r, err := h.RunLoad(LoadOptions{Zome: "myZome", Function: "exposedfn", Rate: 100, Duration: 50 * time.Millisecond}, nil)
+		So(err, ShouldBeNil)
+		So(r.Calls, ShouldBeGreaterThan, 0)
+		So(r.Elapsed, ShouldBeLessThan, time.Second)
+	})
+
+	Convey("it should stop when stopped", t, func() {
+		stop := make(chan struct{})
+		close(stop)
+		r, err := h.RunLoad(LoadOptions{Zome: "myZome", Function: "exposedfn"}, stop)
+		So(err, ShouldBeNil)
+		So(r.Calls, ShouldEqual, 1)
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -141,3 +142,46 @@ func (l *Logger) Log(m interface{}) {
 func (l *Logger) Logf(m string, args ...interface{}) {
 	l.pf(m, args...)
 }
+
+// LogBuffer holds the most recent lines written to it, for displaying recent log output
+type LogBuffer struct {
+	lk    sync.Mutex
+	max   int
+	lines []string
+}
+
+// NewLogBuffer creates a buffer which keeps the last max lines
+func NewLogBuffer(max int) *LogBuffer {
+	return &LogBuffer{max: max}
+}
+
+// Write adds the lines in p to the buffer
+func (b *LogBuffer) Write(p []byte) (n int, err error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.max {
+		b.lines = b.lines[len(b.lines)-b.max:]
+	}
+	return len(p), nil
+}
+
+// Lines returns a copy of the buffered lines, oldest first
+func (b *LogBuffer) Lines() (lines []string) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	lines = make([]string, len(b.lines))
+	copy(lines, b.lines)
+	return
+}
+
+// Tee makes the logger write to w as well as to its current output
+func (l *Logger) Tee(w io.Writer) {
+	if l.w == nil {
+		l.w = w
+	} else {
+		l.w = io.MultiWriter(l.w, w)
+	}
+}
@@ -72,3 +72,16 @@ func TestNewLog(t *testing.T) {
 		So(l._parse("fish", &now), ShouldEqual, now.Format(time.Stamp)+":fish")
 	})
 }
+
+func TestLogBuffer(t *testing.T) {
+	Convey("it should keep the most recent lines", t, func() {
+		b := NewLogBuffer(2)
+		l := Logger{Enabled: true}
+		l.New(nil)
+		l.Tee(b)
+		l.Log("one")
+		l.Log("two")
+		l.Log("three")
+		So(b.Lines(), ShouldResemble, []string{"two", "three"})
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// metrics implements simple named counters for reporting on the activity of a holochain
+
+package holochain
+
+import (
+	"sort"
+	"sync"
+)
+
+// Metrics holds named counters describing the activity and resource usage of a holochain
+// All methods are safe to call on a nil Metrics, in which case they do nothing.
+type Metrics struct {
+	lk     sync.RWMutex
+	values map[string]int64
+}
+
+// NewMetrics creates an empty metrics structure
+func NewMetrics() *Metrics {
+	m := Metrics{values: make(map[string]int64)}
+	return &m
+}
+
+// Inc adds n to the named counter
+func (m *Metrics) Inc(name string, n int64) {
+	if m == nil {
+		return
+	}
+	m.lk.Lock()
+	m.values[name] += n
+	m.lk.Unlock()
+}
+
+// Set sets the named value
+func (m *Metrics) Set(name string, v int64) {
+	if m == nil {
+		return
+	}
+	m.lk.Lock()
+	m.values[name] = v
+	m.lk.Unlock()
+}
+
+// Get returns the named value, or 0 if it has never been set
+func (m *Metrics) Get(name string) (v int64) {
+	if m == nil {
+		return
+	}
+	m.lk.RLock()
+	v = m.values[name]
+	m.lk.RUnlock()
+	return
+}
+
+// Snapshot returns a copy of all the current values
+func (m *Metrics) Snapshot() (s map[string]int64) {
+	s = make(map[string]int64)
+	if m == nil {
+		return
+	}
+	m.lk.RLock()
+	for k, v := range m.values {
+		s[k] = v
+	}
+	m.lk.RUnlock()
+	return
+}
+
+// Names returns the sorted list of metric names
+func (m *Metrics) Names() (names []string) {
+	for k := range m.Snapshot() {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return
+}
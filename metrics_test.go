@@ -0,0 +1,28 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("it should count and set values", t, func() {
+		m := NewMetrics()
+		So(m.Get("calls"), ShouldEqual, 0)
+		m.Inc("calls", 1)
+		m.Inc("calls", 2)
+		So(m.Get("calls"), ShouldEqual, 3)
+		m.Set("dht.bytes", 100)
+		So(m.Get("dht.bytes"), ShouldEqual, 100)
+		So(m.Names(), ShouldResemble, []string{"calls", "dht.bytes"})
+		s := m.Snapshot()
+		So(s["calls"], ShouldEqual, 3)
+	})
+
+	Convey("a nil metrics should be safe to use", t, func() {
+		var m *Metrics
+		m.Inc("calls", 1)
+		So(m.Get("calls"), ShouldEqual, 0)
+		So(len(m.Snapshot()), ShouldEqual, 0)
+	})
+}
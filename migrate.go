@@ -0,0 +1,199 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// migrate implements upgrading the files of a service installed by an older version of hc,
+// so that existing chains keep loading when hc is upgraded.  The format of an installation is
+// recorded in its format file, installations from before there was one being format 0, and
+// loading a service runs each migration to a newer format in turn, recording the format after
+// each so that an interrupted upgrade picks up where it left off.  Installations of a newer
+// format than this hc knows are refused rather than risk damaging them.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatFileName = "format" // records the format of the service's files
+	CurrentFormat  = 1
+
+	legacyStoreExt   = ".db"       // extension of the bolt store chains were kept in before format 1
+	migratedStoreExt = ".migrated" // added to the names of legacy files once they have been migrated
+)
+
+var ErrFormatTooNew error = errors.New("service files were written by a newer version of hc")
+
+// migration upgrades a service's files to a format
+type migration struct {
+	Format      int
+	Description string
+	Run         func(dirs ChainDirs) (notes []string, err error)
+}
+
+// migrations are the upgrades to each format, in order
+var migrations = []migration{
+	{Format: 1, Description: "convert chains kept in bolt stores to chain files", Run: migrateBoltChains},
+}
+
+// ReadFormat returns the format of a service's files, 0 if it predates format files
+func ReadFormat(dirs ChainDirs) (format int, err error) {
+	if !fileExists(filepath.Join(dirs.Config, FormatFileName)) {
+		return
+	}
+	var b []byte
+	if b, err = readFile(dirs.Config, FormatFileName); err != nil {
+		return
+	}
+	if format, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+		err = fmt.Errorf("bad %s file: %v", FormatFileName, err)
+	}
+	return
+}
+
+func writeFormat(dirs ChainDirs, format int) error {
+	return writeFile(dirs.Config, FormatFileName, []byte(strconv.Itoa(format)+"\n"))
+}
+
+// MigrateDirs upgrades the files of a service to the current format, returning a description
+// of each change made
+func MigrateDirs(dirs ChainDirs) (notes []string, err error) {
+	var format int
+	if format, err = ReadFormat(dirs); err != nil {
+		return
+	}
+	if format > CurrentFormat {
+		err = fmt.Errorf("%v: format %d, this hc reads up to format %d", ErrFormatTooNew, format, CurrentFormat)
+		return
+	}
+	for _, m := range migrations {
+		if m.Format <= format {
+			continue
+		}
+		var n []string
+		if n, err = m.Run(dirs); err != nil {
+			err = fmt.Errorf("migrating to format %d (%s): %v", m.Format, m.Description, err)
+			return
+		}
+		notes = append(notes, n...)
+		if err = writeFormat(dirs, m.Format); err != nil {
+			return
+		}
+		format = m.Format
+	}
+	return
+}
+
+// migrateBoltChains converts the chains kept in the bolt stores of early versions to chain
+// files, keeping the stores renamed in case anything needs recovering from them.  The stores
+// were the only state kept differently then: the names of the other files and the gob encoding
+// of headers and entries are unchanged, but the earliest versions kept the DNA hash only in the
+// store's meta data, so it is written out if the chain has no dna.hash file.
+func migrateBoltChains(dirs ChainDirs) (notes []string, err error) {
+	var infos []os.FileInfo
+	if infos, err = ioutil.ReadDir(dirs.Data); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		path := filepath.Join(dirs.Data, info.Name())
+		store := filepath.Join(path, StoreFileName+legacyStoreExt)
+		if !fileExists(store) || fileExists(filepath.Join(path, StoreFileName+".dat")) {
+			continue
+		}
+		var n int
+		var id []byte
+		if n, id, err = convertBoltChain(store, filepath.Join(path, StoreFileName+".dat")); err != nil {
+			err = fmt.Errorf("chain %s: %v", info.Name(), err)
+			return
+		}
+		if id != nil && !fileExists(filepath.Join(path, DNAHashFileName)) {
+			if err = writeFile(path, DNAHashFileName, []byte(Hash{H: id}.String())); err != nil {
+				return
+			}
+			notes = append(notes, fmt.Sprintf("recovered the DNA hash of chain %s from its bolt store", info.Name()))
+		}
+		if err = os.Rename(store, store+migratedStoreExt); err != nil {
+			return
+		}
+		notes = append(notes, fmt.Sprintf("converted the %d entries of chain %s from a bolt store to a chain file", n, info.Name()))
+	}
+	return
+}
+
+// convertBoltChain writes the chain held in a bolt store to a chain file, walking back from
+// its top header to its first, and returns the DNA hash recorded in the store if there is one
+func convertBoltChain(store string, path string) (n int, id []byte, err error) {
+	var p Persister
+	if p, err = CreatePersister(BoltPersisterName, store); err != nil {
+		return
+	}
+	if err = p.Init(); err != nil {
+		return
+	}
+	defer p.Close()
+
+	if id, err = p.GetMeta(IDMetaKey); err != nil {
+		return
+	}
+	var top []byte
+	if top, err = p.GetMeta(TopMetaKey); err != nil {
+		return
+	}
+	var headers []Header
+	var entries []Entry
+	if top != nil {
+		hash := Hash{H: top}
+		for {
+			var header Header
+			var content interface{}
+			if header, content, err = p.Get(hash, true); err != nil {
+				err = fmt.Errorf("reading header %v: %v", hash, err)
+				return
+			}
+			headers = append(headers, header)
+			entries = append(entries, &GobEntry{C: content})
+			if header.HeaderLink.IsNullHash() {
+				break
+			}
+			hash = header.HeaderLink
+		}
+	}
+
+	tmp := path + ".tmp"
+	var f *os.File
+	if f, err = os.Create(tmp); err != nil {
+		return
+	}
+	defer os.Remove(tmp)
+	for i := len(headers) - 1; i >= 0; i-- {
+		if err = writePair(f, &headers[i], entries[i]); err != nil {
+			f.Close()
+			return
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return
+	}
+	n = len(headers)
+	return
+}
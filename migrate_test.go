@@ -0,0 +1,112 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+	dirs := s.Dirs()
+
+	Convey("init should record the current format", t, func() {
+		format, err := ReadFormat(dirs)
+		So(err, ShouldBeNil)
+		So(format, ShouldEqual, CurrentFormat)
+	})
+
+	Convey("installations without a format file should be format 0 and get migrated", t, func() {
+		So(os.Remove(filepath.Join(dirs.Config, FormatFileName)), ShouldBeNil)
+		format, err := ReadFormat(dirs)
+		So(err, ShouldBeNil)
+		So(format, ShouldEqual, 0)
+
+		_, err = LoadServiceDirs(dirs)
+		So(err, ShouldBeNil)
+		format, err = ReadFormat(dirs)
+		So(err, ShouldBeNil)
+		So(format, ShouldEqual, CurrentFormat)
+	})
+
+	Convey("installations of a newer format should be refused", t, func() {
+		So(writeFormat(dirs, CurrentFormat+1), ShouldBeNil)
+		_, err := LoadServiceDirs(dirs)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ErrFormatTooNew.Error())
+	})
+
+	Convey("a bad format file should be an error", t, func() {
+		So(writeFile(dirs.Config, FormatFileName, []byte("one")), ShouldBeNil)
+		_, err := ReadFormat(dirs)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMigrateBoltChains(t *testing.T) {
+	d, s, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dirs := s.Dirs()
+
+	// make the chain as an early version would have kept it
+	store := filepath.Join(h.path, StoreFileName+".db")
+	p, err := CreatePersister(BoltPersisterName, store)
+	if err != nil {
+		panic(err)
+	}
+	if err = p.Init(); err != nil {
+		panic(err)
+	}
+	for i, hd := range h.chain.Headers {
+		hb, err := hd.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		eb, err := h.chain.Entries[i].Marshal()
+		if err != nil {
+			panic(err)
+		}
+		if err = p.Put(hd.Type, h.chain.Hashes[i], hb, hd.EntryLink, eb); err != nil {
+			panic(err)
+		}
+	}
+	if err = p.PutMeta(IDMetaKey, h.dnaHash.H); err != nil {
+		panic(err)
+	}
+	p.Close()
+	if err = os.Remove(filepath.Join(h.path, DNAHashFileName)); err != nil {
+		panic(err)
+	}
+	if err = os.Remove(filepath.Join(h.path, StoreFileName+".dat")); err != nil {
+		panic(err)
+	}
+	if err = os.Remove(filepath.Join(dirs.Config, FormatFileName)); err != nil {
+		panic(err)
+	}
+
+	Convey("migrating should convert the bolt store to a chain file", t, func() {
+		notes, err := MigrateDirs(dirs)
+		So(err, ShouldBeNil)
+		So(len(notes), ShouldEqual, 2)
+		So(fileExists(store), ShouldBeFalse)
+		So(fileExists(store+migratedStoreExt), ShouldBeTrue)
+
+		c, err := NewChainFromFile(h.hashSpec, filepath.Join(h.path, StoreFileName+".dat"))
+		So(err, ShouldBeNil)
+		So(len(c.Headers), ShouldEqual, len(h.chain.Headers))
+		So(c.Hashes[len(c.Hashes)-1].String(), ShouldEqual, h.chain.Hashes[len(h.chain.Hashes)-1].String())
+		So(c.Validate(h.hashSpec), ShouldBeNil)
+
+		b, err := readFile(h.path, DNAHashFileName)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, h.dnaHash.String())
+	})
+
+	Convey("migrating again should do nothing", t, func() {
+		notes, err := MigrateDirs(dirs)
+		So(err, ShouldBeNil)
+		So(len(notes), ShouldEqual, 0)
+	})
+}
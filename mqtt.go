@@ -0,0 +1,254 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// mqtt implements just enough of MQTT 3.1.1 to publish messages to a broker: connecting,
+// publishing at QoS 1 so that the broker acknowledges each message, and disconnecting.  The
+// connection is kept open between messages and reopened if the broker drops it.  Brokers are
+// given as urls: mqtt://[user:password@]host[:port], or mqtts:// to connect over TLS.
+
+package holochain
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublishQoS = 0x32 // publish at QoS 1
+	mqttPuback     = 0x40
+	mqttDisconnect = 0xe0
+
+	mqttTimeout = 10 * time.Second
+)
+
+// parseBroker checks a broker url, returning the address to dial
+func parseBroker(broker string) (u *url.URL, addr string, err error) {
+	if u, err = url.Parse(broker); err != nil {
+		return
+	}
+	var port string
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		port = "1883"
+	case "mqtts", "ssl", "tls":
+		port = "8883"
+	default:
+		err = fmt.Errorf("unknown MQTT broker scheme: %s", u.Scheme)
+		return
+	}
+	if u.Host == "" {
+		err = fmt.Errorf("MQTT broker url has no host: %s", broker)
+		return
+	}
+	addr = u.Host
+	if _, _, e := net.SplitHostPort(addr); e != nil {
+		addr = net.JoinHostPort(addr, port)
+	}
+	return
+}
+
+// mqttString encodes a string as MQTT does, prefixed by its length
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket returns a packet of the given type with its remaining length encoded
+func mqttPacket(kind byte, body []byte) []byte {
+	p := []byte{kind}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+// readMQTTPacket reads a packet, returning its type and body
+func readMQTTPacket(r *bufio.Reader) (kind byte, body []byte, err error) {
+	if kind, err = r.ReadByte(); err != nil {
+		return
+	}
+	var n, shift uint
+	for {
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return
+		}
+		n |= uint(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			err = errors.New("malformed MQTT packet length")
+			return
+		}
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return
+}
+
+// mqttClient is a connection to a broker kept open between publishes, reconnecting when the
+// connection has been lost
+type mqttClient struct {
+	lk       sync.Mutex
+	broker   string
+	clientID string
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+}
+
+func newMQTTClient(broker string, clientID string) *mqttClient {
+	return &mqttClient{broker: broker, clientID: clientID}
+}
+
+// connect dials the broker and sends the connect packet, without a keep alive so that the
+// broker keeps the connection open between publishes however far apart they are
+func (c *mqttClient) connect() (err error) {
+	u, addr, err := parseBroker(c.broker)
+	if err != nil {
+		return
+	}
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	if u.Scheme == "mqtt" || u.Scheme == "tcp" {
+		conn, err = dialer.Dial("tcp", addr)
+	} else {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	r := bufio.NewReader(conn)
+
+	flags := byte(0x02) // clean session
+	var creds []byte
+	if u.User != nil {
+		flags |= 0x80
+		creds = append(creds, mqttString(u.User.Username())...)
+		if pw, ok := u.User.Password(); ok {
+			flags |= 0x40
+			creds = append(creds, mqttString(pw)...)
+		}
+	}
+	connect := append(mqttString("MQTT"), 4, flags, 0, 0)
+	connect = append(connect, mqttString(c.clientID)...)
+	connect = append(connect, creds...)
+	if _, err = conn.Write(mqttPacket(mqttConnect, connect)); err != nil {
+		return
+	}
+	kind, body, err := readMQTTPacket(r)
+	if err != nil {
+		return
+	}
+	if kind != mqttConnack || len(body) != 2 {
+		err = fmt.Errorf("expected MQTT connack, got packet type %x", kind)
+		return
+	}
+	if body[1] != 0 {
+		err = fmt.Errorf("MQTT broker refused connection with code %d", body[1])
+		return
+	}
+	c.conn, c.r = conn, r
+	return
+}
+
+// send publishes a message on the open connection, returning once the broker has
+// acknowledged it
+func (c *mqttClient) send(topic string, payload []byte) (err error) {
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	c.conn.SetDeadline(time.Now().Add(mqttTimeout))
+	id := make([]byte, 2)
+	binary.BigEndian.PutUint16(id, c.packetID)
+	publish := append(mqttString(topic), id...)
+	publish = append(publish, payload...)
+	if _, err = c.conn.Write(mqttPacket(mqttPublishQoS, publish)); err != nil {
+		return
+	}
+	kind, body, err := readMQTTPacket(c.r)
+	if err != nil {
+		return
+	}
+	if kind != mqttPuback || len(body) != 2 || binary.BigEndian.Uint16(body) != c.packetID {
+		err = fmt.Errorf("expected MQTT puback, got packet type %x", kind)
+	}
+	return
+}
+
+// close drops the connection, so that the next publish reconnects
+func (c *mqttClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+}
+
+// publish publishes a message to a topic, connecting to the broker if there is no open
+// connection.  If publishing on a connection opened earlier fails, e.g. because the broker
+// dropped it, it is retried once on a new connection.
+func (c *mqttClient) publish(topic string, payload []byte) (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	reused := c.conn != nil
+	if !reused {
+		if err = c.connect(); err != nil {
+			return
+		}
+	}
+	if err = c.send(topic, payload); err == nil {
+		return
+	}
+	c.close()
+	if !reused {
+		return
+	}
+	if err = c.connect(); err != nil {
+		return
+	}
+	if err = c.send(topic, payload); err != nil {
+		c.close()
+	}
+	return
+}
+
+// Close disconnects from the broker
+func (c *mqttClient) Close() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.conn != nil {
+		c.conn.SetDeadline(time.Now().Add(mqttTimeout))
+		c.conn.Write(mqttPacket(mqttDisconnect, nil))
+		c.close()
+	}
+}
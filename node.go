@@ -2,7 +2,7 @@
 // Use of this source code is governed by GPLv3 found in the LICENSE file
 //----------------------------------------------------------------------------------------
 
-// node implements ipfs network transport for communicating between holochain nodes
+// node implements the messaging used for communicating between holochain nodes over a Transport
 
 package holochain
 
@@ -13,12 +13,8 @@ import (
 	"errors"
 	"fmt"
 	ic "github.com/libp2p/go-libp2p-crypto"
-	net "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
-	pstore "github.com/libp2p/go-libp2p-peerstore"
 	protocol "github.com/libp2p/go-libp2p-protocol"
-	swarm "github.com/libp2p/go-libp2p-swarm"
-	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	rhost "github.com/libp2p/go-libp2p/p2p/host/routed"
 	ma "github.com/multiformats/go-multiaddr"
 	"io"
@@ -47,6 +43,34 @@ const (
 	// Source Messages
 
 	SRC_VALIDATE
+	SRC_HEADER
+	SRC_DNA
+
+	// DHT messages added since the source messages
+
+	SNAPSHOT_REQUEST
+	HEARTBEAT
+
+	// Handshake messages
+
+	HANDSHAKE
+
+	// DHT messages added since the handshake
+
+	HOLD_REQUEST
+
+	// DHT messages added since holding
+
+	RANGE_TRANSFER
+
+	// DHT messages added since range transfers
+
+	TIME_ATTEST_REQUEST
+
+	// DHT messages added since time attestations
+
+	COUNTERSIGN_REQUEST
+	COUNTERSIGN_COMPLETE
 )
 
 // Message represents data that can be sent to node in the network
@@ -55,13 +79,22 @@ type Message struct {
 	Time time.Time
 	From peer.ID
 	Body interface{}
+
+	handedOffBy peer.ID // for puts queued from a range transfer, the node that handed them off
 }
 
 // Node represents a node in the network
 type Node struct {
-	HashAddr peer.ID
-	NetAddr  ma.Multiaddr
-	Host     *rhost.RoutedHost
+	HashAddr  peer.ID
+	NetAddr   ma.Multiaddr
+	Transport Transport
+	Host      *rhost.RoutedHost // only set when using the libp2p transport
+	Hello     *Handshake        // protocol advertised to peers, the defaults if nil
+
+	protocols  peerProtocols
+	topics     []string // topics of the app the node holds, all if empty, advertised in its handshake
+	maxMessage int64    // largest message read from another node, DefaultMaxMessageSize if 0
+	metrics    *Metrics // if set, counts the bytes sent and received
 }
 
 const (
@@ -69,24 +102,24 @@ const (
 	SourceProtocol = protocol.ID("/holochain-src/0.0.0")
 )
 
-type HolochainRouter struct {
-	dummy int
-}
-
-func (r *HolochainRouter) FindPeer(context.Context, peer.ID) (peer pstore.PeerInfo, err error) {
-	err = errors.New("routing not implemented")
-	return
+// NewNode creates a new node with given identity using the default libp2p transport
+func NewNode(listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err error) {
+	return NewNodeWithTransport(TCPTransportName, listenAddr, id, priv)
 }
 
-// NewNode creates a new ipfs basichost node with given identity
-func NewNode(listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err error) {
+// NewNodeWithTransport creates a new node with given identity using the named transport
+func NewNodeWithTransport(transport string, listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err error) {
+	t, ok := transports[transport]
+	if !ok {
+		err = fmt.Errorf("unknown transport: %s", transport)
+		return
+	}
 	var n Node
 	n.NetAddr, err = ma.NewMultiaddr(listenAddr)
 	if err != nil {
 		return
 	}
 
-	ps := pstore.NewPeerstore()
 	pid, err := peer.IDFromPrivateKey(priv)
 	if err != nil {
 		return
@@ -98,24 +131,13 @@ func NewNode(listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err er
 	}
 
 	n.HashAddr = pid
-	ps.AddPrivKey(pid, priv)
-	ps.AddPubKey(pid, priv.GetPublic())
-
-	ctx := context.Background()
-
-	// create a new swarm to be used by the service host
-	netw, err := swarm.NewNetwork(ctx, []ma.Multiaddr{n.NetAddr}, pid, ps, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var bh *bhost.BasicHost
-	bh, err = bhost.New(netw), nil
+	n.Transport, err = t.factory(n.NetAddr, pid, priv)
 	if err != nil {
 		return
 	}
-	hr := HolochainRouter{}
-	n.Host = rhost.Wrap(bh, &hr)
+	if lt, ok := n.Transport.(*LibP2PTransport); ok {
+		n.Host = lt.Host
+	}
 
 	node = &n
 	return
@@ -139,8 +161,22 @@ func (m *Message) Decode(r io.Reader) (err error) {
 	return
 }
 
+// decode reads a message from a stream, failing with ErrMessageTooLarge once it has read more
+// than the node's largest message
+func (node *Node) decode(m *Message, s io.Reader) (err error) {
+	max := node.maxMessage
+	if max == 0 {
+		max = DefaultMaxMessageSize
+	}
+	l := &limitedReader{r: s, n: max}
+	if err = m.Decode(l); err != nil && l.n <= 0 {
+		err = ErrMessageTooLarge
+	}
+	return
+}
+
 // respondWith writes a message either error or otherwise, to the stream
-func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
+func (node *Node) respondWith(s Stream, err error, body interface{}) {
 	var m *Message
 	if err != nil {
 		m = node.NewMessage(ERROR_RESPONSE, err.Error())
@@ -158,19 +194,48 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 	}
 }
 
+// countingStream counts the bytes read from and written to a stream in the node's metrics
+type countingStream struct {
+	Stream
+	metrics *Metrics
+}
+
+func (s *countingStream) Read(p []byte) (n int, err error) {
+	n, err = s.Stream.Read(p)
+	s.metrics.Inc("net.in", int64(n))
+	return
+}
+
+func (s *countingStream) Write(p []byte) (n int, err error) {
+	n, err = s.Stream.Write(p)
+	s.metrics.Inc("net.out", int64(n))
+	return
+}
+
+// counted wraps a stream to count its bytes if the node has metrics
+func (node *Node) counted(s Stream) Stream {
+	if node.metrics == nil {
+		return s
+	}
+	return &countingStream{Stream: s, metrics: node.metrics}
+}
+
 // StartProtocol initiates listening for a protocol on the node
 func (node *Node) StartProtocol(h *Holochain, proto protocol.ID, receiver ReceiverFn) (err error) {
-	node.Host.SetStreamHandler(proto, func(s net.Stream) {
+	node.Transport.SetStreamHandler(proto, func(s Stream) {
+		as, authenticated := s.(AuthenticatedStream)
+		s = node.counted(s)
 		var m Message
-		err := m.Decode(s)
+		err := node.decode(&m, s)
 		var response interface{}
 		if m.From == "" {
-			// @todo other sanity checks on From?
 			err = errors.New("message must have a source")
-		} else {
-			if err == nil {
-				response, err = receiver(h, &m)
-			}
+		} else if !authenticated {
+			err = ErrStreamNotAuthenticated
+		} else if m.From != as.RemotePeer() {
+			err = ErrMessageSourceMismatch
+		} else if err == nil {
+			response, err = receiver(h, &m)
 		}
 		node.respondWith(s, err, response)
 	})
@@ -178,8 +243,15 @@ func (node *Node) StartProtocol(h *Holochain, proto protocol.ID, receiver Receiv
 }
 
 type ValidateResponse struct {
-	Entry Entry
-	Type  string
+	Entry    Entry
+	Type     string
+	Join     *JoinProof         // the source's solution to the join puzzle, if the DNA declares one
+	Identity *IdentityAssertion // the source's attested identity, if the DNA requires one
+	Header   *Header            // the entry's header, whose time is checked against the maximum clock skew
+	PubKey   []byte             // the source's marshaled public key, to check the header's signature with
+	Relay    *RelayedEntry      // for entries the source relayed for a thin client, the client's signed entry
+
+	TimeAttestations []TimeAttestation // gossip partners' attestations of the header's time
 }
 
 // SrcReceiver handles messages on the Source protocol
@@ -195,11 +267,53 @@ func SrcReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 			if err == ErrHashNotFound {
 				// if that fails get it from the entries
 				r.Entry, r.Type, err = h.chain.GetEntry(t)
+				if hd, e := h.chain.GetEntryHeader(t); e == nil {
+					r.Header = hd
+					r.PubKey, err = ic.MarshalPublicKey(h.agent.PubKey())
+					if hash, _, e := hd.Sum(h.hashSpec); e == nil && err == nil {
+						r.TimeAttestations, err = h.TimeAttestations(hash)
+					}
+				} else if relayed, e := h.dht.getRelayed(t); e == nil {
+					r.Entry, r.Type, err = &GobEntry{C: relayed.Entry}, relayed.Type, nil
+					r.Relay = relayed
+				}
+				if r.Relay != nil {
+					// relayed entries are vouched for by their client, not the relay
+					r.Join, r.Identity = r.Relay.Join, r.Relay.Identity
+				} else {
+					if h.JoinPuzzle != nil {
+						r.Join = h.agentJoinProof()
+					}
+					if h.Identity != nil {
+						r.Identity = h.agentIdentity()
+					}
+				}
 				response = &r
 			}
 		default:
 			err = errors.New("expected hash")
 		}
+	case SRC_HEADER:
+		switch t := m.Body.(type) {
+		case Hash:
+			var sh SourceHeader
+			var hd *Header
+			if hd, err = h.chain.GetEntryHeader(t); err != nil {
+				return
+			}
+			if sh.PubKey, err = ic.MarshalPublicKey(h.agent.PubKey()); err == nil {
+				sh.Header = *hd
+				response = &sh
+			}
+		default:
+			err = errors.New("expected hash")
+		}
+	case SRC_DNA:
+		var b *DNABundle
+		b, err = h.SignedBundle()
+		if err == nil {
+			response = *b
+		}
 	default:
 		err = fmt.Errorf("message type %d not in holochain-src protocol", int(m.Type))
 	}
@@ -213,11 +327,16 @@ func (node *Node) StartSrc(h *Holochain) (err error) {
 
 // Close shuts down the node
 func (node *Node) Close() error {
-	return node.Host.Close()
+	return node.Transport.Close()
 }
 
 // Send builds a message and either delivers it locally or via node.Send
 func (h *Holochain) Send(proto protocol.ID, to peer.ID, t MsgType, body interface{}, receiver ReceiverFn) (response interface{}, err error) {
+	return h.sendContext(context.Background(), proto, to, t, body, receiver)
+}
+
+// sendContext sends a message like Send, giving up on a remote node once ctx is done
+func (h *Holochain) sendContext(ctx context.Context, proto protocol.ID, to peer.ID, t MsgType, body interface{}, receiver ReceiverFn) (response interface{}, err error) {
 	message := h.node.NewMessage(t, body)
 	if err != nil {
 		return
@@ -227,9 +346,16 @@ func (h *Holochain) Send(proto protocol.ID, to peer.ID, t MsgType, body interfac
 	if to == h.node.HashAddr {
 		response, err = receiver(h, message)
 	} else {
+		if proto != HandshakeProtocol {
+			if _, err = h.node.PeerProtocol(to); err != nil {
+				return
+			}
+		}
 		var r Message
-		r, err = h.node.Send(proto, to, message)
+		r, err = h.node.SendContext(ctx, proto, to, message)
 		if err != nil {
+			// the connection is gone, so negotiate again on the next one
+			h.node.protocols.forget(to)
 			return
 		}
 		if r.Type == ERROR_RESPONSE {
@@ -243,11 +369,39 @@ func (h *Holochain) Send(proto protocol.ID, to peer.ID, t MsgType, body interfac
 
 // Send delivers a message to a node via the given protocol
 func (node *Node) Send(proto protocol.ID, addr peer.ID, m *Message) (response Message, err error) {
-	s, err := node.Host.NewStream(context.Background(), addr, proto)
+	return node.SendContext(context.Background(), proto, addr, m)
+}
+
+// SendContext delivers a message like Send, closing the stream if ctx is done before the
+// response arrives
+func (node *Node) SendContext(ctx context.Context, proto protocol.ID, addr peer.ID, m *Message) (response Message, err error) {
+	s, err := node.Transport.NewStream(ctx, addr, proto)
 	if err != nil {
 		return
 	}
 	defer s.Close()
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.Close()
+			case <-done:
+			}
+		}()
+		defer func() {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+		}()
+	}
+	as, authenticated := s.(AuthenticatedStream)
+	if !authenticated {
+		err = ErrStreamNotAuthenticated
+		return
+	}
+	s = node.counted(s)
 
 	// encode the message and send it
 	data, err := m.Encode()
@@ -264,10 +418,13 @@ func (node *Node) Send(proto protocol.ID, addr peer.ID, m *Message) (response Me
 	}
 
 	// decode the response
-	err = response.Decode(s)
+	err = node.decode(&response, s)
 	if err != nil {
 		return
 	}
+	if response.From != as.RemotePeer() {
+		err = ErrMessageSourceMismatch
+	}
 	return
 }
 
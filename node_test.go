@@ -195,6 +195,26 @@ func TestSrcReceiver(t *testing.T) {
 		So(fmt.Sprintf("%v", r.(*ValidateResponse).Entry), ShouldEqual, fmt.Sprintf("%v", &entry))
 
 	})
+	Convey("SRC_HEADER should return the header of an entry", t, func() {
+		entry := GobEntry{C: "more bogus entry data"}
+		_, hd, _ := h.NewEntry(time.Now(), "myData", &entry)
+		m := h.node.NewMessage(SRC_HEADER, hd.EntryLink)
+		r, err := SrcReceiver(h, m)
+		So(err, ShouldBeNil)
+		sh := r.(*SourceHeader)
+		So(fmt.Sprintf("%v", &sh.Header), ShouldEqual, fmt.Sprintf("%v", hd))
+		So(sh.check(h.id, hd.EntryLink), ShouldBeNil)
+		other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		So(sh.check(h.id, other), ShouldNotBeNil)
+		bad := *sh
+		bad.Header.Sig.S = append([]byte{}, sh.Header.Sig.S...)
+		bad.Header.Sig.S[0] ^= 0xff
+		So(bad.check(h.id, hd.EntryLink), ShouldNotBeNil)
+
+		m = h.node.NewMessage(SRC_HEADER, "fish")
+		_, err = SrcReceiver(h, m)
+		So(err.Error(), ShouldEqual, "expected hash")
+	})
 }
 
 /*
@@ -0,0 +1,665 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// notary implements periodically anchoring the head of a chain in a system outside of it:
+// an OpenTimestamps calendar, an https notary, or another holochain.  The proof the notary
+// gives back is committed to the chain, so if the chain's history is later rewritten the
+// notarized heads no longer match and the tampering can be shown to anyone holding a proof.
+// As the notarizations are themselves in the chain, checking them asks the notary to confirm
+// each proof rather than trusting the chain's own record of it.
+
+package holochain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NotarizationEntryType  = "%notarization"
+	NotaryWitnessEntryType = "%witness" // committed on a holochain notary's chain
+
+	DefaultNotarizeInterval = time.Hour
+
+	// kinds of notary
+	NotaryHTTPS          = "https"          // posts the statement as json, keeping the response as the proof
+	NotaryOpenTimestamps = "opentimestamps" // submits the statement's digest to a calendar server
+	NotaryHolochain      = "holochain"      // commits the statement to another chain run by the same service
+
+	DefaultOpenTimestampsCalendar = "https://alice.btc.calendar.opentimestamps.org"
+
+	notaryTimeout  = 30 * time.Second
+	maxNotaryReply = 1024 * 1024
+)
+
+var ErrNotNotarized error = errors.New("chain has no notary configured")
+var ErrNothingToNotarize error = errors.New("chain head is already notarized")
+var ErrBadNotaryProof error = errors.New("notary proof doesn't verify")
+
+// NotaryConfig configures where a chain's head is notarized
+type NotaryConfig struct {
+	Kind     string
+	URL      string `toml:",omitempty"` // the notary's url, or for opentimestamps the calendar's
+	Chain    string `toml:",omitempty"` // for holochain notaries the name of the chain witnessing
+	Key      string `toml:",omitempty"` // for https notaries the b58 encoded public key their receipts are signed with
+	Interval string `toml:",omitempty"` // how often to notarize, DefaultNotarizeInterval if not set
+}
+
+// IntervalDuration returns how often the chain should be notarized
+func (c *NotaryConfig) IntervalDuration() (interval time.Duration, err error) {
+	if c.Interval == "" {
+		interval = DefaultNotarizeInterval
+		return
+	}
+	interval, err = time.ParseDuration(c.Interval)
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("notarize interval must be positive, got: %s", c.Interval)
+	}
+	return
+}
+
+// where returns the url or chain of the notary, as recorded in its notarizations
+func (c *NotaryConfig) where() string {
+	if c.Kind == NotaryHolochain {
+		return c.Chain
+	}
+	return c.URL
+}
+
+// Check confirms that the notary is one that is known and is configured well enough to use
+func (c *NotaryConfig) Check() (err error) {
+	if _, err = NewNotary(c); err != nil {
+		return
+	}
+	_, err = c.IntervalDuration()
+	return
+}
+
+// NotaryStatement is what a notary is asked to vouch for: that a chain's head was a
+// particular header no later than a time
+type NotaryStatement struct {
+	DNA   string // hash of the chain's DNA
+	Agent string // node id of the chain's agent
+	Head  string // hash of the chain's top header
+	Index int    // the top header's position in the chain
+	Time  time.Time
+}
+
+// Digest returns the hash of the statement that notaries which only take digests timestamp
+func (s *NotaryStatement) Digest() []byte {
+	b, _ := json.Marshal(s)
+	d := sha256.Sum256(b)
+	return d[:]
+}
+
+// Notarization is a notary's proof of a statement, as committed to the chain
+type Notarization struct {
+	Statement NotaryStatement
+	Notary    string // the kind of notary
+	Where     string // the url or chain of the notary
+	Proof     string // base64 encoded proof from the notary
+}
+
+// Notary vouches for statements of chain heads, and confirms the proofs it gave of them
+type Notary interface {
+	Notarize(h *Holochain, s *NotaryStatement) (proof []byte, err error)
+	Verify(h *Holochain, s *NotaryStatement, proof []byte) (err error)
+}
+
+// NotaryFactory makes a notary from its configuration
+type NotaryFactory func(c *NotaryConfig) (Notary, error)
+
+var notaries = struct {
+	lk        sync.Mutex
+	factories map[string]NotaryFactory
+}{factories: make(map[string]NotaryFactory)}
+
+// RegisterNotary makes a kind of notary available for chains to be configured to use
+func RegisterNotary(kind string, factory NotaryFactory) {
+	notaries.lk.Lock()
+	defer notaries.lk.Unlock()
+	notaries.factories[kind] = factory
+}
+
+// NewNotary makes the notary configured
+func NewNotary(c *NotaryConfig) (n Notary, err error) {
+	notaries.lk.Lock()
+	factory := notaries.factories[c.Kind]
+	notaries.lk.Unlock()
+	if factory == nil {
+		err = fmt.Errorf("unknown notary: %s", c.Kind)
+		return
+	}
+	n, err = factory(c)
+	return
+}
+
+func init() {
+	RegisterNotary(NotaryHTTPS, func(c *NotaryConfig) (Notary, error) {
+		if !strings.HasPrefix(c.URL, "https://") && !strings.HasPrefix(c.URL, "http://") {
+			return nil, fmt.Errorf("https notary needs an http(s) url, got: %s", c.URL)
+		}
+		if c.Key == "" {
+			return nil, errors.New("https notary needs the key its receipts are signed with")
+		}
+		key, err := ic.UnmarshalPublicKey(b58.Decode(c.Key))
+		if err != nil {
+			return nil, fmt.Errorf("bad https notary key: %v", err)
+		}
+		return &httpsNotary{url: c.URL, key: key}, nil
+	})
+	RegisterNotary(NotaryOpenTimestamps, func(c *NotaryConfig) (Notary, error) {
+		u := c.URL
+		if u == "" {
+			u = DefaultOpenTimestampsCalendar
+		}
+		return &otsNotary{calendar: strings.TrimSuffix(u, "/")}, nil
+	})
+	RegisterNotary(NotaryHolochain, func(c *NotaryConfig) (Notary, error) {
+		if c.Chain == "" {
+			return nil, errors.New("holochain notary needs the chain to witness with")
+		}
+		return &holochainNotary{chain: c.Chain}, nil
+	})
+}
+
+// notaryClient is used for all requests to notaries, so that one that doesn't answer can't
+// hold up notarizing or checking forever
+var notaryClient = &http.Client{Timeout: notaryTimeout}
+
+// notaryPost posts a body to a notary and returns its response
+func notaryPost(u string, contentType string, body []byte) (response []byte, err error) {
+	var resp *http.Response
+	resp, err = notaryClient.Post(u, contentType, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("notary %s: %s", u, resp.Status)
+		return
+	}
+	response, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxNotaryReply))
+	return
+}
+
+// httpsNotary posts statements to a notary that responds with a receipt, which is JSON holding
+// the hex encoded digest of the statement and the notary's b58 encoded signature of it
+type httpsNotary struct {
+	url string
+	key ic.PubKey
+}
+
+// NotaryReceipt is what an https notary responds with
+type NotaryReceipt struct {
+	Digest    string
+	Signature string
+}
+
+func (n *httpsNotary) Notarize(h *Holochain, s *NotaryStatement) (proof []byte, err error) {
+	var b []byte
+	if b, err = json.Marshal(s); err != nil {
+		return
+	}
+	if proof, err = notaryPost(n.url, "application/json", b); err != nil {
+		return
+	}
+	err = n.Verify(h, s, proof)
+	return
+}
+
+func (n *httpsNotary) Verify(h *Holochain, s *NotaryStatement, proof []byte) (err error) {
+	var r NotaryReceipt
+	if err = json.Unmarshal(proof, &r); err != nil {
+		err = fmt.Errorf("%v: receipt isn't json: %v", ErrBadNotaryProof, err)
+		return
+	}
+	digest := s.Digest()
+	if r.Digest != hex.EncodeToString(digest) {
+		err = fmt.Errorf("%v: receipt is of another statement", ErrBadNotaryProof)
+		return
+	}
+	var ok bool
+	if ok, err = n.key.Verify(digest, b58.Decode(r.Signature)); err == nil && !ok {
+		err = fmt.Errorf("%v: receipt isn't signed by the notary", ErrBadNotaryProof)
+	}
+	return
+}
+
+// otsNotary submits statement digests to an OpenTimestamps calendar, which responds with an
+// incomplete timestamp that the ots tools can upgrade once the calendar has anchored it
+type otsNotary struct {
+	calendar string
+}
+
+func (n *otsNotary) Notarize(h *Holochain, s *NotaryStatement) (proof []byte, err error) {
+	if proof, err = notaryPost(n.calendar+"/digest", "application/x-www-form-urlencoded", s.Digest()); err != nil {
+		return
+	}
+	_, err = otsPending(s.Digest(), proof, n.calendar)
+	return
+}
+
+// Verify replays the timestamp's operations on the statement's digest, and asks the calendar
+// whether it holds the commitment they lead to
+func (n *otsNotary) Verify(h *Holochain, s *NotaryStatement, proof []byte) (err error) {
+	var commitments [][]byte
+	if commitments, err = otsPending(s.Digest(), proof, n.calendar); err != nil {
+		return
+	}
+	for _, c := range commitments {
+		u := n.calendar + "/timestamp/" + hex.EncodeToString(c)
+		var resp *http.Response
+		if resp, err = notaryClient.Get(u); err != nil {
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return
+		}
+	}
+	err = fmt.Errorf("%v: calendar %s doesn't hold the timestamp", ErrBadNotaryProof, n.calendar)
+	return
+}
+
+const (
+	otsAttestation = 0x00
+	otsSHA256      = 0x08
+	otsAppend      = 0xf0
+	otsPrepend     = 0xf1
+	otsFork        = 0xff
+)
+
+// otsPendingTag marks an attestation that a calendar will anchor the commitment
+var otsPendingTag = []byte{0x83, 0xdf, 0xe3, 0x0d, 0x2e, 0xf9, 0x0c, 0x8e}
+
+// otsPending replays the operations of an OpenTimestamps timestamp of msg, returning the
+// commitments that the calendar has attested it will anchor
+func otsPending(msg []byte, proof []byte, calendar string) (commitments [][]byte, err error) {
+	r := bytes.NewReader(proof)
+	var walk func(msg []byte) error
+	readBytes := func() (b []byte, err error) {
+		var l uint64
+		if l, err = binary.ReadUvarint(r); err != nil {
+			return
+		}
+		if l > uint64(r.Len()) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		b = make([]byte, l)
+		_, err = io.ReadFull(r, b)
+		return
+	}
+	step := func(op byte, msg []byte) (err error) {
+		switch op {
+		case otsAttestation:
+			tag := make([]byte, len(otsPendingTag))
+			if _, err = io.ReadFull(r, tag); err != nil {
+				return
+			}
+			var payload []byte
+			if payload, err = readBytes(); err != nil {
+				return
+			}
+			if bytes.Equal(tag, otsPendingTag) {
+				p := bytes.NewReader(payload)
+				var l uint64
+				if l, err = binary.ReadUvarint(p); err != nil || l != uint64(p.Len()) {
+					return errors.New("bad pending attestation")
+				}
+				if uri := string(payload[len(payload)-int(l):]); strings.TrimSuffix(uri, "/") == calendar {
+					commitments = append(commitments, msg)
+				}
+			}
+			return
+		case otsSHA256:
+			d := sha256.Sum256(msg)
+			msg = d[:]
+		case otsAppend, otsPrepend:
+			var arg []byte
+			if arg, err = readBytes(); err != nil {
+				return
+			}
+			if op == otsAppend {
+				msg = append(append([]byte{}, msg...), arg...)
+			} else {
+				msg = append(append([]byte{}, arg...), msg...)
+			}
+		default:
+			return fmt.Errorf("unsupported timestamp operation %#x", op)
+		}
+		return walk(msg)
+	}
+	walk = func(msg []byte) (err error) {
+		for {
+			var op byte
+			if op, err = r.ReadByte(); err != nil {
+				return
+			}
+			if op != otsFork {
+				return step(op, msg)
+			}
+			if op, err = r.ReadByte(); err != nil {
+				return
+			}
+			if err = step(op, msg); err != nil {
+				return
+			}
+		}
+	}
+	if err = walk(msg); err == nil && r.Len() != 0 {
+		err = errors.New("trailing data")
+	}
+	if err == nil && len(commitments) == 0 {
+		err = errors.New("no pending attestation by the calendar")
+	}
+	if err != nil {
+		err = fmt.Errorf("%v: %v", ErrBadNotaryProof, err)
+	}
+	return
+}
+
+// holochainNotary has another chain run by the same service commit statements, asking its
+// node over the bridge
+type holochainNotary struct {
+	chain string
+}
+
+// bridgeWitnessReply is what a witnessing chain sends back for a statement it committed
+type bridgeWitnessReply struct {
+	Hash string
+}
+
+// bridgeWitnessedReply is what a witnessing chain sends back for a statement it holds
+type bridgeWitnessedReply struct {
+	Statement NotaryStatement
+	Time      time.Time // of the witness entry's header
+}
+
+func (n *holochainNotary) Notarize(h *Holochain, s *NotaryStatement) (proof []byte, err error) {
+	var r bridgeWitnessReply
+	if err = h.bridgeRequest(n.chain, "/_bridge/witness", s, &r); err != nil {
+		return
+	}
+	proof = []byte(r.Hash)
+	return
+}
+
+func (n *holochainNotary) Verify(h *Holochain, s *NotaryStatement, proof []byte) (err error) {
+	var hash Hash
+	if hash, err = NewHash(string(proof)); err != nil {
+		return
+	}
+	var r bridgeWitnessedReply
+	if err = h.bridgeRequest(n.chain, "/_bridge/witness/"+hash.String(), nil, &r); err != nil {
+		err = fmt.Errorf("witness %s not found on %s: %v", hash, n.chain, err)
+		return
+	}
+	if !bytes.Equal(r.Statement.Digest(), s.Digest()) {
+		err = fmt.Errorf("%v: %s witnessed another statement", ErrBadNotaryProof, n.chain)
+	}
+	return
+}
+
+// bridgedWitness commits a statement for a chain that has been granted the witnessing of them
+func (h *Holochain) bridgedWitness(reader BridgeReader, s *NotaryStatement) (hash Hash, err error) {
+	g := h.bridgeGrant(reader)
+	if g == nil || !g.allows(NotaryWitnessEntryType) {
+		err = ErrBridgeNotGranted
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(s); err != nil {
+		return
+	}
+	if hash, err = h.Commit(NotaryWitnessEntryType, string(b)); err != nil {
+		return
+	}
+	if h.dht != nil && h.node != nil {
+		if e := h.dht.SendPut(hash); e != nil {
+			h.dht.dlog.Logf("unable to publish witness: %v", e)
+		}
+	}
+	return
+}
+
+// bridgedWitnessed returns a statement this chain witnessed for a chain granted the witnessing
+func (h *Holochain) bridgedWitnessed(reader BridgeReader, hash Hash) (r *bridgeWitnessedReply, err error) {
+	g := h.bridgeGrant(reader)
+	if g == nil || !g.allows(NotaryWitnessEntryType) {
+		err = ErrBridgeNotGranted
+		return
+	}
+	var header *Header
+	var entry Entry
+	if header, _, entry, err = h.chain.getCommitted(hash); err != nil {
+		return
+	}
+	if header.Type != NotaryWitnessEntryType {
+		err = ErrHashNotFound
+		return
+	}
+	r = &bridgeWitnessedReply{Time: header.Time}
+	c, _ := entry.Content().(string)
+	err = json.Unmarshal([]byte(c), &r.Statement)
+	return
+}
+
+// notaryStatement returns the statement of the chain's current head
+func (h *Holochain) notaryStatement() (s *NotaryStatement, err error) {
+	l := len(h.chain.Headers)
+	if l == 0 {
+		err = ErrChainNotStarted
+		return
+	}
+	s = &NotaryStatement{
+		DNA:   h.dnaHash.String(),
+		Agent: peer.IDB58Encode(h.id),
+		Head:  h.chain.Hashes[l-1].String(),
+		Index: l - 1,
+		Time:  time.Now().UTC(),
+	}
+	return
+}
+
+// Notarize has the configured notary vouch for the chain's current head, and commits its
+// proof.  Heads that are themselves notarizations aren't notarized again.
+func (h *Holochain) Notarize() (hash Hash, n *Notarization, err error) {
+	c := h.Config().Notary
+	if c == nil {
+		err = ErrNotNotarized
+		return
+	}
+	if top := h.chain.Top(); top != nil && top.Type == NotarizationEntryType {
+		err = ErrNothingToNotarize
+		return
+	}
+	var notary Notary
+	if notary, err = NewNotary(c); err != nil {
+		return
+	}
+	var s *NotaryStatement
+	if s, err = h.notaryStatement(); err != nil {
+		return
+	}
+	var proof []byte
+	if proof, err = notary.Notarize(h, s); err != nil {
+		return
+	}
+	n = &Notarization{Statement: *s, Notary: c.Kind, Where: c.where(), Proof: base64.StdEncoding.EncodeToString(proof)}
+	var b []byte
+	if b, err = json.Marshal(n); err != nil {
+		return
+	}
+	if hash, err = h.Commit(NotarizationEntryType, string(b)); err != nil {
+		return
+	}
+	err = h.dht.SendPut(hash)
+	return
+}
+
+// NotarizeEvery notarizes the chain's head every interval, if it has changed
+func (h *Holochain) NotarizeEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, _, err := h.Notarize(); err != nil && err != ErrNothingToNotarize {
+			h.dht.dlog.Logf("notarization error: %v", err)
+		}
+	}
+}
+
+// parseNotarization decodes a notarization entry
+func parseNotarization(entry Entry) (n *Notarization, err error) {
+	s, ok := entry.Content().(string)
+	if !ok {
+		err = errors.New("notarization entry should be a string")
+		return
+	}
+	n = &Notarization{}
+	err = json.Unmarshal([]byte(s), n)
+	return
+}
+
+// check confirms the statement names what a notary needs to vouch for
+func (s *NotaryStatement) check() (err error) {
+	if s.DNA == "" || s.Agent == "" || s.Head == "" || s.Time.IsZero() {
+		err = errors.New("notary statement must name the DNA, agent, head and time")
+		return
+	}
+	if _, err = NewHash(s.Head); err != nil {
+		err = fmt.Errorf("bad notarized head: %v", err)
+	}
+	return
+}
+
+// validateNotarization checks a notarization entry, which must be of its source's chain
+func validateNotarization(entry Entry, props *ValidationProps) (err error) {
+	var n *Notarization
+	if n, err = parseNotarization(entry); err != nil {
+		return
+	}
+	if err = n.Statement.check(); err != nil {
+		return
+	}
+	if n.Proof == "" {
+		err = errors.New("notarization has no proof")
+		return
+	}
+	if props != nil && len(props.Sources) > 0 && props.Sources[0] != n.Statement.Agent {
+		err = errors.New("notarization must be published by the notarized agent")
+	}
+	return
+}
+
+// validateWitness checks a statement committed to a chain acting as a notary
+func validateWitness(entry Entry) (err error) {
+	c, ok := entry.Content().(string)
+	if !ok {
+		err = errors.New("witness entry should be a string")
+		return
+	}
+	var s NotaryStatement
+	if err = json.Unmarshal([]byte(c), &s); err != nil {
+		return
+	}
+	err = s.check()
+	return
+}
+
+// NotarizationCheck is the result of checking a notarization against the chain
+type NotarizationCheck struct {
+	Hash         Hash // of the notarization entry
+	Notarization *Notarization
+	Err          error // why the notarized head no longer matches the chain, if it doesn't
+}
+
+// CheckNotarizations confirms that each head notarized is still where it was in the chain,
+// and has the notary that gave each proof confirm it is a proof of the statement.  It
+// returns the notarizations found, newest first.
+func (h *Holochain) CheckNotarizations() (checks []NotarizationCheck, err error) {
+	var it *ChainIterator
+	if it, err = h.Iterator(true); err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Next() {
+		header := it.Header()
+		if header.Type != NotarizationEntryType {
+			continue
+		}
+		var entry Entry
+		if entry, err = it.Entry(); err != nil {
+			return
+		}
+		c := NotarizationCheck{Hash: header.EntryLink}
+		if c.Notarization, err = parseNotarization(entry); err != nil {
+			return
+		}
+		c.Err = h.checkNotarization(c.Notarization)
+		checks = append(checks, c)
+	}
+	err = it.Err()
+	return
+}
+
+// notaryOf returns the notary that gave a notarization.  Only the notaries the chain is
+// configured with are trusted, as the notarization's own account of where it came from is in
+// the chain being checked.
+func (h *Holochain) notaryOf(n *Notarization) (notary Notary, err error) {
+	c := h.Config().Notary
+	switch {
+	case c != nil && c.Kind == n.Notary && c.where() == n.Where:
+		notary, err = NewNotary(c)
+	case n.Notary == NotaryOpenTimestamps && (n.Where == "" || n.Where == DefaultOpenTimestampsCalendar):
+		notary, err = NewNotary(&NotaryConfig{Kind: NotaryOpenTimestamps})
+	default:
+		err = fmt.Errorf("notarized by %s %s, which the chain isn't configured to trust", n.Notary, n.Where)
+	}
+	return
+}
+
+// checkNotarization confirms a notarized head is still in the chain, and that the notary
+// vouches for it
+func (h *Holochain) checkNotarization(n *Notarization) (err error) {
+	s := &n.Statement
+	if s.DNA != h.dnaHash.String() || s.Agent != peer.IDB58Encode(h.id) {
+		err = errors.New("notarization is of another chain")
+		return
+	}
+	if s.Index < 0 || s.Index >= len(h.chain.Hashes) || h.chain.Hashes[s.Index].String() != s.Head {
+		err = fmt.Errorf("notarized head %s is no longer header %d of the chain", s.Head, s.Index)
+		return
+	}
+	var proof []byte
+	if proof, err = base64.StdEncoding.DecodeString(n.Proof); err != nil {
+		return
+	}
+	var notary Notary
+	if notary, err = h.notaryOf(n); err != nil {
+		return
+	}
+	err = notary.Verify(h, s, proof)
+	return
+}
+
+// NotaryConfig returns the chain's notary configuration, if it is notarized
+func (h *Holochain) NotaryConfig() *NotaryConfig {
+	return h.Config().Notary
+}
@@ -0,0 +1,229 @@
+package holochain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotaryConfig(t *testing.T) {
+	Convey("it should check notary configurations", t, func() {
+		So((&NotaryConfig{Kind: NotaryOpenTimestamps}).Check(), ShouldBeNil)
+		So((&NotaryConfig{Kind: "fax"}).Check().Error(), ShouldEqual, "unknown notary: fax")
+		So((&NotaryConfig{Kind: NotaryHTTPS, URL: "ftp://notary"}).Check().Error(), ShouldEqual, "https notary needs an http(s) url, got: ftp://notary")
+		So((&NotaryConfig{Kind: NotaryHTTPS, URL: "https://notary"}).Check().Error(), ShouldEqual, "https notary needs the key its receipts are signed with")
+		So((&NotaryConfig{Kind: NotaryHolochain}).Check().Error(), ShouldEqual, "holochain notary needs the chain to witness with")
+		So((&NotaryConfig{Kind: NotaryOpenTimestamps, Interval: "-1m"}).Check().Error(), ShouldEqual, "notarize interval must be positive, got: -1m")
+		interval, err := (&NotaryConfig{Kind: NotaryOpenTimestamps}).IntervalDuration()
+		So(err, ShouldBeNil)
+		So(interval, ShouldEqual, DefaultNotarizeInterval)
+	})
+}
+
+func TestNotarize(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	key, pub, _ := ic.GenerateEd25519Key(strings.NewReader("notary key 1234567890123456789012345678901234567890"))
+	pk, _ := pub.Bytes()
+	var received NotaryStatement
+	forge := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &received)
+		digest := received.Digest()
+		sig, _ := key.Sign(digest)
+		if forge {
+			digest = []byte("another statement")
+		}
+		b, _ = json.Marshal(NotaryReceipt{Digest: hex.EncodeToString(digest), Signature: b58.Encode(sig)})
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	Convey("it should fail without a notary", t, func() {
+		_, _, err := h.Notarize()
+		So(err, ShouldEqual, ErrNotNotarized)
+	})
+
+	h.config.Notary = &NotaryConfig{Kind: NotaryHTTPS, URL: ts.URL, Key: b58.Encode(pk)}
+	var hash Hash
+
+	Convey("it should commit the notary's proof of the chain's head", t, func() {
+		head := h.chain.Hashes[len(h.chain.Hashes)-1]
+		var n *Notarization
+		var err error
+		hash, n, err = h.Notarize()
+		So(err, ShouldBeNil)
+		So(received.Head, ShouldEqual, head.String())
+		So(n.Statement.Index, ShouldEqual, len(h.chain.Hashes)-2)
+		So(n.Where, ShouldEqual, ts.URL)
+		proof, _ := base64.StdEncoding.DecodeString(n.Proof)
+		var receipt NotaryReceipt
+		So(json.Unmarshal(proof, &receipt), ShouldBeNil)
+		So(receipt.Digest, ShouldEqual, hex.EncodeToString(n.Statement.Digest()))
+		So(h.chain.Top().Type, ShouldEqual, NotarizationEntryType)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, hash.String())
+	})
+
+	Convey("it shouldn't notarize a notarization", t, func() {
+		_, _, err := h.Notarize()
+		So(err, ShouldEqual, ErrNothingToNotarize)
+	})
+
+	Convey("it should find notarized heads that no longer match the chain", t, func() {
+		checks, err := h.CheckNotarizations()
+		So(err, ShouldBeNil)
+		So(len(checks), ShouldEqual, 1)
+		So(checks[0].Hash.String(), ShouldEqual, hash.String())
+		So(checks[0].Err, ShouldBeNil)
+
+		idx := checks[0].Notarization.Statement.Index
+		head := h.chain.Hashes[idx]
+		h.chain.Hashes[idx], _ = NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		checks, _ = h.CheckNotarizations()
+		So(checks[0].Err.Error(), ShouldEqual, "notarized head "+head.String()+" is no longer header 1 of the chain")
+		h.chain.Hashes[idx] = head
+	})
+
+	Convey("it should check the notary's proof rather than trust the chain's record of it", t, func() {
+		checks, _ := h.CheckNotarizations()
+		n := *checks[0].Notarization
+		n.Statement.Time = n.Statement.Time.Add(time.Hour)
+		err := h.checkNotarization(&n)
+		So(err.Error(), ShouldEqual, ErrBadNotaryProof.Error()+": receipt is of another statement")
+
+		n = *checks[0].Notarization
+		n.Where = "https://elsewhere"
+		err = h.checkNotarization(&n)
+		So(err.Error(), ShouldEqual, "notarized by https https://elsewhere, which the chain isn't configured to trust")
+	})
+
+	Convey("it shouldn't commit a receipt that doesn't verify", t, func() {
+		forge = true
+		defer func() { forge = false }()
+		h.Commit("myData", "4")
+		_, _, err := h.Notarize()
+		So(isError(err, ErrBadNotaryProof), ShouldBeTrue)
+	})
+
+	Convey("notarizations should be validated", t, func() {
+		_, err := h.Commit(NotarizationEntryType, `{"Statement":{"DNA":"x","Agent":"y"},"Proof":"cA=="}`)
+		So(err.Error(), ShouldEqual, "notary statement must name the DNA, agent, head and time")
+	})
+}
+
+func TestHolochainNotary(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+	var chains []*Holochain
+	for i, n := range []string{"notarized", "witness"} {
+		h, err := s.GenDev(filepath.Join(s.Path, n), "toml")
+		if err != nil {
+			panic(err)
+		}
+		if h, err = s.Load(n); err != nil {
+			panic(err)
+		}
+		if _, err = h.GenChain(); err != nil {
+			panic(err)
+		}
+		h.config.Port += i
+		if err = h.Activate(); err != nil {
+			panic(err)
+		}
+		chains = append(chains, h)
+	}
+	h, w := chains[0], chains[1]
+	w.config.BridgeGrants = []BridgeGrant{{From: "notarized", Entries: []string{NotaryWitnessEntryType}}}
+	ts := serveBridge(w)
+	defer ts.Close()
+	h.config.Notary = &NotaryConfig{Kind: NotaryHolochain, Chain: "witness"}
+
+	Convey("it should have the witnessing chain commit the statement", t, func() {
+		_, n, err := h.Notarize()
+		So(err, ShouldBeNil)
+		So(n.Where, ShouldEqual, "witness")
+		So(w.chain.Top().Type, ShouldEqual, NotaryWitnessEntryType)
+		proof, _ := base64.StdEncoding.DecodeString(n.Proof)
+		So(string(proof), ShouldEqual, w.chain.Top().EntryLink.String())
+
+		checks, err := h.CheckNotarizations()
+		So(err, ShouldBeNil)
+		So(checks[0].Err, ShouldBeNil)
+	})
+
+	Convey("it should refuse to witness for chains that weren't granted it", t, func() {
+		w.config.BridgeGrants = nil
+		_, _, err := h.Notarize()
+		So(err, ShouldEqual, ErrBridgeNotGranted)
+	})
+}
+
+// otsTimestamp makes an OpenTimestamps timestamp that appends suffix to the digest, hashes it
+// and has the calendar attest to the result
+func otsTimestamp(suffix string, calendar string) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{otsAppend, byte(len(suffix))})
+	b.WriteString(suffix)
+	b.Write([]byte{otsSHA256, otsAttestation})
+	b.Write(otsPendingTag)
+	b.Write([]byte{byte(len(calendar) + 1), byte(len(calendar))})
+	b.WriteString(calendar)
+	return b.Bytes()
+}
+
+func TestOpenTimestampsNotary(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	var ts *httptest.Server
+	held := make(map[string]bool)
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/digest" {
+			digest, _ := ioutil.ReadAll(r.Body)
+			c := sha256.Sum256(append(digest, "nonce"...))
+			held[hex.EncodeToString(c[:])] = true
+			w.Write(otsTimestamp("nonce", ts.URL))
+			return
+		}
+		if held[strings.TrimPrefix(r.URL.Path, "/timestamp/")] {
+			w.Write([]byte("upgraded"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+	h.config.Notary = &NotaryConfig{Kind: NotaryOpenTimestamps, URL: ts.URL}
+
+	Convey("it should replay the timestamp and ask the calendar for its commitment", t, func() {
+		_, n, err := h.Notarize()
+		So(err, ShouldBeNil)
+		checks, err := h.CheckNotarizations()
+		So(err, ShouldBeNil)
+		So(checks[0].Err, ShouldBeNil)
+
+		held = make(map[string]bool)
+		So(isError(h.checkNotarization(n), ErrBadNotaryProof), ShouldBeTrue)
+	})
+
+	Convey("it should refuse timestamps that aren't attested by the calendar", t, func() {
+		s := NotaryStatement{Head: "x"}
+		_, err := otsPending(s.Digest(), otsTimestamp("nonce", "https://elsewhere"), ts.URL)
+		So(err.Error(), ShouldEqual, ErrBadNotaryProof.Error()+": no pending attestation by the calendar")
+		_, err = otsPending(s.Digest(), []byte{otsAppend, 200}, ts.URL)
+		So(isError(err, ErrBadNotaryProof), ShouldBeTrue)
+	})
+}
@@ -16,6 +16,13 @@ import (
 
 type NucleusFactory func(h *Holochain, code string) (Nucleus, error)
 
+// Nucleus implementations register themselves from an init function in their own file,
+// guarded by a build tag, so they can be left out of a build (i.e. go build -tags nojs)
+const (
+	JSNucleusType   = "js"
+	ZygoNucleusType = "zygo"
+)
+
 type InterfaceSchemaType int
 
 const (
@@ -42,6 +49,10 @@ type ValidationProps struct {
 	Hash     string
 	MetaTag  string // if validating a putMeta this will have the meta type set
 	MetaHash string
+	MetaBase string                 `json:",omitempty"` // if validating a putMeta the hash being linked from
+	Deps     map[string]interface{} `json:",omitempty"` // content of the entry type's dependencies by hash
+	Agents   []string               `json:",omitempty"` // if the entry is countersigned the agents who signed it, its initiator first
+	Claims   map[string]interface{} `json:",omitempty"` // if the DNA requires attested identities the claims of the source's identity
 }
 
 // Nucleus type abstracts the functions of code execution environments
@@ -56,6 +67,13 @@ type Nucleus interface {
 }
 
 var nucleusFactories = make(map[string]NucleusFactory)
+var nucleusExtensions = make(map[string]string)
+var nucleusParsers = make(map[string]NucleusParser)
+var nucleusPrefixLines = make(map[string]int)
+
+// NucleusParser reads the names of the functions a zome's code exposes without running the
+// code, so that code that isn't trusted yet can be checked
+type NucleusParser func(code string) (exposed []string, err error)
 
 // InterfaceSchema returns a functions schema type
 func InterfaceSchema(n Nucleus, name string) (InterfaceSchemaType, error) {
@@ -80,10 +98,63 @@ func RegisterNucleus(name string, factory NucleusFactory) {
 	nucleusFactories[name] = factory
 }
 
-// RegisterBultinNucleii adds the built in nucleus types to the factory hash
+// RegisterBultinNucleii used to add the built in nucleus types to the factory hash.  They now
+// register themselves from init functions, so it is kept only so that callers still build.
+//
+// Deprecated: there is nothing left for it to do.
 func RegisterBultinNucleii() {
-	RegisterNucleus(ZygoNucleusType, NewZygoNucleus)
-	RegisterNucleus(JSNucleusType, NewJSNucleus)
+}
+
+// RegisterNucleusType sets up a Nucleus along with the file extension used for its code
+func RegisterNucleusType(name string, extension string, factory NucleusFactory) {
+	RegisterNucleus(name, factory)
+	nucleusExtensions[name] = extension
+}
+
+// RegisterNucleusParser sets up the parser for the code of a nucleus type
+func RegisterNucleusParser(name string, parser NucleusParser) {
+	nucleusParsers[name] = parser
+}
+
+// RegisterNucleusPrefixLines records how many lines a nucleus type loads before the code given
+// it, so that the lines its errors point at can be mapped back to the zome's code
+func RegisterNucleusPrefixLines(name string, lines int) {
+	nucleusPrefixLines[name] = lines
+}
+
+// parseExposed reads the names of the functions code of a nucleus type exposes without
+// running it
+func parseExposed(nucleusType string, code string) (exposed []string, err error) {
+	parser, ok := nucleusParsers[nucleusType]
+	if !ok {
+		err = fmt.Errorf("no parser for nucleus type: %s", nucleusType)
+		return
+	}
+	exposed, err = parser(code)
+	return
+}
+
+// NucleusTypes returns the sorted names of the registered nucleus types
+func NucleusTypes() (types []string) {
+	types = make([]string, 0)
+	for k := range nucleusFactories {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	return
+}
+
+// NucleusFileExtension returns the file extension for code of the given nucleus type
+func NucleusFileExtension(nucleusType string) (ext string, err error) {
+	if _, ok := nucleusFactories[nucleusType]; !ok {
+		err = fmt.Errorf("unknown nucleus type:%s", nucleusType)
+		return
+	}
+	ext, ok := nucleusExtensions[nucleusType]
+	if !ok {
+		ext = nucleusType
+	}
+	return
 }
 
 // CreateNucleus returns a new Nucleus of the given type
@@ -92,13 +163,7 @@ func CreateNucleus(h *Holochain, nucleusType string, code string) (Nucleus, erro
 	factory, ok := nucleusFactories[nucleusType]
 	if !ok {
 		// Factory has not been registered.
-		// Make a list of all available nucleus factories for error.
-		available := make([]string, 0)
-		for k := range nucleusFactories {
-			available = append(available, k)
-		}
-		sort.Strings(available)
-		return nil, fmt.Errorf("Invalid nucleus name. Must be one of: %s", strings.Join(available, ", "))
+		return nil, fmt.Errorf("Invalid nucleus name. Must be one of: %s", strings.Join(NucleusTypes(), ", "))
 	}
 
 	return factory(h, code)
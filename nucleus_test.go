@@ -1,20 +1,31 @@
 package holochain
 
 import (
-	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
+	"sort"
+	"strings"
 	"testing"
 )
 
+// builtNucleusTypes are the nucleus types included in the build, which the tests of each
+// nucleus add themselves to under the same build tag as the nucleus
+var builtNucleusTypes []string
+
 func TestCreateNucleus(t *testing.T) {
 	Convey("should fail to create a nucleus based from bad nucleus type", t, func() {
 		_, err := CreateNucleus(nil, "non-existent-nucleus-type", "some code")
-		So(err.Error(), ShouldEqual, "Invalid nucleus name. Must be one of: js, zygo")
+		So(err.Error(), ShouldEqual, "Invalid nucleus name. Must be one of: "+strings.Join(NucleusTypes(), ", "))
+	})
+}
+
+func TestNucleusRegistry(t *testing.T) {
+	Convey("the nucleii included in the build should register themselves", t, func() {
+		expected := append([]string{}, builtNucleusTypes...)
+		sort.Strings(expected)
+		So(NucleusTypes(), ShouldResemble, expected)
 	})
-	Convey("should create a nucleus based from a good schema type", t, func() {
-		v, err := CreateNucleus(nil, ZygoNucleusType, `(+ 1 1)`)
-		z := v.(*ZygoNucleus)
-		So(err, ShouldBeNil)
-		So(fmt.Sprintf("%v", z.lastResult), ShouldEqual, "&{2 <nil>}")
+	Convey("it should fail to return the file extension for an unknown nucleus type", t, func() {
+		_, err := NucleusFileExtension("fish")
+		So(err.Error(), ShouldEqual, "unknown nucleus type:fish")
 	})
 }
@@ -0,0 +1,101 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// nucleusapi implements versioning of the API that zome code is written against.  Each zome
+// can declare the version of the API its code expects with "API" in the DNA, and a zome that
+// needs a newer API than this holochain provides, or one older than it still supports, fails
+// to load rather than misbehaving.  Zomes written against an older version are run with shims
+// that keep the behavior they were written for.  Zome code can also feature-detect by calling
+// hostCapabilities(), which tells it the API version it is run with and which host functions
+// it can call.
+//
+// The versions of the API are:
+//
+//   1: property("_id"), property("_agent_id") and property("_agent_name") return the DNA's
+//      hash, the agent's hash and the agent's name
+//   2: those special properties return nothing, the values being in App instead
+
+package holochain
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	NucleusAPIVersion    = 2 // version of the API this holochain provides to zome code
+	MinNucleusAPIVersion = 1 // oldest version of the API zome code can still be written against
+)
+
+// HostCapabilities describes what the host provides to a zome's code
+type HostCapabilities struct {
+	Version   int      // of holochain
+	API       int      // version of the API the zome's code is run with
+	MinAPI    int      // oldest API version zome code can be written against
+	MaxAPI    int      // newest API version zome code can be written against
+	Nucleus   string   // the nucleus type running the zome's code
+	Functions []string // names of the host functions the zome's code can call
+}
+
+// APIVersion returns the version of the API the zome's code is written against
+func (z *Zome) APIVersion() int {
+	if z.API == 0 {
+		return NucleusAPIVersion
+	}
+	return z.API
+}
+
+// checkAPI checks that the zome's API version is one this holochain can run its code with
+func (z *Zome) checkAPI() (err error) {
+	if v := z.APIVersion(); v > NucleusAPIVersion {
+		err = fmt.Errorf("zome %s needs nucleus API version %d, but this holochain only provides up to %d", z.Name, v, NucleusAPIVersion)
+	} else if v < MinNucleusAPIVersion {
+		err = fmt.Errorf("zome %s is written for nucleus API version %d, which is no longer supported (the oldest is %d)", z.Name, v, MinNucleusAPIVersion)
+	}
+	return
+}
+
+// zomeAPIVersion returns the version of the API a zome's code is run with, the current one for
+// code that isn't a zome's
+func (h *Holochain) zomeAPIVersion(zome string) int {
+	if z, ok := h.Zomes[zome]; ok {
+		return z.APIVersion()
+	}
+	return NucleusAPIVersion
+}
+
+// zomeProperty returns a property to a zome's code, as the API version it is written for did
+func (h *Holochain) zomeProperty(zome string, prop string) (property string, err error) {
+	if h.zomeAPIVersion(zome) < 2 {
+		switch prop {
+		case ID_PROPERTY:
+			property = h.dnaHash.String()
+			return
+		case AGENT_ID_PROPERTY:
+			property = h.agentHash.String()
+			return
+		case AGENT_NAME_PROPERTY:
+			property = string(h.Agent().Name())
+			return
+		}
+	}
+	property, err = h.GetProperty(prop)
+	return
+}
+
+// hostCapabilities returns what the host provides to a zome's code, given the names of the
+// host functions its nucleus has registered
+func (h *Holochain) hostCapabilities(zome string, nucleusType string, fns []string) HostCapabilities {
+	functions := make([]string, len(fns))
+	copy(functions, fns)
+	sort.Strings(functions)
+	return HostCapabilities{
+		Version:   Version,
+		API:       h.zomeAPIVersion(zome),
+		MinAPI:    MinNucleusAPIVersion,
+		MaxAPI:    NucleusAPIVersion,
+		Nucleus:   nucleusType,
+		Functions: functions,
+	}
+}
@@ -0,0 +1,50 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestNucleusAPIVersion(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("zomes should be written against the current API if they don't say", t, func() {
+		z := Zome{Name: "z"}
+		So(z.APIVersion(), ShouldEqual, NucleusAPIVersion)
+		So(z.checkAPI(), ShouldBeNil)
+		z.API = MinNucleusAPIVersion
+		So(z.APIVersion(), ShouldEqual, MinNucleusAPIVersion)
+		So(z.checkAPI(), ShouldBeNil)
+	})
+
+	Convey("zomes needing an API this holochain doesn't provide should fail to load", t, func() {
+		z := h.Zomes["myZome"]
+		z.API = NucleusAPIVersion + 1
+		err := h.Prepare()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "zome myZome needs nucleus API version")
+		z.API = -1
+		So(h.Prepare(), ShouldNotBeNil)
+		z.API = 0
+	})
+
+	Convey("older zomes should get the properties of the API they were written for", t, func() {
+		p, err := h.zomeProperty("myZome", AGENT_ID_PROPERTY)
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "")
+		h.Zomes["myZome"].API = 1
+		p, err = h.zomeProperty("myZome", AGENT_ID_PROPERTY)
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, h.agentHash.String())
+		h.Zomes["myZome"].API = 0
+	})
+
+	Convey("host capabilities should list the host functions", t, func() {
+		c := h.hostCapabilities("myZome", ZygoNucleusType, []string{"put", "commit"})
+		So(c.Functions, ShouldResemble, []string{"commit", "put"})
+		So(c.MinAPI, ShouldEqual, MinNucleusAPIVersion)
+		So(c.MaxAPI, ShouldEqual, NucleusAPIVersion)
+		So(c.Version, ShouldEqual, Version)
+	})
+}
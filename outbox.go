@@ -0,0 +1,130 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// outbox implements committing while offline.  Entries committed while the chain isn't
+// activated, or is configured to be offline, are written to the source chain as usual, but
+// their puts wait in the outbox, i.e. the unacknowledged publications, instead of being sent.
+// Once the node is back online the outbox is published in the order it was made.
+
+package holochain
+
+import (
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+const publicationSeqKey = "pubseq"
+
+// nextPublicationSeq numbers a publication, keeping the number of an earlier record of the
+// same put so that re-publishing it doesn't move it to the back of the outbox
+func nextPublicationSeq(tx *buntdb.Tx, p *Publication) (err error) {
+	var v string
+	if v, err = tx.Get(p.key()); err == nil {
+		var old Publication
+		if err = ByteDecoder([]byte(v), &old); err != nil {
+			return
+		}
+		if old.Seq != 0 {
+			p.Seq = old.Seq
+			return
+		}
+	} else if err != buntdb.ErrNotFound {
+		return
+	}
+	var seq int64
+	if v, err = tx.Get(publicationSeqKey); err == nil {
+		if seq, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return
+		}
+	} else if err != buntdb.ErrNotFound {
+		return
+	}
+	seq++
+	_, _, err = tx.Set(publicationSeqKey, fmt.Sprintf("%d", seq), nil)
+	p.Seq = seq
+	return
+}
+
+// Offline returns true if the node can't publish to the DHT, either because the chain
+// hasn't been activated or because its config says to stay offline
+func (h *Holochain) Offline() bool {
+	return h.node == nil || h.Config().Offline
+}
+
+// SetOffline sets whether the chain stays offline and saves it to the chain's config.  If a
+// running node comes back online it contacts the bootstrap server and publishes its outbox.
+func (h *Holochain) SetOffline(offline bool) (err error) {
+	var was bool
+	h.updateConfig(func(config *Config) {
+		was = config.Offline
+		config.Offline = offline
+	})
+	if err = h.saveConfig(); err != nil {
+		return
+	}
+	if was && !offline {
+		h.goOnline()
+	}
+	return
+}
+
+// goOnline contacts the bootstrap server and starts publishing the outbox
+func (h *Holochain) goOnline() {
+	if h.node == nil {
+		return
+	}
+	if h.config.PeerModeDHTNode {
+		h.bootstrap()
+	}
+	atomic.StoreInt32(&h.dht.outboxFull, 1)
+	h.dht.flushOutbox()
+}
+
+// Outbox returns the publications waiting to be acknowledged, in the order they were made
+func (dht *DHT) Outbox() (pubs []Publication, err error) {
+	if pubs, err = dht.Publications(true); err != nil {
+		return
+	}
+	sort.Stable(bySeq(pubs))
+	return
+}
+
+// bySeq sorts publications in the order they were made
+type bySeq []Publication
+
+func (p bySeq) Len() int           { return len(p) }
+func (p bySeq) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p bySeq) Less(i, j int) bool { return p[i].Seq < p[j].Seq }
+
+// queued flags that publications are waiting in the outbox
+func (dht *DHT) queued() {
+	atomic.StoreInt32(&dht.outboxFull, 1)
+}
+
+// sent clears the flag, as the whole outbox is about to be re-published
+func (dht *DHT) sent() {
+	atomic.StoreInt32(&dht.outboxFull, 0)
+}
+
+// flushOutbox starts re-publishing the outbox in the background if anything may be waiting
+// in it, which is checked cheaply so it can be called whenever the node reaches a peer
+func (dht *DHT) flushOutbox() {
+	if dht.h.Offline() || atomic.LoadInt32(&dht.outboxFull) == 0 {
+		return
+	}
+	e := dht.h.goWorker(func() {
+		acked, pending, e := dht.Republish()
+		if e != nil {
+			dht.dlog.Logf("error publishing outbox: %v", e)
+		} else if acked > 0 {
+			dht.dlog.Logf("published %d from outbox, %d still waiting", acked, pending)
+		}
+	})
+	if e != nil {
+		dht.dlog.Logf("unable to start publishing outbox: %v", e)
+	}
+}
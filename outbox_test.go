@@ -0,0 +1,87 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	drain := func() {
+		for len(dht.puts) > 0 {
+			<-dht.puts
+		}
+	}
+	drain()
+	dht.Republish()
+
+	hash1, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	hash2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+
+	Convey("it should be offline until activated or when configured to be", t, func() {
+		So(h.Offline(), ShouldBeFalse)
+		n := h.node
+		h.node = nil
+		So(h.Offline(), ShouldBeTrue)
+		h.node = n
+		h.config.Offline = true
+		So(h.Offline(), ShouldBeTrue)
+	})
+
+	Convey("it should keep puts in the outbox in order while offline", t, func() {
+		So(dht.SendPut(hash2), ShouldBeNil)
+		So(dht.SendPutMeta(MetaReq{O: hash2, M: hash1, T: "someTag"}), ShouldBeNil)
+		So(dht.SendPut(hash1), ShouldBeNil)
+		So(len(dht.puts), ShouldEqual, 0)
+		So(dht.gossip(), ShouldBeNil)
+
+		pubs, err := dht.Outbox()
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldEqual, 3)
+		So(pubs[0].Put.H.String(), ShouldEqual, hash2.String())
+		So(pubs[1].Type, ShouldEqual, PUTMETA_REQUEST)
+		So(pubs[2].Put.H.String(), ShouldEqual, hash1.String())
+		So(pubs[0].Tries, ShouldEqual, 0)
+		So(pubs[0].Seq < pubs[1].Seq && pubs[1].Seq < pubs[2].Seq, ShouldBeTrue)
+
+		// putting again keeps its place in the outbox
+		So(dht.SendPut(hash2), ShouldBeNil)
+		pubs, _ = dht.Outbox()
+		So(pubs[0].Put.H.String(), ShouldEqual, hash2.String())
+
+		acked, pending, err := dht.Republish()
+		So(err, ShouldBeNil)
+		So(acked, ShouldEqual, 0)
+		So(pending, ShouldEqual, 3)
+	})
+
+	Convey("it should publish past failures, holding back links on entries that failed", t, func() {
+		h.config.Offline = false
+		for len(dht.puts) < cap(dht.puts) {
+			dht.puts <- h.node.NewMessage(PUT_REQUEST, PutReq{H: hash1})
+		}
+		acked, pending, err := dht.Republish()
+		So(err, ShouldBeNil)
+		So(acked, ShouldEqual, 0)
+		So(pending, ShouldEqual, 3)
+		pubs, _ := dht.Outbox()
+		So(pubs[0].Tries, ShouldEqual, 1)
+		So(pubs[1].Tries, ShouldEqual, 0)
+		So(pubs[2].Tries, ShouldEqual, 1)
+	})
+
+	Convey("it should publish the outbox once back online", t, func() {
+		drain()
+		acked, pending, err := dht.Republish()
+		So(err, ShouldBeNil)
+		So(acked, ShouldEqual, 3)
+		So(pending, ShouldEqual, 0)
+		pubs, err := dht.Outbox()
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldEqual, 0)
+		p := h.Progress()
+		So(p[len(p)-1], ShouldResemble, Progress{Op: ProgressPublish, Stage: "publishing outbox", Done: 3, Total: 3})
+	})
+}
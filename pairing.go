@@ -0,0 +1,89 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// pairing implements one time pairing codes, which let a client like a mobile web UI get an
+// API token for a chain by presenting a code shown to the node's operator, e.g. as a QR code,
+// instead of having a token typed or pasted into it.  Neither the code nor the token is ever
+// put in a URL the node is sent, so neither ends up in logs, browser history or referrers.
+
+package holochain
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultPairingTTL is how long a pairing code may be used for
+const DefaultPairingTTL = 5 * time.Minute
+
+var ErrPairingNotFound error = errors.New("pairing code not found or already used")
+var ErrPairingExpired error = errors.New("pairing code expired")
+
+// Pairing is a one time code that can be exchanged for a new API token with its scope
+type Pairing struct {
+	Code       string
+	Chain      string
+	Functions  []string
+	EntryTypes []string
+	Expires    time.Time
+}
+
+// URL returns the address a client scans or visits to pair with the node at nodeURL.  The code
+// is in the fragment, which browsers don't send to the node; the UI posts it to /_pair.
+func (p *Pairing) URL(nodeURL string) string {
+	return nodeURL + "/#pair=" + url.QueryEscape(p.Code)
+}
+
+// pairings holds the pairing codes offered by the running process, which are deliberately
+// not persisted so that they don't outlive it
+var pairings = struct {
+	lk    sync.Mutex
+	codes map[string]Pairing
+}{codes: make(map[string]Pairing)}
+
+// NewPairing offers a one time pairing code for a chain, which can be exchanged for an API
+// token of the given scope until ttl has passed
+func (s *Service) NewPairing(chain string, functions []string, entryTypes []string, ttl time.Duration) (p Pairing, err error) {
+	p = Pairing{Chain: chain, Functions: functions, EntryTypes: entryTypes, Expires: time.Now().Add(ttl)}
+	if p.Code, err = newTokenString(); err != nil {
+		return
+	}
+	pairings.lk.Lock()
+	defer pairings.lk.Unlock()
+	for code, x := range pairings.codes {
+		if time.Now().After(x.Expires) {
+			delete(pairings.codes, code)
+		}
+	}
+	pairings.codes[p.Code] = p
+	return
+}
+
+// Pair uses up a pairing code for a chain, issuing a new API token of the code's scope
+func (s *Service) Pair(chain string, code string) (t APIToken, err error) {
+	var p Pairing
+	var found bool
+	pairings.lk.Lock()
+	for c, x := range pairings.codes {
+		if x.Chain == chain && subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			p, found = x, true
+			delete(pairings.codes, c)
+			break
+		}
+	}
+	pairings.lk.Unlock()
+	if !found {
+		err = ErrPairingNotFound
+		return
+	}
+	if time.Now().After(p.Expires) {
+		err = ErrPairingExpired
+		return
+	}
+	t, err = s.IssueToken(chain, "", p.Functions, p.EntryTypes)
+	return
+}
@@ -0,0 +1,38 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestPairing(t *testing.T) {
+	d, s, _ := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should exchange a pairing code for an API token once", t, func() {
+		p, err := s.NewPairing("test", []string{"myZome:getDNA"}, []string{"myData"}, time.Minute)
+		So(err, ShouldBeNil)
+		So(len(p.Code), ShouldEqual, 64)
+		So(p.URL("http://192.168.1.2:3141"), ShouldEqual, "http://192.168.1.2:3141/#pair="+p.Code)
+
+		_, err = s.Pair("other", p.Code)
+		So(err, ShouldEqual, ErrPairingNotFound)
+
+		tk, err := s.Pair("test", p.Code)
+		So(err, ShouldBeNil)
+		So(tk.Functions, ShouldResemble, []string{"myZome:getDNA"})
+		So(tk.EntryTypes, ShouldResemble, []string{"myData"})
+		So(s.CheckToken("test", tk.Token), ShouldNotBeNil)
+
+		_, err = s.Pair("test", p.Code)
+		So(err, ShouldEqual, ErrPairingNotFound)
+	})
+
+	Convey("it should refuse expired pairing codes", t, func() {
+		p, err := s.NewPairing("test", []string{"*"}, []string{"*"}, -time.Second)
+		So(err, ShouldBeNil)
+		_, err = s.Pair("test", p.Code)
+		So(err, ShouldEqual, ErrPairingExpired)
+	})
+}
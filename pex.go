@@ -0,0 +1,285 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// pex implements peer exchange: along with their puts, gossipers swap a signed sample of the
+// addresses of peers they have had good dealings with, so that nodes find more of the network
+// through gossip instead of all relying on the bootstrap server.  Addresses learned this way
+// are only kept for a while unless the peer answers at them, and addresses of peers that have
+// been gone or banned for long are pruned by garbage collection.
+
+package holochain
+
+import (
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/tidwall/buntdb"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	// PexSampleSize is the most peer addresses sent, or accepted, in a peer exchange
+	PexSampleSize = 16
+
+	// PexMaxAge is how old a peer exchange may be when it is received
+	PexMaxAge = 10 * time.Minute
+
+	// PexUnverifiedTTL is how long an address learned from a peer exchange is kept if the
+	// peer never answers at it
+	PexUnverifiedTTL = time.Hour
+
+	// PexAddrRetention is how long the address of a banned or unreachable peer is kept
+	PexAddrRetention = 7 * 24 * time.Hour
+)
+
+// PeerAddr is the network address of a peer
+type PeerAddr struct {
+	Id   peer.ID
+	Addr string
+}
+
+// PeerExchange is a node's signed sample of the addresses of peers it knows to be good
+type PeerExchange struct {
+	From   peer.ID
+	Peers  []PeerAddr
+	Time   time.Time
+	PubKey []byte
+	Sig    []byte
+}
+
+func (x *PeerExchange) signedBytes() ([]byte, error) {
+	return ByteEncoder(PeerExchange{From: x.From, Peers: x.Peers, Time: x.Time})
+}
+
+// verify checks that the exchange was signed by the node it claims to be from
+func (x *PeerExchange) verify() (err error) {
+	var pub ic.PubKey
+	pub, err = ic.UnmarshalPublicKey(x.PubKey)
+	if err != nil {
+		return
+	}
+	if !x.From.MatchesPublicKey(pub) {
+		err = fmt.Errorf("peer exchange from %v signed with another key", x.From)
+		return
+	}
+	var b []byte
+	b, err = x.signedBytes()
+	if err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(b, x.Sig)
+	if err == nil && !ok {
+		err = fmt.Errorf("bad peer exchange signature from %v", x.From)
+	}
+	return
+}
+
+func peerAddrKey(id peer.ID) string {
+	return "addr:" + peer.IDB58Encode(id)
+}
+
+// AddPeerAddr records the address of a peer, both with the transport and in the DHT store so
+// that it can be passed on to other peers
+func (dht *DHT) AddPeerAddr(id peer.ID, addr ma.Multiaddr) (err error) {
+	if dht.h.node != nil {
+		dht.h.node.Transport.AddPeerAddr(id, addr)
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(peerAddrKey(id), addr.String(), nil)
+		return err
+	})
+	return
+}
+
+// addPexAddr records an address learned from a peer exchange, which expires unless the peer
+// answers at it
+func (dht *DHT) addPexAddr(id peer.ID, addr ma.Multiaddr) (err error) {
+	if dht.h.node != nil {
+		dht.h.node.Transport.AddPeerAddr(id, addr)
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(peerAddrKey(id), addr.String(), &buntdb.SetOptions{Expires: true, TTL: PexUnverifiedTTL})
+		return err
+	})
+	return
+}
+
+// keepPeerAddr stops the address of a peer that has answered from expiring
+func keepPeerAddr(tx *buntdb.Tx, id peer.ID) (err error) {
+	k := peerAddrKey(id)
+	ttl, e := tx.TTL(k)
+	if e != nil || ttl < 0 {
+		return
+	}
+	var addr string
+	if addr, err = tx.Get(k); err != nil {
+		return
+	}
+	_, _, err = tx.Set(k, addr, nil)
+	return
+}
+
+// prunePeerAddrs deletes the addresses of peers that have been banned or unreachable, and not
+// seen, for longer than PexAddrRetention, returning how many were deleted
+func prunePeerAddrs(tx *buntdb.Tx, now time.Time) (pruned int, err error) {
+	var keys []string
+	tx.AscendKeys("addr:*", func(key, value string) bool {
+		id, e := peer.IDB58Decode(strings.TrimPrefix(key, "addr:"))
+		if e != nil {
+			keys = append(keys, key)
+			return true
+		}
+		var r PeerRecord
+		if r, err = getPeerRecord(tx, id); err != nil {
+			return false
+		}
+		if (r.Banned() || r.Unreachable) && now.Sub(r.LastSeen) > PexAddrRetention {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if _, err = deleteKeys(tx, keys...); err != nil {
+		return
+	}
+	pruned = len(keys)
+	return
+}
+
+// PeerAddrs returns the recorded addresses of peers
+func (dht *DHT) PeerAddrs() (addrs []PeerAddr, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("addr:*", func(key, value string) bool {
+			var id peer.ID
+			id, e = peer.IDB58Decode(strings.TrimPrefix(key, "addr:"))
+			if e != nil {
+				return false
+			}
+			addrs = append(addrs, PeerAddr{Id: id, Addr: value})
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// pexSample returns a random sample of the addresses of peers that have answered requests
+// and currently aren't banned or unreachable, preceded by this node's own address
+func (dht *DHT) pexSample() (sample []PeerAddr, err error) {
+	if dht.h.node != nil && dht.h.node.NetAddr != nil {
+		sample = append(sample, PeerAddr{Id: dht.h.id, Addr: dht.h.node.NetAddr.String()})
+	}
+	var addrs []PeerAddr
+	if addrs, err = dht.PeerAddrs(); err != nil {
+		return
+	}
+	for _, i := range rand.Perm(len(addrs)) {
+		if len(sample) >= PexSampleSize {
+			break
+		}
+		a := addrs[i]
+		if a.Id == dht.h.id {
+			continue
+		}
+		var r PeerRecord
+		if r, err = dht.GetPeerRecord(a.Id); err != nil {
+			return
+		}
+		if r.Successes > 0 && !r.Banned() && !r.Unreachable {
+			sample = append(sample, a)
+		}
+	}
+	return
+}
+
+// NewPeerExchange returns a signed sample of this node's known good peers
+func (h *Holochain) NewPeerExchange() (x *PeerExchange, err error) {
+	pex := PeerExchange{From: h.id, Time: time.Now()}
+	if pex.Peers, err = h.dht.pexSample(); err != nil {
+		return
+	}
+	priv := h.Agent().PrivKey()
+	pex.PubKey, err = ic.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = pex.signedBytes()
+	if err != nil {
+		return
+	}
+	pex.Sig, err = priv.Sign(b)
+	if err != nil {
+		return
+	}
+	x = &pex
+	return
+}
+
+// putPeerExchange verifies a recent peer exchange received from a gossiper and adds the peers
+// in it whose addresses aren't already known, returning how many were added.  The addresses
+// are unverified until the peers answer at them.
+func (dht *DHT) putPeerExchange(from peer.ID, x *PeerExchange) (added int, err error) {
+	if x.From != from {
+		err = fmt.Errorf("peer exchange for %v sent by %v", x.From, from)
+		return
+	}
+	if len(x.Peers) > PexSampleSize {
+		err = fmt.Errorf("peer exchange from %v has %d peers, more than %d", from, len(x.Peers), PexSampleSize)
+		return
+	}
+	if age := time.Since(x.Time); age > PexMaxAge || -age > dht.h.maxClockSkew() {
+		err = fmt.Errorf("peer exchange from %v is dated %v", from, x.Time)
+		return
+	}
+	if err = x.verify(); err != nil {
+		return
+	}
+	for _, p := range x.Peers {
+		if p.Id == dht.h.id {
+			continue
+		}
+		addr, e := ma.NewMultiaddr(p.Addr)
+		if e != nil {
+			dht.glog.Logf("bad address %s for %v from %v: %v", p.Addr, p.Id, from, e)
+			continue
+		}
+		var r PeerRecord
+		if r, err = dht.GetPeerRecord(p.Id); err != nil {
+			return
+		}
+		if r.Banned() || dht.knowsPeer(p.Id) {
+			continue
+		}
+		if err = dht.addPexAddr(p.Id, addr); err != nil {
+			return
+		}
+		if err = dht.UpdateGossiper(p.Id, 0); err != nil {
+			return
+		}
+		added++
+	}
+	if added > 0 {
+		dht.glog.Logf("learned %d peers from %v", added, from)
+	}
+	return
+}
+
+// knowsPeer returns true if an address is already recorded for the peer, which a peer
+// exchange doesn't override
+func (dht *DHT) knowsPeer(id peer.ID) (known bool) {
+	dht.db.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(peerAddrKey(id))
+		known = err == nil
+		return nil
+	})
+	return
+}
@@ -0,0 +1,135 @@
+package holochain
+
+import (
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedPeerExchange(name string, peers []PeerAddr) (x *PeerExchange) {
+	r := strings.NewReader(name + "1234567890123456789012345678901234567890")
+	priv, _, _ := ic.GenerateEd25519Key(r)
+	id, _ := peer.IDFromPrivateKey(priv)
+	x = &PeerExchange{From: id, Peers: peers, Time: time.Now()}
+	x.PubKey, _ = ic.MarshalPublicKey(priv.GetPublic())
+	b, _ := x.signedBytes()
+	x.Sig, _ = priv.Sign(b)
+	return
+}
+
+func TestPeerExchange(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	good, _ := makePeer("good")
+	untried, _ := makePeer("untried")
+	bad, _ := makePeer("bad")
+	addr, _ := ma.NewMultiaddr("/ip4/192.168.1.2/tcp/1234")
+	dht.AddPeerAddr(good, addr)
+	dht.AddPeerAddr(untried, addr)
+	dht.AddPeerAddr(bad, addr)
+	dht.RecordPeerEvent(good, PeerSuccess)
+	dht.RecordPeerEvent(bad, PeerSuccess)
+	for i := 0; i < MinBanEvents; i++ {
+		dht.RecordPeerEvent(bad, PeerViolation)
+	}
+
+	Convey("it should sign a sample of its own and known good peers' addresses", t, func() {
+		x, err := h.NewPeerExchange()
+		So(err, ShouldBeNil)
+		So(x.From, ShouldEqual, h.id)
+		So(x.verify(), ShouldBeNil)
+		So(len(x.Peers), ShouldEqual, 2)
+		So(x.Peers[0], ShouldResemble, PeerAddr{Id: h.id, Addr: h.node.NetAddr.String()})
+		So(x.Peers[1], ShouldResemble, PeerAddr{Id: good, Addr: addr.String()})
+	})
+
+	Convey("it should add the peers from a gossiper's exchange", t, func() {
+		other, _ := makePeer("other")
+		x := signedPeerExchange("sender", []PeerAddr{
+			{Id: other, Addr: "/ip4/192.168.1.3/tcp/1234"},
+			{Id: h.id, Addr: "/ip4/192.168.1.4/tcp/1234"},
+			{Id: bad, Addr: "/ip4/192.168.1.5/tcp/1234"},
+			{Id: good, Addr: "/ip4/192.168.1.6/tcp/1234"},
+		})
+		added, err := dht.putPeerExchange(x.From, x)
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 1)
+		_, err = dht.GetGossiper(other)
+		So(err, ShouldBeNil)
+		addrs, err := dht.PeerAddrs()
+		So(err, ShouldBeNil)
+		So(addrs, ShouldContain, PeerAddr{Id: other, Addr: "/ip4/192.168.1.3/tcp/1234"})
+		So(addrs, ShouldContain, PeerAddr{Id: good, Addr: addr.String()})
+	})
+
+	Convey("it should keep learned addresses only until they expire unless the peer answers", t, func() {
+		other, _ := makePeer("other")
+		dht.db.View(func(tx *buntdb.Tx) error {
+			ttl, err := tx.TTL(peerAddrKey(other))
+			So(err, ShouldBeNil)
+			So(ttl, ShouldBeGreaterThan, 0)
+			return nil
+		})
+		So(dht.RecordPeerEvent(other, PeerSuccess), ShouldBeNil)
+		dht.db.View(func(tx *buntdb.Tx) error {
+			ttl, err := tx.TTL(peerAddrKey(other))
+			So(err, ShouldBeNil)
+			So(ttl, ShouldBeLessThan, 0)
+			return nil
+		})
+	})
+
+	Convey("it should prune the addresses of peers long gone", t, func() {
+		dht.db.Update(func(tx *buntdb.Tx) error {
+			pruned, err := prunePeerAddrs(tx, time.Now())
+			So(err, ShouldBeNil)
+			So(pruned, ShouldEqual, 0)
+			pruned, err = prunePeerAddrs(tx, time.Now().Add(PexAddrRetention+time.Hour))
+			So(err, ShouldBeNil)
+			So(pruned, ShouldEqual, 1)
+			return nil
+		})
+		So(dht.knowsPeer(bad), ShouldBeFalse)
+		So(dht.knowsPeer(good), ShouldBeTrue)
+	})
+
+	Convey("it should reject exchanges that are forged or too big", t, func() {
+		x := signedPeerExchange("sender", nil)
+		_, err := dht.putPeerExchange(good, x)
+		So(err, ShouldNotBeNil)
+
+		x.Peers = []PeerAddr{{Id: good, Addr: addr.String()}}
+		_, err = dht.putPeerExchange(x.From, x)
+		So(err.Error(), ShouldStartWith, "bad peer exchange signature")
+
+		peers := make([]PeerAddr, PexSampleSize+1)
+		x = signedPeerExchange("sender", peers)
+		_, err = dht.putPeerExchange(x.From, x)
+		So(err.Error(), ShouldContainSubstring, "more than")
+
+		x = signedPeerExchange("sender", nil)
+		x.Time = time.Now().Add(-PexMaxAge - time.Minute)
+		b, _ := x.signedBytes()
+		r := strings.NewReader("sender" + "1234567890123456789012345678901234567890")
+		priv, _, _ := ic.GenerateEd25519Key(r)
+		x.Sig, _ = priv.Sign(b)
+		_, err = dht.putPeerExchange(x.From, x)
+		So(err.Error(), ShouldContainSubstring, "is dated")
+	})
+
+	Convey("gossip responses should include a peer exchange", t, func() {
+		m := h.node.NewMessage(GOSSIP_REQUEST, GossipReq{MyIdx: 1, YourIdx: 2})
+		r, err := DHTReceiver(h, m)
+		So(err, ShouldBeNil)
+		g := r.(Gossip)
+		So(g.Peers, ShouldNotBeNil)
+		So(g.Peers.From, ShouldEqual, h.id)
+	})
+}
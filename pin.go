@@ -0,0 +1,101 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// pin implements pinning of entries in the DHT store, which keeps them from ever being
+// evicted by expiry or garbage collection.  A hash can be pinned before its entry arrives,
+// in which case the entry is kept once it does.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotPinned error = errors.New("entry not pinned")
+var ErrPinInValidation error = errors.New("entries can't be pinned or unpinned during validation")
+
+// Pin describes a pinned entry
+type Pin struct {
+	Hash   Hash
+	Pinned time.Time
+	Held   bool // whether the entry is in the DHT store
+}
+
+// isPinned checks whether the entry with hash k is pinned
+func isPinned(tx *buntdb.Tx, k string) bool {
+	_, err := tx.Get("pin:" + k)
+	return err == nil
+}
+
+// Pin marks an entry as never to be evicted from the DHT store
+func (dht *DHT) Pin(key Hash) error {
+	return dht.db.Update(func(tx *buntdb.Tx) error {
+		k := key.String()
+		if isPinned(tx, k) {
+			return nil
+		}
+		_, _, err := tx.Set("pin:"+k, fmt.Sprintf("%d", time.Now().UnixNano()), nil)
+		return err
+	})
+}
+
+// Unpin lets an entry be evicted from the DHT store again
+func (dht *DHT) Unpin(key Hash) error {
+	return dht.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete("pin:" + key.String())
+		if err == buntdb.ErrNotFound {
+			err = ErrNotPinned
+		}
+		return err
+	})
+}
+
+// Pins returns the pinned entries
+func (dht *DHT) Pins() (pins []Pin, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("pin:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "pin:")
+			var p Pin
+			if p.Hash, e = NewHash(k); e != nil {
+				return false
+			}
+			var t int64
+			if t, e = strconv.ParseInt(value, 10, 64); e != nil {
+				return false
+			}
+			p.Pinned = time.Unix(0, t)
+			_, e = tx.Get("entry:" + k)
+			p.Held = e == nil
+			e = nil
+			pins = append(pins, p)
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// pin pins or unpins an entry on behalf of zome code
+func (h *Holochain) pin(hashstr string, pin bool, validating bool) (err error) {
+	if validating {
+		err = ErrPinInValidation
+		return
+	}
+	var key Hash
+	if key, err = NewHash(hashstr); err != nil {
+		return
+	}
+	if pin {
+		err = h.dht.Pin(key)
+	} else {
+		err = h.dht.Unpin(key)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func TestPin(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	rejected, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	expiring, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	missing, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh5")
+	other, _ := makePeer("other")
+	dht.put(nil, "myData", rejected, other, []byte("rejected"), REJECTED)
+	dht.put(nil, "myData", expiring, other, []byte("expiring"), LIVE)
+	dht.setExpiry(expiring, time.Now().Add(-time.Second))
+	dht.db.Update(func(tx *buntdb.Tx) error {
+		tx.Set("meta:"+missing.String()+":"+expiring.String()+":tag", "orphan", nil)
+		return nil
+	})
+
+	Convey("it should pin and list entries", t, func() {
+		for _, k := range []Hash{rejected, expiring, missing} {
+			So(dht.Pin(k), ShouldBeNil)
+		}
+		So(dht.Pin(rejected), ShouldBeNil)
+		pins, err := dht.Pins()
+		So(err, ShouldBeNil)
+		So(len(pins), ShouldEqual, 3)
+		held := make(map[string]bool)
+		for _, p := range pins {
+			held[p.Hash.String()] = p.Held
+		}
+		So(held, ShouldResemble, map[string]bool{rejected.String(): true, expiring.String(): true, missing.String(): false})
+	})
+
+	Convey("pinned entries shouldn't expire or be collected", t, func() {
+		_, _, _, err := dht.get(expiring)
+		So(err, ShouldBeNil)
+		n, err := dht.ExpireEntries()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+		report, err := h.CollectGarbage("0s")
+		So(err, ShouldBeNil)
+		So(report, ShouldResemble, GCReport{})
+	})
+
+	Convey("unpinned entries should be evicted again", t, func() {
+		for _, k := range []Hash{rejected, expiring, missing} {
+			So(dht.Unpin(k), ShouldBeNil)
+		}
+		So(dht.Unpin(rejected), ShouldEqual, ErrNotPinned)
+		n, err := dht.ExpireEntries()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		report, err := h.CollectGarbage("0s")
+		So(err, ShouldBeNil)
+		So(report.Entries, ShouldEqual, 1)
+		So(report.Metas, ShouldEqual, 1)
+		pins, _ := dht.Pins()
+		So(len(pins), ShouldEqual, 0)
+	})
+
+	Convey("zome code shouldn't pin during validation", t, func() {
+		So(h.pin(rejected.String(), true, true), ShouldEqual, ErrPinInValidation)
+		So(h.pin(rejected.String(), true, false), ShouldBeNil)
+	})
+}
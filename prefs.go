@@ -0,0 +1,133 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// prefs implements a standard place for apps to keep their user's settings.  The agent's
+// preferences are a JSON object kept in preferences entries on its source chain, so that they
+// go wherever the chain goes, and each change commits a new entry holding all of them.  The
+// entries are private: they are never published, and other nodes reject them.  A DNA can give
+// a JSON schema that the preferences must match.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"path/filepath"
+	"time"
+)
+
+const PreferencesEntryType = "%preferences"
+
+var ErrPrefInValidation error = errors.New("preferences can't be used during validation")
+var ErrPrefBadKey error = errors.New("preference keys must not be empty")
+var ErrPreferencesPrivate error = errors.New("preferences entries are private to their agent")
+
+// preparePreferences builds the validator of the DNA's preferences schema
+func (h *Holochain) preparePreferences() (err error) {
+	h.prefsDef = &EntryDef{Name: PreferencesEntryType, DataFormat: DataFormatJSON, Schema: h.PreferencesSchema}
+	if h.PreferencesSchema == "" {
+		return
+	}
+	if !fileExists(filepath.Join(h.path, h.PreferencesSchema)) {
+		return errors.New("DNA specified preferences schema file missing: " + h.PreferencesSchema)
+	}
+	if err = h.prefsDef.BuildJSONSchemaValidator(h.path); err != nil {
+		err = fmt.Errorf("bad preferences schema: %v", err)
+	}
+	return
+}
+
+// validatePreferences checks that a preferences entry is a JSON object matching the DNA's
+// preferences schema, and that it wasn't sent by another agent
+func (h *Holochain) validatePreferences(entry Entry, props *ValidationProps) (err error) {
+	if props != nil && len(props.Sources) > 0 && props.Sources[0] != peer.IDB58Encode(h.id) {
+		return ErrPreferencesPrivate
+	}
+	s, ok := entry.Content().(string)
+	if !ok {
+		return errors.New("preferences entry should be a string")
+	}
+	var input interface{}
+	if err = json.Unmarshal([]byte(s), &input); err != nil {
+		return
+	}
+	if _, ok = input.(map[string]interface{}); !ok {
+		return errors.New("preferences should be a JSON object")
+	}
+	if h.prefsDef != nil && h.prefsDef.validator != nil {
+		err = h.prefsDef.validator.Validate(input)
+	}
+	return
+}
+
+// Preferences returns the agent's preferences, as last set
+func (h *Holochain) Preferences() (prefs map[string]json.RawMessage, err error) {
+	prefs = make(map[string]json.RawMessage)
+	_, header := h.chain.TopType(PreferencesEntryType)
+	if header == nil {
+		return
+	}
+	var entry Entry
+	if entry, _, err = h.chain.GetEntry(header.EntryLink); err != nil {
+		return
+	}
+	s, ok := entry.Content().(string)
+	if !ok {
+		err = errors.New("preferences entry should be a string")
+		return
+	}
+	err = json.Unmarshal([]byte(s), &prefs)
+	return
+}
+
+// GetPref returns the JSON of a preference's value, or an empty string if it isn't set
+func (h *Holochain) GetPref(key string) (value string, err error) {
+	var prefs map[string]json.RawMessage
+	if prefs, err = h.Preferences(); err != nil {
+		return
+	}
+	value = string(prefs[key])
+	return
+}
+
+// SetPref sets a preference to a JSON value, or unsets it if the value is null or empty,
+// committing the changed preferences to the chain
+func (h *Holochain) SetPref(key string, value string) (hash Hash, err error) {
+	if key == "" {
+		err = ErrPrefBadKey
+		return
+	}
+	h.chain.prefs.Lock()
+	defer h.chain.prefs.Unlock()
+	var prefs map[string]json.RawMessage
+	if prefs, err = h.Preferences(); err != nil {
+		return
+	}
+	if value == "" || value == "null" {
+		delete(prefs, key)
+	} else {
+		var v interface{}
+		if e := json.Unmarshal([]byte(value), &v); e != nil {
+			err = fmt.Errorf("preference %s is not valid JSON: %v", key, e)
+			return
+		}
+		prefs[key] = json.RawMessage(value)
+	}
+	var b []byte
+	if b, err = json.Marshal(prefs); err != nil {
+		return
+	}
+	e := GobEntry{C: string(b)}
+	if err = h.ValidateEntry(PreferencesEntryType, &e, &ValidationProps{Sources: []string{peer.IDB58Encode(h.id)}}); err != nil {
+		return
+	}
+	var header *Header
+	if _, header, err = h.NewEntry(time.Now(), PreferencesEntryType, &e); err != nil {
+		return
+	}
+	hash = header.EntryLink
+	return
+}
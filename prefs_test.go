@@ -0,0 +1,111 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+)
+
+func TestPreferences(t *testing.T) {
+	d, s, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("unset preferences should be empty", t, func() {
+		prefs, err := h.Preferences()
+		So(err, ShouldBeNil)
+		So(len(prefs), ShouldEqual, 0)
+		value, err := h.GetPref("theme")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "")
+	})
+
+	Convey("it should commit preferences to the chain without publishing them", t, func() {
+		l := h.chain.Length()
+		hash, err := h.SetPref("theme", `"dark"`)
+		So(err, ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+1)
+		_, entryType, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, PreferencesEntryType)
+		So(h.dht.exists(hash), ShouldNotBeNil)
+
+		_, err = h.SetPref("fontSize", `14`)
+		So(err, ShouldBeNil)
+		value, err := h.GetPref("theme")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, `"dark"`)
+		value, err = h.GetPref("fontSize")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, `14`)
+	})
+
+	Convey("it should unset preferences set to null", t, func() {
+		_, err := h.SetPref("fontSize", "null")
+		So(err, ShouldBeNil)
+		prefs, err := h.Preferences()
+		So(err, ShouldBeNil)
+		So(len(prefs), ShouldEqual, 1)
+	})
+
+	Convey("it should reject bad keys and values", t, func() {
+		_, err := h.SetPref("", `1`)
+		So(err, ShouldEqual, ErrPrefBadKey)
+		_, err = h.SetPref("theme", `dark`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("concurrent changes to the preferences should all be kept", t, func() {
+		var wg sync.WaitGroup
+		for _, key := range []string{"a", "b", "c", "d"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				h.SetPref(key, `true`)
+			}(key)
+		}
+		wg.Wait()
+		prefs, err := h.Preferences()
+		So(err, ShouldBeNil)
+		So(len(prefs), ShouldEqual, 5)
+		for _, key := range []string{"a", "b", "c", "d"} {
+			h.SetPref(key, "null")
+		}
+	})
+
+	Convey("preferences should go with the chain", t, func() {
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		value, err := h2.GetPref("theme")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, `"dark"`)
+	})
+
+	Convey("other agents' preferences should be rejected", t, func() {
+		e := GobEntry{C: `{"theme":"light"}`}
+		err := h.ValidateEntry(PreferencesEntryType, &e, &ValidationProps{Sources: []string{"QmOther"}})
+		So(err, ShouldEqual, ErrPreferencesPrivate)
+		e = GobEntry{C: `["theme"]`}
+		err = h.ValidateEntry(PreferencesEntryType, &e, nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("preferences should be checked against the DNA's schema", t, func() {
+		writeFile(h.path, "schema_prefs.json", []byte(`{
+	"type": "object",
+	"properties": {"theme": {"type": "string", "enum": ["dark", "light"]}}
+}`))
+		h.PreferencesSchema = "schema_prefs.json"
+		So(h.preparePreferences(), ShouldBeNil)
+		_, err := h.SetPref("theme", `"light"`)
+		So(err, ShouldBeNil)
+		_, err = h.SetPref("theme", `"plaid"`)
+		So(err, ShouldNotBeNil)
+		value, _ := h.GetPref("theme")
+		So(value, ShouldEqual, `"light"`)
+
+		h.PreferencesSchema = "missing.json"
+		So(h.preparePreferences(), ShouldNotBeNil)
+		h.PreferencesSchema = ""
+		So(h.preparePreferences(), ShouldBeNil)
+	})
+}
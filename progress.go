@@ -0,0 +1,116 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// progress implements reporting how far long running operations like cloning, joining,
+// genesis and fast sync have got, both to a callback as they go and as the latest state of
+// each operation for status displays.
+
+package holochain
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	ProgressClone    = "clone"
+	ProgressJoin     = "join"
+	ProgressGenChain = "genchain"
+	ProgressFastSync = "fastsync"
+	ProgressLoad     = "load"
+	ProgressPublish  = "publish"
+)
+
+// Progress describes how far a long running operation has got
+type Progress struct {
+	Op    string // the operation, e.g. ProgressClone
+	Stage string // what the operation is doing
+	Done  int
+	Total int // 0 if not known
+}
+
+// Percent returns how complete the operation is as a percentage
+func (p Progress) Percent() int {
+	if p.Total <= 0 {
+		return 0
+	}
+	return p.Done * 100 / p.Total
+}
+
+// Finished returns true if the operation has completed
+func (p Progress) Finished() bool {
+	return p.Total > 0 && p.Done >= p.Total
+}
+
+// progressState holds the latest progress of each operation on a chain or service
+type progressState struct {
+	lk  sync.Mutex
+	ops map[string]Progress
+}
+
+func newProgressState() *progressState {
+	return &progressState{ops: make(map[string]Progress)}
+}
+
+// set records the latest progress of an operation
+func (s *progressState) set(p Progress) {
+	s.lk.Lock()
+	if s.ops == nil {
+		s.ops = make(map[string]Progress)
+	}
+	s.ops[p.Op] = p
+	s.lk.Unlock()
+}
+
+// list returns the latest progress of each operation, ordered by operation
+func (s *progressState) list() (ops []Progress) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	var names []string
+	for op := range s.ops {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	for _, op := range names {
+		ops = append(ops, s.ops[op])
+	}
+	return
+}
+
+// progress records the progress of a service operation and reports it to OnProgress, if it is set
+func (s *Service) progress(op string, stage string, done int, total int) {
+	p := Progress{Op: op, Stage: stage, Done: done, Total: total}
+	s.progressOps.set(p)
+	if s.OnProgress != nil {
+		s.OnProgress(p)
+	}
+}
+
+// Progress returns the latest progress of each of the service's operations, like cloning and
+// joining, ordered by operation
+func (s *Service) Progress() []Progress {
+	return s.progressOps.list()
+}
+
+// SetProgress sets a function to be called as long running operations on the chain make
+// progress, which otherwise goes to the OnProgress function of the service that loaded it
+func (h *Holochain) SetProgress(fn func(Progress)) {
+	h.progressFn = fn
+}
+
+// progress records and reports the progress of an operation on the chain
+func (h *Holochain) progress(op string, stage string, done int, total int) {
+	p := Progress{Op: op, Stage: stage, Done: done, Total: total}
+	h.progressState.set(p)
+	if h.progressFn != nil {
+		h.progressFn(p)
+	} else if h.service != nil && h.service.OnProgress != nil {
+		h.service.OnProgress(p)
+	}
+}
+
+// Progress returns the latest progress of each operation on the chain, ordered by operation
+func (h *Holochain) Progress() []Progress {
+	return h.progressState.list()
+}
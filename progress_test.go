@@ -0,0 +1,49 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should calculate percentages", t, func() {
+		So(Progress{Done: 1, Total: 4}.Percent(), ShouldEqual, 25)
+		So(Progress{Done: 1}.Percent(), ShouldEqual, 0)
+		So(Progress{Done: 4, Total: 4}.Finished(), ShouldBeTrue)
+		So(Progress{Done: 3, Total: 4}.Finished(), ShouldBeFalse)
+	})
+
+	Convey("it should report the progress of clones", t, func() {
+		var reports []Progress
+		s.OnProgress = func(p Progress) { reports = append(reports, p) }
+		defer func() { s.OnProgress = nil }()
+		_, err := s.Clone(filepath.Join(s.Path, "test"), filepath.Join(s.Path, "test2"), true)
+		So(err, ShouldBeNil)
+		So(len(reports), ShouldBeGreaterThan, 2)
+		So(reports[0].Op, ShouldEqual, ProgressClone)
+		for i := 1; i < len(reports); i++ {
+			So(reports[i].Done, ShouldBeGreaterThan, reports[i-1].Done)
+		}
+		last := reports[len(reports)-1]
+		So(last.Finished(), ShouldBeTrue)
+		So(last.Percent(), ShouldEqual, 100)
+		So(s.Progress(), ShouldResemble, []Progress{last})
+	})
+
+	Convey("it should report and record the progress of genesis", t, func() {
+		var reports []Progress
+		h.SetProgress(func(p Progress) { reports = append(reports, p) })
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		So(reports, ShouldResemble, []Progress{
+			{Op: ProgressGenChain, Stage: "generating identity", Done: 0, Total: 2},
+			{Op: ProgressGenChain, Stage: "publishing genesis", Done: 1, Total: 2},
+			{Op: ProgressGenChain, Stage: "done", Done: 2, Total: 2},
+		})
+		So(h.Progress(), ShouldResemble, []Progress{reports[2]})
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// provenance implements looking up who authored an entry and who holds it
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var ErrBadSourceHeader error = errors.New("source's header doesn't verify")
+
+// Provenance describes the origin of an entry and the peers known to hold it
+type Provenance struct {
+	Hash    Hash
+	Author  peer.ID   // the node that committed the entry to its source chain
+	Header  *Header   // the header of the entry on the author's chain, nil if it couldn't be retrieved
+	Holders []peer.ID // peers known (from puts and gossip) to hold the entry
+}
+
+// Signature returns the author's signature of the entry's header
+func (p *Provenance) Signature() (sig Signature) {
+	if p.Header != nil {
+		sig = p.Header.Sig
+	}
+	return
+}
+
+// Provenance returns the author, header and known holders of an entry
+func (h *Holochain) Provenance(hash Hash) (p *Provenance, err error) {
+	prov := Provenance{Hash: hash}
+
+	prov.Header, err = h.chain.GetEntryHeader(hash)
+	if err == nil {
+		prov.Author = h.id
+	} else if err != ErrHashNotFound {
+		return
+	} else {
+		prov.Author, err = h.dht.source(hash)
+		if err != nil {
+			return
+		}
+		// if we are on the network ask the author for the header, which may well be offline
+		if h.node != nil {
+			prov.Header, _ = h.getSourceHeader(prov.Author, hash)
+		}
+	}
+
+	prov.Holders, err = h.dht.GetHolders(hash)
+	if err != nil {
+		return
+	}
+	p = &prov
+	return
+}
+
+// SourceHeader is a source's answer to a request for the header of an entry, with the key
+// the header was signed with
+type SourceHeader struct {
+	Header Header
+	PubKey []byte // the source's marshaled public key
+}
+
+// check confirms that the header is of the entry with the hash and was signed by the source
+func (sh *SourceHeader) check(source peer.ID, hash Hash) (err error) {
+	var pub ic.PubKey
+	if pub, err = ic.UnmarshalPublicKey(sh.PubKey); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(pub); err != nil {
+		return
+	}
+	if id != source {
+		err = fmt.Errorf("%v: key isn't the source's", ErrBadSourceHeader)
+		return
+	}
+	if !sh.Header.EntryLink.Equal(&hash) {
+		err = fmt.Errorf("%v: header is of %v not %v", ErrBadSourceHeader, sh.Header.EntryLink, hash)
+		return
+	}
+	ok, err := pub.Verify(sh.Header.EntryLink.H, sh.Header.Sig.S)
+	if err == nil && !ok {
+		err = fmt.Errorf("%v: bad signature", ErrBadSourceHeader)
+	}
+	return
+}
+
+// getSourceHeader asks the source of an entry for its header, checking that it is the
+// header of the entry and signed by the source
+func (h *Holochain) getSourceHeader(source peer.ID, hash Hash) (header *Header, err error) {
+	r, err := h.Send(SourceProtocol, source, SRC_HEADER, hash, SrcReceiver)
+	if err != nil {
+		h.dht.dlog.Logf("unable to get header of %v from %v: %v", hash, source, err)
+		return
+	}
+	var sh SourceHeader
+	switch t := r.(type) {
+	case *SourceHeader:
+		sh = *t
+	case SourceHeader:
+		sh = t
+	default:
+		err = fmt.Errorf("%v: unexpected response type %T", ErrBadSourceHeader, r)
+		return
+	}
+	if err = sh.check(source, hash); err != nil {
+		h.dht.dlog.Logf("header of %v from %v rejected: %v", hash, source, err)
+		return
+	}
+	header = &sh.Header
+	return
+}
@@ -0,0 +1,61 @@
+package holochain
+
+import (
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProvenance(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should fail for unknown hashes", t, func() {
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat6x5HEhc1TVGs11tmfNSzkqh2")
+		_, err := h.Provenance(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should return the author and header of entries on our chain", t, func() {
+		entry := GobEntry{C: "some data"}
+		_, hd, err := h.NewEntry(time.Now(), "myData", &entry)
+		So(err, ShouldBeNil)
+		p, err := h.Provenance(hd.EntryLink)
+		So(err, ShouldBeNil)
+		So(p.Author, ShouldEqual, h.id)
+		So(fmt.Sprintf("%v", p.Header), ShouldEqual, fmt.Sprintf("%v", hd))
+		So(fmt.Sprintf("%v", p.Signature()), ShouldEqual, fmt.Sprintf("%v", hd.Sig))
+		So(len(p.Holders), ShouldEqual, 0)
+	})
+
+	Convey("it should return the source and holders of entries in the DHT", t, func() {
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		err := h.dht.put(nil, "someType", hash, h.id, []byte("some value"), LIVE)
+		So(err, ShouldBeNil)
+		other, _ := makePeer("other")
+		err = h.dht.AddHolder(hash, other)
+		So(err, ShouldBeNil)
+
+		p, err := h.Provenance(hash)
+		So(err, ShouldBeNil)
+		So(p.Author, ShouldEqual, h.id)
+		So(p.Header, ShouldBeNil) // it's not actually on the source's chain
+		So(len(p.Holders), ShouldEqual, 2)
+		So(p.Holders, ShouldContain, h.id)
+		So(p.Holders, ShouldContain, other)
+	})
+}
+
+func makePeer(id string) (pid peer.ID, err error) {
+	r := strings.NewReader(id + "1234567890123456789012345678901234567890")
+	key, _, err := ic.GenerateEd25519Key(r)
+	if err != nil {
+		return
+	}
+	pid, err = peer.IDFromPrivateKey(key)
+	return
+}
@@ -0,0 +1,216 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// pruning implements retention policies for high-churn entry types, e.g. presence or telemetry,
+// so that the local chain's storage stays bounded.  An entry type's Retention keeps its latest
+// entries, or those made in the last days, and compacting the chain replaces the content of the
+// others with a marker of when it was pruned.  The headers are all kept, so the chain still
+// verifies, and entries are only pruned once their puts have been acknowledged by the DHT.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	DefaultCompactInterval = time.Hour
+	CompactSuffix          = ".compact"
+)
+
+var ErrEntryPruned error = errors.New("entry pruned from the local chain")
+
+// Retention is an entry type's policy for keeping entries on the local chain.  Entries are
+// kept if they are among the KeepLast latest of their type or were made in the last KeepDays
+// days; zero leaves that rule out.
+type Retention struct {
+	KeepLast int `json:",omitempty" toml:",omitempty"`
+	KeepDays int `json:",omitempty" toml:",omitempty"`
+}
+
+// PrunedEntry is the content left in place of a pruned entry
+type PrunedEntry struct {
+	Pruned time.Time
+}
+
+// CompactReport describes what compacting the chain pruned
+type CompactReport struct {
+	Pruned    int   // entries whose content was pruned
+	Reclaimed int64 // bytes the chain's file shrank by
+}
+
+// Check makes sure a retention policy keeps something
+func (r *Retention) Check() (err error) {
+	if r.KeepLast < 0 || r.KeepDays < 0 {
+		err = errors.New("retention can't be negative")
+	} else if r.KeepLast == 0 && r.KeepDays == 0 {
+		err = errors.New("retention must set KeepLast or KeepDays")
+	}
+	return
+}
+
+// keeps returns true if the policy keeps the nth latest entry of its type, made at t
+func (r *Retention) keeps(nth int, t time.Time, now time.Time) bool {
+	return (r.KeepLast > 0 && nth <= r.KeepLast) ||
+		(r.KeepDays > 0 && now.Sub(t) < time.Duration(r.KeepDays)*24*time.Hour)
+}
+
+// isPruned returns true if the entry's content has been pruned
+func isPruned(e Entry) bool {
+	_, ok := e.Content().(PrunedEntry)
+	return ok
+}
+
+// retained returns true if the entry type has a retention policy, so its entries may be pruned
+func (h *Holochain) retained(entryType string) bool {
+	_, d, err := h.GetEntryDef(entryType)
+	return err == nil && d.Retention != nil
+}
+
+// ValidateChain confirms the hashes of the chain, allowing entries of types with a retention
+// policy to have been pruned
+func (h *Holochain) ValidateChain() (err error) {
+	err = h.chain.validate(h.hashSpec, h.retained)
+	return
+}
+
+// prunable returns the indexes of the entries the entry types' retention policies no longer
+// keep, skipping those already pruned and those whose puts haven't been acknowledged
+func (h *Holochain) prunable(now time.Time) (prune []int, err error) {
+	var pubs []Publication
+	if pubs, err = h.dht.Outbox(); err != nil {
+		return
+	}
+	unpublished := make(map[string]bool)
+	for _, p := range pubs {
+		if p.Type == PUT_REQUEST {
+			unpublished[p.Put.H.String()] = true
+		}
+	}
+	c := h.chain
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	seen := make(map[string]int)
+	for i := len(c.Headers) - 1; i >= 0; i-- {
+		hd := c.Headers[i]
+		_, d, e := h.GetEntryDef(hd.Type)
+		if e != nil || d.Retention == nil {
+			continue
+		}
+		seen[hd.Type]++
+		if d.Retention.keeps(seen[hd.Type], hd.Time, now) || isPruned(c.Entries[i]) || unpublished[hd.EntryLink.String()] {
+			continue
+		}
+		prune = append(prune, i)
+	}
+	return
+}
+
+// prune replaces the content of the entries at the given indexes and rewrites the chain's
+// file.  The entries are replaced with a pruned copy once the file is rewritten, rather than
+// changed in place, so that snapshots of the chain taken before aren't changed under readers.
+func (c *Chain) prune(indexes []int, now time.Time) (reclaimed int64, err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	entries := append([]Entry{}, c.Entries...)
+	for _, i := range indexes {
+		entries[i] = &GobEntry{C: PrunedEntry{Pruned: now}}
+	}
+	if c.s == nil {
+		c.Entries = entries
+		return
+	}
+	var before os.FileInfo
+	if before, err = c.s.Stat(); err != nil {
+		return
+	}
+	if err = c.rewrite(c.Headers, entries); err != nil {
+		return
+	}
+	c.Entries = entries
+	var after os.FileInfo
+	if after, err = c.s.Stat(); err != nil {
+		return
+	}
+	reclaimed = before.Size() - after.Size()
+	return
+}
+
+// rewrite replaces the chain's file with one holding the given pairs.  The pairs are written
+// to a temporary file that is synced and then renamed over the old one, so that a crash part
+// way through leaves the old file whole.  The entries still in the file are pointed at the new
+// one.  It must be called holding the chain's lock.
+func (c *Chain) rewrite(headers []*Header, entries []Entry) (err error) {
+	path := c.s.Name()
+	tmp := path + CompactSuffix
+	var f *os.File
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+		return
+	}
+	if c.crypt != nil {
+		_, err = f.Write(c.crypt.header)
+	}
+	for j := 0; err == nil && j < len(headers); j++ {
+		err = c.writePair(f, headers[j], entries[j])
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	c.s.Close()
+	if err = os.Rename(tmp, path); err != nil {
+		return
+	}
+	if err = c.reopenStored(path, entries); err != nil {
+		return
+	}
+	c.s, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	return
+}
+
+// HasRetention returns true if any entry type of the DNA has a retention policy
+func (h *Holochain) HasRetention() bool {
+	for _, z := range h.Zomes {
+		for _, d := range z.Entries {
+			if d.Retention != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CompactChain prunes the entries the entry types' retention policies no longer keep
+func (h *Holochain) CompactChain() (r CompactReport, err error) {
+	now := time.Now()
+	var prune []int
+	if prune, err = h.prunable(now); err != nil || len(prune) == 0 {
+		return
+	}
+	if r.Reclaimed, err = h.chain.prune(prune, now); err != nil {
+		err = fmt.Errorf("compacting chain: %v", err)
+		return
+	}
+	r.Pruned = len(prune)
+	return
+}
+
+// CompactEvery compacts the chain every interval
+func (h *Holochain) CompactEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if r, err := h.CompactChain(); err != nil {
+			h.dht.dlog.Logf("compaction error: %v", err)
+		} else if r.Pruned > 0 {
+			Infof("%s: pruned %d entries, reclaiming %d bytes", h.Name, r.Pruned, r.Reclaimed)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	Convey("it should check the policy keeps something", t, func() {
+		So((&Retention{KeepLast: 2}).Check(), ShouldBeNil)
+		So((&Retention{}).Check().Error(), ShouldEqual, "retention must set KeepLast or KeepDays")
+		So((&Retention{KeepDays: -1}).Check().Error(), ShouldEqual, "retention can't be negative")
+	})
+
+	Convey("it should keep the latest entries or the recent ones", t, func() {
+		now := time.Now()
+		old := now.Add(-72 * time.Hour)
+		r := Retention{KeepLast: 2}
+		So(r.keeps(2, old, now), ShouldBeTrue)
+		So(r.keeps(3, now, now), ShouldBeFalse)
+		r = Retention{KeepDays: 2}
+		So(r.keeps(10, now, now), ShouldBeTrue)
+		So(r.keeps(1, old, now), ShouldBeFalse)
+		r = Retention{KeepLast: 1, KeepDays: 2}
+		So(r.keeps(1, old, now), ShouldBeTrue)
+		So(r.keeps(5, now, now), ShouldBeTrue)
+		So(r.keeps(5, old, now), ShouldBeFalse)
+	})
+}
+
+func TestCompactChain(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	z, def, _ := h.GetEntryDef("profile")
+	def.Retention = &Retention{KeepLast: 1}
+	z.Entries["profile"] = *def
+	dht := h.dht
+	publish := func() {
+		for len(dht.puts) > 0 {
+			<-dht.puts
+		}
+		dht.Republish()
+	}
+
+	var hashes []Hash
+	for _, name := range []string{"Art", "Eric", "Jean"} {
+		hash, err := h.Commit("profile", `{"firstName":"`+name+`","lastName":"H"}`)
+		if err != nil {
+			panic(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	publish()
+
+	Convey("it should prune all but the kept entries, keeping their headers", t, func() {
+		So(h.HasRetention(), ShouldBeTrue)
+		l := h.chain.Length()
+		r, err := h.CompactChain()
+		So(err, ShouldBeNil)
+		So(r.Pruned, ShouldEqual, 2)
+		So(h.chain.Length(), ShouldEqual, l)
+		_, _, err = h.chain.GetEntry(hashes[0])
+		So(err, ShouldEqual, ErrEntryPruned)
+		e, _, err := h.chain.GetEntry(hashes[2])
+		So(err, ShouldBeNil)
+		So(e.Content(), ShouldContainSubstring, "Jean")
+		So(h.ValidateChain(), ShouldBeNil)
+		So(h.chain.Validate(h.hashSpec).Error(), ShouldStartWith, "entry hash mismatch")
+		def.Retention = nil
+		z.Entries["profile"] = *def
+		So(h.ValidateChain().Error(), ShouldStartWith, "entry hash mismatch")
+		def.Retention = &Retention{KeepLast: 1}
+		z.Entries["profile"] = *def
+
+		r, err = h.CompactChain()
+		So(err, ShouldBeNil)
+		So(r.Pruned, ShouldEqual, 0)
+	})
+
+	Convey("the compacted chain should load and keep appending", t, func() {
+		_, err := h.Commit("profile", `{"firstName":"Zoe","lastName":"H"}`)
+		So(err, ShouldBeNil)
+		c, err := NewChainFromFile(h.hashSpec, h.path+"/"+StoreFileName+".dat")
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, h.chain.Length())
+		So(c.validate(h.hashSpec, h.retained), ShouldBeNil)
+		e, entryType, err := c.GetEntry(hashes[1])
+		So(err, ShouldEqual, ErrEntryPruned)
+		So(e, ShouldBeNil)
+		So(entryType, ShouldEqual, "profile")
+		c.Close()
+	})
+
+	Convey("it shouldn't prune entries whose puts are still in the outbox", t, func() {
+		h.config.Offline = true
+		unpublished, err := h.Commit("profile", `{"firstName":"Ann","lastName":"H"}`)
+		So(err, ShouldBeNil)
+		_, err = h.Commit("profile", `{"firstName":"Bob","lastName":"H"}`)
+		So(err, ShouldBeNil)
+		_, err = h.CompactChain()
+		So(err, ShouldBeNil)
+		_, _, err = h.chain.GetEntry(unpublished)
+		So(err, ShouldBeNil)
+	})
+}
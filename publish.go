@@ -0,0 +1,198 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// publish implements tracking of authored puts to the DHT so that those that weren't
+// acknowledged (i.e. because the node was offline) can be re-published later
+
+package holochain
+
+import (
+	"github.com/tidwall/buntdb"
+	"time"
+)
+
+const (
+	DefaultRepublishInterval = 5 * time.Minute
+	PublicationRetention     = 24 * time.Hour // how long the records of acknowledged publications are kept
+)
+
+// Publication records a put or putmeta made by this node and whether it has been acknowledged
+type Publication struct {
+	Type  MsgType // PUT_REQUEST or PUTMETA_REQUEST
+	Put   PutReq
+	Meta  MetaReq
+	Acked bool
+	Tries int
+	Last  time.Time
+	Seq   int64 // order in which the publication was first made
+}
+
+// key returns the key under which the publication is stored in the DHT db
+func (p *Publication) key() string {
+	if p.Type == PUTMETA_REQUEST {
+		return "pub:" + p.Meta.O.String() + ":" + p.Meta.M.String() + ":" + p.Meta.T
+	}
+	return "pub:" + p.Put.H.String()
+}
+
+// savePublication stores the publication record, numbering it if it is new so that the
+// outbox can be published in the order it was made
+func (dht *DHT) savePublication(p *Publication) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if p.Seq == 0 {
+			if err := nextPublicationSeq(tx, p); err != nil {
+				return err
+			}
+		}
+		b, err := ByteEncoder(p)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(p.key(), string(b), nil)
+		return err
+	})
+	return
+}
+
+// publish sends the put or putmeta of a publication and records whether it was acknowledged
+func (dht *DHT) publish(p *Publication) (err error) {
+	var key Hash
+	var body interface{}
+	if p.Type == PUTMETA_REQUEST {
+		key = p.Meta.O
+		body = p.Meta
+	} else {
+		key = p.Put.H
+		body = p.Put
+	}
+	if dht.h.Offline() {
+		// leave it in the outbox until we are back online
+		dht.queued()
+		if e := dht.savePublication(p); e != nil {
+			dht.dlog.Logf("unable to save publication record: %v", e)
+		}
+		return
+	}
+	var n *Node
+	n, err = dht.FindNodeForHash(key)
+	if err == nil {
+		_, err = dht.send(n.HashAddr, p.Type, body)
+	}
+	p.Tries++
+	p.Last = time.Now()
+	p.Acked = err == nil
+	if !p.Acked {
+		dht.queued()
+	}
+	if e := dht.savePublication(p); e != nil {
+		dht.dlog.Logf("unable to save publication record: %v", e)
+	}
+	return
+}
+
+// Publications returns the publication records, optionally only those not yet acknowledged
+func (dht *DHT) Publications(unackedOnly bool) (pubs []Publication, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("pub:*", func(k, v string) bool {
+			var p Publication
+			e = ByteDecoder([]byte(v), &p)
+			if e != nil {
+				return false
+			}
+			if !unackedOnly || !p.Acked {
+				pubs = append(pubs, p)
+			}
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// Republish re-sends the unacknowledged publications in the order they were made, returning
+// how many were acknowledged this time and how many remain unacknowledged.  Publications that
+// fail stay in the outbox to be retried next time, and links on or to an entry whose put
+// failed are held back with it, so that they don't overtake it.  It does nothing while the
+// node is offline.
+func (dht *DHT) Republish() (acked int, pending int, err error) {
+	dht.republishing.Lock()
+	defer dht.republishing.Unlock()
+	var pubs []Publication
+	pubs, err = dht.Outbox()
+	if err != nil {
+		return
+	}
+	pending = len(pubs)
+	if !dht.h.Offline() {
+		dht.sent()
+		failed := make(map[string]bool)
+		for i := range pubs {
+			if len(pubs) > 1 {
+				dht.h.progress(ProgressPublish, "publishing outbox", i, len(pubs))
+			}
+			p := &pubs[i]
+			if p.Type == PUTMETA_REQUEST && (failed[p.Meta.O.String()] || failed[p.Meta.M.String()]) {
+				continue
+			}
+			if e := dht.publish(p); e != nil {
+				dht.dlog.Logf("republish failed (try %d): %v", p.Tries, e)
+				if p.Type != PUTMETA_REQUEST {
+					failed[p.Put.H.String()] = true
+				}
+				continue
+			}
+			acked++
+			pending--
+		}
+		if len(pubs) > 1 {
+			dht.h.progress(ProgressPublish, "publishing outbox", acked, len(pubs))
+		}
+	}
+	dht.h.metrics.Set("publish.pending", int64(pending))
+	return
+}
+
+// PrunePublications removes the records of publications acknowledged longer ago than the
+// retention, returning how many were removed.  Unacknowledged publications are kept until
+// they are acknowledged.
+func (dht *DHT) PrunePublications(retention time.Duration) (pruned int, err error) {
+	cutoff := time.Now().Add(-retention)
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		var keys []string
+		var e error
+		tx.AscendKeys("pub:*", func(k, v string) bool {
+			var p Publication
+			if e = ByteDecoder([]byte(v), &p); e != nil {
+				return false
+			}
+			if p.Acked && p.Last.Before(cutoff) {
+				keys = append(keys, k)
+			}
+			return true
+		})
+		if e != nil {
+			return e
+		}
+		pruned = len(keys)
+		_, e = deleteKeys(tx, keys...)
+		return e
+	})
+	return
+}
+
+// RepublishEvery re-publishes unacknowledged publications every interval, and prunes the
+// records of old acknowledged ones
+func (dht *DHT) RepublishEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		_, _, err := dht.Republish()
+		if err != nil {
+			dht.dlog.Logf("republish error: %v", err)
+		}
+		if _, err = dht.PrunePublications(PublicationRetention); err != nil {
+			dht.dlog.Logf("publication pruning error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestRepublish(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	// make sure any puts from genesis are out of the way
+	for len(h.dht.puts) > 0 {
+		<-h.dht.puts
+	}
+	h.dht.Republish()
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	Convey("it should record acknowledged puts", t, func() {
+		err := h.dht.SendPut(hash)
+		So(err, ShouldBeNil)
+		pubs, err := h.dht.Publications(false)
+		So(err, ShouldBeNil)
+		var found bool
+		for _, p := range pubs {
+			if p.Put.H.String() == hash.String() {
+				found = true
+				So(p.Acked, ShouldBeTrue)
+				So(p.Tries, ShouldEqual, 1)
+			}
+		}
+		So(found, ShouldBeTrue)
+		pubs, err = h.dht.Publications(true)
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldEqual, 0)
+	})
+
+	Convey("it should record unacknowledged puts", t, func() {
+		// fill the put queue so the put gets dropped
+		for len(h.dht.puts) < cap(h.dht.puts) {
+			h.dht.puts <- h.node.NewMessage(PUT_REQUEST, PutReq{H: hash})
+		}
+		req := MetaReq{O: hash, M: hash, T: "someTag"}
+		err := h.dht.SendPutMeta(req)
+		So(err, ShouldEqual, ErrDHTPutQueueFull)
+		pubs, err := h.dht.Publications(true)
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldEqual, 1)
+		So(pubs[0].Type, ShouldEqual, PUTMETA_REQUEST)
+		So(pubs[0].Meta.T, ShouldEqual, "someTag")
+		So(pubs[0].Tries, ShouldEqual, 1)
+		So(pubs[0].Last.Before(time.Now()), ShouldBeTrue)
+
+		acked, pending, err := h.dht.Republish()
+		So(err, ShouldBeNil)
+		So(acked, ShouldEqual, 0)
+		So(pending, ShouldEqual, 1)
+		So(h.Metrics().Get("publish.pending"), ShouldEqual, 1)
+	})
+
+	Convey("it should republish unacknowledged puts", t, func() {
+		for len(h.dht.puts) > 0 {
+			<-h.dht.puts
+		}
+		acked, pending, err := h.dht.Republish()
+		So(err, ShouldBeNil)
+		So(acked, ShouldEqual, 1)
+		So(pending, ShouldEqual, 0)
+		pubs, err := h.dht.Publications(true)
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldEqual, 0)
+	})
+	Convey("it should prune the records of old acknowledged publications", t, func() {
+		pubs, err := h.dht.Publications(false)
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldBeGreaterThan, 0)
+		pruned, err := h.dht.PrunePublications(time.Hour)
+		So(err, ShouldBeNil)
+		So(pruned, ShouldEqual, 0)
+		pruned, err = h.dht.PrunePublications(0)
+		So(err, ShouldBeNil)
+		So(pruned, ShouldEqual, len(pubs))
+		pubs, err = h.dht.Publications(false)
+		So(err, ShouldBeNil)
+		So(len(pubs), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// puzzle implements the join puzzles an open chain's DNA can declare to make throwaway agents
+// costly.  An agent solves the puzzle at genesis, either by finding a memory-hard proof of
+// work or by presenting an invite from one of the DNA's inviters.  The solution is kept in
+// its agent entry and sent along with its entries, and DHT nodes refuse the entries of agents
+// without a solution.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"golang.org/x/crypto/argon2"
+	"strconv"
+)
+
+const (
+	// kinds of join puzzle
+	PuzzleWork   = "work"   // a memory-hard proof of work
+	PuzzleInvite = "invite" // an invite signed by one of the DNA's inviters
+
+	DefaultPuzzleDifficulty = 12   // leading zero bits
+	DefaultPuzzleMemory     = 1024 // KiB used by each attempt
+	MaxPuzzleMemory         = 256 * 1024
+
+	ProgressJoinPuzzle = "join puzzle"
+)
+
+var ErrNoJoinProof error = errors.New("agent hasn't solved the chain's join puzzle")
+var ErrBadJoinProof error = errors.New("agent's join puzzle solution doesn't verify")
+var ErrNoInvite error = errors.New("chain requires an invite to join")
+
+// JoinPuzzle declares in the DNA what an agent must do to join the chain
+type JoinPuzzle struct {
+	Kind       string
+	Difficulty *int     `json:",omitempty" toml:",omitempty"` // leading zero bits the work must find, DefaultPuzzleDifficulty if not set
+	Memory     int      `json:",omitempty" toml:",omitempty"` // KiB each attempt at the work uses, DefaultPuzzleMemory if not set
+	Inviters   []string `json:",omitempty" toml:",omitempty"` // node ids of the agents whose invites are accepted
+}
+
+// JoinProof is an agent's solution to the join puzzle, kept in its agent entry
+type JoinProof struct {
+	Nonce  uint64  // for work, the nonce that solves it
+	Invite *Invite // for invites, the invite the agent was given
+}
+
+// Invite is an inviter's permission for an agent to join a chain
+type Invite struct {
+	Inviter string // node id of the inviter
+	PubKey  []byte // marshaled public key of the inviter
+	Sig     []byte // signature of the invited agent's node id and the DNA hash
+}
+
+// difficulty returns the leading zero bits the work must find.  It is only defaulted when not
+// set at all, so that a DNA can set it to 0 to ask for no work.
+func (p *JoinPuzzle) difficulty() int {
+	if p.Difficulty == nil {
+		return DefaultPuzzleDifficulty
+	}
+	return *p.Difficulty
+}
+
+func (p *JoinPuzzle) memory() int {
+	if p.Memory == 0 {
+		return DefaultPuzzleMemory
+	}
+	return p.Memory
+}
+
+// Check confirms that the puzzle is one that is known and can be solved
+func (p *JoinPuzzle) Check() (err error) {
+	switch p.Kind {
+	case PuzzleWork:
+		if d := p.difficulty(); d < 0 || d > 64 {
+			err = fmt.Errorf("join puzzle difficulty must be from 0 to 64 bits, got: %d", d)
+		} else if m := p.memory(); m < 1 || m > MaxPuzzleMemory {
+			err = fmt.Errorf("join puzzle memory must be from 1 to %d KiB, got: %d", MaxPuzzleMemory, m)
+		}
+	case PuzzleInvite:
+		if len(p.Inviters) == 0 {
+			err = errors.New("invite join puzzle declared without any inviters")
+			return
+		}
+		for _, i := range p.Inviters {
+			if _, e := peer.IDB58Decode(i); e != nil {
+				err = fmt.Errorf("bad inviter %s: %v", i, e)
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown join puzzle: %s", p.Kind)
+	}
+	return
+}
+
+// puzzleSalt is the argon2 salt of the work, which is already bound to the chain and agent by
+// its input
+var puzzleSalt = []byte("holochain join puzzle")
+
+// memoryHash hashes input with argon2id so that computing it needs kib KiB of memory
+func memoryHash(input []byte, kib int) []byte {
+	return argon2.IDKey(input, puzzleSalt, 1, uint32(kib), 1, 32)
+}
+
+// leadingZeros returns the number of leading zero bits of b
+func leadingZeros(b []byte) (n int) {
+	for _, c := range b {
+		if c == 0 {
+			n += 8
+			continue
+		}
+		for c&0x80 == 0 {
+			n++
+			c <<= 1
+		}
+		break
+	}
+	return
+}
+
+// workInput returns what an agent's proof of work hashes, binding it to the agent and chain
+func workInput(dna Hash, id peer.ID, nonce uint64) []byte {
+	return []byte(dna.String() + ":" + peer.IDB58Encode(id) + ":" + strconv.FormatUint(nonce, 10))
+}
+
+// solve finds a proof of work for the agent
+func (p *JoinPuzzle) solve(h *Holochain) (proof *JoinProof) {
+	difficulty, memory := p.difficulty(), p.memory()
+	if difficulty == 0 {
+		h.progress(ProgressJoinPuzzle, "solved", 1, 1)
+		return &JoinProof{}
+	}
+	expected := 1 << uint(difficulty)
+	for nonce := uint64(0); ; nonce++ {
+		if leadingZeros(memoryHash(workInput(h.dnaHash, h.id, nonce), memory)) >= difficulty {
+			h.progress(ProgressJoinPuzzle, "solved", 1, 1)
+			return &JoinProof{Nonce: nonce}
+		}
+		if nonce%64 == 0 {
+			// the work has no fixed end, so progress is against the expected number of attempts
+			done := int(nonce)
+			if done >= expected {
+				done = expected - 1
+			}
+			h.progress(ProgressJoinPuzzle, "working", done, expected)
+		}
+	}
+}
+
+// inviteSigned returns what an inviter signs to invite an agent to a chain
+func inviteSigned(dna Hash, id peer.ID) []byte {
+	return []byte("invite:" + dna.String() + ":" + peer.IDB58Encode(id))
+}
+
+// NewInvite returns an invite, signed by this chain's agent, for another agent to join
+func (h *Holochain) NewInvite(invitee string) (token string, err error) {
+	var id peer.ID
+	if id, err = peer.IDB58Decode(invitee); err != nil {
+		return
+	}
+	i := Invite{Inviter: peer.IDB58Encode(h.id)}
+	if i.PubKey, err = ic.MarshalPublicKey(h.agent.PubKey()); err != nil {
+		return
+	}
+	if i.Sig, err = h.agent.PrivKey().Sign(inviteSigned(h.dnaHash, id)); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(i); err != nil {
+		return
+	}
+	token = b58.Encode(b)
+	return
+}
+
+// SetInvite sets the invite the agent presents to join the chain at genesis
+func (h *Holochain) SetInvite(token string) (err error) {
+	var i Invite
+	if err = json.Unmarshal(b58.Decode(token), &i); err != nil {
+		err = fmt.Errorf("bad invite: %v", err)
+		return
+	}
+	h.invite = &i
+	return
+}
+
+// joinProof solves the DNA's join puzzle, if it declares one, for the agent's genesis
+func (h *Holochain) joinProof() (proof *JoinProof, err error) {
+	p := h.JoinPuzzle
+	if p == nil {
+		return
+	}
+	switch p.Kind {
+	case PuzzleWork:
+		proof = p.solve(h)
+	case PuzzleInvite:
+		if h.invite == nil {
+			// inviters don't need an invite, so they invite themselves
+			var token string
+			if token, err = h.NewInvite(peer.IDB58Encode(h.id)); err != nil {
+				return
+			}
+			if err = h.SetInvite(token); err != nil {
+				return
+			}
+		}
+		proof = &JoinProof{Invite: h.invite}
+	}
+	if err = h.verifyJoin(h.id, proof); err != nil {
+		err = fmt.Errorf("can't join: %v", err)
+	}
+	return
+}
+
+// canJoin checks before genesis that the agent has what it needs to solve the join puzzle and
+// to assert its identity
+func (h *Holochain) canJoin() (err error) {
+	if h.Identity != nil && h.identityProvider() == nil {
+		err = ErrNoIdentityProvider
+		return
+	}
+	p := h.JoinPuzzle
+	if p == nil || p.Kind != PuzzleInvite || h.invite != nil {
+		return
+	}
+	id := peer.IDB58Encode(h.id)
+	for _, i := range p.Inviters {
+		if i == id {
+			return
+		}
+	}
+	err = ErrNoInvite
+	return
+}
+
+// agentJoinProof returns the join puzzle solution in the agent entry of the chain
+func (h *Holochain) agentJoinProof() *JoinProof {
+	i, ok := h.chain.TypeTops[AgentEntryType]
+	if !ok {
+		return nil
+	}
+	if a, ok := h.chain.Entries[i].Content().(AgentEntry); ok {
+		return a.Join
+	}
+	return nil
+}
+
+// verify checks that the invite is from one of the puzzle's inviters and is for the agent
+func (i *Invite) verify(p *JoinPuzzle, dna Hash, id peer.ID) (err error) {
+	inviter := false
+	for _, n := range p.Inviters {
+		if n == i.Inviter {
+			inviter = true
+		}
+	}
+	if !inviter {
+		err = fmt.Errorf("%s isn't one of the chain's inviters", i.Inviter)
+		return
+	}
+	var key ic.PubKey
+	if key, err = ic.UnmarshalPublicKey(i.PubKey); err != nil {
+		return
+	}
+	var pid peer.ID
+	if pid, err = peer.IDB58Decode(i.Inviter); err != nil {
+		return
+	}
+	if !pid.MatchesPublicKey(key) {
+		err = fmt.Errorf("invite from %s signed with another key", i.Inviter)
+		return
+	}
+	if ok, e := key.Verify(inviteSigned(dna, id), i.Sig); e != nil || !ok {
+		err = ErrBadJoinProof
+	}
+	return
+}
+
+// verifyJoin checks an agent's solution to the join puzzle
+func (h *Holochain) verifyJoin(id peer.ID, proof *JoinProof) (err error) {
+	p := h.JoinPuzzle
+	if p == nil {
+		return
+	}
+	if proof == nil {
+		err = ErrNoJoinProof
+		return
+	}
+	switch p.Kind {
+	case PuzzleWork:
+		if d := p.difficulty(); d > 0 && leadingZeros(memoryHash(workInput(h.dnaHash, id, proof.Nonce), p.memory())) < d {
+			err = ErrBadJoinProof
+		}
+	case PuzzleInvite:
+		if proof.Invite == nil {
+			err = ErrNoJoinProof
+			return
+		}
+		err = proof.Invite.verify(p, h.dnaHash, id)
+	default:
+		err = fmt.Errorf("unknown join puzzle: %s", p.Kind)
+	}
+	return
+}
+
+func joinKey(id peer.ID) string {
+	return "join:" + peer.IDB58Encode(id)
+}
+
+// checkJoin confirms that the source of a put has solved the join puzzle, remembering
+// sources that have so that the work isn't verified again
+func (dht *DHT) checkJoin(from peer.ID, proof *JoinProof) (err error) {
+	if dht.h.JoinPuzzle == nil {
+		return
+	}
+	var joined bool
+	dht.db.View(func(tx *buntdb.Tx) error {
+		_, e := tx.Get(joinKey(from))
+		joined = e == nil
+		return nil
+	})
+	if joined {
+		return
+	}
+	if err = dht.h.verifyJoin(from, proof); err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(joinKey(from), "", nil)
+		return err
+	})
+	return
+}
@@ -0,0 +1,111 @@
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func bits(n int) *int {
+	return &n
+}
+
+func TestJoinPuzzleCheck(t *testing.T) {
+	Convey("it should check join puzzles", t, func() {
+		So((&JoinPuzzle{Kind: PuzzleWork}).Check(), ShouldBeNil)
+		So((&JoinPuzzle{Kind: "captcha"}).Check().Error(), ShouldEqual, "unknown join puzzle: captcha")
+		So((&JoinPuzzle{Kind: PuzzleWork, Difficulty: bits(65)}).Check().Error(), ShouldEqual, "join puzzle difficulty must be from 0 to 64 bits, got: 65")
+		So((&JoinPuzzle{Kind: PuzzleWork, Memory: -1}).Check().Error(), ShouldEqual, "join puzzle memory must be from 1 to 262144 KiB, got: -1")
+		So((&JoinPuzzle{Kind: PuzzleInvite}).Check().Error(), ShouldEqual, "invite join puzzle declared without any inviters")
+	})
+
+	Convey("it should count leading zero bits", t, func() {
+		So(leadingZeros([]byte{0xff}), ShouldEqual, 0)
+		So(leadingZeros([]byte{0, 0x10}), ShouldEqual, 11)
+		So(leadingZeros([]byte{0, 0}), ShouldEqual, 16)
+	})
+
+	Convey("memory hashes should depend on the input and memory", t, func() {
+		a := memoryHash([]byte("input"), 16)
+		So(len(a), ShouldEqual, 32)
+		So(memoryHash([]byte("input"), 16), ShouldResemble, a)
+		So(memoryHash([]byte("input"), 32), ShouldNotResemble, a)
+		So(memoryHash([]byte("other"), 16), ShouldNotResemble, a)
+	})
+}
+
+func TestWorkPuzzle(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.JoinPuzzle = &JoinPuzzle{Kind: PuzzleWork, Difficulty: bits(6), Memory: 16}
+	if _, err := h.GenChain(); err != nil {
+		panic(err)
+	}
+
+	Convey("genesis should solve the puzzle in the agent entry", t, func() {
+		proof := h.agentJoinProof()
+		So(proof, ShouldNotBeNil)
+		So(h.verifyJoin(h.id, proof), ShouldBeNil)
+		So(h.verifyJoin(h.id, nil), ShouldEqual, ErrNoJoinProof)
+
+		bad := JoinProof{Nonce: proof.Nonce + 1}
+		for leadingZeros(memoryHash(workInput(h.dnaHash, h.id, bad.Nonce), 16)) >= 6 {
+			bad.Nonce++
+		}
+		So(h.verifyJoin(h.id, &bad), ShouldEqual, ErrBadJoinProof)
+	})
+
+	Convey("a difficulty of 0 should ask for no work", t, func() {
+		h.JoinPuzzle = &JoinPuzzle{Kind: PuzzleWork, Difficulty: bits(0), Memory: 16}
+		So(h.JoinPuzzle.Check(), ShouldBeNil)
+		So(h.verifyJoin(h.id, h.JoinPuzzle.solve(h)), ShouldBeNil)
+		h.JoinPuzzle = &JoinPuzzle{Kind: PuzzleWork, Memory: 16}
+		So(h.JoinPuzzle.difficulty(), ShouldEqual, DefaultPuzzleDifficulty)
+	})
+}
+
+func TestInvitePuzzle(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	other, _ := makePeer("other")
+
+	Convey("agents that aren't inviters should need an invite", t, func() {
+		h.JoinPuzzle = &JoinPuzzle{Kind: PuzzleInvite, Inviters: []string{peer.IDB58Encode(other)}}
+		_, err := h.GenChain()
+		So(err, ShouldEqual, ErrNoInvite)
+	})
+
+	h.JoinPuzzle = &JoinPuzzle{Kind: PuzzleInvite, Inviters: []string{peer.IDB58Encode(h.id)}}
+	if _, err := h.GenChain(); err != nil {
+		panic(err)
+	}
+	if err := h.Activate(); err != nil {
+		panic(err)
+	}
+
+	Convey("inviters should invite themselves", t, func() {
+		proof := h.agentJoinProof()
+		So(proof.Invite.Inviter, ShouldEqual, peer.IDB58Encode(h.id))
+		So(h.verifyJoin(h.id, proof), ShouldBeNil)
+	})
+
+	Convey("invites should only be good for the invited agent", t, func() {
+		token, err := h.NewInvite(peer.IDB58Encode(other))
+		So(err, ShouldBeNil)
+		So(h.SetInvite(token), ShouldBeNil)
+		proof := &JoinProof{Invite: h.invite}
+		So(h.verifyJoin(other, proof), ShouldBeNil)
+		So(h.verifyJoin(h.id, proof), ShouldEqual, ErrBadJoinProof)
+
+		forged := *h.invite
+		forged.Inviter = peer.IDB58Encode(other)
+		So(h.verifyJoin(other, &JoinProof{Invite: &forged}).Error(), ShouldEqual, peer.IDB58Encode(other)+" isn't one of the chain's inviters")
+	})
+
+	Convey("DHT nodes should refuse entries from agents that haven't joined", t, func() {
+		So(h.dht.checkJoin(other, nil), ShouldEqual, ErrNoJoinProof)
+		So(h.dht.checkJoin(other, &JoinProof{Invite: h.invite}), ShouldBeNil)
+		// once joined the agent's proof isn't needed again
+		So(h.dht.checkJoin(other, nil), ShouldBeNil)
+	})
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// quarantine implements re-validating the entries the DHT store received in a window of
+// time, for when a patch to the DNA tightens its validation rules after data the new rules
+// wouldn't accept has already spread.  Entries that fail are quarantined rather than
+// deleted: their content is kept, so the decision can be reviewed and the entries released
+// if the rules are relaxed again, but gets of them are refused, they are left out of
+// snapshots, the links and directory handles they gave are taken out of the indices and the
+// CRDT objects they updated are merged again without them, so the node stops serving them.
+// The zomes' own key-value stores aren't derived from entries, so are left as they are.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"strings"
+	"time"
+)
+
+var ErrEntryQuarantined error = errors.New("entry quarantined")
+var ErrNotQuarantined error = errors.New("entry isn't quarantined")
+
+// RevalidateReport describes the result of re-validating the entries received in a window
+type RevalidateReport struct {
+	Since       time.Time
+	Checked     int
+	Invalid     []InvalidEntry
+	Quarantined int
+}
+
+// ParseSince parses the start of a window as either an RFC3339 time or a duration before now
+func ParseSince(s string, now time.Time) (since time.Time, err error) {
+	if since, err = time.Parse(time.RFC3339, s); err == nil {
+		return
+	}
+	var d time.Duration
+	if d, err = time.ParseDuration(s); err != nil {
+		err = fmt.Errorf("since must be an RFC3339 time or a duration: %s", s)
+		return
+	}
+	if d < 0 {
+		err = fmt.Errorf("since must not be a negative duration: %s", s)
+		return
+	}
+	since = now.Add(-d)
+	return
+}
+
+// receivedSince returns the held entries first received at or after the given time
+func (dht *DHT) receivedSince(entries []heldEntry, since time.Time) (recent []heldEntry, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		for _, he := range entries {
+			r, e := tx.Get("recv:" + he.key)
+			if e != nil {
+				continue
+			}
+			when, e := time.Parse(time.RFC3339, r)
+			if e != nil {
+				return e
+			}
+			if !when.Before(since.Truncate(time.Second)) {
+				recent = append(recent, he)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// quarantineKey returns where a key taken out of the indices by an entry's quarantine is kept
+// until the entry is released
+func quarantineKey(k string, key string) string {
+	return "quarantine:" + k + ":" + key
+}
+
+// quarantine marks an entry held in the DHT store as QUARANTINED, keeping its content but
+// moving the links from and by it, and any directory handle it gave, out of the indices, and
+// taking it out of the state of any CRDT object it updated
+func (dht *DHT) quarantine(k string) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("entry:" + k); err == buntdb.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, _, err := tx.Set("status:"+k, fmt.Sprintf("%d", QUARANTINED), nil); err != nil {
+			return err
+		}
+		moved := make(map[string]string)
+		tx.Ascend("meta", func(key, value string) bool {
+			x := strings.Split(key, ":")
+			if len(x) == 4 && (x[1] == k || x[2] == k) {
+				moved[key] = value
+			}
+			return true
+		})
+		tx.AscendKeys("agentsrc:*", func(key, value string) bool {
+			var d DirectoryEntry
+			if v, e := tx.Get(value); e == nil && json.Unmarshal([]byte(v), &d) == nil && d.Entry == k {
+				moved[key] = value
+				moved[value] = v
+			}
+			return true
+		})
+		for key, value := range moved {
+			if _, _, err := tx.Set(quarantineKey(k, key), value, nil); err != nil {
+				return err
+			}
+			if _, err := deleteKeys(tx, key); err != nil {
+				return err
+			}
+		}
+		return dht.rebuildCRDT(tx, k)
+	})
+	if err == nil {
+		dht.dlog.Logf("quarantined %s", k)
+	}
+	return
+}
+
+// release returns a quarantined entry to LIVE, putting back what its quarantine took out of
+// the indices, except links to or from another entry that is still quarantined, which are
+// kept with that entry's instead
+func (dht *DHT) release(k string) (err error) {
+	quarantined := fmt.Sprintf("%d", QUARANTINED)
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if status, err := tx.Get("status:" + k); err == buntdb.ErrNotFound || (err == nil && status != quarantined) {
+			return ErrNotQuarantined
+		} else if err != nil {
+			return err
+		}
+		if _, _, err := tx.Set("status:"+k, fmt.Sprintf("%d", LIVE), nil); err != nil {
+			return err
+		}
+		prefix := quarantineKey(k, "")
+		moved := make(map[string]string)
+		tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			moved[strings.TrimPrefix(key, prefix)] = value
+			return true
+		})
+		for key, value := range moved {
+			if _, err := tx.Delete(quarantineKey(k, key)); err != nil {
+				return err
+			}
+			to := key
+			if x := strings.Split(key, ":"); len(x) == 4 && x[0] == "meta" {
+				for _, other := range x[1:3] {
+					if status, _ := tx.Get("status:" + other); other != k && status == quarantined {
+						to = quarantineKey(other, key)
+					}
+				}
+			}
+			if _, _, err := tx.Set(to, value, nil); err != nil {
+				return err
+			}
+		}
+		return dht.rebuildCRDT(tx, k)
+	})
+	if err == nil {
+		dht.dlog.Logf("released %s", k)
+	}
+	return
+}
+
+// isQuarantined returns true if the entry held in the DHT store has been quarantined
+func (dht *DHT) isQuarantined(key Hash) bool {
+	_, _, status, err := dht.get(key)
+	return err == nil && status == QUARANTINED
+}
+
+// RevalidateDHT re-validates the live entries the DHT store first received at or after the
+// given time against the chain's current validation rules, quarantining those that fail
+func (h *Holochain) RevalidateDHT(since time.Time) (report RevalidateReport, err error) {
+	report.Since = since
+	var entries []heldEntry
+	if entries, err = h.dht.heldEntries(); err != nil {
+		return
+	}
+	if entries, err = h.dht.receivedSince(entries, since); err != nil {
+		return
+	}
+	for i := range entries {
+		he := &entries[i]
+		report.Checked++
+		e := h.verifyEntry(he)
+		if e == nil {
+			continue
+		}
+		inv := InvalidEntry{EntryType: he.entryType, Err: e}
+		inv.Hash, _ = NewHash(he.key)
+		report.Invalid = append(report.Invalid, inv)
+		if err = h.dht.quarantine(he.key); err != nil {
+			return
+		}
+		report.Quarantined++
+	}
+	return
+}
+
+// ReleaseQuarantined returns a quarantined entry to the DHT store's indices if it passes the
+// chain's current validation rules, e.g. once a patch that tightened them has been relaxed
+func (h *Holochain) ReleaseQuarantined(hash Hash) (err error) {
+	he := heldEntry{key: hash.String()}
+	var status int
+	if he.data, he.entryType, status, err = h.dht.get(hash); err != nil {
+		return
+	}
+	if status != QUARANTINED {
+		err = ErrNotQuarantined
+		return
+	}
+	h.dht.db.View(func(tx *buntdb.Tx) error {
+		he.src, _ = tx.Get("src:" + he.key)
+		return nil
+	})
+	if err = h.verifyEntry(&he); err != nil {
+		return
+	}
+	err = h.dht.release(he.key)
+	return
+}
@@ -0,0 +1,144 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	Convey("it should parse times and durations before now", t, func() {
+		since, err := ParseSince("2017-02-28T10:00:00Z", now)
+		So(err, ShouldBeNil)
+		So(since.Equal(time.Date(2017, 2, 28, 10, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		since, err = ParseSince("48h", now)
+		So(err, ShouldBeNil)
+		So(since.Equal(now.Add(-48*time.Hour)), ShouldBeTrue)
+		_, err = ParseSince("-1h", now)
+		So(err, ShouldNotBeNil)
+		_, err = ParseSince("yesterday", now)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRevalidateDHT(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	other, _ := makePeer("other")
+
+	put := func(content string) Hash {
+		e := GobEntry{C: content}
+		key, _ := e.Sum(h.hashSpec)
+		b, _ := e.Marshal()
+		dht.put(nil, "myData", key, other, b, LIVE)
+		return key
+	}
+	good := put("2")
+	invalid := put("1")
+	old := put("3")
+	dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("recv:"+old.String(), time.Now().Add(-72*time.Hour).Format(time.RFC3339), nil)
+		return err
+	})
+	link, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	dht.putMeta(nil, invalid, link, "tag", &GobEntry{C: "linked"})
+	dht.putMeta(nil, good, invalid, "tag", &GobEntry{C: "linked"})
+
+	Convey("it should quarantine entries received in the window that fail validation", t, func() {
+		r, err := h.RevalidateDHT(time.Now().Add(-time.Hour))
+		So(err, ShouldBeNil)
+		So(r.Quarantined, ShouldEqual, 1)
+		So(len(r.Invalid), ShouldEqual, 1)
+		So(r.Invalid[0].Hash.String(), ShouldEqual, invalid.String())
+		So(dht.isQuarantined(invalid), ShouldBeTrue)
+		So(dht.isQuarantined(good), ShouldBeFalse)
+		So(dht.exists(invalid), ShouldBeNil)
+	})
+
+	Convey("entries received before the window shouldn't be checked", t, func() {
+		So(dht.isQuarantined(old), ShouldBeFalse)
+		r, err := h.RevalidateDHT(time.Now().Add(-100 * time.Hour))
+		So(err, ShouldBeNil)
+		So(r.Quarantined, ShouldEqual, 1)
+		So(dht.isQuarantined(old), ShouldBeTrue)
+	})
+
+	Convey("quarantined entries shouldn't be served or indexed", t, func() {
+		_, err := dht.SendGetWithOptions(invalid, GetOptions{LocalOnly: true})
+		So(err, ShouldEqual, ErrEntryQuarantined)
+		So(StatusCode(err), ShouldEqual, 410)
+		links, err := dht.getMeta(invalid, "tag")
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 0)
+		links, err = dht.getMeta(good, "tag")
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 0)
+	})
+
+	Convey("quarantined entries should be counted and kept through garbage collection", t, func() {
+		st, err := dht.Stats()
+		So(err, ShouldBeNil)
+		So(st.Quarantined, ShouldEqual, 2)
+		_, err = dht.CollectGarbage(0)
+		So(err, ShouldBeNil)
+		So(dht.exists(invalid), ShouldBeNil)
+	})
+
+	Convey("quarantined entries should be left out of snapshots", t, func() {
+		s, err := dht.Snapshot()
+		So(err, ShouldBeNil)
+		So(len(s.Entries), ShouldBeGreaterThan, 0)
+		for _, e := range s.Entries {
+			So(e.Hash, ShouldNotEqual, invalid.String())
+		}
+	})
+
+	Convey("quarantined entries should only be released once they validate", t, func() {
+		So(h.ReleaseQuarantined(invalid), ShouldNotBeNil)
+		So(dht.isQuarantined(invalid), ShouldBeTrue)
+		So(h.ReleaseQuarantined(good), ShouldEqual, ErrNotQuarantined)
+
+		four := put("4")
+		So(dht.putMeta(nil, good, four, "tag", &GobEntry{C: "linked"}), ShouldBeNil)
+		So(dht.quarantine(good.String()), ShouldBeNil)
+		So(dht.isQuarantined(good), ShouldBeTrue)
+		So(h.ReleaseQuarantined(good), ShouldBeNil)
+		So(dht.isQuarantined(good), ShouldBeFalse)
+		links, err := dht.getMeta(good, "tag")
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 1)
+		So(links[0].H, ShouldEqual, four.String())
+	})
+
+	Convey("quarantining a CRDT update should take it out of its object's state", t, func() {
+		h.Zomes["myZome"].Entries["likes"] = EntryDef{Name: "likes", DataFormat: DataFormatJSON, CRDT: CRDTCounter}
+		defer delete(h.Zomes["myZome"].Entries, "likes")
+		like := func(n int) Hash {
+			e := GobEntry{C: fmt.Sprintf(`{"Object":"post1","Value":%d}`, n)}
+			key, _ := e.Sum(h.hashSpec)
+			b, _ := e.Marshal()
+			So(dht.put(nil, "likes", key, other, b, LIVE), ShouldBeNil)
+			kind, op, err := dht.crdtUpdateOf("likes", &e)
+			So(err, ShouldBeNil)
+			So(dht.applyCRDT(key, "likes", kind, &op, other), ShouldBeNil)
+			return key
+		}
+		like(1)
+		bad := like(10)
+		s, err := dht.GetCRDT("likes", "post1")
+		So(err, ShouldBeNil)
+		So(s.Count, ShouldEqual, int64(11))
+		So(dht.quarantine(bad.String()), ShouldBeNil)
+		s, err = dht.GetCRDT("likes", "post1")
+		So(err, ShouldBeNil)
+		So(s.Count, ShouldEqual, int64(1))
+		So(dht.release(bad.String()), ShouldBeNil)
+		s, err = dht.GetCRDT("likes", "post1")
+		So(err, ShouldBeNil)
+		So(s.Count, ShouldEqual, int64(11))
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// quota implements per-chain resource limits so that one chain can't starve the others
+// hosted by the same process
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	DefaultPutQueueLength     = 10
+	DefaultMaxWorkers         = 16
+	DefaultMaxConcurrentCalls = 8
+)
+
+var ErrCallQuotaExceeded error = errors.New("too many concurrent zome calls")
+var ErrWorkerQuotaExceeded error = errors.New("too many background workers")
+var ErrDHTQuotaExceeded error = errors.New("DHT store size limit reached")
+var ErrDHTPutQueueFull error = errors.New("put queue full")
+
+// Quotas holds the resource limits for a chain, zero values mean no limit unless noted
+type Quotas struct {
+	MaxWorkers          int    // maximum number of concurrent background goroutines (i.e. gossip backs)
+	MaxDHTBytes         int64  // maximum number of bytes of data held in the DHT store
+	PutQueueLength      int    // length of the DHT put request queue
+	MaxConcurrentCalls  int    // maximum number of zome calls executing at once
+	GossipBacklogBytes  int64  `toml:",omitempty"` // maximum number of bytes of gossiped puts waiting on disk, DefaultGossipBacklogBytes if 0 and no backlog if negative
+	GossipBacklogPolicy string `toml:",omitempty"` // what to do with gossiped puts when the backlog is full: pause (the default) or drop
+}
+
+// quotaState holds the semaphores used to enforce Quotas
+type quotaState struct {
+	calls   chan bool
+	workers chan bool
+}
+
+func newQuotaState(q *Quotas) (s quotaState) {
+	if q.MaxConcurrentCalls > 0 {
+		s.calls = make(chan bool, q.MaxConcurrentCalls)
+	}
+	if q.MaxWorkers > 0 {
+		s.workers = make(chan bool, q.MaxWorkers)
+	}
+	return
+}
+
+// acquire takes a slot from a semaphore without blocking, returning false if none is available
+// a nil semaphore is unlimited
+func acquire(sem chan bool) bool {
+	if sem == nil {
+		return true
+	}
+	select {
+	case sem <- true:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a slot taken by acquire
+func release(sem chan bool) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// quotaExceeded records and logs that a limit was hit
+func (h *Holochain) quotaExceeded(quota string, m string, args ...interface{}) {
+	h.metrics.Inc("quota."+quota+".exceeded", 1)
+	Infof("Quota warning: %s: %s", h.Name, fmt.Sprintf(m, args...))
+}
+
+// goWorker runs fn on a new goroutine if the worker quota allows it
+func (h *Holochain) goWorker(fn func()) (err error) {
+	sem := h.quotas().workers
+	if !acquire(sem) {
+		h.quotaExceeded("workers", "worker limit of %d reached", h.Config().Quotas.MaxWorkers)
+		return ErrWorkerQuotaExceeded
+	}
+	h.metrics.Inc("workers", 1)
+	go func() {
+		defer func() {
+			h.metrics.Inc("workers", -1)
+			release(sem)
+		}()
+		fn()
+	}()
+	return
+}
+
+// Metrics returns the metrics collected for the holochain
+func (h *Holochain) Metrics() *Metrics {
+	return h.metrics
+}
@@ -0,0 +1,83 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should set up the default quotas", t, func() {
+		So(h.config.Quotas.PutQueueLength, ShouldEqual, DefaultPutQueueLength)
+		So(cap(h.dht.puts), ShouldEqual, DefaultPutQueueLength)
+		So(cap(h.quota.calls), ShouldEqual, DefaultMaxConcurrentCalls)
+		So(cap(h.quota.workers), ShouldEqual, DefaultMaxWorkers)
+	})
+
+	Convey("it should refuse calls beyond the concurrent call limit", t, func() {
+		for i := 0; i < DefaultMaxConcurrentCalls; i++ {
+			h.quota.calls <- true
+		}
+		_, err := h.Call("myZome", "exposedfn", "arg1")
+		So(err, ShouldEqual, ErrCallQuotaExceeded)
+		So(h.Metrics().Get("quota.calls.exceeded"), ShouldEqual, 1)
+		for i := 0; i < DefaultMaxConcurrentCalls; i++ {
+			<-h.quota.calls
+		}
+		result, err := h.Call("myZome", "exposedfn", "arg1")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: arg1")
+	})
+
+	Convey("it should refuse workers beyond the worker limit", t, func() {
+		for i := 0; i < DefaultMaxWorkers; i++ {
+			h.quota.workers <- true
+		}
+		err := h.goWorker(func() {})
+		So(err, ShouldEqual, ErrWorkerQuotaExceeded)
+		for i := 0; i < DefaultMaxWorkers; i++ {
+			<-h.quota.workers
+		}
+		done := make(chan bool)
+		err = h.goWorker(func() { done <- true })
+		So(err, ShouldBeNil)
+		So(<-done, ShouldBeTrue)
+	})
+
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	Convey("it should refuse puts beyond the DHT store size limit", t, func() {
+		h.config.Quotas.MaxDHTBytes = h.Metrics().Get("dht.bytes") + 5
+		err := h.dht.put(nil, "someType", hash, h.id, []byte("some value"), LIVE)
+		So(err, ShouldEqual, ErrDHTQuotaExceeded)
+		So(h.dht.exists(hash), ShouldEqual, ErrHashNotFound)
+
+		h.config.Quotas.MaxDHTBytes = 0
+		err = h.dht.put(nil, "someType", hash, h.id, []byte("some value"), LIVE)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("putting a key again should only count the change in its size", t, func() {
+		size := h.Metrics().Get("dht.bytes")
+		err := h.dht.put(nil, "someType", hash, h.id, []byte("some value"), LIVE)
+		So(err, ShouldBeNil)
+		So(h.Metrics().Get("dht.bytes"), ShouldEqual, size)
+		err = h.dht.put(nil, "someType", hash, h.id, []byte("value"), LIVE)
+		So(err, ShouldBeNil)
+		So(h.Metrics().Get("dht.bytes"), ShouldEqual, size-5)
+	})
+
+	Convey("it should refuse put requests when the put queue is full", t, func() {
+		for i := 0; i < cap(h.dht.puts); i++ {
+			h.dht.puts <- &Message{}
+		}
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: hash})
+		_, err := DHTReceiver(h, m)
+		So(err, ShouldEqual, ErrDHTPutQueueFull)
+		So(h.Metrics().Get("quota.putqueue.exceeded"), ShouldEqual, 1)
+		for i := 0; i < cap(h.dht.puts); i++ {
+			<-h.dht.puts
+		}
+	})
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// random implements randomness derived from the hash of an entry, so that zome code, e.g. for
+// games and lotteries, can make random choices that every validating node agrees on.
+
+package holochain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// DeterministicRandom returns a number in [0, n) derived from the seed hash.  The same seed
+// always gives the same number, and the numbers are evenly distributed over the range.
+func DeterministicRandom(seed Hash, n int64) (r int64, err error) {
+	if n <= 0 {
+		err = fmt.Errorf("deterministicRandom range must be positive, got: %d", n)
+		return
+	}
+	// discard values past the last whole multiple of n so as not to favor low numbers
+	limit := math.MaxUint64 - math.MaxUint64%uint64(n)
+	b := make([]byte, 8)
+	for i := uint64(0); ; i++ {
+		binary.BigEndian.PutUint64(b, i)
+		sum := sha256.Sum256(append(append([]byte("holochain-random:"), seed.H...), b...))
+		v := binary.BigEndian.Uint64(sum[:8])
+		if v < limit {
+			r = int64(v % uint64(n))
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDeterministicRandom(t *testing.T) {
+	seed, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+
+	Convey("it should give the same number for the same seed", t, func() {
+		r, err := DeterministicRandom(seed, 100)
+		So(err, ShouldBeNil)
+		So(r, ShouldBeGreaterThanOrEqualTo, 0)
+		So(r, ShouldBeLessThan, 100)
+		r2, _ := DeterministicRandom(seed, 100)
+		So(r2, ShouldEqual, r)
+		r, _ = DeterministicRandom(seed, 1)
+		So(r, ShouldEqual, 0)
+	})
+
+	Convey("it should spread seeds over the range", t, func() {
+		r1, _ := DeterministicRandom(seed, 1<<40)
+		r2, _ := DeterministicRandom(other, 1<<40)
+		So(r1, ShouldNotEqual, r2)
+	})
+
+	Convey("it should reject empty ranges", t, func() {
+		_, err := DeterministicRandom(seed, 0)
+		So(err.Error(), ShouldEqual, "deterministicRandom range must be positive, got: 0")
+	})
+}
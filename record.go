@@ -0,0 +1,129 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// record implements recording the zome calls made during a development session as test data,
+// so that an exploratory session can be replayed by Test as a regression test.  Calls are
+// recorded against freshly generated genesis entries, as Test replays them, and hashes that
+// Test can substitute, like the DNA hash and the results of earlier calls, are written as
+// its replacement strings.
+
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Recorder records zome calls made on a chain as test data
+type Recorder struct {
+	h       *Holochain
+	file    string
+	tests   []TestData
+	results [3]string // the last three results, as Test substitutes them for %r1% to %r3%
+}
+
+// Record resets a chain that hasn't been started, generates new genesis entries and returns
+// a Recorder that records calls to the test file of the given name
+func (h *Holochain) Record(name string) (r *Recorder, err error) {
+	if h.Started() {
+		err = ErrChainStarted
+		return
+	}
+	file := filepath.Join(h.path, "test", name+".json")
+	if fileExists(file) {
+		err = mkErr(file + " already exists")
+		return
+	}
+	if err = h.Reset(); err != nil {
+		return
+	}
+	if _, err = h.GenChain(); err != nil {
+		return
+	}
+	go h.dht.HandlePutReqs()
+	r = &Recorder{h: h, file: file}
+	return
+}
+
+// committedSince returns the hashes of the entries committed after the first n
+func (h *Holochain) committedSince(n int) (hashes []string) {
+	for _, hd := range h.chain.Headers[n:] {
+		hashes = append(hashes, hd.EntryLink.String())
+	}
+	return
+}
+
+// generalize replaces the hashes in s that Test can substitute with its replacement strings
+func (r *Recorder) generalize(s string, top string) string {
+	h := r.h
+	replacements := []string{top, "%h%"}
+	for i, res := range r.results {
+		if _, err := NewHash(res); err == nil {
+			replacements = append(replacements, res, fmt.Sprintf("%%r%d%%", i+1))
+		}
+	}
+	replacements = append(replacements,
+		h.dnaHash.String(), "%dna%",
+		h.agentHash.String(), "%agent%",
+		peer.IDB58Encode(h.id), "%key%",
+	)
+	var pairs []string
+	for i := 0; i < len(replacements); i += 2 {
+		if replacements[i] != "" {
+			pairs = append(pairs, replacements[i], replacements[i+1])
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// Call makes a zome call, recording it along with its result and the entries it committed
+func (r *Recorder) Call(zome string, function string, input string) (result interface{}, err error) {
+	h := r.h
+	n := h.chain.Length()
+	t := TestData{Zome: zome, FnName: function, Input: r.generalize(input, h.chain.Top().EntryLink.String())}
+	result, err = h.Call(zome, function, input)
+	if err != nil {
+		t.Err = err.Error()
+	} else {
+		t.Output = r.generalize(ToString(result), h.chain.Top().EntryLink.String())
+	}
+	t.Commits = h.committedSince(n)
+	r.tests = append(r.tests, t)
+	r.results[2] = r.results[1]
+	r.results[1] = r.results[0]
+	r.results[0] = strings.Trim(fmt.Sprintf("%v", result), "\"")
+	return
+}
+
+// Tests returns the test data recorded so far
+func (r *Recorder) Tests() []TestData {
+	return r.tests
+}
+
+// Save writes the recorded test data to the chain's test directory
+func (r *Recorder) Save() (err error) {
+	if err = os.MkdirAll(filepath.Dir(r.file), os.ModePerm); err != nil {
+		return
+	}
+	var j []byte
+	if j, err = json.MarshalIndent(r.tests, "", "  "); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(r.file, j, 0644)
+	return
+}
+
+// Close saves the recorded test data and resets the chain, as Test does when it finishes
+func (r *Recorder) Close() (err error) {
+	if err = r.Save(); err != nil {
+		return
+	}
+	err = r.h.Reset()
+	return
+}
@@ -0,0 +1,67 @@
+package holochain
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	if os.Getenv("DEBUG") != "1" {
+		h.config.Loggers.TestPassed.Enabled = false
+		h.config.Loggers.TestFailed.Enabled = false
+		h.config.Loggers.TestInfo.Enabled = false
+	}
+
+	Convey("it should not overwrite an existing test file", t, func() {
+		_, err := h.Record("grouped")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEndWith, "already exists")
+	})
+
+	Convey("it should record calls as replayable test data", t, func() {
+		r, err := h.Record("recorded")
+		So(err, ShouldBeNil)
+		So(h.Started(), ShouldBeTrue)
+
+		result, err := r.Call("myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		hash := ToString(result)
+		_, err = r.Call("myZome", "addData", "5")
+		So(err, ShouldNotBeNil)
+		_, err = r.Call("myZome", "getDNA", "")
+		So(err, ShouldBeNil)
+
+		So(r.Tests(), ShouldResemble, []TestData{
+			{Zome: "myZome", FnName: "addData", Input: "2", Output: "%h%", Commits: []string{hash}},
+			{Zome: "myZome", FnName: "addData", Input: "5", Err: "Error calling 'commit': Invalid entry: 5"},
+			{Zome: "myZome", FnName: "getDNA", Input: "", Output: "%dna%"},
+		})
+
+		So(r.Close(), ShouldBeNil)
+		So(h.Started(), ShouldBeFalse)
+		b, err := readFile(filepath.Join(h.path, "test"), "recorded.json")
+		So(err, ShouldBeNil)
+		var tests []TestData
+		So(json.Unmarshal(b, &tests), ShouldBeNil)
+		So(tests, ShouldResemble, r.Tests())
+	})
+
+	Convey("the recorded test data should pass when replayed", t, func() {
+		errs := h.Test()
+		So(errs, ShouldBeNil)
+	})
+
+	Convey("replays should fail if a call commits different entries", t, func() {
+		os.Remove(filepath.Join(h.path, "test", "recorded.json"))
+		err := writeFile(filepath.Join(h.path, "test"), "recorded.json", []byte(`[{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","Commits":["QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"]}]`))
+		So(err, ShouldBeNil)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "Expected commits")
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// redaction implements the removal of an entry's content from the DHT, e.g. for legal or
+// abuse reasons.  The entry's author, or an agent the DNA names as a redaction authority,
+// commits a redaction entry and links it from the redacted entry.  Nodes holding the entry
+// then replace its content with a tombstone that keeps its hash, so the headers on the
+// author's chain still verify, and gets of it report that it was redacted.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	RedactionEntryType = "%redaction"
+	RedactionMetaTag   = "redaction"
+)
+
+var ErrEntryRedacted error = errors.New("entry redacted")
+var ErrRedactionNotAuthorized error = errors.New("entry can only be redacted by its author or a redaction authority")
+
+// Redaction is an agent's request that the content of an entry be dropped from the DHT
+type Redaction struct {
+	Entry  string // hash of the redacted entry
+	Author string // node id of the redacting agent
+	Reason string
+}
+
+// parseRedaction decodes a redaction entry
+func parseRedaction(entry Entry) (r *Redaction, err error) {
+	s, ok := entry.Content().(string)
+	if !ok {
+		err = errors.New("redaction entry should be a string")
+		return
+	}
+	r = &Redaction{}
+	if err = json.Unmarshal([]byte(s), r); err != nil {
+		return
+	}
+	if _, err = NewHash(r.Entry); err != nil {
+		err = fmt.Errorf("bad redacted entry hash: %v", err)
+	}
+	return
+}
+
+// isRedactionAuthority returns true if the DNA allows the agent to redact any entry
+func (h *Holochain) isRedactionAuthority(agent string) bool {
+	for _, a := range h.Redactors {
+		if a == agent {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRedaction checks a redaction entry, which must be published by the redacting agent
+// who must be the redacted entry's author or a redaction authority.  Nodes that don't know
+// who authored the entry leave that check to the nodes that hold it.
+func (h *Holochain) validateRedaction(entry Entry, props *ValidationProps) (err error) {
+	var r *Redaction
+	if r, err = parseRedaction(entry); err != nil {
+		return
+	}
+	if props == nil {
+		return
+	}
+	if len(props.Sources) > 0 && props.Sources[0] != r.Author {
+		err = errors.New("redaction must be published by the redacting agent")
+		return
+	}
+	if props.MetaBase != "" && props.MetaBase != r.Entry {
+		err = errors.New("redaction must be linked from the redacted entry")
+		return
+	}
+	if h.isRedactionAuthority(r.Author) {
+		return
+	}
+	key, _ := NewHash(r.Entry)
+	me := peer.IDB58Encode(h.id)
+	if _, _, e := h.chain.GetEntry(key); e == nil && r.Author == me {
+		return
+	}
+	if h.dht != nil {
+		if src, e := h.dht.source(key); e == nil {
+			if peer.IDB58Encode(src) != r.Author {
+				err = ErrRedactionNotAuthorized
+			}
+			return
+		}
+	}
+	if r.Author == me || props.MetaBase != "" {
+		err = ErrRedactionNotAuthorized
+	}
+	return
+}
+
+// redact replaces the content of an entry held in the DHT store with a tombstone
+func (dht *DHT) redact(key Hash, redaction Hash) (err error) {
+	k := key.String()
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("entry:" + k); err == buntdb.ErrNotFound {
+			return nil
+		}
+		// the links from and to the entry go with its content
+		freed, err := deleteMetas(tx, k)
+		if err != nil {
+			return err
+		}
+		if err = dht.resize(tx, "entry:"+k, 0); err != nil {
+			return err
+		}
+		if err = dht.incSize(tx, -freed); err != nil {
+			return err
+		}
+		values := map[string]string{
+			"entry:" + k:    "",
+			"status:" + k:   fmt.Sprintf("%d", REDACTED),
+			"redacted:" + k: redaction.String(),
+		}
+		for key, val := range values {
+			if _, _, err := tx.Set(key, val, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		dht.dlog.Logf("redacted %v by %v", key, redaction)
+	}
+	return
+}
+
+// isRedacted returns true if the entry held in the DHT store has been redacted
+func (dht *DHT) isRedacted(key Hash) bool {
+	_, _, status, err := dht.get(key)
+	return err == nil && status == REDACTED
+}
+
+// Redaction returns the hash of the redaction of an entry held in the DHT store
+func (dht *DHT) Redaction(key Hash) (redaction Hash, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("redacted:" + key.String())
+		if err == buntdb.ErrNotFound {
+			return ErrHashNotFound
+		}
+		if err == nil {
+			redaction, err = NewHash(val)
+		}
+		return err
+	})
+	return
+}
+
+// Redact commits a redaction of an entry to the chain and publishes it to the nodes holding
+// the entry, which replace its content with a tombstone
+func (h *Holochain) Redact(key Hash, reason string) (hash Hash, err error) {
+	r := Redaction{Entry: key.String(), Author: peer.IDB58Encode(h.id), Reason: reason}
+	var b []byte
+	if b, err = json.Marshal(r); err != nil {
+		return
+	}
+	if hash, err = h.Commit(RedactionEntryType, string(b)); err != nil {
+		return
+	}
+	if err = h.dht.SendPut(hash); err != nil {
+		return
+	}
+	if err = h.dht.SendPutMeta(MetaReq{O: key, M: hash, T: RedactionMetaTag}); err != nil {
+		return
+	}
+	err = h.dht.redact(key, hash)
+	return
+}
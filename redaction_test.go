@@ -0,0 +1,100 @@
+package holochain
+
+import (
+	"encoding/json"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	for len(dht.puts) > 0 {
+		<-dht.puts
+	}
+	me := peer.IDB58Encode(h.id)
+	other, _ := makePeer("other")
+
+	hash, _ := h.Commit("myData", "2")
+	e := GobEntry{C: "2"}
+	b, _ := e.Marshal()
+	dht.put(nil, "myData", hash, h.id, b, LIVE)
+
+	redaction := func(entry Hash, author string) Entry {
+		j, _ := json.Marshal(Redaction{Entry: entry.String(), Author: author, Reason: "abuse"})
+		return &GobEntry{C: string(j)}
+	}
+
+	Convey("it should only accept redactions by the entry's author or a redaction authority", t, func() {
+		o := peer.IDB58Encode(other)
+		p := ValidationProps{Sources: []string{o}, MetaBase: hash.String()}
+		So(h.validateRedaction(redaction(hash, o), &p), ShouldEqual, ErrRedactionNotAuthorized)
+
+		h.Redactors = []string{o}
+		So(h.validateRedaction(redaction(hash, o), &p), ShouldBeNil)
+		h.Redactors = nil
+
+		p = ValidationProps{Sources: []string{o}}
+		err := h.validateRedaction(redaction(hash, me), &p)
+		So(err.Error(), ShouldEqual, "redaction must be published by the redacting agent")
+
+		p = ValidationProps{Sources: []string{me}, MetaBase: h.dnaHash.String()}
+		err = h.validateRedaction(redaction(hash, me), &p)
+		So(err.Error(), ShouldEqual, "redaction must be linked from the redacted entry")
+
+		p = ValidationProps{Sources: []string{me}, MetaBase: hash.String()}
+		So(h.validateRedaction(redaction(hash, me), &p), ShouldBeNil)
+
+		err = h.ValidateEntry(RedactionEntryType, &GobEntry{C: "fish"}, nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should replace the redacted entry with a tombstone", t, func() {
+		r, err := h.Redact(hash, "abuse")
+		So(err, ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+
+		data, entryType, status, err := dht.get(hash)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, REDACTED)
+		So(entryType, ShouldEqual, "myData")
+		So(len(data), ShouldEqual, 0)
+		So(dht.isRedacted(hash), ShouldBeTrue)
+
+		rh, err := dht.Redaction(hash)
+		So(err, ShouldBeNil)
+		So(rh.String(), ShouldEqual, r.String())
+
+		_, err = h.dht.SendGetWithOptions(hash, GetOptions{LocalOnly: true})
+		So(err, ShouldEqual, ErrEntryRedacted)
+	})
+
+	Convey("it should free the size of the redacted entry", t, func() {
+		e := GobEntry{C: "4"}
+		b, _ := e.Marshal()
+		key, _ := e.Sum(h.hashSpec)
+		dht.put(nil, "myData", key, other, b, LIVE)
+		size := dhtSize(dht)
+		So(dht.redact(key, hash), ShouldBeNil)
+		So(dhtSize(dht), ShouldEqual, size-len(b))
+	})
+
+	Convey("it should keep tombstones when collecting garbage", t, func() {
+		_, err := dht.CollectGarbage(0)
+		So(err, ShouldBeNil)
+		So(dht.isRedacted(hash), ShouldBeTrue)
+	})
+
+	Convey("it should redact from zome code with the redact system function", t, func() {
+		hash2, _ := h.Commit("myData", "4")
+		e := GobEntry{C: "4"}
+		b, _ := e.Marshal()
+		dht.put(nil, "myData", hash2, h.id, b, LIVE)
+		_, err := h.Call(SystemZomeName, "redact", `{"Entry":"`+hash2.String()+`","Reason":"mistake"}`)
+		So(err, ShouldBeNil)
+		So(dht.isRedacted(hash2), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// registry implements a client for app registries.  A registry is a static JSON index, served
+// over http(s) or read from a local file, listing published apps by id along with the DNA hash
+// each must build to, the node id of the agent that signed its bundle and the url of its DNA
+// bundle, which is a JSON encoded DNABundle as made by hc bundle.  Bundle urls may be relative
+// to the index.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	registryTimeout  = 30 * time.Second
+	MaxRegistryFetch = 64 * 1024 * 1024 // largest index or bundle fetched from a registry
+)
+
+var ErrNoRegistry error = errors.New("no app registry configured")
+
+// RegistryEntry describes an app published in a registry
+type RegistryEntry struct {
+	Id          string
+	Name        string
+	Description string
+	Version     string
+	DNAHash     string
+	Publisher   string // node id of the agent whose signature the bundle's manifest must carry
+	URL         string // where to get the app's DNA bundle
+}
+
+// RegistryIndex is the list of apps published in a registry
+type RegistryIndex struct {
+	URL  string `json:"-"` // where the index was fetched from
+	Apps []RegistryEntry
+}
+
+// fetchURL gets the contents of an http(s) url or of a local file
+func fetchURL(u string) (data []byte, err error) {
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		data, err = ioutil.ReadFile(strings.TrimPrefix(u, "file://"))
+		return
+	}
+	client := http.Client{Timeout: registryTimeout}
+	var resp *http.Response
+	resp, err = client.Get(u)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("fetching %s: %s", u, resp.Status)
+		return
+	}
+	data, err = ioutil.ReadAll(io.LimitReader(resp.Body, MaxRegistryFetch+1))
+	if err == nil && len(data) > MaxRegistryFetch {
+		data = nil
+		err = fmt.Errorf("fetching %s: larger than %d bytes", u, MaxRegistryFetch)
+	}
+	return
+}
+
+// FetchRegistryIndex gets the index of a registry
+func FetchRegistryIndex(u string) (index *RegistryIndex, err error) {
+	if u == "" {
+		err = ErrNoRegistry
+		return
+	}
+	var data []byte
+	data, err = fetchURL(u)
+	if err != nil {
+		return
+	}
+	var idx RegistryIndex
+	if err = json.Unmarshal(data, &idx); err != nil {
+		err = fmt.Errorf("bad registry index: %v", err)
+		return
+	}
+	idx.URL = u
+	index = &idx
+	return
+}
+
+// Search returns the apps whose id, name or description contain the query, ignoring case
+func (r *RegistryIndex) Search(query string) (apps []RegistryEntry) {
+	query = strings.ToLower(query)
+	for _, a := range r.Apps {
+		if strings.Contains(strings.ToLower(a.Id+" "+a.Name+" "+a.Description), query) {
+			apps = append(apps, a)
+		}
+	}
+	return
+}
+
+// Find returns the app with the given id
+func (r *RegistryIndex) Find(id string) (app *RegistryEntry, err error) {
+	for i := range r.Apps {
+		if r.Apps[i].Id == id {
+			app = &r.Apps[i]
+			return
+		}
+	}
+	err = fmt.Errorf("app not found in registry: %s", id)
+	return
+}
+
+// bundleURL resolves the url of an app's bundle against the url of the index
+func (r *RegistryIndex) bundleURL(app *RegistryEntry) (u string, err error) {
+	if strings.Contains(app.URL, "://") || filepath.IsAbs(app.URL) {
+		u = app.URL
+		return
+	}
+	if strings.HasPrefix(r.URL, "http://") || strings.HasPrefix(r.URL, "https://") {
+		var base, ref *url.URL
+		if base, err = url.Parse(r.URL); err != nil {
+			return
+		}
+		if ref, err = url.Parse(app.URL); err != nil {
+			return
+		}
+		u = base.ResolveReference(ref).String()
+		return
+	}
+	u = filepath.Join(filepath.Dir(strings.TrimPrefix(r.URL, "file://")), app.URL)
+	return
+}
+
+// Install fetches the bundle of an app in the registry, checks that it builds to the DNA hash
+// listed for it, and clones it to path ready for genesis
+func (s *Service) Install(r *RegistryIndex, id string, path string) (h *Holochain, err error) {
+	var app *RegistryEntry
+	app, err = r.Find(id)
+	if err != nil {
+		return
+	}
+	var expected Hash
+	expected, err = NewHash(app.DNAHash)
+	if err != nil {
+		err = fmt.Errorf("bad DNA hash for %s in registry: %v", id, err)
+		return
+	}
+	var publisher peer.ID
+	publisher, err = peer.IDB58Decode(app.Publisher)
+	if err != nil {
+		err = fmt.Errorf("bad publisher for %s in registry: %v", id, err)
+		return
+	}
+	var u string
+	u, err = r.bundleURL(app)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = fetchURL(u)
+	if err != nil {
+		return
+	}
+	var b DNABundle
+	if err = json.Unmarshal(data, &b); err != nil {
+		err = fmt.Errorf("bad bundle for %s: %v", id, err)
+		return
+	}
+	h, err = s.installBundle(&b, publisher, expected, path)
+	return
+}
@@ -0,0 +1,84 @@
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	_, dnaHash, err := h.BuildDNA()
+	if err != nil {
+		panic(err)
+	}
+	b, err := h.SignedBundle()
+	if err != nil {
+		panic(err)
+	}
+	bundle, _ := json.Marshal(b)
+	ioutil.WriteFile(d+"/test.bundle", bundle, 0644)
+
+	index := RegistryIndex{Apps: []RegistryEntry{
+		{Id: "test", Name: "Test App", Description: "a sample app", DNAHash: dnaHash.String(), Publisher: h.NodeIDStr(), URL: "test.bundle"},
+		{Id: "chat", Name: "Chat", Description: "talk to people", DNAHash: dnaHash.String(), Publisher: h.NodeIDStr(), URL: "chat.bundle"},
+		{Id: "wrong", Name: "Wrong", DNAHash: "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2", Publisher: h.NodeIDStr(), URL: "test.bundle"},
+		{Id: "impostor", Name: "Impostor", DNAHash: dnaHash.String(), Publisher: "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2", URL: "test.bundle"},
+	}}
+	data, _ := json.Marshal(index)
+	ioutil.WriteFile(d+"/index.json", data, 0644)
+
+	Convey("it should require a registry", t, func() {
+		_, err := FetchRegistryIndex("")
+		So(err, ShouldEqual, ErrNoRegistry)
+	})
+
+	Convey("it should search a registry", t, func() {
+		r, err := FetchRegistryIndex(d + "/index.json")
+		So(err, ShouldBeNil)
+		So(len(r.Apps), ShouldEqual, 4)
+		apps := r.Search("TALK")
+		So(len(apps), ShouldEqual, 1)
+		So(apps[0].Id, ShouldEqual, "chat")
+		So(len(r.Search("")), ShouldEqual, 4)
+		_, err = r.Find("fish")
+		So(err.Error(), ShouldEqual, "app not found in registry: fish")
+	})
+
+	Convey("it should install apps, checking their DNA hash", t, func() {
+		r, _ := FetchRegistryIndex(d + "/index.json")
+		_, err := s.Install(r, "wrong", s.Path+"/wrong")
+		So(err, ShouldEqual, ErrDNAHashMismatch)
+		So(dirExists(s.Path+"/wrong"), ShouldBeFalse)
+
+		_, err = s.Install(r, "impostor", s.Path+"/impostor")
+		So(err, ShouldNotBeNil)
+		So(dirExists(s.Path+"/impostor"), ShouldBeFalse)
+
+		h2, err := s.Install(r, "test", s.Path+"/installed")
+		So(err, ShouldBeNil)
+		So(h2.Id, ShouldEqual, h.Id)
+		So(fileExists(s.Path+"/installed/zome_myZome.zy"), ShouldBeTrue)
+	})
+
+	Convey("it should fetch registries over http, resolving bundle urls against the index", t, func() {
+		ts := httptest.NewServer(http.FileServer(http.Dir(d)))
+		defer ts.Close()
+		r, err := FetchRegistryIndex(ts.URL + "/index.json")
+		So(err, ShouldBeNil)
+		u, err := r.bundleURL(&r.Apps[0])
+		So(err, ShouldBeNil)
+		So(u, ShouldEqual, ts.URL+"/test.bundle")
+
+		_, err = s.Install(r, "test", s.Path+"/fromhttp")
+		So(err, ShouldBeNil)
+
+		_, err = s.Install(r, "chat", s.Path+"/chat")
+		So(err.Error(), ShouldEqual, fmt.Sprintf("fetching %s/chat.bundle: 404 Not Found", ts.URL))
+	})
+}
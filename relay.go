@@ -0,0 +1,275 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// relay implements a node role for thin clients, like browsers and phones, that hold their own
+// keys but no chain.  A client signs each entry it authors and posts it to a relay, which
+// checks the signature, validates the entry with the client as its source, and countersigns a
+// receipt for it.  The relay then keeps the signed entry and publishes its put like one of its
+// own, so the put waits in the outbox while the relay is offline.  Nodes asking the relay for
+// the entry to validate it are sent the client's signed entry along with it, so they check
+// that the client authored it, check the client's join proof and identity rather than the
+// relay's, and validate it with the client, not the relay, as its source.  Only the clients
+// listed in the relay's config may use it.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"strings"
+	"time"
+)
+
+var ErrRelayDisabled error = errors.New("node is not a relay")
+var ErrRelayClientNotAllowed error = errors.New("client is not allowed to use this relay")
+var ErrBadRelaySignature error = errors.New("relayed entry signature doesn't verify")
+
+const RelayAnyClient = "*" // in a relay's clients, allows any client to use it
+
+// RelayConfig configures a node to relay the entries of thin clients
+type RelayConfig struct {
+	Clients []string `toml:",omitempty"` // node ids of the clients allowed to use the relay, or RelayAnyClient
+}
+
+// allows returns true if a client may use the relay
+func (c *RelayConfig) allows(client string) bool {
+	for _, id := range c.Clients {
+		if id == client || id == RelayAnyClient {
+			return true
+		}
+	}
+	return false
+}
+
+// RelayedEntry is an entry authored by a thin client and signed with its key
+type RelayedEntry struct {
+	Client    string // node id of the client, i.e. the hash of its public key
+	PubKey    string // base58 encoded public key of the client
+	Type      string
+	Entry     string // the entry's content
+	Time      time.Time
+	Signature string // base58 signature by PubKey of all of the above
+
+	Join     *JoinProof         `json:",omitempty"` // the client's solution to the join puzzle, if the DNA declares one
+	Identity *IdentityAssertion `json:",omitempty"` // the client's attested identity, if the DNA requires one
+}
+
+// RelayReceipt is a relay's countersigned acknowledgement that it received a client's entry
+type RelayReceipt struct {
+	Hash            string // of the entry
+	Client          string
+	ClientSignature string // the client's signature of the entry
+	Relay           string // node id of the relay
+	PubKey          string // base58 encoded public key of the relay
+	Time            time.Time
+	Signature       string // base58 signature by PubKey of all of the above
+}
+
+// signed returns the bytes of the relayed entry that its signature covers
+func (r *RelayedEntry) signed() []byte {
+	return []byte(strings.Join([]string{r.Client, r.PubKey, r.Type, r.Entry, r.Time.UTC().Format(time.RFC3339Nano)}, "\n"))
+}
+
+// Sign signs the relayed entry as authored by the holder of a key
+func (r *RelayedEntry) Sign(key ic.PrivKey) (err error) {
+	var pub []byte
+	if pub, err = ic.MarshalPublicKey(key.GetPublic()); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = peer.IDFromPublicKey(key.GetPublic()); err != nil {
+		return
+	}
+	r.Client = peer.IDB58Encode(id)
+	r.PubKey = b58.Encode(pub)
+	var sig []byte
+	if sig, err = key.Sign(r.signed()); err != nil {
+		return
+	}
+	r.Signature = b58.Encode(sig)
+	return
+}
+
+// verifySigned checks a signature of some bytes by a base58 encoded key belonging to a node
+func verifySigned(id string, pubKey string, b []byte, sig string) (err error) {
+	var key ic.PubKey
+	if key, err = ic.UnmarshalPublicKey(b58.Decode(pubKey)); err != nil {
+		err = fmt.Errorf("bad public key: %v", err)
+		return
+	}
+	var pid peer.ID
+	if pid, err = peer.IDFromPublicKey(key); err != nil {
+		return
+	}
+	if peer.IDB58Encode(pid) != id {
+		err = fmt.Errorf("public key doesn't belong to %s", id)
+		return
+	}
+	if ok, e := key.Verify(b, b58.Decode(sig)); e != nil || !ok {
+		err = ErrBadRelaySignature
+	}
+	return
+}
+
+// Verify checks that the relayed entry was signed by its client
+func (r *RelayedEntry) Verify() error {
+	return verifySigned(r.Client, r.PubKey, r.signed(), r.Signature)
+}
+
+// Sum returns the hash of the relayed entry
+func (r *RelayedEntry) Sum(spec HashSpec) (Hash, error) {
+	e := GobEntry{C: r.Entry}
+	return e.Sum(spec)
+}
+
+// signed returns the bytes of the receipt that its signature covers
+func (r *RelayReceipt) signed() []byte {
+	return []byte(strings.Join([]string{r.Hash, r.Client, r.ClientSignature, r.Relay, r.PubKey, r.Time.UTC().Format(time.RFC3339Nano)}, "\n"))
+}
+
+// Verify checks that the receipt was signed by its relay
+func (r *RelayReceipt) Verify() error {
+	return verifySigned(r.Relay, r.PubKey, r.signed(), r.Signature)
+}
+
+// relayedKey is where an entry relayed for a client is kept, so that clients relaying the same
+// entry don't overwrite each other's
+func relayedKey(hash Hash, client string) string {
+	return "relayed:" + hash.String() + ":" + client
+}
+
+// checkRelayed checks a relayed entry's signature and that it is the entry with a hash and
+// of a type, returning the source to validate it with
+func (dht *DHT) checkRelayed(r *RelayedEntry, hash Hash, entryType string) (source string, err error) {
+	if err = r.Verify(); err != nil {
+		return
+	}
+	var h Hash
+	if h, err = r.Sum(dht.h.hashSpec); err != nil {
+		return
+	}
+	if h.String() != hash.String() || r.Type != entryType {
+		err = errors.New("relayed entry doesn't match the entry put")
+		return
+	}
+	source = r.Client
+	return
+}
+
+// getRelayed returns an entry the node has relayed for a client, the earliest relayed if it
+// has relayed the same entry for more than one client
+func (dht *DHT) getRelayed(hash Hash) (r *RelayedEntry, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("relayed:"+hash.String()+":*", func(key, value string) bool {
+			var x RelayedEntry
+			if e = ByteDecoder([]byte(value), &x); e != nil {
+				return false
+			}
+			if r == nil || x.Time.Before(r.Time) {
+				r = &x
+			}
+			return true
+		})
+		if e == nil && r == nil {
+			e = ErrHashNotFound
+		}
+		return e
+	})
+	return
+}
+
+// Relay validates and publishes an entry authored by a thin client, returning a receipt for it
+func (h *Holochain) Relay(r *RelayedEntry) (receipt *RelayReceipt, err error) {
+	relay := h.Config().Relay
+	if relay == nil {
+		err = ErrRelayDisabled
+		return
+	}
+	if !relay.allows(r.Client) {
+		err = ErrRelayClientNotAllowed
+		return
+	}
+	if err = r.Verify(); err != nil {
+		return
+	}
+	var client peer.ID
+	if client, err = peer.IDB58Decode(r.Client); err != nil {
+		return
+	}
+	if err = h.verifyJoin(client, r.Join); err != nil {
+		return
+	}
+	var claims map[string]interface{}
+	if claims, err = h.verifyIdentity(client, r.Identity); err != nil {
+		return
+	}
+	var entryType string
+	if entryType, err = h.EntryTypeName(r.Type); err != nil {
+		return
+	}
+	if entryType != r.Type {
+		// nodes validating the entry are told its type as the client signed it
+		err = errors.New("relayed entry types must not be qualified by their zome")
+		return
+	}
+	if strings.HasPrefix(entryType, "%") {
+		err = fmt.Errorf("clients can't relay %s entries", entryType)
+		return
+	}
+	if _, _, err = h.GetEntryDef(entryType); err != nil {
+		return
+	}
+	if err = h.checkHeaderTime(r.Time); err != nil {
+		return
+	}
+	var hash Hash
+	if hash, err = r.Sum(h.hashSpec); err != nil {
+		return
+	}
+	p := ValidationProps{Sources: []string{r.Client}, Hash: hash.String(), Claims: claims}
+	if err = h.ValidateEntry(entryType, &GobEntry{C: r.Entry}, &p); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = ByteEncoder(r); err != nil {
+		return
+	}
+	err = h.dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(relayedKey(hash, r.Client), string(b), nil)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	h.metrics.Inc("relay.entries", 1)
+
+	var pub []byte
+	if pub, err = ic.MarshalPublicKey(h.agent.PubKey()); err != nil {
+		return
+	}
+	receipt = &RelayReceipt{
+		Hash:            hash.String(),
+		Client:          r.Client,
+		ClientSignature: r.Signature,
+		Relay:           peer.IDB58Encode(h.id),
+		PubKey:          b58.Encode(pub),
+		Time:            time.Now(),
+	}
+	var sig []byte
+	if sig, err = h.agent.PrivKey().Sign(receipt.signed()); err != nil {
+		return
+	}
+	receipt.Signature = b58.Encode(sig)
+
+	// puts that aren't acknowledged stay in the outbox to be published again
+	if e := h.dht.SendPut(hash); e != nil {
+		h.dht.dlog.Logf("relay: put of %v will be retried: %v", hash, e)
+	}
+	return
+}
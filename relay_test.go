@@ -0,0 +1,159 @@
+package holochain
+
+import (
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	key, _, err := ic.GenerateEd25519Key(strings.NewReader("thin client key1234567890123456789012345678901234567890"))
+	if err != nil {
+		panic(err)
+	}
+	sign := func(entryType string, content string) *RelayedEntry {
+		r := RelayedEntry{Type: entryType, Entry: content, Time: time.Now()}
+		if err := r.Sign(key); err != nil {
+			panic(err)
+		}
+		return &r
+	}
+
+	Convey("it should only relay when configured to", t, func() {
+		_, err := h.Relay(sign("myData", "4"))
+		So(err, ShouldEqual, ErrRelayDisabled)
+	})
+
+	h.config.Relay = &RelayConfig{}
+
+	Convey("it should only relay for clients it lists", t, func() {
+		_, err := h.Relay(sign("myData", "4"))
+		So(err, ShouldEqual, ErrRelayClientNotAllowed)
+	})
+
+	h.config.Relay.Clients = []string{RelayAnyClient}
+
+	Convey("it should check the client's signature", t, func() {
+		r := sign("myData", "4")
+		So(r.Verify(), ShouldBeNil)
+		r.Entry = "6"
+		So(r.Verify(), ShouldEqual, ErrBadRelaySignature)
+		_, err := h.Relay(r)
+		So(err, ShouldEqual, ErrBadRelaySignature)
+	})
+
+	Convey("it should only relay for allowed clients", t, func() {
+		h.config.Relay.Clients = []string{"someone else"}
+		_, err := h.Relay(sign("myData", "4"))
+		So(err, ShouldEqual, ErrRelayClientNotAllowed)
+		r := sign("myData", "4")
+		h.config.Relay.Clients = []string{r.Client}
+		_, err = h.Relay(r)
+		So(err, ShouldBeNil)
+		h.config.Relay.Clients = []string{RelayAnyClient}
+	})
+
+	Convey("it should validate relayed entries", t, func() {
+		_, err := h.Relay(sign("myData", "3"))
+		So(err, ShouldNotBeNil)
+		_, err = h.Relay(sign("myZome:myData", "4"))
+		So(err, ShouldNotBeNil)
+		_, err = h.Relay(sign(AgentEntryType, "4"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should check the client's identity when the DNA requires one", t, func() {
+		me := peer.IDB58Encode(h.id)
+		h.Identity = &IdentityPolicy{Kind: IdentitySigned, Signers: []string{me}}
+		defer func() { h.Identity = nil }()
+		r := sign("myData", "14")
+		_, err := h.Relay(r)
+		So(err, ShouldEqual, ErrNoIdentity)
+
+		token, err := h.NewSignedIdentity(me, map[string]string{"name": "Herbert"})
+		So(err, ShouldBeNil)
+		r.Identity = &IdentityAssertion{Kind: IdentitySigned, Token: token}
+		_, err = h.Relay(r)
+		So(err, ShouldNotBeNil)
+
+		token, err = h.NewSignedIdentity(r.Client, map[string]string{"name": "Thin"})
+		So(err, ShouldBeNil)
+		r.Identity = &IdentityAssertion{Kind: IdentitySigned, Token: token}
+		_, err = h.Relay(r)
+		So(err, ShouldBeNil)
+
+		// validators refuse relayed puts whose client presents no identity
+		client, err := peer.IDB58Decode(r.Client)
+		So(err, ShouldBeNil)
+		_, err = h.dht.checkIdentity(client, nil)
+		So(err, ShouldEqual, ErrNoIdentity)
+	})
+
+	Convey("it should countersign and publish relayed entries", t, func() {
+		r := sign("myData", "4")
+		receipt, err := h.Relay(r)
+		So(err, ShouldBeNil)
+		So(receipt.Verify(), ShouldBeNil)
+		So(receipt.Client, ShouldEqual, r.Client)
+		So(receipt.ClientSignature, ShouldEqual, r.Signature)
+		hash, _ := r.Sum(h.hashSpec)
+		So(receipt.Hash, ShouldEqual, hash.String())
+		So(h.Metrics().Get("relay.entries"), ShouldEqual, 1)
+
+		receipt.Hash = "tampered"
+		So(receipt.Verify(), ShouldEqual, ErrBadRelaySignature)
+
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		So(h.dht.exists(hash), ShouldBeNil)
+	})
+
+	Convey("the relay should send the client's signed entry to validators", t, func() {
+		r := sign("myData", "8")
+		_, err := h.Relay(r)
+		So(err, ShouldBeNil)
+		hash, _ := r.Sum(h.hashSpec)
+		response, err := SrcReceiver(h, h.node.NewMessage(SRC_VALIDATE, hash))
+		So(err, ShouldBeNil)
+		resp := response.(*ValidateResponse)
+		So(resp.Type, ShouldEqual, "myData")
+		So(resp.Relay, ShouldNotBeNil)
+		source, err := h.dht.checkRelayed(resp.Relay, hash, resp.Type)
+		So(err, ShouldBeNil)
+		So(source, ShouldEqual, r.Client)
+		_, err = h.dht.checkRelayed(resp.Relay, hash, "profile")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("entries relayed for more than one client should be kept for each", t, func() {
+		other, _, err := ic.GenerateEd25519Key(strings.NewReader("other thin client key1234567890123456789012345678901234567"))
+		So(err, ShouldBeNil)
+		first := sign("myData", "10")
+		_, err = h.Relay(first)
+		So(err, ShouldBeNil)
+		second := RelayedEntry{Type: "myData", Entry: "10", Time: time.Now()}
+		So(second.Sign(other), ShouldBeNil)
+		_, err = h.Relay(&second)
+		So(err, ShouldBeNil)
+		hash, _ := first.Sum(h.hashSpec)
+		r, err := h.dht.getRelayed(hash)
+		So(err, ShouldBeNil)
+		So(r.Client, ShouldEqual, first.Client)
+	})
+
+	Convey("validators should check the time of relayed entries", t, func() {
+		r := sign("myData", "12")
+		r.Time = time.Now().Add(time.Hour)
+		So(r.Sign(key), ShouldBeNil)
+		hash, _ := r.Sum(h.hashSpec)
+		resp := &ValidateResponse{Type: "myData", Entry: &GobEntry{C: r.Entry}, Relay: r}
+		_, err := h.dht.checkRelayed(resp.Relay, hash, resp.Type)
+		So(err, ShouldBeNil)
+		So(h.checkHeaderTime(resp.Relay.Time), ShouldEqual, ErrHeaderFromFuture)
+	})
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// reload implements applying changes made to a running chain's config file.  Settings that
+// are read as they are used, like logging, gossip interval, quotas and the bootstrap server,
+// take effect straight away, while settings only read as the node starts are reported as
+// needing a restart.  A config file that doesn't validate is ignored.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// restartConfigFields are the config settings that are only read as the node starts
+var restartConfigFields = map[string]bool{
+	"Port":                  true,
+	"PeerModeAuthor":        true,
+	"PeerModeDHTNode":       true,
+	"DevMode":               true,
+	"Transports":            true,
+	"EncryptChain":          true,
+	"Quotas.PutQueueLength": true,
+	"Disabled":              true,
+}
+
+// ConfigChange describes a changed config setting found by a reload
+type ConfigChange struct {
+	Field   string
+	Applied bool // false if the change needs a restart to take effect
+}
+
+func (c ConfigChange) String() string {
+	if c.Applied {
+		return c.Field + " applied"
+	}
+	return c.Field + " requires restart"
+}
+
+// GossipIntervalDuration returns the configured gossip interval, which is zero if not set
+func (config *Config) GossipIntervalDuration() (interval time.Duration, err error) {
+	if config.GossipInterval == "" {
+		return
+	}
+	interval, err = time.ParseDuration(config.GossipInterval)
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("gossip interval must be positive, got: %s", config.GossipInterval)
+	}
+	return
+}
+
+// gossipInterval returns the configured gossip interval, or interval if none is configured
+func (dht *DHT) gossipInterval(interval time.Duration) time.Duration {
+	config := dht.h.Config()
+	if i, err := config.GossipIntervalDuration(); err == nil && i > 0 {
+		return i
+	}
+	return interval
+}
+
+// configChanges returns the names of the exported settings that differ between two configs,
+// with settings of nested structs named as Struct.Setting
+func configChanges(prefix string, old reflect.Value, new reflect.Value) (fields []string) {
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := prefix + f.Name
+		if f.Type.Kind() == reflect.Struct {
+			fields = append(fields, configChanges(name+".", old.Field(i), new.Field(i))...)
+			continue
+		}
+		a, _ := json.Marshal(old.Field(i).Interface())
+		b, _ := json.Marshal(new.Field(i).Interface())
+		if !bytes.Equal(a, b) {
+			fields = append(fields, name)
+		}
+	}
+	return
+}
+
+// configField returns the setting of the config with the given name
+func configField(config *Config, name string) (v reflect.Value) {
+	v = reflect.ValueOf(config).Elem()
+	for _, n := range strings.Split(name, ".") {
+		v = v.FieldByName(n)
+	}
+	return
+}
+
+// ReloadConfig reads the chain's config file, applying the changed settings that can be
+// changed while the chain runs.  It returns all the changes found.
+func (h *Holochain) ReloadConfig() (changes []ConfigChange, err error) {
+	var format string
+	if format, err = findEncodedFile(h.path, ConfigFileName); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(filepath.Join(h.path, ConfigFileName+"."+format)); err != nil {
+		return
+	}
+	defer f.Close()
+	var config Config
+	if err = Decode(f, format, &config); err != nil {
+		return
+	}
+	if err = config.setup(); err != nil {
+		return
+	}
+
+	var loggers, quotas, bootstrap, online bool
+	h.updateConfig(func(current *Config) {
+		updated := *current
+		for _, name := range configChanges("", reflect.ValueOf(*current), reflect.ValueOf(config)) {
+			c := ConfigChange{Field: name, Applied: !restartConfigFields[name]}
+			changes = append(changes, c)
+			if !c.Applied {
+				continue
+			}
+			configField(&updated, name).Set(configField(&config, name))
+			switch {
+			case strings.HasPrefix(name, "Loggers."):
+				loggers = true
+			case strings.HasPrefix(name, "Quotas."):
+				quotas = true
+			case name == "BootstrapServer":
+				bootstrap = true
+			case name == "Offline":
+				online = !updated.Offline
+			}
+		}
+		if loggers {
+			if err = updated.Loggers.setup(); err != nil {
+				return
+			}
+			if h.dht != nil {
+				h.dht.glog = updated.Loggers.Gossip
+				h.dht.dlog = updated.Loggers.DHT
+			}
+		}
+		*current = updated
+		if quotas {
+			h.quota = newQuotaState(&current.Quotas)
+		}
+	})
+	if err != nil {
+		return
+	}
+	config = h.Config()
+
+	if online {
+		h.goOnline()
+	} else if bootstrap && h.node != nil && config.PeerModeDHTNode && !config.Offline {
+		h.bootstrap()
+	}
+	return
+}
+
+// Config returns a copy of the chain's config, which may be replaced by a reload at any time
+func (h *Holochain) Config() (config Config) {
+	if h.configLk == nil {
+		return h.config
+	}
+	h.configLk.RLock()
+	config = h.config
+	h.configLk.RUnlock()
+	return
+}
+
+// updateConfig changes the chain's config with fn while holding the config lock
+func (h *Holochain) updateConfig(fn func(config *Config)) {
+	if h.configLk == nil {
+		fn(&h.config)
+		return
+	}
+	h.configLk.Lock()
+	fn(&h.config)
+	h.configLk.Unlock()
+}
+
+// quotas returns the chain's quota state, which is replaced when a reload changes the quotas
+func (h *Holochain) quotas() (q quotaState) {
+	if h.configLk == nil {
+		return h.quota
+	}
+	h.configLk.RLock()
+	q = h.quota
+	h.configLk.RUnlock()
+	return
+}
+
+// ConfigWatcher reloads a chain's config whenever its config file changes
+type ConfigWatcher struct {
+	h       *Holochain
+	watcher *fsnotify.Watcher
+}
+
+// WatchConfig starts reloading the chain's config whenever its config file changes, logging
+// which changes were applied and which need a restart
+func (h *Holochain) WatchConfig() (w *ConfigWatcher, err error) {
+	var watcher *fsnotify.Watcher
+	if watcher, err = fsnotify.NewWatcher(); err != nil {
+		return
+	}
+	// the directory is watched, rather than the file, as editors often save by replacing it
+	if err = watcher.Add(h.path); err != nil {
+		watcher.Close()
+		return
+	}
+	w = &ConfigWatcher{h: h, watcher: watcher}
+	go w.watch()
+	return
+}
+
+func (w *ConfigWatcher) watch() {
+	h := w.h
+	for {
+		select {
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(filepath.Base(ev.Name), ConfigFileName+".") || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			changes, err := h.ReloadConfig()
+			if err != nil {
+				Infof("%s: config change not applied: %v", h.Name, err)
+				continue
+			}
+			for _, c := range changes {
+				Infof("%s: config reload: %v", h.Name, c)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			Infof("%s: error watching config: %v", h.Name, err)
+		}
+	}
+}
+
+// Close stops watching the config file
+func (w *ConfigWatcher) Close() error {
+	return w.watcher.Close()
+}
@@ -0,0 +1,67 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	write := func(config *Config) {
+		f, err := os.Create(filepath.Join(h.path, ConfigFileName+"."+h.encodingFormat))
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		if err = Encode(f, h.encodingFormat, config); err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should report no changes for an unchanged config", t, func() {
+		changes, err := h.ReloadConfig()
+		So(err, ShouldBeNil)
+		So(len(changes), ShouldEqual, 0)
+	})
+
+	Convey("it should apply runtime settings and report those needing a restart", t, func() {
+		config := h.config
+		config.GossipInterval = "5s"
+		config.Loggers.DHT.Enabled = !h.config.Loggers.DHT.Enabled
+		config.Quotas.MaxConcurrentCalls = 7
+		config.Quotas.PutQueueLength = 3
+		config.Port = h.config.Port + 1
+		port := h.config.Port
+		write(&config)
+
+		changes, err := h.ReloadConfig()
+		So(err, ShouldBeNil)
+		So(changes, ShouldResemble, []ConfigChange{
+			{Field: "Port", Applied: false},
+			{Field: "Loggers.DHT.Enabled", Applied: true},
+			{Field: "Quotas.PutQueueLength", Applied: false},
+			{Field: "Quotas.MaxConcurrentCalls", Applied: true},
+			{Field: "GossipInterval", Applied: true},
+		})
+		So(h.config.Port, ShouldEqual, port)
+		So(h.dht.gossipInterval(time.Second), ShouldEqual, 5*time.Second)
+		So(h.dht.dlog.Enabled, ShouldEqual, config.Loggers.DHT.Enabled)
+		So(cap(h.quota.calls), ShouldEqual, 7)
+		So(changes[0].String(), ShouldEqual, "Port requires restart")
+		So(changes[1].String(), ShouldEqual, "Loggers.DHT.Enabled applied")
+	})
+
+	Convey("it should ignore a config that doesn't validate", t, func() {
+		config := h.config
+		config.GossipInterval = "fish"
+		write(&config)
+		_, err := h.ReloadConfig()
+		So(err, ShouldNotBeNil)
+		So(h.config.GossipInterval, ShouldEqual, "5s")
+	})
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// reputation implements tracking of how well peers behave when gossiping and handling dht
+// requests, scoring them so that misbehaving peers are chosen less often as gossip partners
+// or are temporarily banned
+
+package holochain
+
+import (
+	"encoding/json"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"math/rand"
+	"time"
+)
+
+// PeerEvent identifies a kind of behavior observed from a peer
+type PeerEvent int
+
+const (
+	PeerSuccess    PeerEvent = iota // the peer answered a request correctly
+	PeerInvalidPut                  // the peer sent a put that failed validation
+	PeerTimeout                     // a request to the peer failed or timed out
+	PeerViolation                   // the peer sent a message that broke the protocol
+)
+
+const (
+	BanThreshold    = 0.2 // peers whose score falls below this are temporarily banned
+	BanDuration     = 10 * time.Minute
+	MinBanEvents    = 5 // don't ban peers until this many failures have been seen
+	invalidPutCost  = 5
+	timeoutCost     = 2
+	violationCost   = 10
+	uptimeBonusUnit = time.Hour
+)
+
+// PeerRecord holds the behavior observed from a peer
+type PeerRecord struct {
+	Id          peer.ID
+	Successes   int
+	InvalidPuts int
+	Timeouts    int
+	Violations  int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	BannedUntil time.Time
+	Unreachable bool // the last request to the peer failed
+}
+
+// Score returns a reputation between 0 and 1, where 1 is a peer that has never misbehaved.
+// Successes and time since the peer was first seen count in its favor.
+func (r *PeerRecord) Score() float64 {
+	good := float64(r.Successes+1) + float64(r.LastSeen.Sub(r.FirstSeen)/uptimeBonusUnit)
+	bad := float64(r.InvalidPuts*invalidPutCost + r.Timeouts*timeoutCost + r.Violations*violationCost)
+	return good / (good + bad)
+}
+
+// Banned returns true if the peer is currently banned
+func (r *PeerRecord) Banned() bool {
+	return time.Now().Before(r.BannedUntil)
+}
+
+func (r *PeerRecord) failures() int {
+	return r.InvalidPuts + r.Timeouts + r.Violations
+}
+
+func peerRecordKey(id peer.ID) string {
+	return "rep:" + peer.IDB58Encode(id)
+}
+
+func getPeerRecord(tx *buntdb.Tx, id peer.ID) (r PeerRecord, err error) {
+	var val string
+	val, err = tx.Get(peerRecordKey(id))
+	if err == buntdb.ErrNotFound {
+		err = nil
+		r.Id = id
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(val), &r)
+	return
+}
+
+// RecordPeerEvent updates the reputation of a peer with an observed behavior, banning the
+// peer if its score falls too low
+func (dht *DHT) RecordPeerEvent(id peer.ID, event PeerEvent) (err error) {
+	if id == "" || id == dht.h.id {
+		return
+	}
+	var changed EventMask
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		r, err := getPeerRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		wasUnreachable := r.Unreachable
+		now := time.Now()
+		if r.FirstSeen.IsZero() {
+			r.FirstSeen = now
+		}
+		switch event {
+		case PeerSuccess:
+			r.Successes++
+			r.LastSeen = now
+			r.Unreachable = false
+			if err = keepPeerAddr(tx, id); err != nil {
+				return err
+			}
+			if wasUnreachable {
+				changed = EventPeerJoined
+			}
+		case PeerInvalidPut:
+			r.InvalidPuts++
+			r.LastSeen = now
+		case PeerTimeout:
+			r.Timeouts++
+			r.Unreachable = true
+			if !wasUnreachable {
+				changed = EventPeerLeft
+			}
+		case PeerViolation:
+			r.Violations++
+			r.LastSeen = now
+		}
+		if event != PeerSuccess && r.failures() >= MinBanEvents && r.Score() < BanThreshold && !r.Banned() {
+			r.BannedUntil = now.Add(BanDuration)
+			dht.glog.Logf("banning %v until %v, score: %.2f", id, r.BannedUntil, r.Score())
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(peerRecordKey(id), string(b), nil)
+		return err
+	})
+	if err == nil && changed != 0 {
+		dht.h.emit(Event{Type: changed, Peer: id})
+		if changed == EventPeerLeft {
+			go dht.handOffFrom(id)
+		}
+	}
+	return
+}
+
+// GetPeerRecord returns the reputation record of a peer
+func (dht *DHT) GetPeerRecord(id peer.ID) (r PeerRecord, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		r, e = getPeerRecord(tx, id)
+		return e
+	})
+	return
+}
+
+// pickGossiper chooses randomly among gossipers that aren't banned, weighted by reputation
+func (dht *DHT) pickGossiper(glist []Gossiper) (g *Gossiper, err error) {
+	scores := make([]float64, len(glist))
+	var total float64
+	for i := range glist {
+		var r PeerRecord
+		r, err = dht.GetPeerRecord(glist[i].Id)
+		if err != nil {
+			return
+		}
+		if !r.Banned() {
+			scores[i] = r.Score()
+			total += scores[i]
+		}
+	}
+	if total == 0 {
+		err = ErrDHTErrNoGossipersAvailable
+		return
+	}
+	x := rand.Float64() * total
+	for i := range glist {
+		if scores[i] == 0 {
+			continue
+		}
+		g = &glist[i]
+		x -= scores[i]
+		if x < 0 {
+			break
+		}
+	}
+	return
+}
+
+// recordPeerEvent records a peer event, logging rather than returning any error
+func (dht *DHT) recordPeerEvent(id peer.ID, event PeerEvent) {
+	if err := dht.RecordPeerEvent(id, event); err != nil {
+		dht.glog.Logf("error recording peer event: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestPeerRecordScore(t *testing.T) {
+	Convey("a new peer should have a perfect score", t, func() {
+		r := PeerRecord{}
+		So(r.Score(), ShouldEqual, 1)
+		So(r.Banned(), ShouldBeFalse)
+	})
+	Convey("misbehavior should lower the score", t, func() {
+		r := PeerRecord{Successes: 1, Timeouts: 1}
+		So(r.Score(), ShouldEqual, 0.5)
+		r.Violations = 1
+		So(r.Score(), ShouldBeLessThan, 0.5)
+	})
+	Convey("uptime should count in the peer's favor", t, func() {
+		now := time.Now()
+		r := PeerRecord{Timeouts: 1, FirstSeen: now.Add(-2 * time.Hour), LastSeen: now}
+		So(r.Score(), ShouldEqual, 0.6)
+	})
+}
+
+func TestRecordPeerEvent(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	good, _ := makePeer("good")
+	bad, _ := makePeer("bad")
+
+	Convey("it should ignore events about ourselves", t, func() {
+		err := dht.RecordPeerEvent(h.id, PeerViolation)
+		So(err, ShouldBeNil)
+		r, err := dht.GetPeerRecord(h.id)
+		So(err, ShouldBeNil)
+		So(r.Violations, ShouldEqual, 0)
+	})
+
+	Convey("it should record events", t, func() {
+		dht.RecordPeerEvent(good, PeerSuccess)
+		dht.RecordPeerEvent(good, PeerSuccess)
+		dht.RecordPeerEvent(good, PeerTimeout)
+		r, err := dht.GetPeerRecord(good)
+		So(err, ShouldBeNil)
+		So(r.Id, ShouldEqual, good)
+		So(r.Successes, ShouldEqual, 2)
+		So(r.Timeouts, ShouldEqual, 1)
+		So(r.Banned(), ShouldBeFalse)
+	})
+
+	Convey("it should ban peers whose score falls too low", t, func() {
+		for i := 0; i < MinBanEvents-1; i++ {
+			dht.RecordPeerEvent(bad, PeerInvalidPut)
+		}
+		r, _ := dht.GetPeerRecord(bad)
+		So(r.Banned(), ShouldBeFalse)
+		dht.RecordPeerEvent(bad, PeerInvalidPut)
+		r, _ = dht.GetPeerRecord(bad)
+		So(r.Banned(), ShouldBeTrue)
+	})
+
+	Convey("banned peers should not be picked for gossip", t, func() {
+		glist := []Gossiper{{Id: bad}}
+		_, err := dht.pickGossiper(glist)
+		So(err, ShouldEqual, ErrDHTErrNoGossipersAvailable)
+		glist = append(glist, Gossiper{Id: good})
+		for i := 0; i < 10; i++ {
+			g, err := dht.pickGossiper(glist)
+			So(err, ShouldBeNil)
+			So(g.Id, ShouldEqual, good)
+		}
+	})
+
+	Convey("protocol violations should be recorded by the receiver", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, "fish")
+		m.From = good
+		_, err := DHTReceiver(h, m)
+		So(err, ShouldEqual, ErrDHTExpectedPutReqInBody)
+		r, _ := dht.GetPeerRecord(good)
+		So(r.Violations, ShouldEqual, 1)
+	})
+
+	Convey("bad gossiped messages should be the gossiper's violation, not their claimed source's", t, func() {
+		gossiper, _ := makePeer("gossiper")
+		m := h.node.NewMessage(PUT_REQUEST, "fish")
+		m.From = good
+		taken, _ := dht.receiveGossipedPuts(gossiper, []Put{{M: *m}})
+		So(taken, ShouldEqual, 1)
+		r, _ := dht.GetPeerRecord(good)
+		So(r.Violations, ShouldEqual, 1)
+		r, _ = dht.GetPeerRecord(gossiper)
+		So(r.Violations, ShouldEqual, 1)
+	})
+}
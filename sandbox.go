@@ -0,0 +1,145 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// sandbox implements setting up a throwaway network of local nodes running the same app, for
+// demoing and testing apps with several agents.  Each node gets its own service and agent in a
+// temporary directory, and its chain is configured to find the others through a bootstrap
+// server run by whoever runs the sandbox.  Nodes are numbered from 0 and use the next free
+// ports after those asked for.  The sandbox closes each node's stores once it is set up, as the
+// nodes are run by other processes.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultSandboxNodes = 3
+	DefaultSandboxPort  = 4141 // port the first node's UI is served on
+	SandboxDHTBasePort  = 6383 // port the first node listens to other nodes on
+	SandboxChainName    = "sandbox"
+	SandboxPortTries    = 100 // how many ports past the one asked for are tried for a free one
+)
+
+// SandboxNode is one of the nodes of a sandbox
+type SandboxNode struct {
+	Name    string
+	Service *Service
+	Chain   *Holochain
+	Port    int // port its UI and API are served on
+}
+
+// URL returns where the node's UI and API are served
+func (n *SandboxNode) URL() string {
+	return fmt.Sprintf("http://localhost:%d", n.Port)
+}
+
+// Sandbox is a network of local nodes running the same app
+type Sandbox struct {
+	Root  string // temporary directory holding the nodes
+	Nodes []*SandboxNode
+}
+
+// freePort returns the first port from the given one that nothing is listening on
+func freePort(from int) (port int, err error) {
+	for port = from; port < from+SandboxPortTries; port++ {
+		l, e := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if e == nil {
+			err = l.Close()
+			return
+		}
+	}
+	err = fmt.Errorf("no free port from %d to %d", from, port-1)
+	return
+}
+
+// closeStores closes a sandbox node's chain file and DHT store so that its process can open them
+func (h *Holochain) closeStores() (err error) {
+	err = h.chain.Close()
+	if h.dht != nil {
+		if e := h.dht.db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// NewSandbox sets up n nodes in a temporary directory running the app at src, or the
+// development app if src is empty.  The first node's UI is served on port, or the first free
+// port after it, and the rest on the free ports after that, and the nodes bootstrap from the
+// bootstrap server at bootstrap.
+func NewSandbox(src string, n int, port int, bootstrap string) (sb *Sandbox, err error) {
+	if n < 1 {
+		err = errors.New("a sandbox needs at least one node")
+		return
+	}
+	var root string
+	if root, err = ioutil.TempDir("", "hc-sandbox"); err != nil {
+		return
+	}
+	sb = &Sandbox{Root: root}
+	defer func() {
+		if err != nil {
+			sb.Remove()
+			sb = nil
+		}
+	}()
+	uiPort, dhtPort := port, SandboxDHTBasePort
+	for i := 0; i < n; i++ {
+		if uiPort, err = freePort(uiPort); err != nil {
+			return
+		}
+		if dhtPort, err = freePort(dhtPort); err != nil {
+			return
+		}
+		name := fmt.Sprintf("node%d", i)
+		var s *Service
+		if s, err = Init(filepath.Join(root, name), AgentName(fmt.Sprintf("sandbox %s <%s@sandbox>", name, name))); err != nil {
+			return
+		}
+		path := filepath.Join(s.Path, SandboxChainName)
+		var h *Holochain
+		switch {
+		case i > 0:
+			h, err = s.Clone(sb.Nodes[0].Chain.path, path, false)
+		case src != "":
+			h, err = s.Clone(src, path, true)
+		default:
+			h, err = s.GenDev(path, "toml")
+		}
+		if err != nil {
+			return
+		}
+		if _, err = h.GenChain(); err != nil {
+			return
+		}
+		h.config.Port = dhtPort
+		h.config.PeerModeDHTNode = true
+		h.config.PeerModeAuthor = true
+		h.config.BootstrapServer = bootstrap
+		h.config.Offline = false
+		err = h.saveConfig()
+		if e := h.closeStores(); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			return
+		}
+		sb.Nodes = append(sb.Nodes, &SandboxNode{Name: name, Service: s, Chain: h, Port: uiPort})
+		uiPort++
+		dhtPort++
+	}
+	return
+}
+
+// Remove deletes the sandbox's nodes
+func (sb *Sandbox) Remove() error {
+	return os.RemoveAll(sb.Root)
+}
@@ -0,0 +1,78 @@
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestBootstrapServer(t *testing.T) {
+	ts := httptest.NewServer(NewBootstrapServer())
+	defer ts.Close()
+
+	Convey("it should list the nodes posted to a chain", t, func() {
+		b, _ := json.Marshal(BSReq{Version: 1, NodeID: "node1", NodeAddr: "/ip4/127.0.0.1/tcp/6383"})
+		resp, err := http.Post(ts.URL+"/chain1/node1", "application/json", bytes.NewBuffer(b))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		resp.Body.Close()
+
+		var nodes []BSResp
+		resp, err = http.Get(ts.URL + "/chain1")
+		So(err, ShouldBeNil)
+		So(json.NewDecoder(resp.Body).Decode(&nodes), ShouldBeNil)
+		resp.Body.Close()
+		So(len(nodes), ShouldEqual, 1)
+		So(nodes[0].Req.NodeAddr, ShouldEqual, "/ip4/127.0.0.1/tcp/6383")
+
+		resp, _ = http.Get(ts.URL + "/chain2")
+		So(json.NewDecoder(resp.Body).Decode(&nodes), ShouldBeNil)
+		resp.Body.Close()
+		So(len(nodes), ShouldEqual, 0)
+	})
+}
+
+func TestNewSandbox(t *testing.T) {
+	Convey("it should refuse a sandbox without nodes", t, func() {
+		_, err := NewSandbox("", 0, DefaultSandboxPort, "localhost:3142")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should set up nodes of the same app with their own agents", t, func() {
+		sb, err := NewSandbox("", 2, DefaultSandboxPort, "localhost:3142")
+		So(err, ShouldBeNil)
+		defer sb.Remove()
+		So(len(sb.Nodes), ShouldEqual, 2)
+		n0, n1 := sb.Nodes[0], sb.Nodes[1]
+		So(n0.Chain.DNAHash().String(), ShouldEqual, n1.Chain.DNAHash().String())
+		So(n0.Chain.Agent().Name(), ShouldNotEqual, n1.Chain.Agent().Name())
+		So(n0.Port, ShouldBeGreaterThanOrEqualTo, DefaultSandboxPort)
+		So(n1.Port, ShouldBeGreaterThan, n0.Port)
+		So(n1.URL(), ShouldEqual, fmt.Sprintf("http://localhost:%d", n1.Port))
+		So(n1.Chain.config.Port, ShouldBeGreaterThan, n0.Chain.config.Port)
+		So(n1.Chain.config.BootstrapServer, ShouldEqual, "localhost:3142")
+
+		h, err := n1.Service.Load(SandboxChainName)
+		So(err, ShouldBeNil)
+		So(h.config.BootstrapServer, ShouldEqual, "localhost:3142")
+		So(sb.Remove(), ShouldBeNil)
+		_, err = os.Stat(sb.Root)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("it should skip ports that are in use", t, func() {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", SandboxDHTBasePort))
+		if err == nil {
+			defer l.Close()
+		}
+		port, err := freePort(SandboxDHTBasePort)
+		So(err, ShouldBeNil)
+		So(port, ShouldBeGreaterThan, SandboxDHTBasePort)
+	})
+}
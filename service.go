@@ -6,21 +6,32 @@
 package holochain
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 // System settings, directory, and file names
 const (
-	DefaultDirectoryName string = ".holochain"  // Directory for storing config data
-	DNAFileName          string = "dna"         // Definition of the Holochain
-	ConfigFileName       string = "config"      // Settings of the Holochain
-	SysFileName          string = "system.conf" // Server & System settings
-	AgentFileName        string = "agent.txt"   // User ID info
-	PrivKeyFileName      string = "priv.key"    // Signing key - private
-	StoreFileName        string = "chain"       // Filename for local data store
-	DNAHashFileName      string = "dna.hash"    // Filename for storing the hash of the holochain
+	DefaultDirectoryName   string = ".holochain"      // Directory for storing config data
+	DNAFileName            string = "dna"             // Definition of the Holochain
+	ConfigFileName         string = "config"          // Settings of the Holochain
+	SysFileName            string = "system.conf"     // Server & System settings
+	AgentFileName          string = "agent.txt"       // User ID info
+	PrivKeyFileName        string = "priv.key"        // Signing key - private
+	StoreFileName          string = "chain"           // Filename for local data store
+	DNAHashFileName        string = "dna.hash"        // Filename for storing the hash of the holochain
+	AdminTokenFileName     string = "admin.token"     // Token authorizing access to administrative functions
+	APITokensFileName      string = "api.tokens"      // Tokens authorizing scoped access to chain functions
+	GenesisPendingFileName string = "genesis.pending" // Marks a chain whose genesis hasn't been published
 
 	DefaultPort = 6283
 )
@@ -30,47 +41,91 @@ type ServiceConfig struct {
 	DefaultPeerModeAuthor  bool
 	DefaultPeerModeDHTNode bool
 	DefaultBootstrapServer string
+	DefaultRegistry        string // url of the index of apps searched and installed from
+	DefaultEncryptChain    bool   // whether new chains keep their source chain encrypted
+	IdentityCommand        string // command printing the agent's identity assertion for chains that require one
+}
+
+// DefaultServiceConfig returns the settings of a newly initialized service
+func DefaultServiceConfig() ServiceConfig {
+	return ServiceConfig{
+		DefaultPeerModeDHTNode: true,
+		DefaultPeerModeAuthor:  true,
+		DefaultBootstrapServer: "bootstrap.holochain.net:10000",
+	}
 }
 
 // Holochain service data structure
 type Service struct {
 	Settings     ServiceConfig
 	DefaultAgent Agent
-	Path         string
+	Path         string // directory holding the chains
+	ConfigPath   string // directory holding the service settings and agent, usually Path
+
+	// GetPassphrase returns the passphrase of an encrypted chain.  If it isn't set the
+	// passphrase is taken from the HC_PASSPHRASE environment variable.
+	GetPassphrase func(chain string) (string, error)
+
+	// OnProgress, if set, is called as long running operations like Clone make progress
+	OnProgress func(Progress)
+
+	progressOps progressState // the latest progress of the service's operations
+}
+
+// passphrase returns the passphrase for an encrypted chain
+func (s *Service) passphrase(chain string) (passphrase string, err error) {
+	if s.GetPassphrase != nil {
+		passphrase, err = s.GetPassphrase(chain)
+	} else {
+		passphrase = os.Getenv(PassphraseEnvVar)
+	}
+	if err == nil && passphrase == "" {
+		err = ErrNoPassphrase
+	}
+	return
 }
 
 // IsInitialized checks a path for a correctly set up .holochain directory
 func IsInitialized(root string) bool {
-	return dirExists(root) && fileExists(root+"/"+SysFileName) && fileExists(root+"/"+AgentFileName)
+	return SingleChainDirs(root).IsInitialized()
 }
 
 // Init initializes service defaults including a signing key pair for an agent
 // and writes them out to configuration files in the root path (making the
 // directory if necessary)
 func Init(root string, agent AgentName) (service *Service, err error) {
-	err = os.MkdirAll(root, os.ModePerm)
-	if err != nil {
-		return
+	service, err = InitDirs(SingleChainDirs(root), agent)
+	return
+}
+
+// InitDirs is like Init but with the configuration files and chains kept in
+// the given directories
+func InitDirs(dirs ChainDirs, agent AgentName) (service *Service, err error) {
+	for _, d := range []string{dirs.Config, dirs.Data} {
+		err = os.MkdirAll(d, os.ModePerm)
+		if err != nil {
+			return
+		}
 	}
 	s := Service{
-		Settings: ServiceConfig{
-			DefaultPeerModeDHTNode: true,
-			DefaultPeerModeAuthor:  true,
-			DefaultBootstrapServer: "bootstrap.holochain.net:10000",
-		},
-		Path: root,
+		Settings:   DefaultServiceConfig(),
+		Path:       dirs.Data,
+		ConfigPath: dirs.Config,
 	}
 
-	err = writeToml(root, SysFileName, s.Settings, false)
+	err = writeToml(dirs.Config, SysFileName, s.Settings, false)
 	if err != nil {
 		return
 	}
+	if err = writeFormat(dirs, CurrentFormat); err != nil {
+		return
+	}
 
 	a, err := NewAgent(IPFS, agent)
 	if err != nil {
 		return
 	}
-	err = SaveAgent(root, a)
+	err = SaveAgent(dirs.Config, a)
 	if err != nil {
 		return
 	}
@@ -83,16 +138,31 @@ func Init(root string, agent AgentName) (service *Service, err error) {
 
 // LoadService creates a service object from a configuration file
 func LoadService(path string) (service *Service, err error) {
-	agent, err := LoadAgent(path)
+	service, err = LoadServiceDirs(SingleChainDirs(path))
+	return
+}
+
+// LoadServiceDirs creates a service object from the configuration files and
+// chains in the given directories
+func LoadServiceDirs(dirs ChainDirs) (service *Service, err error) {
+	agent, err := LoadAgent(dirs.Config)
 	if err != nil {
 		return
 	}
+	notes, err := MigrateDirs(dirs)
+	if err != nil {
+		return
+	}
+	for _, n := range notes {
+		Infof("migrated: %s", n)
+	}
 	s := Service{
-		Path:         path,
+		Path:         dirs.Data,
+		ConfigPath:   dirs.Config,
 		DefaultAgent: agent,
 	}
 
-	_, err = toml.DecodeFile(path+"/"+SysFileName, &s.Settings)
+	_, err = toml.DecodeFile(filepath.Join(dirs.Config, SysFileName), &s.Settings)
 	if err != nil {
 		return
 	}
@@ -101,6 +171,105 @@ func LoadService(path string) (service *Service, err error) {
 	return
 }
 
+// RepairDirs checks an existing, possibly partial or older, service installation, creating
+// whatever is missing and filling in any settings added since it was initialized.  The agent
+// is only used if the installation doesn't have one.  It returns a description of each repair.
+func RepairDirs(dirs ChainDirs, agent AgentName) (service *Service, repairs []string, err error) {
+	for _, d := range []string{dirs.Config, dirs.Data} {
+		if !dirExists(d) {
+			if err = os.MkdirAll(d, os.ModePerm); err != nil {
+				return
+			}
+			repairs = append(repairs, "created directory "+d)
+		}
+	}
+
+	defaults := DefaultServiceConfig()
+	var settings ServiceConfig
+	if !fileExists(filepath.Join(dirs.Config, SysFileName)) {
+		settings = defaults
+		repairs = append(repairs, "created "+SysFileName+" with the default settings")
+	} else {
+		var md toml.MetaData
+		md, err = toml.DecodeFile(filepath.Join(dirs.Config, SysFileName), &settings)
+		if err != nil {
+			return
+		}
+		sv := reflect.ValueOf(&settings).Elem()
+		dv := reflect.ValueOf(defaults)
+		for i := 0; i < sv.NumField(); i++ {
+			name := sv.Type().Field(i).Name
+			if !md.IsDefined(name) {
+				sv.Field(i).Set(dv.Field(i))
+				repairs = append(repairs, fmt.Sprintf("added setting %s = %v", name, dv.Field(i).Interface()))
+			}
+		}
+	}
+	if len(repairs) > 0 {
+		if err = writeToml(dirs.Config, SysFileName, settings, true); err != nil {
+			return
+		}
+	}
+
+	hasName := fileExists(filepath.Join(dirs.Config, AgentFileName))
+	hasKey := fileExists(filepath.Join(dirs.Config, PrivKeyFileName))
+	switch {
+	case hasName && !hasKey:
+		var name []byte
+		if name, err = readFile(dirs.Config, AgentFileName); err != nil {
+			return
+		}
+		var a Agent
+		if a, err = NewAgent(IPFS, AgentName(name)); err != nil {
+			return
+		}
+		var k []byte
+		if k, err = a.PrivKey().Bytes(); err != nil {
+			return
+		}
+		if err = writeFile(dirs.Config, PrivKeyFileName, k); err != nil {
+			return
+		}
+		repairs = append(repairs, "generated missing key-pair for agent "+string(name))
+	case !hasName:
+		if agent == "" {
+			err = errors.New("service has no agent, an agent-id is needed to repair it")
+			return
+		}
+		if hasKey {
+			err = writeFile(dirs.Config, AgentFileName, []byte(agent))
+			repairs = append(repairs, "restored agent name "+string(agent))
+		} else {
+			var a Agent
+			if a, err = NewAgent(IPFS, agent); err != nil {
+				return
+			}
+			err = SaveAgent(dirs.Config, a)
+			repairs = append(repairs, "generated agent "+string(agent))
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	service, err = LoadServiceDirs(dirs)
+	return
+}
+
+// SaveSettings writes out the service settings
+func (s *Service) SaveSettings() error {
+	return writeToml(s.Dirs().Config, SysFileName, s.Settings, true)
+}
+
+// Dirs returns the directories of the service
+func (s *Service) Dirs() ChainDirs {
+	dirs := ChainDirs{Config: s.ConfigPath, Data: s.Path}
+	if dirs.Config == "" {
+		dirs.Config = s.Path
+	}
+	return dirs
+}
+
 // ConfiguredChains returns a list of the configured chains for the given service
 func (s *Service) ConfiguredChains() (chains map[string]*Holochain, err error) {
 	files, err := ioutil.ReadDir(s.Path)
@@ -118,3 +287,39 @@ func (s *Service) ConfiguredChains() (chains map[string]*Holochain, err error) {
 	}
 	return
 }
+
+// AdminToken returns the token which authorizes access to the service's administrative
+// functions, generating it if it doesn't yet exist
+func (s *Service) AdminToken() (token string, err error) {
+	p := s.AdminTokenPath()
+	if fileExists(p) {
+		var b []byte
+		b, err = ioutil.ReadFile(p)
+		if err != nil {
+			return
+		}
+		token = strings.TrimSpace(string(b))
+		return
+	}
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = hex.EncodeToString(b)
+	err = ioutil.WriteFile(p, []byte(token), 0600)
+	return
+}
+
+// AdminTokenPath returns the path of the file holding the service's admin token
+func (s *Service) AdminTokenPath() string {
+	return filepath.Join(s.Dirs().Config, AdminTokenFileName)
+}
+
+// CheckAdminToken returns true if token matches the service's admin token
+func (s *Service) CheckAdminToken(token string) bool {
+	t, err := s.AdminToken()
+	if err != nil || t == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1
+}
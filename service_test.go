@@ -3,6 +3,9 @@ package holochain
 import (
 	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -22,7 +25,7 @@ func TestInit(t *testing.T) {
 
 		Convey("it should return a service with default values", func() {
 			So(s.DefaultAgent.Name(), ShouldEqual, AgentName(agent))
-			So(fmt.Sprintf("%v", s.Settings), ShouldEqual, "{true true bootstrap.holochain.net:10000}")
+			So(fmt.Sprintf("%v", s.Settings), ShouldEqual, "{true true bootstrap.holochain.net:10000  false }")
 		})
 
 		p := d + "/" + DefaultDirectoryName
@@ -44,6 +47,63 @@ func TestInit(t *testing.T) {
 	})
 }
 
+func TestRepairDirs(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	dirs := SingleChainDirs(filepath.Join(d, DefaultDirectoryName))
+
+	Convey("it should need an agent to repair an empty installation", t, func() {
+		empty := SingleChainDirs(filepath.Join(d, "empty"))
+		So(empty.HasService(), ShouldBeFalse)
+		_, _, err := RepairDirs(empty, "")
+		So(err.Error(), ShouldEqual, "service has no agent, an agent-id is needed to repair it")
+	})
+
+	s, err := Init(dirs.Config, "Herbert <h@bert.com>")
+	if err != nil {
+		panic(err)
+	}
+	pub, _ := s.DefaultAgent.PrivKey().GetPublic().Bytes()
+
+	Convey("it should find nothing to repair in a fresh installation", t, func() {
+		So(dirs.HasService(), ShouldBeTrue)
+		_, repairs, err := RepairDirs(dirs, "")
+		So(err, ShouldBeNil)
+		So(len(repairs), ShouldEqual, 0)
+	})
+
+	Convey("it should fill in settings added since the installation", t, func() {
+		err := ioutil.WriteFile(filepath.Join(dirs.Config, SysFileName), []byte("DefaultPeerModeAuthor = false\n"), 0600)
+		So(err, ShouldBeNil)
+		s, repairs, err := RepairDirs(dirs, "")
+		So(err, ShouldBeNil)
+		So(len(repairs), ShouldEqual, 4)
+		So(repairs[0], ShouldEqual, "added setting DefaultPeerModeDHTNode = true")
+		So(s.Settings.DefaultPeerModeAuthor, ShouldBeFalse)
+		So(s.Settings.DefaultRegistry, ShouldEqual, DefaultServiceConfig().DefaultRegistry)
+	})
+
+	Convey("it should restore a missing agent name without changing the keys", t, func() {
+		So(os.Remove(filepath.Join(dirs.Config, AgentFileName)), ShouldBeNil)
+		s, repairs, err := RepairDirs(dirs, "Fred <f@red.com>")
+		So(err, ShouldBeNil)
+		So(repairs, ShouldResemble, []string{"restored agent name Fred <f@red.com>"})
+		So(s.DefaultAgent.Name(), ShouldEqual, AgentName("Fred <f@red.com>"))
+		p, _ := s.DefaultAgent.PrivKey().GetPublic().Bytes()
+		So(p, ShouldResemble, pub)
+	})
+
+	Convey("it should generate missing keys", t, func() {
+		So(os.Remove(filepath.Join(dirs.Config, PrivKeyFileName)), ShouldBeNil)
+		s, repairs, err := RepairDirs(dirs, "")
+		So(err, ShouldBeNil)
+		So(repairs, ShouldResemble, []string{"generated missing key-pair for agent Fred <f@red.com>"})
+		So(dirs.IsInitialized(), ShouldBeTrue)
+		p, _ := s.DefaultAgent.PrivKey().GetPublic().Bytes()
+		So(p, ShouldNotResemble, pub)
+	})
+}
+
 func TestLoadService(t *testing.T) {
 	d, service := setupTestService()
 	root := service.Path
@@ -71,3 +131,24 @@ func TestConfiguredChains(t *testing.T) {
 		So(chains["test"].Id, ShouldEqual, h.Id)
 	})
 }
+
+func TestAdminToken(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+	Convey("it should generate the admin token on first use", t, func() {
+		So(fileExists(s.Path+"/"+AdminTokenFileName), ShouldBeFalse)
+		token, err := s.AdminToken()
+		So(err, ShouldBeNil)
+		So(len(token), ShouldEqual, 64)
+		So(fileExists(s.Path+"/"+AdminTokenFileName), ShouldBeTrue)
+		token2, err := s.AdminToken()
+		So(err, ShouldBeNil)
+		So(token2, ShouldEqual, token)
+	})
+	Convey("it should check tokens", t, func() {
+		token, _ := s.AdminToken()
+		So(s.CheckAdminToken(token), ShouldBeTrue)
+		So(s.CheckAdminToken(""), ShouldBeFalse)
+		So(s.CheckAdminToken("fish"), ShouldBeFalse)
+	})
+}
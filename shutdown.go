@@ -0,0 +1,102 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// shutdown implements stopping a chain gracefully: refusing new commits and puts, handing off
+// to peers the entries that would be left held by too few nodes, letting the puts already
+// queued be handled, publishing what is waiting in the outbox, and syncing and closing the
+// chain's file.
+
+package holochain
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultShutdownTimeout = 10 * time.Second
+
+var ErrShuttingDown error = errors.New("chain is shutting down")
+
+// ShuttingDown returns true once the chain has begun shutting down
+func (h *Holochain) ShuttingDown() bool {
+	return atomic.LoadInt32(&h.shuttingDown) == 1
+}
+
+// runEvery calls fn every interval until the chain begins shutting down
+func (h *Holochain) runEvery(interval time.Duration, fn func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-t.C:
+			fn()
+		}
+	}
+}
+
+// drained returns true if there are no puts queued or being handled
+func (dht *DHT) drained() bool {
+	return len(dht.puts) == 0 && atomic.LoadInt32(&dht.inflight) <= 0
+}
+
+// Shutdown stops the chain, waiting up to timeout for queued puts to be handled before
+// publishing the outbox and closing the chain's file and the node
+func (h *Holochain) Shutdown(timeout time.Duration) (err error) {
+	if h.chain != nil {
+		// set under the chain's lock so that no commit that saw the chain running can still
+		// be writing when the chain's file is closed
+		h.chain.lk.Lock()
+	}
+	begun := atomic.CompareAndSwapInt32(&h.shuttingDown, 0, 1)
+	if h.chain != nil {
+		if begun {
+			h.chain.refuse = ErrShuttingDown
+		}
+		h.chain.lk.Unlock()
+	}
+	if !begun {
+		return
+	}
+	if h.stop != nil {
+		close(h.stop)
+	}
+	if h.dht != nil {
+		// hand off first, so peers can fetch the entries from this node before it closes
+		if h.node != nil {
+			if n, e := h.dht.HandOff(); e != nil {
+				h.dht.dlog.Logf("shutdown: handing off entries: %v", e)
+			} else if n > 0 {
+				h.dht.dlog.Logf("shutdown: handed off %d entries", n)
+			}
+		}
+		deadline := time.Now().Add(timeout)
+		for !h.dht.drained() {
+			if time.Now().After(deadline) {
+				h.dht.dlog.Logf("shutdown: %d puts still queued after %v", len(h.dht.puts), timeout)
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if h.node != nil {
+			// anything not acknowledged stays in the outbox for the next run
+			if _, pending, e := h.dht.Republish(); e != nil {
+				h.dht.dlog.Logf("shutdown: publishing outbox: %v", e)
+			} else if pending > 0 {
+				h.dht.dlog.Logf("shutdown: %d publications left in the outbox", pending)
+			}
+		}
+	}
+	if h.chain != nil {
+		err = h.chain.Close()
+	}
+	if h.node != nil {
+		if e := h.node.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
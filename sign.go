@@ -0,0 +1,79 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// sign implements signing arbitrary payloads with the agent's key and checking those
+// signatures, so that apps can build challenges, logins and authenticated messages on the
+// identities of their chains' agents.  Payloads are prefixed before they're signed, so that
+// nothing an app has signed can be passed off as a header, invite, countersignature or any
+// other structure the agent's key signs.  Only zome code and the agent's own command line can
+// sign: signing isn't a function of the system zome, which any client of the chain's web
+// server may be able to call.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"strconv"
+)
+
+// SignedMessagePrefix is prepended to payloads before they're signed
+const SignedMessagePrefix = "holochain signed message:\n"
+
+var ErrSignInValidation error = errors.New("payloads can't be signed during validation")
+var ErrBadMessageSignature error = errors.New("signature doesn't verify")
+
+// MessageSignature is an agent's signature of a payload
+type MessageSignature struct {
+	Signer    string // node id of the agent that signed the payload
+	PubKey    string // b58 encoded marshaled public key of the agent
+	Signature string // b58 encoded signature of the prefixed payload
+}
+
+func signedMessage(data []byte) []byte {
+	return append([]byte(SignedMessagePrefix), data...)
+}
+
+// Sign signs a payload with the agent's key
+func (h *Holochain) Sign(data []byte) (s MessageSignature, err error) {
+	var pk []byte
+	if pk, err = ic.MarshalPublicKey(h.agent.PubKey()); err != nil {
+		return
+	}
+	var sig []byte
+	if sig, err = h.agent.PrivKey().Sign(signedMessage(data)); err != nil {
+		return
+	}
+	s = MessageSignature{Signer: peer.IDB58Encode(h.id), PubKey: b58.Encode(pk), Signature: b58.Encode(sig)}
+	return
+}
+
+// Verify checks that the payload was signed by the signature's signer
+func (s *MessageSignature) Verify(data []byte) (err error) {
+	if err = verifySigned(s.Signer, s.PubKey, signedMessage(data), s.Signature); err == ErrBadRelaySignature {
+		err = ErrBadMessageSignature
+	}
+	return
+}
+
+// ParseMessageSignature parses a signature from its json
+func ParseMessageSignature(j string) (s MessageSignature, err error) {
+	err = json.Unmarshal([]byte(j), &s)
+	return
+}
+
+func sysVerify(h *Holochain, arg string) (s string, err error) {
+	var req struct {
+		Data string
+		MessageSignature
+	}
+	if err = json.Unmarshal([]byte(arg), &req); err != nil {
+		return
+	}
+	s = strconv.FormatBool(req.MessageSignature.Verify([]byte(req.Data)) == nil)
+	return
+}
@@ -0,0 +1,46 @@
+package holochain
+
+import (
+	b58 "github.com/jbenet/go-base58"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("payloads should be signed by the agent", t, func() {
+		s, err := h.Sign([]byte("challenge 42"))
+		So(err, ShouldBeNil)
+		So(s.Signer, ShouldEqual, peer.IDB58Encode(h.id))
+		So(s.Verify([]byte("challenge 42")), ShouldBeNil)
+		So(s.Verify([]byte("challenge 43")), ShouldEqual, ErrBadMessageSignature)
+
+		s.Signer = "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"
+		So(s.Verify([]byte("challenge 42")), ShouldNotBeNil)
+	})
+
+	Convey("signatures should only be of prefixed payloads", t, func() {
+		s, _ := h.Sign([]byte("data"))
+		sig, err := h.agent.PrivKey().Sign([]byte("data"))
+		So(err, ShouldBeNil)
+		raw := MessageSignature{Signer: s.Signer, PubKey: s.PubKey, Signature: b58.Encode(sig)}
+		So(raw.Verify([]byte("data")), ShouldEqual, ErrBadMessageSignature)
+	})
+
+	Convey("the system zome should verify but not sign", t, func() {
+		_, err := h.Call(SystemZomeName, "sign", "hello")
+		So(err, ShouldNotBeNil)
+
+		s, err := h.Sign([]byte("hello"))
+		So(err, ShouldBeNil)
+		r, err := h.Call(SystemZomeName, "verify", map[string]string{"Data": "hello", "Signer": s.Signer, "PubKey": s.PubKey, "Signature": s.Signature})
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, "true")
+		r, err = h.Call(SystemZomeName, "verify", map[string]string{"Data": "goodbye", "Signer": s.Signer, "PubKey": s.PubKey, "Signature": s.Signature})
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, "false")
+	})
+}
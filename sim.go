@@ -0,0 +1,396 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// sim implements running network simulations for protocol work.  A scenario describes a
+// number of nodes running in-process, the fabric connecting them, and a schedule of commits,
+// partitions and churn.  Once the schedule has run, the simulation measures how long the
+// nodes take to converge on holding every entry committed, and checks the scenario's
+// expectations of that.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSimGossipInterval = 100 * time.Millisecond
+	DefaultSimTimeout        = 30 * time.Second
+
+	// SimBasePort is the port of the first node's address, which only identifies it as
+	// nothing listens on it
+	SimBasePort = 40000
+)
+
+// SimScenario describes a network simulation
+type SimScenario struct {
+	Nodes          int
+	App            string // directory of the app the nodes run, the sample app if not set
+	Seed           int64  // seeds the fabric's randomness so runs can be repeated
+	GossipInterval string
+	Latency        LatencySpec
+	Loss           float64 // the probability of a message being lost
+	Events         []SimEvent
+	Expect         SimExpectations
+}
+
+// SimEvent is something that happens at a time in a simulation.  Each event does one thing.
+type SimEvent struct {
+	At        string     // a duration since the simulation started
+	Node      int        // the node committing
+	Commit    *SimCommit `json:",omitempty"`
+	Partition [][]int    `json:",omitempty"` // splits the network into groups of nodes
+	Heal      bool       `json:",omitempty"` // ends any partition
+	Down      []int      `json:",omitempty"` // takes nodes off the network
+	Up        []int      `json:",omitempty"` // brings nodes back onto the network
+}
+
+// SimCommit commits entries on a node and puts them to the DHT
+type SimCommit struct {
+	Type    string // the entry type, myData if not set
+	Content string // the content, a new even number for each entry if not set
+	Count   int    // how many entries to commit, 1 if not set
+}
+
+// SimExpectations are what must happen for a simulation to pass
+type SimExpectations struct {
+	ConvergeWithin string // the longest the nodes that are up may take, after the last event, to hold every entry
+}
+
+// SimReport describes what happened in a simulation
+type SimReport struct {
+	Nodes          int
+	Entries        int
+	Converged      bool
+	ConvergedAfter time.Duration // how long after the last event the nodes converged
+	Sent           int64         // the number of messages sent across the fabric
+	Lost           int64
+	Blocked        int64 // messages that couldn't be sent because of partitions or downed nodes
+	Failures       []string
+}
+
+func (r *SimReport) String() string {
+	s := fmt.Sprintf("nodes: %d\nentries: %d\n", r.Nodes, r.Entries)
+	if r.Converged {
+		s += fmt.Sprintf("converged after: %v\n", r.ConvergedAfter)
+	} else {
+		s += "converged: no\n"
+	}
+	s += fmt.Sprintf("messages: %d sent, %d lost, %d blocked\n", r.Sent, r.Lost, r.Blocked)
+	for _, f := range r.Failures {
+		s += "FAILED: " + f + "\n"
+	}
+	return s
+}
+
+// LoadSimScenario reads a scenario from a file in any of the codec formats, chosen by its
+// extension
+func LoadSimScenario(path string) (sc *SimScenario, err error) {
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	var s SimScenario
+	if err = Decode(f, format, &s); err != nil {
+		err = fmt.Errorf("bad scenario %s: %v", path, err)
+		return
+	}
+	sc = &s
+	return
+}
+
+// simEvent is a parsed SimEvent
+type simEvent struct {
+	at time.Duration
+	SimEvent
+}
+
+type byAt []simEvent
+
+func (p byAt) Len() int           { return len(p) }
+func (p byAt) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byAt) Less(i, j int) bool { return p[i].at < p[j].at }
+
+// events checks the scenario's events and returns them in the order they happen
+func (sc *SimScenario) events() (events []simEvent, err error) {
+	node := func(i int) error {
+		if i < 0 || i >= sc.Nodes {
+			return fmt.Errorf("no node %d, nodes are numbered from 0 to %d", i, sc.Nodes-1)
+		}
+		return nil
+	}
+	for i, e := range sc.Events {
+		ev := simEvent{SimEvent: e}
+		if e.At != "" {
+			if ev.at, err = time.ParseDuration(e.At); err != nil {
+				err = fmt.Errorf("event %d: %v", i, err)
+				return
+			}
+		}
+		var nodes []int
+		nodes = append(nodes, e.Node)
+		nodes = append(nodes, e.Down...)
+		nodes = append(nodes, e.Up...)
+		for _, g := range e.Partition {
+			nodes = append(nodes, g...)
+		}
+		for _, n := range nodes {
+			if err = node(n); err != nil {
+				err = fmt.Errorf("event %d: %v", i, err)
+				return
+			}
+		}
+		events = append(events, ev)
+	}
+	sort.Stable(byAt(events))
+	return
+}
+
+// simNode is a node in a simulation
+type simNode struct {
+	h    *Holochain
+	down bool
+}
+
+// simulation is a running simulation
+type simulation struct {
+	sc      *SimScenario
+	fabric  *SimFabric
+	nodes   []*simNode
+	entries []Hash
+	next    int // the next number to commit as generated content
+}
+
+// RunSimulation runs a scenario, returning a report of what happened.  An error is only
+// returned if the simulation couldn't be run; failed expectations are in the report.
+func RunSimulation(sc *SimScenario) (r *SimReport, err error) {
+	if sc.Nodes < 1 {
+		err = errors.New("a simulation needs at least one node")
+		return
+	}
+	var events []simEvent
+	if events, err = sc.events(); err != nil {
+		return
+	}
+	var l latency
+	if l, err = sc.Latency.parse(); err != nil {
+		return
+	}
+	if sc.Loss < 0 || sc.Loss >= 1 {
+		err = errors.New("loss must be at least 0 and less than 1")
+		return
+	}
+	interval := DefaultSimGossipInterval
+	if sc.GossipInterval != "" {
+		if interval, err = time.ParseDuration(sc.GossipInterval); err != nil {
+			return
+		}
+	}
+	timeout := DefaultSimTimeout
+	if sc.Expect.ConvergeWithin != "" {
+		if timeout, err = time.ParseDuration(sc.Expect.ConvergeWithin); err != nil {
+			return
+		}
+	}
+
+	sim := simulation{sc: sc}
+	if sim.fabric, err = newSimFabric(sc.Seed, l, sc.Loss); err != nil {
+		return
+	}
+	defer sim.fabric.stop()
+	var root string
+	if root, err = ioutil.TempDir("", "hc-sim"); err != nil {
+		return
+	}
+	defer os.RemoveAll(root)
+	defer sim.stop()
+	if err = sim.start(root, interval); err != nil {
+		return
+	}
+
+	start := time.Now()
+	for _, e := range events {
+		time.Sleep(e.at - time.Since(start))
+		if err = sim.apply(e); err != nil {
+			return
+		}
+	}
+
+	r = &SimReport{Nodes: sc.Nodes, Entries: len(sim.entries)}
+	last := time.Now()
+	for {
+		if sim.converged() {
+			r.Converged = true
+			r.ConvergedAfter = time.Since(last)
+			break
+		}
+		if time.Since(last) > timeout {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !r.Converged {
+		r.Failures = append(r.Failures, fmt.Sprintf("nodes didn't converge within %v", timeout))
+	}
+	r.Sent, r.Lost, r.Blocked = sim.fabric.Counts()
+	return
+}
+
+// start sets up the nodes with the same DNA, connects each to the others, and starts them
+// gossiping
+func (sim *simulation) start(root string, interval time.Duration) (err error) {
+	var src string
+	for i := 0; i < sim.sc.Nodes; i++ {
+		var s *Service
+		name := fmt.Sprintf("node%d", i)
+		if s, err = Init(filepath.Join(root, name), AgentName(fmt.Sprintf("sim %s <%s@sim>", name, name))); err != nil {
+			return
+		}
+		path := filepath.Join(s.Path, "sim")
+		var h *Holochain
+		switch {
+		case i > 0:
+			h, err = s.Clone(src, path, false)
+		case sim.sc.App != "":
+			h, err = s.Clone(sim.sc.App, path, true)
+		default:
+			h, err = s.GenDev(path, "toml")
+		}
+		if err != nil {
+			return
+		}
+		src = h.path
+		if _, err = h.GenChain(); err != nil {
+			return
+		}
+		h.config.Transports = []string{SimTransportName}
+		h.config.Port = SimBasePort + i
+		h.config.PeerModeDHTNode = true
+		h.config.PeerModeAuthor = true
+		h.config.BootstrapServer = ""
+		h.config.GossipInterval = ""
+		h.config.Loggers.TestInfo.Enabled = false
+		if err = h.Activate(); err != nil {
+			return
+		}
+		sim.nodes = append(sim.nodes, &simNode{h: h})
+	}
+
+	// the nodes know each other from the start, as if from a bootstrap server
+	for _, n := range sim.nodes {
+		for _, other := range sim.nodes {
+			if n == other {
+				continue
+			}
+			if err = n.h.dht.AddPeerAddr(other.h.id, other.h.node.NetAddr); err != nil {
+				return
+			}
+			if err = n.h.dht.UpdateGossiper(other.h.id, 0); err != nil {
+				return
+			}
+		}
+	}
+	for _, n := range sim.nodes {
+		go n.h.dht.HandlePutReqs()
+		go n.h.dht.Gossip(interval)
+	}
+	return
+}
+
+// stop stops the nodes gossiping and takes them off the fabric
+func (sim *simulation) stop() {
+	for _, n := range sim.nodes {
+		n.h.dht.gossiping = false
+		n.h.node.Close()
+	}
+}
+
+// apply makes an event happen
+func (sim *simulation) apply(e simEvent) (err error) {
+	ids := func(nodes []int) (ids []peer.ID) {
+		for _, i := range nodes {
+			ids = append(ids, sim.nodes[i].h.id)
+		}
+		return
+	}
+	switch {
+	case e.Commit != nil:
+		err = sim.commit(sim.nodes[e.Node].h, e.Commit)
+	case e.Partition != nil:
+		var groups [][]peer.ID
+		for _, g := range e.Partition {
+			groups = append(groups, ids(g))
+		}
+		sim.fabric.Partition(groups)
+	case e.Heal:
+		sim.fabric.Heal()
+	case e.Down != nil:
+		for _, i := range e.Down {
+			sim.nodes[i].down = true
+			sim.fabric.SetDown(sim.nodes[i].h.id, true)
+		}
+	case e.Up != nil:
+		for _, i := range e.Up {
+			sim.nodes[i].down = false
+			sim.fabric.SetDown(sim.nodes[i].h.id, false)
+		}
+	}
+	return
+}
+
+// commit commits entries on a node and puts them to the DHT
+func (sim *simulation) commit(h *Holochain, c *SimCommit) (err error) {
+	entryType := c.Type
+	if entryType == "" {
+		entryType = "myData"
+	}
+	count := c.Count
+	if count == 0 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		content := c.Content
+		if content == "" {
+			sim.next += 2
+			content = fmt.Sprintf("%d", sim.next)
+		}
+		var hash Hash
+		if hash, err = h.Commit(entryType, content); err != nil {
+			return
+		}
+		if err = h.dht.SendPut(hash); err != nil {
+			return
+		}
+		sim.entries = append(sim.entries, hash)
+	}
+	return
+}
+
+// converged returns true if every node that is up holds every entry committed
+func (sim *simulation) converged() bool {
+	for _, n := range sim.nodes {
+		if n.down {
+			continue
+		}
+		for _, hash := range sim.entries {
+			if n.h.dht.exists(hash) != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
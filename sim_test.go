@@ -0,0 +1,211 @@
+package holochain
+
+import (
+	"context"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLatencySpec(t *testing.T) {
+	Convey("it should parse latency specs", t, func() {
+		l, err := (&LatencySpec{}).parse()
+		So(err, ShouldBeNil)
+		So(l.distribution, ShouldEqual, LatencyConstant)
+		So(l.sample(rand.New(rand.NewSource(1))), ShouldEqual, 0)
+
+		l, err = (&LatencySpec{Distribution: LatencyUniform, Mean: "50ms", Jitter: "10ms"}).parse()
+		So(err, ShouldBeNil)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 100; i++ {
+			d := l.sample(r)
+			So(d, ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+			So(d, ShouldBeLessThanOrEqualTo, 60*time.Millisecond)
+		}
+
+		_, err = (&LatencySpec{Distribution: "pareto"}).parse()
+		So(err.Error(), ShouldEqual, "unknown latency distribution: pareto")
+		_, err = (&LatencySpec{Mean: "fast"}).parse()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSimFabric(t *testing.T) {
+	f, err := newSimFabric(1, latency{distribution: LatencyConstant}, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer f.stop()
+	ids := []peer.ID{"node0", "node1", "node2"}
+	var transports []Transport
+	for _, id := range ids {
+		tr, err := NewSimTransport(nil, id, nil)
+		if err != nil {
+			panic(err)
+		}
+		tr.SetStreamHandler("/test", func(s Stream) {
+			buf := make([]byte, 4)
+			n, _ := s.Read(buf)
+			s.Write(buf[:n])
+		})
+		transports = append(transports, tr)
+	}
+	send := func(from int, to int) error {
+		s, err := transports[from].NewStream(context.Background(), ids[to], "/test")
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		s.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		_, err = s.Read(buf)
+		return err
+	}
+
+	Convey("only one fabric should run at a time", t, func() {
+		_, err := newSimFabric(1, latency{}, 0)
+		So(err, ShouldEqual, ErrSimRunning)
+	})
+
+	Convey("it should carry streams between nodes", t, func() {
+		So(send(0, 1), ShouldBeNil)
+		So(send(2, 0), ShouldBeNil)
+	})
+
+	Convey("it should block streams across partitions", t, func() {
+		f.Partition([][]peer.ID{{ids[0], ids[1]}})
+		So(send(0, 1), ShouldBeNil)
+		So(send(0, 2), ShouldEqual, ErrSimUnreachable)
+		So(send(2, 1), ShouldEqual, ErrSimUnreachable)
+		f.Heal()
+		So(send(0, 2), ShouldBeNil)
+	})
+
+	Convey("it should block streams to and from downed nodes", t, func() {
+		f.SetDown(ids[1], true)
+		So(send(0, 1), ShouldEqual, ErrSimUnreachable)
+		So(send(1, 2), ShouldEqual, ErrSimUnreachable)
+		f.SetDown(ids[1], false)
+		So(send(0, 1), ShouldBeNil)
+	})
+
+	Convey("it should lose streams", t, func() {
+		f.loss = 0.999999
+		So(send(0, 1), ShouldEqual, ErrSimLost)
+		f.loss = 0
+	})
+
+	Convey("it should count streams", t, func() {
+		sent, lost, blocked := f.Counts()
+		So(sent, ShouldEqual, 10)
+		So(lost, ShouldEqual, 1)
+		So(blocked, ShouldEqual, 4)
+	})
+
+	Convey("closed transports should be unreachable", t, func() {
+		transports[2].Close()
+		So(send(0, 2), ShouldEqual, ErrSimUnreachable)
+	})
+}
+
+func TestNewSimTransport(t *testing.T) {
+	Convey("it should fail without a simulation running", t, func() {
+		_, err := NewSimTransport(nil, "node0", nil)
+		So(err, ShouldEqual, ErrNoSimulation)
+	})
+}
+
+func TestLoadSimScenario(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+
+	Convey("it should load yaml scenarios", t, func() {
+		writeFile(d, "scenario.yaml", []byte(`nodes: 3
+seed: 7
+latency:
+  distribution: normal
+  mean: 5ms
+  jitter: 2ms
+events:
+  - at: 10ms
+    node: 1
+    commit:
+      count: 2
+  - at: 0s
+    partition: [[0], [1, 2]]
+expect:
+  convergeWithin: 5s
+`))
+		sc, err := LoadSimScenario(filepath.Join(d, "scenario.yaml"))
+		So(err, ShouldBeNil)
+		So(sc.Nodes, ShouldEqual, 3)
+		So(sc.Latency.Mean, ShouldEqual, "5ms")
+		So(sc.Events[0].Commit.Count, ShouldEqual, 2)
+		So(sc.Expect.ConvergeWithin, ShouldEqual, "5s")
+
+		events, err := sc.events()
+		So(err, ShouldBeNil)
+		So(events[0].Partition, ShouldResemble, [][]int{{0}, {1, 2}})
+		So(events[1].at, ShouldEqual, 10*time.Millisecond)
+	})
+
+	Convey("it should reject events on nodes that don't exist", t, func() {
+		sc := SimScenario{Nodes: 2, Events: []SimEvent{{Down: []int{2}}}}
+		_, err := sc.events()
+		So(err.Error(), ShouldEqual, "event 0: no node 2, nodes are numbered from 0 to 1")
+	})
+}
+
+func TestRunSimulation(t *testing.T) {
+	Convey("nodes should converge on the entries committed", t, func() {
+		sc := SimScenario{
+			Nodes:          3,
+			GossipInterval: "20ms",
+			Latency:        LatencySpec{Mean: "1ms"},
+			Events: []SimEvent{
+				{Node: 0, Commit: &SimCommit{Count: 2}},
+				{At: "50ms", Node: 2, Commit: &SimCommit{}},
+			},
+			Expect: SimExpectations{ConvergeWithin: "10s"},
+		}
+		r, err := RunSimulation(&sc)
+		So(err, ShouldBeNil)
+		So(r.Entries, ShouldEqual, 3)
+		So(r.Failures, ShouldBeNil)
+		So(r.Converged, ShouldBeTrue)
+		So(r.Sent, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("nodes partitioned off shouldn't converge until healed", t, func() {
+		sc := SimScenario{
+			Nodes:          2,
+			GossipInterval: "20ms",
+			Events: []SimEvent{
+				{Partition: [][]int{{0}, {1}}},
+				{Node: 0, Commit: &SimCommit{}},
+			},
+			Expect: SimExpectations{ConvergeWithin: "300ms"},
+		}
+		r, err := RunSimulation(&sc)
+		So(err, ShouldBeNil)
+		So(r.Converged, ShouldBeFalse)
+		So(r.Failures, ShouldResemble, []string{"nodes didn't converge within 300ms"})
+		So(r.Blocked, ShouldBeGreaterThan, 0)
+
+		sc.Events = append(sc.Events, SimEvent{At: "100ms", Heal: true})
+		sc.Expect.ConvergeWithin = "10s"
+		r, err = RunSimulation(&sc)
+		So(err, ShouldBeNil)
+		So(r.Converged, ShouldBeTrue)
+	})
+
+	Convey("it should reject bad scenarios", t, func() {
+		_, err := RunSimulation(&SimScenario{})
+		So(err.Error(), ShouldEqual, "a simulation needs at least one node")
+		_, err = RunSimulation(&SimScenario{Nodes: 1, Loss: 1})
+		So(err.Error(), ShouldEqual, "loss must be at least 0 and less than 1")
+	})
+}
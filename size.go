@@ -0,0 +1,144 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// size implements caps on how large entries and the arguments of zome calls may be.  Entry
+// types can declare a MaxSize and functions a MaxArgSize in the DNA, and otherwise get the
+// defaults here, so that a single client can't commit a huge entry and have every node that
+// gossips with it fetch and hold it.  Arguments are checked before any zome code runs, and
+// entries before their validation rules run, both when committed and when received by the
+// DHT store.  Messages read from other nodes are capped to the largest entry the app allows,
+// plus room for the rest of the message, so that a peer can't send an unbounded one.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultMaxEntrySize = 16 * 1024 * 1024 // largest entry, in bytes, of a type that doesn't declare a MaxSize
+	DefaultMaxArgSize   = 16 * 1024 * 1024 // largest argument, in bytes, to a function that doesn't declare a MaxArgSize
+
+	messageOverhead       = 1024 * 1024                           // room in a message for what it carries besides an entry
+	DefaultMaxMessageSize = DefaultMaxEntrySize + messageOverhead // largest message, in bytes, read from another node
+)
+
+var ErrEntryTooLarge error = errors.New("entry too large")
+var ErrArgTooLarge error = errors.New("argument too large")
+var ErrMessageTooLarge error = errors.New("message too large")
+
+// MaxEntrySize returns the size in bytes of the largest entry of the type allowed
+func (d *EntryDef) MaxEntrySize() int {
+	if d.MaxSize > 0 {
+		return d.MaxSize
+	}
+	return DefaultMaxEntrySize
+}
+
+// checkMaxSize checks an entry type's declared size cap
+func (d *EntryDef) checkMaxSize() (err error) {
+	if d.MaxSize < 0 {
+		err = fmt.Errorf("entry type %s: max size must not be negative", d.Name)
+	}
+	return
+}
+
+// entrySize returns the size in bytes of an entry's content
+func entrySize(entry Entry) (size int, err error) {
+	if s, ok := entry.Content().(string); ok {
+		size = len(s)
+		return
+	}
+	var b []byte
+	if b, err = entry.Marshal(); err != nil {
+		return
+	}
+	size = len(b)
+	return
+}
+
+// checkSize checks that an entry isn't larger than its type allows
+func (d *EntryDef) checkSize(entry Entry) (err error) {
+	var size int
+	if size, err = entrySize(entry); err != nil {
+		return
+	}
+	if max := d.MaxEntrySize(); size > max {
+		err = fmt.Errorf("%v: %s entry of %d bytes is over the limit of %d", ErrEntryTooLarge, d.Name, size, max)
+	}
+	return
+}
+
+// maxMessageSize returns the size in bytes of the largest message the node reads from another,
+// enough to carry the largest entry of any of the app's types
+func (h *Holochain) maxMessageSize() int64 {
+	max := DefaultMaxEntrySize
+	for _, z := range h.Zomes {
+		for _, d := range z.Entries {
+			if m := d.MaxEntrySize(); m > max {
+				max = m
+			}
+		}
+	}
+	return int64(max) + messageOverhead
+}
+
+// limitedReader reads from a stream until a limit, after which reads fail with
+// ErrMessageTooLarge
+type limitedReader struct {
+	r io.Reader
+	n int64 // bytes left to read
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	if l.n <= 0 {
+		err = ErrMessageTooLarge
+		return
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err = l.r.Read(p)
+	l.n -= int64(n)
+	return
+}
+
+// MaxArgSize returns the size in bytes of the largest argument a zome function may be called with
+func (h *Holochain) MaxArgSize(zome string, function string) int {
+	if z, ok := h.Zomes[zome]; ok {
+		if f := z.GetFunctionDef(function); f != nil && f.MaxArgSize > 0 {
+			return f.MaxArgSize
+		}
+	}
+	return DefaultMaxArgSize
+}
+
+// argSize returns the size in bytes of an argument to a zome function
+func argSize(arg interface{}) int {
+	switch v := arg.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return len(fmt.Sprintf("%v", arg))
+	}
+	return len(b)
+}
+
+// checkArgSize checks that the argument to a zome function isn't larger than it allows
+func (h *Holochain) checkArgSize(zome string, function string, arg interface{}) (err error) {
+	size := argSize(arg)
+	if max := h.MaxArgSize(zome, function); size > max {
+		err = fmt.Errorf("%v: argument to %s of %d bytes is over the limit of %d", ErrArgTooLarge, function, size, max)
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package holochain
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestSizeCaps(t *testing.T) {
+	Convey("declared caps should not be negative", t, func() {
+		So((&EntryDef{Name: "myData", MaxSize: 10}).checkMaxSize(), ShouldBeNil)
+		So((&EntryDef{Name: "myData", MaxSize: -1}).checkMaxSize(), ShouldNotBeNil)
+		So((&FunctionDef{Name: "fn", MaxArgSize: -1}).Check(), ShouldNotBeNil)
+	})
+
+	Convey("types and functions without caps should get the defaults", t, func() {
+		So((&EntryDef{Name: "myData"}).MaxEntrySize(), ShouldEqual, DefaultMaxEntrySize)
+		So((&EntryDef{Name: "myData", MaxSize: 10}).MaxEntrySize(), ShouldEqual, 10)
+		So(argSize("fish"), ShouldEqual, 4)
+		So(argSize([]byte("fish")), ShouldEqual, 4)
+		So(argSize(map[string]int{"prime": 7}), ShouldEqual, len(`{"prime":7}`))
+		So(argSize(nil), ShouldEqual, 0)
+	})
+
+	Convey("oversized errors should be reported as too large", t, func() {
+		So(StatusCode(ErrEntryTooLarge), ShouldEqual, 413)
+		So(StatusCode(ErrArgTooLarge), ShouldEqual, 413)
+		So(isError((&EntryDef{Name: "myData", MaxSize: 1}).checkSize(&GobEntry{C: "fish"}), ErrEntryTooLarge), ShouldBeTrue)
+	})
+
+	Convey("messages over the node's limit should not be read", t, func() {
+		node := &Node{HashAddr: "node", maxMessage: 1000}
+		data, err := node.NewMessage(PUT_REQUEST, "fish").Encode()
+		So(err, ShouldBeNil)
+		var m Message
+		So(node.decode(&m, bytes.NewReader(data)), ShouldBeNil)
+		So(m.Body, ShouldEqual, "fish")
+
+		data, err = node.NewMessage(PUT_REQUEST, strings.Repeat("fish", 1000)).Encode()
+		So(err, ShouldBeNil)
+		So(node.decode(&m, bytes.NewReader(data)), ShouldEqual, ErrMessageTooLarge)
+		node.maxMessage = 0
+		So(node.decode(&m, bytes.NewReader(data)), ShouldBeNil)
+	})
+}
+
+func TestSizeCapsEnforced(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	def := h.Zomes["myZome"].Entries["myData"]
+	def.MaxSize = 4
+	h.Zomes["myZome"].Entries["myData"] = def
+	for i := range h.Zomes["myZome"].Functions {
+		if h.Zomes["myZome"].Functions[i].Name == "addData" {
+			h.Zomes["myZome"].Functions[i].MaxArgSize = 4
+		}
+	}
+
+	Convey("oversized entries should not be committed", t, func() {
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		_, err = h.Commit("myData", "200000")
+		So(isError(err, ErrEntryTooLarge), ShouldBeTrue)
+	})
+
+	Convey("oversized entries should be rejected before validation when received", t, func() {
+		p := ValidationProps{Sources: []string{"someone"}}
+		err := h.ValidateEntry("myData", &GobEntry{C: "200000"}, &p)
+		So(isError(err, ErrEntryTooLarge), ShouldBeTrue)
+		So(h.ValidateEntry("myData", &GobEntry{C: "2000"}, &p), ShouldBeNil)
+	})
+
+	Convey("oversized entries of system types should be rejected", t, func() {
+		p := ValidationProps{Sources: []string{"someone"}}
+		err := h.ValidateEntry(PreferencesEntryType, &GobEntry{C: strings.Repeat("x", DefaultMaxEntrySize+1)}, &p)
+		So(isError(err, ErrEntryTooLarge), ShouldBeTrue)
+	})
+
+	Convey("the node should read messages big enough for the largest entry", t, func() {
+		So(h.maxMessageSize(), ShouldEqual, DefaultMaxMessageSize)
+		def := h.Zomes["myZome"].Entries["primes"]
+		def.MaxSize = 2 * DefaultMaxEntrySize
+		h.Zomes["myZome"].Entries["primes"] = def
+		defer func() {
+			def.MaxSize = 0
+			h.Zomes["myZome"].Entries["primes"] = def
+		}()
+		So(h.maxMessageSize(), ShouldEqual, 2*DefaultMaxEntrySize+messageOverhead)
+	})
+
+	Convey("oversized arguments should be rejected before calling the zome", t, func() {
+		_, err := h.Call("myZome", "addData", "200000")
+		So(isError(err, ErrArgTooLarge), ShouldBeTrue)
+		_, err = h.Call("myZome", "addData", "20")
+		So(err, ShouldBeNil)
+		_, err = h.Call("myZome", "getDNA", strings.Repeat("x", DefaultMaxArgSize+1))
+		So(isError(err, ErrArgTooLarge), ShouldBeTrue)
+	})
+
+	Convey("the caps should be described with the functions", t, func() {
+		schemas, err := h.FunctionSchemas()
+		So(err, ShouldBeNil)
+		for _, s := range schemas["myZome"] {
+			if s.Name == "addData" {
+				So(s.MaxArgSize, ShouldEqual, 4)
+			} else {
+				So(s.MaxArgSize, ShouldEqual, DefaultMaxArgSize)
+			}
+		}
+	})
+}
@@ -0,0 +1,412 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// snapshot implements fast sync, where a new node loads signed snapshots of the DHT store
+// from several peers instead of replaying their whole gossip backlog, fully verifying a random
+// sample of the entries and links before switching over to normal gossip.  The status a peer
+// gives an entry can't be checked with its source, so peers holding the same entry must agree
+// on it.
+
+package holochain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSnapshotSample is the number of entries fully validated with their sources during a
+// fast sync
+const DefaultSnapshotSample = 20
+
+var ErrNoSnapshots error = errors.New("no valid snapshots received")
+var ErrSnapshotsDisagree error = errors.New("snapshots disagree")
+
+// SnapshotEntry holds an entry of the DHT store along with its status
+type SnapshotEntry struct {
+	Hash    string
+	Type    string
+	Source  string
+	Status  int
+	Data    []byte
+	Expires int64 // unix nanoseconds, or 0 if the entry doesn't expire
+}
+
+// SnapshotMeta holds a meta entry of the DHT store
+type SnapshotMeta struct {
+	Hash     string
+	MetaHash string
+	Tag      string
+	Data     []byte
+}
+
+// DHTSnapshot holds the contents of a node's DHT store, signed by the node
+type DHTSnapshot struct {
+	Idx     int // the node's gossip index at the time of the snapshot
+	Entries []SnapshotEntry
+	Metas   []SnapshotMeta
+	PubKey  []byte
+	Sig     []byte
+}
+
+// signedBytes returns the bytes of the snapshot that are signed
+func (s *DHTSnapshot) signedBytes() ([]byte, error) {
+	return ByteEncoder(DHTSnapshot{Idx: s.Idx, Entries: s.Entries, Metas: s.Metas})
+}
+
+// Snapshot returns a signed snapshot of the DHT store, leaving out expired and quarantined entries
+func (dht *DHT) Snapshot() (s *DHTSnapshot, err error) {
+	var snap DHTSnapshot
+	now := time.Now()
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		snap.Idx, e = getIntVal("_idx", tx)
+		if e != nil {
+			return e
+		}
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			if isExpired(tx, k, now) {
+				return true
+			}
+			se := SnapshotEntry{Hash: k, Data: []byte(value)}
+			se.Type, _ = tx.Get("type:" + k)
+			se.Source, _ = tx.Get("src:" + k)
+			var status string
+			status, e = tx.Get("status:" + k)
+			if e == nil {
+				se.Status, e = strconv.Atoi(status)
+			}
+			if e != nil {
+				return false
+			}
+			if se.Status == QUARANTINED {
+				// peers syncing from the snapshot mustn't pick up what this node won't serve
+				return true
+			}
+			if exp, err := tx.Get("expires:" + k); err == nil {
+				se.Expires, _ = strconv.ParseInt(exp, 10, 64)
+			}
+			snap.Entries = append(snap.Entries, se)
+			return true
+		})
+		if e != nil {
+			return e
+		}
+		tx.AscendKeys("meta:*", func(key, value string) bool {
+			x := strings.SplitN(key, ":", 4)
+			if !isExpired(tx, x[1], now) {
+				snap.Metas = append(snap.Metas, SnapshotMeta{Hash: x[1], MetaHash: x[2], Tag: x[3], Data: []byte(value)})
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	priv := dht.h.Agent().PrivKey()
+	snap.PubKey, err = ic.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = snap.signedBytes()
+	if err != nil {
+		return
+	}
+	snap.Sig, err = priv.Sign(b)
+	if err != nil {
+		return
+	}
+	s = &snap
+	return
+}
+
+// checkHash confirms that data has the given hash, which for key entries is the node id
+// whose key the data is
+func (dht *DHT) checkHash(entryType string, hash string, data []byte) (err error) {
+	var h Hash
+	switch entryType {
+	case KeyEntryType:
+		h, err = NewHash(peer.IDB58Encode(peer.ID(data)))
+	case DNAEntryType:
+		h = dht.h.DNAHash()
+	default:
+		err = h.Sum(dht.h.hashSpec, data)
+	}
+	if err == nil && h.String() != hash {
+		err = fmt.Errorf("snapshot data doesn't match hash %s", hash)
+	}
+	return
+}
+
+// verifySnapshot checks that a snapshot was signed by the peer it came from and that the
+// data of each of its entries matches its hash
+func (dht *DHT) verifySnapshot(from peer.ID, s *DHTSnapshot) (err error) {
+	var pub ic.PubKey
+	pub, err = ic.UnmarshalPublicKey(s.PubKey)
+	if err != nil {
+		return
+	}
+	if !from.MatchesPublicKey(pub) {
+		err = fmt.Errorf("snapshot from %v signed with another key", from)
+		return
+	}
+	var b []byte
+	b, err = s.signedBytes()
+	if err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(b, s.Sig)
+	if err == nil && !ok {
+		err = fmt.Errorf("bad snapshot signature from %v", from)
+	}
+	if err != nil {
+		return
+	}
+	for _, e := range s.Entries {
+		if err = dht.checkHash(e.Type, e.Hash, e.Data); err != nil {
+			return
+		}
+	}
+	for _, m := range s.Metas {
+		if err = dht.checkHash("", m.MetaHash, m.Data); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// verifySnapshotEntry fully validates a snapshot entry by getting it from its source, as is
+// done when handling a put
+func (dht *DHT) verifySnapshotEntry(e *SnapshotEntry) (err error) {
+	err = dht.verifySnapshotSource(e, &ValidationProps{Sources: []string{e.Source}, Hash: e.Hash})
+	return
+}
+
+// verifySnapshotMeta fully validates a snapshot meta by getting the entry it links from the
+// entry's source, as is done when handling a putmeta
+func (dht *DHT) verifySnapshotMeta(m *SnapshotMeta, e *SnapshotEntry) (err error) {
+	p := ValidationProps{Sources: []string{e.Source}, MetaTag: m.Tag, MetaHash: m.MetaHash, MetaBase: m.Hash}
+	err = dht.verifySnapshotSource(e, &p)
+	return
+}
+
+// verifySnapshotSource gets a snapshot entry from its source, checks it matches and validates
+// it with the given props
+func (dht *DHT) verifySnapshotSource(e *SnapshotEntry, p *ValidationProps) (err error) {
+	var key Hash
+	key, err = NewHash(e.Hash)
+	if err != nil {
+		return
+	}
+	var source peer.ID
+	source, err = peer.IDB58Decode(e.Source)
+	if err != nil {
+		return
+	}
+	var r interface{}
+	r, err = dht.h.Send(SourceProtocol, source, SRC_VALIDATE, key, SrcReceiver)
+	if err != nil {
+		return
+	}
+	resp, ok := r.(*ValidateResponse)
+	if !ok {
+		err = fmt.Errorf("expected validate response from %v, got %T", source, r)
+		return
+	}
+	var b []byte
+	b, err = resp.Entry.Marshal()
+	if err != nil {
+		return
+	}
+	if resp.Type != e.Type || !bytes.Equal(b, e.Data) {
+		err = fmt.Errorf("snapshot entry %s doesn't match its source", e.Hash)
+		return
+	}
+	err = dht.h.ValidateEntry(resp.Type, resp.Entry, p)
+	return
+}
+
+// FastSync loads snapshots of the DHT store from peers, validating a random sample of sample
+// entries of every type, and of sample links, with their sources before storing any of them.
+// Afterwards gossip with those peers carries on from the point at which their snapshots were
+// taken.
+func (dht *DHT) FastSync(peers []peer.ID, sample int) (n int, err error) {
+	snapshots := make(map[peer.ID]*DHTSnapshot)
+	total := len(peers) + 2
+	for i, id := range peers {
+		dht.h.progress(ProgressFastSync, "requesting snapshots", i, total)
+		if dht.h.peerLacks(id, FeatureFastSync) {
+			dht.glog.Logf("skipping snapshot from %v which doesn't support fast sync", id)
+			continue
+		}
+		r, e := dht.send(id, SNAPSHOT_REQUEST, nil)
+		if e != nil {
+			dht.glog.Logf("snapshot request to %v failed: %v", id, e)
+			dht.recordPeerEvent(id, PeerTimeout)
+			continue
+		}
+		s, ok := r.(DHTSnapshot)
+		if !ok {
+			e = fmt.Errorf("expected snapshot, got %T", r)
+		} else {
+			e = dht.verifySnapshot(id, &s)
+		}
+		if e != nil {
+			dht.glog.Logf("rejecting snapshot from %v: %v", id, e)
+			dht.recordPeerEvent(id, PeerViolation)
+			continue
+		}
+		dht.recordPeerEvent(id, PeerSuccess)
+		snapshots[id] = &s
+	}
+	if len(snapshots) == 0 {
+		err = ErrNoSnapshots
+		return
+	}
+
+	// merge the snapshots, remembering which peers hold each entry
+	entries := make(map[string]*SnapshotEntry)
+	holders := make(map[string][]peer.ID)
+	metas := make(map[string]*SnapshotMeta)
+	var checkable, metaKeys []string
+	for id, s := range snapshots {
+		for i := range s.Entries {
+			e := &s.Entries[i]
+			if have, ok := entries[e.Hash]; !ok {
+				entries[e.Hash] = e
+				// the data of DNA and key entries is fully checked by its hash
+				if e.Type != DNAEntryType && e.Type != KeyEntryType {
+					checkable = append(checkable, e.Hash)
+				}
+			} else if have.Status != e.Status || have.Type != e.Type || have.Source != e.Source {
+				err = fmt.Errorf("%v: %v and %v on entry %s", ErrSnapshotsDisagree, holders[e.Hash][0], id, e.Hash)
+				return
+			}
+			holders[e.Hash] = append(holders[e.Hash], id)
+		}
+		for i := range s.Metas {
+			m := &s.Metas[i]
+			key := "meta:" + m.Hash + ":" + m.MetaHash + ":" + m.Tag
+			if _, ok := metas[key]; !ok {
+				metas[key] = m
+				metaKeys = append(metaKeys, key)
+			}
+		}
+	}
+
+	dht.h.progress(ProgressFastSync, "verifying sample", len(peers), total)
+	for j, i := range rand.Perm(len(checkable)) {
+		if j >= sample {
+			break
+		}
+		if err = dht.verifySnapshotEntry(entries[checkable[i]]); err != nil {
+			err = fmt.Errorf("fast sync sample failed verification: %v", err)
+			return
+		}
+	}
+	for j, i := range rand.Perm(len(metaKeys)) {
+		if j >= sample {
+			break
+		}
+		m := metas[metaKeys[i]]
+		e, ok := entries[m.MetaHash]
+		if !ok {
+			err = fmt.Errorf("fast sync sample failed verification: link to %s not in snapshots", m.MetaHash)
+			return
+		}
+		if err = dht.verifySnapshotMeta(m, e); err != nil {
+			err = fmt.Errorf("fast sync sample failed verification: %v", err)
+			return
+		}
+	}
+
+	dht.h.progress(ProgressFastSync, "storing entries", len(peers)+1, total)
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		for k, e := range entries {
+			if _, err := tx.Get("entry:" + k); err != buntdb.ErrNotFound {
+				continue
+			}
+			if err := dht.incSize(tx, len(e.Data)); err != nil {
+				return err
+			}
+			values := map[string]string{
+				"entry:" + k:  string(e.Data),
+				"type:" + k:   e.Type,
+				"src:" + k:    e.Source,
+				"status:" + k: fmt.Sprintf("%d", e.Status),
+			}
+			if e.Expires != 0 {
+				values["expires:"+k] = fmt.Sprintf("%d", e.Expires)
+			}
+			for key, val := range values {
+				if _, _, err := tx.Set(key, val, nil); err != nil {
+					return err
+				}
+			}
+			key, _ := NewHash(k)
+			for _, id := range holders[k] {
+				if err := setHolder(tx, key, id); err != nil {
+					return err
+				}
+			}
+			n++
+		}
+		for key, m := range metas {
+			if err := dht.resize(tx, key, len(m.Data)); err != nil {
+				return err
+			}
+			if _, _, err := tx.Set(key, string(m.Data), nil); err != nil {
+				return err
+			}
+		}
+		// pick up gossip from where each snapshot left off
+		for id, s := range snapshots {
+			key := "peer:" + peer.IDB58Encode(id)
+			idx, err := getIntVal(key, tx)
+			if err != nil {
+				return err
+			}
+			if s.Idx > idx {
+				if _, _, err = tx.Set(key, fmt.Sprintf("%d", s.Idx), nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		dht.h.progress(ProgressFastSync, "done", total, total)
+		dht.glog.Logf("fast sync loaded %d entries from %d peers", n, len(snapshots))
+		dht.h.metrics.Inc("dht.fastsync.entries", int64(n))
+	}
+	return
+}
+
+// FastSync adds the peers at the given addresses, of the form accepted by ParsePeerAddr, and
+// loads the DHT from their snapshots
+func (h *Holochain) FastSync(peerAddrs []string, sample int) (n int, err error) {
+	var peers []peer.ID
+	for _, a := range peerAddrs {
+		id, addr, e := ParsePeerAddr(a)
+		if e != nil {
+			err = e
+			return
+		}
+		h.node.Transport.AddPeerAddr(id, addr)
+		peers = append(peers, id)
+	}
+	n, err = h.dht.FastSync(peers, sample)
+	return
+}
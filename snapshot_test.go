@@ -0,0 +1,95 @@
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	e := GobEntry{C: "124"}
+	_, hd, _ := h.NewEntry(time.Now(), "myData", &e)
+	DHTReceiver(h, h.node.NewMessage(PUT_REQUEST, PutReq{H: hd.EntryLink}))
+	dht.simHandlePutReqs()
+
+	Convey("it should make a signed snapshot of the DHT store", t, func() {
+		s, err := dht.Snapshot()
+		So(err, ShouldBeNil)
+		So(len(s.Entries), ShouldEqual, 4)
+		idx, _ := dht.GetIdx()
+		So(s.Idx, ShouldEqual, idx)
+		So(dht.verifySnapshot(h.id, s), ShouldBeNil)
+	})
+
+	Convey("it should reject snapshots from the wrong peer or with tampered data", t, func() {
+		s, _ := dht.Snapshot()
+		p, _ := makePeer("peer")
+		So(dht.verifySnapshot(p, s).Error(), ShouldStartWith, "snapshot from")
+
+		s.Idx++
+		So(dht.verifySnapshot(h.id, s).Error(), ShouldStartWith, "bad snapshot signature")
+		s.Idx--
+
+		for i := range s.Entries {
+			if s.Entries[i].Hash == hd.EntryLink.String() {
+				s.Entries[i].Data = []byte("fish")
+			}
+		}
+		So(dht.checkHash("myData", hd.EntryLink.String(), []byte("fish")).Error(), ShouldEqual, "snapshot data doesn't match hash "+hd.EntryLink.String())
+		So(dht.verifySnapshot(h.id, s), ShouldNotBeNil)
+	})
+
+	Convey("it should fully verify sampled entries with their source", t, func() {
+		s, _ := dht.Snapshot()
+		for i := range s.Entries {
+			if s.Entries[i].Hash == hd.EntryLink.String() {
+				So(dht.verifySnapshotEntry(&s.Entries[i]), ShouldBeNil)
+				s.Entries[i].Type = "primes"
+				So(dht.verifySnapshotEntry(&s.Entries[i]).Error(), ShouldEqual, "snapshot entry "+hd.EntryLink.String()+" doesn't match its source")
+			}
+		}
+	})
+
+	Convey("fast sync should pick up gossip where the snapshot left off", t, func() {
+		link, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		So(dht.putMeta(nil, hd.EntryLink, link, "tag", &GobEntry{C: "linked"}), ShouldBeNil)
+		_, err := dht.FastSync([]peer.ID{h.id}, DefaultSnapshotSample)
+		So(err.Error(), ShouldEqual, "fast sync sample failed verification: link to "+link.String()+" not in snapshots")
+		dht.db.Update(func(tx *buntdb.Tx) error {
+			freed, err := deleteKeys(tx, "meta:"+hd.EntryLink.String()+":"+link.String()+":tag")
+			if err == nil {
+				err = dht.incSize(tx, -freed)
+			}
+			return err
+		})
+
+		size := func() (n int) {
+			dht.db.View(func(tx *buntdb.Tx) (err error) {
+				n, err = getIntVal("_size", tx)
+				return
+			})
+			return
+		}
+		before := size()
+		n, err := dht.FastSync([]peer.ID{h.id}, DefaultSnapshotSample)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0) // we already hold all our own entries
+		So(size(), ShouldEqual, before)
+		idx, _ := dht.GetIdx()
+		gidx, err := dht.GetGossiper(h.id)
+		So(err, ShouldBeNil)
+		So(gidx, ShouldEqual, idx)
+	})
+
+	Convey("fast sync should fail without any snapshots", t, func() {
+		p, _ := makePeer("peer")
+		_, err := dht.FastSync([]peer.ID{p}, DefaultSnapshotSample)
+		So(err, ShouldEqual, ErrNoSnapshots)
+	})
+}
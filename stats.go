@@ -0,0 +1,163 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// stats implements a history of a chain's activity by day, kept in the node's local store and
+// never published, so that operators can report on how an app is being used without sending
+// anything to outside analytics.  Each day records the entries committed to the chain by
+// type, the new entries the DHT store received and how many of them were new agents joining,
+// and the most gossip partners the node knew of while serving.  Days are in UTC.
+
+package holochain
+
+import (
+	"encoding/json"
+	"github.com/tidwall/buntdb"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultStatsInterval = 15 * time.Minute // how often the node's peers are counted while serving
+	MaxStatsDays         = 366              // the most days of stats returned at once
+
+	statsDayFormat = "2006-01-02"
+)
+
+// DayStats is the activity of a chain on a day
+type DayStats struct {
+	Day        string         // the day, as YYYY-MM-DD in UTC
+	Commits    int            // entries committed to the chain
+	EntryTypes map[string]int `json:",omitempty"` // entries committed to the chain by type
+	Received   int            // new entries received by the DHT store
+	NewAgents  int            // new agents whose key entries the DHT store received
+	Peers      int            // the most gossip partners known at any count
+}
+
+func statsKey(day string) string {
+	return "stats:" + day
+}
+
+func statsDay(t time.Time) string {
+	return t.UTC().Format(statsDayFormat)
+}
+
+// updateStats changes a day's stats within a transaction
+func updateStats(tx *buntdb.Tx, day string, fn func(s *DayStats)) (err error) {
+	s := DayStats{Day: day}
+	if v, e := tx.Get(statsKey(day)); e == nil {
+		if err = json.Unmarshal([]byte(v), &s); err != nil {
+			return
+		}
+	} else if e != buntdb.ErrNotFound {
+		return e
+	}
+	fn(&s)
+	var b []byte
+	if b, err = json.Marshal(&s); err != nil {
+		return
+	}
+	_, _, err = tx.Set(statsKey(day), string(b), nil)
+	return
+}
+
+// recordCommit counts an entry committed to the chain
+func (dht *DHT) recordCommit(entryType string, t time.Time) error {
+	return dht.db.Update(func(tx *buntdb.Tx) error {
+		return updateStats(tx, statsDay(t), func(s *DayStats) {
+			s.Commits++
+			if s.EntryTypes == nil {
+				s.EntryTypes = make(map[string]int)
+			}
+			s.EntryTypes[entryType]++
+		})
+	})
+}
+
+// recordReceived counts a new entry received by the DHT store within its put's transaction
+func recordReceived(tx *buntdb.Tx, entryType string, t time.Time) error {
+	return updateStats(tx, statsDay(t), func(s *DayStats) {
+		s.Received++
+		if entryType == AgentEntryType {
+			s.NewAgents++
+		}
+	})
+}
+
+// SampleStats counts the node's gossip partners into today's stats
+func (h *Holochain) SampleStats() (err error) {
+	var glist []Gossiper
+	if glist, err = h.dht.Gossipers(); err != nil {
+		return
+	}
+	err = h.dht.db.Update(func(tx *buntdb.Tx) error {
+		return updateStats(tx, statsDay(time.Now()), func(s *DayStats) {
+			if len(glist) > s.Peers {
+				s.Peers = len(glist)
+			}
+		})
+	})
+	return
+}
+
+// SampleStatsEvery counts the node's gossip partners at the given interval, until the chain
+// shuts down
+func (h *Holochain) SampleStatsEvery(interval time.Duration) {
+	sample := func() {
+		if err := h.SampleStats(); err != nil {
+			h.dht.dlog.Logf("stats error: %v", err)
+		}
+	}
+	sample()
+	h.runEvery(interval, sample)
+}
+
+// StatsHistory returns the chain's stats for each of the given number of days up to today,
+// oldest first, or for every day since the first recorded if days isn't positive, but never
+// for more than MaxStatsDays.  Days with no activity are included with zero counts.
+func (h *Holochain) StatsHistory(days int) (history []DayStats, err error) {
+	recorded := make(map[string]DayStats)
+	first := ""
+	err = h.dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("stats:*", func(key, value string) bool {
+			var s DayStats
+			if e = json.Unmarshal([]byte(value), &s); e != nil {
+				return false
+			}
+			day := strings.TrimPrefix(key, "stats:")
+			if first == "" {
+				first = day
+			}
+			recorded[day] = s
+			return true
+		})
+		return e
+	})
+	if err != nil {
+		return
+	}
+	today, _ := time.Parse(statsDayFormat, statsDay(time.Now()))
+	earliest := today.AddDate(0, 0, 1-MaxStatsDays)
+	start := today.AddDate(0, 0, 1-days)
+	if days <= 0 {
+		if first == "" {
+			return
+		}
+		if start, err = time.Parse(statsDayFormat, first); err != nil {
+			return
+		}
+	}
+	if start.Before(earliest) {
+		start = earliest
+	}
+	for d := start; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := d.Format(statsDayFormat)
+		s, ok := recorded[day]
+		if !ok {
+			s = DayStats{Day: day}
+		}
+		history = append(history, s)
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	today := statsDay(time.Now())
+
+	Convey("commits should be counted by entry type", t, func() {
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		_, err = h.Commit("myData", "4")
+		So(err, ShouldBeNil)
+		history, err := h.StatsHistory(1)
+		So(err, ShouldBeNil)
+		So(len(history), ShouldEqual, 1)
+		st := history[0]
+		So(st.Day, ShouldEqual, today)
+		So(st.EntryTypes["myData"], ShouldEqual, 2)
+		So(st.Commits, ShouldBeGreaterThanOrEqualTo, 2)
+	})
+
+	Convey("new entries received by the DHT store should be counted once", t, func() {
+		history, _ := h.StatsHistory(1)
+		before := history[0]
+		other, _ := makePeer("other")
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		agent, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+		So(h.dht.put(nil, "myData", hash, other, []byte("6"), LIVE), ShouldBeNil)
+		So(h.dht.put(nil, "myData", hash, other, []byte("6"), LIVE), ShouldBeNil)
+		So(h.dht.put(nil, AgentEntryType, agent, other, []byte("agent"), LIVE), ShouldBeNil)
+		history, err := h.StatsHistory(1)
+		So(err, ShouldBeNil)
+		So(history[0].Received, ShouldEqual, before.Received+2)
+		So(history[0].NewAgents, ShouldEqual, before.NewAgents+1)
+	})
+
+	Convey("sampling should record the most peers known", t, func() {
+		So(h.SampleStats(), ShouldBeNil)
+		history, _ := h.StatsHistory(1)
+		So(history[0].Peers, ShouldBeGreaterThanOrEqualTo, 0)
+		So(h.dht.db.Update(func(tx *buntdb.Tx) error {
+			return updateStats(tx, today, func(s *DayStats) { s.Peers = 5 })
+		}), ShouldBeNil)
+		So(h.SampleStats(), ShouldBeNil)
+		history, _ = h.StatsHistory(1)
+		So(history[0].Peers, ShouldEqual, 5)
+	})
+
+	Convey("history should include days without activity", t, func() {
+		earlier := statsDay(time.Now().AddDate(0, 0, -3))
+		So(h.dht.db.Update(func(tx *buntdb.Tx) error {
+			return updateStats(tx, earlier, func(s *DayStats) { s.Commits = 7 })
+		}), ShouldBeNil)
+		history, err := h.StatsHistory(7)
+		So(err, ShouldBeNil)
+		So(len(history), ShouldEqual, 7)
+		So(history[6].Day, ShouldEqual, today)
+		So(history[3].Day, ShouldEqual, earlier)
+		So(history[3].Commits, ShouldEqual, 7)
+		So(history[4].Commits, ShouldEqual, 0)
+		So(history[0].Commits, ShouldEqual, 0)
+
+		history, err = h.StatsHistory(0)
+		So(err, ShouldBeNil)
+		So(len(history), ShouldEqual, 4)
+		So(history[0].Day, ShouldEqual, earlier)
+	})
+
+	Convey("history should never be longer than MaxStatsDays", t, func() {
+		history, err := h.StatsHistory(1000000)
+		So(err, ShouldBeNil)
+		So(len(history), ShouldEqual, MaxStatsDays)
+		So(history[MaxStatsDays-1].Day, ShouldEqual, today)
+
+		So(h.dht.db.Update(func(tx *buntdb.Tx) error {
+			return updateStats(tx, "0001-01-01", func(s *DayStats) { s.Commits = 1 })
+		}), ShouldBeNil)
+		history, err = h.StatsHistory(0)
+		So(err, ShouldBeNil)
+		So(len(history), ShouldEqual, MaxStatsDays)
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// subscriptions implements standing queries: a query names an entry type and the values some
+// of its fields must have, and each entry matching it that is committed to the local chain or
+// received into the DHT store is pushed to the subscriber as it arrives, so apps can react to
+// new entries without polling.  Clients of hc serve subscribe over a websocket or server-sent
+// events, and zomes declare their Subscriptions in the DNA to have matches passed to their
+// exposed receive function.  Entries a receive function commits are pushed to clients but not
+// passed to receive functions, so that one committing an entry its own subscription matches,
+// or two zomes answering each other, can't loop forever.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"reflect"
+)
+
+// ReceiveFunctionName is the zome function matches of the zome's subscriptions are passed to
+const ReceiveFunctionName = "receive"
+
+// Query selects entries of a type whose fields have the given values
+type Query struct {
+	Entry string
+	Where map[string]interface{} `json:",omitempty" toml:",omitempty"` // top level fields of json entries
+}
+
+// QueryMatch is an entry that matched a query, as pushed to subscribers
+type QueryMatch struct {
+	Hash      string
+	EntryType string
+	Entry     interface{} // the entry's content, decoded if it's json
+	Source    string      // "commit" if committed locally, or "put" if received from another node
+	From      string      `json:",omitempty"` // the node that put it
+
+	received bool    // committed by a zome's receive function
+	from     peer.ID // the node that put it, which the receive function is called on behalf of
+}
+
+// Check makes sure the query selects an entry type of the DNA that it can filter
+func (q *Query) Check(h *Holochain) (err error) {
+	if q.Entry == "" {
+		return errors.New("query must name an entry type")
+	}
+	_, def, err := h.GetEntryDef(q.Entry)
+	if err != nil {
+		return
+	}
+	if len(q.Where) > 0 && def.DataFormat != DataFormatJSON {
+		err = fmt.Errorf("query of %s can't filter fields of a %s entry", q.Entry, def.DataFormat)
+	}
+	return
+}
+
+// ParseQuery parses a query from its json
+func ParseQuery(h *Holochain, j string) (q Query, err error) {
+	if err = json.Unmarshal([]byte(j), &q); err != nil {
+		err = fmt.Errorf("bad query: %v", err)
+		return
+	}
+	err = q.Check(h)
+	return
+}
+
+// matches returns true if the fields of a json entry have the values the query selects
+func (q *Query) matches(fields map[string]interface{}) bool {
+	for k, v := range q.Where {
+		if !reflect.DeepEqual(fields[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the match for a followed entry if it's selected by the query
+func (q *Query) match(f *Followed) (m *QueryMatch) {
+	_, name := SplitEntryType(q.Entry)
+	if f.Err != nil || f.Event.EntryType != name {
+		return
+	}
+	content := f.Entry.Content()
+	if s, ok := content.(string); ok && len(q.Where) > 0 {
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(s), &fields) != nil {
+			return
+		}
+		if !q.matches(fields) {
+			return
+		}
+		content = fields
+	}
+	m = &QueryMatch{Hash: f.Event.Hash.String(), EntryType: name, Entry: content, Source: "put"}
+	if f.Event.Type == EventCommit {
+		m.Source = "commit"
+		m.received = f.Event.Received
+	} else if f.Event.Peer != "" {
+		m.From = peer.IDB58Encode(f.Event.Peer)
+		m.from = f.Event.Peer
+	}
+	return
+}
+
+// SubscribeQuery returns a channel on which each newly committed or received entry matching
+// the query is sent, until stop is closed
+func (h *Holochain) SubscribeQuery(q Query, stop <-chan struct{}) (matches <-chan QueryMatch, err error) {
+	if err = q.Check(h); err != nil {
+		return
+	}
+	followed := h.Follow(stop)
+	out := make(chan QueryMatch, DefaultEventBuffer)
+	go func() {
+		defer close(out)
+		for f := range followed {
+			if m := q.match(&f); m != nil {
+				select {
+				case out <- *m:
+				case <-stop:
+				}
+			}
+		}
+	}()
+	matches = out
+	return
+}
+
+// checkSubscriptions checks the queries a zome subscribes to
+func (z *Zome) checkSubscriptions(h *Holochain) (err error) {
+	for i := range z.Subscriptions {
+		if err = z.Subscriptions[i].Check(h); err != nil {
+			return fmt.Errorf("zome %s subscription: %v", z.Name, err)
+		}
+	}
+	return
+}
+
+// ReceiveSubscriptions passes the matches of the zomes' subscriptions to their receive
+// functions as json, until stop is closed
+func (h *Holochain) ReceiveSubscriptions(stop <-chan struct{}) (err error) {
+	for name, z := range h.Zomes {
+		for _, q := range z.Subscriptions {
+			var matches <-chan QueryMatch
+			if matches, err = h.SubscribeQuery(q, stop); err != nil {
+				return
+			}
+			go h.receiveMatches(name, matches)
+		}
+	}
+	return
+}
+
+// receiveMatches calls a zome's receive function with each match that wasn't itself committed
+// by a receive function
+func (h *Holochain) receiveMatches(zome string, matches <-chan QueryMatch) {
+	r := *h
+	r.receiving = true
+	for m := range matches {
+		if m.received {
+			continue
+		}
+		c := &r
+		if m.from != "" {
+			c = r.WithCaller(m.from)
+		}
+		b, err := json.Marshal(m)
+		if err == nil {
+			_, err = c.Call(zome, ReceiveFunctionName, string(b))
+		}
+		if err != nil {
+			Infof("%s: %s:%s failed for %s: %v", h.Name, zome, ReceiveFunctionName, m.Hash, err)
+		}
+	}
+}
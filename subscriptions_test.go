@@ -0,0 +1,97 @@
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func nextMatch(c <-chan QueryMatch) (m QueryMatch, ok bool) {
+	select {
+	case m, ok = <-c:
+	case <-time.After(time.Second):
+	}
+	return
+}
+
+func TestQueryCheck(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should check the queried entry type", t, func() {
+		_, err := ParseQuery(h, `{"Entry":"profile","Where":{"firstName":"Art"}}`)
+		So(err, ShouldBeNil)
+		_, err = ParseQuery(h, `{}`)
+		So(err.Error(), ShouldEqual, "query must name an entry type")
+		_, err = ParseQuery(h, `{"Entry":"nope"}`)
+		So(err, ShouldNotBeNil)
+		_, err = ParseQuery(h, `{"Entry":"myData","Where":{"x":1}}`)
+		So(err.Error(), ShouldEqual, "query of myData can't filter fields of a zygo entry")
+		_, err = ParseQuery(h, `[`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSubscribeQuery(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	stop := make(chan struct{})
+	matches, err := h.SubscribeQuery(Query{Entry: "profile", Where: map[string]interface{}{"firstName": "Art"}}, stop)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should push committed entries matching the query", t, func() {
+		_, err := h.Call("myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		_, err = h.Commit("profile", `{"firstName":"Eric","lastName":"H"}`)
+		So(err, ShouldBeNil)
+		hash, err := h.Commit("profile", `{"firstName":"Art","lastName":"Brock"}`)
+		So(err, ShouldBeNil)
+
+		m, ok := nextMatch(matches)
+		So(ok, ShouldBeTrue)
+		So(m.Hash, ShouldEqual, hash.String())
+		So(m.EntryType, ShouldEqual, "profile")
+		So(m.Source, ShouldEqual, "commit")
+		So(m.Entry.(map[string]interface{})["lastName"], ShouldEqual, "Brock")
+		So(len(matches), ShouldEqual, 0)
+	})
+
+	Convey("it should match entries put by other nodes", t, func() {
+		q := Query{Entry: "profile", Where: map[string]interface{}{"firstName": "Art"}}
+		other, _ := makePeer("other")
+		f := Followed{
+			Event: Event{Type: EventPutReceived, EntryType: "profile", Peer: other},
+			Entry: &GobEntry{C: `{"firstName":"Art"}`},
+		}
+		m := q.match(&f)
+		So(m.Source, ShouldEqual, "put")
+		So(m.From, ShouldEqual, peer.IDB58Encode(other))
+		So(m.from, ShouldEqual, other)
+		f.Entry = &GobEntry{C: `{"firstName":"Eric"}`}
+		So(q.match(&f), ShouldBeNil)
+	})
+
+	Convey("it should mark entries committed by receive functions so they aren't passed back", t, func() {
+		r := *h
+		r.receiving = true
+		_, err := r.Commit("profile", `{"firstName":"Art","lastName":"Received"}`)
+		So(err, ShouldBeNil)
+		m, ok := nextMatch(matches)
+		So(ok, ShouldBeTrue)
+		So(m.received, ShouldBeTrue)
+		_, err = h.Commit("profile", `{"firstName":"Art","lastName":"Again"}`)
+		So(err, ShouldBeNil)
+		m, _ = nextMatch(matches)
+		So(m.received, ShouldBeFalse)
+	})
+
+	Convey("it should stop when told to", t, func() {
+		close(stop)
+		_, ok := nextMatch(matches)
+		So(ok, ShouldBeFalse)
+	})
+}
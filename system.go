@@ -0,0 +1,347 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// system implements the built in "hc" zome, which is available to every holochain and
+// provides utilities that app zomes would otherwise have to re-implement in each nucleus
+
+package holochain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	mh "github.com/multiformats/go-multihash"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SystemZomeName is the name of the built in zome, which app zomes may not use
+const SystemZomeName = "hc"
+
+var ErrSystemZomeName error = errors.New("zome name " + SystemZomeName + " is reserved for the system zome")
+
+type systemFunction struct {
+	FunctionDef
+	fn func(h *Holochain, arg string) (string, error)
+}
+
+var systemFunctions = map[string]systemFunction{
+	"hash": {
+		FunctionDef{Name: "hash", Description: "hashes a string with the holochain's hash type", Arg: StringType, Returns: HashType, ReadOnly: true},
+		sysHash,
+	},
+	"base58Encode": {
+		FunctionDef{Name: "base58Encode", Description: "encodes a string as base58", Arg: StringType, Returns: StringType, ReadOnly: true},
+		func(h *Holochain, arg string) (string, error) { return b58.Encode([]byte(arg)), nil },
+	},
+	"base58Decode": {
+		FunctionDef{Name: "base58Decode", Description: "decodes a base58 string", Arg: StringType, Returns: StringType, ReadOnly: true},
+		sysBase58Decode,
+	},
+	"multihashDecode": {
+		FunctionDef{Name: "multihashDecode", Description: "splits a hash into its hash function code, name, length and hex digest", Arg: HashType, Returns: JSONType, ReadOnly: true},
+		sysMultihashDecode,
+	},
+	"multihashFromHex": {
+		FunctionDef{Name: "multihashFromHex", Description: "converts a hex encoded multihash to a hash", Arg: StringType, Returns: HashType, ReadOnly: true},
+		sysMultihashFromHex,
+	},
+	"jsonGet": {
+		FunctionDef{Name: "jsonGet", Description: "returns the value at a dotted path, e.g. a.b.0, of {Object,Path}", Arg: JSONType, Returns: JSONType, ReadOnly: true},
+		sysJSONGet,
+	},
+	"jsonMerge": {
+		FunctionDef{Name: "jsonMerge", Description: "merges a list of objects, later keys replacing earlier ones", Arg: JSONType, Returns: JSONType, ReadOnly: true},
+		sysJSONMerge,
+	},
+	"verifySignature": {
+		FunctionDef{Name: "verifySignature", Description: "checks that {Data,Signature,Key} is a valid signature, with Signature and Key base58 encoded", Arg: JSONType, Returns: JSONType, ReadOnly: true},
+		sysVerifySignature,
+	},
+	"verify": {
+		FunctionDef{Name: "verify", Description: "checks that {Data,Signer,PubKey,Signature} is a signature of Data made by sign", Arg: JSONType, Returns: JSONType, ReadOnly: true},
+		sysVerify,
+	},
+	"agentInfo": {
+		FunctionDef{Name: "agentInfo", Description: "returns the name, agent entry hash, node id and public key of this agent", Returns: JSONType, ReadOnly: true},
+		sysAgentInfo,
+	},
+	"attest": {
+		FunctionDef{Name: "attest", Description: "publishes this agent's signed attestation of {Kind,Identity,Proof}, where Kind is email, domain or key, returning {Hash,Salt}, Salt being the secret an email address was hashed with", Arg: JSONType, Returns: JSONType},
+		sysAttest,
+	},
+	"attestations": {
+		FunctionDef{Name: "attestations", Description: "returns the verified attestations of the agent with the given node id", Arg: StringType, Returns: JSONType, ReadOnly: true},
+		sysAttestations,
+	},
+	"verifyAttestation": {
+		FunctionDef{Name: "verifyAttestation", Description: "checks the signatures of an attestation, and for domain attestations that the domain lists the agent", Arg: JSONType, Returns: JSONType, ReadOnly: true},
+		sysVerifyAttestation,
+	},
+	"redact": {
+		FunctionDef{Name: "redact", Description: "publishes a redaction of {Entry,Reason}, which has the nodes holding the entry drop its content, returning the redaction's hash", Arg: JSONType, Returns: StringType},
+		sysRedact,
+	},
+	"liveness": {
+		FunctionDef{Name: "liveness", Description: "returns the chain head and time of the last heartbeat of the agent with the given node id, and whether it's alive", Arg: StringType, Returns: JSONType, ReadOnly: true},
+		sysLiveness,
+	},
+}
+
+// SystemFunctions returns the declarations of the system zome's functions sorted by name
+func SystemFunctions() (defs []FunctionDef) {
+	var names []string
+	for name := range systemFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		defs = append(defs, systemFunctions[name].FunctionDef)
+	}
+	return
+}
+
+// callSystem calls a function of the system zome
+func (h *Holochain) callSystem(function string, arguments interface{}) (result interface{}, err error) {
+	f, ok := systemFunctions[function]
+	if !ok {
+		err = fmt.Errorf("unknown %s function: %s", SystemZomeName, function)
+		return
+	}
+	var arg string
+	if f.Arg != "" {
+		arg, err = f.marshalArg(arguments)
+		if err != nil {
+			return
+		}
+	}
+	result, err = f.fn(h, arg)
+	return
+}
+
+func marshalJSON(v interface{}) (s string, err error) {
+	var b []byte
+	b, err = json.Marshal(v)
+	s = string(b)
+	return
+}
+
+func sysHash(h *Holochain, arg string) (s string, err error) {
+	var hash Hash
+	if err = hash.Sum(h.hashSpec, []byte(arg)); err != nil {
+		return
+	}
+	s = hash.String()
+	return
+}
+
+func sysBase58Decode(h *Holochain, arg string) (s string, err error) {
+	b := b58.Decode(arg)
+	if len(b) == 0 && arg != "" {
+		err = fmt.Errorf("invalid base58: %s", arg)
+		return
+	}
+	s = string(b)
+	return
+}
+
+func sysMultihashDecode(h *Holochain, arg string) (s string, err error) {
+	var hash Hash
+	hash, err = NewHash(strings.Trim(arg, "\""))
+	if err != nil {
+		return
+	}
+	var d *mh.DecodedMultihash
+	d, err = mh.Decode(hash.H)
+	if err != nil {
+		return
+	}
+	s, err = marshalJSON(map[string]interface{}{"Code": d.Code, "Name": d.Name, "Length": d.Length, "Digest": hex.EncodeToString(d.Digest)})
+	return
+}
+
+func sysMultihashFromHex(h *Holochain, arg string) (s string, err error) {
+	var m mh.Multihash
+	m, err = mh.FromHexString(arg)
+	if err != nil {
+		return
+	}
+	s = m.B58String()
+	return
+}
+
+func sysJSONGet(h *Holochain, arg string) (s string, err error) {
+	var req struct {
+		Object interface{}
+		Path   string
+	}
+	if err = json.Unmarshal([]byte(arg), &req); err != nil {
+		return
+	}
+	v := req.Object
+	if req.Path != "" {
+		for _, p := range strings.Split(req.Path, ".") {
+			switch t := v.(type) {
+			case map[string]interface{}:
+				var ok bool
+				if v, ok = t[p]; !ok {
+					err = fmt.Errorf("path not found: %s", req.Path)
+					return
+				}
+			case []interface{}:
+				i, e := strconv.Atoi(p)
+				if e != nil || i < 0 || i >= len(t) {
+					err = fmt.Errorf("path not found: %s", req.Path)
+					return
+				}
+				v = t[i]
+			default:
+				err = fmt.Errorf("path not found: %s", req.Path)
+				return
+			}
+		}
+	}
+	s, err = marshalJSON(v)
+	return
+}
+
+func sysJSONMerge(h *Holochain, arg string) (s string, err error) {
+	var objects []map[string]interface{}
+	if err = json.Unmarshal([]byte(arg), &objects); err != nil {
+		err = fmt.Errorf("expected a list of objects: %v", err)
+		return
+	}
+	merged := make(map[string]interface{})
+	for _, o := range objects {
+		for k, v := range o {
+			merged[k] = v
+		}
+	}
+	s, err = marshalJSON(merged)
+	return
+}
+
+func sysVerifySignature(h *Holochain, arg string) (s string, err error) {
+	var req struct {
+		Data      string
+		Signature string
+		Key       string
+	}
+	if err = json.Unmarshal([]byte(arg), &req); err != nil {
+		return
+	}
+	var key ic.PubKey
+	key, err = ic.UnmarshalPublicKey(b58.Decode(req.Key))
+	if err != nil {
+		return
+	}
+	var ok bool
+	ok, err = key.Verify([]byte(req.Data), b58.Decode(req.Signature))
+	if err != nil {
+		// a malformed signature just doesn't verify
+		ok, err = false, nil
+	}
+	s = strconv.FormatBool(ok)
+	return
+}
+
+func sysAgentInfo(h *Holochain, arg string) (s string, err error) {
+	a := h.Agent()
+	var pub []byte
+	pub, err = ic.MarshalPublicKey(a.PubKey())
+	if err != nil {
+		return
+	}
+	s, err = marshalJSON(map[string]string{
+		"Name":   string(a.Name()),
+		"Hash":   h.agentHash.String(),
+		"Id":     peer.IDB58Encode(h.id),
+		"PubKey": b58.Encode(pub),
+	})
+	return
+}
+
+func sysLiveness(h *Holochain, arg string) (s string, err error) {
+	head, at, alive, err := h.Liveness(arg)
+	if err != nil {
+		return
+	}
+	s, err = marshalJSON(map[string]interface{}{"Head": head.String(), "Time": at, "Alive": alive})
+	return
+}
+
+func sysAttest(h *Holochain, arg string) (s string, err error) {
+	var req struct{ Kind, Identity, Proof string }
+	if err = json.Unmarshal([]byte(arg), &req); err != nil {
+		return
+	}
+	var hash Hash
+	var salt string
+	hash, salt, err = h.Attest(req.Kind, req.Identity, req.Proof)
+	if err != nil {
+		return
+	}
+	s, err = marshalJSON(map[string]string{"Hash": hash.String(), "Salt": salt})
+	return
+}
+
+func sysRedact(h *Holochain, arg string) (s string, err error) {
+	var req struct{ Entry, Reason string }
+	if err = json.Unmarshal([]byte(arg), &req); err != nil {
+		return
+	}
+	var key Hash
+	if key, err = NewHash(req.Entry); err != nil {
+		return
+	}
+	var hash Hash
+	hash, err = h.Redact(key, req.Reason)
+	if err != nil {
+		return
+	}
+	s = hash.String()
+	return
+}
+
+func sysAttestations(h *Holochain, arg string) (s string, err error) {
+	var id peer.ID
+	id, err = peer.IDB58Decode(arg)
+	if err != nil {
+		return
+	}
+	var attestations []*Attestation
+	attestations, err = h.Attestations(id)
+	if err != nil {
+		return
+	}
+	if attestations == nil {
+		attestations = []*Attestation{}
+	}
+	s, err = marshalJSON(attestations)
+	return
+}
+
+func sysVerifyAttestation(h *Holochain, arg string) (s string, err error) {
+	var a Attestation
+	if err = json.Unmarshal([]byte(arg), &a); err != nil {
+		return
+	}
+	err = a.Verify()
+	if err == nil && a.Kind == AttestDomain {
+		err = a.VerifyDomain()
+	}
+	if err != nil {
+		// an attestation that doesn't check out just isn't valid
+		s = strconv.FormatBool(false)
+		err = nil
+		return
+	}
+	s = strconv.FormatBool(true)
+	return
+}
@@ -0,0 +1,97 @@
+package holochain
+
+import (
+	"encoding/json"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSystemZome(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should hash with the holochain's hash type", t, func() {
+		var expected Hash
+		expected.Sum(h.hashSpec, []byte("fish"))
+		result, err := h.Call(SystemZomeName, "hash", "fish")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, expected.String())
+
+		result, err = h.Call(SystemZomeName, "multihashDecode", expected.String())
+		So(err, ShouldBeNil)
+		var decoded map[string]interface{}
+		json.Unmarshal([]byte(result.(string)), &decoded)
+		So(decoded["Name"], ShouldEqual, "sha2-256")
+		So(decoded["Length"], ShouldEqual, 32)
+
+		result, err = h.Call(SystemZomeName, "multihashFromHex", expected.H.HexString())
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, expected.String())
+	})
+
+	Convey("it should convert to and from base58", t, func() {
+		result, err := h.Call(SystemZomeName, "base58Encode", "hello")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "Cn8eVZg")
+		result, err = h.Call(SystemZomeName, "base58Decode", "Cn8eVZg")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "hello")
+	})
+
+	Convey("it should manipulate json", t, func() {
+		result, err := h.Call(SystemZomeName, "jsonGet", `{"Object":{"a":{"b":[1,"x"]}},"Path":"a.b.1"}`)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, `"x"`)
+		_, err = h.Call(SystemZomeName, "jsonGet", `{"Object":{"a":1},"Path":"a.b"}`)
+		So(err.Error(), ShouldEqual, "path not found: a.b")
+
+		result, err = h.Call(SystemZomeName, "jsonMerge", `[{"a":1,"b":2},{"b":3}]`)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, `{"a":1,"b":3}`)
+	})
+
+	Convey("it should verify signatures", t, func() {
+		sig, err := h.Agent().PrivKey().Sign([]byte("fish"))
+		So(err, ShouldBeNil)
+		pub, _ := ic.MarshalPublicKey(h.Agent().PubKey())
+		arg := map[string]string{"Data": "fish", "Signature": b58.Encode(sig), "Key": b58.Encode(pub)}
+		result, err := h.Call(SystemZomeName, "verifySignature", arg)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "true")
+
+		arg["Data"] = "fowl"
+		result, err = h.Call(SystemZomeName, "verifySignature", arg)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "false")
+	})
+
+	Convey("it should describe the agent", t, func() {
+		result, err := h.Call(SystemZomeName, "agentInfo", nil)
+		So(err, ShouldBeNil)
+		var info map[string]string
+		json.Unmarshal([]byte(result.(string)), &info)
+		So(info["Name"], ShouldEqual, string(h.Agent().Name()))
+		So(info["Hash"], ShouldEqual, h.agentHash.String())
+	})
+
+	Convey("it should reject unknown functions", t, func() {
+		_, err := h.Call(SystemZomeName, "fish", "")
+		So(err.Error(), ShouldEqual, "unknown hc function: fish")
+	})
+
+	Convey("it should be described with the app zomes", t, func() {
+		schemas, err := h.FunctionSchemas()
+		So(err, ShouldBeNil)
+		So(len(schemas[SystemZomeName]), ShouldEqual, len(systemFunctions))
+		So(h.readOnly(&BatchCall{Zome: SystemZomeName, Fn: "hash"}), ShouldBeTrue)
+	})
+
+	Convey("app zomes may not use its name", t, func() {
+		h.Zomes[SystemZomeName] = h.Zomes["myZome"]
+		err := h.Prepare()
+		delete(h.Zomes, SystemZomeName)
+		So(err, ShouldEqual, ErrSystemZomeName)
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// template implements cloning from template apps, whose DNA and config may contain {{NAME}}
+// placeholders that are filled in at clone time, so that many configured instances can be
+// stamped out from a single source.  Values are escaped as the contents of a double quoted
+// string, which all of the codec formats read alike, so a value can't end the string it is
+// placed in and add settings of its own.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var templateVarRegexp = regexp.MustCompile(`{{([A-Z0-9_]+)}}`)
+
+// escapeTemplateValue escapes a value to go inside a double quoted string, leaving values
+// like numbers that need no escaping unchanged
+func escapeTemplateValue(v string) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b[1 : len(b)-1])
+}
+
+// SubstituteTemplate replaces the {{NAME}} placeholders in text with their escaped values from
+// vars, returning an error naming any that have no value
+func SubstituteTemplate(text string, vars map[string]string) (result string, err error) {
+	missing := make(map[string]bool)
+	result = templateVarRegexp.ReplaceAllStringFunc(text, func(m string) string {
+		name := templateVarRegexp.FindStringSubmatch(m)[1]
+		v, ok := vars[name]
+		if !ok {
+			missing[name] = true
+			return m
+		}
+		return escapeTemplateValue(v)
+	})
+	if len(missing) > 0 {
+		var names []string
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		err = fmt.Errorf("unresolved template variables: %s", strings.Join(names, ", "))
+	}
+	return
+}
+
+// LoadTemplateAnswers reads template variable values from a file in any of the codec
+// formats, chosen by its extension
+func LoadTemplateAnswers(path string) (vars map[string]string, err error) {
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	vars = make(map[string]string)
+	if err = Decode(f, format, &vars); err != nil {
+		err = fmt.Errorf("bad answers file %s: %v", path, err)
+	}
+	return
+}
+
+// templateDefaults returns a copy of vars with values for the variables that can be derived
+// from the cloning agent if they weren't given
+func templateDefaults(vars map[string]string, agent Agent) map[string]string {
+	v := make(map[string]string)
+	for k, val := range vars {
+		v[k] = val
+	}
+	if _, ok := v["AGENT_NAME"]; !ok {
+		v["AGENT_NAME"] = string(agent.Name())
+	}
+	return v
+}
+
+// openTemplate returns a reader of the file, with its template variables substituted if
+// vars isn't nil
+func openTemplate(path string, vars map[string]string) (r io.Reader, err error) {
+	var b []byte
+	b, err = ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if vars != nil {
+		var s string
+		if s, err = SubstituteTemplate(string(b), vars); err != nil {
+			err = fmt.Errorf("%s: %v", filepath.Base(path), err)
+			return
+		}
+		b = []byte(s)
+	}
+	r = bytes.NewReader(b)
+	return
+}
+
+// configFromTemplate replaces the chain's default config with the template's, if it has one
+func (h *Holochain) configFromTemplate(srcPath string, vars map[string]string) (err error) {
+	format, e := findEncodedFile(srcPath, ConfigFileName)
+	if e != nil {
+		// templates don't have to provide a config
+		return
+	}
+	var r io.Reader
+	r, err = openTemplate(filepath.Join(srcPath, ConfigFileName+"."+format), vars)
+	if err != nil {
+		return
+	}
+	if err = Decode(r, format, &h.config); err != nil {
+		return
+	}
+	var f *os.File
+	f, err = os.Create(filepath.Join(h.path, ConfigFileName+"."+h.encodingFormat))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if err = Encode(f, h.encodingFormat, &h.config); err != nil {
+		return
+	}
+	err = h.setupConfig()
+	return
+}
+
+// CloneTemplate clones a holochain from a template source, filling in the placeholders in its
+// DNA and config from vars.  AGENT_NAME defaults to the name of the service's agent.
+func (s *Service) CloneTemplate(srcPath string, path string, vars map[string]string) (hP *Holochain, err error) {
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	hP, err = s.clone(srcPath, path, true, vars)
+	return
+}
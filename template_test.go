@@ -0,0 +1,80 @@
+package holochain
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSubstituteTemplate(t *testing.T) {
+	Convey("it should fill in template variables", t, func() {
+		s, err := SubstituteTemplate("port {{PORT}} for {{COMMUNITY}}, {{PORT}} {{lower}}", map[string]string{"PORT": "4141", "COMMUNITY": "gardeners"})
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, "port 4141 for gardeners, 4141 {{lower}}")
+	})
+
+	Convey("it should escape values so they stay inside their string", t, func() {
+		s, err := SubstituteTemplate(`{"Name":"{{NAME}}"}`, map[string]string{"NAME": `x","Admin":true,"y":"<\`})
+		So(err, ShouldBeNil)
+		var v map[string]interface{}
+		So(json.Unmarshal([]byte(s), &v), ShouldBeNil)
+		So(v["Name"], ShouldEqual, `x","Admin":true,"y":"<\`)
+		So(v["Admin"], ShouldBeNil)
+
+		var toml struct{ Name string }
+		s, _ = SubstituteTemplate(`Name = "{{NAME}}"`, map[string]string{"NAME": "a\"\nAdmin = true"})
+		So(Decode(strings.NewReader(s), "toml", &toml), ShouldBeNil)
+		So(toml.Name, ShouldEqual, "a\"\nAdmin = true")
+	})
+
+	Convey("it should report unresolved variables", t, func() {
+		_, err := SubstituteTemplate("{{PORT}} {{COMMUNITY}} {{PORT}}", map[string]string{})
+		So(err.Error(), ShouldEqual, "unresolved template variables: COMMUNITY, PORT")
+	})
+}
+
+func TestCloneTemplate(t *testing.T) {
+	d, s, _ := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	orig := s.Path + "/test"
+	dna, _ := ioutil.ReadFile(orig + "/dna.toml")
+	ioutil.WriteFile(orig+"/dna.toml", []byte(strings.Replace(string(dna), "a bogus test holochain", "{{COMMUNITY}} chain for {{AGENT_NAME}}", 1)), 0644)
+	ioutil.WriteFile(orig+"/config.json", []byte(`{"Port":{{PORT}},"GCRetention":"1h"}`), 0644)
+
+	Convey("it should report missing variables", t, func() {
+		_, err := s.CloneTemplate(orig, s.Path+"/missing", map[string]string{"PORT": "4141"})
+		So(err.Error(), ShouldEqual, "dna.toml: unresolved template variables: COMMUNITY")
+		So(dirExists(s.Path+"/missing"), ShouldBeFalse)
+	})
+
+	Convey("it should fill in the DNA and config of the clone", t, func() {
+		h, err := s.CloneTemplate(orig, s.Path+"/gardeners", map[string]string{"PORT": "4141", "COMMUNITY": "gardeners"})
+		So(err, ShouldBeNil)
+		So(h.Properties["description"], ShouldEqual, "gardeners chain for "+string(h.agent.Name()))
+		So(h.config.Port, ShouldEqual, 4141)
+		So(h.config.GCRetention, ShouldEqual, "1h")
+
+		h, err = s.Load("gardeners")
+		So(err, ShouldBeNil)
+		So(h.config.Port, ShouldEqual, 4141)
+	})
+
+	Convey("it should load answers files", t, func() {
+		ioutil.WriteFile(d+"/answers.json", []byte(`{"PORT":"5151","COMMUNITY":"bakers","AGENT_NAME":"Bob"}`), 0644)
+		vars, err := LoadTemplateAnswers(d + "/answers.json")
+		So(err, ShouldBeNil)
+		h, err := s.CloneTemplate(orig, s.Path+"/bakers", vars)
+		So(err, ShouldBeNil)
+		So(h.Properties["description"], ShouldEqual, "bakers chain for Bob")
+		So(h.config.Port, ShouldEqual, 5151)
+	})
+
+	Convey("plain clones should leave placeholders alone", t, func() {
+		h, err := s.Clone(orig, s.Path+"/plain", true)
+		So(err, ShouldBeNil)
+		So(h.Properties["description"], ShouldEqual, "{{COMMUNITY}} chain for {{AGENT_NAME}}")
+	})
+}
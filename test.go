@@ -4,6 +4,7 @@ import (
 	"bytes"
 	. "github.com/smartystreets/goconvey/convey"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -25,7 +26,7 @@ func mkTestDirName() string {
 func setupTestService() (d string, s *Service) {
 	d = mkTestDirName()
 	agent := AgentName("Herbert <h@bert.com>")
-	s, err := Init(d+"/"+DefaultDirectoryName, agent)
+	s, err := Init(filepath.Join(d, DefaultDirectoryName), agent)
 	s.Settings.DefaultBootstrapServer = "localhost:3142"
 	if err != nil {
 		panic(err)
@@ -35,7 +36,7 @@ func setupTestService() (d string, s *Service) {
 
 func setupTestChain(n string) (d string, s *Service, h *Holochain) {
 	d, s = setupTestService()
-	path := s.Path + "/" + n
+	path := filepath.Join(s.Path, n)
 	h, err := s.GenDev(path, "toml")
 	if err != nil {
 		panic(err)
@@ -0,0 +1,229 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// testdata implements the parts of an app's test files beyond each test's input and
+// expected output: the zome calls that set up for and clean up after a test, the signals a
+// test's call must emit, and the state entries must reach in the DHT, on the test node or on
+// mock nodes started alongside it.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TestWait is how long a test waits for the signals it expects, and for entries to reach the
+// state it expects in the DHT, as puts are handled in the background
+const TestWait = time.Second
+
+// TestSignalQuiet is how long a test keeps listening after its expected signals for any
+// unexpected ones
+const TestSignalQuiet = 100 * time.Millisecond
+
+// TestNodeWait is how long a test waits for entries to reach the state it expects on a mock
+// node, which only gets them by gossip
+const TestNodeWait = 5 * time.Second
+
+// TestGossipInterval is how often mock nodes gossip with the test node
+const TestGossipInterval = 50 * time.Millisecond
+
+// TestStep is a zome call made to set up for, or clean up after, a test
+type TestStep struct {
+	Zome   string
+	FnName string
+	Input  string
+}
+
+// TestDHTState is the state an entry must reach in the DHT after a test's call, either in
+// the test node's own store or in that of one of the mock nodes started for the test file.
+type TestDHTState struct {
+	Hash   string
+	Type   string // if set the entry type the entry must be stored as
+	Status string // live, rejected, deleted, updated, redacted, quarantined or missing, defaulting to live
+	Node   int    `json:",omitempty"` // the mock node, numbered from 1, to check the state on, or 0 for the test node
+}
+
+// TestStatuses are the names of the DHT statuses used in test files
+var TestStatuses = map[string]int{
+	"live":        LIVE,
+	"rejected":    REJECTED,
+	"deleted":     DELETED,
+	"updated":     UPDATED,
+	"redacted":    REDACTED,
+	"quarantined": QUARANTINED,
+}
+
+// testCalls makes a test's setup or cleanup zome calls
+func (h *Holochain) testCalls(testID string, stage string, calls []TestStep, replace func(string) string) (err error) {
+	for i, c := range calls {
+		if _, err = h.Call(c.Zome, c.FnName, replace(c.Input)); err != nil {
+			err = fmt.Errorf("\nTest: %s\n\t%s call %d (%s:%s) failed: %v", testID, stage, i, c.Zome, c.FnName, err)
+			h.config.Loggers.TestFailed.pf(fmt.Sprintf("\n=====================\n%s\n\tfailed! m(\n=====================", err.Error()))
+			return
+		}
+	}
+	return
+}
+
+// collectSignals returns the names of the events sent on c, waiting at most TestWait for the
+// first n and then listening until no more arrive within TestSignalQuiet, so that unexpected
+// signals are caught too
+func collectSignals(c <-chan Event, n int) (names []string) {
+	timeout := time.After(TestWait)
+	for {
+		wait := TestWait
+		if len(names) >= n {
+			wait = TestSignalQuiet
+		}
+		select {
+		case e := <-c:
+			names = append(names, EventNames[e.Type])
+		case <-time.After(wait):
+			return
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// testNodesNeeded returns how many mock nodes a test file's DHT expectations refer to
+func testNodesNeeded(tests []TestData) (n int) {
+	for _, t := range tests {
+		for _, s := range t.DHT {
+			if s.Node > n {
+				n = s.Node
+			}
+		}
+	}
+	return
+}
+
+// startTestNodes starts n mock nodes with the test node's DNA that gossip with the test node,
+// and returns them with a function that stops them and removes their files
+func (h *Holochain) startTestNodes(n int) (nodes []*Holochain, stop func(), err error) {
+	if h.node == nil {
+		err = errors.New("mock nodes need the test node to be activated")
+		return
+	}
+	var root string
+	if root, err = ioutil.TempDir("", "holochain.test"); err != nil {
+		return
+	}
+	stop = func() {
+		for _, m := range nodes {
+			m.dht.gossiping = false
+			m.node.Close()
+		}
+		os.RemoveAll(root)
+	}
+	defer func() {
+		if err != nil {
+			stop()
+			nodes, stop = nil, nil
+		}
+	}()
+	port := h.config.Port
+	for i := 1; i <= n; i++ {
+		var s *Service
+		name := fmt.Sprintf("node%d", i)
+		if s, err = Init(filepath.Join(root, name), AgentName(fmt.Sprintf("test %s <%s@test>", name, name))); err != nil {
+			return
+		}
+		var m *Holochain
+		if m, err = s.Clone(h.path, filepath.Join(s.Path, "test"), false); err != nil {
+			return
+		}
+		if _, err = m.GenChain(); err != nil {
+			return
+		}
+		if port, err = freePort(port + 1); err != nil {
+			return
+		}
+		m.config.Transports = h.config.Transports
+		m.config.Port = port
+		m.config.PeerModeDHTNode = true
+		m.config.PeerModeAuthor = true
+		m.config.BootstrapServer = ""
+		m.config.GossipInterval = ""
+		m.config.Loggers.TestInfo.Enabled = false
+		m.config.Loggers.TestPassed.Enabled = false
+		m.config.Loggers.TestFailed.Enabled = false
+		if err = m.Activate(); err != nil {
+			return
+		}
+		nodes = append(nodes, m)
+		if err = m.dht.AddPeerAddr(h.id, h.node.NetAddr); err != nil {
+			return
+		}
+		if err = m.dht.UpdateGossiper(h.id, 0); err != nil {
+			return
+		}
+		go m.dht.HandlePutReqs()
+		go m.dht.Gossip(TestGossipInterval)
+	}
+	return
+}
+
+// checkTestDHT checks that entries reach the states a test expects in the DHT of the test
+// node or of the mock nodes
+func (h *Holochain) checkTestDHT(testID string, states []TestDHTState, nodes []*Holochain, replace func(string) string) (err error) {
+	for _, s := range states {
+		var hash Hash
+		if hash, err = NewHash(replace(s.Hash)); err != nil {
+			return
+		}
+		status := s.Status
+		if status == "" {
+			status = "live"
+		}
+		on, where, wait := h, "the test node", TestWait
+		if s.Node > 0 {
+			if s.Node > len(nodes) {
+				err = fmt.Errorf("\nTest: %s\n\tno mock node %d", testID, s.Node)
+				return
+			}
+			on, where, wait = nodes[s.Node-1], fmt.Sprintf("mock node %d", s.Node), TestNodeWait
+		}
+		var got string
+		deadline := time.Now().Add(wait)
+		for {
+			got = on.dhtState(hash, s.Type)
+			if got == status || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got != status {
+			comparisonString := fmt.Sprintf("\nTest: %s\n\tExpected DHT state of %v on %s:\t%s\n\tGot:\t\t%s", testID, hash, where, status, got)
+			err = errors.New(comparisonString)
+			h.config.Loggers.TestFailed.pf(fmt.Sprintf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString))
+			return
+		}
+	}
+	return
+}
+
+// dhtState returns the name of the state of an entry in the DHT, which if entryType is set
+// includes the type it is stored as when that is a different type
+func (h *Holochain) dhtState(hash Hash, entryType string) string {
+	_, t, status, err := h.dht.get(hash)
+	if err == ErrHashNotFound {
+		return "missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if entryType != "" && t != entryType {
+		return "stored as " + t
+	}
+	if name, ok := StatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("status %d", status)
+}
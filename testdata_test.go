@@ -0,0 +1,104 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTestFixtures(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	if os.Getenv("DEBUG") != "1" {
+		h.config.Loggers.TestPassed.Enabled = false
+		h.config.Loggers.TestFailed.Enabled = false
+		h.config.Loggers.TestInfo.Enabled = false
+	}
+	testPath := filepath.Join(h.path, "test")
+	cleanupTestDir(testPath)
+	writeTest := func(test string) {
+		os.RemoveAll(testPath)
+		err := writeFile(testPath, "fixtures.json", []byte(`[`+test+`]`))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should run setup and cleanup calls around the test's call", t, func() {
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","Setup":[{"Zome":"myZome","FnName":"addData","Input":"4"}],"Cleanup":[{"Zome":"myZome","FnName":"addData","Input":"6"}]}`)
+		So(h.Test(), ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, 5)
+	})
+
+	Convey("it should fail the test if a setup call fails", t, func() {
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","Setup":[{"Zome":"myZome","FnName":"addData","Input":"3"}]}`)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "setup call 0 (myZome:addData) failed")
+	})
+
+	Convey("it should fail the test if a cleanup call fails", t, func() {
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","Cleanup":[{"Zome":"myZome","FnName":"addData","Input":"3"}]}`)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "cleanup call 0 (myZome:addData) failed")
+	})
+
+	Convey("it should check the signals the call emits", t, func() {
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","Signals":["commit"]}`)
+		So(h.Test(), ShouldBeNil)
+
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","Signals":["commit","put"]}`)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "Expected signals")
+	})
+
+	Convey("it should check the state entries reach in the DHT", t, func() {
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","DHT":[{"Hash":"%h%","Status":"missing"}]}`)
+		So(h.Test(), ShouldBeNil)
+
+		writeTest(`{"Zome":"myZome","FnName":"addData","Input":"2","Output":"%h%","DHT":[{"Hash":"%h%"}]}`)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "Expected DHT state")
+		So(errs[0].Error(), ShouldContainSubstring, "missing")
+	})
+
+	Convey("it should name the states of entries in the DHT", t, func() {
+		hash, err := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		So(err, ShouldBeNil)
+		So(h.dhtState(hash, ""), ShouldEqual, "missing")
+		So(h.dht.put(nil, "myData", hash, h.id, []byte("2"), LIVE), ShouldBeNil)
+		So(h.dhtState(hash, ""), ShouldEqual, "live")
+		So(h.dhtState(hash, "myData"), ShouldEqual, "live")
+		So(h.dhtState(hash, "primes"), ShouldEqual, "stored as myData")
+		So(h.dht.put(nil, "myData", hash, h.id, []byte("2"), DELETED), ShouldBeNil)
+		So(h.dhtState(hash, ""), ShouldEqual, "deleted")
+	})
+}
+
+func TestCollectSignals(t *testing.T) {
+	Convey("it should collect signals beyond the ones expected", t, func() {
+		c := make(chan Event, 3)
+		c <- Event{Type: EventCommit}
+		c <- Event{Type: EventPutReceived}
+		c <- Event{Type: EventCommit}
+		So(collectSignals(c, 1), ShouldResemble, []string{EventNames[EventCommit], EventNames[EventPutReceived], EventNames[EventCommit]})
+	})
+
+	Convey("it should stop listening once the signals are quiet", t, func() {
+		c := make(chan Event, 1)
+		c <- Event{Type: EventCommit}
+		start := time.Now()
+		So(collectSignals(c, 1), ShouldResemble, []string{EventNames[EventCommit]})
+		So(time.Since(start), ShouldBeLessThan, TestWait)
+	})
+
+	Convey("it should count the mock nodes a test file needs", t, func() {
+		So(testNodesNeeded([]TestData{{}}), ShouldEqual, 0)
+		So(testNodesNeeded([]TestData{{DHT: []TestDHTState{{Node: 2}}}, {DHT: []TestDHTState{{}, {Node: 1}}}}), ShouldEqual, 2)
+	})
+}
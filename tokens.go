@@ -0,0 +1,243 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// tokens implements API tokens scoped to particular zome functions and entry types, so that
+// each of the clients served by a node can be limited to what it needs
+
+package holochain
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScopeAll in a token's list of functions or entry types allows them all
+const ScopeAll = "*"
+
+var ErrTokenNotFound error = errors.New("token not found")
+
+var tokensLock sync.Mutex
+
+// APIToken authorizes a client to call the functions, and commit the entry types, of a chain
+// that are in its scope.  Functions are given as zome:function, where either part may be *.
+type APIToken struct {
+	Token      string
+	Chain      string
+	Name       string `json:",omitempty"` // who the token was issued to, as zome code sees the caller
+	Origin     string // if set, the only web origin the token may be used from
+	Functions  []string
+	EntryTypes []string
+	Created    time.Time
+}
+
+// AllowsCall returns true if the function is in the token's scope
+func (t *APIToken) AllowsCall(zome string, function string) bool {
+	for _, f := range t.Functions {
+		x := strings.SplitN(f, ":", 2)
+		if len(x) == 1 {
+			if x[0] == ScopeAll {
+				return true
+			}
+			continue
+		}
+		if (x[0] == ScopeAll || x[0] == zome) && (x[1] == ScopeAll || x[1] == function) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEntry returns true if committing entries of the given type is in the token's scope
+func (t *APIToken) AllowsEntry(entryType string) bool {
+	for _, e := range t.EntryTypes {
+		if e == ScopeAll || e == entryType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrigin returns true if the token may be used from the given web origin.  Requests
+// without an origin, i.e. not from a browser, are allowed.
+func (t *APIToken) AllowsOrigin(origin string) bool {
+	return t.Origin == "" || origin == "" || t.Origin == origin
+}
+
+func newTokenString() (token string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = hex.EncodeToString(b)
+	return
+}
+
+func (s *Service) loadTokens() (tokens []APIToken, err error) {
+	p := filepath.Join(s.Dirs().Config, APITokensFileName)
+	if !fileExists(p) {
+		return
+	}
+	var b []byte
+	b, err = ioutil.ReadFile(p)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &tokens)
+	return
+}
+
+func (s *Service) saveTokens(tokens []APIToken) (err error) {
+	var b []byte
+	b, err = json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(s.Dirs().Config, APITokensFileName), b, 0600)
+	return
+}
+
+// updateTokens loads the tokens, lets fn change them and saves the result
+func (s *Service) updateTokens(fn func(tokens []APIToken) ([]APIToken, error)) (err error) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+	var tokens []APIToken
+	tokens, err = s.loadTokens()
+	if err != nil {
+		return
+	}
+	tokens, err = fn(tokens)
+	if err != nil {
+		return
+	}
+	err = s.saveTokens(tokens)
+	return
+}
+
+// IssueToken creates a new API token for a chain with the given scope
+func (s *Service) IssueToken(chain string, origin string, functions []string, entryTypes []string) (t APIToken, err error) {
+	return s.IssueNamedToken(chain, "", origin, functions, entryTypes)
+}
+
+// IssueNamedToken creates a new API token for a chain with the given scope, naming who it is
+// issued to
+func (s *Service) IssueNamedToken(chain string, name string, origin string, functions []string, entryTypes []string) (t APIToken, err error) {
+	for _, f := range functions {
+		if f != ScopeAll && !strings.Contains(f, ":") {
+			err = fmt.Errorf("function scope must be zome:function, got: %s", f)
+			return
+		}
+	}
+	t = APIToken{Chain: chain, Name: name, Origin: origin, Functions: functions, EntryTypes: entryTypes, Created: time.Now()}
+	t.Token, err = newTokenString()
+	if err != nil {
+		return
+	}
+	err = s.updateTokens(func(tokens []APIToken) ([]APIToken, error) {
+		return append(tokens, t), nil
+	})
+	return
+}
+
+// RotateToken replaces a token with a new one of the same scope
+func (s *Service) RotateToken(token string) (t APIToken, err error) {
+	var fresh string
+	fresh, err = newTokenString()
+	if err != nil {
+		return
+	}
+	err = s.updateTokens(func(tokens []APIToken) ([]APIToken, error) {
+		for i := range tokens {
+			if subtle.ConstantTimeCompare([]byte(tokens[i].Token), []byte(token)) == 1 {
+				tokens[i].Token = fresh
+				tokens[i].Created = time.Now()
+				t = tokens[i]
+				return tokens, nil
+			}
+		}
+		return nil, ErrTokenNotFound
+	})
+	return
+}
+
+// RevokeToken removes a token
+func (s *Service) RevokeToken(token string) (err error) {
+	err = s.updateTokens(func(tokens []APIToken) ([]APIToken, error) {
+		for i := range tokens {
+			if subtle.ConstantTimeCompare([]byte(tokens[i].Token), []byte(token)) == 1 {
+				return append(tokens[:i], tokens[i+1:]...), nil
+			}
+		}
+		return nil, ErrTokenNotFound
+	})
+	return
+}
+
+// Tokens returns the API tokens issued for a chain
+func (s *Service) Tokens(chain string) (tokens []APIToken, err error) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+	var all []APIToken
+	all, err = s.loadTokens()
+	if err != nil {
+		return
+	}
+	tokens = make([]APIToken, 0)
+	for _, t := range all {
+		if t.Chain == chain {
+			tokens = append(tokens, t)
+		}
+	}
+	return
+}
+
+// CheckToken returns the API token for a chain matching token, or nil if there is none
+func (s *Service) CheckToken(chain string, token string) *APIToken {
+	if token == "" {
+		return nil
+	}
+	tokens, err := s.Tokens(chain)
+	if err != nil {
+		return nil
+	}
+	for i := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tokens[i].Token), []byte(token)) == 1 {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// WithScope returns a copy of the holochain whose calls and commits are limited to the scope of
+// an API token
+func (h *Holochain) WithScope(t *APIToken) *Holochain {
+	x := *h
+	x.scope = t
+	x.caller = tokenCaller(t)
+	return &x
+}
+
+// checkCallScope returns an error if the holochain is scoped and the function is out of scope
+func (h *Holochain) checkCallScope(zome string, function string) (err error) {
+	if h.scope != nil && !h.scope.AllowsCall(zome, function) {
+		err = fmt.Errorf("%s:%s is not in the scope of this token", zome, function)
+	}
+	return
+}
+
+// checkEntryScope returns an error if the holochain is scoped and the entry type is out of scope
+func (h *Holochain) checkEntryScope(entryType string) (err error) {
+	if h.scope != nil && !h.scope.AllowsEntry(entryType) {
+		err = fmt.Errorf("committing %s is not in the scope of this token", entryType)
+	}
+	return
+}
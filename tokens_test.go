@@ -0,0 +1,78 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestAPITokens(t *testing.T) {
+	d, s, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should issue tokens for a chain", t, func() {
+		tokens, err := s.Tokens("test")
+		So(err, ShouldBeNil)
+		So(len(tokens), ShouldEqual, 0)
+
+		_, err = s.IssueToken("test", "", []string{"getDNA"}, nil)
+		So(err.Error(), ShouldEqual, "function scope must be zome:function, got: getDNA")
+
+		tk, err := s.IssueToken("test", "http://kiosk", []string{"myZome:getDNA"}, []string{"myData"})
+		So(err, ShouldBeNil)
+		So(len(tk.Token), ShouldEqual, 64)
+		tokens, err = s.Tokens("test")
+		So(err, ShouldBeNil)
+		So(len(tokens), ShouldEqual, 1)
+		tokens, _ = s.Tokens("other")
+		So(len(tokens), ShouldEqual, 0)
+
+		So(s.CheckToken("test", tk.Token).Origin, ShouldEqual, "http://kiosk")
+		So(s.CheckToken("other", tk.Token), ShouldBeNil)
+		So(s.CheckToken("test", ""), ShouldBeNil)
+	})
+
+	Convey("it should rotate and revoke tokens", t, func() {
+		tk, _ := s.IssueToken("test", "", []string{"*"}, []string{"*"})
+		rotated, err := s.RotateToken(tk.Token)
+		So(err, ShouldBeNil)
+		So(rotated.Token, ShouldNotEqual, tk.Token)
+		So(rotated.Functions, ShouldResemble, tk.Functions)
+		So(s.CheckToken("test", tk.Token), ShouldBeNil)
+		So(s.CheckToken("test", rotated.Token), ShouldNotBeNil)
+
+		So(s.RevokeToken(rotated.Token), ShouldBeNil)
+		So(s.CheckToken("test", rotated.Token), ShouldBeNil)
+		So(s.RevokeToken(rotated.Token), ShouldEqual, ErrTokenNotFound)
+		_, err = s.RotateToken(rotated.Token)
+		So(err, ShouldEqual, ErrTokenNotFound)
+	})
+
+	Convey("it should check scope", t, func() {
+		tk := APIToken{Functions: []string{"myZome:getDNA", "jsZome:*"}, EntryTypes: []string{"myData"}, Origin: "http://kiosk"}
+		So(tk.AllowsCall("myZome", "getDNA"), ShouldBeTrue)
+		So(tk.AllowsCall("myZome", "addData"), ShouldBeFalse)
+		So(tk.AllowsCall("jsZome", "addData"), ShouldBeTrue)
+		So(tk.AllowsEntry("myData"), ShouldBeTrue)
+		So(tk.AllowsEntry("primes"), ShouldBeFalse)
+		So(tk.AllowsOrigin("http://kiosk"), ShouldBeTrue)
+		So(tk.AllowsOrigin("http://evil"), ShouldBeFalse)
+		So(tk.AllowsOrigin(""), ShouldBeTrue)
+		tk.Functions = []string{"*"}
+		So(tk.AllowsCall("hc", "agentInfo"), ShouldBeTrue)
+	})
+
+	Convey("a scoped holochain should only call and commit what its token allows", t, func() {
+		scoped := h.WithScope(&APIToken{Functions: []string{"myZome:getDNA", "myZome:addData"}, EntryTypes: []string{"primes"}})
+		_, err := scoped.Call("myZome", "getDNA", "")
+		So(err, ShouldBeNil)
+		_, err = scoped.Call("jsZome", "getDNA", "")
+		So(err.Error(), ShouldEqual, "jsZome:getDNA is not in the scope of this token")
+		_, err = scoped.Call("myZome", "addData", "2")
+		So(err.Error(), ShouldEndWith, "committing myData is not in the scope of this token")
+		_, err = scoped.Commit("myData", "2")
+		So(err.Error(), ShouldEqual, "committing myData is not in the scope of this token")
+
+		_, err = h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+	})
+}
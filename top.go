@@ -0,0 +1,156 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// top implements what's needed to watch the resource usage of served chains from outside of
+// the process serving them: recording the address each chain is served on, sampling the
+// runtime's figures into the metrics, and fetching a served chain's metrics.
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/tidwall/buntdb"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	ServingFileName  = "serving"   // records where the chain is being served, while it is
+	ServeLogFileName = "serve.log" // where a chain installed as a system service logs to
+)
+
+var ErrNotServing error = errors.New("chain isn't being served")
+
+// nucleusBusy tracks the time during which any zome code is running, so that validation run
+// from within a call, or calls running at the same time, aren't counted more than once and
+// the busy time can never exceed the time that has passed
+type nucleusBusy struct {
+	lk      sync.Mutex
+	running int
+	since   time.Time
+}
+
+func newNucleusBusy() *nucleusBusy {
+	return &nucleusBusy{}
+}
+
+// startNucleus marks zome code as running until the returned function is called
+func (h *Holochain) startNucleus() (stop func()) {
+	b := h.nucleusBusy
+	b.lk.Lock()
+	if b.running == 0 {
+		b.since = time.Now()
+	}
+	b.running++
+	b.lk.Unlock()
+	return func() {
+		b.lk.Lock()
+		defer b.lk.Unlock()
+		if b.running--; b.running == 0 {
+			h.metrics.Inc("nucleus.ns", int64(time.Since(b.since)))
+		}
+	}
+}
+
+// SampleRuntime records the current goroutine count and heap size, which cover the whole
+// process serving the chain, and the size of the chain's DHT store
+func (h *Holochain) SampleRuntime() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	h.metrics.Set("runtime.goroutines", int64(runtime.NumGoroutine()))
+	h.metrics.Set("runtime.heap", int64(mem.HeapAlloc))
+	if h.dht != nil && h.dht.db != nil {
+		h.dht.db.View(func(tx *buntdb.Tx) error {
+			if size, err := getIntVal("_size", tx); err == nil {
+				h.metrics.Set("dht.bytes", int64(size))
+			}
+			return nil
+		})
+	}
+}
+
+// Serving is where a chain is being served
+type Serving struct {
+	URL    string // the base url of the chain's http api
+	Socket string `json:",omitempty"` // the unix socket the url is served over, if it is
+}
+
+// SetServing records where the chain is being served
+func (h *Holochain) SetServing(s Serving) (err error) {
+	var b []byte
+	if b, err = json.Marshal(s); err != nil {
+		return
+	}
+	err = writeFile(h.path, ServingFileName, b)
+	return
+}
+
+// ClearServing removes the record of where the chain is being served
+func (h *Holochain) ClearServing() (err error) {
+	err = os.Remove(filepath.Join(h.path, ServingFileName))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// GetServing returns where the chain was last recorded as being served
+func (h *Holochain) GetServing() (s Serving, err error) {
+	s, err = readServing(h.path)
+	return
+}
+
+// readServing returns where the chain in a directory was last recorded as being served
+func readServing(path string) (s Serving, err error) {
+	var b []byte
+	b, err = readFile(path, ServingFileName)
+	if os.IsNotExist(err) {
+		err = ErrNotServing
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &s)
+	return
+}
+
+// Client returns an http client that reaches where the chain is being served, dialing its
+// unix socket if it is served over one
+func (s Serving) Client(timeout time.Duration) (client *http.Client) {
+	client = &http.Client{Timeout: timeout}
+	if s.Socket != "" {
+		client.Transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", s.Socket) },
+		}
+	}
+	return
+}
+
+// FetchMetrics gets the metrics of a chain from where it is being served, authorizing with
+// the given token
+func (s Serving) FetchMetrics(token string) (metrics map[string]int64, err error) {
+	client := s.Client(5 * time.Second)
+	var req *http.Request
+	if req, err = http.NewRequest("GET", s.URL+"/_metrics", nil); err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("fetching metrics: " + resp.Status)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	return
+}
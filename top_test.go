@@ -0,0 +1,108 @@
+package holochain
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResourceMetrics(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should time running zome code", t, func() {
+		before := h.metrics.Get("nucleus.ns")
+		_, err := h.Call("myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		So(h.metrics.Get("nucleus.ns"), ShouldBeGreaterThan, before)
+	})
+
+	Convey("it shouldn't count zome code running inside other zome code twice", t, func() {
+		before := h.metrics.Get("nucleus.ns")
+		start := time.Now()
+		stop := h.startNucleus()
+		h.startNucleus()()
+		stopInner := h.startNucleus()
+		time.Sleep(10 * time.Millisecond)
+		stopInner()
+		So(h.metrics.Get("nucleus.ns"), ShouldEqual, before)
+		stop()
+		So(h.metrics.Get("nucleus.ns")-before, ShouldBeLessThanOrEqualTo, int64(time.Since(start)))
+		So(h.metrics.Get("nucleus.ns")-before, ShouldBeGreaterThanOrEqualTo, int64(10*time.Millisecond))
+	})
+
+	Convey("it should sample the runtime and DHT store size", t, func() {
+		h.SampleRuntime()
+		So(h.metrics.Get("runtime.goroutines"), ShouldBeGreaterThan, 0)
+		So(h.metrics.Get("runtime.heap"), ShouldBeGreaterThan, 0)
+		So(h.metrics.Get("dht.bytes"), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("it should count the bytes streams carry", t, func() {
+		m := NewMetrics()
+		node := Node{metrics: m}
+		var buf closingBuffer
+		s := node.counted(&buf)
+		s.Write([]byte("hello"))
+		b := make([]byte, 3)
+		s.Read(b)
+		So(m.Get("net.out"), ShouldEqual, 5)
+		So(m.Get("net.in"), ShouldEqual, 3)
+
+		node.metrics = nil
+		So(node.counted(&buf), ShouldEqual, &buf)
+	})
+}
+
+type closingBuffer struct {
+	bytes.Buffer
+}
+
+func (b *closingBuffer) Close() error { return nil }
+
+func TestServing(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should record where the chain is served", t, func() {
+		_, err := h.GetServing()
+		So(err, ShouldEqual, ErrNotServing)
+		So(h.SetServing(Serving{URL: "http://localhost:3141"}), ShouldBeNil)
+		s, err := h.GetServing()
+		So(err, ShouldBeNil)
+		So(s.URL, ShouldEqual, "http://localhost:3141")
+		So(h.ClearServing(), ShouldBeNil)
+		_, err = h.GetServing()
+		So(err, ShouldEqual, ErrNotServing)
+		So(h.ClearServing(), ShouldBeNil)
+	})
+
+	Convey("it should fetch the metrics of a served chain", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/app/_metrics" {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer admin" {
+				http.Error(w, "api token required", 401)
+				return
+			}
+			fmt.Fprint(w, `{"calls":3,"net.in":100}`)
+		}))
+		defer ts.Close()
+		m, err := Serving{URL: ts.URL + "/app"}.FetchMetrics("admin")
+		So(err, ShouldBeNil)
+		So(m["calls"], ShouldEqual, 3)
+		So(m["net.in"], ShouldEqual, 100)
+
+		_, err = Serving{URL: ts.URL}.FetchMetrics("admin")
+		So(err.Error(), ShouldEqual, "fetching metrics: 404 Not Found")
+
+		_, err = Serving{URL: ts.URL + "/app"}.FetchMetrics("")
+		So(err.Error(), ShouldEqual, "fetching metrics: 401 Unauthorized")
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// topics implements selective gossip.  A DNA can group its entry types into topics, and each
+// node can choose to hold only the entries of some of them, so that e.g. archive nodes take
+// everything while phones only take messages.  Nodes say which topics they hold when asking
+// for gossip and are only sent the puts of those topics' entries, the others being sent as
+// empty places so that gossip indexes still line up.  Nodes also advertise their topics in
+// their handshake, so that entries are only handed off or asked to be held by peers that hold
+// their topics, and a node refuses puts of entries outside its topics.  Entries of types in no topic are only
+// held by nodes that hold everything, while system entries, like agents' keys, are held by
+// all nodes as they are needed to validate the rest.  Each topic can call for its own
+// resilience, as fewer nodes may hold some topics than others.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownTopic error = errors.New("unknown topic")
+var ErrTopicNotHeld error = errors.New("entry is outside the topics this node holds")
+
+// Topic is a group of entry types that nodes can choose to hold
+type Topic struct {
+	EntryTypes []string // the entry types in the topic, which may be qualified by their zomes
+	Resilience int      `json:",omitempty" toml:",omitempty"` // how many nodes should hold each of the topic's entries, the DNA's Resilience if not set
+}
+
+// checkTopics checks the DNA's topics and the topics the node is configured to hold, noting
+// the topic of each entry type in one
+func (h *Holochain) checkTopics() (err error) {
+	var names []string
+	for name := range h.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	topics := make(map[string]string)
+	for _, name := range names {
+		t := h.Topics[name]
+		if t == nil || len(t.EntryTypes) == 0 {
+			return fmt.Errorf("topic %s has no entry types", name)
+		}
+		if t.Resilience < 0 {
+			return fmt.Errorf("topic %s resilience must not be negative", name)
+		}
+		for _, ref := range t.EntryTypes {
+			var entryType string
+			if entryType, err = h.EntryTypeName(ref); err != nil {
+				return fmt.Errorf("topic %s: %v", name, err)
+			}
+			if strings.HasPrefix(entryType, "%") {
+				return fmt.Errorf("topic %s: system entry type %s is held by all nodes", name, entryType)
+			}
+			if _, _, err = h.GetEntryDef(entryType); err != nil {
+				return fmt.Errorf("topic %s: %v", name, err)
+			}
+			if other, ok := topics[entryType]; ok {
+				return fmt.Errorf("entry type %s is in both topic %s and topic %s", entryType, other, name)
+			}
+			topics[entryType] = name
+		}
+	}
+	for _, name := range h.Config().Topics {
+		if _, ok := h.Topics[name]; !ok {
+			return fmt.Errorf("%v: %s", ErrUnknownTopic, name)
+		}
+	}
+	h.topics = topics
+	return
+}
+
+// TopicOf returns the topic an entry type is in, or an empty string if it isn't in one
+func (h *Holochain) TopicOf(entryType string) string {
+	return h.topics[entryType]
+}
+
+// holdsTopics returns true if a node holding the given topics, all if there are none, holds
+// entries of a type
+func (h *Holochain) holdsTopics(topics []string, entryType string) bool {
+	if len(topics) == 0 || strings.HasPrefix(entryType, "%") {
+		return true
+	}
+	topic := h.topics[entryType]
+	for _, t := range topics {
+		if t == topic && topic != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// holds returns true if this node holds entries of a type
+func (h *Holochain) holds(entryType string) bool {
+	return h.holdsTopics(h.Config().Topics, entryType)
+}
+
+// peerHolds returns true if a peer holds entries of a type, going by the topics it advertised
+// in its handshake
+func (h *Holochain) peerHolds(id peer.ID, entryType string) bool {
+	if id == h.node.HashAddr {
+		return h.holds(entryType)
+	}
+	p, err := h.node.PeerProtocol(id)
+	if err != nil {
+		return false
+	}
+	return h.holdsTopics(p.Topics, entryType)
+}
+
+// resilienceOf returns how many nodes should hold each entry of a type
+func (h *Holochain) resilienceOf(entryType string) int {
+	if t, ok := h.Topics[h.topics[entryType]]; ok && t.Resilience > 0 {
+		return t.Resilience
+	}
+	return h.resilience()
+}
+
+// putEntryType returns the type of the entry a put is of, or for a putmeta the type of the
+// entry the meta is on, if it is known
+func (dht *DHT) putEntryType(m *Message) (entryType string, ok bool) {
+	switch t := m.Body.(type) {
+	case PutReq:
+		return dht.entryType(t.H)
+	case MetaReq:
+		return dht.entryType(t.O)
+	}
+	return
+}
+
+// filterTopics replaces the puts of entries outside the given topics with empty places
+func (dht *DHT) filterTopics(puts []Put, topics []string) []Put {
+	if len(topics) == 0 {
+		return puts
+	}
+	for i := range puts {
+		if puts[i].M.Body == nil {
+			continue
+		}
+		if entryType, ok := dht.putEntryType(&puts[i].M); ok && !dht.h.holdsTopics(topics, entryType) {
+			puts[i] = Put{}
+			dht.h.metrics.Inc("gossip.filtered", 1)
+		}
+	}
+	return puts
+}
@@ -0,0 +1,118 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestTopics(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	h.Topics = map[string]*Topic{
+		"data":   {EntryTypes: []string{"myData", "myZome:primes"}, Resilience: 5},
+		"people": {EntryTypes: []string{"profile"}},
+	}
+
+	Convey("it should note the topic of each entry type", t, func() {
+		So(h.checkTopics(), ShouldBeNil)
+		So(h.TopicOf("myData"), ShouldEqual, "data")
+		So(h.TopicOf("primes"), ShouldEqual, "data")
+		So(h.TopicOf("profile"), ShouldEqual, "people")
+		So(h.TopicOf("myOdds"), ShouldEqual, "")
+	})
+
+	Convey("topics should set the resilience of their entries", t, func() {
+		So(h.resilienceOf("myData"), ShouldEqual, 5)
+		So(h.resilienceOf("profile"), ShouldEqual, DefaultResilience)
+		So(h.resilienceOf("myOdds"), ShouldEqual, DefaultResilience)
+	})
+
+	Convey("it should reject bad topics", t, func() {
+		h.Topics["dup"] = &Topic{EntryTypes: []string{"myData"}}
+		So(h.checkTopics(), ShouldNotBeNil)
+		h.Topics["dup"] = &Topic{EntryTypes: []string{"nosuchtype"}}
+		So(h.checkTopics(), ShouldNotBeNil)
+		h.Topics["dup"] = &Topic{EntryTypes: []string{"jsZome:myData"}}
+		So(h.checkTopics(), ShouldNotBeNil)
+		h.Topics["dup"] = &Topic{EntryTypes: []string{AgentEntryType}}
+		So(h.checkTopics(), ShouldNotBeNil)
+		h.Topics["dup"] = &Topic{}
+		So(h.checkTopics(), ShouldNotBeNil)
+		delete(h.Topics, "dup")
+
+		h.config.Topics = []string{"nosuchtopic"}
+		err := h.checkTopics()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ErrUnknownTopic.Error())
+		h.config.Topics = nil
+		So(h.checkTopics(), ShouldBeNil)
+	})
+
+	Convey("nodes should hold everything unless they choose topics", t, func() {
+		So(h.holds("profile"), ShouldBeTrue)
+		So(h.holds("myOdds"), ShouldBeTrue)
+		h.config.Topics = []string{"data"}
+		So(h.holds("myData"), ShouldBeTrue)
+		So(h.holds("profile"), ShouldBeFalse)
+		So(h.holds("myOdds"), ShouldBeFalse)
+		So(h.holds(AgentEntryType), ShouldBeTrue)
+		h.config.Topics = nil
+	})
+
+	Convey("nodes should advertise their topics and only be offered entries of them", t, func() {
+		h.config.Topics = []string{"data"}
+		h.node.topics = h.config.Topics
+		So(h.node.handshake().Topics, ShouldResemble, []string{"data"})
+		p, err := negotiate(h.node.handshake(), Handshake{Version: 1, MinVersion: 1, Topics: []string{"people"}})
+		So(err, ShouldBeNil)
+		So(p.Topics, ShouldResemble, []string{"people"})
+
+		phone, _ := makePeer("phone")
+		h.node.protocols.set(phone, p)
+		So(h.peerHolds(phone, "profile"), ShouldBeTrue)
+		So(h.peerHolds(phone, "myData"), ShouldBeFalse)
+		So(h.peerHolds(phone, AgentEntryType), ShouldBeTrue)
+
+		old, _ := makePeer("old")
+		h.node.protocols.set(old, &PeerProtocol{})
+		So(h.peerHolds(old, "myData"), ShouldBeTrue)
+
+		So(h.peerHolds(h.node.HashAddr, "profile"), ShouldBeFalse)
+		h.config.Topics = nil
+		h.node.topics = nil
+	})
+
+	Convey("gossip should only send the puts of the topics asked for", t, func() {
+		dht := h.dht
+		var data, profile Hash
+		data.Sum(h.hashSpec, []byte("data"))
+		profile.Sum(h.hashSpec, []byte("profile"))
+		So(dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: data}), "myData", data, h.id, []byte("2"), LIVE), ShouldBeNil)
+		So(dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: profile}), "profile", profile, h.id, []byte("{}"), LIVE), ShouldBeNil)
+
+		all, err := dht.GetPuts(0)
+		So(err, ShouldBeNil)
+		So(len(dht.filterTopics(all, nil)), ShouldEqual, len(all))
+
+		puts, err := dht.GetPuts(0)
+		So(err, ShouldBeNil)
+		puts = dht.filterTopics(puts, []string{"data"})
+		So(len(puts), ShouldEqual, len(all))
+		var sent []string
+		empty := 0
+		for _, p := range puts {
+			if p.M.Body == nil {
+				empty++
+				continue
+			}
+			if r, ok := p.M.Body.(PutReq); ok {
+				sent = append(sent, r.H.String())
+			}
+		}
+		So(empty, ShouldEqual, 1)
+		So(sent, ShouldContain, data.String())
+		So(sent, ShouldNotContain, profile.String())
+		So(h.Metrics().Get("gossip.filtered"), ShouldEqual, 1)
+	})
+}
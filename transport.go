@@ -0,0 +1,230 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// transport abstracts the network mechanism used to carry messages between holochain nodes
+// and implements the default libp2p (TCP) transport
+
+package holochain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	net "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	rhost "github.com/libp2p/go-libp2p/p2p/host/routed"
+	ma "github.com/multiformats/go-multiaddr"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	TCPTransportName       = "tcp"
+	WebSocketTransportName = "websocket"
+	QUICTransportName      = "quic"
+)
+
+// Stream is a bi-directional connection to another node over which a message and its
+// response are sent
+type Stream interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// StreamHandler is called for each stream opened by a remote node
+type StreamHandler func(s Stream)
+
+// Transport abstracts the networking used between nodes
+type Transport interface {
+	Name() string
+	ListenAddr() ma.Multiaddr
+	SetStreamHandler(proto protocol.ID, handler StreamHandler)
+	NewStream(ctx context.Context, to peer.ID, proto protocol.ID) (Stream, error)
+	AddPeerAddr(id peer.ID, addr ma.Multiaddr)
+	Close() error
+}
+
+// TransportFactory creates a transport listening on the given address
+type TransportFactory func(listenAddr ma.Multiaddr, id peer.ID, priv ic.PrivKey) (Transport, error)
+
+type transportDef struct {
+	factory    TransportFactory
+	addrFormat string
+}
+
+var transports = make(map[string]transportDef)
+
+// RegisterTransport sets up a Transport to be available for use by nodes.  addrFormat is
+// a multiaddr format string which will be passed the port number to listen on.
+func RegisterTransport(name string, addrFormat string, factory TransportFactory) {
+	if factory == nil {
+		panic("Transport factory for type " + name + " does not exist.")
+	}
+	_, registered := transports[name]
+	if registered {
+		panic("Transport factory for type " + name + " already registered.")
+	}
+	transports[name] = transportDef{factory: factory, addrFormat: addrFormat}
+}
+
+// AvailableTransports returns the names of the transports registered in this build
+func AvailableTransports() (names []string) {
+	for k := range transports {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return
+}
+
+// TransportForAddr returns the transport that reaches a node at the given address, going by
+// the address's outermost protocol
+func TransportForAddr(addr ma.Multiaddr) (name string, err error) {
+	protos := addr.Protocols()
+	if len(protos) == 0 {
+		err = fmt.Errorf("no protocols in address: %v", addr)
+		return
+	}
+	switch protos[len(protos)-1].Name {
+	case "tcp":
+		name = TCPTransportName
+	case "ws":
+		name = WebSocketTransportName
+	case "quic":
+		name = QUICTransportName
+	default:
+		err = fmt.Errorf("no transport for address: %v", addr)
+		return
+	}
+	if _, ok := transports[name]; !ok {
+		err = fmt.Errorf("transport %s needed for %v not available in this build", name, addr)
+	}
+	return
+}
+
+// TransportListenAddr returns the address the named transport would listen on for a port
+func TransportListenAddr(name string, port int) (addr string, err error) {
+	t, ok := transports[name]
+	if !ok {
+		err = fmt.Errorf("unknown transport: %s, must be one of: %s", name, strings.Join(AvailableTransports(), ", "))
+		return
+	}
+	addr = fmt.Sprintf(t.addrFormat, port)
+	return
+}
+
+// chooseTransport returns the first transport in the chain's preference list that is
+// available in this build
+func (h *Holochain) chooseTransport() (name string, err error) {
+	prefs := h.config.Transports
+	if len(prefs) == 0 {
+		prefs = []string{TCPTransportName}
+	}
+	for _, name = range prefs {
+		if _, ok := transports[name]; ok {
+			return
+		}
+		Infof("transport %s not available in this build, skipping", name)
+	}
+	err = fmt.Errorf("none of the preferred transports (%s) are available", strings.Join(prefs, ", "))
+	return
+}
+
+func init() {
+	RegisterTransport(TCPTransportName, "/ip4/127.0.0.1/tcp/%d", NewLibP2PTransport)
+}
+
+// LibP2PTransport implements Transport with a libp2p routed host over TCP
+type LibP2PTransport struct {
+	addr ma.Multiaddr
+	Host *rhost.RoutedHost
+}
+
+type HolochainRouter struct {
+	dummy int
+}
+
+func (r *HolochainRouter) FindPeer(context.Context, peer.ID) (peer pstore.PeerInfo, err error) {
+	err = errors.New("routing not implemented")
+	return
+}
+
+// NewLibP2PTransport creates a libp2p basichost based transport
+func NewLibP2PTransport(listenAddr ma.Multiaddr, id peer.ID, priv ic.PrivKey) (t Transport, err error) {
+	ps := pstore.NewPeerstore()
+	ps.AddPrivKey(id, priv)
+	ps.AddPubKey(id, priv.GetPublic())
+
+	ctx := context.Background()
+
+	// create a new swarm to be used by the service host
+	netw, err := swarm.NewNetwork(ctx, []ma.Multiaddr{listenAddr}, id, ps, nil)
+	if err != nil {
+		return
+	}
+
+	var bh *bhost.BasicHost
+	bh, err = bhost.New(netw), nil
+	if err != nil {
+		return
+	}
+	hr := HolochainRouter{}
+	t = &LibP2PTransport{addr: listenAddr, Host: rhost.Wrap(bh, &hr)}
+	return
+}
+
+// Name returns the transport name
+func (t *LibP2PTransport) Name() string { return TCPTransportName }
+
+// ListenAddr returns the address the transport is listening on
+func (t *LibP2PTransport) ListenAddr() ma.Multiaddr { return t.addr }
+
+// SetStreamHandler registers a handler for streams opened for a protocol
+func (t *LibP2PTransport) SetStreamHandler(proto protocol.ID, handler StreamHandler) {
+	t.Host.SetStreamHandler(proto, func(s net.Stream) {
+		handler(&authStream{Stream: s, remote: s.Conn().RemotePeer()})
+	})
+}
+
+// NewStream opens a stream to a peer for a protocol, whose connection libp2p has
+// authenticated against the peer's key
+func (t *LibP2PTransport) NewStream(ctx context.Context, to peer.ID, proto protocol.ID) (s Stream, err error) {
+	var ns net.Stream
+	if ns, err = t.Host.NewStream(ctx, to, proto); err != nil {
+		return
+	}
+	s = &authStream{Stream: ns, remote: ns.Conn().RemotePeer()}
+	return
+}
+
+// AddPeerAddr records the address of a peer
+func (t *LibP2PTransport) AddPeerAddr(id peer.ID, addr ma.Multiaddr) {
+	t.Host.Peerstore().AddAddr(id, addr, pstore.PermanentAddrTTL)
+}
+
+// Close shuts down the transport
+func (t *LibP2PTransport) Close() error {
+	return t.Host.Close()
+}
+
+// multiaddrHostPort extracts a host:port string from ip4/ip6 addresses with a tcp or udp port
+func multiaddrHostPort(addr ma.Multiaddr) (hostPort string, err error) {
+	parts := strings.Split(addr.String(), "/")
+	if len(parts) < 5 || (parts[1] != "ip4" && parts[1] != "ip6") || (parts[3] != "tcp" && parts[3] != "udp") {
+		err = fmt.Errorf("unable to get host and port from address: %s", addr.String())
+		return
+	}
+	host := parts[2]
+	if parts[1] == "ip6" {
+		host = "[" + host + "]"
+	}
+	hostPort = host + ":" + parts[4]
+	return
+}
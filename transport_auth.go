@@ -0,0 +1,189 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// transport_auth implements authenticating the nodes at each end of a stream for transports
+// that don't do so themselves.  Before any message is sent each node signs the other's
+// random nonce with its key, along with a value binding the signature to the connection the
+// transport made, e.g. the listener's TLS certificate, so that a signature can't be relayed
+// onto another connection.  Nodes then refuse messages whose From isn't the authenticated
+// node at the other end of the stream.
+
+package holochain
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io"
+	"time"
+)
+
+const (
+	StreamAuthTimeout = 10 * time.Second // how long a node has to complete the handshake
+
+	streamAuthTag      = "holochain stream auth:\n"
+	streamNonceSize    = 32
+	maxStreamAuthFrame = 4096
+)
+
+var ErrStreamNotAuthenticated error = errors.New("stream's remote node not authenticated")
+var ErrMessageSourceMismatch error = errors.New("message source doesn't match the node that sent it")
+var ErrStreamAuthFailed error = errors.New("stream authentication failed")
+
+// AuthenticatedStream is a Stream whose remote node has proven that it holds the key of
+// its peer id
+type AuthenticatedStream interface {
+	Stream
+	RemotePeer() peer.ID
+}
+
+// authStream is a stream with the peer id authenticated for its remote node
+type authStream struct {
+	Stream
+	remote peer.ID
+}
+
+// RemotePeer returns the peer id of the node at the other end of the stream
+func (s *authStream) RemotePeer() peer.ID { return s.remote }
+
+func writeAuthFrame(w io.Writer, b []byte) (err error) {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	_, err = w.Write(append(l[:], b...))
+	return
+}
+
+func readAuthFrame(r io.Reader) (b []byte, err error) {
+	var l [4]byte
+	if _, err = io.ReadFull(r, l[:]); err != nil {
+		return
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n > maxStreamAuthFrame {
+		err = fmt.Errorf("%v: frame of %d bytes too large", ErrStreamAuthFailed, n)
+		return
+	}
+	b = make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return
+}
+
+// streamAuthPayload builds what a node signs to authenticate itself on a stream
+func streamAuthPayload(role string, binding []byte, id peer.ID, nonces ...[]byte) []byte {
+	b := []byte(streamAuthTag + role + "\n")
+	b = append(b, binding...)
+	b = append(b, []byte(id)...)
+	for _, n := range nonces {
+		b = append(b, n...)
+	}
+	return b
+}
+
+func streamNonce() (nonce []byte, err error) {
+	nonce = make([]byte, streamNonceSize)
+	_, err = rand.Read(nonce)
+	return
+}
+
+// readAuthProof reads a node's public key and signature, checking the signature and
+// returning the node's peer id
+func readAuthProof(r io.Reader, payload []byte) (id peer.ID, err error) {
+	var pk, sig []byte
+	if pk, err = readAuthFrame(r); err != nil {
+		return
+	}
+	if sig, err = readAuthFrame(r); err != nil {
+		return
+	}
+	var pub ic.PubKey
+	if pub, err = ic.UnmarshalPublicKey(pk); err != nil {
+		return
+	}
+	if id, err = peer.IDFromPublicKey(pub); err != nil {
+		return
+	}
+	ok, err := pub.Verify(payload, sig)
+	if err == nil && !ok {
+		err = ErrStreamAuthFailed
+	}
+	return
+}
+
+// writeAuthProof writes the node's public key and its signature of the payload
+func writeAuthProof(w io.Writer, priv ic.PrivKey, payload []byte) (err error) {
+	var pk, sig []byte
+	if pk, err = ic.MarshalPublicKey(priv.GetPublic()); err != nil {
+		return
+	}
+	if sig, err = priv.Sign(payload); err != nil {
+		return
+	}
+	if err = writeAuthFrame(w, pk); err != nil {
+		return
+	}
+	err = writeAuthFrame(w, sig)
+	return
+}
+
+// acceptAuth authenticates the node that opened a stream, and this node to it
+func acceptAuth(s Stream, id peer.ID, priv ic.PrivKey, binding []byte) (as AuthenticatedStream, err error) {
+	var mine, theirs []byte
+	if mine, err = streamNonce(); err != nil {
+		return
+	}
+	if err = writeAuthFrame(s, mine); err != nil {
+		return
+	}
+	if theirs, err = readAuthFrame(s); err != nil {
+		return
+	}
+	if len(theirs) != streamNonceSize {
+		err = ErrStreamAuthFailed
+		return
+	}
+	var remote peer.ID
+	if remote, err = readAuthProof(s, streamAuthPayload("dial", binding, id, mine, theirs)); err != nil {
+		return
+	}
+	if err = writeAuthProof(s, priv, streamAuthPayload("listen", binding, remote, theirs, mine)); err != nil {
+		return
+	}
+	as = &authStream{Stream: s, remote: remote}
+	return
+}
+
+// dialAuth authenticates this node to the node a stream was opened to, and that node to
+// this one, checking that it is the node intended
+func dialAuth(s Stream, to peer.ID, id peer.ID, priv ic.PrivKey, binding []byte) (as AuthenticatedStream, err error) {
+	var mine, theirs []byte
+	if theirs, err = readAuthFrame(s); err != nil {
+		return
+	}
+	if len(theirs) != streamNonceSize {
+		err = ErrStreamAuthFailed
+		return
+	}
+	if mine, err = streamNonce(); err != nil {
+		return
+	}
+	if err = writeAuthFrame(s, mine); err != nil {
+		return
+	}
+	if err = writeAuthProof(s, priv, streamAuthPayload("dial", binding, to, theirs, mine)); err != nil {
+		return
+	}
+	var remote peer.ID
+	if remote, err = readAuthProof(s, streamAuthPayload("listen", binding, id, mine, theirs)); err != nil {
+		return
+	}
+	if remote != to {
+		err = fmt.Errorf("%v: expected %v but reached %v", ErrStreamAuthFailed, to.Pretty(), remote.Pretty())
+		return
+	}
+	as = &authStream{Stream: s, remote: remote}
+	return
+}
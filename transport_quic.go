@@ -0,0 +1,282 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// +build quic
+
+// transport_quic implements a Transport over QUIC (UDP) for environments where TCP
+// connections are unreliable.  It depends on quic-go so is only included in builds
+// made with `-tags quic`
+
+package holochain
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	quic "github.com/lucas-clemente/quic-go"
+	ma "github.com/multiformats/go-multiaddr"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterTransport(QUICTransportName, "/ip4/127.0.0.1/udp/%d/quic", NewQUICTransport)
+}
+
+// QUICTransport implements Transport by opening a QUIC session per stream, the first line
+// written on the stream names the protocol.  The listener's TLS certificate carries its
+// node key's signature, which dialers check against the peer they meant to reach, and each
+// stream is then authenticated against both nodes' keys bound to that certificate.
+type QUICTransport struct {
+	addr     ma.Multiaddr
+	id       peer.ID
+	priv     ic.PrivKey
+	cert     []byte // the listener's certificate
+	listener quic.Listener
+	lk       sync.RWMutex
+	handlers map[protocol.ID]StreamHandler
+	peers    map[peer.ID]string
+}
+
+// quicStream adapts a QUIC stream to the Stream interface, reading through a buffer
+// as the protocol header has been read from it
+type quicStream struct {
+	r    *bufio.Reader
+	s    quic.Stream
+	sess quic.Session
+}
+
+func (s *quicStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *quicStream) Write(p []byte) (int, error) { return s.s.Write(p) }
+func (s *quicStream) Close() error {
+	s.s.Close()
+	return s.sess.Close(nil)
+}
+
+// quicKeyExtension is the certificate extension holding the node key's signature of the
+// certificate's key
+var quicKeyExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 7, 1}
+
+const quicKeyTag = "holochain quic certificate:\n"
+
+// quicNodeKey is the value of the quicKeyExtension
+type quicNodeKey struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// makeQUICTLSConfig creates a self signed certificate for the QUIC listener, whose key is
+// signed by the node's key
+func makeQUICTLSConfig(priv ic.PrivKey) (config *tls.Config, der []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return
+	}
+	var nk quicNodeKey
+	if nk.PubKey, err = ic.MarshalPublicKey(priv.GetPublic()); err != nil {
+		return
+	}
+	if nk.Signature, err = priv.Sign(append([]byte(quicKeyTag), spki...)); err != nil {
+		return
+	}
+	ext, err := asn1.Marshal(nk)
+	if err != nil {
+		return
+	}
+	template := x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(10 * 365 * 24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: quicKeyExtension, Value: ext}},
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key); err != nil {
+		return
+	}
+	config = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return
+}
+
+// verifyQUICCert checks that a listener's certificate key is signed by the key of the peer
+func verifyQUICCert(der []byte, to peer.ID) (err error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return
+	}
+	for _, e := range cert.Extensions {
+		if !e.Id.Equal(quicKeyExtension) {
+			continue
+		}
+		var nk quicNodeKey
+		if _, err = asn1.Unmarshal(e.Value, &nk); err != nil {
+			return
+		}
+		var pub ic.PubKey
+		if pub, err = ic.UnmarshalPublicKey(nk.PubKey); err != nil {
+			return
+		}
+		var id peer.ID
+		if id, err = peer.IDFromPublicKey(pub); err != nil {
+			return
+		}
+		if id != to {
+			return fmt.Errorf("%v: certificate is for %v, not %v", ErrStreamAuthFailed, id.Pretty(), to.Pretty())
+		}
+		ok, err := pub.Verify(append([]byte(quicKeyTag), cert.RawSubjectPublicKeyInfo...), nk.Signature)
+		if err == nil && !ok {
+			err = ErrStreamAuthFailed
+		}
+		return err
+	}
+	return fmt.Errorf("%v: certificate has no node key", ErrStreamAuthFailed)
+}
+
+// quicBinding binds stream authentication to the listener's certificate
+func quicBinding(der []byte) []byte {
+	b := sha256.Sum256(der)
+	return b[:]
+}
+
+// NewQUICTransport creates a QUIC transport listening on the given address
+func NewQUICTransport(listenAddr ma.Multiaddr, id peer.ID, priv ic.PrivKey) (tr Transport, err error) {
+	hostPort, err := multiaddrHostPort(listenAddr)
+	if err != nil {
+		return
+	}
+	tlsConfig, der, err := makeQUICTLSConfig(priv)
+	if err != nil {
+		return
+	}
+	t := QUICTransport{addr: listenAddr, id: id, priv: priv, cert: der, handlers: make(map[protocol.ID]StreamHandler), peers: make(map[peer.ID]string)}
+	t.listener, err = quic.ListenAddr(hostPort, tlsConfig, nil)
+	if err != nil {
+		return
+	}
+	go t.accept()
+	tr = &t
+	return
+}
+
+func (t *QUICTransport) accept() {
+	for {
+		sess, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			st, err := sess.AcceptStream()
+			if err != nil {
+				return
+			}
+			s := quicStream{r: bufio.NewReader(st), s: st, sess: sess}
+			defer s.Close()
+			line, err := s.r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			t.lk.RLock()
+			handler, ok := t.handlers[protocol.ID(strings.TrimSpace(line))]
+			t.lk.RUnlock()
+			if !ok {
+				Debugf("quic: no handler for protocol %s", line)
+				return
+			}
+			as, err := acceptAuth(&s, t.id, t.priv, quicBinding(t.cert))
+			if err != nil {
+				Debugf("quic: authentication failed: %v", err)
+				return
+			}
+			handler(as)
+		}()
+	}
+}
+
+// Name returns the transport name
+func (t *QUICTransport) Name() string { return QUICTransportName }
+
+// ListenAddr returns the address the transport is listening on
+func (t *QUICTransport) ListenAddr() ma.Multiaddr { return t.addr }
+
+// SetStreamHandler registers a handler for streams opened for a protocol
+func (t *QUICTransport) SetStreamHandler(proto protocol.ID, handler StreamHandler) {
+	t.lk.Lock()
+	t.handlers[proto] = handler
+	t.lk.Unlock()
+}
+
+// NewStream opens a stream to a peer for a protocol
+func (t *QUICTransport) NewStream(ctx context.Context, to peer.ID, proto protocol.ID) (s Stream, err error) {
+	t.lk.RLock()
+	hostPort, ok := t.peers[to]
+	t.lk.RUnlock()
+	if !ok {
+		err = fmt.Errorf("no address for peer %s", to.Pretty())
+		return
+	}
+	// the certificate is self signed so the usual chain verification is skipped, instead
+	// it must carry the signature of the key of the peer being dialed
+	var cert []byte
+	config := &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("%v: no certificate", ErrStreamAuthFailed)
+			}
+			cert = rawCerts[0]
+			return verifyQUICCert(cert, to)
+		},
+	}
+	sess, err := quic.DialAddr(hostPort, config, nil)
+	if err != nil {
+		return
+	}
+	st, err := sess.OpenStreamSync()
+	if err != nil {
+		sess.Close(err)
+		return
+	}
+	_, err = st.Write([]byte(string(proto) + "\n"))
+	if err != nil {
+		sess.Close(err)
+		return
+	}
+	qs := &quicStream{r: bufio.NewReader(st), s: st, sess: sess}
+	if s, err = dialAuth(qs, to, t.id, t.priv, quicBinding(cert)); err != nil {
+		qs.Close()
+	}
+	return
+}
+
+// AddPeerAddr records the address of a peer
+func (t *QUICTransport) AddPeerAddr(id peer.ID, addr ma.Multiaddr) {
+	hostPort, err := multiaddrHostPort(addr)
+	if err != nil {
+		Debugf("ignoring peer address: %v", err)
+		return
+	}
+	t.lk.Lock()
+	t.peers[id] = hostPort
+	t.lk.Unlock()
+}
+
+// Close shuts down the transport
+func (t *QUICTransport) Close() error {
+	return t.listener.Close()
+}
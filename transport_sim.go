@@ -0,0 +1,287 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// transport_sim implements a Transport over an in-process fabric that connects the nodes of
+// a network simulation.  The fabric delays messages according to a latency distribution,
+// loses some of them, and can partition the network or take nodes down, so the protocol can
+// be tried out under conditions that are hard to arrange with real networks.
+
+package holochain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const SimTransportName = "sim"
+
+const (
+	LatencyConstant    = "constant"
+	LatencyUniform     = "uniform"     // spread evenly over Mean-Jitter to Mean+Jitter
+	LatencyNormal      = "normal"      // normally distributed with Jitter as the standard deviation
+	LatencyExponential = "exponential" // exponentially distributed with the given Mean
+)
+
+var ErrNoSimulation error = errors.New("no simulation is running")
+var ErrSimRunning error = errors.New("a simulation is already running")
+var ErrSimLost error = errors.New("message lost")
+var ErrSimUnreachable error = errors.New("node unreachable")
+
+// LatencySpec describes the distribution of the time messages take to cross the fabric
+type LatencySpec struct {
+	Distribution string
+	Mean         string // a duration, e.g. 50ms
+	Jitter       string // a duration, how widely the latency varies around the mean
+}
+
+// latency is a parsed LatencySpec
+type latency struct {
+	distribution string
+	mean, jitter time.Duration
+}
+
+func (l *LatencySpec) parse() (p latency, err error) {
+	p.distribution = l.Distribution
+	if p.distribution == "" {
+		p.distribution = LatencyConstant
+	}
+	switch p.distribution {
+	case LatencyConstant, LatencyUniform, LatencyNormal, LatencyExponential:
+	default:
+		err = fmt.Errorf("unknown latency distribution: %s", l.Distribution)
+		return
+	}
+	if l.Mean != "" {
+		if p.mean, err = time.ParseDuration(l.Mean); err != nil {
+			return
+		}
+	}
+	if l.Jitter != "" {
+		p.jitter, err = time.ParseDuration(l.Jitter)
+	}
+	return
+}
+
+// sample draws a latency from the distribution
+func (l latency) sample(r *rand.Rand) (d time.Duration) {
+	switch l.distribution {
+	case LatencyUniform:
+		d = l.mean - l.jitter + time.Duration(r.Int63n(int64(2*l.jitter)+1))
+	case LatencyNormal:
+		d = l.mean + time.Duration(r.NormFloat64()*float64(l.jitter))
+	case LatencyExponential:
+		d = time.Duration(math.Min(r.ExpFloat64()*float64(l.mean), float64(math.MaxInt64/2)))
+	default:
+		d = l.mean
+	}
+	if d < 0 {
+		d = 0
+	}
+	return
+}
+
+// SimFabric connects the nodes of a simulation
+type SimFabric struct {
+	lk      sync.Mutex
+	rand    *rand.Rand
+	latency latency
+	loss    float64
+	nodes   map[peer.ID]*SimTransport
+	down    map[peer.ID]bool
+	groups  map[peer.ID]int // the partition each node is in, if the network is partitioned
+	sent    int64
+	lost    int64
+	blocked int64
+}
+
+var activeFabric struct {
+	lk     sync.Mutex
+	fabric *SimFabric
+}
+
+// newSimFabric makes the fabric that simulation transports connect through until it is
+// stopped
+func newSimFabric(seed int64, l latency, loss float64) (f *SimFabric, err error) {
+	activeFabric.lk.Lock()
+	defer activeFabric.lk.Unlock()
+	if activeFabric.fabric != nil {
+		err = ErrSimRunning
+		return
+	}
+	f = &SimFabric{
+		rand:    rand.New(rand.NewSource(seed)),
+		latency: l,
+		loss:    loss,
+		nodes:   make(map[peer.ID]*SimTransport),
+		down:    make(map[peer.ID]bool),
+	}
+	activeFabric.fabric = f
+	return
+}
+
+// stop disconnects the fabric so another simulation can be run
+func (f *SimFabric) stop() {
+	activeFabric.lk.Lock()
+	defer activeFabric.lk.Unlock()
+	if activeFabric.fabric == f {
+		activeFabric.fabric = nil
+	}
+}
+
+// SetDown takes a node off the network, or brings it back
+func (f *SimFabric) SetDown(id peer.ID, down bool) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.down[id] = down
+}
+
+// Partition splits the network into groups of nodes that can only reach each other.  Nodes
+// not in any of the groups are put together in a group of their own.
+func (f *SimFabric) Partition(groups [][]peer.ID) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.groups = make(map[peer.ID]int)
+	for i, g := range groups {
+		for _, id := range g {
+			f.groups[id] = i + 1
+		}
+	}
+}
+
+// Heal removes any partition of the network
+func (f *SimFabric) Heal() {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.groups = nil
+}
+
+// Counts returns how many streams have been opened across the fabric, and how many of them
+// were lost or blocked by partitions or downed nodes
+func (f *SimFabric) Counts() (sent int64, lost int64, blocked int64) {
+	return atomic.LoadInt64(&f.sent), atomic.LoadInt64(&f.lost), atomic.LoadInt64(&f.blocked)
+}
+
+// route decides the fate of a stream, returning the transport it reaches and how long each
+// way takes
+func (f *SimFabric) route(from peer.ID, to peer.ID) (t *SimTransport, out time.Duration, back time.Duration, err error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	atomic.AddInt64(&f.sent, 1)
+	t = f.nodes[to]
+	if t == nil || f.down[from] || f.down[to] || (f.groups != nil && f.groups[from] != f.groups[to]) {
+		atomic.AddInt64(&f.blocked, 1)
+		err = ErrSimUnreachable
+		return
+	}
+	out = f.latency.sample(f.rand)
+	back = f.latency.sample(f.rand)
+	if f.loss > 0 && f.rand.Float64() < f.loss {
+		atomic.AddInt64(&f.lost, 1)
+		err = ErrSimLost
+	}
+	return
+}
+
+// SimTransport implements Transport over the active simulation fabric
+type SimTransport struct {
+	id       peer.ID
+	addr     ma.Multiaddr
+	fabric   *SimFabric
+	lk       sync.Mutex
+	handlers map[protocol.ID]StreamHandler
+}
+
+func init() {
+	RegisterTransport(SimTransportName, "/ip4/127.0.0.1/tcp/%d", NewSimTransport)
+}
+
+// NewSimTransport connects a node to the simulation fabric
+func NewSimTransport(listenAddr ma.Multiaddr, id peer.ID, priv ic.PrivKey) (t Transport, err error) {
+	activeFabric.lk.Lock()
+	f := activeFabric.fabric
+	activeFabric.lk.Unlock()
+	if f == nil {
+		err = ErrNoSimulation
+		return
+	}
+	st := &SimTransport{id: id, addr: listenAddr, fabric: f, handlers: make(map[protocol.ID]StreamHandler)}
+	f.lk.Lock()
+	f.nodes[id] = st
+	f.lk.Unlock()
+	t = st
+	return
+}
+
+// Name returns the transport name
+func (t *SimTransport) Name() string { return SimTransportName }
+
+// ListenAddr returns the address the transport was given, which is only used to identify it
+func (t *SimTransport) ListenAddr() ma.Multiaddr { return t.addr }
+
+// SetStreamHandler registers a handler for streams opened for a protocol
+func (t *SimTransport) SetStreamHandler(proto protocol.ID, handler StreamHandler) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.handlers[proto] = handler
+}
+
+// NewStream opens a stream to a peer for a protocol across the fabric
+func (t *SimTransport) NewStream(ctx context.Context, to peer.ID, proto protocol.ID) (s Stream, err error) {
+	var remote *SimTransport
+	var out, back time.Duration
+	remote, out, back, err = t.fabric.route(t.id, to)
+	time.Sleep(out)
+	if err != nil {
+		return
+	}
+	remote.lk.Lock()
+	handler := remote.handlers[proto]
+	remote.lk.Unlock()
+	if handler == nil {
+		err = fmt.Errorf("protocol %s not supported by %v", proto, to)
+		return
+	}
+	// the fabric is in-process so it knows which node is at each end of a stream
+	local, other := net.Pipe()
+	go func() {
+		handler(&authStream{Stream: other, remote: t.id})
+		other.Close()
+	}()
+	s = &authStream{Stream: &simStream{Conn: local, delay: back}, remote: to}
+	return
+}
+
+// AddPeerAddr does nothing as the fabric finds nodes by their ids
+func (t *SimTransport) AddPeerAddr(id peer.ID, addr ma.Multiaddr) {}
+
+// Close takes the node off the fabric
+func (t *SimTransport) Close() error {
+	t.fabric.lk.Lock()
+	defer t.fabric.lk.Unlock()
+	delete(t.fabric.nodes, t.id)
+	return nil
+}
+
+// simStream delays the response to a message by the time it takes to cross the fabric
+type simStream struct {
+	net.Conn
+	delay time.Duration
+	once  sync.Once
+}
+
+func (s *simStream) Read(p []byte) (int, error) {
+	s.once.Do(func() { time.Sleep(s.delay) })
+	return s.Conn.Read(p)
+}
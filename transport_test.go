@@ -0,0 +1,222 @@
+package holochain
+
+import (
+	"context"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	. "github.com/smartystreets/goconvey/convey"
+	gonet "net"
+	"strings"
+	"testing"
+)
+
+func TestTransportRegistry(t *testing.T) {
+	Convey("it should have the tcp and websocket transports available", t, func() {
+		names := AvailableTransports()
+		So(names, ShouldContain, TCPTransportName)
+		So(names, ShouldContain, WebSocketTransportName)
+	})
+	Convey("it should panic on duplicate registration", t, func() {
+		So(func() { RegisterTransport(TCPTransportName, "", NewLibP2PTransport) }, ShouldPanicWith, "Transport factory for type tcp already registered.")
+	})
+	Convey("it should build listen addresses", t, func() {
+		a, err := TransportListenAddr(TCPTransportName, 1234)
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, "/ip4/127.0.0.1/tcp/1234")
+		a, err = TransportListenAddr(WebSocketTransportName, 1234)
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, "/ip4/127.0.0.1/tcp/1234/ws")
+		_, err = TransportListenAddr("carrier-pigeon", 1234)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("it should get host and port from multiaddrs", t, func() {
+		a, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234/ws")
+		hp, err := multiaddrHostPort(a)
+		So(err, ShouldBeNil)
+		So(hp, ShouldEqual, "127.0.0.1:1234")
+	})
+	Convey("it should pick the transport by the address's protocols", t, func() {
+		a, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234/ws")
+		name, err := TransportForAddr(a)
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, WebSocketTransportName)
+		a, _ = ma.NewMultiaddr("/dns4/ws.example.com/tcp/1234")
+		name, err = TransportForAddr(a)
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, TCPTransportName)
+	})
+}
+
+func TestChooseTransport(t *testing.T) {
+	var h Holochain
+	Convey("it should default to tcp", t, func() {
+		name, err := h.chooseTransport()
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, TCPTransportName)
+	})
+	Convey("it should skip transports not in the build", t, func() {
+		h.config.Transports = []string{"carrier-pigeon", WebSocketTransportName, TCPTransportName}
+		name, err := h.chooseTransport()
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, WebSocketTransportName)
+	})
+	Convey("it should fail if no preferred transport is available", t, func() {
+		h.config.Transports = []string{"carrier-pigeon"}
+		_, err := h.chooseTransport()
+		So(err.Error(), ShouldEqual, "none of the preferred transports (carrier-pigeon) are available")
+	})
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	node1, err := makeTransportNode(WebSocketTransportName, 1240, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node1.Close()
+	node2, err := makeTransportNode(WebSocketTransportName, 1241, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+
+	Convey("It should create a websocket node", t, func() {
+		So(node1.NetAddr.String(), ShouldEqual, "/ip4/127.0.0.1/tcp/1240/ws")
+		So(node1.Transport.Name(), ShouldEqual, WebSocketTransportName)
+		So(node1.Host, ShouldBeNil)
+	})
+
+	Convey("It should send between nodes", t, func() {
+		node1.Transport.AddPeerAddr(node2.HashAddr, node2.NetAddr)
+		var payload string
+		node2.Transport.SetStreamHandler("/testprotocol/1.0.0", func(s Stream) {
+			buf := make([]byte, 1024)
+			n, err := s.Read(buf)
+			if err != nil {
+				payload = err.Error()
+			} else {
+				payload = string(buf[:n])
+			}
+			s.Write([]byte("I got: " + payload))
+		})
+
+		s, err := node1.Transport.NewStream(context.Background(), node2.HashAddr, "/testprotocol/1.0.0")
+		So(err, ShouldBeNil)
+		_, err = s.Write([]byte("greetings"))
+		So(err, ShouldBeNil)
+
+		buf := make([]byte, 1024)
+		n, err := s.Read(buf)
+		So(err, ShouldBeNil)
+		So(payload, ShouldEqual, "greetings")
+		So(string(buf[:n]), ShouldEqual, "I got: greetings")
+		s.Close()
+	})
+
+	Convey("It should send messages", t, func() {
+		var h Holochain
+		node2.StartSrc(&h)
+		m := node1.NewMessage(PUT_REQUEST, "fish")
+		r, err := node1.Send(SourceProtocol, node2.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.From, ShouldEqual, node2.HashAddr)
+		So(r.Body, ShouldEqual, "message type 2 not in holochain-src protocol")
+	})
+
+	Convey("It should refuse messages whose source isn't the node that sent them", t, func() {
+		node3, err := makeTransportNode(WebSocketTransportName, 1242, "node3")
+		So(err, ShouldBeNil)
+		node3.Close()
+		m := node1.NewMessage(PUT_REQUEST, "fish")
+		m.From = node3.HashAddr
+		r, err := node1.Send(SourceProtocol, node2.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body, ShouldEqual, ErrMessageSourceMismatch.Error())
+	})
+
+	Convey("It should fail to reach a node other than the one intended", t, func() {
+		node3, err := makeTransportNode(WebSocketTransportName, 1243, "node3")
+		So(err, ShouldBeNil)
+		defer node3.Close()
+		node1.Transport.AddPeerAddr(node3.HashAddr, node2.NetAddr)
+		_, err = node1.Transport.NewStream(context.Background(), node3.HashAddr, "/testprotocol/1.0.0")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("It should fail to send to unknown peers", t, func() {
+		_, err := node2.Transport.NewStream(context.Background(), node1.HashAddr, "/testprotocol/1.0.0")
+		So(err.Error(), ShouldEqual, "no address for peer "+node1.HashAddr.Pretty())
+	})
+}
+
+func TestStreamAuth(t *testing.T) {
+	key := func(seed string) (ic.PrivKey, peer.ID) {
+		k, _, _ := ic.GenerateEd25519Key(strings.NewReader(seed + "1234567890123456789012345678901234567890"))
+		id, _ := peer.IDFromPrivateKey(k)
+		return k, id
+	}
+	lkey, lid := key("listener")
+	dkey, did := key("dialer")
+	_, other := key("other")
+
+	handshake := func(to peer.ID, dialBinding string, listenBinding string) (accepted peer.ID, dialed peer.ID, aerr error, derr error) {
+		a, b := gonet.Pipe()
+		defer a.Close()
+		defer b.Close()
+		done := make(chan bool)
+		go func() {
+			as, err := acceptAuth(a, lid, lkey, []byte(listenBinding))
+			if err == nil {
+				accepted = as.RemotePeer()
+			}
+			aerr = err
+			a.Close()
+			done <- true
+		}()
+		ds, err := dialAuth(b, to, did, dkey, []byte(dialBinding))
+		if err == nil {
+			dialed = ds.RemotePeer()
+		}
+		derr = err
+		b.Close()
+		<-done
+		return
+	}
+
+	Convey("it should authenticate both ends of a stream", t, func() {
+		accepted, dialed, aerr, derr := handshake(lid, "binding", "binding")
+		So(aerr, ShouldBeNil)
+		So(derr, ShouldBeNil)
+		So(accepted, ShouldEqual, did)
+		So(dialed, ShouldEqual, lid)
+	})
+
+	Convey("it should fail if the listener isn't the node dialed", t, func() {
+		_, _, aerr, derr := handshake(other, "binding", "binding")
+		So(aerr, ShouldNotBeNil)
+		So(derr, ShouldNotBeNil)
+	})
+
+	Convey("it should fail if the ends see different connections", t, func() {
+		_, _, aerr, derr := handshake(lid, "binding", "relayed")
+		So(aerr, ShouldNotBeNil)
+		So(derr, ShouldNotBeNil)
+	})
+}
+
+func makeTransportNode(transport string, port int, id string) (*Node, error) {
+	listenaddr, err := TransportListenAddr(transport, port)
+	if err != nil {
+		return nil, err
+	}
+	r := strings.NewReader(id + "1234567890123456789012345678901234567890")
+	key, _, err := ic.GenerateEd25519Key(r)
+	if err != nil {
+		panic(err)
+	}
+	pid, _ := peer.IDFromPrivateKey(key)
+
+	return NewNodeWithTransport(transport, listenaddr, pid, key)
+}
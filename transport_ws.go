@@ -0,0 +1,162 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// transport_ws implements a Transport over WebSockets so nodes can talk through
+// http proxies and with browser-adjacent environments
+
+package holochain
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"github.com/gorilla/websocket"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	gonet "net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterTransport(WebSocketTransportName, "/ip4/127.0.0.1/tcp/%d/ws", NewWebSocketTransport)
+}
+
+// WebSocketTransport implements Transport by serving each protocol at its own http path.
+// Each connection is authenticated against the nodes' keys, bound to the websocket
+// handshake's accept key.
+type WebSocketTransport struct {
+	addr     ma.Multiaddr
+	id       peer.ID
+	priv     ic.PrivKey
+	listener gonet.Listener
+	mux      *http.ServeMux
+	lk       sync.RWMutex
+	peers    map[peer.ID]string
+}
+
+// wsStream adapts a websocket connection to the Stream interface
+type wsStream struct {
+	conn *websocket.Conn
+	buf  []byte
+}
+
+func (s *wsStream) Read(p []byte) (n int, err error) {
+	for len(s.buf) == 0 {
+		_, s.buf, err = s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+	}
+	n = copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return
+}
+
+func (s *wsStream) Write(p []byte) (n int, err error) {
+	err = s.conn.WriteMessage(websocket.BinaryMessage, p)
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
+
+func (s *wsStream) Close() error {
+	return s.conn.Close()
+}
+
+// NewWebSocketTransport creates a websocket transport listening on the given address
+func NewWebSocketTransport(listenAddr ma.Multiaddr, id peer.ID, priv ic.PrivKey) (tr Transport, err error) {
+	hostPort, err := multiaddrHostPort(listenAddr)
+	if err != nil {
+		return
+	}
+	t := WebSocketTransport{addr: listenAddr, id: id, priv: priv, mux: http.NewServeMux(), peers: make(map[peer.ID]string)}
+	t.listener, err = gonet.Listen("tcp", hostPort)
+	if err != nil {
+		return
+	}
+	go http.Serve(t.listener, t.mux)
+	tr = &t
+	return
+}
+
+// Name returns the transport name
+func (t *WebSocketTransport) Name() string { return WebSocketTransportName }
+
+// ListenAddr returns the address the transport is listening on
+func (t *WebSocketTransport) ListenAddr() ma.Multiaddr { return t.addr }
+
+// SetStreamHandler registers a handler for streams opened for a protocol
+func (t *WebSocketTransport) SetStreamHandler(proto protocol.ID, handler StreamHandler) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	t.mux.HandleFunc(string(proto), func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			Debugf("websocket upgrade failed: %v", err)
+			return
+		}
+		s := wsStream{conn: conn}
+		defer s.Close()
+		conn.SetReadDeadline(time.Now().Add(StreamAuthTimeout))
+		as, err := acceptAuth(&s, t.id, t.priv, []byte(wsAcceptKey(r.Header.Get("Sec-Websocket-Key"))))
+		if err != nil {
+			Debugf("websocket authentication failed: %v", err)
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		handler(as)
+	})
+}
+
+// wsAcceptKey computes the Sec-WebSocket-Accept the server answers a handshake's key with
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// NewStream opens a stream to a peer for a protocol
+func (t *WebSocketTransport) NewStream(ctx context.Context, to peer.ID, proto protocol.ID) (s Stream, err error) {
+	t.lk.RLock()
+	hostPort, ok := t.peers[to]
+	t.lk.RUnlock()
+	if !ok {
+		err = fmt.Errorf("no address for peer %s", to.Pretty())
+		return
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+hostPort+string(proto), nil)
+	if err != nil {
+		return
+	}
+	ws := &wsStream{conn: conn}
+	conn.SetReadDeadline(time.Now().Add(StreamAuthTimeout))
+	if s, err = dialAuth(ws, to, t.id, t.priv, []byte(resp.Header.Get("Sec-Websocket-Accept"))); err != nil {
+		ws.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	return
+}
+
+// AddPeerAddr records the address of a peer
+func (t *WebSocketTransport) AddPeerAddr(id peer.ID, addr ma.Multiaddr) {
+	hostPort, err := multiaddrHostPort(addr)
+	if err != nil {
+		Debugf("ignoring peer address: %v", err)
+		return
+	}
+	t.lk.Lock()
+	t.peers[id] = hostPort
+	t.lk.Unlock()
+}
+
+// Close shuts down the transport
+func (t *WebSocketTransport) Close() error {
+	return t.listener.Close()
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// ttl implements expiry of DHT entries whose type declares a time to live.  Expiry is
+// measured from the time in the entry's header on the author's chain, so that every node
+// agrees on when an entry expires no matter when it received it.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/buntdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultExpireInterval = time.Minute
+)
+
+var ErrEntryExpired error = errors.New("entry expired")
+
+// TTLDuration returns the time to live of entries of this type, or 0 if they don't expire
+func (d *EntryDef) TTLDuration() (ttl time.Duration, err error) {
+	if d.TTL == "" {
+		return
+	}
+	ttl, err = time.ParseDuration(d.TTL)
+	if err == nil && ttl <= 0 {
+		err = fmt.Errorf("ttl must be positive, got: %s", d.TTL)
+	}
+	return
+}
+
+// entryExpiry returns when an entry of the given type expires, or the zero time if it doesn't.
+// The header is the one the source sent with the entry, checked against the entry's hash and
+// the source's key, but its time isn't covered by the source's signature so a time after now
+// counts as now.
+func (dht *DHT) entryExpiry(entryType string, header *Header) (expires time.Time, err error) {
+	var d *EntryDef
+	_, d, err = dht.h.GetEntryDef(entryType)
+	if err != nil {
+		// system entry types have no def and don't expire
+		err = nil
+		return
+	}
+	var ttl time.Duration
+	ttl, err = d.TTLDuration()
+	if err != nil || ttl == 0 {
+		return
+	}
+	created := time.Now()
+	if header != nil && header.Time.Before(created) {
+		created = header.Time
+	}
+	expires = created.Add(ttl)
+	return
+}
+
+// setExpiry records when the entry at key expires
+func (dht *DHT) setExpiry(key Hash, expires time.Time) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("expires:"+key.String(), fmt.Sprintf("%d", expires.UnixNano()), nil)
+		return err
+	})
+	return
+}
+
+// isExpired checks whether the entry with hash k has expired, which pinned entries never do
+func isExpired(tx *buntdb.Tx, k string, now time.Time) bool {
+	if isPinned(tx, k) {
+		return false
+	}
+	val, err := tx.Get("expires:" + k)
+	if err != nil {
+		return false
+	}
+	t, err := strconv.ParseInt(val, 10, 64)
+	return err == nil && t <= now.UnixNano()
+}
+
+// ExpireEntries removes expired entries, and the links on and by them, from the DHT store,
+// returning how many entries were removed.  The expiry record is kept so that the entry is
+// no longer gossiped.
+func (dht *DHT) ExpireEntries() (n int, err error) {
+	now := time.Now()
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		var expired []string
+		tx.AscendKeys("expires:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "expires:")
+			if isExpired(tx, k, now) {
+				expired = append(expired, k)
+			}
+			return true
+		})
+		for _, k := range expired {
+			val, err := tx.Get("entry:" + k)
+			if err == buntdb.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var holders []string
+			tx.AscendKeys("holder:"+k+":*", func(key, value string) bool {
+				holders = append(holders, key)
+				return true
+			})
+			if _, err = deleteKeys(tx, append(holders, "entry:"+k, "type:"+k, "src:"+k, "status:"+k, "recv:"+k)...); err != nil {
+				return err
+			}
+			freed, err := deleteMetas(tx, k)
+			if err != nil {
+				return err
+			}
+			if err = dht.incSize(tx, -(len(val) + freed)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if n > 0 {
+		dht.dlog.Logf("expired %d entries", n)
+		dht.h.metrics.Inc("dht.expired", int64(n))
+	}
+	return
+}
+
+// ExpireEvery removes expired entries from the DHT store every interval
+func (dht *DHT) ExpireEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := dht.ExpireEntries(); err != nil {
+			dht.dlog.Logf("expire error: %v", err)
+		}
+	}
+}
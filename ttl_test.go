@@ -0,0 +1,97 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"testing"
+	"time"
+)
+
+func TestTTLDuration(t *testing.T) {
+	Convey("it should parse entry type ttls", t, func() {
+		d := EntryDef{Name: "beacon"}
+		ttl, err := d.TTLDuration()
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, 0)
+
+		d.TTL = "10m"
+		ttl, err = d.TTLDuration()
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, 10*time.Minute)
+
+		d.TTL = "-1s"
+		_, err = d.TTLDuration()
+		So(err.Error(), ShouldEqual, "ttl must be positive, got: -1s")
+
+		d.TTL = "soon"
+		_, err = d.TTLDuration()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExpireEntries(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+
+	h.Zomes["myZome"].Entries["myData"] = EntryDef{Name: "myData", DataFormat: DataFormatRawZygo, TTL: "1h"}
+
+	Convey("it should reject puts of entries that have already expired", t, func() {
+		e := GobEntry{C: "124"}
+		_, hd, _ := h.NewEntry(time.Now().Add(-2*time.Hour), "myData", &e)
+		DHTReceiver(h, h.node.NewMessage(PUT_REQUEST, PutReq{H: hd.EntryLink}))
+		So(dht.simHandlePutReqs(), ShouldEqual, ErrEntryExpired)
+		_, _, _, err := dht.get(hd.EntryLink)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	e := GobEntry{C: "126"}
+	_, hd, _ := h.NewEntry(time.Now(), "myData", &e)
+	hash := hd.EntryLink
+	DHTReceiver(h, h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}))
+
+	Convey("it should store entries that haven't expired", t, func() {
+		So(dht.simHandlePutReqs(), ShouldBeNil)
+		_, _, _, err := dht.get(hash)
+		So(err, ShouldBeNil)
+		n, err := dht.ExpireEntries()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+	})
+
+	Convey("it should stop serving and gossiping entries once they expire", t, func() {
+		idx, _ := dht.GetIdx()
+		So(dht.setExpiry(hash, time.Now().Add(-time.Second)), ShouldBeNil)
+		_, _, _, err := dht.get(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+
+		puts, err := dht.GetPuts(idx)
+		So(err, ShouldBeNil)
+		So(len(puts), ShouldEqual, 1)
+		So(puts[0].M.Body, ShouldBeNil)
+	})
+
+	Convey("it should remove expired entries from the store", t, func() {
+		link, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		dht.db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set("meta:"+hash.String()+":"+link.String()+":tag", "x", nil)
+			return err
+		})
+		n, err := dht.ExpireEntries()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		So(dht.exists(hash), ShouldEqual, ErrHashNotFound)
+		So(h.Metrics().Get("dht.expired"), ShouldEqual, int64(1))
+		dht.db.View(func(tx *buntdb.Tx) error {
+			_, err := tx.Get("meta:" + hash.String() + ":" + link.String() + ":tag")
+			So(err, ShouldEqual, buntdb.ErrNotFound)
+			_, err = tx.Get("recv:" + hash.String())
+			So(err, ShouldEqual, buntdb.ErrNotFound)
+			return nil
+		})
+
+		n, err = dht.ExpireEntries()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+	})
+}
@@ -8,18 +8,17 @@ package holochain
 import (
 	"bytes"
 	"encoding/gob"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
-	"github.com/ghodss/yaml"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 func writeToml(path string, file string, data interface{}, overwrite bool) error {
-	p := path + "/" + file
+	p := filepath.Join(path, file)
 	if !overwrite && fileExists(p) {
 		return mkErr(path + " already exists")
 	}
@@ -35,7 +34,7 @@ func writeToml(path string, file string, data interface{}, overwrite bool) error
 }
 
 func writeFile(path string, file string, data []byte) error {
-	p := path + "/" + file
+	p := filepath.Join(path, file)
 	if fileExists(p) {
 		return mkErr(p + " already exists")
 	}
@@ -58,7 +57,7 @@ func writeFile(path string, file string, data []byte) error {
 }
 
 func readFile(path string, file string) (data []byte, err error) {
-	p := path + "/" + file
+	p := filepath.Join(path, file)
 	data, err = ioutil.ReadFile(p)
 	return data, err
 }
@@ -112,8 +111,8 @@ func CopyDir(source string, dest string) (err error) {
 
 	for _, entry := range entries {
 
-		sfp := source + "/" + entry.Name()
-		dfp := dest + "/" + entry.Name()
+		sfp := filepath.Join(source, entry.Name())
+		dfp := filepath.Join(dest, entry.Name())
 		if entry.IsDir() {
 			err = CopyDir(sfp, dfp)
 			if err != nil {
@@ -154,61 +153,6 @@ func CopyFile(source string, dest string) (err error) {
 	return
 }
 
-// Encode encodes data to the writer according to the given format
-func Encode(writer io.Writer, format string, data interface{}) (err error) {
-	switch format {
-	case "toml":
-		enc := toml.NewEncoder(writer)
-		err = enc.Encode(data)
-
-	case "json":
-		enc := json.NewEncoder(writer)
-		enc.SetIndent("", "    ")
-		err = enc.Encode(data)
-
-	case "yaml":
-		y, e := yaml.Marshal(data)
-		if e != nil {
-			err = e
-			return
-		}
-		n, e := writer.Write(y)
-		if e != nil {
-			err = e
-			return
-		}
-		if n != len(y) {
-			err = errors.New("unable to write all bytes while encoding")
-		}
-
-	default:
-		err = errors.New("unknown encoding format: " + format)
-	}
-	return
-}
-
-// Decode extracts data from the reader according to the type
-func Decode(reader io.Reader, format string, data interface{}) (err error) {
-	switch format {
-	case "toml":
-		_, err = toml.DecodeReader(reader, data)
-
-	case "json":
-		dec := json.NewDecoder(reader)
-		err = dec.Decode(data)
-	case "yaml":
-		y, e := ioutil.ReadAll(reader)
-		if e != nil {
-			err = e
-			return
-		}
-		err = yaml.Unmarshal(y, data)
-	default:
-		err = errors.New("unknown encoding format: " + format)
-	}
-	return
-}
-
 // ByteEncoder encodes anything using gob
 func ByteEncoder(data interface{}) (b []byte, err error) {
 	var buf bytes.Buffer
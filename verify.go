@@ -0,0 +1,181 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// verify implements auditing of the DHT store against the chain's current validation rules,
+// which finds entries that were accepted under rules that have since changed, e.g. after a
+// validation bug has been fixed.  The content of every entry is checked against its hash, and
+// the signature of its header is checked too: from this node's own chain for its own entries,
+// and from the source for entries put by other nodes, when the source can be reached.
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/tidwall/buntdb"
+	"strings"
+)
+
+var ErrVerifyHashMismatch error = errors.New("entry content doesn't match its hash")
+var ErrVerifyBadSignature error = errors.New("bad signature on entry's chain header")
+
+// InvalidEntry describes an entry held in the DHT store that fails verification
+type InvalidEntry struct {
+	Hash      Hash
+	EntryType string
+	Err       error
+}
+
+// VerifyReport describes the result of verifying the DHT store
+type VerifyReport struct {
+	Checked int
+	Invalid []InvalidEntry
+	Purged  int
+}
+
+// heldEntry is a live entry read out of the DHT store for verification
+type heldEntry struct {
+	key       string
+	entryType string
+	src       string
+	data      []byte
+}
+
+// heldEntries returns the live entries in the DHT store
+func (dht *DHT) heldEntries() (entries []heldEntry, err error) {
+	live := fmt.Sprintf("%d", LIVE)
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.AscendKeys("entry:*", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			if status, _ := tx.Get("status:" + k); status != live {
+				return true
+			}
+			he := heldEntry{key: k, data: []byte(value)}
+			if he.entryType, e = tx.Get("type:" + k); e != nil {
+				return false
+			}
+			he.src, _ = tx.Get("src:" + k)
+			entries = append(entries, he)
+			return true
+		})
+		return e
+	})
+	return
+}
+
+// verifyEntry checks a held entry's content, signature and validity
+func (h *Holochain) verifyEntry(he *heldEntry) (err error) {
+	if err = h.dht.checkHash(he.entryType, he.key, he.data); err != nil {
+		err = ErrVerifyHashMismatch
+		return
+	}
+	if he.entryType == DNAEntryType || he.entryType == KeyEntryType {
+		return
+	}
+	var key Hash
+	if key, err = NewHash(he.key); err != nil {
+		return
+	}
+	if header, e := h.chain.GetEntryHeader(key); e == nil {
+		var ok bool
+		ok, err = h.agent.PrivKey().GetPublic().Verify(header.EntryLink.H, header.Sig.S)
+		if err == nil && !ok {
+			err = ErrVerifyBadSignature
+		}
+		if err != nil {
+			return
+		}
+	} else if he.src != "" && h.node != nil {
+		if err = h.verifySourceHeader(he.src, key); err != nil {
+			return
+		}
+	}
+	if he.entryType == AgentEntryType {
+		return
+	}
+	var e GobEntry
+	if err = e.Unmarshal(he.data); err != nil {
+		return
+	}
+	p := ValidationProps{Hash: he.key}
+	if he.src != "" {
+		p.Sources = []string{he.src}
+	}
+	err = h.validateEntry(he.entryType, &e, &p)
+	return
+}
+
+// verifySourceHeader checks the header of an entry held for another node with its source.  A
+// source that can't be reached doesn't fail the entry, as there is nothing to tell either way.
+func (h *Holochain) verifySourceHeader(src string, key Hash) (err error) {
+	var source peer.ID
+	if source, err = peer.IDB58Decode(src); err != nil {
+		return
+	}
+	if _, e := h.getSourceHeader(source, key); e != nil && strings.HasPrefix(e.Error(), ErrBadSourceHeader.Error()) {
+		err = ErrVerifyBadSignature
+	}
+	return
+}
+
+// rejectEntry removes an invalid entry and its links from the DHT store, keeping its status
+// record as REJECTED so that it isn't accepted again
+func (dht *DHT) rejectEntry(k string) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("entry:" + k)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", REJECTED), nil); err != nil {
+			return err
+		}
+		var holders []string
+		tx.AscendKeys("holder:"+k+":*", func(key, value string) bool {
+			holders = append(holders, key)
+			return true
+		})
+		if _, err = deleteKeys(tx, append(holders, "entry:"+k, "type:"+k, "src:"+k, "expires:"+k, "recv:"+k, "gc:entry:"+k)...); err != nil {
+			return err
+		}
+		freed, err := deleteMetas(tx, k)
+		if err != nil {
+			return err
+		}
+		return dht.incSize(tx, -(len(val) + freed))
+	})
+	return
+}
+
+// VerifyDHT re-validates every live entry held in the DHT store against the chain's current
+// validation rules, reporting those that would be rejected today and, if purge is set,
+// removing them from the store
+func (h *Holochain) VerifyDHT(purge bool) (report VerifyReport, err error) {
+	var entries []heldEntry
+	if entries, err = h.dht.heldEntries(); err != nil {
+		return
+	}
+	for i := range entries {
+		he := &entries[i]
+		report.Checked++
+		e := h.verifyEntry(he)
+		if e == nil {
+			continue
+		}
+		inv := InvalidEntry{EntryType: he.entryType, Err: e}
+		inv.Hash, _ = NewHash(he.key)
+		report.Invalid = append(report.Invalid, inv)
+		if purge {
+			if err = h.dht.rejectEntry(he.key); err != nil {
+				return
+			}
+			report.Purged++
+		}
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestVerifyDHT(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	dht := h.dht
+	other, _ := makePeer("other")
+
+	put := func(content string) Hash {
+		e := GobEntry{C: content}
+		key, _ := e.Sum(h.hashSpec)
+		b, _ := e.Marshal()
+		dht.put(nil, "myData", key, other, b, LIVE)
+		return key
+	}
+	good := put("2")
+	invalid := put("1")
+	e := GobEntry{C: "4"}
+	b, _ := e.Marshal()
+	tampered, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	dht.put(nil, "myData", tampered, other, b, LIVE)
+
+	Convey("it should report entries that fail verification", t, func() {
+		r, err := h.VerifyDHT(false)
+		So(err, ShouldBeNil)
+		So(r.Checked, ShouldBeGreaterThan, 3)
+		So(r.Purged, ShouldEqual, 0)
+		So(len(r.Invalid), ShouldEqual, 2)
+		found := make(map[string]error)
+		for _, inv := range r.Invalid {
+			So(inv.EntryType, ShouldEqual, "myData")
+			found[inv.Hash.String()] = inv.Err
+		}
+		So(found[invalid.String()].Error(), ShouldEqual, "Invalid entry: 1")
+		So(found[tampered.String()], ShouldEqual, ErrVerifyHashMismatch)
+		_, ok := found[good.String()]
+		So(ok, ShouldBeFalse)
+		So(dht.exists(invalid), ShouldBeNil)
+	})
+
+	Convey("it should purge invalid entries when asked", t, func() {
+		size := dhtSize(dht)
+		r, err := h.VerifyDHT(true)
+		So(err, ShouldBeNil)
+		So(r.Purged, ShouldEqual, 2)
+		So(dht.exists(invalid), ShouldEqual, ErrHashNotFound)
+		So(dht.exists(tampered), ShouldEqual, ErrHashNotFound)
+		So(dht.exists(good), ShouldBeNil)
+		So(dhtSize(dht), ShouldEqual, size-2*len(b))
+
+		r, err = h.VerifyDHT(false)
+		So(err, ShouldBeNil)
+		So(len(r.Invalid), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,350 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// verifyapp implements checking a DNA bundle before it is installed, so that a user can find
+// out that an app is broken, or asks for more than it should, before cloning and joining it.
+// The checks are of the hashes the DNA records for its files, of the entry schemas, of each
+// zome's code parsing, of the function declarations against the functions the code exposes,
+// and of the capabilities the DNA grants.  The code is only parsed, never run, as the app
+// isn't trusted until it has been checked.
+
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AppProblem is a problem found verifying an app's DNA bundle
+type AppProblem struct {
+	Warning bool   // the app may still work but the problem should be looked into
+	Where   string // the zome, entry type, file or DNA setting the problem is in
+	Message string
+}
+
+func (p AppProblem) String() string {
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", kind, p.Where, p.Message)
+}
+
+// AppProblems is the list of problems found verifying an app
+type AppProblems []AppProblem
+
+// Errors returns how many of the problems are errors rather than warnings
+func (problems AppProblems) Errors() (n int) {
+	for _, p := range problems {
+		if !p.Warning {
+			n++
+		}
+	}
+	return
+}
+
+// ReadBundle reads a DNA bundle from a holochain directory, or from a JSON encoded bundle in
+// a local file or at an http(s) url
+func ReadBundle(src string) (b *DNABundle, err error) {
+	if dirExists(src) {
+		var format string
+		if format, err = findDNA(src); err != nil {
+			return
+		}
+		var f *os.File
+		if f, err = os.Open(filepath.Join(src, DNAFileName+"."+format)); err != nil {
+			return
+		}
+		defer f.Close()
+		var h *Holochain
+		if h, err = DecodeDNA(f, format); err != nil {
+			return
+		}
+		h.path = src
+		b, err = h.Bundle()
+		return
+	}
+	var data []byte
+	if data, err = fetchURL(src); err != nil {
+		return
+	}
+	var bundle DNABundle
+	if err = json.Unmarshal(data, &bundle); err != nil {
+		err = fmt.Errorf("bad bundle: %v", err)
+		return
+	}
+	b = &bundle
+	return
+}
+
+// VerifyApp checks a DNA bundle, and if expected is set that it builds to that DNA hash,
+// returning the problems found with it.  An error is only returned if the bundle's DNA can't
+// be read at all.
+func VerifyApp(b *DNABundle, expected Hash) (problems AppProblems, err error) {
+	var tmp string
+	if tmp, err = ioutil.TempDir("", "hc-verify"); err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	if err = b.write(tmp); err != nil {
+		return
+	}
+	var format string
+	if format, err = findDNA(tmp); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(filepath.Join(tmp, DNAFileName+"."+format)); err != nil {
+		return
+	}
+	h, err := DecodeDNA(f, format)
+	f.Close()
+	if err != nil {
+		return
+	}
+	h.path = tmp
+	if err = h.PrepareHashType(); err != nil {
+		return
+	}
+
+	v := appVerifier{h: h, b: b}
+	v.checkHashes()
+	v.checkSchemas()
+	v.checkZomes()
+	v.checkCapabilities()
+	if len(expected.H) > 0 {
+		// building the DNA regenerates its hashes, so this must come after checking them
+		if _, e := h.VerifyDNA(expected); e != nil {
+			v.fail("DNA", "%v", e)
+		}
+	}
+	problems = v.problems
+	return
+}
+
+type appVerifier struct {
+	h        *Holochain
+	b        *DNABundle
+	problems AppProblems
+}
+
+func (v *appVerifier) fail(where string, format string, args ...interface{}) {
+	v.problems = append(v.problems, AppProblem{Where: where, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *appVerifier) warn(where string, format string, args ...interface{}) {
+	v.problems = append(v.problems, AppProblem{Warning: true, Where: where, Message: fmt.Sprintf(format, args...)})
+}
+
+// zomeNames returns the names of the DNA's zomes in order, so problems are reported the same
+// way each time
+func (v *appVerifier) zomeNames() (names []string) {
+	for name := range v.h.Zomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// checkHash checks that a bundle file exists and matches the hash the DNA records for it
+func (v *appVerifier) checkHash(file string, recorded Hash) {
+	data, ok := v.b.Files[file]
+	if !ok {
+		v.fail(file, "file missing from bundle")
+		return
+	}
+	if len(recorded.H) == 0 {
+		v.warn(file, "no hash recorded in the DNA")
+		return
+	}
+	var actual Hash
+	if err := actual.Sum(v.h.hashSpec, data); err != nil {
+		v.fail(file, "unable to hash: %v", err)
+	} else if actual.String() != recorded.String() {
+		v.fail(file, "hash %v doesn't match %v recorded in the DNA", actual, recorded)
+	}
+}
+
+func (v *appVerifier) checkHashes() {
+	for _, name := range v.zomeNames() {
+		z := v.h.Zomes[name]
+		v.checkHash(z.Code, z.CodeHash)
+		for _, e := range z.Entries {
+			if e.Schema != "" && strings.HasSuffix(e.Schema, ".json") {
+				v.checkHash(e.Schema, e.SchemaHash)
+			}
+		}
+	}
+	for _, l := range v.h.Libraries {
+		v.checkHash(l.Code, l.CodeHash)
+	}
+}
+
+func (v *appVerifier) checkSchemas() {
+	if err := v.h.checkEntryTypeNames(); err != nil {
+		v.fail("Zomes", "%v", err)
+	}
+	if err := v.h.checkTopics(); err != nil {
+		v.fail("Topics", "%v", err)
+	}
+	if v.h.PropertiesSchema != "" {
+		d := EntryDef{Name: "properties", Schema: v.h.PropertiesSchema}
+		if err := d.BuildJSONSchemaValidator(v.h.path); err != nil {
+			v.fail(v.h.PropertiesSchema, "bad properties schema: %v", err)
+		}
+	}
+	if v.h.PreferencesSchema != "" {
+		d := EntryDef{Name: PreferencesEntryType, Schema: v.h.PreferencesSchema}
+		if err := d.BuildJSONSchemaValidator(v.h.path); err != nil {
+			v.fail(v.h.PreferencesSchema, "bad preferences schema: %v", err)
+		}
+	}
+	for _, name := range v.zomeNames() {
+		z := v.h.Zomes[name]
+		for _, e := range z.Entries {
+			where := "entry type " + e.Name
+			if err := checkCRDTKind(e.CRDT); err != nil {
+				v.fail(where, "%v", err)
+			}
+			if _, err := e.TTLDuration(); err != nil {
+				v.fail(where, "%v", err)
+			}
+			if e.Retention != nil {
+				if err := e.Retention.Check(); err != nil {
+					v.fail(where, "%v", err)
+				}
+			}
+			if err := e.checkDependencies(); err != nil {
+				v.fail(where, "%v", err)
+			}
+			if err := e.checkLinks(v.h); err != nil {
+				v.fail(where, "%v", err)
+			}
+			if e.MaxSize < 0 {
+				v.fail(where, "max size must not be negative")
+			}
+			if !strings.HasSuffix(e.Schema, ".json") {
+				continue
+			}
+			if _, ok := v.b.Files[e.Schema]; !ok {
+				continue // already reported by checkHashes
+			}
+			if err := e.BuildJSONSchemaValidator(v.h.path); err != nil {
+				v.fail(where, "bad schema %s: %v", e.Schema, err)
+			}
+		}
+	}
+}
+
+// checkZomes parses each zome's code, without running it, and checks the functions it declares
+// against those the code exposes
+func (v *appVerifier) checkZomes() {
+	for _, name := range v.zomeNames() {
+		z := v.h.Zomes[name]
+		where := "zome " + name
+		if name == SystemZomeName {
+			v.fail(where, "%v", ErrSystemZomeName)
+			continue
+		}
+		if err := v.h.checkLibraries(z); err != nil {
+			v.fail(where, "%v", err)
+			continue
+		}
+		for _, f := range z.Functions {
+			if err := f.Check(); err != nil {
+				v.fail(where, "function %s: %v", f.Name, err)
+			}
+		}
+		if _, ok := v.b.Files[z.Code]; !ok {
+			continue // already reported by checkHashes
+		}
+		code, err := v.h.zomeCode(z)
+		if err != nil {
+			v.fail(where, "%v", err)
+			continue
+		}
+		names, err := parseExposed(z.NucleusType, string(code))
+		if err != nil {
+			v.fail(where, "code doesn't parse: %s: %v", v.h.codeLocation(z, err), err)
+			continue
+		}
+		exposed := make(map[string]bool)
+		for _, name := range names {
+			exposed[name] = true
+		}
+		for _, f := range z.Functions {
+			if !exposed[f.Name] {
+				v.fail(where, "function %s is declared but not exposed by the code", f.Name)
+			}
+		}
+		if len(z.Subscriptions) > 0 {
+			if err = z.checkSubscriptions(v.h); err != nil {
+				v.fail(where, "%v", err)
+			}
+			if !exposed[ReceiveFunctionName] {
+				v.fail(where, "has subscriptions but doesn't expose a %s function", ReceiveFunctionName)
+			}
+		}
+		for _, e := range z.Entries {
+			if e.Countersigners > 0 && !exposed[CountersignFunctionName] {
+				v.fail(where, "has countersigned entry types but doesn't expose a %s function", CountersignFunctionName)
+				break
+			}
+		}
+		if len(exposed) == 0 {
+			v.warn(where, "code exposes no functions")
+		}
+	}
+}
+
+// checkCapabilities checks the powers the DNA grants beyond its zomes' own entries
+func (v *appVerifier) checkCapabilities() {
+	if p := v.h.HTTPFetch; p != nil {
+		if err := p.Check(); err != nil {
+			v.fail("HTTPFetch", "%v", err)
+		}
+		for _, host := range p.Hosts {
+			pattern := strings.TrimPrefix(host, "*.")
+			if strings.Contains(pattern, "*") || !strings.Contains(pattern, ".") {
+				v.warn("HTTPFetch", "host %s allows fetching from too broad a set of hosts", host)
+			}
+		}
+	}
+	for _, r := range v.h.Redactors {
+		if _, err := peer.IDB58Decode(r); err != nil {
+			v.fail("Redactors", "bad agent %s: %v", r, err)
+			continue
+		}
+		v.warn("Redactors", "agent %s may redact any entry", r)
+	}
+	if v.h.AuditLog {
+		v.warn("AuditLog", "nodes publish the types and times of all the entries on their chains")
+	}
+	if v.h.Resilience < 0 {
+		v.fail("Resilience", "must not be negative")
+	}
+	if p := v.h.JoinPuzzle; p != nil {
+		if err := p.Check(); err != nil {
+			v.fail("JoinPuzzle", "%v", err)
+		} else if p.Kind == PuzzleWork && p.difficulty() > 24 {
+			v.warn("JoinPuzzle", "difficulty of %d bits may take agents hours to join", p.difficulty())
+		}
+	}
+	if p := v.h.Identity; p != nil {
+		if err := p.Check(); err != nil {
+			v.fail("Identity", "%v", err)
+		}
+	}
+	if p := v.h.Profiles; p != nil {
+		if err := p.Check(v.h); err != nil {
+			v.fail("Profiles", "%v", err)
+		}
+	}
+}
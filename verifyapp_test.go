@@ -0,0 +1,81 @@
+package holochain
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func problemsContaining(problems AppProblems, s string) (found AppProblems) {
+	for _, p := range problems {
+		if strings.Contains(p.String(), s) {
+			found = append(found, p)
+		}
+	}
+	return
+}
+
+func TestVerifyApp(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	_, hash, err := h.BuildDNA()
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should find no errors in a good app", t, func() {
+		b, err := ReadBundle(h.path)
+		So(err, ShouldBeNil)
+		problems, err := VerifyApp(b, hash)
+		So(err, ShouldBeNil)
+		So(problems.Errors(), ShouldEqual, 0)
+	})
+
+	Convey("it should report tampered code and undeclared functions", t, func() {
+		b, _ := ReadBundle(h.path)
+		code := string(b.Files["zome_myZome.zy"])
+		b.Files["zome_myZome.zy"] = []byte(strings.Replace(code, `(expose "exposedfn" STRING)`, "", 1))
+		problems, err := VerifyApp(b, hash)
+		So(err, ShouldBeNil)
+		So(len(problemsContaining(problems, "error: zome_myZome.zy: hash")), ShouldEqual, 1)
+		So(len(problemsContaining(problems, "function exposedfn is declared but not exposed")), ShouldEqual, 1)
+		So(len(problemsContaining(problems, ErrDNAHashMismatch.Error())), ShouldEqual, 1)
+	})
+
+	Convey("it should report code that doesn't load and bad schemas", t, func() {
+		b, _ := ReadBundle(h.path)
+		b.Files["zome_jsZome.js"] = []byte("function (")
+		b.Files["schema_profile.json"] = []byte("{")
+		problems, err := VerifyApp(b, Hash{})
+		So(err, ShouldBeNil)
+		So(len(problemsContaining(problems, "zome jsZome: code doesn't parse")), ShouldEqual, 1)
+		So(len(problemsContaining(problems, "bad schema schema_profile.json")), ShouldBeGreaterThanOrEqualTo, 1)
+		So(len(problemsContaining(problems, ErrDNAHashMismatch.Error())), ShouldEqual, 0)
+	})
+
+	Convey("it should check code without running it", t, func() {
+		b, _ := ReadBundle(h.path)
+		b.Files["zome_jsZome.js"] = append(b.Files["zome_jsZome.js"], []byte("\nthrow 'ran';")...)
+		b.Files["zome_myZome.zy"] = append(b.Files["zome_myZome.zy"], []byte("\n(ran)")...)
+		problems, err := VerifyApp(b, Hash{})
+		So(err, ShouldBeNil)
+		So(len(problemsContaining(problems, "code doesn't")), ShouldEqual, 0)
+		So(len(problemsContaining(problems, "not exposed")), ShouldEqual, 0)
+	})
+
+	Convey("it should report files missing from the bundle", t, func() {
+		b, _ := ReadBundle(h.path)
+		delete(b.Files, "zome_jsZome.js")
+		problems, err := VerifyApp(b, Hash{})
+		So(err, ShouldBeNil)
+		So(problems, ShouldContain, AppProblem{Where: "zome_jsZome.js", Message: "file missing from bundle"})
+	})
+
+	Convey("it should warn about broad capabilities", t, func() {
+		v := appVerifier{h: &Holochain{HTTPFetch: &HTTPFetchPolicy{Hosts: []string{"api.example.com", "*.com", "*"}}}}
+		v.checkCapabilities()
+		So(v.problems.Errors(), ShouldEqual, 0)
+		So(len(v.problems), ShouldEqual, 2)
+		So(v.problems[0].String(), ShouldEqual, "warning: HTTPFetch: host *.com allows fetching from too broad a set of hosts")
+	})
+}
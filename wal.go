@@ -0,0 +1,220 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// wal implements crash-safe writes of the source chain's file.  Each header and entry pair is
+// first written to a write-ahead log beside the chain's file, recording where in the file it
+// goes, and only then appended to the file, after which the log is removed.  If the process
+// dies part way through, loading the chain replays the logged pair over whatever half-written
+// pair the file was left with, or, if the log itself is incomplete, drops it and leaves the
+// file as it was before the write began.  How often writes are forced to disk is set by the
+// config's Fsync policy.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	WALSuffix = ".wal"
+
+	// policies for forcing chain writes to disk
+	FsyncAlways   = "always"   // sync the log and the chain's file on every commit
+	FsyncInterval = "interval" // sync the chain's file every FsyncInterval
+	FsyncNever    = "never"    // leave it to the operating system
+
+	DefaultFsyncInterval = time.Second
+
+	walHeaderLen = 20 // offset, length and checksum of the logged pair
+)
+
+var ErrBadWAL error = errors.New("write-ahead log is incomplete")
+
+// FsyncPolicy returns the configured policy for forcing chain writes to disk
+func (config *Config) FsyncPolicy() (policy string, err error) {
+	switch config.Fsync {
+	case "":
+		policy = FsyncAlways
+	case FsyncAlways, FsyncInterval, FsyncNever:
+		policy = config.Fsync
+	default:
+		err = fmt.Errorf("unknown fsync policy: %s", config.Fsync)
+	}
+	return
+}
+
+// FsyncIntervalDuration returns how often the interval policy syncs the chain's file
+func (config *Config) FsyncIntervalDuration() (interval time.Duration, err error) {
+	if config.FsyncInterval == "" {
+		interval = DefaultFsyncInterval
+		return
+	}
+	interval, err = time.ParseDuration(config.FsyncInterval)
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("fsync interval must be positive, got: %s", config.FsyncInterval)
+	}
+	return
+}
+
+// encodeWAL returns the log of a pair of bytes to be written at offset in the chain's file
+func encodeWAL(offset int64, data []byte) []byte {
+	b := make([]byte, walHeaderLen, walHeaderLen+len(data))
+	binary.LittleEndian.PutUint64(b[0:], uint64(offset))
+	binary.LittleEndian.PutUint64(b[8:], uint64(len(data)))
+	binary.LittleEndian.PutUint32(b[16:], crc32.ChecksumIEEE(data))
+	return append(b, data...)
+}
+
+// decodeWAL returns the offset and bytes of a logged pair, or ErrBadWAL if the log wasn't
+// completely written
+func decodeWAL(b []byte) (offset int64, data []byte, err error) {
+	if len(b) < walHeaderLen {
+		err = ErrBadWAL
+		return
+	}
+	offset = int64(binary.LittleEndian.Uint64(b[0:]))
+	l := binary.LittleEndian.Uint64(b[8:])
+	data = b[walHeaderLen:]
+	if uint64(len(data)) != l || crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(b[16:]) {
+		err = ErrBadWAL
+	}
+	return
+}
+
+// recoverWAL finishes or abandons a chain write that was interrupted, returning true if a
+// logged pair was written to the chain's file
+func recoverWAL(path string) (recovered bool, err error) {
+	walPath := path + WALSuffix
+	var b []byte
+	if b, err = ioutil.ReadFile(walPath); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	offset, data, e := decodeWAL(b)
+	if e == nil {
+		var f *os.File
+		if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600); err != nil {
+			return
+		}
+		defer f.Close()
+		if err = f.Truncate(offset); err != nil {
+			return
+		}
+		if _, err = f.WriteAt(data, offset); err != nil {
+			return
+		}
+		if err = f.Sync(); err != nil {
+			return
+		}
+		recovered = true
+		Infof("recovered interrupted write to %s", path)
+	} else {
+		Infof("dropped incomplete write-ahead log of %s", path)
+	}
+	err = os.Remove(walPath)
+	return
+}
+
+// writeDurably appends a header and entry pair to the chain's file through the write-ahead log
+func (c *Chain) writeDurably(header *Header, entry Entry) (err error) {
+	var buf bytes.Buffer
+	if err = c.writePair(&buf, header, entry); err != nil {
+		return
+	}
+	var fi os.FileInfo
+	if fi, err = c.s.Stat(); err != nil {
+		return
+	}
+	walPath := c.s.Name() + WALSuffix
+	var w *os.File
+	if w, err = os.OpenFile(walPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+		return
+	}
+	_, err = w.Write(encodeWAL(fi.Size(), buf.Bytes()))
+	if err == nil && c.fsync == FsyncAlways {
+		err = w.Sync()
+	}
+	w.Close()
+	if err != nil {
+		return
+	}
+	if _, err = c.s.Write(buf.Bytes()); err != nil {
+		return
+	}
+	if c.fsync == FsyncAlways {
+		if err = c.s.Sync(); err != nil {
+			return
+		}
+	}
+	err = os.Remove(walPath)
+	return
+}
+
+// Sync forces the chain's file to disk
+func (c *Chain) Sync() (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.s != nil {
+		err = c.s.Sync()
+	}
+	return
+}
+
+// Close syncs and closes the chain's file, after which new entries aren't persisted and the
+// entries still stored in the file can't be read
+func (c *Chain) Close() (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.r != nil {
+		c.r.Close()
+		c.r = nil
+	}
+	if c.s == nil {
+		return
+	}
+	if err = c.s.Sync(); err == nil {
+		err = c.s.Close()
+	}
+	c.s = nil
+	return
+}
+
+// setFsync sets the chain's policy for forcing writes to disk
+func (h *Holochain) setFsync() (err error) {
+	var policy string
+	config := h.Config()
+	if policy, err = config.FsyncPolicy(); err != nil {
+		return
+	}
+	h.chain.fsync = policy
+	return
+}
+
+// SyncInterval returns how often the chain's file should be synced in the background, which
+// is zero unless the fsync policy is interval
+func (h *Holochain) SyncInterval() (interval time.Duration) {
+	if h.chain.fsync == FsyncInterval {
+		config := h.Config()
+		interval, _ = config.FsyncIntervalDuration()
+	}
+	return
+}
+
+// FsyncEvery syncs the chain's file every interval, for the interval policy, // until the chain shuts down
+func (h *Holochain) FsyncEvery(interval time.Duration) {
+	h.runEvery(interval, func() {
+		if err := h.chain.Sync(); err != nil {
+			h.dht.dlog.Logf("fsync error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package holochain
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWALEncoding(t *testing.T) {
+	Convey("it should round trip a logged pair", t, func() {
+		b := encodeWAL(42, []byte("pair"))
+		offset, data, err := decodeWAL(b)
+		So(err, ShouldBeNil)
+		So(offset, ShouldEqual, 42)
+		So(string(data), ShouldEqual, "pair")
+	})
+
+	Convey("it should detect incomplete logs", t, func() {
+		b := encodeWAL(42, []byte("pair"))
+		_, _, err := decodeWAL(b[:10])
+		So(err, ShouldEqual, ErrBadWAL)
+		_, _, err = decodeWAL(b[:len(b)-1])
+		So(err, ShouldEqual, ErrBadWAL)
+		b[len(b)-1] = 'X'
+		_, _, err = decodeWAL(b)
+		So(err, ShouldEqual, ErrBadWAL)
+	})
+}
+
+func TestFsyncConfig(t *testing.T) {
+	Convey("it should check the fsync policy and interval", t, func() {
+		config := Config{}
+		policy, err := config.FsyncPolicy()
+		So(err, ShouldBeNil)
+		So(policy, ShouldEqual, FsyncAlways)
+		interval, err := config.FsyncIntervalDuration()
+		So(err, ShouldBeNil)
+		So(interval, ShouldEqual, DefaultFsyncInterval)
+
+		config.Fsync = "sometimes"
+		_, err = config.FsyncPolicy()
+		So(err.Error(), ShouldEqual, "unknown fsync policy: sometimes")
+		config.FsyncInterval = "-1s"
+		_, err = config.FsyncIntervalDuration()
+		So(err.Error(), ShouldEqual, "fsync interval must be positive, got: -1s")
+	})
+}
+
+func TestWALRecovery(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+	path := d + "/chain.dat"
+
+	c, err := NewChainFromFile(h, path)
+	if err != nil {
+		panic(err)
+	}
+	e := GobEntry{C: "some data"}
+	c.AddEntry(h, now, "myData", &e, key)
+
+	Convey("it shouldn't leave a log behind after a write", t, func() {
+		So(fileExists(path+WALSuffix), ShouldBeFalse)
+	})
+
+	// crash part way through appending a pair to the chain's file, or, if walLen isn't -1,
+	// part way through writing its log
+	crash := func(c *Chain, walLen int) {
+		e := GobEntry{C: "more data"}
+		_, _, header, err := c.PrepareHeader(h, now, "myData", &e, key)
+		if err != nil {
+			panic(err)
+		}
+		var buf bytes.Buffer
+		if err = c.writePair(&buf, header, &e); err != nil {
+			panic(err)
+		}
+		fi, _ := c.s.Stat()
+		wal := encodeWAL(fi.Size(), buf.Bytes())
+		if walLen != -1 {
+			wal = wal[:walLen]
+		}
+		if err = ioutil.WriteFile(path+WALSuffix, wal, 0600); err != nil {
+			panic(err)
+		}
+		if walLen == -1 {
+			c.s.Write(buf.Bytes()[:buf.Len()/2])
+		}
+		c.s.Close()
+	}
+
+	Convey("it should finish an interrupted write from the log", t, func() {
+		crash(c, -1)
+		c, err = NewChainFromFile(h, path)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 2)
+		So(c.Entries[1].Content(), ShouldEqual, "more data")
+		So(fileExists(path+WALSuffix), ShouldBeFalse)
+	})
+
+	Convey("it should drop an incomplete log, leaving the chain as it was", t, func() {
+		crash(c, 30)
+		c, err = NewChainFromFile(h, path)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 2)
+		So(fileExists(path+WALSuffix), ShouldBeFalse)
+	})
+
+	Convey("it should keep appending after recovery", t, func() {
+		e := GobEntry{C: "last data"}
+		_, err := c.AddEntry(h, now, "myData", &e, key)
+		So(err, ShouldBeNil)
+		So(c.Close(), ShouldBeNil)
+		c, err = NewChainFromFile(h, path)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 3)
+		c.Close()
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should refuse commits and puts once shut down", t, func() {
+		So(h.ShuttingDown(), ShouldBeFalse)
+		So(h.Shutdown(DefaultShutdownTimeout), ShouldBeNil)
+		So(h.ShuttingDown(), ShouldBeTrue)
+		_, err := h.Commit("profile", `{"firstName":"Eric","lastName":"H"}`)
+		So(err, ShouldEqual, ErrShuttingDown)
+		_, err = h.dht.queuePut(&Message{})
+		So(err, ShouldEqual, ErrShuttingDown)
+		So(h.chain.s, ShouldBeNil)
+		// shutting down again does nothing
+		So(h.Shutdown(DefaultShutdownTimeout), ShouldBeNil)
+	})
+
+	Convey("the chain should refuse entries that got past the check before shutting down", t, func() {
+		e := GobEntry{C: "2"}
+		l, hash, header, err := h.chain.PrepareHeader(h.hashSpec, time.Now(), "myData", &e, h.agent.PrivKey())
+		So(err, ShouldBeNil)
+		So(h.chain.addEntry(l, hash, header, &e), ShouldEqual, ErrShuttingDown)
+	})
+
+	Convey("background work should stop once shut down", t, func() {
+		done := make(chan bool)
+		go func() {
+			h.FsyncEvery(time.Millisecond)
+			done <- true
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("FsyncEvery didn't stop")
+		}
+	})
+
+	Convey("the chain's file should hold everything committed", t, func() {
+		c, err := NewChainFromFile(h.hashSpec, h.path+"/"+StoreFileName+".dat")
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, h.chain.Length())
+		c.Close()
+		_, err = os.Stat(h.path + "/" + StoreFileName + ".dat" + WALSuffix)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
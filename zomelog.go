@@ -0,0 +1,97 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// zomelog implements logging for zome developers: leveled messages from zome code written to a
+// logger per zome, and tracing of the host functions that zome code calls
+
+package holochain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+var logLevels = map[string]int{LogLevelDebug: 0, LogLevelInfo: 1, LogLevelWarn: 2, LogLevelError: 3}
+
+// HostCall describes a call made by zome code to a host function
+type HostCall struct {
+	Zome     string
+	Function string
+	Args     []string
+	Duration time.Duration
+	Err      error
+}
+
+func (c HostCall) String() string {
+	s := fmt.Sprintf("%s:%s(%s) %v", c.Zome, c.Function, strings.Join(c.Args, ", "), c.Duration)
+	if c.Err != nil {
+		s += " error: " + c.Err.Error()
+	}
+	return s
+}
+
+// SetTracer sets a function to be called with each host function call zome code makes, or
+// stops tracing if fn is nil
+func (h *Holochain) SetTracer(fn func(HostCall)) {
+	h.tracer = fn
+}
+
+// traceHostCall reports a host function call to the tracer if there is one
+func (h *Holochain) traceHostCall(zome string, function string, args []string, start time.Time, err error) {
+	if h.tracer != nil {
+		h.tracer(HostCall{Zome: zome, Function: function, Args: args, Duration: time.Since(start), Err: err})
+	}
+}
+
+// checkLogLevel returns an error if level isn't one of the log levels
+func checkLogLevel(level string) (err error) {
+	if _, ok := logLevels[level]; !ok {
+		err = fmt.Errorf("unknown log level: %s", level)
+	}
+	return
+}
+
+// zomeLogger returns the logger for a zome, which is the app logger unless the zome has its own
+func (h *Holochain) zomeLogger(zome string) *Logger {
+	loggers := h.Config().Loggers
+	if l, ok := loggers.Zomes[zome]; ok && l != nil {
+		return l
+	}
+	return &loggers.App
+}
+
+// zomeLog writes a message from zome code to the zome's logger, if level is at or above the
+// configured minimum
+func (h *Holochain) zomeLog(zome string, level string, msg string) (err error) {
+	if level == "" {
+		level = LogLevelDebug
+	}
+	if err = checkLogLevel(level); err != nil {
+		return
+	}
+	min := h.Config().ZomeLogLevel
+	if min == "" {
+		min = LogLevelDebug
+	}
+	if logLevels[level] < logLevels[min] {
+		return
+	}
+	prefix := ""
+	if zome != "" {
+		prefix = zome + ": "
+	}
+	if level != LogLevelDebug {
+		prefix += strings.ToUpper(level) + ": "
+	}
+	h.zomeLogger(zome).p(prefix + msg)
+	return
+}
@@ -0,0 +1,64 @@
+package holochain
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCheckLogLevel(t *testing.T) {
+	Convey("it should accept the log levels", t, func() {
+		for _, l := range []string{LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError} {
+			So(checkLogLevel(l), ShouldBeNil)
+		}
+	})
+	Convey("it should reject other levels", t, func() {
+		So(checkLogLevel("loud").Error(), ShouldEqual, "unknown log level: loud")
+	})
+}
+
+func TestZomeLog(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	var buf bytes.Buffer
+	l := Logger{Enabled: true}
+	l.New(&buf)
+	h.config.Loggers.Zomes = map[string]*Logger{"myZome": &l}
+
+	Convey("it should write to the zome's logger with the level", t, func() {
+		err := h.zomeLog("myZome", "", "fish")
+		So(err, ShouldBeNil)
+		err = h.zomeLog("myZome", LogLevelWarn, "shark")
+		So(err, ShouldBeNil)
+		So(buf.String(), ShouldEqual, "myZome: fish\nmyZome: WARN: shark\n")
+	})
+
+	Convey("it should drop messages below the configured level", t, func() {
+		buf.Reset()
+		h.config.ZomeLogLevel = LogLevelInfo
+		h.zomeLog("myZome", LogLevelDebug, "fish")
+		h.zomeLog("myZome", LogLevelError, "shark")
+		So(buf.String(), ShouldEqual, "myZome: ERROR: shark\n")
+		h.config.ZomeLogLevel = ""
+	})
+
+	Convey("it should reject bad levels", t, func() {
+		err := h.zomeLog("myZome", "loud", "fish")
+		So(err.Error(), ShouldEqual, "unknown log level: loud")
+	})
+
+	Convey("zomes without their own logger should use the app logger", t, func() {
+		So(h.zomeLogger("otherZome"), ShouldEqual, &h.config.Loggers.App)
+		So(h.zomeLogger("myZome"), ShouldEqual, &l)
+	})
+}
+
+func TestHostCallString(t *testing.T) {
+	Convey("it should describe the call", t, func() {
+		c := HostCall{Zome: "myZome", Function: "debug", Args: []string{`"fish"`, `"warn"`}}
+		So(c.String(), ShouldEqual, `myZome:debug("fish", "warn") 0s`)
+		c.Err = ErrHashNotFound
+		So(c.String(), ShouldEndWith, " error: "+ErrHashNotFound.Error())
+	})
+}
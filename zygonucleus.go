@@ -3,6 +3,8 @@
 //----------------------------------------------------------------------------------------
 // ZygoNucleus implements a zygomys use of the Nucleus interface
 
+// +build !nozygo
+
 package holochain
 
 import (
@@ -17,15 +19,96 @@ import (
 	"time"
 )
 
-const (
-	ZygoNucleusType = "zygo"
-)
+func init() {
+	RegisterNucleusType(ZygoNucleusType, "zy", NewZygoNucleus)
+	RegisterNucleusParser(ZygoNucleusType, parseZygoExposed)
+	// the zygo nucleus loads its library once on its own and once with the code
+	RegisterNucleusPrefixLines(ZygoNucleusType, 2*strings.Count(ZygoLibrary, "\n"))
+}
+
+// parseZygoExposed reads zygo code, returning the names its top level expose forms give.  It
+// only checks that brackets balance and strings end, as loading zygo code would run its macros.
+func parseZygoExposed(code string) (exposed []string, err error) {
+	var open []rune
+	line := 1
+	form := 0 // position in the current top level form of its next element, from 1
+	var head string
+	element := func(s string, quoted bool) {
+		if len(open) != 1 {
+			return
+		}
+		if form == 1 && !quoted {
+			head = s
+		} else if form == 2 && quoted && head == "expose" {
+			exposed = append(exposed, s)
+		}
+		form++
+	}
+	rs := []rune(code)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == ';':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			line++
+		case c == '(' || c == '[' || c == '{':
+			element("", false)
+			open = append(open, c)
+			if len(open) == 1 {
+				form, head = 1, ""
+			}
+		case c == ')' || c == ']' || c == '}':
+			pairs := map[rune]rune{')': '(', ']': '[', '}': '{'}
+			if len(open) == 0 || open[len(open)-1] != pairs[c] {
+				err = fmt.Errorf("unbalanced %c at line %d", c, line)
+				return
+			}
+			open = open[:len(open)-1]
+		case c == '"' || c == '`':
+			start := line
+			var s []rune
+			for i++; i < len(rs) && rs[i] != c; i++ {
+				if rs[i] == '\\' && c == '"' && i+1 < len(rs) {
+					i++
+				}
+				if rs[i] == '\n' {
+					line++
+				}
+				s = append(s, rs[i])
+			}
+			if i == len(rs) {
+				err = fmt.Errorf("unterminated string at line %d", start)
+				return
+			}
+			element(string(s), true)
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+		default:
+			var s []rune
+			for ; i < len(rs) && !strings.ContainsRune(" \t\r\n,;()[]{}\"`", rs[i]); i++ {
+				s = append(s, rs[i])
+			}
+			i--
+			element(string(s), false)
+		}
+	}
+	if len(open) > 0 {
+		err = fmt.Errorf("unclosed %c at end of code, line %d", open[len(open)-1], line)
+	}
+	return
+}
 
 type ZygoNucleus struct {
 	env        *zygo.Glisp
 	interfaces []Interface
 	lastResult zygo.Sexp
 	library    string
+	validating bool
+	zome       string
+	hostFns    []string // names of the host functions made available to the zome code
 }
 
 // Name returns the string value under which this nucleus is registered
@@ -128,6 +211,8 @@ func (z *ZygoNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationP
 	if err != nil {
 		return
 	}
+	z.validating = true
+	defer func() { z.validating = false }()
 	result, err := z.env.Run()
 	if err != nil {
 		err = fmt.Errorf("Error executing validate: %v", err)
@@ -137,7 +222,7 @@ func (z *ZygoNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationP
 	case *zygo.SexpBool:
 		r := result.(*zygo.SexpBool).Val
 		if !r {
-			err = fmt.Errorf("Invalid entry: %v", entry.Content())
+			err = &ErrValidationFailed{Reason: fmt.Sprintf("%v", entry.Content())}
 		}
 	case *zygo.SexpSentinel:
 		err = errors.New("validate should return boolean, got nil")
@@ -287,7 +372,7 @@ func (z *ZygoNucleus) putmeta(env *zygo.Glisp, h *Holochain, hash string, metaHa
 }
 
 // get exposes DHTGet to zygo
-func (z *ZygoNucleus) get(env *zygo.Glisp, h *Holochain, hash string) (result *zygo.SexpHash, err error) {
+func (z *ZygoNucleus) get(env *zygo.Glisp, h *Holochain, hash string, opts GetOptions) (result *zygo.SexpHash, err error) {
 	result, err = zygo.MakeHash(nil, "hash", env)
 	if err != nil {
 		return nil, err
@@ -298,7 +383,7 @@ func (z *ZygoNucleus) get(env *zygo.Glisp, h *Holochain, hash string) (result *z
 	if err != nil {
 		return
 	}
-	response, err := h.dht.SendGet(key)
+	response, err := h.dht.SendGetWithOptions(key, opts)
 	if err == nil {
 		switch t := response.(type) {
 		case *GobEntry:
@@ -348,11 +433,157 @@ func (z *ZygoNucleus) getmeta(env *zygo.Glisp, h *Holochain, metahash string, me
 	return result, err
 }
 
+// zygoStrings returns the values of arguments which should all be strings
+func zygoStrings(name string, args []zygo.Sexp) (strs []string, err error) {
+	for i, a := range args {
+		t, ok := a.(*zygo.SexpStr)
+		if !ok {
+			err = fmt.Errorf("argument %d of %s should be string", i+1, name)
+			return
+		}
+		strs = append(strs, t.S)
+	}
+	return
+}
+
+// query parses the query an aggregate host function is given, as a hash or as its json
+func (z *ZygoNucleus) query(h *Holochain, name string, arg zygo.Sexp) (q Query, err error) {
+	var j string
+	switch t := arg.(type) {
+	case *zygo.SexpStr:
+		j = t.S
+	case *zygo.SexpHash:
+		j = zygo.SexpToJson(t)
+	default:
+		err = fmt.Errorf("1st argument of %s should be string or hash", name)
+		return
+	}
+	if z.validating {
+		err = ErrAggregateInValidation
+		return
+	}
+	q, err = ParseQuery(h, j)
+	return
+}
+
+// bridgeGet exposes BridgeGet to zygo
+func (z *ZygoNucleus) bridgeGet(env *zygo.Glisp, h *Holochain, chain string, hash string) (result *zygo.SexpHash, err error) {
+	result, err = zygo.MakeHash(nil, "hash", env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewHash(hash)
+	if err == nil && z.validating {
+		err = ErrBridgeInValidation
+	}
+	var entry *GobEntry
+	if err == nil {
+		entry, _, err = h.BridgeGet(chain, key)
+	}
+	if err != nil {
+		err = result.HashSet(env.MakeSymbol("error"), &zygo.SexpStr{S: err.Error()})
+		return result, err
+	}
+	j, err := json.Marshal(entry.C)
+	if err == nil {
+		err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: string(j)})
+	}
+	return result, err
+}
+
+// bridgeQuery exposes BridgeQuery to zygo
+func (z *ZygoNucleus) bridgeQuery(env *zygo.Glisp, h *Holochain, chain string, hash string, metaTag string) (result *zygo.SexpHash, err error) {
+	result, err = zygo.MakeHash(nil, "hash", env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewHash(hash)
+	if err == nil && z.validating {
+		err = ErrBridgeInValidation
+	}
+	var entries []MetaEntry
+	if err == nil {
+		entries, err = h.BridgeQuery(chain, MetaQuery{H: key, T: metaTag})
+	}
+	if err != nil {
+		err = result.HashSet(env.MakeSymbol("error"), &zygo.SexpStr{S: err.Error()})
+		return result, err
+	}
+	j, err := json.Marshal(entries)
+	if err == nil {
+		err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: string(j)})
+	}
+	return result, err
+}
+
+// crdtValue converts a zygo value to one that can be stored in a crdt
+func crdtValue(s zygo.Sexp) (v interface{}, err error) {
+	switch t := s.(type) {
+	case *zygo.SexpStr:
+		v = t.S
+	case *zygo.SexpInt:
+		v = t.Val
+	case *zygo.SexpFloat:
+		v = t.Val
+	case *zygo.SexpBool:
+		v = t.Val
+	default:
+		err = errors.New("crdt value should be string, number or boolean")
+	}
+	return
+}
+
+// setZome tells the nucleus which zome its code belongs to
+func (z *ZygoNucleus) setZome(zome string) {
+	z.zome = zome
+}
+
+// cover wraps each of the functions so that hit is called whenever its body runs, whether
+// from a zome call or from other code in the zome
+func (z *ZygoNucleus) cover(fns []string, hit func(fn string)) (err error) {
+	z.env.AddFunction("__cover", func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+		if len(args) == 1 {
+			if s, ok := args[0].(*zygo.SexpStr); ok {
+				hit(s.S)
+			}
+		}
+		return zygo.SexpNull, nil
+	})
+	for _, fn := range fns {
+		err = z.env.LoadString(fmt.Sprintf(`(def __covered_%s %s) (defn %s [x] (__cover "%s") (__covered_%s x))`, fn, fn, fn, fn, fn))
+		if err == nil {
+			_, err = z.env.Run()
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// addHostFn makes a host function available to the zome code, tracing its calls
+func (z *ZygoNucleus) addHostFn(h *Holochain, name string, fn zygo.GlispUserFunction) {
+	z.hostFns = append(z.hostFns, name)
+	z.env.AddFunction(name, func(env *zygo.Glisp, n string, args []zygo.Sexp) (zygo.Sexp, error) {
+		if h.tracer == nil {
+			return fn(env, n, args)
+		}
+		start := time.Now()
+		result, err := fn(env, n, args)
+		a := make([]string, len(args))
+		for i, arg := range args {
+			a[i] = zygo.SexpToJson(arg)
+		}
+		h.traceHostCall(z.zome, name, a, start, err)
+		return result, err
+	})
+}
+
 // NewZygoNucleus builds an zygo execution environment with user specified code
 func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	var z ZygoNucleus
 	z.env = zygo.NewGlispSandbox()
-	z.env.AddFunction("version",
+	z.addHostFn(h, "version",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			return &zygo.SexpStr{S: VersionStr}, nil
 		})
@@ -361,13 +592,13 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 
 	// use a closure so that the registered zygo function can call Expose on the correct ZygoNucleus obj
 
-	z.env.AddFunction("debug",
+	z.addHostFn(h, "debug",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
-			if len(args) != 1 {
+			if len(args) != 1 && len(args) != 2 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
 
-			var msg string
+			var msg, level string
 
 			switch t := args[0].(type) {
 			case *zygo.SexpStr:
@@ -376,12 +607,20 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 				return zygo.SexpNull,
 					errors.New("argument of debug should be string")
 			}
+			if len(args) == 2 {
+				switch t := args[1].(type) {
+				case *zygo.SexpStr:
+					level = t.S
+				default:
+					return zygo.SexpNull,
+						errors.New("level argument of debug should be string")
+				}
+			}
 
-			h.config.Loggers.App.p(msg)
-			return zygo.SexpNull, err
+			return zygo.SexpNull, h.zomeLog(z.zome, level, msg)
 		})
 
-	z.env.AddFunction("expose",
+	z.addHostFn(h, "expose",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			if len(args) != 2 {
 				return zygo.SexpNull, zygo.WrongNargs
@@ -409,7 +648,7 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return zygo.SexpNull, err
 		})
 
-	z.env.AddFunction("property",
+	z.addHostFn(h, "property",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			if len(args) != 1 {
 				return zygo.SexpNull, zygo.WrongNargs
@@ -425,7 +664,7 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 					errors.New("1st argument of expose should be string")
 			}
 
-			p, err := h.GetProperty(prop)
+			p, err := h.zomeProperty(z.zome, prop)
 			if err != nil {
 				return zygo.SexpNull, err
 			}
@@ -433,7 +672,7 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return &result, err
 		})
 
-	z.env.AddFunction("commit",
+	z.addHostFn(h, "commit",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			if len(args) != 2 {
 				return zygo.SexpNull, zygo.WrongNargs
@@ -460,34 +699,512 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 					errors.New("2nd argument of commit should be string or hash")
 			}
 
-			e := GobEntry{C: entry}
-			var l int
-			var hash Hash
-			var header *Header
-			l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, time.Now(), entryType, &e, h.agent.PrivKey())
+			hash, err := h.Commit(entryType, entry)
 			if err != nil {
 				return zygo.SexpNull, err
 			}
+			var result = zygo.SexpStr{S: hash.String()}
+			return &result, nil
+		})
 
-			p := ValidationProps{
-				Sources: []string{peer.IDB58Encode(h.id)},
-				Hash:    hash.String(),
+	crdtUpdate := func(kind string) zygo.GlispUserFunction {
+		return func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 3 && len(args) != 4 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var entryType, object, cause string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				entryType = t.S
+			default:
+				return zygo.SexpNull,
+					fmt.Errorf("1st argument of %s should be string", name)
+			}
+			switch t := args[1].(type) {
+			case *zygo.SexpStr:
+				object = t.S
+			default:
+				return zygo.SexpNull,
+					fmt.Errorf("2nd argument of %s should be string", name)
+			}
+			value, err := crdtValue(args[2])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			if len(args) == 4 {
+				switch t := args[3].(type) {
+				case *zygo.SexpStr:
+					cause = t.S
+				default:
+					return zygo.SexpNull,
+						fmt.Errorf("4th argument of %s should be string", name)
+				}
 			}
+			hash, err := h.CRDTUpdate(kind, entryType, object, value, cause)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: hash.String()}, nil
+		}
+	}
+	z.addHostFn(h, "crdtAdd", crdtUpdate(CRDTGrowOnlySet))
+	z.addHostFn(h, "crdtSet", crdtUpdate(CRDTLWWRegister))
+	z.addHostFn(h, "crdtIncrement", crdtUpdate(CRDTCounter))
 
-			err = h.ValidateEntry(entryType, &e, &p)
+	z.addHostFn(h, "crdtGet",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var s [2]string
+			for i, a := range args {
+				switch t := a.(type) {
+				case *zygo.SexpStr:
+					s[i] = t.S
+				default:
+					return zygo.SexpNull,
+						errors.New("arguments of crdtGet should be strings")
+				}
+			}
+			v, err := h.CRDTValue(s[0], s[1])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			j, err := json.Marshal(v)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
 
-			if err == nil {
-				err = h.chain.addEntry(l, hash, header, &e)
+	z.addHostFn(h, "hc",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var fn string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				fn = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of hc should be string")
+			}
+			var arg interface{}
+			if len(args) == 2 {
+				switch t := args[1].(type) {
+				case *zygo.SexpStr:
+					arg = t.S
+				case *zygo.SexpHash:
+					arg = zygo.SexpToJson(t)
+				default:
+					return zygo.SexpNull,
+						errors.New("2nd argument of hc should be string or hash")
+				}
 			}
+			// called directly as the zome call already holds a call slot and passed the scope check
+			r, err := h.callSystem(fn, arg)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: r.(string)}, nil
+		})
 
+	z.addHostFn(h, "fetch",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var u string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				u = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of fetch should be string")
+			}
+			body, err := h.httpFetch(u, z.validating)
 			if err != nil {
 				return zygo.SexpNull, err
 			}
-			var result = zygo.SexpStr{S: header.EntryLink.String()}
-			return &result, nil
+			return &zygo.SexpStr{S: body}, nil
+		})
+
+	for _, fn := range []string{"pin", "unpin"} {
+		pin := fn == "pin"
+		z.addHostFn(h, fn,
+			func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+				if len(args) != 1 {
+					return zygo.SexpNull, zygo.WrongNargs
+				}
+				var hashstr string
+				switch t := args[0].(type) {
+				case *zygo.SexpStr:
+					hashstr = t.S
+				default:
+					return zygo.SexpNull,
+						errors.New("argument of " + name + " should be string")
+				}
+				return zygo.SexpNull, h.pin(hashstr, pin, z.validating)
+			})
+	}
+
+	z.addHostFn(h, "kvSet",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var key, value string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				key = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of kvSet should be string")
+			}
+			switch t := args[1].(type) {
+			case *zygo.SexpStr:
+				value = t.S
+			case *zygo.SexpHash:
+				value = zygo.SexpToJson(t)
+			default:
+				return zygo.SexpNull,
+					errors.New("2nd argument of kvSet should be string or hash")
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrKVInValidation
+			}
+			return zygo.SexpNull, h.dht.KVSet(z.zome, key, value)
+		})
+
+	z.addHostFn(h, "kvGet",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var key string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				key = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of kvGet should be string")
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrKVInValidation
+			}
+			value, err := h.dht.KVGet(z.zome, key)
+			if err == ErrKVNotFound {
+				return zygo.SexpNull, nil
+			}
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: value}, nil
+		})
+
+	z.addHostFn(h, "kvDelete",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var key string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				key = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of kvDelete should be string")
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrKVInValidation
+			}
+			if err := h.dht.KVDelete(z.zome, key); err != nil && err != ErrKVNotFound {
+				return zygo.SexpNull, err
+			}
+			return zygo.SexpNull, nil
+		})
+
+	z.addHostFn(h, "kvList",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) > 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var prefix string
+			if len(args) == 1 {
+				switch t := args[0].(type) {
+				case *zygo.SexpStr:
+					prefix = t.S
+				default:
+					return zygo.SexpNull,
+						errors.New("argument of kvList should be string")
+				}
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrKVInValidation
+			}
+			keys, err := h.dht.KVList(z.zome, prefix)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			vals := make([]zygo.Sexp, len(keys))
+			for i, k := range keys {
+				vals[i] = &zygo.SexpStr{S: k}
+			}
+			return &zygo.SexpArray{Val: vals}, nil
+		})
+
+	z.addHostFn(h, "getPref",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var key string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				key = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of getPref should be string")
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrPrefInValidation
+			}
+			value, err := h.GetPref(key)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			if value == "" {
+				return zygo.SexpNull, nil
+			}
+			return &zygo.SexpStr{S: value}, nil
 		})
 
-	z.env.AddFunction("put",
+	z.addHostFn(h, "setPref",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var key, value string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				key = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of setPref should be string")
+			}
+			switch t := args[1].(type) {
+			case *zygo.SexpStr:
+				b, err := json.Marshal(t.S)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
+				value = string(b)
+			case *zygo.SexpInt:
+				value = fmt.Sprintf("%d", t.Val)
+			case *zygo.SexpBool:
+				value = fmt.Sprintf("%v", t.Val)
+			case *zygo.SexpHash:
+				value = zygo.SexpToJson(t)
+			case *zygo.SexpSentinel:
+				// nil unsets the preference
+			default:
+				return zygo.SexpNull,
+					errors.New("2nd argument of setPref should be string, int, bool, hash or nil")
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrPrefInValidation
+			}
+			hash, err := h.SetPref(key, value)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: hash.String()}, nil
+		})
+
+	z.addHostFn(h, "findAgent",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var query string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				query = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of findAgent should be string")
+			}
+			entries, err := h.findAgent(query, z.validating)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			j, err := json.Marshal(entries)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
+
+	z.addHostFn(h, "caller",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 0 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrCallerInValidation
+			}
+			j, err := json.Marshal(h.Caller())
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
+
+	z.addHostFn(h, "countersign",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrCountersignInValidation
+			}
+			var entryType, entry string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				entryType = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of countersign should be string")
+			}
+			switch t := args[1].(type) {
+			case *zygo.SexpStr:
+				entry = t.S
+			case *zygo.SexpHash:
+				entry = zygo.SexpToJson(t)
+			default:
+				return zygo.SexpNull,
+					errors.New("2nd argument of countersign should be string or hash")
+			}
+			var agents []peer.ID
+			switch t := args[2].(type) {
+			case *zygo.SexpArray:
+				for _, x := range t.Val {
+					s, ok := x.(*zygo.SexpStr)
+					if !ok {
+						return zygo.SexpNull,
+							errors.New("3rd argument of countersign should be array of strings")
+					}
+					id, err := peer.IDB58Decode(s.S)
+					if err != nil {
+						return zygo.SexpNull, err
+					}
+					agents = append(agents, id)
+				}
+			default:
+				return zygo.SexpNull,
+					errors.New("3rd argument of countersign should be array of strings")
+			}
+			hash, _, err := h.Countersign(entryType, entry, agents)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: hash.String()}, nil
+		})
+
+	z.addHostFn(h, "sign",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrSignInValidation
+			}
+			var data string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				data = t.S
+			case *zygo.SexpHash:
+				data = zygo.SexpToJson(t)
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of sign should be string or hash")
+			}
+			s, err := h.Sign([]byte(data))
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			j, err := json.Marshal(s)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
+
+	z.addHostFn(h, "verify",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var data, sig string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				data = t.S
+			case *zygo.SexpHash:
+				data = zygo.SexpToJson(t)
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of verify should be string or hash")
+			}
+			switch t := args[1].(type) {
+			case *zygo.SexpStr:
+				sig = t.S
+			case *zygo.SexpHash:
+				sig = zygo.SexpToJson(t)
+			default:
+				return zygo.SexpNull,
+					errors.New("2nd argument of verify should be string or hash")
+			}
+			s, err := ParseMessageSignature(sig)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpBool{Val: s.Verify([]byte(data)) == nil}, nil
+		})
+
+	z.addHostFn(h, "hostCapabilities",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 0 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			c := h.hostCapabilities(z.zome, ZygoNucleusType, z.hostFns)
+			result, err := zygo.MakeHash(nil, "hash", env)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			fns := make([]zygo.Sexp, len(c.Functions))
+			for i, f := range c.Functions {
+				fns[i] = &zygo.SexpStr{S: f}
+			}
+			for _, kv := range []struct {
+				key string
+				val zygo.Sexp
+			}{
+				{"Version", &zygo.SexpInt{Val: int64(c.Version)}},
+				{"API", &zygo.SexpInt{Val: int64(c.API)}},
+				{"MinAPI", &zygo.SexpInt{Val: int64(c.MinAPI)}},
+				{"MaxAPI", &zygo.SexpInt{Val: int64(c.MaxAPI)}},
+				{"Nucleus", &zygo.SexpStr{S: c.Nucleus}},
+				{"Functions", &zygo.SexpArray{Val: fns}},
+			} {
+				if err = result.HashSet(env.MakeSymbol(kv.key), kv.val); err != nil {
+					return zygo.SexpNull, err
+				}
+			}
+			return result, nil
+		})
+
+	z.addHostFn(h, "put",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			if len(args) != 1 {
 				return zygo.SexpNull, zygo.WrongNargs
@@ -505,9 +1222,9 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return result, err
 		})
 
-	z.env.AddFunction("get",
+	z.addHostFn(h, "get",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
-			if len(args) != 1 {
+			if len(args) != 1 && len(args) != 2 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
 
@@ -519,11 +1236,25 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 				return zygo.SexpNull,
 					errors.New("argument of put should be string")
 			}
-			result, err := z.get(env, h, hashstr)
+			var opts GetOptions
+			if len(args) == 2 {
+				switch t := args[1].(type) {
+				case *zygo.SexpHash:
+					var err error
+					opts, err = ParseGetOptions(zygo.SexpToJson(t))
+					if err != nil {
+						return zygo.SexpNull, err
+					}
+				default:
+					return zygo.SexpNull,
+						errors.New("2nd argument of get should be hash")
+				}
+			}
+			result, err := z.get(env, h, hashstr, opts)
 			return result, err
 		})
 
-	z.env.AddFunction("putmeta",
+	z.addHostFn(h, "putmeta",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			if len(args) != 3 {
 				return zygo.SexpNull, zygo.WrongNargs
@@ -557,7 +1288,7 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return result, err
 		})
 
-	z.env.AddFunction("getmeta",
+	z.addHostFn(h, "getmeta",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			if len(args) != 2 {
 				return zygo.SexpNull, zygo.WrongNargs
@@ -584,6 +1315,121 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return result, err
 		})
 
+	z.addHostFn(h, "countLinks",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			strs, err := zygoStrings(name, args)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			if z.validating {
+				return zygo.SexpNull, ErrAggregateInValidation
+			}
+			key, err := NewHash(strs[0])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			n, err := h.CountLinks(key, strs[1])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpInt{Val: int64(n)}, nil
+		})
+
+	z.addHostFn(h, "queryCount",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			q, err := z.query(h, name, args[0])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			n, err := h.QueryCount(q)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpInt{Val: int64(n)}, nil
+		})
+
+	z.addHostFn(h, "querySum",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			q, err := z.query(h, name, args[0])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			field, ok := args[1].(*zygo.SexpStr)
+			if !ok {
+				return zygo.SexpNull, errors.New("2nd argument of querySum should be string")
+			}
+			a, err := h.QuerySum(q, field.S)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpFloat{Val: a.Sum}, nil
+		})
+
+	z.addHostFn(h, "bridgeGet",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			strs, err := zygoStrings(name, args)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return z.bridgeGet(env, h, strs[0], strs[1])
+		})
+
+	z.addHostFn(h, "bridgeQuery",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			strs, err := zygoStrings(name, args)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return z.bridgeQuery(env, h, strs[0], strs[1], strs[2])
+		})
+
+	z.addHostFn(h, "deterministicRandom",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			var hashstr string
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				hashstr = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of deterministicRandom should be string")
+			}
+			var n int64
+			switch t := args[1].(type) {
+			case *zygo.SexpInt:
+				n = t.Val
+			default:
+				return zygo.SexpNull,
+					errors.New("2nd argument of deterministicRandom should be integer")
+			}
+			seed, err := NewHash(hashstr)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			r, err := DeterministicRandom(seed, n)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpInt{Val: r}, nil
+		})
+
 	l := ZygoLibrary
 	if h != nil {
 		l += fmt.Sprintf(`(def App_DNAHash "%s")(def App_AgentHash "%s")(def App_AgentStr "%s")(def App_KeyHash "%s")`, h.dnaHash, h.agentHash, h.Agent().Name(), peer.IDB58Encode(h.id))
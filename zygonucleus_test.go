@@ -1,3 +1,5 @@
+// +build !nozygo
+
 package holochain
 
 import (
@@ -5,10 +7,53 @@ import (
 	zygo "github.com/glycerine/zygomys/repl"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
+func init() {
+	builtNucleusTypes = append(builtNucleusTypes, ZygoNucleusType)
+}
+
+func TestZygoNucleusType(t *testing.T) {
+	Convey("it should return the file extension for zygo", t, func() {
+		ext, err := NucleusFileExtension(ZygoNucleusType)
+		So(err, ShouldBeNil)
+		So(ext, ShouldEqual, "zy")
+	})
+	Convey("should create a nucleus based from a good schema type", t, func() {
+		v, err := CreateNucleus(nil, ZygoNucleusType, `(+ 1 1)`)
+		z := v.(*ZygoNucleus)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", z.lastResult), ShouldEqual, "&{2 <nil>}")
+	})
+	Convey("the chain should make a nucleus for a zygo zome", t, func() {
+		d, _, h := setupTestChain("test")
+		defer cleanupTestDir(d)
+		v, err := h.MakeNucleus("myZome")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.env.Run()
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestParseZygoExposed(t *testing.T) {
+	Convey("it should read the functions code exposes without running it", t, func() {
+		exposed, err := parseZygoExposed("(expose \"getDNA\" STRING) ; (expose \"no\" STRING)\n(defn f [] (expose \"nested\" STRING))\n(expose `raw` JSON)\n(ran)")
+		So(err, ShouldBeNil)
+		So(exposed, ShouldResemble, []string{"getDNA", "raw"})
+	})
+	Convey("it should report unbalanced code with its line", t, func() {
+		_, err := parseZygoExposed("(defn f [x]\n  (+ x 1)))")
+		So(err.Error(), ShouldEqual, "unbalanced ) at line 2")
+		_, err = parseZygoExposed("(expose \"f STRING)")
+		So(err.Error(), ShouldEqual, "unterminated string at line 1")
+	})
+}
+
 func TestNewZygoNucleus(t *testing.T) {
 	Convey("new should create a nucleus", t, func() {
 		v, err := NewZygoNucleus(nil, `(+ 1 1)`)
@@ -278,3 +323,306 @@ func TestZygoDHT(t *testing.T) {
 		So(r.(*zygo.SexpStr).S, ShouldEqual, `[{"E":{"C":"{\"firstName\":\"Zippy\",\"lastName\":\"Pinhead\"}"},"H":"QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt"}]`)
 	})
 }
+
+func TestZygoFetch(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "42")
+	}))
+	defer ts.Close()
+	h.HTTPFetch = &HTTPFetchPolicy{Hosts: []string{"127.0.0.1"}}
+
+	Convey("it should fetch from zome functions but not during validation", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.Run(fmt.Sprintf(`(fetch "%s")`, ts.URL))
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, "42")
+		z.validating = true
+		_, err = z.Run(fmt.Sprintf(`(fetch "%s")`, ts.URL))
+		So(err.Error(), ShouldEndWith, ErrFetchInValidation.Error())
+	})
+}
+
+func TestZygoPin(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should pin and unpin entries", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.Run(`(pin "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")`)
+		So(err, ShouldBeNil)
+		pins, _ := h.dht.Pins()
+		So(len(pins), ShouldEqual, 1)
+		_, err = z.Run(`(unpin "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")`)
+		So(err, ShouldBeNil)
+		_, err = z.Run(`(unpin "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")`)
+		So(err.Error(), ShouldEndWith, ErrNotPinned.Error())
+	})
+}
+
+func TestZygoKV(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should keep node-local values for the zome", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		z.setZome("myZome")
+		_, err = z.Run(`(kvGet "cursor")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult, ShouldEqual, zygo.SexpNull)
+		_, err = z.Run(`(kvSet "cursor" "42")`)
+		So(err, ShouldBeNil)
+		_, err = z.Run(`(kvGet "cursor")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, "42")
+		_, err = z.Run(`(kvList "cur")`)
+		So(err, ShouldBeNil)
+		keys := z.lastResult.(*zygo.SexpArray).Val
+		So(len(keys), ShouldEqual, 1)
+		So(keys[0].(*zygo.SexpStr).S, ShouldEqual, "cursor")
+		_, err = z.Run(`(kvDelete "cursor")`)
+		So(err, ShouldBeNil)
+		_, err = h.dht.KVGet("myZome", "cursor")
+		So(err, ShouldEqual, ErrKVNotFound)
+
+		z.validating = true
+		_, err = z.Run(`(kvSet "cursor" "43")`)
+		So(err.Error(), ShouldEndWith, ErrKVInValidation.Error())
+	})
+}
+
+func TestZygoPrefs(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should get and set the agent's preferences", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.Run(`(getPref "lang")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult, ShouldEqual, zygo.SexpNull)
+		_, err = z.Run(`(setPref "lang" "fr")`)
+		So(err, ShouldBeNil)
+		_, err = z.Run(`(getPref "lang")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `"fr"`)
+		_, err = z.Run(`(setPref "lang" nil)`)
+		So(err, ShouldBeNil)
+		value, _ := h.GetPref("lang")
+		So(value, ShouldEqual, "")
+
+		z.validating = true
+		_, err = z.Run(`(setPref "lang" "de")`)
+		So(err.Error(), ShouldEndWith, ErrPrefInValidation.Error())
+	})
+}
+
+func TestZygoFindAgent(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should search the agent directory", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.Run(`(findAgent "nomatch")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `[]`)
+		_, err = z.Run(`(findAgent "")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldContainSubstring, `"Source":"agent"`)
+
+		z.validating = true
+		_, err = z.Run(`(findAgent "")`)
+		So(err.Error(), ShouldEndWith, ErrDirectoryInValidation.Error())
+	})
+}
+
+func TestZygoCaller(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should tell zome code who is calling", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.Run(`(caller)`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `{"Kind":"self"}`)
+
+		other, _ := makePeer("other")
+		v, err = NewZygoNucleus(h.WithCaller(other), "")
+		So(err, ShouldBeNil)
+		z = v.(*ZygoNucleus)
+		_, err = z.Run(`(caller)`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `{"Kind":"agent","ID":"`+peer.IDB58Encode(other)+`"}`)
+
+		z.validating = true
+		_, err = z.Run(`(caller)`)
+		So(err.Error(), ShouldEndWith, ErrCallerInValidation.Error())
+	})
+}
+
+func TestZygoDeterministicRandom(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	v, err := NewZygoNucleus(h, "")
+	if err != nil {
+		panic(err)
+	}
+	z := v.(*ZygoNucleus)
+
+	Convey("deterministicRandom should derive a number from a hash", t, func() {
+		_, err := z.Run(`(deterministicRandom "` + h.dnaHash.String() + `" 6)`)
+		So(err, ShouldBeNil)
+		r, _ := DeterministicRandom(h.dnaHash, 6)
+		So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, r)
+
+		_, err = z.Run(`(deterministicRandom "` + h.dnaHash.String() + `" 0)`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestZygoAggregates(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	e := GobEntry{C: `{"firstName":"Zippy","age":30}`}
+	b, _ := e.Marshal()
+	var base, meta Hash
+	base.Sum(h.hashSpec, b)
+	meta.Sum(h.hashSpec, []byte("meta"))
+	if err := h.dht.put(nil, "profile", base, h.id, b, LIVE); err != nil {
+		panic(err)
+	}
+	if err := h.dht.putMeta(nil, base, meta, "follows", &GobEntry{C: "x"}); err != nil {
+		panic(err)
+	}
+
+	Convey("it should have aggregate functions", t, func() {
+		v, err := NewZygoNucleus(h, fmt.Sprintf(`(countLinks "%s" "follows")`, base.String()))
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 1)
+		_, err = z.Run(`(queryCount (hash Entry:"profile" Where:(hash firstName:"Zippy")))`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 1)
+		_, err = z.Run(`(querySum "{\"Entry\":\"profile\"}" "age")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpFloat).Val, ShouldEqual, 30)
+
+		z.validating = true
+		_, err = z.Run(`(queryCount "{\"Entry\":\"profile\"}")`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestZygoSign(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("zome code should be able to sign and verify payloads", t, func() {
+		v, err := NewZygoNucleus(h, `(verify "challenge" (sign "challenge"))`)
+		So(err, ShouldBeNil)
+		So(v.(*ZygoNucleus).lastResult.(*zygo.SexpBool).Val, ShouldBeTrue)
+		v, err = NewZygoNucleus(h, `(verify "other" (sign "challenge"))`)
+		So(err, ShouldBeNil)
+		So(v.(*ZygoNucleus).lastResult.(*zygo.SexpBool).Val, ShouldBeFalse)
+
+		v, err = NewZygoNucleus(h, `(sign "challenge")`)
+		So(err, ShouldBeNil)
+		s, err := ParseMessageSignature(v.(*ZygoNucleus).lastResult.(*zygo.SexpStr).S)
+		So(err, ShouldBeNil)
+		So(s.Verify([]byte("challenge")), ShouldBeNil)
+	})
+}
+
+func TestZygoHostCapabilities(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("zome code should be able to feature-detect host functions", t, func() {
+		v, err := NewZygoNucleus(h, `(hostCapabilities)`)
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		c := z.lastResult.(*zygo.SexpHash)
+		api, err := c.HashGet(z.env, z.env.MakeSymbol("API"))
+		So(err, ShouldBeNil)
+		So(api.(*zygo.SexpInt).Val, ShouldEqual, NucleusAPIVersion)
+		nucleus, err := c.HashGet(z.env, z.env.MakeSymbol("Nucleus"))
+		So(err, ShouldBeNil)
+		So(nucleus.(*zygo.SexpStr).S, ShouldEqual, ZygoNucleusType)
+		fns, err := c.HashGet(z.env, z.env.MakeSymbol("Functions"))
+		So(err, ShouldBeNil)
+		var functions []string
+		for _, f := range fns.(*zygo.SexpArray).Val {
+			functions = append(functions, f.(*zygo.SexpStr).S)
+		}
+		So(functions, ShouldContain, "countLinks")
+		So(functions, ShouldContain, "hostCapabilities")
+
+		_, err = z.Run(`(hget (hostCapabilities) Nucleus:)`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, ZygoNucleusType)
+	})
+
+	Convey("zomes written for API version 1 should get the special properties", t, func() {
+		h.Zomes["myZome"].API = 1
+		defer func() { h.Zomes["myZome"].API = 0 }()
+		v, err := h.MakeNucleus("myZome")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.Run(`(property "_id")`)
+		So(err, ShouldBeNil)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, h.dnaHash.String())
+	})
+}
+
+func TestZygoSystemZome(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("the system zome should be callable from zygo", t, func() {
+		v, err := NewZygoNucleus(h, `(hc "base58Encode" "hello")`)
+		So(err, ShouldBeNil)
+		zy := v.(*ZygoNucleus)
+		So(zy.lastResult.(*zygo.SexpStr).S, ShouldEqual, "Cn8eVZg")
+	})
+
+	Convey("calls from zygo shouldn't take another call slot", t, func() {
+		sem := make(chan bool, 1)
+		h.quota.calls = sem
+		sem <- true
+		v, err := NewZygoNucleus(h, `(hc "base58Encode" "hello")`)
+		<-sem
+		h.quota = newQuotaState(&h.config.Quotas)
+		So(err, ShouldBeNil)
+		So(v.(*ZygoNucleus).lastResult.(*zygo.SexpStr).S, ShouldEqual, "Cn8eVZg")
+	})
+}
+
+func TestZygoCoverage(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("zygo should count function bodies, including calls from other functions", t, func() {
+		hits := make(map[string]int)
+		hit := func(fn string) { hits[fn]++ }
+		v, err := NewZygoNucleus(h, `(defn c [x] (d x)) (defn d [x] x)`)
+		So(err, ShouldBeNil)
+		zy := v.(*ZygoNucleus)
+		So(zy.cover([]string{"c", "d"}, hit), ShouldBeNil)
+		_, err = zy.Run(`(c 1)`)
+		So(err, ShouldBeNil)
+		So(hits["c"], ShouldEqual, 1)
+		So(hits["d"], ShouldEqual, 1)
+	})
+}